@@ -0,0 +1,248 @@
+// Package alert posts critical events, such as a corrupt record or a full disk, to operator webhooks
+//
+// Every alert is sent as one JSON object to each configured URL:
+//
+//	{"event":"disk_full","severity":"critical","message":"52428800 bytes free on db, ...","time":"2024-01-01T10:00:00Z","host":"kv-1"}
+//
+// Delivery is asynchronous and best effort: alerts wait in a bounded queue, a failed call is retried a few times,
+// and alerts arriving while the queue is full are dropped. Every alert is also logged by the store
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBadURL is returned for a webhook URL that is not an absolute http or https URL
+var ErrBadURL = errors.New("webhook URL must be an absolute http or https URL")
+
+// Stats counts the alerts handled by a Notifier
+type Stats struct {
+	// Webhooks is the number of configured webhook URLs
+	Webhooks int
+
+	// Sent is the number of webhook calls that succeeded
+	Sent uint64
+
+	// Failed is the number of webhook calls given up on after every attempt failed
+	Failed uint64
+
+	// Dropped is the number of alerts discarded because the queue was full
+	Dropped uint64
+}
+
+// Notifier delivers alerts to a set of webhook URLs
+// It is safe for concurrent use
+type Notifier struct {
+	// mu protects urls
+	mu sync.Mutex
+
+	// urls are the webhooks every alert is posted to
+	urls []string
+
+	// queue holds the alerts waiting for delivery
+	queue chan models.KVStashAlert
+
+	// done is closed when the delivery goroutine exits
+	done chan struct{}
+
+	// closeOnce guards closing queue
+	closeOnce sync.Once
+
+	// client makes the webhook calls
+	client *http.Client
+
+	// host is reported in every alert
+	host string
+
+	// sent, failed, and dropped are the counters reported by Stats
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// ValidateURL checks that u can be used as a webhook URL
+func ValidateURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%w: %q", ErrBadURL, u)
+	}
+	return nil
+}
+
+// New creates a notifier posting to urls and starts its delivery goroutine, which Close stops
+// Returns ErrBadURL if a URL is invalid
+func New(urls []string) (*Notifier, error) {
+	n := &Notifier{
+		queue:  make(chan models.KVStashAlert, constants.AlertQueueSize),
+		done:   make(chan struct{}),
+		client: &http.Client{Timeout: constants.AlertTimeout * time.Second},
+	}
+	n.host, _ = os.Hostname()
+
+	if err := n.SetURLs(urls); err != nil {
+		return nil, fmt.Errorf("New: %w", err)
+	}
+
+	go n.run()
+	return n, nil
+}
+
+// SetURLs replaces the webhook URLs; an empty list disables delivery
+// Returns ErrBadURL if a URL is invalid, in which case the previous URLs stay in effect
+func (n *Notifier) SetURLs(urls []string) error {
+	for _, u := range urls {
+		if err := ValidateURL(u); err != nil {
+			return fmt.Errorf("SetURLs: %w", err)
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.urls = slices.Clone(urls)
+	return nil
+}
+
+// URLs returns the webhook URLs
+func (n *Notifier) URLs() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return slices.Clone(n.urls)
+}
+
+// Send queues a for delivery without blocking; it is dropped if no webhook is configured or the queue is full
+func (n *Notifier) Send(a models.KVStashAlert) {
+	if len(n.URLs()) == 0 {
+		return
+	}
+
+	if a.Host == "" {
+		a.Host = n.host
+	}
+
+	select {
+	case n.queue <- a:
+	default:
+		n.dropped.Add(1)
+		logging.Warnf("Send: alert queue is full, dropped %v alert", a.Event)
+	}
+}
+
+// Stats returns the notifier's counters
+func (n *Notifier) Stats() Stats {
+	return Stats{
+		Webhooks: len(n.URLs()),
+		Sent:     n.sent.Load(),
+		Failed:   n.failed.Load(),
+		Dropped:  n.dropped.Load(),
+	}
+}
+
+// Close delivers the queued alerts and stops the notifier, waiting at most timeout
+// Send must not be called after Close
+func (n *Notifier) Close(timeout time.Duration) {
+	n.closeOnce.Do(func() {
+		close(n.queue)
+	})
+
+	select {
+	case <-n.done:
+	case <-time.After(timeout):
+		logging.Warnf("Close: gave up delivering %d queued alerts", len(n.queue))
+	}
+}
+
+// run delivers queued alerts until the queue is closed
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	for a := range n.queue {
+		body, err := json.Marshal(a)
+		if err != nil {
+			logging.Errorf("run: failed to encode %v alert: %v", a.Event, err)
+			continue
+		}
+
+		for _, u := range n.URLs() {
+			if err := n.post(u, body); err != nil {
+				n.failed.Add(1)
+				logging.Errorf("run: failed to deliver %v alert: %v", a.Event, err)
+				continue
+			}
+			n.sent.Add(1)
+		}
+	}
+}
+
+// post calls a webhook with body, trying up to constants.AlertAttempts times with a growing delay
+// Responses other than 2xx count as failures; only network errors, 429, and 5xx are retried
+func (n *Notifier) post(u string, body []byte) error {
+	var err error
+	for attempt := range constants.AlertAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		var retry bool
+		retry, err = n.call(u, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("post: %w (after %d attempts)", err, constants.AlertAttempts)
+}
+
+// call makes a single webhook call, reporting whether a failure is worth retrying
+func (n *Notifier) call(u string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AlertTimeout*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("call: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error quotes the URL, which may carry a token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, fmt.Errorf("call: %v: %w", redactURL(u), err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("call: %v answered %v", redactURL(u), resp.Status)
+	}
+	return false, nil
+}
+
+// redactURL strips the credentials and query of a webhook URL, which often carry a token, for logging
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "webhook"
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+	return parsed.String()
+}
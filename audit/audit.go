@@ -0,0 +1,136 @@
+// Package audit writes an append-only trail of mutations for environments with audit requirements
+//
+// Every entry is one JSON line recording who made a change (client address, a fingerprint of the
+// credentials presented, and the authenticated subject), when, and what it was:
+//
+//	{"time":"2024-01-01T10:00:00.123Z","request_id":"9f86d081884c7d65","client":"10.0.0.7","op":"set","key":"user:1","size":42,"status":201}
+//
+// Values are never recorded, only their size. In privacy mode keys are recorded as hashes, see the redact package
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/vi88i/kvstash/redact"
+	"io"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the audit trail besides the key-value operations ("set", "delete")
+const (
+	// OpConfigReload is a reload of the configuration file through the admin endpoint
+	OpConfigReload = "admin.config_reload"
+
+	// OpResumeWrites re-enables writes after the store became read-only
+	OpResumeWrites = "admin.resume_writes"
+
+	// OpCompactionPause pauses automatic compaction
+	OpCompactionPause = "admin.compaction_pause"
+
+	// OpCompactionResume resumes automatic compaction
+	OpCompactionResume = "admin.compaction_resume"
+
+	// OpMaintenanceOn puts the server into maintenance mode
+	OpMaintenanceOn = "admin.maintenance_on"
+
+	// OpMaintenanceOff ends maintenance mode
+	OpMaintenanceOff = "admin.maintenance_off"
+
+	// OpRelocate moves the database to another directory
+	OpRelocate = "admin.relocate"
+
+	// OpSchedulerPause holds the store's background jobs
+	OpSchedulerPause = "admin.scheduler_pause"
+
+	// OpSchedulerResume lets the store's background jobs run again
+	OpSchedulerResume = "admin.scheduler_resume"
+
+	// OpIndexCheck checks the index against the segments on demand, which may rebuild it
+	OpIndexCheck = "admin.index_check"
+
+	// OpReindex rebuilds the index from the segments
+	OpReindex = "admin.reindex"
+
+	// OpAuthReject is a request rejected because its credentials were missing or not accepted
+	OpAuthReject = "auth.reject"
+)
+
+// Entry is one record of the audit trail
+type Entry struct {
+	// Time is when the operation completed
+	Time time.Time `json:"time"`
+
+	// RequestID matches the X-Request-ID header returned to the client
+	RequestID string `json:"request_id,omitempty"`
+
+	// Client is the address the request came from
+	Client string `json:"client"`
+
+	// Credential is a fingerprint of the Authorization header, empty if none was sent
+	Credential string `json:"credential,omitempty"`
+
+	// Subject is the authenticated client as provider:subject, empty if authentication is disabled or failed
+	Subject string `json:"subject,omitempty"`
+
+	// Op is the operation, e.g. "set", "delete", or one of the admin operations
+	Op string `json:"op"`
+
+	// Key is the affected key (hashed in privacy mode), empty for admin operations
+	Key string `json:"key,omitempty"`
+
+	// Size is the size of the written value in bytes
+	Size int `json:"size,omitempty"`
+
+	// Status is the HTTP status code of the response
+	Status int `json:"status"`
+}
+
+// Logger appends entries to an audit trail
+// It is safe for concurrent use
+type Logger struct {
+	// mu serializes writes so entries are never interleaved
+	mu sync.Mutex
+
+	// w receives one JSON line per entry
+	w io.Writer
+}
+
+// New creates a logger writing to w, typically a logrotate.Writer
+func New(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Log appends e to the audit trail, redacting its key in privacy mode
+func (l *Logger) Log(e Entry) error {
+	if e.Key != "" {
+		e.Key = redact.Key(e.Key)
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("Log: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.w.Write(line); err != nil {
+		return fmt.Errorf("Log: %w", err)
+	}
+
+	return nil
+}
+
+// Fingerprint returns a short hash identifying a credential without recording it
+func Fingerprint(credential string) string {
+	if credential == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(credential))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,113 @@
+// Package auth authenticates the bearer tokens clients present to the server
+//
+// Authentication is done by providers, each verifying one kind of token:
+//
+//   - StaticKeys accepts a fixed set of API keys
+//   - HMAC accepts tokens signed with a shared secret, see SignHMAC
+//   - JWT accepts JSON Web Tokens of an identity provider, checking their issuer and audience and verifying their
+//     signature with the provider's published keys (JWKS)
+//
+// An Authenticator tries its providers in order. A provider that does not recognize a token, e.g. a JWT provider
+// given an API key, passes it on to the next one; a provider that recognizes a token but rejects it, e.g. because
+// it expired, fails the authentication. Any type implementing Provider can be plugged in the same way:
+//
+//	authenticator := auth.New(auth.NewStaticKeys(keys), jwt)
+//	identity, err := authenticator.Authenticate(ctx, auth.BearerToken(r))
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors returned by Authenticate
+var (
+	// ErrNoCredentials is returned when no token was presented
+	ErrNoCredentials = errors.New("no credentials")
+
+	// ErrInvalidCredentials is returned for a token that no provider accepts
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrUnrecognized is returned by a provider for a token it does not handle, so the next provider is tried
+	ErrUnrecognized = errors.New("token not recognized")
+)
+
+// Identity is the authenticated client of a request
+type Identity struct {
+	// Provider is the name of the provider that accepted the token
+	Provider string
+
+	// Subject identifies the client within the provider, e.g. the sub claim of a JWT
+	Subject string
+}
+
+// String returns the identity as provider:subject
+func (id *Identity) String() string {
+	return id.Provider + ":" + id.Subject
+}
+
+// Provider verifies one kind of token
+type Provider interface {
+	// Name identifies the provider in identities and logs
+	Name() string
+
+	// Authenticate returns the identity token proves
+	// Returns an error wrapping ErrUnrecognized if the token is not meant for this provider,
+	// and one wrapping ErrInvalidCredentials if it is but cannot be accepted
+	Authenticate(ctx context.Context, token string) (*Identity, error)
+}
+
+// Authenticator authenticates tokens with a chain of providers
+// It is safe for concurrent use
+type Authenticator struct {
+	// providers are tried in order until one recognizes the token
+	providers []Provider
+}
+
+// New creates an authenticator trying providers in order
+func New(providers ...Provider) *Authenticator {
+	return &Authenticator{providers: providers}
+}
+
+// Providers returns the names of the providers of a, in the order they are tried
+func (a *Authenticator) Providers() []string {
+	names := make([]string, 0, len(a.providers))
+	for _, p := range a.providers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// Authenticate returns the identity token proves with the first provider recognizing it
+// Returns ErrNoCredentials for an empty token and an error wrapping ErrInvalidCredentials if it is not accepted
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	for _, p := range a.providers {
+		identity, err := p.Authenticate(ctx, token)
+		if errors.Is(err, ErrUnrecognized) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Authenticate: %v: %w", p.Name(), err)
+		}
+		return identity, nil
+	}
+
+	return nil, fmt.Errorf("Authenticate: %w", ErrInvalidCredentials)
+}
+
+// BearerToken returns the token of the Authorization header of r, empty if it has none
+// Both "Authorization: Bearer <token>" and the bare "Authorization: <token>" of API keys are accepted
+func BearerToken(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return header
+}
@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+JWT validation:
+
+A JWT is accepted if it is signed by one of the keys the identity provider publishes at its JWKS URL, its iss claim
+is the configured issuer, its aud claim contains the configured audience, it has a sub claim, and it is within its
+exp and nbf times (with constants.AuthClockSkew of leeway). Tokens of other issuers are not recognized, so one JWT
+provider per trusted issuer can be chained.
+
+Only asymmetric algorithms are supported (RS*, PS*, ES*, EdDSA): "none" and the HMAC algorithms are rejected, so a
+token cannot be signed with the public key as a shared secret. The key of a token is looked up by its kid header;
+a token without kid is accepted only if the JWKS has a single key.
+
+The JWKS is fetched on first use and again after constants.JWKSRefreshInterval, or as soon as a token names a key
+that is not known, so keys rotated by the identity provider are picked up. Fetches are at least
+constants.JWKSMinRefreshInterval apart. If a fetch fails, the keys fetched before keep being used.
+*/
+
+// JWTOptions configures a JWT provider
+type JWTOptions struct {
+	// Issuer is the required iss claim, e.g. "https://login.example.com/"
+	Issuer string
+
+	// Audience is the value the aud claim must contain, e.g. "kvstash"
+	Audience string
+
+	// JWKSURL is where the identity provider publishes its signing keys, e.g. "https://login.example.com/.well-known/jwks.json"
+	JWKSURL string
+
+	// HTTPClient fetches the JWKS (default: a client with a constants.JWKSFetchTimeout timeout)
+	HTTPClient *http.Client
+}
+
+// JWT accepts JSON Web Tokens of an identity provider
+type JWT struct {
+	// opts are the validated options
+	opts JWTOptions
+
+	// mu protects keys, fetched, and attempted, and serializes JWKS fetches
+	mu sync.Mutex
+
+	// keys maps key IDs to the public keys of the last successful JWKS fetch
+	keys map[string]crypto.PublicKey
+
+	// fetched is when keys were fetched
+	fetched time.Time
+
+	// attempted is when the JWKS was last fetched, successfully or not
+	attempted time.Time
+}
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	// Alg is the signature algorithm
+	Alg string `json:"alg"`
+
+	// Kid identifies the signing key in the JWKS
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the registered claims of a JWT checked by the provider
+type jwtClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expiry    *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings
+type audience []string
+
+// UnmarshalJSON accepts a single audience or an array of them
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("UnmarshalJSON: aud must be a string or an array of strings")
+	}
+	*a = list
+	return nil
+}
+
+// jwk is a key of a JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWT creates a provider accepting the JWTs of the identity provider described by opts
+// Returns an error if the issuer, audience, or JWKS URL is missing or the URL is not an http or https URL
+func NewJWT(opts JWTOptions) (*JWT, error) {
+	if opts.Issuer == "" || opts.Audience == "" {
+		return nil, errors.New("NewJWT: issuer and audience are required")
+	}
+	u, err := url.Parse(opts.JWKSURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("NewJWT: JWKS URL must be an absolute http or https URL, got %q", opts.JWKSURL)
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: constants.JWKSFetchTimeout * time.Second}
+	}
+	return &JWT{opts: opts}, nil
+}
+
+// Name returns "jwt"
+func (p *JWT) Name() string {
+	return "jwt"
+}
+
+// Authenticate returns the subject of token if it is a valid JWT of the issuer for the audience
+// Tokens that are not JWTs or are issued by another issuer are not recognized
+// Returns an error wrapping neither ErrInvalidCredentials nor ErrUnrecognized if the JWKS cannot be fetched
+func (p *JWT) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnrecognized
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrUnrecognized
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrUnrecognized
+	}
+	if claims.Issuer != p.opts.Issuer {
+		return nil, ErrUnrecognized
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidCredentials)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	now := float64(time.Now().Unix())
+	switch {
+	case !slices.Contains(claims.Audience, p.opts.Audience):
+		return nil, fmt.Errorf("%w: token is not for audience %q", ErrInvalidCredentials, p.opts.Audience)
+	case claims.Expiry == nil:
+		return nil, fmt.Errorf("%w: token has no expiry", ErrInvalidCredentials)
+	case now > *claims.Expiry+constants.AuthClockSkew:
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	case claims.NotBefore != nil && now < *claims.NotBefore-constants.AuthClockSkew:
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidCredentials)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidCredentials)
+	}
+
+	return &Identity{Provider: p.Name(), Subject: claims.Subject}, nil
+}
+
+// key returns the public key kid, fetching the JWKS if it is stale or does not have the key
+func (p *JWT) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	key, found := p.lookup(kid)
+	stale := now.Sub(p.fetched) > constants.JWKSRefreshInterval*time.Second
+	if (!found || stale) && now.Sub(p.attempted) >= constants.JWKSMinRefreshInterval*time.Second {
+		p.attempted = now
+		keys, err := p.fetch(ctx)
+		if err != nil {
+			if p.keys == nil {
+				return nil, fmt.Errorf("key: %w", err)
+			}
+			logging.Warnf("key: keeping the keys fetched at %v: %v", p.fetched.Format(time.RFC3339), err)
+		} else {
+			p.keys = keys
+			p.fetched = now
+			key, found = p.lookup(kid)
+		}
+	}
+
+	if p.keys == nil {
+		return nil, fmt.Errorf("key: the JWKS of %v was not fetched yet", p.opts.JWKSURL)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidCredentials, kid)
+	}
+	return key, nil
+}
+
+// lookup returns the key kid of the last fetched JWKS, or its only key if kid is empty
+// Must be called with mu held
+func (p *JWT) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" {
+		if len(p.keys) != 1 {
+			return nil, false
+		}
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetch downloads and parses the JWKS
+// Keys that are not signing keys or of an unsupported type are skipped
+func (p *JWT) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.opts.JWKSURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch: %v returned %v", p.opts.JWKSURL, resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, constants.MaxJWKSSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fetch: invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logging.Warnf("fetch: skipping key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("fetch: %v has no usable signing keys", p.opts.JWKSURL)
+	}
+	return keys, nil
+}
+
+// publicKey returns the public key k describes
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("publicKey: invalid n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("publicKey: invalid e")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var point ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, point = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, point = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, point = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("publicKey: unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("publicKey: invalid x or y")
+		}
+		// ecdh checks that the point is on the curve
+		if _, err := point.NewPublicKey(slices.Concat([]byte{4}, x, y)); err != nil {
+			return nil, fmt.Errorf("publicKey: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("publicKey: unsupported or invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("publicKey: unsupported key type %q", k.Kty)
+	}
+}
+
+// verifySignature checks that sig is the signature of signed with key under alg
+// Returns an error wrapping ErrInvalidCredentials if it is not, or if alg is not supported or does not match key
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	var digest []byte
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		sum := sha256.Sum256([]byte(signed))
+		hash, digest = crypto.SHA256, sum[:]
+	case "384":
+		sum := sha512.Sum384([]byte(signed))
+		hash, digest = crypto.SHA384, sum[:]
+	case "512":
+		sum := sha512.Sum512([]byte(signed))
+		hash, digest = crypto.SHA512, sum[:]
+	}
+
+	valid := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case digest == nil:
+		case strings.HasPrefix(alg, "RS"):
+			valid = rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+		case strings.HasPrefix(alg, "PS"):
+			valid = rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		curves := map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+		if curves[alg] == k.Curve.Params().Name && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			valid = ecdsa.Verify(k, digest, r, s)
+		}
+	case ed25519.PublicKey:
+		valid = alg == "EdDSA" && ed25519.Verify(k, []byte(signed), sig)
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: bad signature or unsupported algorithm %q", ErrInvalidCredentials, alg)
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT into v
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("decodeSegment: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decodeSegment: %w", err)
+	}
+	return nil
+}
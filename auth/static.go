@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// StaticKeys accepts a fixed set of API keys
+type StaticKeys struct {
+	// keys maps the SHA-256 of every key to its subject, so lookups do not leak keys through timing
+	keys map[[sha256.Size]byte]string
+}
+
+// NewStaticKeys creates a provider accepting the keys of subjects, a map of API keys to the subjects they identify
+// Returns an error if a key is empty
+func NewStaticKeys(subjects map[string]string) (*StaticKeys, error) {
+	p := &StaticKeys{keys: make(map[[sha256.Size]byte]string, len(subjects))}
+	for key, subject := range subjects {
+		if key == "" {
+			return nil, fmt.Errorf("NewStaticKeys: empty API key for %q", subject)
+		}
+		p.keys[sha256.Sum256([]byte(key))] = subject
+	}
+	return p, nil
+}
+
+// Name returns "key"
+func (p *StaticKeys) Name() string {
+	return "key"
+}
+
+// Authenticate returns the subject of token if it is one of the keys
+// Any other token is not recognized, so it is passed on to the next provider
+func (p *StaticKeys) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	sum := sha256.Sum256([]byte(token))
+	for hash, subject := range p.keys {
+		if subtle.ConstantTimeCompare(hash[:], sum[:]) == 1 {
+			return &Identity{Provider: p.Name(), Subject: subject}, nil
+		}
+	}
+	return nil, ErrUnrecognized
+}
+
+// LoadStaticKeys creates a StaticKeys provider from a file listing one "<subject> <key>" pair per line
+// Blank lines and lines starting with # are skipped
+func LoadStaticKeys(path string) (*StaticKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadStaticKeys: %w", err)
+	}
+
+	subjects := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("LoadStaticKeys: %v:%d: expected \"<subject> <key>\"", path, i+1)
+		}
+		if _, ok := subjects[fields[1]]; ok {
+			return nil, fmt.Errorf("LoadStaticKeys: %v:%d: duplicate key", path, i+1)
+		}
+		subjects[fields[1]] = fields[0]
+	}
+
+	p, err := NewStaticKeys(subjects)
+	if err != nil {
+		return nil, fmt.Errorf("LoadStaticKeys: %w", err)
+	}
+	return p, nil
+}
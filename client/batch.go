@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"github.com/vi88i/kvstash/constants"
+	"sync"
+	"time"
+)
+
+// CoalesceOptions controls how concurrent Get calls are folded into multi-get requests
+type CoalesceOptions struct {
+	// Window is how long the first Get of a batch waits for others to join (default: 1ms)
+	Window time.Duration
+
+	// MaxBatch flushes a batch early once it holds this many distinct keys (default: constants.MaxBatchKeys)
+	MaxBatch int
+}
+
+// getResult is the outcome of a coalesced Get delivered to a waiting caller
+type getResult struct {
+	value string
+	err   error
+}
+
+// batcher collects concurrent Get calls and resolves them with a single MGet
+//
+// The first Get of a batch arms a timer of Window; the batch is sent when the timer fires or
+// as soon as it reaches MaxBatch keys, whichever comes first. Concurrent Gets of the same key
+// share one slot in the batch.
+type batcher struct {
+	// client sends the multi-get requests
+	client *Client
+
+	// window is the maximum time a batch stays open
+	window time.Duration
+
+	// maxBatch is the maximum number of distinct keys per batch
+	maxBatch int
+
+	// mu protects pending and timer
+	mu sync.Mutex
+
+	// pending maps every key of the open batch to the callers waiting for it
+	pending map[string][]chan getResult
+
+	// timer flushes the open batch when its window expires
+	timer *time.Timer
+}
+
+// newBatcher creates a batcher for c, applying defaults to opts
+func newBatcher(c *Client, opts CoalesceOptions) *batcher {
+	if opts.Window <= 0 {
+		opts.Window = time.Millisecond
+	}
+	if opts.MaxBatch <= 0 || opts.MaxBatch > constants.MaxBatchKeys {
+		opts.MaxBatch = constants.MaxBatchKeys
+	}
+
+	return &batcher{
+		client:   c,
+		window:   opts.Window,
+		maxBatch: opts.MaxBatch,
+		pending:  make(map[string][]chan getResult),
+	}
+}
+
+// get adds key to the open batch and waits for its result
+// Returns ErrNotFound if the key is missing from the multi-get response
+func (b *batcher) get(ctx context.Context, key string) (string, error) {
+	ch := make(chan getResult, 1)
+
+	b.mu.Lock()
+	b.pending[key] = append(b.pending[key], ch)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	if len(b.pending) >= b.maxBatch {
+		batch := b.take()
+		b.mu.Unlock()
+		go b.send(batch)
+	} else {
+		b.mu.Unlock()
+	}
+
+	select {
+	case res := <-ch:
+		return res.value, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// flush sends the open batch, if any
+func (b *batcher) flush() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.send(batch)
+	}
+}
+
+// take detaches the open batch and stops its timer
+// Must be called with mu held
+func (b *batcher) take() map[string][]chan getResult {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	batch := b.pending
+	b.pending = make(map[string][]chan getResult)
+	return batch
+}
+
+// send resolves a batch with one MGet and delivers the result to every waiting caller
+// The request is not tied to any single caller's context, since it serves all of them
+func (b *batcher) send(batch map[string][]chan getResult) {
+	keys := make([]string, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+
+	values, err := b.client.MGet(context.Background(), keys)
+	for key, waiters := range batch {
+		res := getResult{err: err}
+		if err == nil {
+			value, ok := values[key]
+			if ok {
+				res.value = value
+			} else {
+				res.err = ErrNotFound
+			}
+		}
+
+		for _, ch := range waiters {
+			ch <- res
+		}
+	}
+}
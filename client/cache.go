@@ -0,0 +1,184 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheOptions configures the client-side read cache
+type CacheOptions struct {
+	// MaxEntries is the maximum number of cached keys; the least recently used key is evicted first (default: 10000)
+	MaxEntries int
+
+	// TTL bounds how long an entry is served from the cache (default: no limit)
+	TTL time.Duration
+}
+
+// cacheEntry is a cached Get result
+type cacheEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// nearCache caches Get results locally and invalidates them from the server's changefeed
+//
+// Correctness relies on the changefeed: the cache is only used while a watch stream is connected.
+// When the stream drops, the cache is cleared and bypassed until a new stream is established, so
+// no update can be missed. A Get result is only cached if no invalidation arrived while it was
+// being fetched, which prevents a slow read from caching a value that was overwritten meanwhile.
+//
+// Entries are cached under the key passed to Get, while the changefeed carries the keys the server
+// stored. A server normalizing keys stores "User" as "user", so an event for "user" could not
+// invalidate an entry cached for "User"; the cache stays disabled while connected to such a server.
+type nearCache struct {
+	// client is used to open the watch stream
+	client *Client
+
+	// maxEntries and ttl are the configured limits
+	maxEntries int
+	ttl        time.Duration
+
+	// mu protects all fields below
+	mu sync.Mutex
+
+	// active is true while the watch stream is connected to a server that does not normalize keys
+	active bool
+
+	// generation is incremented on every invalidation
+	generation uint64
+
+	// entries maps keys to their element in lru
+	entries map[string]*list.Element
+
+	// lru orders entries from most to least recently used
+	lru *list.List
+
+	// cancel stops the watch goroutine
+	cancel context.CancelFunc
+}
+
+// newNearCache creates a cache for c and starts watching the changefeed
+func newNearCache(c *Client, opts CacheOptions) *nearCache {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 10000
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	nc := &nearCache{
+		client:     c,
+		maxEntries: opts.MaxEntries,
+		ttl:        opts.TTL,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		cancel:     cancel,
+	}
+	go nc.run(ctx)
+
+	return nc
+}
+
+// run keeps a watch stream open and applies its events to the cache until ctx is done
+func (nc *nearCache) run(ctx context.Context) {
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		stream, err := nc.client.openWatch(ctx, "", "", 0)
+		if err != nil {
+			timer := time.NewTimer(nc.client.retry.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+			continue
+		}
+		attempt = 0
+
+		nc.setActive(stream.normalization == "" || stream.normalization == "none")
+		for {
+			var event Event
+			if err := stream.decoder.Decode(&event); err != nil {
+				break
+			}
+			nc.invalidate(event.Key)
+		}
+		stream.body.Close()
+		nc.setActive(false)
+	}
+}
+
+// setActive enables or disables the cache; both transitions clear it
+func (nc *nearCache) setActive(active bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.active = active
+	nc.generation++
+	nc.entries = make(map[string]*list.Element)
+	nc.lru.Init()
+}
+
+// get returns the cached value of key
+// Also returns the current generation, which must be passed to put when caching the fetched value
+func (nc *nearCache) get(key string) (string, bool, uint64) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	elem, ok := nc.entries[key]
+	if !nc.active || !ok {
+		return "", false, nc.generation
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if nc.ttl > 0 && time.Now().After(entry.expires) {
+		nc.lru.Remove(elem)
+		delete(nc.entries, key)
+		return "", false, nc.generation
+	}
+
+	nc.lru.MoveToFront(elem)
+	return entry.value, true, nc.generation
+}
+
+// put caches value for key unless the cache was invalidated since generation was obtained from get
+func (nc *nearCache) put(key string, value string, generation uint64) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if !nc.active || generation != nc.generation {
+		return
+	}
+
+	entry := &cacheEntry{key: key, value: value, expires: time.Now().Add(nc.ttl)}
+	if elem, ok := nc.entries[key]; ok {
+		elem.Value = entry
+		nc.lru.MoveToFront(elem)
+		return
+	}
+
+	nc.entries[key] = nc.lru.PushFront(entry)
+	if nc.lru.Len() > nc.maxEntries {
+		oldest := nc.lru.Back()
+		nc.lru.Remove(oldest)
+		delete(nc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate drops key from the cache
+func (nc *nearCache) invalidate(key string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.generation++
+	if elem, ok := nc.entries[key]; ok {
+		nc.lru.Remove(elem)
+		delete(nc.entries, key)
+	}
+}
+
+// close stops watching the changefeed and disables the cache
+func (nc *nearCache) close() {
+	nc.cancel()
+	nc.setActive(false)
+}
@@ -0,0 +1,754 @@
+// Package client implements a Go client for the KVStash HTTP API
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors returned by the client for well-known server responses
+var (
+	ErrNotFound        = errors.New("key not found")
+	ErrBadRequest      = errors.New("bad request")
+	ErrConditionFailed = errors.New("batch condition failed")
+	ErrBadChecksum     = errors.New("value does not match its checksum")
+)
+
+// Server endpoints used by the client
+const (
+	kvEndpoint       = "/kvstash"
+	mgetEndpoint     = "/kvstash/mget"
+	statsEndpoint    = "/kvstash/stats"
+	undeleteEndpoint = "/kvstash/undelete"
+	renameEndpoint   = "/kvstash/rename"
+	expireEndpoint   = "/kvstash/expire"
+	batchEndpoint    = "/kvstash/batch"
+)
+
+// KV is the set of key-value operations offered by the client
+type KV interface {
+	// Get returns the value stored for key, or ErrNotFound
+	Get(ctx context.Context, key string) (string, error)
+
+	// MGet returns the values of all keys that exist; missing keys are absent from the result
+	MGet(ctx context.Context, keys []string) (map[string]string, error)
+
+	// Set stores value under key
+	Set(ctx context.Context, key string, value string) error
+
+	// Delete removes key, or returns ErrNotFound
+	Delete(ctx context.Context, key string) error
+
+	// Undelete restores the value key held before it was deleted, see Client.Undelete
+	Undelete(ctx context.Context, key string) error
+
+	// CheckAndSet applies writes only if every check holds, all of them or none, see Client.CheckAndSet
+	CheckAndSet(ctx context.Context, checks []models.KVStashCondition, writes []models.KVStashBatchWrite) ([]*models.KVStashVersion, error)
+
+	// Rename moves the value of from to the key to, see Client.Rename
+	Rename(ctx context.Context, from string, to string, replace bool) error
+
+	// SetWithTTL stores value under key, which expires after ttl, see Client.SetWithTTL
+	SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error
+
+	// SetUntil stores value under key, which expires at the absolute time at, see Client.SetUntil
+	SetUntil(ctx context.Context, key string, value string, at time.Time) error
+
+	// Expire changes the expiry time of key to ttl from now, see Client.Expire
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+
+	// ExpireAt changes the expiry time of key to the absolute time at, see Client.ExpireAt
+	ExpireAt(ctx context.Context, key string, at time.Time) error
+
+	// CompareAndSwap stores value under key only if key currently holds old, see Client.CompareAndSwap
+	CompareAndSwap(ctx context.Context, key string, old string, value string) error
+}
+
+// Options configures a Client
+// The zero value is usable and selects the defaults documented on each field
+type Options struct {
+	// HTTPClient is used to send requests
+	// When nil, a client with a pooled keep-alive transport tuned by the fields below is created
+	HTTPClient *http.Client
+
+	// MaxConnsPerHost limits the number of connections to the server (default: 64)
+	// Ignored if HTTPClient is set
+	MaxConnsPerHost int
+
+	// IdleConnTimeout is how long an idle keep-alive connection is kept in the pool (default: 90s)
+	// Ignored if HTTPClient is set
+	IdleConnTimeout time.Duration
+
+	// Timeout bounds every call, including all of its retries (default: no timeout)
+	Timeout time.Duration
+
+	// Token is sent as a bearer token with every request, for servers requiring authentication (default: none)
+	Token string
+
+	// Retry controls automatic retries (default: DefaultRetryPolicy)
+	Retry *RetryPolicy
+
+	// Coalesce folds concurrent Get calls into multi-get requests (default: disabled)
+	Coalesce *CoalesceOptions
+
+	// Cache enables a local read cache invalidated by the server's changefeed (default: disabled)
+	// The cache stays disabled while the server normalizes keys, see -key-normalization
+	// A client with a cache must be closed with Close
+	Cache *CacheOptions
+
+	// VerifyChecksums checks every value read by Get and MGet against the checksum the server sent with it, and
+	// fails the read with ErrBadChecksum if it does not match or is missing (default: disabled)
+	VerifyChecksums bool
+}
+
+// Client talks to a KVStash server over HTTP
+// It is safe for concurrent use
+type Client struct {
+	// baseURL is the server address, e.g. "http://localhost:8080"
+	baseURL string
+
+	// httpClient sends the requests
+	httpClient *http.Client
+
+	// timeout bounds every call (0 means no timeout)
+	timeout time.Duration
+
+	// token is the bearer token sent with every request, empty if none
+	token string
+
+	// retry controls automatic retries of failed requests
+	retry RetryPolicy
+
+	// batcher coalesces Get calls, nil if coalescing is disabled
+	batcher *batcher
+
+	// cache holds recent Get results, nil if caching is disabled
+	cache *nearCache
+
+	// verifyChecksums checks the values read against their checksums, see Options.VerifyChecksums
+	verifyChecksums bool
+}
+
+var _ KV = (*Client)(nil)
+
+// New creates a client for the server at baseURL
+// opts may be nil to use the defaults
+func New(baseURL string, opts *Options) *Client {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	c := &Client{
+		baseURL:         strings.TrimRight(baseURL, "/"),
+		httpClient:      opts.HTTPClient,
+		timeout:         opts.Timeout,
+		token:           opts.Token,
+		retry:           DefaultRetryPolicy,
+		verifyChecksums: opts.VerifyChecksums,
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Transport: newTransport(opts)}
+	}
+
+	if opts.Retry != nil {
+		c.retry = *opts.Retry
+	}
+
+	if opts.Coalesce != nil {
+		c.batcher = newBatcher(c, *opts.Coalesce)
+	}
+
+	if opts.Cache != nil {
+		c.cache = newNearCache(c, *opts.Cache)
+	}
+
+	return c
+}
+
+// Close releases background resources held by the client, such as the cache's changefeed connection
+func (c *Client) Close() error {
+	if c.cache != nil {
+		c.cache.close()
+	}
+	return nil
+}
+
+// authorize adds the bearer token of the client to req, if it has one
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// newTransport creates a keep-alive transport whose idle pool is as large as its connection limit,
+// so connections are reused under load instead of being closed and re-dialed
+func newTransport(opts *Options) *http.Transport {
+	maxConns := opts.MaxConnsPerHost
+	if maxConns <= 0 {
+		maxConns = 64
+	}
+
+	idleTimeout := opts.IdleConnTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = 90 * time.Second
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = maxConns
+	transport.MaxIdleConns = maxConns
+	transport.MaxIdleConnsPerHost = maxConns
+	transport.IdleConnTimeout = idleTimeout
+	return transport
+}
+
+// withTimeout applies the client's per-call timeout to ctx
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// Get returns the value stored for key
+// Returns ErrNotFound if the key does not exist
+// If caching is enabled, cached values are returned without contacting the server
+// If coalescing is enabled, the lookup is sent as part of a multi-get together with concurrent Get calls
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	if c.cache == nil {
+		return c.get(ctx, key)
+	}
+
+	value, ok, generation := c.cache.get(key)
+	if ok {
+		return value, nil
+	}
+
+	value, err := c.get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	c.cache.put(key, value, generation)
+
+	return value, nil
+}
+
+// get fetches the value of key from the server
+func (c *Client) get(ctx context.Context, key string) (string, error) {
+	if c.batcher != nil {
+		value, err := c.batcher.get(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("Get: %w", err)
+		}
+		return value, nil
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodGet, kvEndpoint, newKeyRequest(key, ""), &resp, true); err != nil {
+		return "", fmt.Errorf("Get: %w", err)
+	}
+
+	if resp.Data == nil {
+		return "", fmt.Errorf("Get: response without data")
+	}
+	if err := c.decodeValue(resp.Data); err != nil {
+		return "", fmt.Errorf("Get: %w", err)
+	}
+
+	return resp.Data.Value, nil
+}
+
+// decodeValue decodes the value of kv, a key-value pair read from the server, in place and verifies it against its
+// checksum if the client verifies checksums
+// Returns ErrBadChecksum if the checksum does not match or the server did not send one
+func (c *Client) decodeValue(kv *models.KVStashRequest) error {
+	value, err := models.DecodeValue(kv.Value, kv.ValueEncoding)
+	if err != nil {
+		return fmt.Errorf("decodeValue: %w", err)
+	}
+	kv.Value, kv.ValueEncoding = value, ""
+
+	if !c.verifyChecksums {
+		return nil
+	}
+	if kv.Checksum == nil {
+		return fmt.Errorf("decodeValue: %w: the server sent no checksum", ErrBadChecksum)
+	}
+	if !kv.Checksum.Matches(kv.Value) {
+		return fmt.Errorf("decodeValue: %w: %d bytes received, expected %v %v", ErrBadChecksum,
+			len(kv.Value), kv.Checksum.Algorithm, kv.Checksum.Digest)
+	}
+	return nil
+}
+
+// MGet returns the values of all keys that exist in a single round trip
+// Missing and deleted keys are absent from the result
+func (c *Client) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	// Keys that are not valid UTF-8 cannot be sent as JSON strings, so then all keys are sent base64-encoded
+	req := &models.KVStashMultiGetRequest{Keys: keys}
+	for _, key := range keys {
+		if models.KeyEncodingFor(key) != "" {
+			req.KeyEncoding = models.KeyEncodingBase64
+			req.Keys = make([]string, len(keys))
+			for i, k := range keys {
+				req.Keys[i] = models.EncodeKey(k, req.KeyEncoding)
+			}
+			break
+		}
+	}
+
+	var resp models.KVStashMultiGetResponse
+	if err := c.do(ctx, http.MethodPost, mgetEndpoint, req, &resp, true); err != nil {
+		return nil, fmt.Errorf("MGet: %w", err)
+	}
+
+	values := make(map[string]string, len(resp.Data))
+	for _, kv := range resp.Data {
+		key, err := models.DecodeKey(kv.Key, kv.KeyEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("MGet: %w", err)
+		}
+		if err := c.decodeValue(&kv); err != nil {
+			return nil, fmt.Errorf("MGet: %w", err)
+		}
+		values[key] = kv.Value
+	}
+
+	return values, nil
+}
+
+// Set stores value under key
+// Setting the same value twice has the same effect as setting it once, so Set is retried on network errors
+func (c *Client) Set(ctx context.Context, key string, value string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	// Drop the cached value right away so this client reads its own write without waiting for the changefeed
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodPost, kvEndpoint, newKeyRequest(key, value), &resp, true); err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+
+	return nil
+}
+
+// SetWithTTL stores value under key, which expires after ttl, rounded up to whole seconds
+// A ttl of 0 applies the default TTL of the key's namespace on the server and a negative ttl never expires
+// Like Set, it is retried on network errors
+func (c *Client) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	req := newKeyRequest(key, value)
+	switch {
+	case ttl < 0:
+		req.TTL = -1
+	case ttl > 0:
+		req.TTL = int64((ttl + time.Second - 1) / time.Second)
+	}
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodPost, kvEndpoint, req, &resp, true); err != nil {
+		return fmt.Errorf("SetWithTTL: %w", err)
+	}
+
+	return nil
+}
+
+// SetUntil stores value under key, which expires at the absolute time at, rounded up to whole seconds
+// Like Set, it is retried on network errors
+func (c *Client) SetUntil(ctx context.Context, key string, value string, at time.Time) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	req := newKeyRequest(key, value)
+	req.ExpireAt = unixSeconds(at)
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodPost, kvEndpoint, req, &resp, true); err != nil {
+		return fmt.Errorf("SetUntil: %w", err)
+	}
+
+	return nil
+}
+
+// Expire changes the expiry time of key to ttl from now, rounded up to whole seconds, without rewriting its value;
+// a negative ttl makes it never expire
+// Returns ErrNotFound if the key does not exist
+// Setting an expiry time is idempotent, so it is retried on network errors like Set
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	req := &models.KVStashExpireRequest{TTL: -1}
+	if ttl >= 0 {
+		req.TTL = int64((ttl + time.Second - 1) / time.Second)
+	}
+
+	if err := c.expire(ctx, key, req); err != nil {
+		return fmt.Errorf("Expire: %w", err)
+	}
+	return nil
+}
+
+// ExpireAt changes the expiry time of key to the absolute time at, rounded up to whole seconds, without rewriting
+// its value; a time in the past expires the key right away
+// Returns ErrNotFound if the key does not exist
+func (c *Client) ExpireAt(ctx context.Context, key string, at time.Time) error {
+	if err := c.expire(ctx, key, &models.KVStashExpireRequest{ExpireAt: unixSeconds(at)}); err != nil {
+		return fmt.Errorf("ExpireAt: %w", err)
+	}
+	return nil
+}
+
+// expire sends req, an expiry change without its key, for key
+func (c *Client) expire(ctx context.Context, key string, req *models.KVStashExpireRequest) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	req.KeyEncoding = models.KeyEncodingFor(key)
+	req.Key = models.EncodeKey(key, req.KeyEncoding)
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodPost, expireEndpoint, req, &resp, true); err != nil {
+		return fmt.Errorf("expire: %w", err)
+	}
+
+	return nil
+}
+
+// unixSeconds returns at as a Unix time in seconds, rounded up
+func unixSeconds(at time.Time) int64 {
+	seconds := at.Unix()
+	if at.Nanosecond() > 0 {
+		seconds++
+	}
+	return seconds
+}
+
+// Delete removes key
+// Returns ErrNotFound if the key does not exist
+// Delete is only retried when the server explicitly rejected the request (429/503), because a
+// retry after a lost response would report ErrNotFound for a delete that actually succeeded
+func (c *Client) Delete(ctx context.Context, key string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodDelete, kvEndpoint, newKeyRequest(key, ""), &resp, false); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+
+	return nil
+}
+
+// CheckAndSet applies writes only if every check holds, all of them or none, and returns their versions
+// Returns ErrConditionFailed if a check does not hold
+// CheckAndSet is only retried when the server explicitly rejected the request (429/503), since a retry after
+// a lost response would fail checks the batch itself invalidated
+func (c *Client) CheckAndSet(ctx context.Context, checks []models.KVStashCondition, writes []models.KVStashBatchWrite) ([]*models.KVStashVersion, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		for _, w := range writes {
+			defer c.cache.invalidate(w.Key)
+		}
+	}
+
+	// Keys and values that are not valid UTF-8 cannot be sent as JSON strings, so then all keys, or all values, are
+	// sent base64-encoded
+	req := &models.KVStashBatchRequest{Checks: checks, Writes: writes}
+	for _, check := range checks {
+		if models.KeyEncodingFor(check.Key) != "" {
+			req.KeyEncoding = models.KeyEncodingBase64
+		}
+		if check.Value != nil && models.KeyEncodingFor(*check.Value) != "" {
+			req.ValueEncoding = models.KeyEncodingBase64
+		}
+	}
+	for _, w := range writes {
+		if models.KeyEncodingFor(w.Key) != "" {
+			req.KeyEncoding = models.KeyEncodingBase64
+		}
+		if models.KeyEncodingFor(w.Value) != "" {
+			req.ValueEncoding = models.KeyEncodingBase64
+		}
+	}
+	if req.KeyEncoding != "" || req.ValueEncoding != "" {
+		req.Checks = make([]models.KVStashCondition, len(checks))
+		for i, check := range checks {
+			check.Key = models.EncodeKey(check.Key, req.KeyEncoding)
+			if check.Value != nil {
+				value, _ := models.EncodeValue(*check.Value, req.ValueEncoding)
+				check.Value = &value
+			}
+			req.Checks[i] = check
+		}
+		req.Writes = make([]models.KVStashBatchWrite, len(writes))
+		for i, w := range writes {
+			w.Key = models.EncodeKey(w.Key, req.KeyEncoding)
+			w.Value, _ = models.EncodeValue(w.Value, req.ValueEncoding)
+			req.Writes[i] = w
+		}
+	}
+
+	var resp models.KVStashBatchResponse
+	if err := c.do(ctx, http.MethodPost, batchEndpoint, req, &resp, false); err != nil {
+		return nil, fmt.Errorf("CheckAndSet: %w", err)
+	}
+
+	return resp.Versions, nil
+}
+
+// CompareAndSwap stores value under key only if key currently holds old
+// Returns a StatusError with status 409 if it does not, including if the key does not exist
+// CompareAndSwap is only retried when the server explicitly rejected the request (429/503): a retry after a lost
+// response would report a conflict for a swap that actually succeeded
+func (c *Client) CompareAndSwap(ctx context.Context, key string, old string, value string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	req := newKeyRequest(key, value)
+	if models.KeyEncodingFor(old) != "" && req.ValueEncoding == "" {
+		req.Value, req.ValueEncoding = models.EncodeValue(value, models.KeyEncodingBase64)
+	}
+	expected := models.EncodeKey(old, req.ValueEncoding)
+	req.ExpectedValue = &expected
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodPost, kvEndpoint, req, &resp, false); err != nil {
+		return fmt.Errorf("CompareAndSwap: %w", err)
+	}
+
+	return nil
+}
+
+// Undelete restores the value key held before it was deleted, as long as the server has not compacted it away
+// Returns ErrNotFound if there is nothing to restore, and a StatusError with status 409 if the key is not deleted
+// Undelete is only retried when the server explicitly rejected the request (429/503), like Delete
+func (c *Client) Undelete(ctx context.Context, key string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		defer c.cache.invalidate(key)
+	}
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodPost, undeleteEndpoint, newKeyRequest(key, ""), &resp, false); err != nil {
+		return fmt.Errorf("Undelete: %w", err)
+	}
+
+	return nil
+}
+
+// Rename moves the value of from to the key to, keeping its type and expiry time; to is replaced if it exists and
+// replace is set
+// Returns ErrNotFound if from does not exist, and a StatusError with status 409 if to exists and replace is not set
+// Rename is only retried when the server explicitly rejected the request (429/503), like Delete
+func (c *Client) Rename(ctx context.Context, from string, to string, replace bool) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.cache != nil {
+		defer c.cache.invalidate(from)
+		defer c.cache.invalidate(to)
+	}
+
+	encoding := models.KeyEncodingFor(from)
+	if encoding == "" {
+		encoding = models.KeyEncodingFor(to)
+	}
+	req := &models.KVStashRenameRequest{
+		Key:         models.EncodeKey(from, encoding),
+		NewKey:      models.EncodeKey(to, encoding),
+		Replace:     replace,
+		KeyEncoding: encoding,
+	}
+
+	var resp models.KVStashResponse
+	if err := c.do(ctx, http.MethodPost, renameEndpoint, req, &resp, false); err != nil {
+		return fmt.Errorf("Rename: %w", err)
+	}
+
+	return nil
+}
+
+// Stats returns the server's request metrics, store statistics, and compaction activity
+func (c *Client) Stats(ctx context.Context) (*models.KVStashStats, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var stats models.KVStashStats
+	if err := c.do(ctx, http.MethodGet, statsEndpoint, nil, &stats, true); err != nil {
+		return nil, fmt.Errorf("Stats: %w", err)
+	}
+
+	return &stats, nil
+}
+
+// newKeyRequest builds the body of a key-value request, base64-encoding keys and values that are not valid UTF-8
+func newKeyRequest(key string, value string) *models.KVStashRequest {
+	encoding := models.KeyEncodingFor(key)
+	req := &models.KVStashRequest{Key: models.EncodeKey(key, encoding), KeyEncoding: encoding}
+	req.Value, req.ValueEncoding = models.EncodeValue(value, "")
+	return req
+}
+
+// do sends a request to endpoint and decodes a successful response into out,
+// retrying according to the client's retry policy
+// idempotent controls whether network errors (where the outcome is unknown) may be retried
+func (c *Client) do(ctx context.Context, method string, endpoint string, in any, out any, idempotent bool) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("do: failed to serialize request: %w", err)
+	}
+
+	// The same key is sent with every attempt so a server can recognize retries of one logical request
+	idempotencyKey := newIdempotencyKey()
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := c.send(ctx, method, endpoint, body, idempotencyKey, out)
+		if err == nil {
+			return nil
+		}
+
+		// Rejections (429/503) are always safe to retry, network errors only for idempotent operations
+		var statusErr *StatusError
+		retryable := idempotent && ctx.Err() == nil
+		if errors.As(err, &statusErr) {
+			retryable = statusErr.Retryable()
+		}
+
+		delay, ok := c.retry.next(attempt, time.Since(start), retryAfter)
+		if !retryable || !ok {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// send performs a single HTTP round trip and decodes a successful response into out
+// Returns the Retry-After delay requested by the server, if any, alongside the error
+func (c *Client) send(ctx context.Context, method string, endpoint string, body []byte, idempotencyKey string, out any) (time.Duration, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("send: failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+	c.authorize(httpReq)
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("send: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("send: failed to read response: %w", err)
+	}
+
+	if httpResp.StatusCode >= 200 && httpResp.StatusCode < 300 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return 0, fmt.Errorf("send: failed to decode response: %w", err)
+		}
+		return 0, nil
+	}
+
+	// Error responses may come from a proxy and not carry a JSON body, so decoding them is best effort
+	var errResp models.KVStashResponse
+	_ = json.Unmarshal(respBody, &errResp)
+
+	return parseRetryAfter(httpResp.Header.Get("Retry-After")), &StatusError{
+		StatusCode: httpResp.StatusCode,
+		Message:    errResp.Message,
+	}
+}
+
+// StatusError is returned when the server responds with a non-2xx status code
+type StatusError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+
+	// Message is the message field of the response body
+	Message string
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned %d: %v", e.StatusCode, e.Message)
+}
+
+// Unwrap maps well-known status codes to the client's sentinel errors
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusBadRequest:
+		return ErrBadRequest
+	case http.StatusPreconditionFailed:
+		return ErrConditionFailed
+	}
+	return nil
+}
+
+// Retryable reports whether the server asked the client to try again later
+// 429 and 503 are returned before a request is applied, so they are safe to retry for every operation
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// newIdempotencyKey returns a random 128-bit hex string
+func newIdempotencyKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,498 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Mock is an in-memory implementation of KV for testing applications without a server
+// It mirrors the server's validation and returns the same errors as Client
+// It is safe for concurrent use
+type Mock struct {
+	// mu protects all fields below
+	mu sync.RWMutex
+
+	// data holds the stored key-value pairs
+	data map[string]*mockEntry
+
+	// deleted holds the value every deleted key held before it was last deleted, for Undelete
+	deleted map[string]*mockEntry
+
+	// revision is the revision of the latest write
+	revision uint64
+}
+
+// mockEntry is a value stored in the mock
+type mockEntry struct {
+	value string
+
+	// revision is the revision the value was written at, see models.KVStashVersion.Revision
+	revision uint64
+
+	// expiresAt is when the value expires, the zero time if it never does
+	expiresAt time.Time
+}
+
+// expired reports whether the entry expired
+func (e *mockEntry) expired() bool {
+	return !e.expiresAt.IsZero() && !time.Now().Before(e.expiresAt)
+}
+
+// version returns the version of the entry
+// The mock has no segments, so the checksum covers the value and revision only; like on the server, two writes of
+// the same value have different checksums
+func (e *mockEntry) version() *models.KVStashVersion {
+	var revision [8]byte
+	binary.BigEndian.PutUint64(revision[:], e.revision)
+	sum := sha256.Sum256(append(revision[:], e.value...))
+	return &models.KVStashVersion{Revision: e.revision, Checksum: hex.EncodeToString(sum[:])}
+}
+
+var _ KV = (*Mock)(nil)
+
+// NewMock creates an empty in-memory KV
+func NewMock() *Mock {
+	return &Mock{data: make(map[string]*mockEntry), deleted: make(map[string]*mockEntry)}
+}
+
+// Get returns the value stored for key, or ErrNotFound
+func (m *Mock) Get(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("Get: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.lookup(key)
+	if !ok {
+		return "", fmt.Errorf("Get: %w", ErrNotFound)
+	}
+
+	return entry.value, nil
+}
+
+// MGet returns the values of all keys that exist; missing keys are absent from the result
+func (m *Mock) MGet(ctx context.Context, keys []string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("MGet: %w", err)
+	}
+
+	if len(keys) > constants.MaxBatchKeys {
+		return nil, fmt.Errorf("MGet: %w: too many keys (max %d)", ErrBadRequest, constants.MaxBatchKeys)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if entry, ok := m.lookup(key); ok {
+			values[key] = entry.value
+		}
+	}
+
+	return values, nil
+}
+
+// Set stores value under key
+// Returns ErrBadRequest for the same inputs the server rejects
+func (m *Mock) Set(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+
+	if err := validateMockKey(key); err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+
+	if err := validateMockValue(value); err != nil {
+		return fmt.Errorf("Set: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value, time.Time{})
+	return nil
+}
+
+// SetWithTTL stores value under key, which expires after ttl, rounded up to whole seconds
+// The mock has no namespaces, so a ttl of 0, like a negative one, never expires
+func (m *Mock) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("SetWithTTL: %w", err)
+	}
+
+	if err := validateMockKey(key); err != nil {
+		return fmt.Errorf("SetWithTTL: %w", err)
+	}
+
+	if err := validateMockValue(value); err != nil {
+		return fmt.Errorf("SetWithTTL: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value, mockExpiry(ttl))
+	return nil
+}
+
+// SetUntil stores value under key, which expires at the absolute time at, rounded up to whole seconds
+func (m *Mock) SetUntil(ctx context.Context, key string, value string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("SetUntil: %w", err)
+	}
+
+	if err := validateMockKey(key); err != nil {
+		return fmt.Errorf("SetUntil: %w", err)
+	}
+
+	if err := validateMockValue(value); err != nil {
+		return fmt.Errorf("SetUntil: %w", err)
+	}
+
+	expireAt := unixSeconds(at)
+	if expireAt <= 0 {
+		return fmt.Errorf("SetUntil: %w: expire_at must be a positive Unix time", ErrBadRequest)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value, time.Unix(expireAt, 0))
+	return nil
+}
+
+// Expire changes the expiry time of key to ttl from now, rounded up to whole seconds; a negative ttl makes it
+// never expire
+// Returns ErrNotFound if the key does not exist
+func (m *Mock) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Expire: %w", err)
+	}
+
+	if ttl == 0 {
+		return fmt.Errorf("Expire: %w: ttl must be positive, or -1 for no expiry", ErrBadRequest)
+	}
+
+	if err := m.expire(key, mockExpiry(ttl)); err != nil {
+		return fmt.Errorf("Expire: %w", err)
+	}
+	return nil
+}
+
+// ExpireAt changes the expiry time of key to the absolute time at, rounded up to whole seconds; a time in the
+// past expires the key right away
+// Returns ErrNotFound if the key does not exist
+func (m *Mock) ExpireAt(ctx context.Context, key string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("ExpireAt: %w", err)
+	}
+
+	expireAt := unixSeconds(at)
+	if expireAt <= 0 {
+		return fmt.Errorf("ExpireAt: %w: expire_at must be a positive Unix time", ErrBadRequest)
+	}
+
+	if err := m.expire(key, time.Unix(expireAt, 0)); err != nil {
+		return fmt.Errorf("ExpireAt: %w", err)
+	}
+	return nil
+}
+
+// expire rewrites key to expire at expiresAt, the zero time for never
+func (m *Mock) expire(key string, expiresAt time.Time) error {
+	if err := validateMockKey(key); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.lookup(key)
+	if !ok {
+		return ErrNotFound
+	}
+	m.put(key, entry.value, expiresAt)
+
+	return nil
+}
+
+// mockExpiry returns the expiry time of a value written with ttl, rounded up to whole seconds, the zero time if
+// ttl is not positive
+func mockExpiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add((ttl + time.Second - 1) / time.Second * time.Second)
+}
+
+// Delete removes key, or returns ErrNotFound
+func (m *Mock) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+
+	if err := validateMockKey(key); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.remove(key) {
+		return fmt.Errorf("Delete: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// Undelete restores the value key held before it was last deleted
+// Returns ErrNotFound if key was never deleted, and a StatusError with status 409 if it holds a value
+func (m *Mock) Undelete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Undelete: %w", err)
+	}
+
+	if err := validateMockKey(key); err != nil {
+		return fmt.Errorf("Undelete: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.lookup(key); ok {
+		return fmt.Errorf("Undelete: %w", &StatusError{StatusCode: http.StatusConflict, Message: "key is not deleted"})
+	}
+	entry, ok := m.deleted[key]
+	if !ok {
+		return fmt.Errorf("Undelete: %w", ErrNotFound)
+	}
+	delete(m.deleted, key)
+	m.put(key, entry.value, entry.expiresAt)
+
+	return nil
+}
+
+// CheckAndSet applies writes only if every check holds, all of them or none, and returns their versions
+// Returns a StatusError with status 412, which wraps ErrConditionFailed, if a check does not hold
+func (m *Mock) CheckAndSet(ctx context.Context, checks []models.KVStashCondition, writes []models.KVStashBatchWrite) ([]*models.KVStashVersion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("CheckAndSet: %w", err)
+	}
+
+	if err := validateMockBatch(checks, writes); err != nil {
+		return nil, fmt.Errorf("CheckAndSet: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range checks {
+		if !m.holds(c) {
+			return nil, fmt.Errorf("CheckAndSet: %w",
+				&StatusError{StatusCode: http.StatusPreconditionFailed, Message: "batch condition failed"})
+		}
+	}
+
+	versions := make([]*models.KVStashVersion, len(writes))
+	for i, w := range writes {
+		if w.Delete {
+			m.remove(w.Key)
+			continue
+		}
+		expiresAt := mockExpiry(time.Duration(w.TTL) * time.Second)
+		if w.ExpireAt > 0 {
+			expiresAt = time.Unix(w.ExpireAt, 0)
+		}
+		versions[i] = m.put(w.Key, w.Value, expiresAt).version()
+	}
+
+	return versions, nil
+}
+
+// CompareAndSwap stores value under key only if key currently holds old
+// Returns a StatusError with status 409 if it does not, including if the key does not exist
+func (m *Mock) CompareAndSwap(ctx context.Context, key string, old string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("CompareAndSwap: %w", err)
+	}
+
+	if err := validateMockKey(key); err != nil {
+		return fmt.Errorf("CompareAndSwap: %w", err)
+	}
+
+	if err := validateMockValue(value); err != nil {
+		return fmt.Errorf("CompareAndSwap: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.lookup(key); !ok || entry.value != old {
+		return fmt.Errorf("CompareAndSwap: %w",
+			&StatusError{StatusCode: http.StatusConflict, Message: "current value does not match the expected one"})
+	}
+	m.put(key, value, time.Time{})
+
+	return nil
+}
+
+// Rename moves the value of from to the key to; to is replaced if it exists and replace is set
+// Returns ErrNotFound if from does not exist, and a StatusError with status 409 if to exists and replace is not set
+func (m *Mock) Rename(ctx context.Context, from string, to string, replace bool) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Rename: %w", err)
+	}
+
+	if err := validateMockKey(from); err != nil {
+		return fmt.Errorf("Rename: %w", err)
+	}
+	if err := validateMockKey(to); err != nil {
+		return fmt.Errorf("Rename: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.lookup(from)
+	if !ok {
+		return fmt.Errorf("Rename: %w", ErrNotFound)
+	}
+	if _, exists := m.lookup(to); exists && !replace {
+		return fmt.Errorf("Rename: %w", &StatusError{StatusCode: http.StatusConflict, Message: "key already exists"})
+	}
+	if from == to {
+		return nil
+	}
+
+	m.remove(from)
+	m.put(to, entry.value, entry.expiresAt)
+
+	return nil
+}
+
+// holds reports whether every condition of c holds for the current value of its key
+// Must be called with mu held
+func (m *Mock) holds(c models.KVStashCondition) bool {
+	entry, exists := m.lookup(c.Key)
+	switch {
+	case c.Exists != nil && *c.Exists != exists:
+		return false
+	case c.Version != "" && (!exists || c.Version != entry.version().Checksum):
+		return false
+	case c.Revision != nil && (!exists || *c.Revision != entry.revision):
+		return false
+	case c.Value != nil && (!exists || *c.Value != entry.value):
+		return false
+	}
+	return true
+}
+
+// lookup returns the entry of key, unless it does not exist or expired
+// Must be called with mu held
+func (m *Mock) lookup(key string) (*mockEntry, bool) {
+	entry, ok := m.data[key]
+	if !ok || entry.expired() {
+		return nil, false
+	}
+	return entry, true
+}
+
+// put stores value under key at a new revision, expiring at expiresAt, the zero time for never
+// Must be called with mu held
+func (m *Mock) put(key string, value string, expiresAt time.Time) *mockEntry {
+	m.revision++
+	entry := &mockEntry{value: value, revision: m.revision, expiresAt: expiresAt}
+	m.data[key] = entry
+	return entry
+}
+
+// remove deletes key, keeping its value for Undelete, and reports whether it existed
+// Must be called with mu held
+func (m *Mock) remove(key string) bool {
+	entry, ok := m.lookup(key)
+	if !ok {
+		return false
+	}
+	delete(m.data, key)
+	m.deleted[key] = entry
+	return true
+}
+
+// validateMockKey applies the server's key validation
+func validateMockKey(key string) error {
+	if len(key) == 0 {
+		return fmt.Errorf("%w: key should not be empty", ErrBadRequest)
+	}
+
+	if len(key) > constants.MaxKeySize {
+		return fmt.Errorf("%w: key exceeds maximum size (%d bytes)", ErrBadRequest, constants.MaxKeySize)
+	}
+
+	return nil
+}
+
+// validateMockValue applies the server's value validation
+func validateMockValue(value string) error {
+	if len(value) == 0 {
+		return fmt.Errorf("%w: value should be non-empty", ErrBadRequest)
+	}
+
+	if len(value) > constants.MaxValueSize {
+		return fmt.Errorf("%w: value exceeds maximum size (%d bytes)", ErrBadRequest, constants.MaxValueSize)
+	}
+
+	return nil
+}
+
+// validateMockBatch applies the server's batch validation
+func validateMockBatch(checks []models.KVStashCondition, writes []models.KVStashBatchWrite) error {
+	if len(writes) == 0 {
+		return fmt.Errorf("%w: no writes", ErrBadRequest)
+	}
+
+	if len(checks)+len(writes) > constants.MaxBatchKeys {
+		return fmt.Errorf("%w: too many checks and writes (max %d)", ErrBadRequest, constants.MaxBatchKeys)
+	}
+
+	for i, c := range checks {
+		if err := validateMockKey(c.Key); err != nil {
+			return fmt.Errorf("check %d: %w", i, err)
+		}
+		if c.Exists == nil && c.Version == "" && c.Revision == nil && c.Value == nil {
+			return fmt.Errorf("%w: check %d has no condition", ErrBadRequest, i)
+		}
+	}
+
+	for i, w := range writes {
+		if err := validateMockKey(w.Key); err != nil {
+			return fmt.Errorf("write %d: %w", i, err)
+		}
+		if w.Delete {
+			continue
+		}
+		if err := validateMockValue(w.Value); err != nil {
+			return fmt.Errorf("write %d: %w", i, err)
+		}
+		if w.TTL < -1 {
+			return fmt.Errorf("write %d: %w: ttl must be positive, or -1 for no expiry", i, ErrBadRequest)
+		}
+		if w.ExpireAt < 0 || (w.ExpireAt > 0 && w.TTL != 0) {
+			return fmt.Errorf("write %d: %w: expire_at must be a positive Unix time and cannot be combined with ttl",
+				i, ErrBadRequest)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EventDropped is delivered by Subscribe when the server dropped notifications because the subscriber fell behind
+const EventDropped = "dropped"
+
+// notifyEndpoint is the path of the keyspace notification stream on the server
+const notifyEndpoint = "/kvstash/notify"
+
+// Notification is a keyspace notification received from Subscribe
+type Notification struct {
+	Event
+
+	// Dropped is the number of missed notifications, set on notifications of type EventDropped
+	Dropped uint64
+}
+
+// Subscribe streams keyspace notifications of the given event classes ("set", "delete"; none selects all)
+// on keys starting with prefix
+// Notifications are a fire-hose without resume: dropped connections are re-established with exponential backoff
+// and an EventReset notification marks the gap, and an EventDropped notification reports notifications the
+// server skipped because the subscriber fell behind
+// The first connection is established before Subscribe returns; the returned channel is closed when ctx is done
+func (c *Client) Subscribe(ctx context.Context, prefix string, classes ...string) (<-chan Notification, error) {
+	body, err := c.openNotify(ctx, prefix, classes)
+	if err != nil {
+		return nil, fmt.Errorf("Subscribe: %w", err)
+	}
+
+	out := make(chan Notification, 64)
+	go c.runNotify(ctx, prefix, classes, body, out)
+
+	return out, nil
+}
+
+// runNotify forwards notifications from body to out and reconnects whenever the stream ends
+func (c *Client) runNotify(ctx context.Context, prefix string, classes []string, body io.ReadCloser, out chan<- Notification) {
+	defer close(out)
+
+	send := func(n Notification) bool {
+		select {
+		case out <- n:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		// Read until the stream ends: the connection dropped, the server went away, or ctx is done
+		readEvents(body, func(name string, data []byte) error {
+			n := Notification{}
+			if name == EventDropped {
+				var dropped struct {
+					Count uint64 `json:"count"`
+				}
+				if err := json.Unmarshal(data, &dropped); err != nil {
+					return err
+				}
+				n.Type, n.Dropped = EventDropped, dropped.Count
+			} else if err := json.Unmarshal(data, &n.Event); err != nil {
+				return err
+			} else if err := decodeEventKey(&n.Event); err != nil {
+				return err
+			}
+
+			if !send(n) {
+				return ctx.Err()
+			}
+			return nil
+		})
+		body.Close()
+		if ctx.Err() != nil {
+			return
+		}
+
+		for attempt := 1; ; attempt++ {
+			timer := time.NewTimer(c.retry.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			var err error
+			if body, err = c.openNotify(ctx, prefix, classes); err == nil {
+				break
+			}
+		}
+
+		if !send(Notification{Event: Event{Type: EventReset}}) {
+			return
+		}
+	}
+}
+
+// openNotify opens a keyspace notification stream
+func (c *Client) openNotify(ctx context.Context, prefix string, classes []string) (io.ReadCloser, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if len(classes) > 0 {
+		query.Set("events", strings.Join(classes, ","))
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+notifyEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("openNotify: failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+	c.authorize(httpReq)
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("openNotify: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+
+		var errResp struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(httpResp.Body).Decode(&errResp)
+		return nil, &StatusError{StatusCode: httpResp.StatusCode, Message: errResp.Message}
+	}
+
+	return httpResp.Body, nil
+}
+
+// readEvents parses a server-sent event stream, calling fn with the name and data of every event
+// Comments and fields other than event and data are ignored
+func readEvents(r io.Reader, fn func(name string, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	var name string
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data != nil {
+				if err := fn(name, data); err != nil {
+					return err
+				}
+			}
+			name, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimSpace(strings.TrimPrefix(line, "data:"))...)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried
+// Delays grow exponentially from BaseDelay up to MaxDelay with full jitter; a Retry-After header
+// sent by the server overrides the computed delay
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one (1 disables retries)
+	MaxAttempts int
+
+	// BaseDelay is the upper bound of the delay before the first retry
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between two attempts, including delays requested via Retry-After
+	MaxDelay time.Duration
+
+	// Budget caps the total time spent on one operation across all attempts (0 means no limit)
+	// A retry is not attempted if its delay would exceed the remaining budget
+	Budget time.Duration
+}
+
+// DefaultRetryPolicy retries up to 4 times within 10 seconds, long enough to ride out a compaction cycle
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+	Budget:      10 * time.Second,
+}
+
+// NoRetry disables automatic retries
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// next returns the delay before the attempt following attempt, and false if no retry should be made
+// elapsed is the time spent on the operation so far and retryAfter is the server-requested delay (0 if none)
+func (p RetryPolicy) next(attempt int, elapsed time.Duration, retryAfter time.Duration) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	delay := retryAfter
+	if delay <= 0 {
+		delay = p.backoff(attempt)
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Budget > 0 && elapsed+delay > p.Budget {
+		return 0, false
+	}
+
+	return delay, true
+}
+
+// backoff returns a random delay between 0 and min(BaseDelay * 2^(attempt-1), MaxDelay)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || (p.MaxDelay > 0 && ceiling > p.MaxDelay) {
+		ceiling = p.MaxDelay
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+// Returns 0 if the header is empty or invalid
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		return max(time.Until(when), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Event is a mutation received from the server's changefeed
+type Event = models.KVStashEvent
+
+// EventReset is delivered when a watch could not resume where it left off, e.g. after a server
+// restart or a disconnect longer than the server's retained history
+// Events between the last delivered event and the reset may have been missed, so consumers
+// caching data derived from earlier events should discard it
+const EventReset = "reset"
+
+// watchEndpoint is the path of the changefeed stream on the server
+const watchEndpoint = "/kvstash/watch"
+
+// watchStream is an open changefeed connection
+type watchStream struct {
+	// body is the streaming response body
+	body io.ReadCloser
+
+	// decoder reads newline-delimited events from body
+	decoder *json.Decoder
+
+	// epoch and seq are the position the stream started after
+	epoch string
+	seq   uint64
+
+	// normalization is the server's key normalization, "none" or "" (older servers) if keys are not normalized
+	normalization string
+}
+
+// decodeEventKey replaces the key of an event received from the server with the raw key
+func decodeEventKey(event *Event) error {
+	key, err := models.DecodeKey(event.Key, event.KeyEncoding)
+	if err != nil {
+		return fmt.Errorf("decodeEventKey: %w", err)
+	}
+	event.Key, event.KeyEncoding = key, ""
+
+	return nil
+}
+
+// Watch streams the changefeed events of every key starting with prefix
+// The first connection is established before Watch returns; afterwards, dropped connections are
+// re-established with exponential backoff, resuming after the last received event
+// If resuming is impossible, an event of type EventReset is delivered and watching continues from the current position
+// The returned channel is closed when ctx is done
+func (c *Client) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	stream, err := c.openWatch(ctx, prefix, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("Watch: %w", err)
+	}
+
+	events := make(chan Event, 64)
+	go c.runWatch(ctx, prefix, stream, events)
+
+	return events, nil
+}
+
+// runWatch forwards events from stream to out and reconnects whenever the stream ends
+func (c *Client) runWatch(ctx context.Context, prefix string, stream *watchStream, out chan<- Event) {
+	defer close(out)
+
+	epoch, seq := stream.epoch, stream.seq
+	for {
+		for {
+			var event Event
+			if err := stream.decoder.Decode(&event); err != nil {
+				break
+			}
+			if err := decodeEventKey(&event); err != nil {
+				break
+			}
+			epoch, seq = event.Epoch, event.Seq
+
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				stream.body.Close()
+				return
+			}
+		}
+		stream.body.Close()
+
+		for attempt := 1; ; attempt++ {
+			if ctx.Err() != nil {
+				return
+			}
+
+			var err error
+			stream, err = c.openWatch(ctx, prefix, epoch, seq)
+			if err == nil {
+				epoch, seq = stream.epoch, stream.seq
+				break
+			}
+
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusGone {
+				select {
+				case out <- Event{Type: EventReset}:
+				case <-ctx.Done():
+					return
+				}
+				epoch, seq = "", 0
+				continue
+			}
+
+			timer := time.NewTimer(c.retry.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// openWatch opens a changefeed stream for prefix, resuming after seq of epoch if epoch is set
+func (c *Client) openWatch(ctx context.Context, prefix string, epoch string, seq uint64) (*watchStream, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if epoch != "" {
+		query.Set("epoch", epoch)
+		query.Set("since", strconv.FormatUint(seq, 10))
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+watchEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("openWatch: failed to create request: %w", err)
+	}
+	c.authorize(httpReq)
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("openWatch: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+
+		var errResp models.KVStashResponse
+		_ = json.NewDecoder(httpResp.Body).Decode(&errResp)
+		return nil, &StatusError{StatusCode: httpResp.StatusCode, Message: errResp.Message}
+	}
+
+	startSeq, err := strconv.ParseUint(httpResp.Header.Get("X-KVStash-Seq"), 10, 64)
+	if err != nil {
+		httpResp.Body.Close()
+		return nil, fmt.Errorf("openWatch: invalid X-KVStash-Seq header: %w", err)
+	}
+
+	return &watchStream{
+		body:          httpResp.Body,
+		decoder:       json.NewDecoder(httpResp.Body),
+		epoch:         httpResp.Header.Get("X-KVStash-Epoch"),
+		seq:           startSeq,
+		normalization: httpResp.Header.Get("X-KVStash-Key-Normalization"),
+	}, nil
+}
@@ -0,0 +1,337 @@
+// Package main implements kvstash-admin, an offline maintenance tool for KVStash databases
+// It operates directly on a database directory and must only be used while the server is stopped
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/export"
+	"github.com/vi88i/kvstash/redact"
+	"github.com/vi88i/kvstash/store"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// command is a single kvstash-admin subcommand
+type command struct {
+	// usage describes the arguments and purpose of the command
+	usage string
+
+	// run executes the command with the remaining command line arguments
+	run func(args []string) error
+}
+
+// commands maps subcommand names to their implementation
+var commands = map[string]command{
+	"compact":            {"compact [-db dir]: rewrite the database keeping only live keys", runCompact},
+	"verify":             {"verify [-db dir]: validate metadata and value checksums of every record", runVerify},
+	"salvage":            {"salvage [-db dir] -out dir: copy every readable record into a new database", runSalvage},
+	"rebuild-index":      {"rebuild-index [-db dir]: rebuild the index as startup would and summarize it", runRebuildIndex},
+	"truncate-torn-tail": {"truncate-torn-tail [-db dir]: drop a partially written record from the active log", runTruncateTornTail},
+	"verify-backup":      {"verify-backup [-source dir] [-strict] <backup dir>: check that a backup is restorable", runVerifyBackup},
+	"verify-export":      {"verify-export <archive>: check the checksums and totals of an export archive", runVerifyExport},
+	"doctor":             {"doctor [-db dir]: check the environment for common problems", runDoctor},
+	"scan":               {"scan [-db dir] [-keys-only] [-limit n] <pattern>: print live keys matching a glob such as 'user:*:profile'", runScan},
+	"upgrade":            {"upgrade [-db dir] [-keep-backup] [-dry-run]: rewrite legacy segments in the current format", runUpgradeFormat},
+}
+
+// aliases maps alternative subcommand names to their canonical name
+var aliases = map[string]string{
+	"upgrade-format": "upgrade",
+}
+
+// commandOrder lists the subcommands in the order they are shown in the usage text
+var commandOrder = []string{"compact", "verify", "salvage", "rebuild-index", "truncate-torn-tail", "upgrade", "verify-backup", "verify-export", "doctor", "scan"}
+
+func main() {
+	// Store logs (shown with -v) name keys, so honor the server's privacy mode setting
+	redact.SetEnabled(os.Getenv("KVSTASH_REDACT_LOGS") == "1")
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if canonical, ok := aliases[name]; ok {
+		name = canonical
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the list of available subcommands
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kvstash-admin <command> [flags]")
+	fmt.Fprintln(os.Stderr, "The server must be stopped while running any command.")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %v\n", commands[name].usage)
+	}
+}
+
+// newFlagSet creates the flag set shared by all subcommands
+// Store logging is discarded unless -v is given
+func newFlagSet(name string) (*flag.FlagSet, *string, *bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	dbPath := fs.String("db", constants.DBPath, "database directory")
+	verbose := fs.Bool("v", false, "show store logs")
+	return fs, dbPath, verbose
+}
+
+// parseFlags parses args and applies the -v flag
+func parseFlags(fs *flag.FlagSet, verbose *bool, args []string) {
+	fs.Parse(args)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+}
+
+func runCompact(args []string) error {
+	fs, dbPath, verbose := newFlagSet("compact")
+	parseFlags(fs, verbose, args)
+
+	report, err := store.Compact(*dbPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("compacted %v: %d keys, %d -> %d bytes\n", *dbPath, report.Keys, report.BytesBefore, report.BytesAfter)
+	return nil
+}
+
+func runVerify(args []string) error {
+	fs, dbPath, verbose := newFlagSet("verify")
+	parseFlags(fs, verbose, args)
+
+	report, err := store.Verify(*dbPath)
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range report.Segments {
+		status := "ok"
+		if seg.Err != nil {
+			status = fmt.Sprintf("CORRUPTED after %d valid bytes: %v", seg.ValidEnd, seg.Err)
+		}
+		fmt.Printf("%-12v %10d bytes %6d records %6d tombstones  %v\n", seg.Name, seg.FileSize, seg.Records, seg.Tombstones, status)
+	}
+	fmt.Printf("%d live keys, %d deleted keys\n", report.LiveKeys, report.DeletedKeys)
+
+	if report.Corrupted() {
+		return fmt.Errorf("database is corrupted")
+	}
+	return nil
+}
+
+func runSalvage(args []string) error {
+	fs, dbPath, verbose := newFlagSet("salvage")
+	outPath := fs.String("out", "", "directory of the salvaged database (must not exist)")
+	parseFlags(fs, verbose, args)
+
+	if *outPath == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	report, err := store.Salvage(*dbPath, *outPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("salvaged %d records (%d bytes skipped), wrote %d live keys to %v\n",
+		report.Records, report.SkippedBytes, report.LiveKeys, *outPath)
+	return nil
+}
+
+func runRebuildIndex(args []string) error {
+	fs, dbPath, verbose := newFlagSet("rebuild-index")
+	parseFlags(fs, verbose, args)
+
+	report, err := store.RebuildIndex(*dbPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d segments, active log %v (%d records), %d live keys, %d deleted keys\n",
+		report.Segments, report.ActiveLog, report.ActiveLogCount, report.LiveKeys, report.DeletedKeys)
+	return nil
+}
+
+func runTruncateTornTail(args []string) error {
+	fs, dbPath, verbose := newFlagSet("truncate-torn-tail")
+	parseFlags(fs, verbose, args)
+
+	removed, err := store.TruncateTornTail(*dbPath)
+	if err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		fmt.Println("active log tail is intact")
+	} else {
+		fmt.Printf("removed %d bytes from the active log tail\n", removed)
+	}
+	return nil
+}
+
+func runUpgradeFormat(args []string) error {
+	fs, dbPath, verbose := newFlagSet("upgrade")
+	keepBackup := fs.Bool("keep-backup", false, "keep the original database next to the upgraded one")
+	dryRun := fs.Bool("dry-run", false, "only report the format of every segment")
+	parseFlags(fs, verbose, args)
+
+	formats, err := store.DetectFormat(*dbPath)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range formats.Order {
+		fmt.Printf("%-12v %v\n", segment, formats.Segments[segment])
+	}
+
+	if formats.Current() {
+		fmt.Printf("%v already uses the current format, nothing to upgrade\n", *dbPath)
+		return nil
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	report, err := store.UpgradeFormat(*dbPath, *keepBackup)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("upgraded %d segments (%d records verified)\n", report.UpgradedSegments, report.Records)
+	if report.BackupPath != "" {
+		fmt.Printf("original database kept at %v\n", report.BackupPath)
+	}
+	return nil
+}
+
+func runDoctor(args []string) error {
+	fs, dbPath, verbose := newFlagSet("doctor")
+	parseFlags(fs, verbose, args)
+
+	errorCount := 0
+	for _, f := range store.Diagnose(*dbPath) {
+		fmt.Printf("[%-5v] %-12v %v\n", f.Severity, f.Check, f.Message)
+		if f.Fix != "" {
+			fmt.Printf("        %-12v fix: %v\n", "", f.Fix)
+		}
+		if f.Severity == store.SeverityError {
+			errorCount++
+		}
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("%d problems found", errorCount)
+	}
+	return nil
+}
+
+func runVerifyExport(args []string) error {
+	fs := flag.NewFlagSet("verify-export", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one archive")
+	}
+
+	manifest, err := export.VerifyArchive(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%v: exported %v, %d keys, %d key bytes, %d value bytes, all checksums valid\n", fs.Arg(0),
+		manifest.Created.Format(time.RFC3339), manifest.Records, manifest.KeyBytes, manifest.ValueBytes)
+	fmt.Printf("types: %v\n", manifest.Types)
+	fmt.Printf("sha256: %v\n", manifest.SHA256)
+	return nil
+}
+
+func runVerifyBackup(args []string) error {
+	fs := flag.NewFlagSet("verify-backup", flag.ExitOnError)
+	sourcePath := fs.String("source", "", "database the backup was taken from, to compare keys against")
+	strict := fs.Bool("strict", false, "fail if the backup's keys differ from the source's")
+	verbose := fs.Bool("v", false, "show store logs")
+	parseFlags(fs, verbose, args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one backup directory")
+	}
+	backupPath := fs.Arg(0)
+
+	report, err := store.VerifyBackup(backupPath, *sourcePath)
+	if err != nil {
+		return err
+	}
+
+	records := 0
+	for _, seg := range report.Verify.Segments {
+		records += seg.Records
+	}
+	fmt.Printf("%v: %d segments, %d records, all checksums valid\n", backupPath, len(report.Verify.Segments), records)
+	if report.TornTail {
+		fmt.Println("active log ends in a partial record; it is discarded on restore")
+	}
+	fmt.Printf("index: %d live keys, %d deleted keys\n", report.Index.LiveKeys, report.Index.DeletedKeys)
+
+	if report.Source == nil {
+		return nil
+	}
+
+	fmt.Printf("source %v: %d live keys; %d missing from backup, %d only in backup\n",
+		*sourcePath, report.Source.LiveKeys, report.MissingKeys, report.ExtraKeys)
+	if *strict && (report.MissingKeys > 0 || report.ExtraKeys > 0) {
+		return fmt.Errorf("backup differs from source")
+	}
+	return nil
+}
+
+func runScan(args []string) error {
+	fs, dbPath, verbose := newFlagSet("scan")
+	keysOnly := fs.Bool("keys-only", false, "print keys without values")
+	limit := fs.Int("limit", 0, "stop after this many keys (0 means no limit)")
+	parseFlags(fs, verbose, args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one pattern argument")
+	}
+
+	// errLimit stops the scan once enough keys were printed
+	errLimit := errors.New("limit reached")
+	count := 0
+	err := store.ScanDir(*dbPath, fs.Arg(0), func(key string, value string) error {
+		if *keysOnly {
+			fmt.Println(key)
+		} else {
+			fmt.Printf("%v\t%v\n", key, value)
+		}
+		count++
+		if *limit > 0 && count >= *limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d keys\n", count)
+	return nil
+}
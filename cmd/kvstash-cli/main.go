@@ -0,0 +1,197 @@
+// Package main implements kvstash-cli, a command line tool for inspecting a running KVStash server
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/vi88i/kvstash/client"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// command is a single kvstash-cli subcommand
+type command struct {
+	// usage describes the arguments and purpose of the command
+	usage string
+
+	// run executes the command with the remaining command line arguments
+	run func(args []string) error
+}
+
+// commands maps subcommand names to their implementation
+var commands = map[string]command{
+	"top":    {"top [-addr url] [-interval d] [-n count]: live view of request rates, latencies, compaction, and disk usage", runTop},
+	"rename": {"rename [-addr url] [-replace] key new-key: move the value of key to new-key in one atomic write", runRename},
+}
+
+// commandOrder lists the subcommands in the order they are shown in the usage text
+var commandOrder = []string{"top", "rename"}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the list of available subcommands
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kvstash-cli <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %v\n", commands[name].usage)
+	}
+}
+
+// newFlagSet creates the flag set shared by all subcommands
+func newFlagSet(name string) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost:8080", "server address")
+	token := fs.String("token", os.Getenv("KVSTASH_TOKEN"), "bearer token for servers requiring authentication (env KVSTASH_TOKEN)")
+	return fs, addr, token
+}
+
+func runTop(args []string) error {
+	fs, addr, token := newFlagSet("top")
+	interval := fs.Duration("interval", time.Second, "refresh interval")
+	count := fs.Int("n", 0, "exit after this many refreshes (0 means run until interrupted)")
+	fs.Parse(args)
+
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+
+	c := client.New(*addr, &client.Options{Timeout: *interval, Retry: &client.NoRetry, Token: *token})
+	defer c.Close()
+
+	var prev *models.KVStashStats
+	var prevAt time.Time
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		stats, err := c.Stats(context.Background())
+		now := time.Now()
+
+		// Clear the screen and move the cursor home before each frame
+		fmt.Print("\033[H\033[2J")
+		if err != nil {
+			fmt.Printf("kvstash top - %v - %v\n", *addr, err)
+			prev = nil
+			continue
+		}
+
+		renderTop(os.Stdout, *addr, stats, prev, now.Sub(prevAt))
+		prev, prevAt = stats, now
+	}
+
+	return nil
+}
+
+func runRename(args []string) error {
+	fs, addr, token := newFlagSet("rename")
+	replace := fs.Bool("replace", false, "replace the value of new-key if it exists")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("expected a key and a new key, got %d arguments", fs.NArg())
+	}
+
+	c := client.New(*addr, &client.Options{Token: *token})
+	defer c.Close()
+
+	if err := c.Rename(context.Background(), fs.Arg(0), fs.Arg(1), *replace); err != nil {
+		return err
+	}
+	fmt.Printf("renamed %v to %v\n", fs.Arg(0), fs.Arg(1))
+	return nil
+}
+
+// renderTop writes one frame of the top view
+// prev is the previous sample (nil on the first frame), taken elapsed before stats, used to compute rates
+func renderTop(w io.Writer, addr string, stats *models.KVStashStats, prev *models.KVStashStats, elapsed time.Duration) {
+	uptime := time.Duration(stats.UptimeSeconds * float64(time.Second)).Round(time.Second)
+	fmt.Fprintf(w, "kvstash top - %v - up %v\n\n", addr, uptime)
+
+	ops := make([]string, 0, len(stats.Requests))
+	for op := range stats.Requests {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintf(w, "%-8v %10v %12v %8v %9v %9v %9v\n", "OP", "QPS", "TOTAL", "ERRORS", "P50 ms", "P95 ms", "P99 ms")
+	var totalQPS float64
+	for _, op := range ops {
+		cur := stats.Requests[op]
+
+		qps := "-"
+		if prev != nil && elapsed > 0 {
+			// A counter that went backwards means the server restarted between samples
+			if before := prev.Requests[op].Count; cur.Count >= before {
+				rate := float64(cur.Count-before) / elapsed.Seconds()
+				totalQPS += rate
+				qps = fmt.Sprintf("%.1f", rate)
+			}
+		}
+
+		fmt.Fprintf(w, "%-8v %10v %12d %8d %9.2f %9.2f %9.2f\n", op, qps, cur.Count, cur.Errors, cur.P50Ms, cur.P95Ms, cur.P99Ms)
+	}
+	if prev != nil {
+		fmt.Fprintf(w, "%-8v %10.1f\n", "total", totalQPS)
+	}
+
+	st := stats.Store
+	if br := stats.Breaker; br.Degraded {
+		fmt.Fprintf(w, "\nDEGRADED    writes disabled since %v after %d consecutive failures: %v\n",
+			br.DegradedSince, br.ConsecutiveFailures, br.LastError)
+	}
+
+	fmt.Fprintf(w, "\nSTORE       %d segments (active %v), %d live keys, %d deleted keys, %v on disk, %d open snapshots\n",
+		st.Segments, st.ActiveLog, st.LiveKeys, st.DeletedKeys, formatBytes(st.DiskBytes), st.OpenSnapshots)
+
+	cp := stats.Compaction
+	state := "idle"
+	if cp.Running {
+		state = "RUNNING"
+	}
+	fmt.Fprintf(w, "COMPACTION  %v, %d runs, %d failures, %d skipped\n", state, cp.Runs, cp.Failures, cp.Skipped)
+	if cp.LastStart != "" {
+		fmt.Fprintf(w, "            last started %v, took %.0f ms", cp.LastStart, cp.LastDurationMs)
+		if cp.Runs > 0 {
+			fmt.Fprintf(w, ", %v -> %v", formatBytes(cp.LastBytesBefore), formatBytes(cp.LastBytesAfter))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// formatBytes renders a byte count with a binary unit suffix
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,624 @@
+// Package main is the entry point for the KVStash server application
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/vi88i/kvstash/alert"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/auth"
+	"github.com/vi88i/kvstash/config"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/export"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/logrotate"
+	"github.com/vi88i/kvstash/mirror"
+	"github.com/vi88i/kvstash/redact"
+	"github.com/vi88i/kvstash/store"
+	"github.com/vi88i/kvstash/svc"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// main initializes the store and starts the HTTP server
+// When invoked as `kvstash export -sqlite <file>` or `kvstash export -archive <file>` it exports the database instead
+// and exits, and as `kvstash import -archive <file>` it restores an export archive into the database and exits
+func main() {
+	os.Exit(run())
+}
+
+// run does the work of main and returns the exit status, once the deferred cleanups closed the store and the logs
+func run() int {
+	dbPath := flag.String("db-path", cmp.Or(os.Getenv("KVSTASH_DB_PATH"), constants.DBPath),
+		"database directory (env KVSTASH_DB_PATH)")
+	port := flag.Int("port", envInt("KVSTASH_PORT", constants.Port), "port the HTTP server listens on (env KVSTASH_PORT)")
+	compactionInterval := flag.Duration("compaction-interval",
+		envDuration("KVSTASH_COMPACTION_INTERVAL", constants.CompactionInterval*time.Second),
+		"delay between automatic compactions, overridden by compaction_interval of -config (env KVSTASH_COMPACTION_INTERVAL)")
+	maxValueSize := flag.Int("max-value-size", envInt("KVSTASH_MAX_VALUE_SIZE", constants.MaxValueSize),
+		fmt.Sprintf("largest value in bytes writes accept, at most %d (env KVSTASH_MAX_VALUE_SIZE)", constants.MaxValueSize))
+	redactLogs := flag.Bool("redact-logs", os.Getenv("KVSTASH_REDACT_LOGS") == "1",
+		"never print raw keys or values in logs and error messages (env KVSTASH_REDACT_LOGS=1)")
+	logLevel := flag.String("log-level", cmp.Or(os.Getenv("KVSTASH_LOG_LEVEL"), logging.LevelInfo),
+		"lowest level logged: debug (every key written), info, warn, or error; overridden by log_level of -config "+
+			"(env KVSTASH_LOG_LEVEL)")
+	logFormat := flag.String("log-format", cmp.Or(os.Getenv("KVSTASH_LOG_FORMAT"), logging.FormatText),
+		"log output format: text (key=value pairs) or json (env KVSTASH_LOG_FORMAT)")
+	logFile := flag.String("log-file", "", "write logs to this file instead of stderr")
+	logMaxSizeMB := flag.Int64("log-max-size-mb", 100, "rotate the log file when it exceeds this size in MiB (0 disables)")
+	logRotateEvery := flag.Duration("log-rotate-every", 24*time.Hour, "rotate the log file at least this often (0 disables)")
+	logMaxBackups := flag.Int("log-max-backups", 7, "number of rotated log files to keep (0 keeps all)")
+	logMaxAge := flag.Duration("log-max-age", 0, "remove rotated log files older than this (0 disables)")
+	auditFile := flag.String("audit-log", "", "append an audit trail of writes, deletes, and admin actions to this file "+
+		"(rotated like -log-file)")
+	deletionHistory := flag.String("deletion-history", "", "append the tombstones compaction and segment retention "+
+		"drop to this file, recording when each key was deleted and by whom")
+	requestTimeout := flag.Duration("request-timeout", constants.RequestTimeout*time.Second,
+		"answer key-value requests that take longer than this with 504 (0 disables)")
+	slowLogThreshold := flag.Duration("slowlog-threshold", constants.SlowLogThreshold*time.Millisecond,
+		"record requests slower than this in the slow query log (0 records every request)")
+	minFreeDiskMB := flag.Int64("min-free-disk-mb", constants.MinFreeDiskBytes>>20,
+		"refuse writes and skip compaction while less than this many MiB are free on the database volume (0 disables)")
+	keyNormalization := flag.String("key-normalization", os.Getenv("KVSTASH_KEY_NORMALIZATION"),
+		"normalize keys before storing or looking them up: a comma separated list of trim, fold, and nfc, or none "+
+			"(env KVSTASH_KEY_NORMALIZATION; must stay the same for a database)")
+	startupCheck := flag.String("startup-check", cmp.Or(os.Getenv("KVSTASH_STARTUP_CHECK"), string(store.StartupCheckFast)),
+		"how much of every record to verify while loading the database: fast checks metadata checksums, "+
+			"full also checks value checksums, none only checks that records fit in their files (env KVSTASH_STARTUP_CHECK)")
+	indexSnapshotInterval := flag.Duration("index-snapshot-interval",
+		envDuration("KVSTASH_INDEX_SNAPSHOT_INTERVAL", constants.IndexSnapshotInterval*time.Second),
+		"write a snapshot of the index this often and on shutdown, so startup only reads the records written after "+
+			"the last one (0 disables; env KVSTASH_INDEX_SNAPSHOT_INTERVAL)")
+	startupRepair := flag.String("startup-repair", cmp.Or(os.Getenv("KVSTASH_STARTUP_REPAIR"), string(store.StartupRepairFail)),
+		"what to do with a corrupt sealed segment at startup: fail refuses to start, skip-segment moves the segment to "+
+			constants.QuarantineDBPath+" and loads the rest, salvage recovers every readable record into a fresh "+
+			"database (env KVSTASH_STARTUP_REPAIR)")
+	maxInFlight := flag.Int("max-inflight", constants.MaxInFlightRequests,
+		"serve at most this many key-value requests concurrently, queueing the rest (0 disables the limit)")
+	maxQueued := flag.Int("max-queued", constants.MaxQueuedRequests,
+		"answer key-value requests with 503 while this many are already waiting for -max-inflight")
+	warmupRecent := flag.Int("warmup-recent", 0, "read the values of this many most recently written keys at startup, "+
+		"before serving requests, so the first reads don't hit a cold disk")
+	warmupKeys := flag.String("warmup-keys", "", "file listing keys, one per line, whose values are read at startup like -warmup-recent")
+	alertWebhooks := flag.String("alert-webhooks", "", "comma separated URLs to post critical events to, such as a corrupt record, "+
+		"a failed compaction, a full disk, or a tripped write breaker")
+	authKeysFile := flag.String("auth-keys-file", "", "require requests to present one of the API keys listed in this file, "+
+		"one \"<subject> <key>\" pair per line")
+	authHMACFile := flag.String("auth-hmac-secrets-file", "", "accept tokens signed with one of the secrets listed in this file, one per line")
+	jwtIssuer := flag.String("auth-jwt-issuer", "", "accept JWTs of this issuer (requires -auth-jwt-audience and -auth-jwt-jwks-url)")
+	jwtAudience := flag.String("auth-jwt-audience", "", "audience JWTs must be issued for")
+	jwtJWKSURL := flag.String("auth-jwt-jwks-url", "", "URL of the JWKS holding the issuer's signing keys")
+	mirrorURL := flag.String("mirror-url", "", "asynchronously replay a sample of writes against the KVStash server at this "+
+		"base URL, counting the answers that differ from this server's")
+	mirrorPercent := flag.Float64("mirror-percent", 100, "percentage of writes replayed with -mirror-url")
+	mirrorToken := flag.String("mirror-token", os.Getenv("KVSTASH_MIRROR_TOKEN"),
+		"bearer token sent to the -mirror-url server (env KVSTASH_MIRROR_TOKEN)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", constants.ShutdownTimeout*time.Second,
+		"on SIGINT or SIGTERM, wait this long for the requests in flight before closing the database")
+	debugAddr := flag.String("debug-addr", os.Getenv("KVSTASH_DEBUG_ADDR"), "serve net/http/pprof and a dump of the "+
+		"store internals on this address, e.g. localhost:6060; keep it off public interfaces, it has no authentication "+
+		"(env KVSTASH_DEBUG_ADDR)")
+	configPath := flag.String("config", "", "JSON configuration file with hot-tunable settings, reloaded on SIGHUP")
+	flag.Parse()
+	if err := logging.Setup(os.Stderr, *logFormat); err != nil {
+		logging.Fatalf("Invalid -log-format: %v", err)
+	}
+	if err := logging.SetLevel(*logLevel); err != nil {
+		logging.Fatalf("Invalid -log-level: %v", err)
+	}
+	redact.SetEnabled(*redactLogs)
+	svc.SetRequestTimeout(*requestTimeout)
+	svc.SetSlowLogThreshold(*slowLogThreshold)
+	if *maxInFlight < 0 || *maxQueued < 0 {
+		logging.Fatalf("Invalid -max-inflight or -max-queued: must not be negative")
+	}
+	svc.SetConcurrencyLimit(*maxInFlight, *maxQueued)
+
+	var providers []auth.Provider
+	if *authKeysFile != "" {
+		p, err := auth.LoadStaticKeys(*authKeysFile)
+		if err != nil {
+			logging.Fatalf("Invalid -auth-keys-file: %v", err)
+		}
+		providers = append(providers, p)
+	}
+	if *authHMACFile != "" {
+		p, err := auth.LoadHMAC(*authHMACFile)
+		if err != nil {
+			logging.Fatalf("Invalid -auth-hmac-secrets-file: %v", err)
+		}
+		providers = append(providers, p)
+	}
+	if *jwtIssuer != "" || *jwtAudience != "" || *jwtJWKSURL != "" {
+		p, err := auth.NewJWT(auth.JWTOptions{Issuer: *jwtIssuer, Audience: *jwtAudience, JWKSURL: *jwtJWKSURL})
+		if err != nil {
+			logging.Fatalf("Invalid -auth-jwt flags: %v", err)
+		}
+		providers = append(providers, p)
+	}
+	if len(providers) > 0 {
+		authenticator := auth.New(providers...)
+		logging.Infof("Requests must authenticate with %v", strings.Join(authenticator.Providers(), ", "))
+		svc.SetAuthenticator(authenticator)
+	}
+
+	// Apply the logging and startup settings before the index build logs anything; the rest needs the store
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			logging.Fatalf("Failed to load configuration: %v", err)
+		}
+		applyLogging(cfg)
+		if cfg.Startup != nil {
+			applyStartup(cfg.Startup)
+		}
+	}
+
+	rotation := logrotate.Options{
+		MaxSize:     *logMaxSizeMB << 20,
+		RotateEvery: *logRotateEvery,
+		MaxBackups:  *logMaxBackups,
+		MaxAge:      *logMaxAge,
+	}
+	if *logFile != "" {
+		w, err := logrotate.Open(*logFile, rotation)
+		if err != nil {
+			logging.Fatalf("Failed to open log file: %v", err)
+		}
+		defer w.Close()
+		logging.Setup(w, *logFormat)
+	}
+
+	if *auditFile != "" {
+		w, err := logrotate.Open(*auditFile, rotation)
+		if err != nil {
+			logging.Errorf("Failed to open audit log: %v", err)
+			return 1
+		}
+		defer w.Close()
+		svc.SetAuditLog(audit.New(w))
+	}
+
+	var webhooks []string
+	if *alertWebhooks != "" {
+		webhooks = strings.Split(*alertWebhooks, ",")
+	}
+	notifier, err := alert.New(webhooks)
+	if err != nil {
+		logging.Errorf("Invalid -alert-webhooks: %v", err)
+		return 1
+	}
+	defer notifier.Close(constants.AlertTimeout * time.Second)
+	svc.SetAlertNotifier(notifier)
+
+	if *mirrorURL != "" {
+		m, err := mirror.New(mirror.Options{Target: *mirrorURL, Percent: *mirrorPercent, Token: *mirrorToken})
+		if err != nil {
+			logging.Errorf("Invalid -mirror-url or -mirror-percent: %v", err)
+			return 1
+		}
+		defer m.Close(constants.MirrorTimeout * time.Second)
+		svc.SetMirror(m)
+	}
+
+	normalization, err := store.ParseKeyNormalization(*keyNormalization)
+	if err != nil {
+		logging.Errorf("Invalid -key-normalization: %v", err)
+		return 1
+	}
+	check, err := store.ParseStartupCheck(*startupCheck)
+	if err != nil {
+		logging.Errorf("Invalid -startup-check: %v", err)
+		return 1
+	}
+	repair, err := store.ParseStartupRepair(*startupRepair)
+	if err != nil {
+		logging.Errorf("Invalid -startup-repair: %v", err)
+		return 1
+	}
+
+	// Initialize the store
+	if *port <= 0 || *port > 65535 {
+		logging.Errorf("Invalid -port: %d", *port)
+		return 1
+	}
+	if *compactionInterval <= 0 {
+		logging.Errorf("Invalid -compaction-interval: must be positive")
+		return 1
+	}
+	if *indexSnapshotInterval < 0 {
+		logging.Errorf("Invalid -index-snapshot-interval: must not be negative")
+		return 1
+	}
+	kvStore, err := store.NewStore(store.ServerConfig{
+		DBPath:                *dbPath,
+		CompactionInterval:    *compactionInterval,
+		MaxValueSize:          *maxValueSize,
+		KeyNormalization:      normalization,
+		StartupCheck:          check,
+		StartupRepair:         repair,
+		IndexSnapshotInterval: *indexSnapshotInterval,
+	})
+	if err != nil {
+		logging.Errorf("Failed to initialize store: %v", err)
+		return 1
+	}
+	defer func() {
+		if err := kvStore.Close(); err != nil {
+			logging.Errorf("Failed to close store: %v", err)
+		}
+	}()
+	kvStore.SetAlertHandler(notifier.Send)
+	kvStore.SetDeletionHistory(*deletionHistory)
+
+	if err := kvStore.SetMinFreeBytes(*minFreeDiskMB << 20); err != nil {
+		logging.Errorf("Invalid -min-free-disk-mb: %v", err)
+		return 1
+	}
+
+	if args := flag.Args(); len(args) > 0 && args[0] == "export" {
+		// -h prints the usage of the subcommand, which is not a failure
+		if err := runExport(kvStore, args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			logging.Errorf("export: %v", err)
+			return 1
+		}
+		return 0
+	}
+	if args := flag.Args(); len(args) > 0 && args[0] == "import" {
+		// -h prints the usage of the subcommand, which is not a failure
+		if err := runImport(kvStore, args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			logging.Errorf("import: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	if *configPath != "" {
+		reloader := config.NewReloader(*configPath, func(cfg *config.Config) error {
+			return applyConfig(kvStore, notifier, cfg)
+		})
+		if _, err := reloader.Reload(); err != nil {
+			logging.Errorf("Failed to apply configuration: %v", err)
+			return 1
+		}
+		svc.SetConfigReloader(reloader)
+		go reloadOnSIGHUP(reloader)
+	}
+
+	if *warmupRecent > 0 || *warmupKeys != "" {
+		opts := store.WarmupOptions{Recent: *warmupRecent}
+		if *warmupKeys != "" {
+			data, err := os.ReadFile(*warmupKeys)
+			if err != nil {
+				logging.Errorf("Failed to read -warmup-keys: %v", err)
+				return 1
+			}
+			for _, key := range strings.Split(string(data), "\n") {
+				if key = strings.TrimSuffix(key, "\r"); key != "" {
+					opts.Keys = append(opts.Keys, key)
+				}
+			}
+		}
+		kvStore.Warmup(opts)
+	}
+
+	// Push metrics to an OpenTelemetry collector if the OTEL environment variables ask for it
+	shutdownOTel, err := svc.StartOTelExporter(kvStore)
+	if err != nil {
+		logging.Errorf("Failed to start OpenTelemetry exporter: %v", err)
+		return 1
+	}
+	defer shutdownOTel(context.Background())
+
+	// Serve until SIGINT or SIGTERM; a second signal during the shutdown exits right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Start the HTTP server, then close the store and the logs through the deferred calls
+	if err := svc.StartHTTPServer(ctx, kvStore, svc.ServerConfig{
+		Port:            *port,
+		ShutdownTimeout: *shutdownTimeout,
+		DebugAddr:       *debugAddr,
+	}); err != nil {
+		logging.Errorf("HTTP server stopped: %v", err)
+		return 1
+	}
+	logging.Infof("Shutting down")
+	return 0
+}
+
+// envInt returns the integer in the environment variable name, or def if it is not set
+// Exits if the variable is set but not an integer
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		logging.Fatalf("Invalid %v: %v", name, err)
+	}
+	return n
+}
+
+// envDuration returns the duration in the environment variable name, such as "90s", or def if it is not set
+// Exits if the variable is set but not a duration
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logging.Fatalf("Invalid %v: %v", name, err)
+	}
+	return d
+}
+
+// applyLogging applies the logging settings of cfg
+func applyLogging(cfg *config.Config) {
+	if cfg.LogLevel != "" {
+		logging.SetLevel(cfg.LogLevel)
+	}
+	if cfg.RedactLogs != nil {
+		redact.SetEnabled(*cfg.RedactLogs)
+	}
+}
+
+// applyStartup applies the startup section of the configuration file to the flags given neither on the command line
+// nor through their environment variable
+func applyStartup(s *config.Startup) {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	number := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	settings := []struct {
+		flag  string
+		env   string
+		value string
+	}{
+		{"db-path", "KVSTASH_DB_PATH", s.DBPath},
+		{"port", "KVSTASH_PORT", number(s.Port)},
+		{"max-value-size", "KVSTASH_MAX_VALUE_SIZE", number(s.MaxValueSize)},
+		{"key-normalization", "KVSTASH_KEY_NORMALIZATION", s.KeyNormalization},
+		{"startup-check", "KVSTASH_STARTUP_CHECK", s.StartupCheck},
+		{"startup-repair", "KVSTASH_STARTUP_REPAIR", s.StartupRepair},
+	}
+
+	for _, setting := range settings {
+		if setting.value == "" || given[setting.flag] || os.Getenv(setting.env) != "" {
+			continue
+		}
+		if err := flag.Set(setting.flag, setting.value); err != nil {
+			logging.Fatalf("Invalid startup.%v: %v", strings.ReplaceAll(setting.flag, "-", "_"), err)
+		}
+	}
+}
+
+// applyConfig puts every setting of a validated configuration into effect
+func applyConfig(kvStore *store.Store, notifier *alert.Notifier, cfg *config.Config) error {
+	if cfg.CompactionInterval > 0 {
+		if err := kvStore.SetCompactionInterval(time.Duration(cfg.CompactionInterval)); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.CompactionPauseWindows != nil {
+		windows := make([]store.PauseWindow, 0, len(cfg.CompactionPauseWindows))
+		for i := range cfg.CompactionPauseWindows {
+			w, err := cfg.CompactionPauseWindows[i].StorePauseWindow()
+			if err != nil {
+				return fmt.Errorf("applyConfig: %w", err)
+			}
+			windows = append(windows, w)
+		}
+		if err := kvStore.SetCompactionPauseWindows(windows); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.CompactionWindows != nil {
+		windows := make([]store.PauseWindow, 0, len(cfg.CompactionWindows))
+		for i := range cfg.CompactionWindows {
+			w, err := cfg.CompactionWindows[i].StorePauseWindow()
+			if err != nil {
+				return fmt.Errorf("applyConfig: %w", err)
+			}
+			windows = append(windows, w)
+		}
+		if err := kvStore.SetCompactionWindows(windows); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.CompactionMinGarbageRatio != nil {
+		if err := kvStore.SetCompactionMinGarbage(*cfg.CompactionMinGarbageRatio); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.Durability != "" {
+		if err := kvStore.SetDurability(store.Durability(cfg.Durability)); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.SegmentMinKeys != nil || cfg.SegmentMaxKeys != nil {
+		sizing := kvStore.SegmentSizing()
+		if cfg.SegmentMinKeys != nil {
+			sizing.MinKeys = *cfg.SegmentMinKeys
+		}
+		if cfg.SegmentMaxKeys != nil {
+			sizing.MaxKeys = *cfg.SegmentMaxKeys
+		}
+		if err := kvStore.SetSegmentSizing(sizing); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.ValueTransformers != nil {
+		if err := kvStore.SetTransformers(*cfg.ValueTransformers); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.InlineValueBytes != nil {
+		if err := kvStore.SetInlineThreshold(*cfg.InlineValueBytes); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.ReadCacheBytes != nil {
+		if err := kvStore.SetReadCacheBytes(*cfg.ReadCacheBytes); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.SegmentRetention != nil {
+		if err := kvStore.SetRetention(time.Duration(*cfg.SegmentRetention)); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.DeletionHistoryRetention != nil {
+		if err := kvStore.SetDeletionHistoryRetention(time.Duration(*cfg.DeletionHistoryRetention)); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.IndexCheckInterval != nil || cfg.IndexCheckSample != nil || cfg.IndexCheckMaxDivergence != nil {
+		check := kvStore.IndexCheck()
+		if cfg.IndexCheckInterval != nil {
+			check.Interval = time.Duration(*cfg.IndexCheckInterval)
+		}
+		if cfg.IndexCheckSample != nil {
+			check.Sample = *cfg.IndexCheckSample
+		}
+		if cfg.IndexCheckMaxDivergence != nil {
+			check.MaxDivergence = *cfg.IndexCheckMaxDivergence
+		}
+		if err := kvStore.SetIndexCheck(check); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.ReadVerification != "" {
+		if err := kvStore.SetVerification(store.Verification(cfg.ReadVerification)); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.Namespaces != nil {
+		namespaces := make([]store.Namespace, 0, len(cfg.Namespaces))
+		for i := range cfg.Namespaces {
+			namespaces = append(namespaces, cfg.Namespaces[i].StoreNamespace())
+		}
+		if err := kvStore.SetNamespaces(namespaces); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.MinFreeDiskMB != nil {
+		if err := kvStore.SetMinFreeBytes(*cfg.MinFreeDiskMB << 20); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.SoftLimitRatio != nil {
+		if err := kvStore.SetSoftLimit(*cfg.SoftLimitRatio); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.AlertWebhooks != nil {
+		if err := notifier.SetURLs(cfg.AlertWebhooks); err != nil {
+			return fmt.Errorf("applyConfig: %w", err)
+		}
+	}
+	if cfg.RequestTimeout > 0 {
+		svc.SetRequestTimeout(time.Duration(cfg.RequestTimeout))
+	}
+	if cfg.SlowLogThreshold > 0 {
+		svc.SetSlowLogThreshold(time.Duration(cfg.SlowLogThreshold))
+	}
+	if cfg.MaxInFlight != nil || cfg.MaxQueued != nil {
+		maxInFlight, maxQueued := svc.ConcurrencyLimit()
+		if cfg.MaxInFlight != nil {
+			maxInFlight = *cfg.MaxInFlight
+		}
+		if cfg.MaxQueued != nil {
+			maxQueued = *cfg.MaxQueued
+		}
+		svc.SetConcurrencyLimit(maxInFlight, maxQueued)
+	}
+	applyLogging(cfg)
+
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration file every time the process receives SIGHUP
+// An invalid file is logged and the previous configuration stays in effect
+func reloadOnSIGHUP(reloader *config.Reloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if _, err := reloader.Reload(); err != nil {
+			logging.Warnf("reloadOnSIGHUP: %v", err)
+			continue
+		}
+		logging.Infof("reloadOnSIGHUP: reloaded %v", reloader.Path())
+	}
+}
+
+// runExport parses the export subcommand flags and writes the store contents to the requested output
+// Returns an error instead of exiting, so the store is closed on the way out
+func runExport(kvStore *store.Store, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	sqlitePath := fs.String("sqlite", "", "path of the SQLite file to create")
+	archivePath := fs.String("archive", "", "path of the export archive to create, see kvstash import")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if (*sqlitePath == "") == (*archivePath == "") {
+		return errors.New("exactly one of -sqlite <file> and -archive <file> is required")
+	}
+
+	if *archivePath != "" {
+		manifest, err := export.ToArchive(kvStore, *archivePath)
+		if err != nil {
+			return err
+		}
+		logging.Infof("export: wrote %d keys to %v (sha256 %v)", manifest.Records, *archivePath, manifest.SHA256)
+		return nil
+	}
+
+	count, err := export.ToSQLite(kvStore, *sqlitePath)
+	if err != nil {
+		return err
+	}
+	logging.Infof("export: wrote %d keys to %v", count, *sqlitePath)
+	return nil
+}
+
+// runImport parses the import subcommand flags and restores an export archive into the store
+// The archive is verified in full before the first key is written; on an error the keys already written stay and
+// are synced when the store is closed
+func runImport(kvStore *store.Store, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	archivePath := fs.String("archive", "", "path of the export archive to restore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *archivePath == "" {
+		return errors.New("-archive <file> is required")
+	}
+
+	manifest, imported, err := export.FromArchive(kvStore, *archivePath)
+	if err != nil {
+		return fmt.Errorf("%w (%d keys written)", err, imported)
+	}
+	logging.Infof("import: wrote %d of the %d keys of %v, skipping %d expired", imported, manifest.Records, *archivePath, manifest.Records-imported)
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package codec implements the on-disk encoding of KVStash segment files
+//
+// A segment file is a sequence of records, each made of a fixed-size metadata entry followed by
+// a payload holding the key and value (see EncodePayload):
+//
+//	[metadata (MetadataSize bytes)][payload (Metadata.Size bytes)]
+//
+// The package has no dependencies on the store and no side effects, so external tools can parse
+// segment files with it directly. Decoding functions never panic on malformed input and the
+// Decode* and checksum functions do not allocate, which makes them suitable for fuzzing
+package codec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+)
+
+// MetadataSize is the size in bytes of an encoded metadata entry
+const MetadataSize = constants.MetadataSize
+
+// SegmentNameSize is the size in bytes of the zero-padded segment file name stored in metadata
+const SegmentNameSize = 32
+
+// Errors returned when decoding or validating records
+var (
+	// ErrShortBuffer indicates that a buffer is too small to hold an encoded metadata entry
+	ErrShortBuffer = errors.New("buffer too short")
+
+	// ErrMetadataCorrupted indicates that a metadata entry does not match its metadata checksum
+	ErrMetadataCorrupted = errors.New("metadata corrupted")
+
+	// ErrChecksumMismatch indicates that a payload does not match its value checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch: data corrupted")
+
+	// ErrTruncated indicates that a record extends past the end of the available data
+	// This is the signature of a torn write at the tail of the active log
+	ErrTruncated = errors.New("truncated record")
+
+	// ErrBadOffset indicates that a metadata entry points outside its own record
+	ErrBadOffset = errors.New("metadata points outside its record")
+)
+
+// Metadata is the decoded form of a metadata entry
+// It contains information needed to locate and validate stored values
+type Metadata struct {
+	// Offset is the byte position in the file where the value data starts
+	Offset int64
+
+	// Size is the length in bytes of the value data
+	Size int64
+
+	// Flags is a bit set of record flags, see constants.FlagDeleted
+	Flags int64
+
+	// SegmentFile is the name of the log file (fixed 32-byte array)
+	SegmentFile [SegmentNameSize]byte
+
+	// Checksum is the SHA-256 hash of the value data for integrity verification
+	Checksum [32]byte
+
+	// MChecksum is the SHA-256 hash of the metadata itself for integrity verification
+	MChecksum [32]byte
+}
+
+// SegmentName converts a segment file name to the fixed-size array stored in metadata
+// Shorter names are zero-padded on the right
+// Returns an error if the name exceeds SegmentNameSize bytes
+func SegmentName(name string) ([SegmentNameSize]byte, error) {
+	var out [SegmentNameSize]byte
+
+	if len(name) > SegmentNameSize {
+		return out, fmt.Errorf("SegmentName: name too large")
+	}
+
+	copy(out[:], name)
+	return out, nil
+}
+
+// ValueChecksum computes the value checksum of a record
+// It is SHA-256(offset || size || flags || segment || data), with integers in BigEndian
+func ValueChecksum(offset int64, size int64, flags int64, segment [SegmentNameSize]byte, data []byte) [32]byte {
+	var header [24 + SegmentNameSize]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(header[8:16], uint64(size))
+	binary.BigEndian.PutUint64(header[16:24], uint64(flags))
+	copy(header[24:], segment[:])
+
+	h := sha256.New()
+	h.Write(header[:])
+	h.Write(data)
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// MetadataChecksum computes the metadata checksum of a record
+// It is SHA-256(offset || size || flags || segment || valueChecksum), with integers in BigEndian
+func MetadataChecksum(offset int64, size int64, flags int64, segment [SegmentNameSize]byte, valueChecksum [32]byte) [32]byte {
+	var buf [24 + SegmentNameSize + 32]byte
+	binary.BigEndian.PutUint64(buf[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(size))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(flags))
+	copy(buf[24:56], segment[:])
+	copy(buf[56:88], valueChecksum[:])
+
+	return sha256.Sum256(buf[:])
+}
+
+// ComputeChecksum calculates and sets both the value checksum and metadata checksum
+// along with the offset, size, flags, and segment file fields
+func (m *Metadata) ComputeChecksum(offset int64, size int64, flags int64, fileName string, data []byte) error {
+	segment, err := SegmentName(fileName)
+	if err != nil {
+		return fmt.Errorf("ComputeChecksum: %w", err)
+	}
+
+	m.Offset = offset
+	m.Size = size
+	m.Flags = flags
+	m.SegmentFile = segment
+	m.Checksum = ValueChecksum(offset, size, flags, segment, data)
+	m.MChecksum = MetadataChecksum(offset, size, flags, segment, m.Checksum)
+	return nil
+}
+
+// ValidateMChecksum verifies the integrity of the metadata by recomputing its checksum
+// Returns ErrMetadataCorrupted if the computed checksum does not match the stored MChecksum
+func (m *Metadata) ValidateMChecksum() error {
+	if MetadataChecksum(m.Offset, m.Size, m.Flags, m.SegmentFile, m.Checksum) != m.MChecksum {
+		return fmt.Errorf("ValidateMChecksum: %w", ErrMetadataCorrupted)
+	}
+
+	return nil
+}
+
+// ValidateChecksum verifies data against the value checksum
+// segment is the name of the file the record was read from, which is part of the checksum
+// Returns ErrChecksumMismatch if they don't match
+func (m *Metadata) ValidateChecksum(segment string, data []byte) error {
+	name, err := SegmentName(segment)
+	if err != nil {
+		return fmt.Errorf("ValidateChecksum: %w", err)
+	}
+
+	if ValueChecksum(m.Offset, m.Size, m.Flags, name, data) != m.Checksum {
+		return fmt.Errorf("ValidateChecksum: %w", ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+// Segment returns the segment file name stored in the metadata, without its zero padding
+func (m *Metadata) Segment() string {
+	n := bytes.IndexByte(m.SegmentFile[:], 0)
+	if n < 0 {
+		n = SegmentNameSize
+	}
+	return string(m.SegmentFile[:n])
+}
+
+// GetMetadataFlagValue reports whether the flag bit is set
+func (m *Metadata) GetMetadataFlagValue(flag int64) bool {
+	return ((1 << flag) & m.Flags) > 0
+}
+
+// ComputeMetadataFlag combines flag bit indexes into a flags value
+func ComputeMetadataFlag(flags []int64) int64 {
+	value := int64(0)
+
+	for i := range flags {
+		value = (value | 1<<flags[i])
+	}
+
+	return value
+}
+
+// EncodeMetadata writes the metadata into the first MetadataSize bytes of dst
+// The layout is:
+//   - Bytes 0-7: Offset (8 bytes, BigEndian uint64)
+//   - Bytes 8-15: Size (8 bytes, BigEndian uint64)
+//   - Bytes 16-23: Flags (8 bytes, BigEndian uint64)
+//   - Bytes 24-55: SegmentFile (32 bytes)
+//   - Bytes 56-87: Checksum (32 bytes)
+//   - Bytes 88-119: MChecksum (32 bytes)
+//
+// Returns ErrShortBuffer if dst is smaller than MetadataSize
+func EncodeMetadata(dst []byte, m *Metadata) error {
+	if len(dst) < MetadataSize {
+		return fmt.Errorf("EncodeMetadata: %w", ErrShortBuffer)
+	}
+
+	binary.BigEndian.PutUint64(dst[0:8], uint64(m.Offset))
+	binary.BigEndian.PutUint64(dst[8:16], uint64(m.Size))
+	binary.BigEndian.PutUint64(dst[16:24], uint64(m.Flags))
+
+	copy(dst[24:56], m.SegmentFile[:])
+	copy(dst[56:88], m.Checksum[:])
+	copy(dst[88:120], m.MChecksum[:])
+
+	return nil
+}
+
+// AppendMetadata appends the encoded metadata to dst and returns the extended slice
+func AppendMetadata(dst []byte, m *Metadata) []byte {
+	n := len(dst)
+	dst = append(dst, make([]byte, MetadataSize)...)
+	EncodeMetadata(dst[n:], m)
+	return dst
+}
+
+// DecodeMetadata populates m from the first MetadataSize bytes of src
+// The checksum is not validated; use ValidateMChecksum for that
+// Returns ErrShortBuffer if src is smaller than MetadataSize
+func DecodeMetadata(src []byte, m *Metadata) error {
+	if len(src) < MetadataSize {
+		return fmt.Errorf("DecodeMetadata: %w", ErrShortBuffer)
+	}
+
+	m.Offset = int64(binary.BigEndian.Uint64(src[0:8]))
+	m.Size = int64(binary.BigEndian.Uint64(src[8:16]))
+	m.Flags = int64(binary.BigEndian.Uint64(src[16:24]))
+
+	copy(m.SegmentFile[:], src[24:56])
+	copy(m.Checksum[:], src[56:88])
+	copy(m.MChecksum[:], src[88:120])
+
+	return nil
+}
+
+// Serialize converts the metadata to a MetadataSize byte array for storage, see EncodeMetadata
+func (m *Metadata) Serialize() []byte {
+	out := make([]byte, MetadataSize)
+	EncodeMetadata(out, m)
+	return out
+}
+
+// Deserialize populates the metadata fields from exactly MetadataSize bytes, see DecodeMetadata
+// Returns an error if the input data is not the correct size
+func (m *Metadata) Deserialize(data []byte) error {
+	if len(data) != MetadataSize {
+		return fmt.Errorf("Deserialize: data does not conform size")
+	}
+
+	return DecodeMetadata(data, m)
+}
@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+)
+
+// LegacyMetadataSize is the size in bytes of a metadata entry written before the flags field existed
+const LegacyMetadataSize = constants.LegacyMetadataSize
+
+// LegacyValueChecksum computes the value checksum of a legacy record
+// It is SHA-256(offset || size || segment || data), with integers in BigEndian
+func LegacyValueChecksum(offset int64, size int64, segment [SegmentNameSize]byte, data []byte) [32]byte {
+	var header [16 + SegmentNameSize]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(header[8:16], uint64(size))
+	copy(header[16:], segment[:])
+
+	h := sha256.New()
+	h.Write(header[:])
+	h.Write(data)
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// LegacyMetadataChecksum computes the metadata checksum of a legacy record
+// It is SHA-256(offset || size || segment || valueChecksum), with integers in BigEndian
+func LegacyMetadataChecksum(offset int64, size int64, segment [SegmentNameSize]byte, valueChecksum [32]byte) [32]byte {
+	var buf [16 + SegmentNameSize + 32]byte
+	binary.BigEndian.PutUint64(buf[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(size))
+	copy(buf[16:48], segment[:])
+	copy(buf[48:80], valueChecksum[:])
+
+	return sha256.Sum256(buf[:])
+}
+
+// DecodeLegacyMetadata populates m from the first LegacyMetadataSize bytes of src
+// The layout is:
+//   - Bytes 0-7: Offset (8 bytes, BigEndian uint64)
+//   - Bytes 8-15: Size (8 bytes, BigEndian uint64)
+//   - Bytes 16-47: SegmentFile (32 bytes)
+//   - Bytes 48-79: Checksum (32 bytes)
+//   - Bytes 80-111: MChecksum (32 bytes)
+//
+// Flags is always 0, since the legacy format had no tombstones
+// Returns ErrShortBuffer if src is smaller than LegacyMetadataSize
+func DecodeLegacyMetadata(src []byte, m *Metadata) error {
+	if len(src) < LegacyMetadataSize {
+		return fmt.Errorf("DecodeLegacyMetadata: %w", ErrShortBuffer)
+	}
+
+	m.Offset = int64(binary.BigEndian.Uint64(src[0:8]))
+	m.Size = int64(binary.BigEndian.Uint64(src[8:16]))
+	m.Flags = 0
+
+	copy(m.SegmentFile[:], src[16:48])
+	copy(m.Checksum[:], src[48:80])
+	copy(m.MChecksum[:], src[80:112])
+
+	return nil
+}
+
+// DeserializeLegacy populates the metadata fields from exactly LegacyMetadataSize bytes, see DecodeLegacyMetadata
+func (m *Metadata) DeserializeLegacy(data []byte) error {
+	if len(data) != LegacyMetadataSize {
+		return fmt.Errorf("DeserializeLegacy: data does not conform size")
+	}
+
+	return DecodeLegacyMetadata(data, m)
+}
+
+// ValidateLegacyMChecksum verifies the metadata checksum of a legacy entry
+// Returns ErrMetadataCorrupted if it doesn't match
+func (m *Metadata) ValidateLegacyMChecksum() error {
+	if LegacyMetadataChecksum(m.Offset, m.Size, m.SegmentFile, m.Checksum) != m.MChecksum {
+		return fmt.Errorf("ValidateLegacyMChecksum: %w", ErrMetadataCorrupted)
+	}
+
+	return nil
+}
+
+// ValidateLegacyChecksum verifies the value checksum of a legacy entry against data
+// Returns ErrChecksumMismatch if it doesn't match
+func (m *Metadata) ValidateLegacyChecksum(data []byte) error {
+	if LegacyValueChecksum(m.Offset, m.Size, m.SegmentFile, data) != m.Checksum {
+		return fmt.Errorf("ValidateLegacyChecksum: %w", ErrChecksumMismatch)
+	}
+
+	return nil
+}
@@ -0,0 +1,336 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"io"
+	"math"
+)
+
+// Record is a single entry of a segment file
+type Record struct {
+	// Start is the byte position of the record's metadata in the segment file
+	Start int64
+
+	// Metadata is the decoded metadata, whose metadata checksum has been validated
+	Metadata Metadata
+
+	// Payload holds the undecoded payload bytes as stored on disk, see DecodePayload
+	Payload []byte
+}
+
+// End returns the byte position just past the record, i.e. where the next record starts
+func (rec *Record) End() int64 {
+	return rec.Metadata.Offset + rec.Metadata.Size
+}
+
+// Deleted reports whether the record is a tombstone
+func (rec *Record) Deleted() bool {
+	return rec.Metadata.GetMetadataFlagValue(constants.FlagDeleted)
+}
+
+// ValidateChecksum verifies the payload against the value checksum
+// segment is the name of the file the record was read from, which is part of the checksum
+func (rec *Record) ValidateChecksum(segment string) error {
+	if err := rec.Metadata.ValidateChecksum(segment, rec.Payload); err != nil {
+		return fmt.Errorf("ValidateChecksum: %w at offset %d", ErrChecksumMismatch, rec.Start)
+	}
+
+	return nil
+}
+
+// checkBounds validates that the metadata of the record at pos describes the record itself
+// and that the payload fits in the first avail bytes after pos
+func checkBounds(m *Metadata, pos int64, metadataSize int64, avail int64) error {
+	if m.Offset != pos+metadataSize || m.Size < 0 {
+		return fmt.Errorf("%w at offset %d", ErrBadOffset, pos)
+	}
+
+	if m.Size > avail-metadataSize {
+		return fmt.Errorf("%w: incomplete value at offset %d, expected %d bytes", ErrTruncated, m.Offset, m.Size)
+	}
+
+	return nil
+}
+
+// DecodeRecord decodes the record at the start of buf, which is located at byte position pos of its segment file
+// It validates the metadata checksum and bounds but not the value checksum; use ValidateChecksum for that
+// rec.Payload aliases buf, so nothing is allocated
+// Returns the number of bytes consumed, io.EOF if buf is empty, and an error wrapping ErrTruncated
+// if buf ends before the record does
+func DecodeRecord(buf []byte, pos int64, rec *Record) (int, error) {
+	if len(buf) == 0 {
+		return 0, io.EOF
+	}
+
+	if len(buf) < MetadataSize {
+		return 0, fmt.Errorf("DecodeRecord: %w: metadata at offset %d", ErrTruncated, pos)
+	}
+
+	rec.Start = pos
+	DecodeMetadata(buf, &rec.Metadata)
+
+	if err := rec.Metadata.ValidateMChecksum(); err != nil {
+		return 0, fmt.Errorf("DecodeRecord: %w at offset %d", ErrMetadataCorrupted, pos)
+	}
+
+	if err := checkBounds(&rec.Metadata, pos, MetadataSize, int64(len(buf))); err != nil {
+		return 0, fmt.Errorf("DecodeRecord: %w", err)
+	}
+
+	n := MetadataSize + int(rec.Metadata.Size)
+	rec.Payload = buf[MetadataSize:n:n]
+	return n, nil
+}
+
+// ReadRecord reads the record starting at pos of a segment file of fileSize bytes
+// It validates the metadata checksum and bounds but not the value checksum; use ValidateChecksum for that
+// Returns io.EOF if pos is exactly the end of the file
+// Returns an error wrapping ErrTruncated if the record extends past fileSize
+func ReadRecord(r io.ReaderAt, fileSize int64, pos int64) (*Record, error) {
+	return readRecord(r, fileSize, pos, true)
+}
+
+// ReadTrustedRecord reads the record starting at pos like ReadRecord, but only checks its bounds
+// The metadata checksum is not validated, for readers that trust the file
+func ReadTrustedRecord(r io.ReaderAt, fileSize int64, pos int64) (*Record, error) {
+	return readRecord(r, fileSize, pos, false)
+}
+
+// readRecord reads the record starting at pos, validating the metadata checksum if checkMetadata is set
+func readRecord(r io.ReaderAt, fileSize int64, pos int64, checkMetadata bool) (*Record, error) {
+	if pos == fileSize {
+		return nil, io.EOF
+	}
+
+	if pos+MetadataSize > fileSize {
+		return nil, fmt.Errorf("ReadRecord: %w: metadata at offset %d", ErrTruncated, pos)
+	}
+
+	var buf [MetadataSize]byte
+	if _, err := r.ReadAt(buf[:], pos); err != nil {
+		return nil, fmt.Errorf("ReadRecord: failed to read metadata: %w", err)
+	}
+
+	rec := &Record{Start: pos}
+	DecodeMetadata(buf[:], &rec.Metadata)
+
+	if checkMetadata {
+		if err := rec.Metadata.ValidateMChecksum(); err != nil {
+			return nil, fmt.Errorf("ReadRecord: %w at offset %d", ErrMetadataCorrupted, pos)
+		}
+	}
+
+	if err := checkBounds(&rec.Metadata, pos, MetadataSize, fileSize-pos); err != nil {
+		return nil, fmt.Errorf("ReadRecord: %w", err)
+	}
+
+	rec.Payload = make([]byte, rec.Metadata.Size)
+	if _, err := r.ReadAt(rec.Payload, rec.Metadata.Offset); err != nil {
+		return nil, fmt.Errorf("ReadRecord: failed to read value data: %w", err)
+	}
+
+	return rec, nil
+}
+
+// ReadLegacyRecord reads and fully validates the legacy record starting at pos
+// Both the metadata and the value checksum are verified
+// Returns io.EOF if pos is exactly the end of the file
+func ReadLegacyRecord(r io.ReaderAt, fileSize int64, pos int64) (*Record, error) {
+	if pos == fileSize {
+		return nil, io.EOF
+	}
+
+	if pos+LegacyMetadataSize > fileSize {
+		return nil, fmt.Errorf("ReadLegacyRecord: %w: metadata at offset %d", ErrTruncated, pos)
+	}
+
+	var buf [LegacyMetadataSize]byte
+	if _, err := r.ReadAt(buf[:], pos); err != nil {
+		return nil, fmt.Errorf("ReadLegacyRecord: failed to read metadata: %w", err)
+	}
+
+	rec := &Record{Start: pos}
+	DecodeLegacyMetadata(buf[:], &rec.Metadata)
+
+	if err := rec.Metadata.ValidateLegacyMChecksum(); err != nil {
+		return nil, fmt.Errorf("ReadLegacyRecord: %w at offset %d", ErrMetadataCorrupted, pos)
+	}
+
+	if err := checkBounds(&rec.Metadata, pos, LegacyMetadataSize, fileSize-pos); err != nil {
+		return nil, fmt.Errorf("ReadLegacyRecord: %w", err)
+	}
+
+	rec.Payload = make([]byte, rec.Metadata.Size)
+	if _, err := r.ReadAt(rec.Payload, rec.Metadata.Offset); err != nil {
+		return nil, fmt.Errorf("ReadLegacyRecord: failed to read value data: %w", err)
+	}
+
+	if err := rec.Metadata.ValidateLegacyChecksum(rec.Payload); err != nil {
+		return nil, fmt.Errorf("ReadLegacyRecord: %w at offset %d", ErrChecksumMismatch, pos)
+	}
+
+	return rec, nil
+}
+
+// Payload formats
+//
+// Records are written with a length-prefixed binary payload, so keys and values may hold arbitrary bytes:
+//
+//	[PayloadBinary (1 byte)][key length (4 bytes, BigEndian uint32)][key][value]
+//
+// Records of keys with a time to live carry their expiry time, in Unix milliseconds, before the key length:
+//
+//	[PayloadExpiring (1 byte)][expiry (8 bytes, BigEndian int64)][key length (4 bytes)][key][value]
+//
+// Records written since revisions were introduced carry the revision of the write and the expiry time (0 for none):
+//
+//	[PayloadRevised (1 byte)][revision (8 bytes, BigEndian uint64)][expiry (8 bytes)][key length (4 bytes)][key][value]
+//
+// Older records hold a JSON document {"key": ..., "value": ...}, which always starts with '{', and remain
+// readable; JSON cannot represent bytes that are not valid UTF-8, which is why it is no longer written
+const (
+	// PayloadBinary is the first byte of a length-prefixed payload
+	PayloadBinary = 0x01
+
+	// PayloadExpiring is the first byte of a length-prefixed payload with an expiry time
+	PayloadExpiring = 0x02
+
+	// PayloadRevised is the first byte of a length-prefixed payload with a revision and an expiry time
+	PayloadRevised = 0x03
+
+	// payloadHeaderSize is the size of the format byte and key length preceding the key
+	payloadHeaderSize = 5
+
+	// expiringHeaderSize is the size of the format byte, expiry time, and key length preceding the key
+	expiringHeaderSize = 13
+
+	// revisedHeaderSize is the size of the format byte, revision, expiry time, and key length preceding the key
+	revisedHeaderSize = 21
+)
+
+// ErrBadPayload indicates that a payload is in no known payload format or its key length is out of bounds
+var ErrBadPayload = errors.New("malformed payload")
+
+// payload is the JSON document stored after the metadata entry of records written before PayloadBinary
+type payload struct {
+	// Key is the record's key
+	Key string `json:"key"`
+
+	// Value is the record's value (empty for tombstones)
+	Value string `json:"value"`
+}
+
+// EncodePayload encodes a key and value as a length-prefixed record payload
+// Both may contain arbitrary bytes; the error is reserved for keys longer than a uint32 can describe
+func EncodePayload(key string, value string) ([]byte, error) {
+	return EncodeExpiringPayload(key, value, 0)
+}
+
+// EncodeExpiringPayload encodes a key and value that expire at expiresAt, in Unix milliseconds
+// An expiresAt of 0 means the key never expires and produces the same payload as EncodePayload
+func EncodeExpiringPayload(key string, value string, expiresAt int64) ([]byte, error) {
+	if uint64(len(key)) > math.MaxUint32 {
+		return nil, fmt.Errorf("EncodeExpiringPayload: key of %d bytes is too large", len(key))
+	}
+
+	headerSize := payloadHeaderSize
+	if expiresAt != 0 {
+		headerSize = expiringHeaderSize
+	}
+
+	data := make([]byte, headerSize, headerSize+len(key)+len(value))
+	if expiresAt != 0 {
+		data[0] = PayloadExpiring
+		binary.BigEndian.PutUint64(data[1:9], uint64(expiresAt))
+	} else {
+		data[0] = PayloadBinary
+	}
+	binary.BigEndian.PutUint32(data[headerSize-4:headerSize], uint32(len(key)))
+	data = append(data, key...)
+	data = append(data, value...)
+
+	return data, nil
+}
+
+// EncodeRevisedPayload encodes a key and value written at revision, expiring at expiresAt in Unix milliseconds
+// or never if expiresAt is 0
+func EncodeRevisedPayload(key string, value string, expiresAt int64, revision uint64) ([]byte, error) {
+	if uint64(len(key)) > math.MaxUint32 {
+		return nil, fmt.Errorf("EncodeRevisedPayload: key of %d bytes is too large", len(key))
+	}
+
+	data := make([]byte, revisedHeaderSize, revisedHeaderSize+len(key)+len(value))
+	data[0] = PayloadRevised
+	binary.BigEndian.PutUint64(data[1:9], revision)
+	binary.BigEndian.PutUint64(data[9:17], uint64(expiresAt))
+	binary.BigEndian.PutUint32(data[17:21], uint32(len(key)))
+	data = append(data, key...)
+	data = append(data, value...)
+
+	return data, nil
+}
+
+// PayloadOverhead returns the number of bytes EncodeRevisedPayload adds to a key and value
+// Payloads in the older formats are up to 16 bytes smaller
+func PayloadOverhead() int64 {
+	return revisedHeaderSize
+}
+
+// DecodePayload decodes a record payload, in any payload format, into its key and value
+func DecodePayload(data []byte) (string, string, error) {
+	key, value, _, _, err := DecodeRevisedPayload(data)
+	return key, value, err
+}
+
+// DecodeExpiringPayload decodes a record payload into its key, value, and expiry time in Unix milliseconds
+// The expiry time is 0 for keys that never expire
+func DecodeExpiringPayload(data []byte) (string, string, int64, error) {
+	key, value, expiresAt, _, err := DecodeRevisedPayload(data)
+	return key, value, expiresAt, err
+}
+
+// DecodeRevisedPayload decodes a record payload into its key, value, expiry time in Unix milliseconds, and revision
+// The expiry time is 0 for keys that never expire, the revision is 0 for payloads written before revisions
+func DecodeRevisedPayload(data []byte) (string, string, int64, uint64, error) {
+	if len(data) > 0 && (data[0] == PayloadBinary || data[0] == PayloadExpiring || data[0] == PayloadRevised) {
+		headerSize := payloadHeaderSize
+		switch data[0] {
+		case PayloadExpiring:
+			headerSize = expiringHeaderSize
+		case PayloadRevised:
+			headerSize = revisedHeaderSize
+		}
+		if len(data) < headerSize {
+			return "", "", 0, 0, fmt.Errorf("DecodeRevisedPayload: %w: %d byte header", ErrBadPayload, len(data))
+		}
+
+		var expiresAt int64
+		var revision uint64
+		switch data[0] {
+		case PayloadExpiring:
+			expiresAt = int64(binary.BigEndian.Uint64(data[1:9]))
+		case PayloadRevised:
+			revision = binary.BigEndian.Uint64(data[1:9])
+			expiresAt = int64(binary.BigEndian.Uint64(data[9:17]))
+		}
+
+		keyLen := uint64(binary.BigEndian.Uint32(data[headerSize-4 : headerSize]))
+		if keyLen > uint64(len(data)-headerSize) {
+			return "", "", 0, 0, fmt.Errorf("DecodeRevisedPayload: %w: key length %d exceeds payload", ErrBadPayload, keyLen)
+		}
+
+		rest := data[headerSize:]
+		return string(rest[:keyLen]), string(rest[keyLen:]), expiresAt, revision, nil
+	}
+
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return "", "", 0, 0, fmt.Errorf("DecodeRevisedPayload: %w: %w", ErrBadPayload, err)
+	}
+
+	return p.Key, p.Value, 0, 0, nil
+}
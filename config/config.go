@@ -0,0 +1,490 @@
+// Package config loads the server's configuration file and reloads it at runtime
+//
+// The file is JSON. Every setting is optional; settings that are omitted keep their current value
+// (the command line flag or the built-in default):
+//
+//	{
+//	  "startup": {
+//	    "db_path": "/var/lib/kvstash",
+//	    "port": 8080,
+//	    "max_value_size": 1048576,
+//	    "key_normalization": "trim,fold",
+//	    "startup_check": "fast",
+//	    "startup_repair": "fail"
+//	  },
+//	  "log_level": "info",
+//	  "redact_logs": true,
+//	  "compaction_interval": "5m",
+//	  "compaction_pause_windows": [{"start": "08:00", "end": "20:00", "days": ["mon", "tue", "wed", "thu", "fri"]}],
+//	  "compaction_windows": [{"start": "02:00", "end": "04:00"}],
+//	  "compaction_min_garbage_ratio": 0.3,
+//	  "durability": "sync",
+//	  "segment_min_keys": 64,
+//	  "segment_max_keys": 16384,
+//	  "segment_retention": "720h",
+//	  "deletion_history_retention": "2160h",
+//	  "index_check_interval": "10m",
+//	  "index_check_sample": 256,
+//	  "index_check_max_divergence": 0.01,
+//	  "inline_value_bytes": 128,
+//	  "read_cache_bytes": 67108864,
+//	  "value_transformers": ["deflate"],
+//	  "read_verification": "full",
+//	  "request_timeout": "10s",
+//	  "min_free_disk_mb": 64,
+//	  "soft_limit_ratio": 0.9,
+//	  "slowlog_threshold": "10ms",
+//	  "max_inflight": 128,
+//	  "max_queued": 1024,
+//	  "alert_webhooks": ["https://hooks.example.com/kvstash"],
+//	  "namespaces": [
+//	    {"name": "cache", "prefix": "cache:", "default_ttl": "10m", "max_keys": 100000, "eviction": "lru"}
+//	  ]
+//	}
+//
+// The settings in "startup" are read once when the server starts, where the command line flag or environment variable
+// of the same setting takes precedence; changing them takes a restart. All other settings are hot-tunable: they are
+// applied at startup and again on every reload
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/vi88i/kvstash/alert"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/store"
+	"os"
+	"sync"
+	"time"
+)
+
+// Config holds the settings read from the configuration file
+type Config struct {
+	// Startup holds the settings only read when the server starts
+	Startup *Startup `json:"startup,omitempty"`
+
+	// LogLevel is the lowest level logged: "debug" (log every key written), "info", "warn", or "error"
+	LogLevel string `json:"log_level,omitempty"`
+
+	// RedactLogs hides raw keys and values in logs, see the redact package
+	RedactLogs *bool `json:"redact_logs,omitempty"`
+
+	// CompactionInterval is the delay between automatic compaction cycles
+	CompactionInterval Duration `json:"compaction_interval,omitempty"`
+
+	// CompactionPauseWindows replaces the daily windows, in the server's local time, during which automatic
+	// compaction does not run; an empty list removes every window
+	CompactionPauseWindows []PauseWindow `json:"compaction_pause_windows,omitempty"`
+
+	// CompactionWindows replaces the daily windows, in the server's local time, outside of which automatic
+	// compaction does not run; an empty list lets it run at any time
+	CompactionWindows []PauseWindow `json:"compaction_windows,omitempty"`
+
+	// CompactionMinGarbageRatio is the fraction of the database that must be garbage for a cycle to run (0 disables)
+	CompactionMinGarbageRatio *float64 `json:"compaction_min_garbage_ratio,omitempty"`
+
+	// ReadVerification is how much of a record reads check when the request does not say: "full" (the value
+	// checksum), "metadata" (the record's metadata only), or "none"
+	ReadVerification string `json:"read_verification,omitempty"`
+
+	// Durability is "sync" (every write reaches the disk before it is acknowledged) or "none"
+	Durability string `json:"durability,omitempty"`
+
+	// SegmentMinKeys and SegmentMaxKeys bound the number of writes after which the active log is rotated,
+	// which the store adapts to the observed record sizes and write rate; equal bounds fix it
+	SegmentMinKeys *int `json:"segment_min_keys,omitempty"`
+	SegmentMaxKeys *int `json:"segment_max_keys,omitempty"`
+
+	// SegmentRetention is the age after which segments are dropped with their keys instead of being compacted;
+	// "0s" keeps every segment
+	SegmentRetention *Duration `json:"segment_retention,omitempty"`
+
+	// DeletionHistoryRetention is the age after which deletions are pruned from the deletion history log set with
+	// -deletion-history; "0s" keeps them all
+	DeletionHistoryRetention *Duration `json:"deletion_history_retention,omitempty"`
+
+	// IndexCheckInterval is the delay between two checks of the index against the segments; "0s" disables them
+	IndexCheckInterval *Duration `json:"index_check_interval,omitempty"`
+
+	// IndexCheckSample is the number of index entries a check compares with their records; 0 uses the default
+	IndexCheckSample *int `json:"index_check_sample,omitempty"`
+
+	// IndexCheckMaxDivergence is the fraction of the sample that may diverge without rebuilding the index
+	IndexCheckMaxDivergence *float64 `json:"index_check_max_divergence,omitempty"`
+
+	// InlineValueBytes is the size in bytes up to which values are kept in the in-memory index as well, so reads of
+	// them skip the disk; 0 keeps none
+	InlineValueBytes *int `json:"inline_value_bytes,omitempty"`
+
+	// ReadCacheBytes is the memory in bytes the cache of the values most recently read from disk may take; 0
+	// disables it
+	ReadCacheBytes *int64 `json:"read_cache_bytes,omitempty"`
+
+	// ValueTransformers names the transformers applied to the values written from now on, in order, e.g. "deflate"
+	// to compress them; an empty list stores values as they are
+	ValueTransformers *[]string `json:"value_transformers,omitempty"`
+
+	// RequestTimeout is the server-side deadline of key-value requests
+	RequestTimeout Duration `json:"request_timeout,omitempty"`
+
+	// MinFreeDiskMB is the free space on the database volume in MiB below which writes are refused (0 disables)
+	MinFreeDiskMB *int64 `json:"min_free_disk_mb,omitempty"`
+
+	// SoftLimitRatio is the fraction of the value size, namespace, and free disk space limits past which writes are
+	// answered with warnings (0 disables)
+	SoftLimitRatio *float64 `json:"soft_limit_ratio,omitempty"`
+
+	// SlowLogThreshold is the latency above which requests enter the slow query log
+	SlowLogThreshold Duration `json:"slowlog_threshold,omitempty"`
+
+	// MaxInFlight is the number of key-value requests served concurrently (0 disables the limit)
+	MaxInFlight *int `json:"max_inflight,omitempty"`
+
+	// MaxQueued is the number of key-value requests allowed to wait for a slot
+	MaxQueued *int `json:"max_queued,omitempty"`
+
+	// AlertWebhooks replaces the URLs critical events are posted to; an empty list disables alerting
+	AlertWebhooks []string `json:"alert_webhooks,omitempty"`
+
+	// Namespaces replaces the namespace configuration of the store; an empty list removes every namespace
+	Namespaces []Namespace `json:"namespaces,omitempty"`
+}
+
+// Startup holds the settings of the configuration file that take a restart to change, each one the same as the
+// command line flag of that name
+type Startup struct {
+	// DBPath is the database directory, see -db-path
+	DBPath string `json:"db_path,omitempty"`
+
+	// Port is the port the HTTP server listens on, see -port
+	Port int `json:"port,omitempty"`
+
+	// MaxValueSize is the largest value in bytes writes accept, see -max-value-size
+	MaxValueSize int `json:"max_value_size,omitempty"`
+
+	// KeyNormalization is the comma separated list of key normalization modes, see -key-normalization
+	KeyNormalization string `json:"key_normalization,omitempty"`
+
+	// StartupCheck is how much of every record is checked while the index is built, see -startup-check
+	StartupCheck string `json:"startup_check,omitempty"`
+
+	// StartupRepair is what happens to a corrupt sealed segment found while the index is built, see -startup-repair
+	StartupRepair string `json:"startup_repair,omitempty"`
+}
+
+// Validate checks that every startup setting has an accepted value
+func (s *Startup) Validate() error {
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("Validate: port must be between 1 and 65535, got %d", s.Port)
+	}
+
+	if s.MaxValueSize < 0 || s.MaxValueSize > constants.MaxValueSize {
+		return fmt.Errorf("Validate: max_value_size must be between 1 and %d, got %d", constants.MaxValueSize, s.MaxValueSize)
+	}
+
+	if _, err := store.ParseKeyNormalization(s.KeyNormalization); err != nil {
+		return fmt.Errorf("Validate: key_normalization: %w", err)
+	}
+
+	if s.StartupCheck != "" {
+		if _, err := store.ParseStartupCheck(s.StartupCheck); err != nil {
+			return fmt.Errorf("Validate: startup_check: %w", err)
+		}
+	}
+
+	if s.StartupRepair != "" {
+		if _, err := store.ParseStartupRepair(s.StartupRepair); err != nil {
+			return fmt.Errorf("Validate: startup_repair: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Namespace configures the default TTL and limits of the keys starting with a prefix, see store.Namespace
+type Namespace struct {
+	// Name identifies the namespace
+	Name string `json:"name"`
+
+	// Prefix selects the keys of the namespace
+	Prefix string `json:"prefix"`
+
+	// DefaultTTL is the time to live of keys written without one
+	DefaultTTL Duration `json:"default_ttl,omitempty"`
+
+	// MaxValueSize is the maximum value size in bytes
+	MaxValueSize int `json:"max_value_size,omitempty"`
+
+	// MaxKeys is the maximum number of live keys
+	MaxKeys int `json:"max_keys,omitempty"`
+
+	// Eviction is "none" (refuse writes to a full namespace), "lru", or "fifo"
+	Eviction string `json:"eviction,omitempty"`
+}
+
+// PauseWindow is a daily window during which automatic compaction does not run, or the only time it runs
+// in compaction_windows, see store.PauseWindow
+type PauseWindow struct {
+	// Start and End are "HH:MM" times of day; a window ending before it starts runs past midnight
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Days lists the weekdays the window starts on, such as "sat" or "sunday" (default: every day)
+	Days []string `json:"days,omitempty"`
+}
+
+// StorePauseWindow converts the window to its store form
+func (w *PauseWindow) StorePauseWindow() (store.PauseWindow, error) {
+	return store.ParsePauseWindow(w.Start, w.End, w.Days)
+}
+
+// StoreNamespace converts the namespace to its store form
+func (ns *Namespace) StoreNamespace() store.Namespace {
+	return store.Namespace{
+		Name:         ns.Name,
+		Prefix:       ns.Prefix,
+		DefaultTTL:   time.Duration(ns.DefaultTTL),
+		MaxValueSize: ns.MaxValueSize,
+		MaxKeys:      ns.MaxKeys,
+		Eviction:     store.EvictionPolicy(ns.Eviction),
+	}
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "5m" in the configuration file
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("UnmarshalJSON: duration must be a string such as \"90s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("UnmarshalJSON: %w", err)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads and validates the configuration file at path
+// Unknown settings are rejected so typos don't go unnoticed
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Load: %w", err)
+	}
+
+	cfg := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Load: failed to parse %v: %w", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("Load: %v: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that every setting has an accepted value
+func (c *Config) Validate() error {
+	if c.Startup != nil {
+		if err := c.Startup.Validate(); err != nil {
+			return fmt.Errorf("Validate: startup: %w", err)
+		}
+	}
+
+	if c.LogLevel != "" {
+		if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+			return fmt.Errorf("Validate: log_level: %w", err)
+		}
+	}
+
+	if c.CompactionInterval < 0 {
+		return fmt.Errorf("Validate: compaction_interval must be positive, got %v", time.Duration(c.CompactionInterval))
+	}
+
+	for i := range c.CompactionPauseWindows {
+		if _, err := c.CompactionPauseWindows[i].StorePauseWindow(); err != nil {
+			return fmt.Errorf("Validate: compaction_pause_windows: %w", err)
+		}
+	}
+
+	for i := range c.CompactionWindows {
+		if _, err := c.CompactionWindows[i].StorePauseWindow(); err != nil {
+			return fmt.Errorf("Validate: compaction_windows: %w", err)
+		}
+	}
+
+	if r := c.CompactionMinGarbageRatio; r != nil && (*r < 0 || *r >= 1) {
+		return fmt.Errorf("Validate: compaction_min_garbage_ratio must be at least 0 and below 1, got %v", *r)
+	}
+
+	if c.SegmentMinKeys != nil && *c.SegmentMinKeys < 1 {
+		return fmt.Errorf("Validate: segment_min_keys must be positive, got %d", *c.SegmentMinKeys)
+	}
+
+	if c.SegmentMaxKeys != nil && *c.SegmentMaxKeys < 1 {
+		return fmt.Errorf("Validate: segment_max_keys must be positive, got %d", *c.SegmentMaxKeys)
+	}
+
+	if c.SegmentMinKeys != nil && c.SegmentMaxKeys != nil && *c.SegmentMinKeys > *c.SegmentMaxKeys {
+		return fmt.Errorf("Validate: segment_min_keys must not exceed segment_max_keys, got %d and %d",
+			*c.SegmentMinKeys, *c.SegmentMaxKeys)
+	}
+
+	if c.ValueTransformers != nil {
+		if err := store.ValidateTransformers(*c.ValueTransformers); err != nil {
+			return fmt.Errorf("Validate: value_transformers: %w", err)
+		}
+	}
+
+	if r := c.SoftLimitRatio; r != nil && (*r < 0 || *r >= 1) {
+		return fmt.Errorf("Validate: soft_limit_ratio must be at least 0 and below 1, got %v", *r)
+	}
+
+	if n := c.InlineValueBytes; n != nil && (*n < 0 || *n > constants.MaxInlineThreshold) {
+		return fmt.Errorf("Validate: inline_value_bytes must be between 0 and %d, got %d", constants.MaxInlineThreshold, *n)
+	}
+	if n := c.ReadCacheBytes; n != nil && (*n < 0 || *n > constants.MaxReadCacheBytes) {
+		return fmt.Errorf("Validate: read_cache_bytes must be between 0 and %d, got %d", int64(constants.MaxReadCacheBytes), *n)
+	}
+
+	if c.SegmentRetention != nil && *c.SegmentRetention < 0 {
+		return fmt.Errorf("Validate: segment_retention must not be negative, got %v", time.Duration(*c.SegmentRetention))
+	}
+
+	if c.DeletionHistoryRetention != nil && *c.DeletionHistoryRetention < 0 {
+		return fmt.Errorf("Validate: deletion_history_retention must not be negative, got %v",
+			time.Duration(*c.DeletionHistoryRetention))
+	}
+
+	if c.IndexCheckInterval != nil && *c.IndexCheckInterval < 0 {
+		return fmt.Errorf("Validate: index_check_interval must not be negative, got %v", time.Duration(*c.IndexCheckInterval))
+	}
+
+	if n := c.IndexCheckSample; n != nil && *n < 0 {
+		return fmt.Errorf("Validate: index_check_sample must not be negative, got %d", *n)
+	}
+
+	if r := c.IndexCheckMaxDivergence; r != nil && (*r < 0 || *r >= 1) {
+		return fmt.Errorf("Validate: index_check_max_divergence must be at least 0 and below 1, got %v", *r)
+	}
+
+	if c.RequestTimeout < 0 {
+		return fmt.Errorf("Validate: request_timeout must be positive, got %v", time.Duration(c.RequestTimeout))
+	}
+
+	if c.SlowLogThreshold < 0 {
+		return fmt.Errorf("Validate: slowlog_threshold must be positive, got %v", time.Duration(c.SlowLogThreshold))
+	}
+
+	if c.MinFreeDiskMB != nil && *c.MinFreeDiskMB < 0 {
+		return fmt.Errorf("Validate: min_free_disk_mb must not be negative, got %d", *c.MinFreeDiskMB)
+	}
+
+	if c.MaxInFlight != nil && *c.MaxInFlight < 0 {
+		return fmt.Errorf("Validate: max_inflight must not be negative, got %d", *c.MaxInFlight)
+	}
+
+	if c.MaxQueued != nil && *c.MaxQueued < 0 {
+		return fmt.Errorf("Validate: max_queued must not be negative, got %d", *c.MaxQueued)
+	}
+
+	if c.ReadVerification != "" {
+		if _, err := store.ParseVerification(c.ReadVerification); err != nil {
+			return fmt.Errorf("Validate: read_verification: %w", err)
+		}
+	}
+
+	if c.Durability != "" {
+		if _, err := store.ParseDurability(c.Durability); err != nil {
+			return fmt.Errorf("Validate: durability: %w", err)
+		}
+	}
+
+	for _, u := range c.AlertWebhooks {
+		if err := alert.ValidateURL(u); err != nil {
+			return fmt.Errorf("Validate: alert_webhooks: %w", err)
+		}
+	}
+
+	for i := range c.Namespaces {
+		ns := c.Namespaces[i].StoreNamespace()
+		if err := ns.Validate(); err != nil {
+			return fmt.Errorf("Validate: namespaces: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Reloader owns the configuration file of a running server
+// It is safe for concurrent use
+type Reloader struct {
+	// path is the configuration file
+	path string
+
+	// apply puts a validated configuration into effect
+	apply func(*Config) error
+
+	// mu serializes reloads and protects current and loadedAt
+	mu sync.Mutex
+
+	// current is the configuration applied last
+	current *Config
+
+	// loadedAt is when current was applied
+	loadedAt time.Time
+}
+
+// NewReloader creates a reloader for the configuration file at path
+// apply is called with every successfully loaded configuration
+func NewReloader(path string, apply func(*Config) error) *Reloader {
+	return &Reloader{path: path, apply: apply}
+}
+
+// Reload reads, validates, and applies the configuration file
+// If the file is missing or invalid, nothing is applied and the previous configuration stays in effect
+func (r *Reloader) Reload() (*Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := Load(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("Reload: %w", err)
+	}
+
+	if err := r.apply(cfg); err != nil {
+		return nil, fmt.Errorf("Reload: failed to apply %v: %w", r.path, err)
+	}
+
+	r.current = cfg
+	r.loadedAt = time.Now()
+	return cfg, nil
+}
+
+// Current returns the configuration applied last and when it was applied (nil before the first Reload)
+func (r *Reloader) Current() (*Config, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current, r.loadedAt
+}
+
+// Path returns the configuration file path
+func (r *Reloader) Path() string {
+	return r.path
+}
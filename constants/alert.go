@@ -0,0 +1,12 @@
+package constants
+
+const (
+	// AlertQueueSize is the number of alerts waiting for delivery; further alerts are dropped and logged
+	AlertQueueSize = 64
+
+	// AlertTimeout is the deadline of a single webhook call in seconds
+	AlertTimeout = 5
+
+	// AlertAttempts is the number of times a webhook call is tried before the alert is given up on
+	AlertAttempts = 3
+)
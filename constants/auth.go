@@ -0,0 +1,19 @@
+package constants
+
+const (
+	// AuthClockSkew is the leeway in seconds allowed when checking the expiry and not-before times of tokens
+	AuthClockSkew = 60
+
+	// JWKSRefreshInterval is how long in seconds fetched JWKS keys are used before they are fetched again
+	JWKSRefreshInterval = 3600
+
+	// JWKSMinRefreshInterval is the minimum delay in seconds between fetches of the JWKS, so tokens naming
+	// unknown keys cannot make the server hammer the identity provider
+	JWKSMinRefreshInterval = 30
+
+	// JWKSFetchTimeout is the deadline of a JWKS fetch in seconds
+	JWKSFetchTimeout = 5
+
+	// MaxJWKSSize is the largest JWKS document accepted in bytes
+	MaxJWKSSize = 1 << 20
+)
@@ -0,0 +1,6 @@
+package constants
+
+const (
+	// WriteFailureThreshold is the number of consecutive failed writes after which the store stops accepting writes
+	WriteFailureThreshold = 5
+)
@@ -0,0 +1,9 @@
+package constants
+
+const (
+	// MinFreeDiskBytes is the default free space on the database volume below which the server refuses writes
+	MinFreeDiskBytes = 64 << 20 // 64 MiB
+
+	// DiskCheckInterval is the minimum delay between two free disk space checks in seconds
+	DiskCheckInterval = 1
+)
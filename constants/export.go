@@ -0,0 +1,15 @@
+package constants
+
+const (
+	// ArchiveMagic starts every export archive
+	ArchiveMagic = "KVSTASHX"
+
+	// ArchiveEndMagic ends every complete export archive, so a truncated one is detected
+	ArchiveEndMagic = "KVSXEND\n"
+
+	// ArchiveVersion is the version of the export archive format written by this build
+	ArchiveVersion = 1
+
+	// MaxArchiveHeaderSize is the largest header or manifest accepted in an export archive in bytes
+	MaxArchiveHeaderSize = 1 << 20
+)
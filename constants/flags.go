@@ -0,0 +1,34 @@
+package constants
+
+const (
+	FlagDeleted = 0
+
+	// FlagList, FlagSet, and FlagHash mark records holding a collection; records without them hold a string
+	FlagList = 1
+	FlagSet  = 2
+	FlagHash = 3
+
+	// FlagJSON marks records holding a JSON document
+	FlagJSON = 4
+
+	// FlagBatch marks every record of a conditional batch but the last one, which commits the batch
+	// Recovery discards batch records that are not followed by their commit record
+	FlagBatch = 5
+
+	// FlagExpiry marks records that change the expiry time of a key without rewriting its value; they carry the key
+	// and the new expiry time but no value
+	FlagExpiry = 6
+
+	// FlagTransforms is the first of the flag bits holding the IDs of the value transformers applied to the record's
+	// value, one byte per transformer in the order they were applied, up to MaxTransforms of them
+	FlagTransforms = 32
+
+	// MaxTransforms is the number of transformers that can be chained
+	MaxTransforms = 4
+
+	// DeflateTransformID is the ID of the built-in deflate transformer
+	DeflateTransformID = 1
+
+	// MinCustomTransformID is the first transformer ID free for custom transformers, the lower ones are reserved
+	MinCustomTransformID = 128
+)
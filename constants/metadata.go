@@ -0,0 +1,37 @@
+// Package constants defines application-wide configuration values and limits
+package constants
+
+const (
+	// MetadataSize is the fixed size in bytes for metadata entries in the log file
+	// Layout: 8 bytes (offset) + 8 bytes (size) + 32 bytes (segment file) + 32 bytes (checksum) + 32 bytes (metadata checksum) + 8 bytes (flags) = 120 bytes
+	MetadataSize = 120
+
+	// LegacyMetadataSize is the size of metadata entries written before the flags field was introduced
+	// Layout: 8 bytes (offset) + 8 bytes (size) + 32 bytes (segment file) + 32 bytes (checksum) + 32 bytes (metadata checksum) = 112 bytes
+	// Segments in this format have no tombstones and can be rewritten with kvstash-admin upgrade
+	LegacyMetadataSize = 112
+
+	// MaxKeySize is the maximum allowed size in bytes for a key
+	MaxKeySize = 256 // 256 bytes
+
+	// MaxValueSize is the maximum allowed size in bytes for a value
+	MaxValueSize = 1048576 // 1 MB
+
+	// SoftLimitRatio is the default fraction of a limit past which the server warns that writes approach it
+	SoftLimitRatio = 0.9
+
+	// MaxBatchKeys is the maximum number of keys in a single multi-key request
+	MaxBatchKeys = 1000
+
+	// RangeLimit is the number of keys a range request returns when it sets no limit
+	RangeLimit = 100
+
+	// ReadAheadKeys is the number of values an iterator reads ahead in one batch
+	ReadAheadKeys = 128
+
+	// KeyListMaxLevel is the number of levels of the skip list of the index keys, enough for 4^32 keys
+	KeyListMaxLevel = 32
+
+	// KeyListBranching is the inverse of the probability that a key of the skip list is on the level above
+	KeyListBranching = 4
+)
@@ -0,0 +1,36 @@
+package constants
+
+const (
+	// LatencySamples is the number of most recent requests per operation used to compute latency percentiles
+	LatencySamples = 1024
+
+	// SlowLogSize is the number of slow requests kept by the slow query log
+	SlowLogSize = 128
+
+	// SlowLogThreshold is the default latency in milliseconds above which a request enters the slow query log
+	SlowLogThreshold = 10
+
+	// KeyspaceTopN is the default number of largest values listed by the keyspace report
+	KeyspaceTopN = 10
+
+	// KeyspaceMaxTopN is the maximum number of largest values the keyspace report can list
+	KeyspaceMaxTopN = 1000
+
+	// KeyspaceMaxPrefixes is the number of prefixes with the most keys listed by the keyspace report
+	KeyspaceMaxPrefixes = 100
+
+	// HotKeysTopN is the default number of most accessed keys listed by the hot key report
+	HotKeysTopN = 10
+
+	// HotKeysTracked is the number of most accessed keys the hot key report keeps track of, and can list
+	HotKeysTracked = 100
+
+	// HotKeySketchWidth and HotKeySketchDepth size the count-min sketch estimating the accesses of every key:
+	// estimates exceed the true count by at most 2/width of all accesses, except with a probability of 1/2^depth
+	HotKeySketchWidth = 4096
+	HotKeySketchDepth = 4
+
+	// HotKeyHalfLife is the time in seconds after which the hot key report halves every access count, so it
+	// follows shifts in the traffic
+	HotKeyHalfLife = 60
+)
@@ -0,0 +1,12 @@
+package constants
+
+const (
+	// MirrorQueueSize is the number of writes waiting to be mirrored; further writes are not mirrored
+	MirrorQueueSize = 1024
+
+	// MirrorTimeout is the deadline of a mirrored request in seconds
+	MirrorTimeout = 5
+
+	// MaxMirrorBodySize is the largest request body mirrored in bytes; larger requests are not mirrored
+	MaxMirrorBodySize = 16 << 20
+)
@@ -0,0 +1,29 @@
+package constants
+
+const (
+	// DBPath is the directory path where database files are stored (relative to the working directory)
+	DBPath = "db"
+
+	// TmpDBPath is the directory path where compacted files are created
+	TmpDBPath = "tmp_db"
+
+	// BackupDBPath is the directory path where backup is stored before compaction
+	BackupDBPath = "bkp_db"
+
+	// QuarantineDBPath is the directory path where corrupt segments and databases are moved by the startup repair
+	QuarantineDBPath = "quarantine_db"
+
+	// RelocatedName is the file left in a database directory whose database was moved by a relocation,
+	// holding the absolute path of the new directory
+	RelocatedName = "RELOCATED"
+
+	// RelocationCatchUpBytes is the number of bytes left to copy below which a relocation blocks writes to copy them
+	RelocationCatchUpBytes = 4 << 20
+
+	// RelocationMaxRounds is the number of copy rounds after which a relocation blocks writes to copy the rest,
+	// so that a steady write load cannot keep it from finishing
+	RelocationMaxRounds = 10
+
+	// MaxRelocationHops is the number of RELOCATED files followed when a database is opened, to detect loops
+	MaxRelocationHops = 16
+)
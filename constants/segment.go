@@ -0,0 +1,143 @@
+package constants
+
+const (
+	// KeysPerSegment is the number of writes after which the active log is rotated until segment sizing has
+	// observed a full segment
+	KeysPerSegment = 512
+
+	// MinKeysPerSegment and MaxKeysPerSegment bound the rotation threshold chosen by segment sizing (default bounds)
+	MinKeysPerSegment = 64
+	MaxKeysPerSegment = 16384
+
+	// SegmentFillTime is the time in seconds segment sizing aims for the active log to take to fill at the
+	// observed write rate
+	SegmentFillTime = 300
+
+	// MinSegmentBytes and MaxSegmentBytes bound the segment size segment sizing aims for
+	MinSegmentBytes = 1 << 20
+	MaxSegmentBytes = 64 << 20
+
+	// SegmentSizingWeight is the weight of the last rotated segment in the averages segment sizing keeps
+	SegmentSizingWeight = 0.3
+
+	// MaxClockSkew is the largest difference in milliseconds between the clock and the elapsed time measured by the
+	// monotonic clock that the store follows; larger jumps are clock skew
+	MaxClockSkew = 1000
+
+	// ClockSaveInterval is the delay in seconds between two saves of the store's time to the superblock, besides
+	// the saves at every rotation
+	ClockSaveInterval = 60
+
+	// MaxInlineThreshold is the largest size in bytes of the values kept in the index, see Store.SetInlineThreshold
+	MaxInlineThreshold = 4096
+
+	// InlineEntryOverhead is the memory in bytes an index entry takes for a value kept in it, besides the value
+	// itself: the string header the entry points to
+	InlineEntryOverhead = 16
+
+	// MaxReadCacheBytes is the largest memory budget in bytes of the read cache, see Store.SetReadCacheBytes
+	MaxReadCacheBytes = 1 << 40
+
+	// ReadCacheEntryOverhead is the memory in bytes the read cache takes for a value, besides the key and the value
+	// themselves: the list element, the item, and the map entry pointing to it
+	ReadCacheEntryOverhead = 128
+
+	// RetentionCheckInterval is the delay in seconds between two checks for segments older than the retention
+	RetentionCheckInterval = 60
+
+	// IndexCheckInterval is the delay in seconds between two checks of the server's index against the segments
+	IndexCheckInterval = 600
+
+	// IndexCheckSample is the default number of index entries compared with their records by an index check
+	IndexCheckSample = 256
+
+	// IndexCheckIdleInterval is the delay in seconds after which a disabled index check looks whether it was enabled
+	IndexCheckIdleInterval = 60
+
+	// IndexCheckMaxDivergences is the number of divergent entries an index check reports in detail
+	IndexCheckMaxDivergences = 10
+
+	// SegmentNamePrefix is the prefix of segment files
+	SegmentNamePrefix = "seg"
+
+	// SegmentNameExt is the extension of the segment files
+	SegmentNameExt = ".log"
+
+	// SealExt is appended to the name of a segment for the file recording the offset it was sealed at
+	// after a failed write; the bytes after it are ignored
+	SealExt = ".sealed"
+
+	// FilterExt is appended to the name of a sealed segment for the file holding the Bloom filter of its keys
+	FilterExt = ".bloom"
+
+	// FilterBitsPerKey and FilterHashes size the Bloom filters of the sealed segments, for about 1% false positives
+	FilterBitsPerKey = 10
+	FilterHashes     = 7
+
+	// FilterBuildInterval is the delay in seconds between two looks for sealed segments without a Bloom filter
+	FilterBuildInterval = 60
+
+	// MaxOpenSegments is the number of segment files kept open for reads
+	MaxOpenSegments = 128
+
+	// PrefetchWorkers is the number of prefetches running at once; further hints are dropped
+	PrefetchWorkers = 2
+
+	// PrefetchGap is the largest gap in bytes between two records of a segment that a prefetch reads through,
+	// turning them into one sequential read
+	PrefetchGap = 64 * 1024
+
+	// PrefetchReadSize is the size in bytes of a single prefetch read
+	PrefetchReadSize = 1024 * 1024
+
+	// Compaction interval in seconds
+	CompactionInterval = 60
+
+	// CompactionHistorySize is the number of compaction cycles kept in the compaction history
+	CompactionHistorySize = 64
+)
+
+const (
+	// SuperblockName is the file in the database directory recording the active segment and the next segment number
+	SuperblockName = "SUPERBLOCK"
+
+	// SuperblockSize is the size in bytes of the superblock
+	// Layout: magic (4) | format version (4) | active segment (8) | next segment (8) | revision (8) |
+	// clock high-water mark in Unix milliseconds (8) | SHA-256 of the preceding bytes (32)
+	SuperblockSize = 72
+
+	// RevisionSuperblockSize is the size in bytes of superblocks written before the clock high-water mark
+	RevisionSuperblockSize = 64
+
+	// LegacySuperblockSize is the size in bytes of superblocks written before revisions, which lack the revision
+	LegacySuperblockSize = 56
+
+	// SuperblockMagic identifies a superblock file
+	SuperblockMagic = "KVSB"
+
+	// FormatVersion is the database format version written to the superblock
+	// Databases with a newer version are refused; version 2 added revisions to records and the superblock
+	FormatVersion = 2
+)
+
+const (
+	// IndexSnapshotName is the file in the database directory holding the last snapshot of the index
+	IndexSnapshotName = "INDEX"
+
+	// IndexSnapshotMagic identifies an index snapshot file
+	IndexSnapshotMagic = "KVIX"
+
+	// IndexSnapshotVersion is the format version of the index snapshot; snapshots of another version are ignored
+	IndexSnapshotVersion = 1
+
+	// IndexSnapshotInterval is the delay in seconds between two snapshots of the server's index
+	IndexSnapshotInterval = 300
+)
+
+const (
+	// FilterMagic identifies a segment Bloom filter file
+	FilterMagic = "KVBF"
+
+	// FilterVersion is the format version of the segment Bloom filters; filters of another version are built again
+	FilterVersion = 1
+)
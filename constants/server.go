@@ -0,0 +1,21 @@
+package constants
+
+const (
+	// Port is the default port the HTTP server listens on
+	Port = 8080
+
+	// ShutdownTimeout is the default time in seconds the server waits for the requests in flight when it is stopped
+	ShutdownTimeout = 30
+
+	// RequestTimeout is the default server-side deadline of a key-value request in seconds
+	RequestTimeout = 10
+
+	// MaxInFlightRequests is the default number of key-value requests served concurrently
+	MaxInFlightRequests = 128
+
+	// MaxQueuedRequests is the default number of key-value requests allowed to wait for a slot
+	MaxQueuedRequests = 1024
+
+	// OverloadRetryAfter is the Retry-After delay in seconds of requests rejected because the queue is full
+	OverloadRetryAfter = 1
+)
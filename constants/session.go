@@ -0,0 +1,20 @@
+package constants
+
+const (
+	// SessionKeyPrefix starts the keys sessions are stored under, followed by the session ID
+	SessionKeyPrefix = "_session:"
+
+	// SessionIDBytes is the number of random bytes of a session ID, which is their lowercase hex encoding, so IDs
+	// survive case folding key normalization
+	SessionIDBytes = 32
+
+	// SessionTTL is the default time to live of a session in seconds
+	SessionTTL = 1800
+
+	// MaxSessionTTL is the longest time to live of a session in seconds (30 days)
+	MaxSessionTTL = 30 * 24 * 3600
+
+	// SessionRefreshAttempts is how many times a refresh is retried when another write to the session
+	// came in between its read and its write
+	SessionRefreshAttempts = 5
+)
@@ -0,0 +1,23 @@
+package constants
+
+const (
+	// WatchRetainedEvents is the number of recent changefeed events kept for watchers to resume from
+	WatchRetainedEvents = 4096
+
+	// WatchBufferSize is the number of undelivered events a watcher may lag behind before it is dropped
+	WatchBufferSize = 256
+
+	// NotifyBufferSize is the number of undelivered keyspace notifications a subscriber may lag behind
+	// before further notifications are dropped
+	NotifyBufferSize = 1024
+
+	// NotifyHeartbeat is the interval in seconds of keep-alive comments on idle notification streams
+	NotifyHeartbeat = 15
+
+	// ExpiryCheckInterval is the delay in milliseconds between two checks for keys that expired
+	ExpiryCheckInterval = 100
+
+	// MaxExpiredPerCheck is the number of expired keys announced while the store is locked once;
+	// the rest are announced right after
+	MaxExpiredPerCheck = 1000
+)
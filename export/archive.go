@@ -0,0 +1,407 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"github.com/vi88i/kvstash/store"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+Export archives:
+
+An export archive holds every live key of a store, with its type and expiry time, in a self-describing container
+whose integrity is checked end to end before anything is restored from it:
+
+	header:   "KVSTASHX" | version (u16) | header length (u32) | header JSON | SHA-256 of the header fields before it
+	records:  'R' | body length (u32) | body | SHA-256 of the body
+	          body = type (u8) | expires at in Unix ms (i64) | key length (u32) | key | value
+	manifest: 'M' | manifest length (u32) | manifest JSON
+	trailer:  SHA-256 of every byte before it | "KVSXEND\n"
+
+Integers are little endian. The header records the archive version and when it was created, the manifest the
+number of records, their key and value bytes, and the number of keys of each type. A flipped bit fails the checksum
+of its record or header, a truncated archive misses its trailer, and records dropped, duplicated, or reordered as
+a whole fail the totals of the manifest or the hash of the archive.
+
+FromArchive reads the whole archive once to verify it before it writes the first key, so a corrupted or truncated
+export never replaces good data. Collections are stored encoded as returned by store.Iterator.Value.
+*/
+
+// Errors returned when reading an export archive
+var (
+	// ErrCorruptArchive is returned for an archive that fails a checksum, its totals, or is not an archive
+	ErrCorruptArchive = errors.New("corrupt export archive")
+
+	// ErrTruncatedArchive is returned for an archive that ends before its trailer
+	ErrTruncatedArchive = errors.New("truncated export archive")
+
+	// ErrUnsupportedArchive is returned for an archive written in a newer format
+	ErrUnsupportedArchive = errors.New("unsupported export archive version")
+)
+
+const (
+	// recordTag and manifestTag start the records and the manifest of an archive
+	recordTag   = 'R'
+	manifestTag = 'M'
+
+	// headerPrefixSize is the size of the magic, version, and length that precede the header JSON
+	headerPrefixSize = len(constants.ArchiveMagic) + 2 + 4
+
+	// recordFieldsSize is the size of the type, expiry time, and key length of a record body
+	recordFieldsSize = 1 + 8 + 4
+
+	// maxRecordSize is the largest record body a valid store can export
+	maxRecordSize = recordFieldsSize + constants.MaxKeySize + constants.MaxValueSize
+)
+
+// archiveHeader is the header JSON of an archive
+type archiveHeader struct {
+	// Version is the archive format version, repeated from the binary header
+	Version int `json:"version"`
+
+	// Created is when the export was taken
+	Created time.Time `json:"created"`
+
+	// StoreFormat is the segment format version of the store the archive was exported from
+	StoreFormat int `json:"store_format"`
+}
+
+// Manifest summarizes the records of an export archive
+type Manifest struct {
+	// Records is the number of keys in the archive
+	Records int `json:"records"`
+
+	// KeyBytes and ValueBytes are the total size of the keys and values
+	KeyBytes   int64 `json:"key_bytes"`
+	ValueBytes int64 `json:"value_bytes"`
+
+	// Types counts the keys of every value type, e.g. {"string": 950, "hash": 50}
+	Types map[string]int `json:"types"`
+
+	// Created is when the export was taken, from the header
+	Created time.Time `json:"-"`
+
+	// SHA256 is the hash of the archive recorded in its trailer, in hex
+	SHA256 string `json:"-"`
+}
+
+// add counts a record in the totals of m
+func (m *Manifest) add(key string, value string, typ models.KVStashValueType) {
+	if m.Types == nil {
+		m.Types = make(map[string]int)
+	}
+	m.Records++
+	m.KeyBytes += int64(len(key))
+	m.ValueBytes += int64(len(value))
+	m.Types[typ.String()]++
+}
+
+// ToArchive writes every live key in the store into a new export archive at path
+// The export reads from a store snapshot, so it reflects a single consistent point in time
+// Refuses to overwrite an existing file, and removes the partial file if the export fails
+func ToArchive(s *store.Store, path string) (*Manifest, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("ToArchive: %w", err)
+	}
+
+	manifest, err := writeArchive(s, file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("ToArchive: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// writeArchive writes an archive of a snapshot of s to w
+func writeArchive(s *store.Store, w io.Writer) (*Manifest, error) {
+	hash := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(w, hash))
+
+	manifest := &Manifest{Types: make(map[string]int), Created: time.Now().UTC()}
+	headerJSON, err := json.Marshal(archiveHeader{
+		Version:     constants.ArchiveVersion,
+		Created:     manifest.Created,
+		StoreFormat: constants.FormatVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("writeArchive: %w", err)
+	}
+	header := make([]byte, 0, headerPrefixSize+len(headerJSON))
+	header = append(header, constants.ArchiveMagic...)
+	header = binary.LittleEndian.AppendUint16(header, constants.ArchiveVersion)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(headerJSON)))
+	header = append(header, headerJSON...)
+	headerSum := sha256.Sum256(header)
+	out.Write(header)
+	out.Write(headerSum[:])
+
+	snap := s.Snapshot()
+	defer snap.Release()
+
+	it := snap.Iterator()
+	for it.Next() {
+		entry := it.Entry()
+		body := encodeRecord(it.Key(), it.Value(), entry.Type, entry.ExpiresAt)
+		sum := sha256.Sum256(body)
+		out.WriteByte(recordTag)
+		out.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(body))))
+		out.Write(body)
+		out.Write(sum[:])
+		manifest.add(it.Key(), it.Value(), entry.Type)
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("writeArchive: failed to read snapshot: %w", err)
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("writeArchive: %w", err)
+	}
+	out.WriteByte(manifestTag)
+	out.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(manifestJSON))))
+	out.Write(manifestJSON)
+	if err := out.Flush(); err != nil {
+		return nil, fmt.Errorf("writeArchive: %w", err)
+	}
+
+	// The trailer is not part of the hash it records
+	sum := hash.Sum(nil)
+	if _, err := w.Write(append(sum, constants.ArchiveEndMagic...)); err != nil {
+		return nil, fmt.Errorf("writeArchive: %w", err)
+	}
+
+	manifest.SHA256 = hex.EncodeToString(sum)
+	return manifest, nil
+}
+
+// VerifyArchive checks every checksum, the totals, and the hash of the export archive at path
+// Returns an error wrapping ErrCorruptArchive, ErrTruncatedArchive, or ErrUnsupportedArchive if it is not intact
+func VerifyArchive(path string) (*Manifest, error) {
+	manifest, err := readArchiveFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyArchive: %w", err)
+	}
+	return manifest, nil
+}
+
+// FromArchive verifies the export archive at path, then writes its keys into s, replacing keys that exist
+// Keys that expired since the export are skipped; keys of s missing from the archive are left alone
+// Nothing is written if the archive is not intact, see VerifyArchive
+// Returns the manifest of the archive and the number of keys written
+func FromArchive(s *store.Store, path string) (*Manifest, int, error) {
+	if _, err := readArchiveFile(path, nil); err != nil {
+		return nil, 0, fmt.Errorf("FromArchive: %w", err)
+	}
+
+	imported := 0
+	now := time.Now().UnixMilli()
+	manifest, err := readArchiveFile(path, func(key string, value string, typ models.KVStashValueType, expiresAt int64) error {
+		if expiresAt != 0 && expiresAt <= now {
+			return nil
+		}
+		if err := s.ImportRecord(key, value, typ, expiresAt); err != nil {
+			return fmt.Errorf("key=%v: %w", redact.Key(key), err)
+		}
+		imported++
+		return nil
+	})
+	if err != nil {
+		return nil, imported, fmt.Errorf("FromArchive: %w", err)
+	}
+
+	return manifest, imported, nil
+}
+
+// readArchiveFile reads the archive at path, see readArchive
+func readArchiveFile(path string, apply func(key string, value string, typ models.KVStashValueType, expiresAt int64) error) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("readArchiveFile: %w", err)
+	}
+	defer file.Close()
+
+	manifest, err := readArchive(file, apply)
+	if err != nil {
+		return nil, fmt.Errorf("readArchiveFile: %w", err)
+	}
+	return manifest, nil
+}
+
+// readArchive reads and verifies an archive from r, calling apply, if not nil, for every record once it is verified
+// The archive as a whole is only verified at its end, so apply may have been called for the records before an error
+func readArchive(r io.Reader, apply func(key string, value string, typ models.KVStashValueType, expiresAt int64) error) (*Manifest, error) {
+	in := bufio.NewReader(r)
+	hash := sha256.New()
+	hashed := io.TeeReader(in, hash)
+
+	prefix := make([]byte, headerPrefixSize)
+	if err := readFull(hashed, prefix); err != nil {
+		return nil, fmt.Errorf("readArchive: %w", err)
+	}
+	if string(prefix[:len(constants.ArchiveMagic)]) != constants.ArchiveMagic {
+		return nil, fmt.Errorf("readArchive: %w: not an export archive", ErrCorruptArchive)
+	}
+	if version := binary.LittleEndian.Uint16(prefix[len(constants.ArchiveMagic):]); version != constants.ArchiveVersion {
+		return nil, fmt.Errorf("readArchive: %w: version %d, this build reads version %d",
+			ErrUnsupportedArchive, version, constants.ArchiveVersion)
+	}
+	headerJSON, err := readBlock(hashed, binary.LittleEndian.Uint32(prefix[headerPrefixSize-4:]), constants.MaxArchiveHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("readArchive: header: %w", err)
+	}
+	if err := readChecksum(hashed, append(prefix, headerJSON...)); err != nil {
+		return nil, fmt.Errorf("readArchive: header: %w", err)
+	}
+	var header archiveHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("readArchive: %w: invalid header: %v", ErrCorruptArchive, err)
+	}
+
+	totals := Manifest{Types: make(map[string]int)}
+	var manifestJSON []byte
+	for manifestJSON == nil {
+		tag := make([]byte, 1+4)
+		if err := readFull(hashed, tag); err != nil {
+			return nil, fmt.Errorf("readArchive: after record %d: %w", totals.Records, err)
+		}
+		length := binary.LittleEndian.Uint32(tag[1:])
+
+		switch tag[0] {
+		case recordTag:
+			body, err := readBlock(hashed, length, maxRecordSize)
+			if err == nil {
+				err = readChecksum(hashed, body)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("readArchive: record %d: %w", totals.Records+1, err)
+			}
+			key, value, typ, expiresAt, err := decodeRecord(body)
+			if err != nil {
+				return nil, fmt.Errorf("readArchive: record %d: %w", totals.Records+1, err)
+			}
+			totals.add(key, value, typ)
+			if apply != nil {
+				if err := apply(key, value, typ, expiresAt); err != nil {
+					return nil, fmt.Errorf("readArchive: record %d: %w", totals.Records, err)
+				}
+			}
+
+		case manifestTag:
+			manifestJSON, err = readBlock(hashed, length, constants.MaxArchiveHeaderSize)
+			if err != nil {
+				return nil, fmt.Errorf("readArchive: manifest: %w", err)
+			}
+
+		default:
+			return nil, fmt.Errorf("readArchive: %w: unexpected tag 0x%02x after record %d", ErrCorruptArchive, tag[0], totals.Records)
+		}
+	}
+
+	sum := hash.Sum(nil)
+	trailer := make([]byte, sha256.Size+len(constants.ArchiveEndMagic))
+	if err := readFull(in, trailer); err != nil {
+		return nil, fmt.Errorf("readArchive: trailer: %w", err)
+	}
+	if string(trailer[sha256.Size:]) != constants.ArchiveEndMagic {
+		return nil, fmt.Errorf("readArchive: %w: invalid trailer", ErrCorruptArchive)
+	}
+	if !bytes.Equal(trailer[:sha256.Size], sum) {
+		return nil, fmt.Errorf("readArchive: %w: archive hash mismatch", ErrCorruptArchive)
+	}
+	if _, err := in.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("readArchive: %w: data after the trailer", ErrCorruptArchive)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("readArchive: %w: invalid manifest: %v", ErrCorruptArchive, err)
+	}
+	if manifest.Records != totals.Records || manifest.KeyBytes != totals.KeyBytes || manifest.ValueBytes != totals.ValueBytes {
+		return nil, fmt.Errorf("readArchive: %w: manifest lists %d records, %d key and %d value bytes, the archive holds %d, %d, and %d",
+			ErrCorruptArchive, manifest.Records, manifest.KeyBytes, manifest.ValueBytes, totals.Records, totals.KeyBytes, totals.ValueBytes)
+	}
+
+	manifest.Created = header.Created
+	manifest.SHA256 = hex.EncodeToString(sum)
+	return &manifest, nil
+}
+
+// encodeRecord returns the body of the record of a key
+func encodeRecord(key string, value string, typ models.KVStashValueType, expiresAt int64) []byte {
+	body := make([]byte, 0, recordFieldsSize+len(key)+len(value))
+	body = append(body, byte(typ))
+	body = binary.LittleEndian.AppendUint64(body, uint64(expiresAt))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(key)))
+	body = append(body, key...)
+	return append(body, value...)
+}
+
+// decodeRecord parses the body of a record
+func decodeRecord(body []byte) (string, string, models.KVStashValueType, int64, error) {
+	if len(body) < recordFieldsSize {
+		return "", "", 0, 0, fmt.Errorf("decodeRecord: %w: record too short", ErrCorruptArchive)
+	}
+	typ := models.KVStashValueType(body[0])
+	expiresAt := int64(binary.LittleEndian.Uint64(body[1:9]))
+	keyLen := binary.LittleEndian.Uint32(body[9:13])
+	if uint64(keyLen) > uint64(len(body)-recordFieldsSize) {
+		return "", "", 0, 0, fmt.Errorf("decodeRecord: %w: key length %d exceeds the record", ErrCorruptArchive, keyLen)
+	}
+	rest := body[recordFieldsSize:]
+	return string(rest[:keyLen]), string(rest[keyLen:]), typ, expiresAt, nil
+}
+
+// readBlock reads a block of length bytes, refusing lengths above limit
+func readBlock(r io.Reader, length uint32, limit int) ([]byte, error) {
+	if uint64(length) > uint64(limit) {
+		return nil, fmt.Errorf("readBlock: %w: block of %d bytes exceeds the limit of %d", ErrCorruptArchive, length, limit)
+	}
+	block := make([]byte, length)
+	if err := readFull(r, block); err != nil {
+		return nil, fmt.Errorf("readBlock: %w", err)
+	}
+	return block, nil
+}
+
+// readChecksum reads the SHA-256 following data and checks that it matches
+func readChecksum(r io.Reader, data []byte) error {
+	sum := make([]byte, sha256.Size)
+	if err := readFull(r, sum); err != nil {
+		return fmt.Errorf("readChecksum: %w", err)
+	}
+	if want := sha256.Sum256(data); !bytes.Equal(sum, want[:]) {
+		return fmt.Errorf("readChecksum: %w: checksum mismatch", ErrCorruptArchive)
+	}
+	return nil
+}
+
+// readFull fills buf from r, reporting an archive that ends early as ErrTruncatedArchive
+func readFull(r io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncatedArchive
+		}
+		return err
+	}
+	return nil
+}
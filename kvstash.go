@@ -0,0 +1,670 @@
+// Package kvstash is an embeddable, persistent key-value store inspired by Bitcask
+//
+// Data is stored in append-only segment files in a single directory, with an in-memory index
+// for O(1) lookups and periodic background compaction:
+//
+//	db, err := kvstash.Open("data", nil)
+//	if err != nil { ... }
+//	defer db.Close()
+//
+//	if err := db.Set("user:1", "Alice"); err != nil { ... }
+//	value, err := db.Get("user:1")
+//	if errors.Is(err, kvstash.ErrNotFound) { ... }
+//
+// A directory must only be opened by one DB (or server) at a time.
+package kvstash
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"time"
+)
+
+// Errors returned by DB operations
+var (
+	ErrNotFound        = store.ErrKeyNotFound
+	ErrEmptyKey        = store.ErrEmptyKey
+	ErrKeyTooLarge     = store.ErrKeyTooLarge
+	ErrValueTooLarge   = store.ErrValueTooLarge
+	ErrBadPattern      = store.ErrBadPattern
+	ErrDegraded        = store.ErrDegraded
+	ErrMaintenance     = store.ErrMaintenance
+	ErrDiskFull        = store.ErrDiskFull
+	ErrWrongType       = store.ErrWrongType
+	ErrBadJSONPath     = store.ErrBadJSONPath
+	ErrPathNotFound    = store.ErrJSONPathNotFound
+	ErrInvalidJSON     = store.ErrInvalidJSON
+	ErrNamespaceFull   = store.ErrNamespaceFull
+	ErrBadNamespace    = store.ErrBadNamespace
+	ErrBadTTL          = store.ErrBadTTL
+	ErrBadExpireAt     = store.ErrBadExpireAt
+	ErrNotDeleted      = store.ErrNotDeleted
+	ErrNoPriorVersion  = store.ErrNoPriorVersion
+	ErrConditionFailed = store.ErrConditionFailed
+	ErrBadBatch        = store.ErrBadBatch
+	ErrBadKeyspace     = store.ErrBadKeyspaceOptions
+	ErrBadRelocation   = store.ErrBadRelocation
+	ErrRelocating      = store.ErrRelocating
+	ErrSnapshotsOpen   = store.ErrSnapshotsOpen
+	ErrNoSession       = store.ErrSessionNotFound
+	ErrBadSessionTTL   = store.ErrBadSessionTTL
+	ErrSessionBusy     = store.ErrSessionBusy
+	ErrBadTransformer  = store.ErrBadTransformer
+	ErrNoTransformer   = store.ErrUnknownTransformer
+	ErrKeyExists       = store.ErrKeyExists
+	ErrBadMaxValueSize = store.ErrBadMaxValueSize
+)
+
+// Snapshot is a consistent point-in-time view of the live keys of a DB
+// Compaction is deferred while a snapshot is open, so it must be released with Release
+type Snapshot = store.Snapshot
+
+// Iterator walks keys in ascending order; see Snapshot.Iterator and DB.Iterator
+type Iterator = store.Iterator
+
+// KeyNormalization selects how keys are rewritten before they are stored or looked up; see Options.KeyNormalization
+type KeyNormalization = store.KeyNormalization
+
+// ParseKeyNormalization parses a comma separated list of normalization modes such as "trim,fold,nfc"
+var ParseKeyNormalization = store.ParseKeyNormalization
+
+// Namespace configures the default TTL, maximum value size, and key limit of the keys starting with a prefix
+type Namespace = store.Namespace
+
+// EvictionPolicy selects what happens when a write would exceed the MaxKeys of a namespace
+type EvictionPolicy = store.EvictionPolicy
+
+// Eviction policies of a Namespace
+const (
+	EvictNone = store.EvictNone
+	EvictLRU  = store.EvictLRU
+	EvictFIFO = store.EvictFIFO
+)
+
+// Verification selects how much of a record Get and GetMany check; see Options.Verification
+type Verification = store.Verification
+
+// Verification levels
+const (
+	VerifyFull     = store.VerifyFull
+	VerifyMetadata = store.VerifyMetadata
+	VerifyNone     = store.VerifyNone
+)
+
+// StartupCheck selects how much of every record Open verifies while loading the database; see Options.StartupCheck
+type StartupCheck = store.StartupCheck
+
+// Startup check levels
+const (
+	StartupCheckFast = store.StartupCheckFast
+	StartupCheckFull = store.StartupCheckFull
+	StartupCheckNone = store.StartupCheckNone
+)
+
+// StartupRepair selects what Open does with a corrupt sealed segment; see Options.StartupRepair
+type StartupRepair = store.StartupRepair
+
+// Startup repair policies
+const (
+	StartupRepairFail    = store.StartupRepairFail
+	StartupRepairSkip    = store.StartupRepairSkip
+	StartupRepairSalvage = store.StartupRepairSalvage
+)
+
+// Repair describes a corrupt sealed segment repaired by Open, see DB.Repairs
+type Repair = store.Repair
+
+// KeyspaceOptions selects what DB.Keyspace analyzes
+type KeyspaceOptions = store.KeyspaceOptions
+
+// KeyspaceStats holds value size and key length histograms, the largest values, and key counts per prefix
+type KeyspaceStats = store.KeyspaceStats
+
+// HotKey is a key accessed often, with its estimated share of the accesses, see DB.HotKeys
+type HotKey = store.HotKey
+
+// IndexCheck configures the background check of the index against the segments, see Options.IndexCheck
+type IndexCheck = store.IndexCheck
+
+// IndexCheckReport is the result of DB.CheckIndex
+type IndexCheckReport = store.IndexCheckReport
+
+// ReindexReport is the result of DB.Reindex
+type ReindexReport = store.ReindexReport
+
+// RelocationReport describes a database moved by DB.Relocate
+type RelocationReport = store.RelocationReport
+
+// Session is a session of the session store, see DB.CreateSession
+type Session = store.Session
+
+// Condition is a precondition of DB.CheckAndSet: the key exists or not, has a version, or holds a value
+type Condition = models.KVStashCondition
+
+// BatchWrite sets or deletes a key as part of DB.CheckAndSet
+type BatchWrite = models.KVStashBatchWrite
+
+// ConditionError lists the conditions of DB.CheckAndSet that did not hold; it wraps ErrConditionFailed
+type ConditionError = store.ConditionError
+
+// WarmupOptions selects the values read by DB.Warmup
+type WarmupOptions = store.WarmupOptions
+
+// WarmupReport is the result of DB.Warmup
+type WarmupReport = store.WarmupReport
+
+// Alert is a critical event raised by the database, such as a corrupt record or a full disk, or its resolution
+type Alert = models.KVStashAlert
+
+// Warning tells that a write came close to a limit, see DB.SoftLimitWarnings
+type Warning = models.KVStashWarning
+
+// Hooks receives the database's writes, reads, compaction cycles, and corrupt records, see DB.AddHooks
+type Hooks = store.Hooks
+
+// NoHooks implements Hooks with no-ops, for embedding in hooks that only need some of its methods
+type NoHooks = store.NoHooks
+
+// SetInfo describes a write passed to Hooks.OnSet
+type SetInfo = store.SetInfo
+
+// GetInfo describes a read passed to Hooks.OnGet
+type GetInfo = store.GetInfo
+
+// CorruptionInfo describes a corrupt record passed to Hooks.OnCorruption
+type CorruptionInfo = store.CorruptionInfo
+
+// Deletion records when a key was deleted and by whom, see DB.DeletionHistory
+type Deletion = models.KVStashDeletion
+
+// CompactionRun describes a compaction cycle passed to Hooks.OnCompactionEnd
+type CompactionRun = store.CompactionRun
+
+// Transformer encodes values before they are written and decodes them after they are read, e.g. to compress or
+// encrypt them; see RegisterTransformer
+type Transformer = store.Transformer
+
+// RegisterTransformer makes t available to Options.Transformers under its name, and to reads under its ID
+// The built-in "deflate" compresses values; custom transformers take IDs from 128 on
+// Returns ErrBadTransformer if the ID or name is empty or taken
+func RegisterTransformer(t Transformer) error {
+	return store.RegisterTransformer(t)
+}
+
+// ValueType is the kind of value stored under a key: a string, list, set, or hash
+type ValueType = models.KVStashValueType
+
+// Value types returned by DB.Type
+const (
+	TypeString = models.TypeString
+	TypeList   = models.TypeList
+	TypeSet    = models.TypeSet
+	TypeHash   = models.TypeHash
+	TypeJSON   = models.TypeJSON
+)
+
+// Options configures a DB
+// The zero value (or nil) selects the defaults documented on each field
+type Options struct {
+	// DisableCompaction turns off periodic background compaction
+	DisableCompaction bool
+
+	// CompactionInterval is the delay between compaction cycles (default: 60s)
+	CompactionInterval time.Duration
+
+	// TmpPath is the directory compaction builds the new database in (default: path + ".tmp")
+	TmpPath string
+
+	// BackupPath is the directory the database is copied to during compaction (default: path + ".bkp")
+	BackupPath string
+
+	// FailureThreshold is the number of consecutive failed writes after which the database becomes read-only
+	// and writes fail with ErrDegraded until DB.ResumeWrites is called (default: 5)
+	FailureThreshold int
+
+	// MinFreeBytes is the free disk space below which writes fail with ErrDiskFull and compaction is skipped
+	// (default: 0, disabled)
+	MinFreeBytes int64
+
+	// SoftLimit is the fraction of a limit, such as the maximum value size or MinFreeBytes, past which
+	// DB.SoftLimitWarnings warns about writes (default: 0, disabled)
+	SoftLimit float64
+
+	// KeyNormalization makes equivalent spellings of a key the same key, by trimming white space, folding case,
+	// or converting to Unicode NFC (default: none, keys are compared bytewise)
+	// It must be the same every time the database is opened, or keys may stop matching
+	KeyNormalization KeyNormalization
+
+	// MaxValueSize lowers the largest value writes accept (default: 0, 1 MiB, the most a record can hold); Open
+	// returns ErrBadMaxValueSize for a larger one
+	MaxValueSize int
+
+	// Namespaces configures default TTLs and limits for groups of keys; see DB.SetNamespaces
+	Namespaces []Namespace
+
+	// Verification is how much of a record Get and GetMany check: VerifyFull hashes the whole value,
+	// VerifyMetadata only checks the record's metadata, and VerifyNone checks nothing (default: VerifyFull)
+	// Iterators and collection updates always verify values in full
+	Verification Verification
+
+	// StartupCheck is how much of every record Open verifies while loading the database: StartupCheckFast checks
+	// metadata checksums, StartupCheckFull also hashes every value, and StartupCheckNone only checks that records
+	// fit in their files (default: StartupCheckFast)
+	StartupCheck StartupCheck
+
+	// StartupRepair is what Open does when a segment other than the one written to is corrupt: StartupRepairFail
+	// returns an error, StartupRepairSkip moves the segment to QuarantinePath and loads the others, and
+	// StartupRepairSalvage recovers every readable record into a fresh database and moves the original to
+	// QuarantinePath (default: StartupRepairFail); see DB.Repairs
+	StartupRepair StartupRepair
+
+	// QuarantinePath is the directory corrupt segments and databases are moved to (default: path + ".quarantine")
+	QuarantinePath string
+
+	// Hooks are called on the database's operations, including the corrupt records found by Open (default: none);
+	// see DB.AddHooks
+	Hooks []Hooks
+
+	// Transformers names the registered transformers applied to the values written, in order, e.g. "deflate"
+	// (default: none); values written before keep theirs, see RegisterTransformer
+	Transformers []string
+
+	// DeletionHistory is the file the tombstones of deleted keys are appended to before compaction drops them
+	// (default: "", they are not kept); see DB.DeletionHistory
+	DeletionHistory string
+
+	// DeletionHistoryRetention is the age after which deletions are pruned from DeletionHistory
+	// (default: 0, they are kept)
+	DeletionHistoryRetention time.Duration
+
+	// IndexCheck has the index checked against the segments in the background, and rebuilt if they diverge
+	// (default: disabled); see DB.CheckIndex
+	IndexCheck IndexCheck
+}
+
+// DB is an open KVStash database
+// It is safe for concurrent use
+type DB struct {
+	// store is the underlying storage engine
+	store *store.Store
+}
+
+// Open opens the database in the directory path, creating it if it doesn't exist
+// opts may be nil to use the defaults
+func Open(path string, opts *Options) (*DB, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	s, err := store.Open(path, store.Options{
+		TmpPath:                  opts.TmpPath,
+		BackupPath:               opts.BackupPath,
+		AutoCompact:              !opts.DisableCompaction,
+		CompactionInterval:       opts.CompactionInterval,
+		FailureThreshold:         opts.FailureThreshold,
+		MinFreeBytes:             opts.MinFreeBytes,
+		SoftLimit:                opts.SoftLimit,
+		KeyNormalization:         opts.KeyNormalization,
+		MaxValueSize:             opts.MaxValueSize,
+		Namespaces:               opts.Namespaces,
+		Verification:             opts.Verification,
+		StartupCheck:             opts.StartupCheck,
+		StartupRepair:            opts.StartupRepair,
+		QuarantinePath:           opts.QuarantinePath,
+		Hooks:                    opts.Hooks,
+		Transformers:             opts.Transformers,
+		DeletionHistory:          opts.DeletionHistory,
+		DeletionHistoryRetention: opts.DeletionHistoryRetention,
+		IndexCheck:               opts.IndexCheck,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+
+	return &DB{store: s}, nil
+}
+
+// Get returns the value stored for key, or ErrNotFound
+func (db *DB) Get(key string) (string, error) {
+	return db.store.Get(&models.KVStashRequest{Key: key})
+}
+
+// GetRevision returns the value stored for key and the revision of the write that stored it, or ErrNotFound
+// Writes to a key get increasing revisions, which survive restarts and compaction
+func (db *DB) GetRevision(key string) (string, uint64, error) {
+	value, version, err := db.store.GetVersion(&models.KVStashRequest{Key: key})
+	if err != nil {
+		return "", 0, err
+	}
+	return value, version.Revision, nil
+}
+
+// GetMany returns the values of the keys that exist and hold a string, mapped by key
+// The values are read grouped by segment file, which is much cheaper than a Get per key
+func (db *DB) GetMany(keys []string) (map[string]string, error) {
+	return db.store.GetMany(keys, "")
+}
+
+// Prefetch hints that the values of keys are about to be read; they are read into the page cache in the background
+func (db *DB) Prefetch(keys []string) {
+	db.store.Prefetch(keys)
+}
+
+// Set stores value under key
+func (db *DB) Set(key string, value string) error {
+	_, err := db.store.Set(&models.KVStashRequest{Key: key, Value: value})
+	return err
+}
+
+// SetWithTTL stores value under key, which expires after ttl
+// A ttl of 0 applies the default TTL of the key's namespace and a negative ttl never expires
+// Returns ErrNamespaceFull if the key's namespace is full and does not evict
+func (db *DB) SetWithTTL(key string, value string, ttl time.Duration) error {
+	_, err := db.store.SetWithTTL(key, value, ttl)
+	return err
+}
+
+// SetUntil stores value under key, which expires at the absolute time at
+// Returns ErrBadExpireAt for the zero time and ErrNamespaceFull if the key's namespace is full and does not evict
+func (db *DB) SetUntil(key string, value string, at time.Time) error {
+	_, err := db.store.SetUntil(key, value, at)
+	return err
+}
+
+// Expire changes the expiry time of key to ttl from now without rewriting its value; a negative ttl makes it never
+// expire
+// Returns ErrNotFound if the key does not exist, or ErrBadTTL for a ttl of 0
+func (db *DB) Expire(key string, ttl time.Duration) error {
+	_, err := db.store.Expire(key, ttl)
+	return err
+}
+
+// ExpireAt changes the expiry time of key to at without rewriting its value; the zero time makes it never expire
+// Returns ErrNotFound if the key does not exist
+func (db *DB) ExpireAt(key string, at time.Time) error {
+	_, err := db.store.ExpireAt(key, at)
+	return err
+}
+
+// SetNamespaces replaces the namespace configuration, see Namespace
+// Returns ErrBadNamespace if a namespace is invalid
+func (db *DB) SetNamespaces(namespaces []Namespace) error {
+	return db.store.SetNamespaces(namespaces)
+}
+
+// Warmup opens the newest segment files and reads the selected values, so the first reads after Open
+// don't wait for a cold disk; failures are logged and counted in the report
+func (db *DB) Warmup(opts WarmupOptions) *WarmupReport {
+	return db.store.Warmup(opts)
+}
+
+// Delete removes key, or returns ErrNotFound
+func (db *DB) Delete(key string) error {
+	return db.store.Delete(&models.KVStashRequest{Key: key})
+}
+
+// CheckAndSet applies writes only if every check holds, all of them or none, even across a crash
+// Returns a *ConditionError wrapping ErrConditionFailed if a check does not hold
+func (db *DB) CheckAndSet(checks []Condition, writes []BatchWrite) error {
+	_, err := db.store.CheckAndSet(checks, writes)
+	return err
+}
+
+// CompareAndSwap stores value under key only if key currently holds old
+// Returns a *ConditionError wrapping ErrConditionFailed if it does not, including if the key does not exist
+func (db *DB) CompareAndSwap(key string, old string, value string) error {
+	_, err := db.store.CompareAndSwap(&models.KVStashRequest{Key: key, Value: value, ExpectedValue: &old})
+	return err
+}
+
+// Undelete restores the value key held before it was deleted, as long as compaction has not removed it
+// Returns ErrNotFound, ErrNotDeleted if the key is live, or ErrNoPriorVersion if there is nothing to restore
+func (db *DB) Undelete(key string) error {
+	_, err := db.store.Undelete(key)
+	return err
+}
+
+// DeletionHistory returns the deletions of key, oldest first, while their tombstones are in the database or, once
+// compaction dropped them, in the Options.DeletionHistory file
+func (db *DB) DeletionHistory(key string) ([]Deletion, error) {
+	return db.store.DeletionHistory(key)
+}
+
+// Rename moves the value of from to the key to in one atomic write, keeping its type and expiry time; to is
+// replaced if it exists and replace is set
+// Returns ErrNotFound if from does not exist, or ErrKeyExists if to exists and replace is not set
+func (db *DB) Rename(from string, to string, replace bool) error {
+	_, err := db.store.Rename(from, to, replace)
+	return err
+}
+
+// CreateSession creates a session holding data with a new random ID, which expires after ttl (0 for 30 minutes)
+func (db *DB) CreateSession(data string, ttl time.Duration) (*Session, error) {
+	return db.store.CreateSession(data, ttl)
+}
+
+// ValidateSession returns the session id, or ErrNoSession if it does not exist or expired
+func (db *DB) ValidateSession(id string) (*Session, error) {
+	return db.store.ValidateSession(id)
+}
+
+// RefreshSession makes the session id expire ttl from now (0 for 30 minutes), replacing its data if data is not nil
+// Returns ErrNoSession if it does not exist or expired
+func (db *DB) RefreshSession(id string, ttl time.Duration, data *string) (*Session, error) {
+	return db.store.RefreshSession(id, ttl, data)
+}
+
+// DestroySession deletes the session id, or returns ErrNoSession if it does not exist
+func (db *DB) DestroySession(id string) error {
+	return db.store.DestroySession(id)
+}
+
+// Type returns the kind of value stored under key, or ErrNotFound
+func (db *DB) Type(key string) (ValueType, error) {
+	return db.store.Type(key)
+}
+
+// LPush prepends values to the list stored under key and returns its new length
+// Lists, sets, and hashes return ErrWrongType for keys holding another kind of value
+func (db *DB) LPush(key string, values ...string) (int, error) {
+	return db.store.LPush(key, values...)
+}
+
+// RPush appends values to the list stored under key and returns its new length
+func (db *DB) RPush(key string, values ...string) (int, error) {
+	return db.store.RPush(key, values...)
+}
+
+// LPop removes and returns the first element of the list stored under key, or ErrNotFound
+func (db *DB) LPop(key string) (string, error) {
+	return db.store.LPop(key)
+}
+
+// LRange returns the elements of the list stored under key from start to stop, both inclusive
+// Negative indexes count from the end, so LRange(key, 0, -1) returns the whole list
+func (db *DB) LRange(key string, start int, stop int) ([]string, error) {
+	return db.store.LRange(key, start, stop)
+}
+
+// LLen returns the length of the list stored under key
+func (db *DB) LLen(key string) (int, error) {
+	return db.store.LLen(key)
+}
+
+// SAdd adds members to the set stored under key and returns how many were new
+func (db *DB) SAdd(key string, members ...string) (int, error) {
+	return db.store.SAdd(key, members...)
+}
+
+// SRem removes members from the set stored under key and returns how many were in it
+func (db *DB) SRem(key string, members ...string) (int, error) {
+	return db.store.SRem(key, members...)
+}
+
+// SMembers returns the members of the set stored under key in ascending order
+func (db *DB) SMembers(key string) ([]string, error) {
+	return db.store.SMembers(key)
+}
+
+// SIsMember reports whether member is in the set stored under key
+func (db *DB) SIsMember(key string, member string) (bool, error) {
+	return db.store.SIsMember(key, member)
+}
+
+// HSet sets fields of the hash stored under key and returns how many were new
+func (db *DB) HSet(key string, fields map[string]string) (int, error) {
+	return db.store.HSet(key, fields)
+}
+
+// HGet returns the value of field in the hash stored under key, or ErrNotFound
+func (db *DB) HGet(key string, field string) (string, error) {
+	return db.store.HGet(key, field)
+}
+
+// HDel removes fields from the hash stored under key and returns how many were in it
+func (db *DB) HDel(key string, fields ...string) (int, error) {
+	return db.store.HDel(key, fields...)
+}
+
+// HGetAll returns all fields of the hash stored under key
+func (db *DB) HGetAll(key string) (map[string]string, error) {
+	return db.store.HGetAll(key)
+}
+
+// JSONGet returns the value at path (e.g. $.address.city) in the JSON document stored under key, encoded as JSON
+// Returns ErrNotFound if the key does not exist and ErrPathNotFound if the path leads nowhere
+func (db *DB) JSONGet(key string, path string) (string, error) {
+	return db.store.JSONGet(key, path)
+}
+
+// JSONSet sets the value at path in the JSON document stored under key to value, a JSON-encoded value
+// Setting $ creates or replaces the whole document
+func (db *DB) JSONSet(key string, path string, value string) error {
+	return db.store.JSONSet(key, path, value)
+}
+
+// JSONDel removes the value at path from the JSON document stored under key; deleting $ deletes the key
+func (db *DB) JSONDel(key string, path string) error {
+	return db.store.JSONDel(key, path)
+}
+
+// Snapshot captures a consistent view of all live keys
+// The snapshot must be released with Release
+func (db *DB) Snapshot() *Snapshot {
+	return db.store.Snapshot()
+}
+
+// Iterator returns an iterator over all live keys in ascending order, as of the time of the call
+// The iterator must be closed with Close
+func (db *DB) Iterator() *Iterator {
+	return db.store.Iterator()
+}
+
+// Scan returns an iterator over the live keys matching a glob pattern such as user:*:profile,
+// in ascending order, as of the time of the call
+// See store.Pattern for the syntax; returns ErrBadPattern if the pattern is malformed
+// The iterator must be closed with Close
+func (db *DB) Scan(pattern string) (*Iterator, error) {
+	return db.store.Scan(pattern)
+}
+
+// Range returns an iterator over the live keys k with start <= k < end, in ascending order; an empty end has no
+// upper bound
+// The keys are read from the index as the iterator advances, so keys written or deleted meanwhile are seen as they
+// are if the iterator has not passed them yet
+// The iterator must be closed with Close
+func (db *DB) Range(start, end string) *Iterator {
+	return db.store.Range(start, end)
+}
+
+// ResumeWrites re-enables writes after repeated storage errors made the database read-only
+func (db *DB) ResumeWrites() error {
+	return db.store.ResumeWrites()
+}
+
+// SetMaintenance turns maintenance mode on or off: while it is on, writes fail with ErrMaintenance,
+// reads continue, and automatic compaction does not start
+func (db *DB) SetMaintenance(enabled bool, message string) {
+	db.store.SetMaintenance(enabled, message)
+}
+
+// PauseCompaction stops automatic compaction for d, or until ResumeCompaction if d is 0
+func (db *DB) PauseCompaction(d time.Duration) error {
+	return db.store.PauseCompaction(d)
+}
+
+// ResumeCompaction lifts a pause set by PauseCompaction
+func (db *DB) ResumeCompaction() {
+	db.store.ResumeCompaction()
+}
+
+// Keyspace returns analytics of the live keys, optionally sampled: value size and key length histograms,
+// the largest values, and key counts per prefix
+// Returns ErrBadKeyspace if an option is out of range
+func (db *DB) Keyspace(opts KeyspaceOptions) (KeyspaceStats, error) {
+	return db.store.Keyspace(opts)
+}
+
+// HotKeys returns the n keys read and written most often recently, hottest first (n <= 0 for 10, at most 100)
+// The accesses are estimated with a count-min sketch and count half as much every minute
+func (db *DB) HotKeys(n int) []HotKey {
+	return db.store.HotKeys(n)
+}
+
+// CheckIndex compares a random sample of index entries with the records they point at on disk, and rebuilds the
+// index from the segments if more of them diverge than Options.IndexCheck allows
+// Returns an error if the rebuild failed, along with the report
+func (db *DB) CheckIndex() (*IndexCheckReport, error) {
+	return db.store.CheckIndex()
+}
+
+// Reindex rebuilds the index from the segments, blocking reads and writes meanwhile
+func (db *DB) Reindex() (*ReindexReport, error) {
+	return db.store.Reindex()
+}
+
+// SetIndexCheck changes the background index check set with Options.IndexCheck
+func (db *DB) SetIndexCheck(c IndexCheck) error {
+	return db.store.SetIndexCheck(c)
+}
+
+// Repairs returns the corrupt segments Open repaired as Options.StartupRepair says, empty if there were none
+func (db *DB) Repairs() []Repair {
+	return db.store.Repairs()
+}
+
+// Relocate moves the database to the directory path, which must not exist or be empty, while it stays open
+// Writes only wait while the last bytes are copied; opening the old directory later opens the new one
+// Returns ErrBadRelocation for an unusable path and ErrSnapshotsOpen if snapshots were open at the switch
+func (db *DB) Relocate(path string) (*RelocationReport, error) {
+	return db.store.Relocate(path)
+}
+
+// SetAlertHandler sets the function receiving the database's alerts; the alert package posts them to webhooks
+// fn must not block or call into the database
+func (db *DB) SetAlertHandler(fn func(Alert)) {
+	db.store.SetAlertHandler(fn)
+}
+
+// AddHooks registers h to be called on the database's operations, e.g. to keep custom metrics, a cache, or a replica
+// Hooks run synchronously, often with the database locked, so they must not block or call into the database
+func (db *DB) AddHooks(h Hooks) {
+	db.store.AddHooks(h)
+}
+
+// SoftLimitWarnings returns the limits a successful write of a value of valueSize bytes to key came within
+// Options.SoftLimit of, so callers can react before writes start failing
+func (db *DB) SoftLimitWarnings(key string, valueSize int) []Warning {
+	return db.store.SoftLimitWarnings(key, valueSize)
+}
+
+// Store returns the underlying storage engine, e.g. to serve the DB over HTTP with the svc package
+func (db *DB) Store() *store.Store {
+	return db.store
+}
+
+// Close stops background compaction and closes the database
+func (db *DB) Close() error {
+	return db.store.Close()
+}
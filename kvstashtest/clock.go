@@ -0,0 +1,141 @@
+package kvstashtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Epoch is the time the Clock of a store opened by Open starts at
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is a store.MonotonicClock that only moves when told to, so expiry and background jobs do not depend on how
+// fast a test runs
+// Advance and Set let time pass; Jump changes the time without time passing, as a system clock stepped by NTP or a
+// VM restored from a snapshot does, which the store reports as clock skew, see store.ClockStats
+// It is safe for concurrent use
+type Clock struct {
+	// mu protects now, elapsed, and timers
+	mu sync.Mutex
+
+	// changed is signaled when a timer is added
+	changed *sync.Cond
+
+	// now is the time returned by Now
+	now time.Time
+
+	// elapsed is the time returned by Elapsed
+	elapsed time.Duration
+
+	// timers are the channels returned by After that did not fire yet
+	timers []timer
+}
+
+// timer is a channel returned by After, to receive the time once the clock's elapsed time reaches deadline
+type timer struct {
+	deadline time.Duration
+	ch       chan time.Time
+}
+
+// NewClock creates a clock set to start
+func NewClock(start time.Time) *Clock {
+	c := &Clock{now: start}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the clock's time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Elapsed returns the time Advance and Set let pass since the clock was created
+func (c *Clock) Elapsed() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.elapsed
+}
+
+// After returns a channel that receives the clock's time once Advance or Set let d pass
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, timer{deadline: c.elapsed + d, ch: ch})
+	c.changed.Broadcast()
+	return ch
+}
+
+// Advance lets d pass, firing the timers that are due
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(max(d, 0))
+}
+
+// Set sets the clock to t, letting the time up to t pass and firing the timers that are due
+// A t in the past sets the clock back without time passing, like Jump
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d := t.Sub(c.now); d > 0 {
+		c.advance(d)
+		return
+	}
+	c.now = t
+}
+
+// Jump moves the clock by d, forward or back, without time passing: timers do not fire, and the store ignores the
+// jump and reports clock skew until the clock is back in step, e.g. after Jump(-d)
+func (c *Clock) Jump(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Timers returns the number of channels returned by After that did not fire yet
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// WaitForTimers blocks until at least n channels returned by After wait for the clock, e.g. until the store's
+// background jobs are all waiting for their next run, so that a following Advance is seen by every one of them
+func (c *Clock) WaitForTimers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+// advance lets d pass and fires the timers that are due
+// Must be called with mu held
+func (c *Clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.elapsed += d
+
+	pending := c.timers[:0]
+	for _, tm := range c.timers {
+		if tm.deadline > c.elapsed {
+			pending = append(pending, tm)
+			continue
+		}
+		tm.ch <- c.now
+	}
+	c.timers = pending
+}
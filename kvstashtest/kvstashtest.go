@@ -0,0 +1,212 @@
+// Package kvstashtest provides fixtures for tests of code built on a KVStash store
+//
+// Open creates a store in a temporary directory of the test, with a Clock that only moves when the test advances it,
+// and closes it when the test ends:
+//
+//	func TestSession(t *testing.T) {
+//		db := kvstashtest.Open(t, store.Options{})
+//		db.Seed(map[string]string{"user:1": "alice"})
+//		db.Clock.Advance(time.Hour)
+//		db.Compact()
+//		db.Crash()
+//		...
+//	}
+//
+// Failures of the fixtures themselves end the test with Fatal
+package kvstashtest
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/store"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Store is a store in a temporary directory, closed when the test ends
+// The store's methods are available directly; the fixtures below fail the test instead of returning errors
+type Store struct {
+	*store.Store
+
+	// Dir is the database directory, which Crash changes
+	Dir string
+
+	// Clock is the clock of the store, see store.Clock
+	Clock *Clock
+
+	// tb is the test the store belongs to
+	tb testing.TB
+
+	// opts are the options the store was opened with
+	opts store.Options
+}
+
+// Open opens a store in a new temporary directory of tb, configured by opts
+// opts.Clock defaults to a Clock starting at Epoch, and opts.Durability to store.DurabilityNone, since tests rarely
+// need every write flushed
+// The background jobs wait for the Clock: expiry notifications run when it is advanced, segment retention, if
+// opts.Retention is set, when it is advanced a minute, and automatic compaction, if opts.AutoCompact is set, when it
+// is advanced past the compaction interval; Compact runs a cycle right away
+// The store is closed when the test ends
+func Open(tb testing.TB, opts store.Options) *Store {
+	tb.Helper()
+
+	clock, ok := opts.Clock.(*Clock)
+	if opts.Clock == nil {
+		clock = NewClock(Epoch)
+		opts.Clock = clock
+	} else if !ok {
+		tb.Fatalf("Open: opts.Clock must be a *kvstashtest.Clock, got %T", opts.Clock)
+	}
+	if opts.Durability == "" {
+		opts.Durability = store.DurabilityNone
+	}
+	opts.TmpPath = ""
+	opts.BackupPath = ""
+
+	s := &Store{Clock: clock, tb: tb, opts: opts}
+	s.open(filepath.Join(tb.TempDir(), "db"))
+	return s
+}
+
+// open opens the store on the database directory dir, closing it when the test ends
+func (s *Store) open(dir string) {
+	s.tb.Helper()
+
+	kv, err := store.Open(dir, s.opts)
+	if err != nil {
+		s.tb.Fatalf("open: %v", err)
+	}
+	s.tb.Cleanup(func() {
+		kv.Close()
+	})
+
+	s.Store = kv
+	s.Dir = dir
+}
+
+// Seed writes every key and value of pairs
+func (s *Store) Seed(pairs map[string]string) {
+	s.tb.Helper()
+
+	for key, value := range pairs {
+		if _, err := s.SetWithTTL(key, value, -1); err != nil {
+			s.tb.Fatalf("Seed: %v", err)
+		}
+	}
+}
+
+// SeedN writes n keys named prefix followed by their number from 0, each holding "value-" and its number,
+// and returns the keys in order
+func (s *Store) SeedN(prefix string, n int) []string {
+	s.tb.Helper()
+
+	keys := make([]string, n)
+	for i := range n {
+		keys[i] = fmt.Sprintf("%v%d", prefix, i)
+		if _, err := s.SetWithTTL(keys[i], fmt.Sprintf("value-%d", i), -1); err != nil {
+			s.tb.Fatalf("SeedN: %v", err)
+		}
+	}
+	return keys
+}
+
+// Rotate closes the active log and continues in a new segment, see store.Store.Rotate
+func (s *Store) Rotate() {
+	s.tb.Helper()
+
+	if err := s.Store.Rotate(); err != nil {
+		s.tb.Fatalf("Rotate: %v", err)
+	}
+}
+
+// Compact runs a compaction cycle now, see store.Store.Compact
+func (s *Store) Compact() {
+	s.tb.Helper()
+
+	if err := s.Store.Compact(); err != nil {
+		s.tb.Fatalf("Compact: %v", err)
+	}
+}
+
+// Crash simulates a crash of the process: the database files are copied as they are on disk to a new directory,
+// the store is closed, and the copy is opened in its place with the same options and clock, rebuilding the index
+// as a restart would
+// No write may be running during the call
+func (s *Store) Crash() {
+	s.tb.Helper()
+	s.crash(0)
+}
+
+// CrashTorn simulates a crash in the middle of a write, like Crash, with the last n bytes of the active log lost
+func (s *Store) CrashTorn(n int64) {
+	s.tb.Helper()
+	s.crash(n)
+}
+
+// crash copies the database to a new directory with the last torn bytes of the active log cut off, and reopens it
+func (s *Store) crash(torn int64) {
+	s.tb.Helper()
+
+	activeLog := s.Stats().ActiveLog
+	dir := filepath.Join(s.tb.TempDir(), "db")
+	if err := copyDir(s.Dir, dir); err != nil {
+		s.tb.Fatalf("crash: %v", err)
+	}
+	if torn > 0 {
+		path := filepath.Join(dir, activeLog)
+		info, err := os.Stat(path)
+		if err != nil {
+			s.tb.Fatalf("crash: %v", err)
+		}
+		if err := os.Truncate(path, max(info.Size()-torn, 0)); err != nil {
+			s.tb.Fatalf("crash: %v", err)
+		}
+	}
+
+	if err := s.Store.Close(); err != nil {
+		s.tb.Fatalf("crash: %v", err)
+	}
+	s.open(dir)
+}
+
+// copyDir copies the regular files of src to a new directory dst
+func copyDir(src string, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("copyDir: %w", err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("copyDir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return fmt.Errorf("copyDir: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file src to dst
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,194 @@
+// Package logging writes the server's leveled, structured log through log/slog
+//
+// Lines are written with Debugf, Infof, Warnf, and Errorf, which format the message like log.Printf and drop it below
+// the level set with SetLevel. Lines that are emitted for every key touched (writes, deletes, index rebuild) are
+// debug lines. The level can be changed at any time, e.g. on configuration reload.
+//
+// Until Setup is called the lines go to slog.Default, so an application embedding the store keeps control of its
+// logs. Setup installs a text or JSON handler as the default, which the standard log package then writes through
+// too, at LevelInfo.
+//
+// Fields attached to a context with WithFields, such as the ID of the request being served, are added to every line
+// written through From(ctx).
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Level names accepted by SetLevel
+const (
+	// LevelDebug shows every log line, including per-key lines
+	LevelDebug = "debug"
+
+	// LevelInfo hides per-key lines (default)
+	LevelInfo = "info"
+
+	// LevelWarn shows only the lines about something going wrong
+	LevelWarn = "warn"
+
+	// LevelError shows only failures
+	LevelError = "error"
+)
+
+// Output formats accepted by Setup
+const (
+	// FormatText writes key=value pairs, one line per record (default)
+	FormatText = "text"
+
+	// FormatJSON writes one JSON object per record
+	FormatJSON = "json"
+)
+
+// level is the lowest level written
+var level slog.LevelVar
+
+// handler is the handler installed by Setup, nil to write through slog.Default
+var handler atomic.Pointer[slog.Handler]
+
+// ParseLevel returns the slog level named by LevelDebug, LevelInfo, LevelWarn, or LevelError
+func ParseLevel(name string) (slog.Level, error) {
+	switch name {
+	case LevelDebug:
+		return slog.LevelDebug, nil
+	case LevelInfo:
+		return slog.LevelInfo, nil
+	case LevelWarn:
+		return slog.LevelWarn, nil
+	case LevelError:
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("ParseLevel: unknown log level %q (expected %q, %q, %q, or %q)",
+		name, LevelDebug, LevelInfo, LevelWarn, LevelError)
+}
+
+// SetLevel sets the lowest level written to LevelDebug, LevelInfo, LevelWarn, or LevelError
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return fmt.Errorf("SetLevel: %w", err)
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level returns the name of the lowest level written
+func Level() string {
+	switch l := level.Level(); {
+	case l <= slog.LevelDebug:
+		return LevelDebug
+	case l <= slog.LevelInfo:
+		return LevelInfo
+	case l <= slog.LevelWarn:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// Setup writes the log to w in format, FormatText or FormatJSON, and makes it the slog and standard log default
+// Returns an error for an unknown format, in which case the output is left as it was
+func Setup(w io.Writer, format string) error {
+	options := &slog.HandlerOptions{Level: &level}
+	var h slog.Handler
+	switch format {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("Setup: unknown log format %q (expected %q or %q)", format, FormatText, FormatJSON)
+	}
+
+	handler.Store(&h)
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fieldsKey is the context key of the fields added by WithFields
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx whose log lines written through From carry the given fields, key-value pairs
+// like the arguments of slog.Logger.Info, after those already attached to ctx
+func WithFields(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	record := slog.NewRecord(time.Time{}, 0, "", 0)
+	record.Add(args...)
+	merged := make([]slog.Attr, 0, len(fields)+record.NumAttrs())
+	merged = append(merged, fields...)
+	record.Attrs(func(a slog.Attr) bool {
+		merged = append(merged, a)
+		return true
+	})
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// Logger writes log lines carrying the fields of a context, see From
+type Logger struct {
+	ctx    context.Context
+	fields []slog.Attr
+}
+
+// From returns a Logger adding the fields attached to ctx by WithFields to every line
+func From(ctx context.Context) *Logger {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	return &Logger{ctx: ctx, fields: fields}
+}
+
+// base writes lines without fields
+var base = &Logger{ctx: context.Background()}
+
+// logf formats and writes a line at l if the level lets it through
+func (lg *Logger) logf(l slog.Level, format string, args ...any) {
+	if l < level.Level() {
+		return
+	}
+	h := slog.Default().Handler()
+	if installed := handler.Load(); installed != nil {
+		h = *installed
+	}
+	if !h.Enabled(lg.ctx, l) {
+		return
+	}
+
+	record := slog.NewRecord(time.Now(), l, fmt.Sprintf(format, args...), 0)
+	record.AddAttrs(lg.fields...)
+	_ = h.Handle(lg.ctx, record)
+}
+
+// Debugf writes a line at LevelDebug
+func (lg *Logger) Debugf(format string, args ...any) { lg.logf(slog.LevelDebug, format, args...) }
+
+// Infof writes a line at LevelInfo
+func (lg *Logger) Infof(format string, args ...any) { lg.logf(slog.LevelInfo, format, args...) }
+
+// Warnf writes a line at LevelWarn
+func (lg *Logger) Warnf(format string, args ...any) { lg.logf(slog.LevelWarn, format, args...) }
+
+// Errorf writes a line at LevelError
+func (lg *Logger) Errorf(format string, args ...any) { lg.logf(slog.LevelError, format, args...) }
+
+// Debugf writes a line at LevelDebug, for per-key lines and other detail
+func Debugf(format string, args ...any) { base.logf(slog.LevelDebug, format, args...) }
+
+// Infof writes a line at LevelInfo, for the normal operation of the server
+func Infof(format string, args ...any) { base.logf(slog.LevelInfo, format, args...) }
+
+// Warnf writes a line at LevelWarn, for something unexpected that the server handled
+func Warnf(format string, args ...any) { base.logf(slog.LevelWarn, format, args...) }
+
+// Errorf writes a line at LevelError, for a failure that lost work or needs an operator
+func Errorf(format string, args ...any) { base.logf(slog.LevelError, format, args...) }
+
+// Fatalf writes a line at LevelError whatever the level, and exits with status 1
+func Fatalf(format string, args ...any) {
+	level.Set(min(level.Level(), slog.LevelError))
+	base.logf(slog.LevelError, format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,281 @@
+// Package logrotate implements a log file writer with size and time based rotation and retention
+//
+// It is meant to be installed as the output of the standard logger:
+//
+//	w, err := logrotate.Open("kvstash.log", logrotate.Options{MaxSize: 100 << 20, MaxBackups: 7})
+//	if err != nil { ... }
+//	defer w.Close()
+//	log.SetOutput(w)
+//
+// When the active file would exceed MaxSize, or has been open for RotateEvery, it is renamed to
+// <path>.<timestamp> and a new file is started. Rotated files beyond MaxBackups or older than MaxAge are removed
+package logrotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timestampFormat is the suffix appended to rotated files; it sorts chronologically
+const timestampFormat = "20060102-150405"
+
+// Options configures rotation and retention
+// A zero field disables the corresponding rule
+type Options struct {
+	// MaxSize is the size in bytes after which the file is rotated
+	MaxSize int64
+
+	// RotateEvery is the maximum time a file stays active before it is rotated
+	RotateEvery time.Duration
+
+	// MaxBackups is the number of rotated files to keep
+	MaxBackups int
+
+	// MaxAge is the maximum age of a rotated file before it is removed
+	MaxAge time.Duration
+}
+
+// Writer is an io.Writer appending to a log file and rotating it according to its Options
+// It is safe for concurrent use
+type Writer struct {
+	// path is the path of the active log file
+	path string
+
+	// opts holds the rotation and retention rules
+	opts Options
+
+	// mu serializes writes and rotations
+	mu sync.Mutex
+
+	// file is the active log file
+	file *os.File
+
+	// size is the current size of the active log file
+	size int64
+
+	// openedAt is when the active log file was started, used for time based rotation
+	openedAt time.Time
+}
+
+// Open opens (or creates) the log file at path for appending
+// Rotated files left over from earlier runs are subject to retention right away
+func Open(path string, opts Options) (*Writer, error) {
+	w := &Writer{path: path, opts: opts}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("Open: failed to create log directory: %w", err)
+	}
+
+	if err := w.openFile(); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+
+	w.removeExpired()
+	return w, nil
+}
+
+// Write appends p to the active log file, rotating first if p would not fit or the file is due for rotation
+// A single write is never split across files
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, fmt.Errorf("Write: log file is closed")
+	}
+
+	if w.due(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "logrotate: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("Write: %w", err)
+	}
+
+	return n, nil
+}
+
+// Rotate rotates the log file immediately, e.g. in response to a signal
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("Rotate: log file is closed")
+	}
+
+	if err := w.rotate(); err != nil {
+		return fmt.Errorf("Rotate: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the active log file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+
+	return nil
+}
+
+// due reports whether the active file must be rotated before writing n more bytes
+// An empty file is never rotated, so a line larger than MaxSize still gets written
+func (w *Writer) due(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+
+	if w.opts.MaxSize > 0 && w.size+n > w.opts.MaxSize {
+		return true
+	}
+
+	return w.opts.RotateEvery > 0 && time.Since(w.openedAt) >= w.opts.RotateEvery
+}
+
+// openFile opens the active log file for appending
+func (w *Writer) openFile() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("openFile: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("openFile: failed to stat %v: %w", w.path, err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+// rotate renames the active file with a timestamp suffix, starts a new one, and applies retention
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("rotate: failed to close %v: %w", w.path, err)
+	}
+
+	// Several rotations within a second get a counter so no rotated file is overwritten
+	rotated := w.path + "." + time.Now().Format(timestampFormat)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%v.%v.%d", w.path, time.Now().Format(timestampFormat), i)
+	}
+
+	renameErr := os.Rename(w.path, rotated)
+
+	// Reopen even if the rename failed, so logging continues
+	if err := w.openFile(); err != nil {
+		return fmt.Errorf("rotate: %w", err)
+	}
+	if renameErr != nil {
+		return fmt.Errorf("rotate: failed to rename %v: %w", w.path, renameErr)
+	}
+
+	w.removeExpired()
+	return nil
+}
+
+// backups returns the rotated files of the log, newest first
+func (w *Writer) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("backups: %w", err)
+	}
+
+	// backup is a rotated file with its parsed timestamp and same-second counter
+	type backup struct {
+		name    string
+		stamp   string
+		counter int
+	}
+
+	backups := []backup{}
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, w.path+".")
+		stamp, counter, _ := strings.Cut(suffix, ".")
+		if _, err := time.Parse(timestampFormat, stamp); err != nil {
+			continue
+		}
+
+		b := backup{name: m, stamp: stamp}
+		if counter != "" {
+			n, err := strconv.Atoi(counter)
+			if err != nil {
+				continue
+			}
+			b.counter = n
+		}
+		backups = append(backups, b)
+	}
+
+	// Timestamps sort chronologically as strings
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].stamp != backups[j].stamp {
+			return backups[i].stamp > backups[j].stamp
+		}
+		return backups[i].counter > backups[j].counter
+	})
+
+	names := make([]string, 0, len(backups))
+	for _, b := range backups {
+		names = append(names, b.name)
+	}
+
+	return names, nil
+}
+
+// removeExpired deletes rotated files beyond MaxBackups or older than MaxAge
+// Failures are reported on stderr, since the log itself may be what is failing
+func (w *Writer) removeExpired() {
+	if w.opts.MaxBackups <= 0 && w.opts.MaxAge <= 0 {
+		return
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logrotate: %v\n", err)
+		return
+	}
+
+	for i, backup := range backups {
+		expired := w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups
+		if !expired && w.opts.MaxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > w.opts.MaxAge {
+				expired = true
+			}
+		}
+
+		if expired {
+			if err := os.Remove(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "logrotate: failed to remove %v: %v\n", backup, err)
+			}
+		}
+	}
+}
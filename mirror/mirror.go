@@ -0,0 +1,236 @@
+// Package mirror replays a sample of the writes a server receives against a secondary KVStash endpoint
+//
+// Mirroring tests a new version, engine, or machine with production-shaped load without putting it in the path of
+// clients: the primary answers every request as usual, and a copy of a sampled request is sent to the secondary
+// afterwards. The secondary's answer is only compared with the primary's: a different status code counts as a
+// divergence and is logged with the request ID, so the two servers' logs can be matched.
+//
+// Mirroring is asynchronous and best effort: requests wait in a bounded queue and are sent one at a time, in the
+// order the primary answered them, and requests arriving while the queue is full are dropped. A sampled write
+// depends on writes that were not sampled (a delete of a key whose write was skipped, a conditional batch), so
+// divergences are only expected to be zero when every write is mirrored to a secondary that started from the same
+// data
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBadOptions is returned by New for a target that is not an absolute http or https URL or a percentage
+// outside (0, 100]
+var ErrBadOptions = errors.New("invalid mirror options")
+
+// Options configures a Mirror
+type Options struct {
+	// Target is the base URL of the secondary, e.g. "http://kv-canary:8080"
+	Target string
+
+	// Percent is the percentage of writes mirrored, in (0, 100]
+	Percent float64
+
+	// Token is sent as a bearer token to secondaries requiring authentication (default: none)
+	Token string
+}
+
+// Request is a write answered by the primary, to be replayed against the secondary
+type Request struct {
+	// Method is the HTTP method
+	Method string
+
+	// URI is the path and query of the request, e.g. "/kvstash/json?key=doc"
+	URI string
+
+	// Header holds the headers copied to the mirrored request: Content-Type, Idempotency-Key, and X-Request-ID
+	Header http.Header
+
+	// Body is the request body
+	Body []byte
+
+	// Status is the status code the primary answered with
+	Status int
+}
+
+// Stats counts the writes handled by a Mirror
+type Stats struct {
+	// Target is the base URL of the secondary
+	Target string
+
+	// Percent is the percentage of writes mirrored
+	Percent float64
+
+	// Mirrored is the number of mirrored requests the secondary answered
+	Mirrored uint64
+
+	// Diverged is the number of mirrored requests the secondary answered with another status than the primary
+	Diverged uint64
+
+	// Failed is the number of mirrored requests the secondary could not be reached for or did not answer in time
+	Failed uint64
+
+	// Dropped is the number of sampled writes not mirrored because the queue was full or the body too large
+	Dropped uint64
+}
+
+// Mirror sends sampled writes to a secondary
+// It is safe for concurrent use
+type Mirror struct {
+	// opts are the validated options
+	opts Options
+
+	// queue holds the requests waiting to be mirrored
+	queue chan Request
+
+	// done is closed when the sending goroutine exits
+	done chan struct{}
+
+	// closeOnce guards closing queue
+	closeOnce sync.Once
+
+	// client sends the mirrored requests
+	client *http.Client
+
+	// mirrored, diverged, failed, and dropped are the counters reported by Stats
+	mirrored atomic.Uint64
+	diverged atomic.Uint64
+	failed   atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// New creates a mirror sending to opts.Target and starts its sending goroutine, which Close stops
+// Returns ErrBadOptions if the target or percentage is invalid
+func New(opts Options) (*Mirror, error) {
+	u, err := url.Parse(opts.Target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("New: %w: target must be an absolute http or https URL, got %q", ErrBadOptions, opts.Target)
+	}
+	if !(opts.Percent > 0 && opts.Percent <= 100) {
+		return nil, fmt.Errorf("New: %w: percent must be in (0, 100], got %v", ErrBadOptions, opts.Percent)
+	}
+	opts.Target = strings.TrimRight(opts.Target, "/")
+
+	m := &Mirror{
+		opts:   opts,
+		queue:  make(chan Request, constants.MirrorQueueSize),
+		done:   make(chan struct{}),
+		client: &http.Client{Timeout: constants.MirrorTimeout * time.Second},
+	}
+
+	go m.run()
+	return m, nil
+}
+
+// Sample reports whether the next write should be mirrored, true for Percent percent of calls
+func (m *Mirror) Sample() bool {
+	return m.opts.Percent >= 100 || rand.Float64()*100 < m.opts.Percent
+}
+
+// Send queues req to be mirrored without blocking; it is dropped if the queue is full
+func (m *Mirror) Send(req Request) {
+	select {
+	case m.queue <- req:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+// Drop counts a sampled write that was not mirrored, e.g. because its body was too large
+func (m *Mirror) Drop() {
+	m.dropped.Add(1)
+}
+
+// Stats returns the mirror's counters
+func (m *Mirror) Stats() Stats {
+	return Stats{
+		Target:   m.opts.Target,
+		Percent:  m.opts.Percent,
+		Mirrored: m.mirrored.Load(),
+		Diverged: m.diverged.Load(),
+		Failed:   m.failed.Load(),
+		Dropped:  m.dropped.Load(),
+	}
+}
+
+// Close sends the queued requests and stops the mirror, waiting at most timeout
+// Send must not be called after Close
+func (m *Mirror) Close(timeout time.Duration) {
+	m.closeOnce.Do(func() {
+		close(m.queue)
+	})
+
+	select {
+	case <-m.done:
+	case <-time.After(timeout):
+		logging.Warnf("Close: gave up mirroring %d queued requests", len(m.queue))
+	}
+}
+
+// run mirrors queued requests until the queue is closed
+func (m *Mirror) run() {
+	defer close(m.done)
+
+	for req := range m.queue {
+		status, err := m.send(req)
+		if err != nil {
+			m.failed.Add(1)
+			logging.Warnf("run: failed to mirror %v %v: %v", req.Method, path(req.URI), err)
+			continue
+		}
+
+		m.mirrored.Add(1)
+		if status != req.Status {
+			m.diverged.Add(1)
+			logging.Warnf("run: %v %v (request %v) diverged: primary answered %d, secondary %d",
+				req.Method, path(req.URI), req.Header.Get("X-Request-ID"), req.Status, status)
+		}
+	}
+}
+
+// send replays req against the secondary and returns the status code it answered with
+func (m *Mirror) send(req Request) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.MirrorTimeout*time.Second)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, m.opts.Target+req.URI, bytes.NewReader(req.Body))
+	if err != nil {
+		return 0, fmt.Errorf("send: %w", err)
+	}
+	for name, values := range req.Header {
+		httpReq.Header[name] = values
+	}
+	if m.opts.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+m.opts.Token)
+	}
+
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		// The error quotes the URL, whose query may hold a key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, fmt.Errorf("send: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// path returns uri without its query, which may hold a key, for logging
+func path(uri string) string {
+	p, _, _ := strings.Cut(uri, "?")
+	return p
+}
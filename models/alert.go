@@ -0,0 +1,74 @@
+package models
+
+// Alert events raised for conditions that need an operator's attention
+const (
+	// AlertCorruption is raised when a record fails its checksum
+	AlertCorruption = "corruption"
+
+	// AlertCompactionFailed is raised when a compaction cycle fails and the old database is kept
+	AlertCompactionFailed = "compaction_failed"
+
+	// AlertCompactionRecovered is raised when a compaction cycle succeeds after a failed one
+	AlertCompactionRecovered = "compaction_recovered"
+
+	// AlertBackupRestored is raised when the database was restored from its backup after a crash during compaction
+	AlertBackupRestored = "backup_restored"
+
+	// AlertDiskFull is raised when free disk space drops below the minimum and writes are refused
+	AlertDiskFull = "disk_full"
+
+	// AlertDiskRecovered is raised when free disk space is back above the minimum
+	AlertDiskRecovered = "disk_recovered"
+
+	// AlertBreakerTripped is raised when repeated storage errors make the store read-only
+	AlertBreakerTripped = "breaker_tripped"
+
+	// AlertWritesResumed is raised when writes are re-enabled after the breaker tripped
+	AlertWritesResumed = "writes_resumed"
+
+	// AlertWriterFailover is raised when a failed write sealed the active log and writes continue in a new segment
+	AlertWriterFailover = "writer_failover"
+
+	// AlertClockSkew is raised when the system clock jumps and expiry follows the elapsed time instead
+	AlertClockSkew = "clock_skew"
+
+	// AlertClockRecovered is raised when the system clock is back in step after a jump
+	AlertClockRecovered = "clock_recovered"
+
+	// AlertIndexDivergence is raised when index entries disagree with the records they point at, see
+	// Store.CheckIndex
+	AlertIndexDivergence = "index_divergence"
+
+	// AlertIndexRebuilt is raised when the index was rebuilt from the segments after it diverged
+	AlertIndexRebuilt = "index_rebuilt"
+
+	// AlertTest is sent on demand to check the alert configuration
+	AlertTest = "test"
+)
+
+// Alert severities
+const (
+	// SeverityCritical needs attention
+	SeverityCritical = "critical"
+
+	// SeverityResolved reports that an earlier critical condition cleared
+	SeverityResolved = "resolved"
+)
+
+// KVStashAlert is a critical event, or its resolution, posted to the alert webhooks
+type KVStashAlert struct {
+	// Event is the condition, e.g. AlertDiskFull
+	Event string `json:"event"`
+
+	// Severity is SeverityCritical or SeverityResolved
+	Severity string `json:"severity"`
+
+	// Message describes the event; keys are hashed in privacy mode
+	Message string `json:"message"`
+
+	// Time is the RFC 3339 time the event occurred
+	Time string `json:"time"`
+
+	// Host is the name of the machine running the server
+	Host string `json:"host,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ChecksumSHA256 is the algorithm of value checksums: the SHA-256 of the value's bytes, hex-encoded
+const ChecksumSHA256 = "sha256"
+
+// KVStashChecksum is the checksum of a value returned by a read, so clients can verify the value they received
+// independently of the server's own checks
+type KVStashChecksum struct {
+	// Algorithm is the checksum algorithm, ChecksumSHA256
+	Algorithm string `json:"algorithm"`
+
+	// Digest is the hex-encoded checksum
+	Digest string `json:"digest"`
+}
+
+// ValueChecksum returns the checksum of value
+func ValueChecksum(value string) *KVStashChecksum {
+	sum := sha256.Sum256([]byte(value))
+	return &KVStashChecksum{Algorithm: ChecksumSHA256, Digest: hex.EncodeToString(sum[:])}
+}
+
+// Matches reports whether c is the checksum of value; checksums of an unknown algorithm never match
+func (c *KVStashChecksum) Matches(value string) bool {
+	return c.Algorithm == ChecksumSHA256 && strings.EqualFold(c.Digest, ValueChecksum(value).Digest)
+}
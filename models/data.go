@@ -0,0 +1,389 @@
+// Package models defines data structures for KVStash API requests, responses, and internal storage
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// KVStashRequest represents a key-value pair in API requests
+type KVStashRequest struct {
+	// Key is the unique identifier for the value
+	Key string `json:"key"`
+
+	// Value is the data associated with the key
+	Value string `json:"value"`
+
+	// KeyEncoding is the encoding of Key, "" for a plain string or KeyEncodingBase64
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// ValueEncoding is the encoding of Value and ExpectedValue, "" for a plain string or KeyEncodingBase64 for binary
+	// values; responses base64-encode values that are not valid UTF-8 and set it even if the request did not
+	ValueEncoding string `json:"value_encoding,omitempty"`
+
+	// Checksum is the checksum of Value in read responses, see ValueChecksum; ignored in requests
+	Checksum *KVStashChecksum `json:"checksum,omitempty"`
+
+	// TTL is the number of seconds after which the key expires when it is set, -1 for never;
+	// 0 applies the default TTL of the key's namespace, if any
+	TTL int64 `json:"ttl,omitempty"`
+
+	// ExpireAt is the Unix time in seconds at which the key expires when it is set, instead of a TTL
+	ExpireAt int64 `json:"expire_at,omitempty"`
+
+	// Verify overrides how much of the record a get checks: "full", "metadata", or "none" (default: the server's
+	// read_verification setting)
+	Verify string `json:"verify,omitempty"`
+
+	// ExpectedValue, ExpectedVersion, and ExpectedRevision make a set a compare-and-swap: the value is only written
+	// if the key currently holds this value, has this version checksum, or was written at this revision, see
+	// KVStashCondition; ignored by gets and deletes
+	ExpectedValue    *string `json:"expected_value,omitempty"`
+	ExpectedVersion  string  `json:"expected_version,omitempty"`
+	ExpectedRevision *uint64 `json:"expected_revision,omitempty"`
+
+	// Phases, if set, receives the time the store spent in each phase of the request
+	Phases *KVStashPhases `json:"-"`
+
+	// Subject identifies who sent the request, recorded with deletes, see KVStashDeletion
+	Subject string `json:"-"`
+}
+
+// Conditional reports whether the request is a compare-and-swap, expecting a current value, version, or revision
+func (r *KVStashRequest) Conditional() bool {
+	return r.ExpectedValue != nil || r.ExpectedVersion != "" || r.ExpectedRevision != nil
+}
+
+// Expectation returns the expectations of the request as a condition on its key
+func (r *KVStashRequest) Expectation() KVStashCondition {
+	return KVStashCondition{
+		Key:      r.Key,
+		Value:    r.ExpectedValue,
+		Version:  r.ExpectedVersion,
+		Revision: r.ExpectedRevision,
+	}
+}
+
+// KVStashPhases breaks down the time a store operation took by phase, in milliseconds
+type KVStashPhases struct {
+	// LockMs is the wait for the store lock
+	LockMs float64 `json:"lock_ms"`
+
+	// ReadMs is reading the record from its segment file
+	ReadMs float64 `json:"read_ms,omitempty"`
+
+	// ChecksumMs is decoding the record and verifying its checksum
+	ChecksumMs float64 `json:"checksum_ms,omitempty"`
+
+	// WriteMs is appending to the active log, including the flush to disk with sync durability
+	WriteMs float64 `json:"write_ms,omitempty"`
+}
+
+// KVStashResponse represents the API response structure
+type KVStashResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message"`
+
+	// Data contains the retrieved key-value pair for successful GET requests
+	Data *KVStashRequest `json:"data"`
+
+	// Version identifies the write for successful POST requests and the write of the value for GET requests
+	Version *KVStashVersion `json:"version,omitempty"`
+
+	// Warnings lists the limits a successful POST request came close to, see KVStashWarning
+	Warnings []KVStashWarning `json:"warnings,omitempty"`
+}
+
+// KVStashVersion identifies the write that produced a key's current value
+type KVStashVersion struct {
+	// Revision orders the writes to a key: every write, delete included, gets a higher revision than the writes
+	// before it, across restarts and compactions
+	// Revisions are drawn from one counter for the whole store, so they grow but skip numbers for a single key
+	Revision uint64 `json:"revision"`
+
+	// Epoch and Seq are the position of the write in the changefeed, see /kvstash/watch, empty for reads
+	// Seq grows with every write; a restart starts a new epoch and Seq starts over
+	Epoch string `json:"epoch,omitempty"`
+	Seq   uint64 `json:"seq,omitempty"`
+
+	// Checksum is the hex SHA-256 checksum of the record, which covers the value, segment, and offset
+	Checksum string `json:"checksum"`
+
+	// Segment and Offset locate the value on disk until compaction rewrites it
+	Segment string `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// KVStashMultiGetRequest represents a request to fetch several keys at once
+type KVStashMultiGetRequest struct {
+	// Keys lists the keys to fetch
+	Keys []string `json:"keys"`
+
+	// KeyEncoding is the encoding of Keys and of the keys in the response, "" for plain strings or KeyEncodingBase64
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// ValueEncoding is the encoding of the values in the response, see KVStashRequest.ValueEncoding
+	ValueEncoding string `json:"value_encoding,omitempty"`
+
+	// Verify overrides how much of each record is checked, see KVStashRequest.Verify
+	Verify string `json:"verify,omitempty"`
+}
+
+// KVStashMultiGetResponse represents the API response of a multi-get request
+type KVStashMultiGetResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message"`
+
+	// Data contains the key-value pairs that were found; missing keys are omitted
+	Data []KVStashRequest `json:"data"`
+
+	// Missing lists the requested keys that were not found, in request order and encoded like the request's keys
+	Missing []string `json:"missing"`
+}
+
+// KVStashRangeRequest represents a request for the keys k with Start <= k < End, in ascending order
+type KVStashRangeRequest struct {
+	// Start is the first key of the range, "" for the first key of the store
+	Start string `json:"start"`
+
+	// End is the key the range stops before, "" for no upper bound
+	End string `json:"end"`
+
+	// Limit is the maximum number of keys returned, at most constants.MaxBatchKeys (default: constants.RangeLimit)
+	Limit int `json:"limit,omitempty"`
+
+	// KeyEncoding is the encoding of Start, End, and the keys in the response, "" for plain strings or
+	// KeyEncodingBase64; with "", keys that are not valid UTF-8 are returned base64-encoded with their own KeyEncoding
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// ValueEncoding is the encoding of the values in the response, see KVStashRequest.ValueEncoding
+	ValueEncoding string `json:"value_encoding,omitempty"`
+}
+
+// KVStashRangeResponse represents the API response of a range request
+type KVStashRangeResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message"`
+
+	// Data contains the key-value pairs of the range in ascending key order; lists, sets, and hashes are omitted
+	Data []KVStashRequest `json:"data"`
+
+	// Next is the key to send as Start to get the rest of the range, omitted if the range was exhausted
+	Next string `json:"next,omitempty"`
+
+	// NextEncoding is the encoding of Next, see KVStashRequest.KeyEncoding
+	NextEncoding string `json:"next_encoding,omitempty"`
+}
+
+// KVStashBatchRequest represents a conditional batch: the writes are applied only if every check holds
+type KVStashBatchRequest struct {
+	// Checks are the preconditions of the batch
+	Checks []KVStashCondition `json:"checks"`
+
+	// Writes are applied in order, all of them or none
+	Writes []KVStashBatchWrite `json:"writes"`
+
+	// KeyEncoding is the encoding of the keys of checks and writes, "" for plain strings or KeyEncodingBase64
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// ValueEncoding is the encoding of the values of checks and writes, see KVStashRequest.ValueEncoding
+	ValueEncoding string `json:"value_encoding,omitempty"`
+}
+
+// KVStashCondition is a precondition of a batch; every field that is set must hold
+type KVStashCondition struct {
+	// Key is the key the condition applies to
+	Key string `json:"key"`
+
+	// Exists requires the key to exist (true) or not to exist (false)
+	Exists *bool `json:"exists,omitempty"`
+
+	// Version requires the key's current value to have this checksum, see KVStashVersion.Checksum
+	Version string `json:"version,omitempty"`
+
+	// Revision requires the key's current value to have been written at this revision, see KVStashVersion.Revision
+	// Unlike Version it tells a rewrite of the same value apart
+	Revision *uint64 `json:"revision,omitempty"`
+
+	// Value requires the key to hold this string value
+	Value *string `json:"value,omitempty"`
+}
+
+// KVStashBatchWrite is a write of a batch, setting or deleting a key
+type KVStashBatchWrite struct {
+	// Key is the key written
+	Key string `json:"key"`
+
+	// Value is the value set, ignored by deletes
+	Value string `json:"value,omitempty"`
+
+	// TTL is the time to live of the value in seconds, see KVStashRequest.TTL
+	TTL int64 `json:"ttl,omitempty"`
+
+	// ExpireAt is the Unix time in seconds at which the value expires, see KVStashRequest.ExpireAt
+	ExpireAt int64 `json:"expire_at,omitempty"`
+
+	// Delete deletes the key instead of setting it
+	Delete bool `json:"delete,omitempty"`
+}
+
+// KVStashBatchResponse represents the API response of a batch
+type KVStashBatchResponse struct {
+	// Success indicates whether the batch was applied
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message"`
+
+	// Failed lists the indexes of the checks that did not hold
+	Failed []int `json:"failed,omitempty"`
+
+	// Versions holds the version of every write of an applied batch, null for deletes
+	Versions []*KVStashVersion `json:"versions,omitempty"`
+
+	// Warnings lists the limits the writes of an applied batch came close to, see KVStashWarning
+	Warnings []KVStashWarning `json:"warnings,omitempty"`
+}
+
+// KVStashRenameRequest moves the value of a key to another key
+type KVStashRenameRequest struct {
+	// Key is the key whose value is moved
+	Key string `json:"key"`
+
+	// NewKey is the key the value is moved to
+	NewKey string `json:"new_key"`
+
+	// Replace allows replacing the value of NewKey if it exists
+	Replace bool `json:"replace,omitempty"`
+
+	// KeyEncoding is the encoding of Key and NewKey, "" for plain strings or KeyEncodingBase64
+	KeyEncoding string `json:"key_encoding,omitempty"`
+}
+
+// KVStashExpireRequest changes the expiry time of a key without rewriting its value
+type KVStashExpireRequest struct {
+	// Key is the key whose expiry time is changed
+	Key string `json:"key"`
+
+	// TTL is the number of seconds from now after which the key expires, -1 for never
+	TTL int64 `json:"ttl,omitempty"`
+
+	// ExpireAt is the Unix time in seconds at which the key expires, instead of a TTL
+	ExpireAt int64 `json:"expire_at,omitempty"`
+
+	// KeyEncoding is the encoding of Key, "" for a plain string or KeyEncodingBase64
+	KeyEncoding string `json:"key_encoding,omitempty"`
+}
+
+// KVStashCollectionRequest represents an operation on a list, set, or hash
+type KVStashCollectionRequest struct {
+	// Op is the operation: lpush, rpush, lpop, lrange, llen, sadd, srem, smembers, sismember,
+	// hset, hget, hdel, hgetall, or type
+	Op string `json:"op"`
+
+	// Key is the key holding the collection
+	Key string `json:"key"`
+
+	// KeyEncoding is the encoding of Key, "" for a plain string or KeyEncodingBase64
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// Values are the list elements, set members, or hash field names the operation applies to
+	Values []string `json:"values,omitempty"`
+
+	// Fields are the hash fields and values written by hset
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Start is the index of the first list element returned by lrange (default 0)
+	Start int `json:"start,omitempty"`
+
+	// Stop is the index of the last list element returned by lrange; negative indexes count from the end
+	// (default -1, the last element)
+	Stop *int `json:"stop,omitempty"`
+}
+
+// KVStashCollectionResponse represents the API response of a collection operation
+type KVStashCollectionResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message"`
+
+	// Count is the list length (push, llen), or the number of members or fields added or removed
+	Count int `json:"count"`
+
+	// Value is the element returned by lpop, the field value returned by hget, or the type returned by type
+	Value string `json:"value,omitempty"`
+
+	// Values are the list elements returned by lrange or the set members returned by smembers
+	Values []string `json:"values,omitempty"`
+
+	// Fields are the hash fields returned by hgetall
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Member reports whether the value is in the set, for sismember
+	Member bool `json:"member,omitempty"`
+}
+
+// KVStashJSONResponse represents the API response of a JSON document operation
+type KVStashJSONResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message"`
+
+	// Data is the value at the requested path, for GET requests
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// KVStashSessionRequest represents a request to the session store
+type KVStashSessionRequest struct {
+	// ID is the session, for every request but creating one
+	ID string `json:"id,omitempty"`
+
+	// Data is the application data of a new session, or replaces the data of a refreshed one if it is set
+	Data *string `json:"data,omitempty"`
+
+	// TTL is the time to live of a new or refreshed session in seconds (default: 1800)
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// KVStashSession is a session of the session store
+type KVStashSession struct {
+	// ID identifies the session; it is a secret, like a password
+	ID string `json:"id"`
+
+	// Data is the application data of the session
+	Data string `json:"data"`
+
+	// CreatedAt is when the session was created
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is when the session expires unless it is refreshed
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Revision is the revision of the last write to the session, see KVStashVersion.Revision
+	Revision uint64 `json:"revision"`
+}
+
+// KVStashSessionResponse represents the API response of the session store
+type KVStashSessionResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message"`
+
+	// Session is the created, validated, or refreshed session
+	Session *KVStashSession `json:"session,omitempty"`
+}
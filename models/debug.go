@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// KVStashDebugInternals is the response of GET /debug/kvstash on the debug listener
+type KVStashDebugInternals struct {
+	// DataDir is the database directory
+	DataDir string `json:"data_dir"`
+
+	// ActiveLog is the segment written to and ActiveLogOffset the offset the next record is written at, empty and 0
+	// while the store has no writer
+	ActiveLog       string `json:"active_log"`
+	ActiveLogOffset int64  `json:"active_log_offset"`
+
+	// ActiveLogCount is the number of records written to the active log
+	ActiveLogCount int `json:"active_log_count"`
+
+	// SegmentStart and SegmentStartOffset are when and at which offset the store started writing to the active log
+	SegmentStart       time.Time `json:"segment_start"`
+	SegmentStartOffset int64     `json:"segment_start_offset"`
+
+	// HeldBytes is the size of the records of a batch buffered by the writer and not written yet
+	HeldBytes int `json:"held_bytes"`
+
+	// SegmentCount is the number of segments the store keeps count of, not read from the database directory like
+	// the segments of the stats, and NextSegment the number of the segment the next rotation creates
+	SegmentCount int `json:"segment_count"`
+	NextSegment  int `json:"next_segment"`
+
+	// IndexEntries is the number of index entries, live and deleted, and ExpiryQueue the number of them waiting for
+	// their expiry time
+	IndexEntries int `json:"index_entries"`
+	ExpiryQueue  int `json:"expiry_queue"`
+
+	// Revision is the last revision assigned to a write
+	Revision uint64 `json:"revision"`
+
+	// OpenSnapshots is the number of unreleased snapshots, and Relocating whether the database is being relocated
+	OpenSnapshots int  `json:"open_snapshots"`
+	Relocating    bool `json:"relocating"`
+
+	// Durability is the durability mode of the active log writer
+	Durability string `json:"durability"`
+
+	// IndexSnapshot describes the index snapshot loaded at startup and those written since
+	IndexSnapshot KVStashDebugIndexSnapshot `json:"index_snapshot"`
+
+	// SegmentFilters is the number of sealed segments whose Bloom filter is kept in memory
+	SegmentFilters int `json:"segment_filters"`
+
+	// FilterSkips is the number of segments that scans for a key, by undelete and the deletion history, skipped
+	// because their Bloom filter ruled the key out
+	FilterSkips int64 `json:"filter_skips"`
+
+	// Goroutines is the number of goroutines of the server, and GoMaxProcs the number of CPUs running them at once
+	Goroutines int `json:"goroutines"`
+	GoMaxProcs int `json:"gomaxprocs"`
+
+	// HeapBytes is the memory taken by live and not yet collected heap objects, and GCRuns the number of garbage
+	// collections since the server started
+	HeapBytes uint64 `json:"heap_bytes"`
+	GCRuns    uint32 `json:"gc_runs"`
+}
+
+// KVStashDebugIndexSnapshot describes the index snapshots of the store
+type KVStashDebugIndexSnapshot struct {
+	// IntervalSeconds is the delay between two snapshots, 0 if none are written
+	IntervalSeconds float64 `json:"interval_seconds"`
+
+	// Loaded indicates that the index was loaded from a snapshot at startup, and ReplayedBytes is the size of the
+	// records read after it
+	Loaded        bool  `json:"loaded"`
+	ReplayedBytes int64 `json:"replayed_bytes"`
+
+	// Saves is the number of snapshots written since startup
+	Saves int64 `json:"saves"`
+
+	// LastSave is when the last snapshot was written, omitted if none was, and LastSaveMs how long it took
+	LastSave   *time.Time `json:"last_save,omitempty"`
+	LastSaveMs float64    `json:"last_save_ms"`
+
+	// LastEntries is the number of index entries of the last snapshot written
+	LastEntries int `json:"last_entries"`
+
+	// LastError says why the last snapshot failed, omitted if it succeeded
+	LastError string `json:"last_error,omitempty"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// KVStashDeletion records the deletion of a key, taken from its tombstone
+type KVStashDeletion struct {
+	// Key is the deleted key
+	Key string `json:"key"`
+
+	// KeyEncoding is KeyEncodingBase64 if Key is not valid UTF-8 and was sent base64-encoded, "" otherwise
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// Revision is the revision of the tombstone, which orders the deletion among the writes of the key
+	Revision uint64 `json:"revision"`
+
+	// DeletedAt is when the key was deleted, zero for tombstones written before deletions were timestamped
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+
+	// Event is why the key was deleted: EventDelete or EventEvict
+	Event string `json:"event,omitempty"`
+
+	// By is the subject of the request that deleted the key, empty if it was not authenticated or the key was
+	// deleted by a batch, rename, or eviction
+	By string `json:"by,omitempty"`
+
+	// ArchivedAt is when compaction or segment retention moved the tombstone to the deletion history, zero while
+	// the tombstone is still in the database
+	ArchivedAt time.Time `json:"archived_at,omitzero"`
+}
+
+// KVStashDeletionHistory is the API response listing the deletions of a key
+type KVStashDeletionHistory struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message,omitempty"`
+
+	// Deletions are the deletions of the key, oldest first
+	Deletions []KVStashDeletion `json:"deletions"`
+}
@@ -0,0 +1,43 @@
+package models
+
+// Event types published on the changefeed
+const (
+	// EventSet is published when a key is set
+	EventSet = "set"
+
+	// EventDelete is published when a key is deleted
+	EventDelete = "delete"
+
+	// EventEvict is published when a key is deleted to make room in a full namespace
+	EventEvict = "evict"
+
+	// EventExpire is published when a key reaches its expiry time, at most ExpiryCheckInterval later
+	// It is not published for keys that are overwritten or deleted before they expire
+	EventExpire = "expire"
+
+	// EventExpiry is published when the expiry time of a key is changed without rewriting its value
+	EventExpiry = "expiry"
+)
+
+// KVStashEvent represents a single mutation published on the changefeed
+// Events only carry the key; watchers that need the value read it with a regular Get
+type KVStashEvent struct {
+	// Epoch identifies the changefeed instance; sequence numbers are only comparable within one epoch
+	Epoch string `json:"epoch"`
+
+	// Seq is the position of the event in the changefeed, starting at 1
+	Seq uint64 `json:"seq"`
+
+	// Type is the kind of mutation (EventSet, EventDelete, EventEvict, EventExpire, or EventExpiry)
+	Type string `json:"type"`
+
+	// Key is the mutated key
+	Key string `json:"key"`
+
+	// Revision is the revision of the write, see KVStashVersion.Revision; for EventExpire the revision of the
+	// write that expired
+	Revision uint64 `json:"revision,omitempty"`
+
+	// KeyEncoding is KeyEncodingBase64 if Key is not valid UTF-8 and was sent base64-encoded, "" otherwise
+	KeyEncoding string `json:"key_encoding,omitempty"`
+}
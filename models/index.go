@@ -19,6 +19,27 @@ type KVStashIndexEntry struct {
 
 	// Checksum holds the SHA-256 checksum of the entry (value or tombstone)
 	Checksum [32]byte
+
+	// Type is the kind of value stored, TypeString for values written with Set
+	Type KVStashValueType
+
+	// ExpiresAt is when the key expires in Unix milliseconds, 0 if it never expires
+	// Expired entries are treated like deleted ones and are dropped by compaction
+	ExpiresAt int64
+
+	// Batch indicates the record carries FlagBatch, which is part of its checksum
+	Batch bool
+
+	// Revision is the revision of the write, see KVStashVersion.Revision
+	Revision uint64
+
+	// Transforms holds the IDs of the value transformers applied to the stored value, the first applied in the
+	// lowest byte, 0 if there are none
+	Transforms uint32
+
+	// Inline holds the value when it is small enough to be kept in the index, nil otherwise
+	// Reads that do not ask for a verification level return it without reading the segment file
+	Inline *string
 }
 
 // KVStashIndex is a map from keys to their storage locations
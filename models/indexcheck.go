@@ -0,0 +1,101 @@
+package models
+
+import "time"
+
+// KVStashIndexCheckStats describes the checks of the index against the segments, also the response of
+// GET /kvstash/admin/index-check
+type KVStashIndexCheckStats struct {
+	// IntervalSeconds is the delay between two background checks, 0 if they are disabled
+	IntervalSeconds float64 `json:"interval_seconds"`
+
+	// Sample is the number of index entries a check compares with their records
+	Sample int `json:"sample"`
+
+	// MaxDivergence is the fraction of the sample that may diverge without rebuilding the index
+	MaxDivergence float64 `json:"max_divergence"`
+
+	// Runs is the number of checks, and Divergent the number of divergent entries they found
+	Runs      int64 `json:"runs"`
+	Divergent int64 `json:"divergent"`
+
+	// Rebuilds is the number of times the index was rebuilt
+	Rebuilds int64 `json:"rebuilds"`
+
+	// Last is the last check, omitted if none ran
+	Last *KVStashIndexCheckReport `json:"last,omitempty"`
+
+	// LastRebuild is the last rebuild, omitted if none ran
+	LastRebuild *KVStashReindexReport `json:"last_rebuild,omitempty"`
+}
+
+// KVStashIndexCheckReport is the result of a check of the index against the segments
+type KVStashIndexCheckReport struct {
+	// Time is when the check ran
+	Time time.Time `json:"time"`
+
+	// Checked is the number of index entries compared with their records
+	Checked int `json:"checked"`
+
+	// Divergent is the number of them that disagree
+	Divergent int `json:"divergent"`
+
+	// Divergences details some of the divergent entries
+	Divergences []KVStashIndexDivergence `json:"divergences"`
+
+	// Rebuild is the rebuild of the index that followed, omitted if there was none
+	Rebuild *KVStashReindexReport `json:"rebuild,omitempty"`
+
+	// Error says why the rebuild failed, empty if it succeeded or did not run
+	Error string `json:"error,omitempty"`
+}
+
+// KVStashIndexDivergence is an index entry that disagrees with the record it points at
+type KVStashIndexDivergence struct {
+	// Key is the key of the entry
+	Key string `json:"key"`
+
+	// KeyEncoding is KeyEncodingBase64 if Key is not valid UTF-8 and was sent base64-encoded, "" otherwise
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// Segment and Offset locate the record the entry points at
+	Segment string `json:"segment"`
+	Offset  int64  `json:"offset"`
+
+	// Reason says how they disagree
+	Reason string `json:"reason"`
+}
+
+// KVStashReindexReport is the result of a rebuild of the index from the segments
+type KVStashReindexReport struct {
+	// Start is when the rebuild started
+	Start time.Time `json:"start"`
+
+	// DurationMs is how long the store was locked for the rebuild
+	DurationMs float64 `json:"duration_ms"`
+
+	// Segments is the number of segment files read
+	Segments int `json:"segments"`
+
+	// Keys is the number of entries of the rebuilt index
+	Keys int `json:"keys"`
+
+	// Added, Removed, and Changed count the entries the rebuild added, removed, and pointed at another record
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+// KVStashIndexCheckResponse is the API response of POST /kvstash/admin/index-check and /kvstash/admin/reindex
+type KVStashIndexCheckResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message provides additional information about the operation result
+	Message string `json:"message,omitempty"`
+
+	// Check is the result of the check, omitted for a rebuild requested directly
+	Check *KVStashIndexCheckReport `json:"check,omitempty"`
+
+	// Rebuild is the result of the rebuild, omitted if the index was not rebuilt
+	Rebuild *KVStashReindexReport `json:"rebuild,omitempty"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// KeyEncodingBase64 is the key_encoding of keys sent base64-encoded (standard alphabet, padded) in JSON
+// Keys are arbitrary bytes, but JSON strings can only carry valid UTF-8, so other keys must be encoded
+// An empty key_encoding means the key is sent as a plain JSON string
+const KeyEncodingBase64 = "base64"
+
+// ErrBadKeyEncoding indicates an unknown key_encoding or a key that is not valid in its encoding
+var ErrBadKeyEncoding = errors.New("invalid key encoding")
+
+// ErrBadValueEncoding indicates an unknown value_encoding or a value that is not valid in its encoding
+var ErrBadValueEncoding = errors.New("invalid value encoding")
+
+// KeyEncodingFor returns the encoding needed to carry key in JSON: "" for valid UTF-8, KeyEncodingBase64 otherwise
+func KeyEncodingFor(key string) string {
+	if utf8.ValidString(key) {
+		return ""
+	}
+	return KeyEncodingBase64
+}
+
+// EncodeKey returns key as it is sent in JSON with the given encoding
+func EncodeKey(key string, encoding string) string {
+	if encoding == KeyEncodingBase64 {
+		return base64.StdEncoding.EncodeToString([]byte(key))
+	}
+	return key
+}
+
+// DecodeKey returns the raw key from its JSON form in the given encoding
+// Returns ErrBadKeyEncoding if the encoding is unknown or the key is not valid base64
+func DecodeKey(key string, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return key, nil
+	case KeyEncodingBase64:
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return "", fmt.Errorf("DecodeKey: %w: %v", ErrBadKeyEncoding, err)
+		}
+		return string(raw), nil
+	}
+
+	return "", fmt.Errorf("DecodeKey: %w: unknown encoding %q", ErrBadKeyEncoding, encoding)
+}
+
+// EncodeValue returns value as it is sent in JSON with the given encoding, "" or KeyEncodingBase64; with "", a value
+// that is not valid UTF-8 is base64-encoded anyway
+// Returns the encoded value and its encoding
+func EncodeValue(value string, encoding string) (string, string) {
+	if encoding == "" {
+		encoding = KeyEncodingFor(value)
+	}
+	return EncodeKey(value, encoding), encoding
+}
+
+// DecodeValue returns the raw value from its JSON form in the given encoding, "" or KeyEncodingBase64
+// Returns ErrBadValueEncoding if the encoding is unknown or the value is not valid base64
+func DecodeValue(value string, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return value, nil
+	case KeyEncodingBase64:
+		raw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", fmt.Errorf("DecodeValue: %w: %v", ErrBadValueEncoding, err)
+		}
+		return string(raw), nil
+	}
+
+	return "", fmt.Errorf("DecodeValue: %w: unknown encoding %q", ErrBadValueEncoding, encoding)
+}
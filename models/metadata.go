@@ -0,0 +1,15 @@
+package models
+
+import (
+	"github.com/vi88i/kvstash/codec"
+)
+
+// KVStashMetadata represents the metadata for a log entry
+// It contains information needed to locate and validate stored values
+// The encoding and checksums are implemented by the codec package
+type KVStashMetadata = codec.Metadata
+
+// ComputeMetadataFlag combines flag bit indexes into a flags value
+func ComputeMetadataFlag(flags []int64) int64 {
+	return codec.ComputeMetadataFlag(flags)
+}
@@ -0,0 +1,615 @@
+package models
+
+// KVStashStats is the response of the stats endpoint
+type KVStashStats struct {
+	// UptimeSeconds is the time since the server started
+	UptimeSeconds float64 `json:"uptime_seconds"`
+
+	// Panics is the number of requests whose handler panicked and was recovered
+	Panics uint64 `json:"panics"`
+
+	// Requests holds the request counters and latencies of each operation (get, set, delete, mget)
+	Requests map[string]KVStashOpStats `json:"requests"`
+
+	// StoreLatency maps store operations (get, set, delete, read, update) to the latency of each of their phases
+	// (total, lock, read, checksum, write)
+	StoreLatency map[string]map[string]KVStashLatencyStats `json:"store_latency"`
+
+	// SegmentIO maps segment files to the latency of their flushes to stable storage (sync) and of reading and
+	// writing their records during compaction (compaction_read, compaction_write)
+	SegmentIO map[string]map[string]KVStashLatencyStats `json:"segment_io"`
+
+	// Store summarizes the index and disk usage
+	Store KVStashStoreStats `json:"store"`
+
+	// Compaction describes the automatic compaction activity
+	Compaction KVStashCompactionStats `json:"compaction"`
+
+	// Amplification describes the write and space amplification since the server started
+	Amplification KVStashAmplificationStats `json:"amplification"`
+
+	// Breaker describes the write circuit breaker
+	Breaker KVStashBreakerStats `json:"breaker"`
+
+	// Maintenance describes the maintenance mode set through the admin endpoint
+	Maintenance KVStashMaintenanceStats `json:"maintenance"`
+
+	// Scheduler describes the store's background jobs
+	Scheduler KVStashSchedulerStats `json:"scheduler"`
+
+	// IndexCheck describes the checks of the index against the segments
+	IndexCheck KVStashIndexCheckStats `json:"index_check"`
+
+	// Retention describes the segments dropped by age
+	Retention KVStashRetentionStats `json:"retention"`
+
+	// DeletionHistory describes the log dropped tombstones are archived to, omitted if it is not set
+	DeletionHistory *KVStashDeletionHistoryStats `json:"deletion_history,omitempty"`
+
+	// Clock describes the store's time and clock skew
+	Clock KVStashClockStats `json:"clock"`
+
+	// Limiter describes the concurrency limit of key-value requests
+	Limiter KVStashLimiterStats `json:"limiter"`
+
+	// Alerts counts the alerts posted to the webhooks, omitted if alerting is not set up
+	Alerts *KVStashAlertStats `json:"alerts,omitempty"`
+
+	// Mirror counts the writes mirrored to a secondary, omitted if mirroring is disabled
+	Mirror *KVStashMirrorStats `json:"mirror,omitempty"`
+}
+
+// KVStashOpStats holds the counters and latency percentiles of one operation
+// Percentiles are computed over the most recent requests
+type KVStashOpStats struct {
+	// Count is the total number of requests served
+	Count uint64 `json:"count"`
+
+	// Errors is the number of requests answered with a 5xx status
+	Errors uint64 `json:"errors"`
+
+	// P50Ms, P95Ms, and P99Ms are latency percentiles in milliseconds
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+}
+
+// KVStashLatencyStats summarizes a latency histogram
+// Percentiles are estimated from the histogram buckets over every operation since the server started
+type KVStashLatencyStats struct {
+	// Count is the number of operations that reached the phase
+	Count uint64 `json:"count"`
+
+	// MeanMs is the average latency in milliseconds
+	MeanMs float64 `json:"mean_ms"`
+
+	// P50Ms, P95Ms, and P99Ms are latency percentiles in milliseconds
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+}
+
+// KVStashStoreStats summarizes the store's index and disk usage
+type KVStashStoreStats struct {
+	// DataDir is the database directory
+	DataDir string `json:"data_dir"`
+
+	// Segments is the number of segment files, including the active log
+	Segments int `json:"segments"`
+
+	// ActiveLog is the name of the segment currently written to
+	ActiveLog string `json:"active_log"`
+
+	// SegmentLimit is the number of writes after which the active log is rotated, chosen by segment sizing
+	SegmentLimit int `json:"segment_limit"`
+
+	// RecordBytes and WriteBytesPerSec are the average record size and write rate segment sizing observed,
+	// 0 before a segment was rotated
+	RecordBytes      float64 `json:"record_bytes"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+
+	// LiveKeys and DeletedKeys count the index entries by state
+	LiveKeys    int `json:"live_keys"`
+	DeletedKeys int `json:"deleted_keys"`
+
+	// DiskBytes is the total size of the segment files
+	DiskBytes int64 `json:"disk_bytes"`
+
+	// GarbageRatio is the fraction of DiskBytes not taken up by live keys, which compaction reclaims
+	GarbageRatio float64 `json:"garbage_ratio"`
+
+	// OpenSnapshots is the number of unreleased snapshots
+	OpenSnapshots int `json:"open_snapshots"`
+
+	// DiskFreeBytes is the free space on the database volume, 0 if the low disk watchdog is disabled
+	DiskFreeBytes uint64 `json:"disk_free_bytes"`
+
+	// DiskLow indicates that free space is below the minimum and writes are rejected with 507
+	DiskLow bool `json:"disk_low"`
+
+	// SoftLimitRatio is the fraction of a limit past which writes are answered with warnings, 0 if they are not
+	SoftLimitRatio float64 `json:"soft_limit_ratio"`
+
+	// SoftLimitWarnings counts the warnings by limit, e.g. "value_size", since the server started
+	SoftLimitWarnings map[string]int64 `json:"soft_limit_warnings"`
+
+	// InlineThreshold is the size in bytes up to which values are kept in the index, 0 if none are
+	InlineThreshold int `json:"inline_threshold"`
+
+	// InlineValues and InlineBytes are the number and size of the values kept in the index
+	InlineValues int   `json:"inline_values"`
+	InlineBytes  int64 `json:"inline_bytes"`
+
+	// InlineMemoryBytes is the memory taken by the values kept in the index, overhead included
+	InlineMemoryBytes int64 `json:"inline_memory_bytes"`
+
+	// InlineHits is the number of reads served from the index since the server started
+	InlineHits int64 `json:"inline_hits"`
+
+	// ReadCacheBudget is the memory in bytes the cache of values read from disk may take, 0 if it is disabled
+	ReadCacheBudget int64 `json:"read_cache_budget"`
+
+	// ReadCacheValues and ReadCacheBytes are the number of cached values and the memory they take, overhead included
+	ReadCacheValues int   `json:"read_cache_values"`
+	ReadCacheBytes  int64 `json:"read_cache_bytes"`
+
+	// ReadCacheHits, ReadCacheMisses, and ReadCacheEvicted count the reads served from the cache, the reads of
+	// values not in it, and the values evicted to stay within the budget since the server started
+	ReadCacheHits    int64 `json:"read_cache_hits"`
+	ReadCacheMisses  int64 `json:"read_cache_misses"`
+	ReadCacheEvicted int64 `json:"read_cache_evictions"`
+
+	// ValueTransformers names the transformers applied to the values written, in order
+	ValueTransformers []string `json:"value_transformers"`
+
+	// TransformedKeys is the number of live keys whose value is stored transformed
+	TransformedKeys int `json:"transformed_keys"`
+}
+
+// KVStashCompactionStats describes the automatic compaction activity
+type KVStashCompactionStats struct {
+	// Running indicates that a compaction cycle is in progress
+	Running bool `json:"running"`
+
+	// Runs, Failures, and Skipped count the compaction cycles by outcome
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+	Skipped  int64 `json:"skipped"`
+
+	// LastStart is the RFC 3339 start time of the most recent cycle, empty if none ran yet
+	LastStart string `json:"last_start"`
+
+	// LastDurationMs is the duration of the most recent finished cycle in milliseconds
+	LastDurationMs float64 `json:"last_duration_ms"`
+
+	// LastBytesBefore and LastBytesAfter are the database size before and after the most recent successful cycle
+	LastBytesBefore int64 `json:"last_bytes_before"`
+	LastBytesAfter  int64 `json:"last_bytes_after"`
+
+	// Paused indicates that cycles are skipped, because an operator paused compaction or a pause window is active
+	Paused bool `json:"paused"`
+
+	// PauseReason says why cycles are skipped, empty unless Paused
+	PauseReason string `json:"pause_reason,omitempty"`
+
+	// PausedSince is the RFC 3339 time an operator paused compaction, empty unless paused through the admin endpoint
+	PausedSince string `json:"paused_since,omitempty"`
+}
+
+// KVStashBreakerStats describes the write circuit breaker, which makes the store read-only after repeated storage errors
+type KVStashBreakerStats struct {
+	// Degraded indicates that the breaker tripped and writes are rejected with 503
+	Degraded bool `json:"degraded"`
+
+	// ConsecutiveFailures is the number of failed writes since the last successful one
+	ConsecutiveFailures int `json:"consecutive_failures"`
+
+	// Trips is the number of times the breaker tripped
+	Trips int64 `json:"trips"`
+
+	// DegradedSince is the RFC 3339 time the breaker last tripped, empty if it never did
+	DegradedSince string `json:"degraded_since,omitempty"`
+
+	// LastError is the most recent storage error, empty if none occurred
+	LastError string `json:"last_error,omitempty"`
+
+	// Failovers is the number of times a failed write sealed the active log and writes continued in a new segment
+	Failovers int64 `json:"failovers"`
+
+	// LastFailover is the RFC 3339 time of the last failover, empty if there was none
+	LastFailover string `json:"last_failover,omitempty"`
+}
+
+// KVStashMaintenanceStats describes the maintenance mode, in which writes are rejected with 503 while reads continue
+type KVStashMaintenanceStats struct {
+	// Enabled indicates that the server is in maintenance mode
+	Enabled bool `json:"enabled"`
+
+	// Since is the RFC 3339 time maintenance mode was turned on, empty if it is off
+	Since string `json:"since,omitempty"`
+
+	// Message is the reason given by the operator, empty if none was given
+	Message string `json:"message,omitempty"`
+}
+
+// KVStashMaintenanceRequest turns maintenance mode on or off
+type KVStashMaintenanceRequest struct {
+	// Enabled turns maintenance mode on (true) or off (false)
+	Enabled bool `json:"enabled"`
+
+	// Message is returned to clients whose writes are rejected, such as "nightly backup, back at 02:30"
+	Message string `json:"message,omitempty"`
+}
+
+// KVStashRelocateRequest moves the database to another directory
+type KVStashRelocateRequest struct {
+	// Path is the new database directory, which must not exist or be empty
+	Path string `json:"path"`
+}
+
+// KVStashRelocateResponse is the response of the relocate endpoint
+type KVStashRelocateResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+
+	// From and To are the old and the new database directory
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// Segments and Bytes count the segment files moved and the bytes copied
+	Segments int   `json:"segments,omitempty"`
+	Bytes    int64 `json:"bytes,omitempty"`
+
+	// Rounds is the number of copy rounds run while writes continued
+	Rounds int `json:"rounds,omitempty"`
+
+	// PauseMs is how long writes waited for the final catch up and the switch
+	PauseMs float64 `json:"pause_ms,omitempty"`
+
+	// DurationMs is how long the relocation took
+	DurationMs float64 `json:"duration_ms,omitempty"`
+}
+
+// KVStashSlowLogEntry is a request recorded by the slow query log
+// The phases add up to TotalMs: decoding the request, executing it against the store, and encoding the response
+type KVStashSlowLogEntry struct {
+	// ID increases with every recorded request, so clients can tell which entries they have seen
+	ID uint64 `json:"id"`
+
+	// Time is the RFC 3339 time the request started
+	Time string `json:"time"`
+
+	// RequestID matches the X-Request-ID header returned to the client
+	RequestID string `json:"request_id,omitempty"`
+
+	// Op is the operation (get, set, delete, mget)
+	Op string `json:"op"`
+
+	// Key is the requested key (hashed in privacy mode); for mget it is the first of Keys keys
+	Key string `json:"key,omitempty"`
+
+	// Keys is the number of keys of an mget request
+	Keys int `json:"keys,omitempty"`
+
+	// Status is the HTTP status code of the response
+	Status int `json:"status"`
+
+	// TotalMs is the time spent in the handler in milliseconds
+	TotalMs float64 `json:"total_ms"`
+
+	// DecodeMs, StoreMs, and EncodeMs break TotalMs down by phase
+	DecodeMs float64 `json:"decode_ms"`
+	StoreMs  float64 `json:"store_ms"`
+	EncodeMs float64 `json:"encode_ms"`
+
+	// Phases breaks StoreMs down further for get, set, and delete requests
+	Phases *KVStashPhases `json:"phases,omitempty"`
+}
+
+// KVStashSlowLogResponse is the response of the slow query log endpoint
+type KVStashSlowLogResponse struct {
+	// ThresholdMs is the latency above which requests are recorded
+	ThresholdMs float64 `json:"threshold_ms"`
+
+	// Entries holds the recorded requests, most recent first
+	Entries []KVStashSlowLogEntry `json:"entries"`
+}
+
+// KVStashCompactionRun is a compaction cycle in the compaction history
+type KVStashCompactionRun struct {
+	// Trigger is why the cycle ran, e.g. "interval"
+	Trigger string `json:"trigger"`
+
+	// Start is the RFC 3339 time the cycle started
+	Start string `json:"start"`
+
+	// DurationMs is how long the cycle took in milliseconds
+	DurationMs float64 `json:"duration_ms"`
+
+	// Outcome is "success", "failure", or "skipped"
+	Outcome string `json:"outcome"`
+
+	// SegmentsBefore and SegmentsAfter are the number of segment files before and after the cycle
+	SegmentsBefore int `json:"segments_before"`
+	SegmentsAfter  int `json:"segments_after"`
+
+	// BytesBefore and BytesAfter are the database size before and after the cycle
+	BytesBefore int64 `json:"bytes_before"`
+	BytesAfter  int64 `json:"bytes_after"`
+
+	// BytesReclaimed is BytesBefore minus BytesAfter
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+
+	// Error describes why the cycle failed or was skipped
+	Error string `json:"error,omitempty"`
+}
+
+// KVStashCompactionHistory is the response of the compaction history endpoint
+type KVStashCompactionHistory struct {
+	// Runs holds the most recent compaction cycles, newest first
+	Runs []KVStashCompactionRun `json:"runs"`
+}
+
+// KVStashLimiterStats describes the concurrency limit of key-value requests
+type KVStashLimiterStats struct {
+	// MaxInFlight is the number of requests served concurrently, 0 if unlimited
+	MaxInFlight int `json:"max_inflight"`
+
+	// MaxQueued is the number of requests allowed to wait for a slot
+	MaxQueued int `json:"max_queued"`
+
+	// InFlight is the number of requests being served
+	InFlight int `json:"inflight"`
+
+	// Queued is the number of requests waiting for a slot
+	Queued int `json:"queued"`
+
+	// Rejected is the number of requests rejected with 503 because the queue was full
+	Rejected uint64 `json:"rejected"`
+}
+
+// KVStashAlertStats counts the alerts posted to the alert webhooks
+type KVStashAlertStats struct {
+	// Webhooks is the number of configured webhook URLs
+	Webhooks int `json:"webhooks"`
+
+	// Sent is the number of webhook calls that succeeded
+	Sent uint64 `json:"sent"`
+
+	// Failed is the number of webhook calls that failed after every retry
+	Failed uint64 `json:"failed"`
+
+	// Dropped is the number of alerts discarded because too many were waiting for delivery
+	Dropped uint64 `json:"dropped"`
+}
+
+// KVStashMirrorStats counts the writes mirrored to a secondary endpoint
+type KVStashMirrorStats struct {
+	// Target is the base URL of the secondary
+	Target string `json:"target"`
+
+	// Percent is the percentage of writes mirrored
+	Percent float64 `json:"percent"`
+
+	// Mirrored is the number of mirrored requests the secondary answered
+	Mirrored uint64 `json:"mirrored"`
+
+	// Diverged is the number of mirrored requests the secondary answered with another status than the primary
+	Diverged uint64 `json:"diverged"`
+
+	// Failed is the number of mirrored requests the secondary could not be reached for or did not answer in time
+	Failed uint64 `json:"failed"`
+
+	// Dropped is the number of sampled writes not mirrored because too many were waiting or the body was too large
+	Dropped uint64 `json:"dropped"`
+}
+
+// KVStashSchedulerStats describes the store's background jobs, also the response of GET /kvstash/admin/scheduler
+type KVStashSchedulerStats struct {
+	// Paused indicates that the background jobs are held through the admin endpoint
+	Paused bool `json:"paused"`
+
+	// PausedSince is the RFC 3339 time the scheduler was paused, empty unless paused
+	PausedSince string `json:"paused_since,omitempty"`
+
+	// Jobs describes every background job, by name
+	Jobs []KVStashJobStats `json:"jobs"`
+}
+
+// KVStashJobStats describes a background job
+type KVStashJobStats struct {
+	// Name identifies the job: "compaction", "expiry", "retention", or "index-check"
+	Name string `json:"name"`
+
+	// Runs is the number of times the job ran
+	Runs int64 `json:"runs"`
+
+	// LastRun is the RFC 3339 time the job last ran, empty if it never ran
+	LastRun string `json:"last_run,omitempty"`
+
+	// NextRun is the RFC 3339 time the job runs next unless the scheduler is paused
+	NextRun string `json:"next_run,omitempty"`
+}
+
+// KVStashClockStats describes the store's time, which ignores jumps of the system clock
+type KVStashClockStats struct {
+	// Now is the store's time in RFC 3339 with fractional seconds
+	Now string `json:"now"`
+
+	// Skewed indicates that the system clock jumped and expiry follows the elapsed time instead
+	Skewed bool `json:"skewed"`
+
+	// SkewMs is how far the system clock is ahead of the store's time, negative if it is behind, 0 unless skewed
+	SkewMs float64 `json:"skew_ms"`
+
+	// SkewedSince is the RFC 3339 time the clock jumped, empty unless skewed
+	SkewedSince string `json:"skewed_since,omitempty"`
+
+	// Jumps is the number of jumps of the system clock since the server started
+	Jumps int64 `json:"jumps"`
+
+	// HighWater is the RFC 3339 time last saved to the superblock, which the store does not start before
+	HighWater string `json:"high_water,omitempty"`
+}
+
+// KVStashHealth is the response of GET /kvstash/health
+type KVStashHealth struct {
+	// Status is "ok", "warn" if a check warns, or "fail" if a check fails
+	Status string `json:"status"`
+
+	// Checks holds the result of every check
+	Checks []KVStashHealthCheck `json:"checks"`
+}
+
+// KVStashHealthCheck is the result of a health check
+type KVStashHealthCheck struct {
+	// Name identifies the check: "writes", "disk", or "clock"
+	Name string `json:"name"`
+
+	// Status is "ok", "warn", or "fail"
+	Status string `json:"status"`
+
+	// Message says what is wrong, empty if the status is "ok"
+	Message string `json:"message,omitempty"`
+}
+
+// KVStashRetentionStats describes the segments dropped by age
+type KVStashRetentionStats struct {
+	// RetentionSeconds is the age after which segments are dropped, 0 if they are kept
+	RetentionSeconds float64 `json:"retention_seconds"`
+
+	// SegmentsDropped and KeysDropped count the segments and index entries dropped since the server started
+	SegmentsDropped int64 `json:"segments_dropped"`
+	KeysDropped     int64 `json:"keys_dropped"`
+
+	// OldestSegment is the RFC 3339 time the oldest segment other than the active log was sealed, empty if there
+	// is none
+	OldestSegment string `json:"oldest_segment,omitempty"`
+}
+
+// KVStashDeletionHistoryStats describes the log the tombstones dropped by compaction and segment retention are
+// archived to
+type KVStashDeletionHistoryStats struct {
+	// RetentionSeconds is the age after which deletions are pruned from the log, 0 if they are kept
+	RetentionSeconds float64 `json:"retention_seconds"`
+
+	// Archived counts the tombstones appended to the log since the server started
+	Archived int64 `json:"archived"`
+}
+
+// KVStashAmplificationStats describes the bytes written to disk for the bytes clients wrote, and the disk usage for
+// the live data
+type KVStashAmplificationStats struct {
+	// Writes is the number of records written by clients, tombstones included
+	Writes int64 `json:"writes"`
+
+	// LogicalBytes is the size of the keys and values written by clients
+	LogicalBytes int64 `json:"logical_bytes"`
+
+	// LogBytes, CompactionBytes, and BackupBytes are the bytes written to the active log, to compacted databases,
+	// and to the backup taken before each compaction
+	LogBytes        int64 `json:"log_bytes"`
+	CompactionBytes int64 `json:"compaction_bytes"`
+	BackupBytes     int64 `json:"backup_bytes"`
+
+	// Flushes is the number of flushes of the active log to stable storage, two per record with durability "sync"
+	Flushes int64 `json:"flushes"`
+
+	// WriteAmplification is the bytes written to disk over LogicalBytes
+	WriteAmplification float64 `json:"write_amplification"`
+
+	// LiveBytes is the size of the keys and values of the live keys
+	LiveBytes int64 `json:"live_bytes"`
+
+	// SpaceAmplification is the size of the segment files over LiveBytes
+	SpaceAmplification float64 `json:"space_amplification"`
+}
+
+// KVStashKeyspaceStats is the response of GET /kvstash/admin/keyspace
+type KVStashKeyspaceStats struct {
+	// LiveKeys is the number of live keys
+	LiveKeys int `json:"live_keys"`
+
+	// Analyzed is the number of keys the figures below are computed from, fewer than LiveKeys if sampled
+	Analyzed int `json:"analyzed"`
+
+	// Sample is the fraction of keys analyzed
+	Sample float64 `json:"sample"`
+
+	// ValueBytes and KeyBytes are the total size of the analyzed values and keys
+	ValueBytes int64 `json:"value_bytes"`
+	KeyBytes   int64 `json:"key_bytes"`
+
+	// ValueSizes and KeyLengths are power-of-two histograms of value sizes and key lengths in bytes
+	ValueSizes []KVStashSizeBucket `json:"value_sizes"`
+	KeyLengths []KVStashSizeBucket `json:"key_lengths"`
+
+	// Largest lists the keys with the largest values, largest first
+	Largest []KVStashKeySize `json:"largest"`
+
+	// Prefixes counts the keys by prefix, most keys first
+	Prefixes []KVStashPrefixCount `json:"prefixes"`
+
+	// OtherPrefixes is the number of prefixes with fewer keys that are not listed
+	OtherPrefixes int `json:"other_prefixes"`
+}
+
+// KVStashHotKeys is the response of GET /kvstash/admin/hotkeys
+type KVStashHotKeys struct {
+	// HalfLifeSeconds is the time after which accesses count half as much
+	HalfLifeSeconds float64 `json:"half_life_seconds"`
+
+	// Keys lists the keys accessed most often, hottest first
+	Keys []KVStashHotKey `json:"keys"`
+}
+
+// KVStashHotKey is a key accessed often
+type KVStashHotKey struct {
+	// Key is the key, base64-encoded if KeyEncoding says so
+	Key string `json:"key"`
+
+	// KeyEncoding is KeyEncodingBase64 if Key is not valid UTF-8 and was sent base64-encoded, "" otherwise
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// Accesses is the estimated number of reads and writes of the key, older ones counting less
+	Accesses uint64 `json:"accesses"`
+
+	// Share is the fraction of all accesses that went to the key
+	Share float64 `json:"share"`
+}
+
+// KVStashSizeBucket is a bucket of a size histogram holding sizes above the previous bucket's UpTo
+type KVStashSizeBucket struct {
+	// UpTo is the largest size in the bucket
+	UpTo int64 `json:"up_to"`
+
+	// Count is the number of keys in the bucket
+	Count int `json:"count"`
+}
+
+// KVStashKeySize is a key and the size of its value
+type KVStashKeySize struct {
+	// Key is the key, base64-encoded if KeyEncoding says so
+	Key string `json:"key"`
+
+	// KeyEncoding is KeyEncodingBase64 if Key is not valid UTF-8 and was sent base64-encoded, "" otherwise
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// Size is the size of the value in bytes
+	Size int64 `json:"size"`
+}
+
+// KVStashPrefixCount counts the keys sharing a prefix
+type KVStashPrefixCount struct {
+	// Prefix is the start of the keys up to and including the delimiter, "" for keys without it
+	Prefix string `json:"prefix"`
+
+	// PrefixEncoding is KeyEncodingBase64 if Prefix is not valid UTF-8 and was sent base64-encoded, "" otherwise
+	PrefixEncoding string `json:"prefix_encoding,omitempty"`
+
+	// Keys is the number of keys with the prefix
+	Keys int `json:"keys"`
+
+	// ValueBytes is the total size of their values
+	ValueBytes int64 `json:"value_bytes"`
+}
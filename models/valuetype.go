@@ -0,0 +1,39 @@
+package models
+
+// KVStashValueType is the kind of value stored under a key
+type KVStashValueType uint8
+
+// Value types
+const (
+	// TypeString is a plain value written with Set
+	TypeString KVStashValueType = iota
+
+	// TypeList is an ordered list of strings
+	TypeList
+
+	// TypeSet is an unordered set of unique strings
+	TypeSet
+
+	// TypeHash maps string fields to string values
+	TypeHash
+
+	// TypeJSON is a JSON document that can be read and updated by path
+	TypeJSON
+)
+
+// String returns the name of the value type
+func (t KVStashValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeHash:
+		return "hash"
+	case TypeJSON:
+		return "json"
+	}
+	return "unknown"
+}
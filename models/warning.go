@@ -0,0 +1,22 @@
+package models
+
+// Limits a write can approach, see KVStashWarning
+const (
+	// WarnValueSize warns that a value is close to the maximum value size of its namespace
+	WarnValueSize = "value_size"
+
+	// WarnNamespaceQuota warns that a namespace that does not evict is close to its maximum number of keys
+	WarnNamespaceQuota = "namespace_quota"
+
+	// WarnDiskSpace warns that the database volume is close to the free space below which writes are refused
+	WarnDiskSpace = "disk_space"
+)
+
+// KVStashWarning tells that a successful write came close to a limit that makes writes fail once reached
+type KVStashWarning struct {
+	// Limit is the limit approached, e.g. WarnValueSize
+	Limit string `json:"limit"`
+
+	// Message describes how close the write came
+	Message string `json:"message"`
+}
@@ -1,5 +0,0 @@
-package constants
-
-const (
-	FlagDeleted = 0
-)
@@ -1,12 +0,0 @@
-package constants
-
-const (
-	// DBPath is the directory path where database files are stored
-	DBPath = "../db"
-
-	// TmpDBPath is the directory path where compacted files are created
-	TmpDBPath = "../tmp_db"
-
-	// BackupDBPath is the directory path where backup is stored before compaction
-	BackupDBPath = "../bkp_db"
-)
@@ -1,3 +0,0 @@
-module kvstash
-
-go 1.24.5
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"time"
+)
+
+// SetAlertHandler sets the function receiving the store's alerts, e.g. a corrupt record or a breaker trip
+// (see the models.Alert* events); nil discards them
+// Alerts raised before a handler is set, such as a backup restored by Open, are passed to the first handler
+// fn is called synchronously, possibly with the store locked, so it must not block or call into the store
+func (s *Store) SetAlertHandler(fn func(models.KVStashAlert)) {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	s.onAlert = fn
+	if fn == nil {
+		return
+	}
+	for _, a := range s.pendingAlerts {
+		fn(a)
+	}
+	s.pendingAlerts = nil
+}
+
+// raiseAlert logs an alert and passes it to the alert handler, keeping it until a handler is set
+// Messages must not contain raw keys, see redact.Key
+func (s *Store) raiseAlert(event string, severity string, format string, args ...any) {
+	a := models.KVStashAlert{
+		Event:    event,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Time:     time.Now().UTC().Format(time.RFC3339),
+	}
+	if severity == models.SeverityCritical {
+		logging.Errorf("raiseAlert: %v %v: %v", a.Severity, a.Event, a.Message)
+	} else {
+		logging.Infof("raiseAlert: %v %v: %v", a.Severity, a.Event, a.Message)
+	}
+
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	if s.onAlert != nil {
+		s.onAlert(a)
+		return
+	}
+	if len(s.pendingAlerts) < constants.AlertQueueSize {
+		s.pendingAlerts = append(s.pendingAlerts, a)
+	}
+}
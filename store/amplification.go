@@ -0,0 +1,98 @@
+package store
+
+import (
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"sync/atomic"
+)
+
+/*
+Write and space amplification:
+
+Write amplification is the number of bytes written to disk for every byte of key and value a client wrote. Besides
+the key and value, every record written to the active log carries its metadata and payload header, and compaction
+writes the database again twice: once to the backup, once to the compacted database. Space amplification is the
+size of the segment files over the size of the keys and values of the live keys, which compaction brings back
+towards its floor, the record overhead.
+
+With DurabilitySync the metadata and the payload of a record are two writes to a file opened with O_SYNC, so every
+record is flushed to stable storage twice, while the records of a batch are flushed once together; Flushes counts
+these flushes, and the fsyncs of the active log with DurabilityNone.
+
+The counters start at zero when the store is opened.
+*/
+
+// AmplificationStats describes the write and space amplification of a store
+type AmplificationStats struct {
+	// Writes is the number of records written by clients, tombstones included
+	Writes int64
+
+	// LogicalBytes is the size of the keys and values written by clients
+	LogicalBytes int64
+
+	// LogBytes is the number of bytes appended to the active log by client writes, metadata included
+	LogBytes int64
+
+	// CompactionBytes is the number of bytes written to compacted databases
+	CompactionBytes int64
+
+	// BackupBytes is the number of bytes copied to the backup before compaction
+	BackupBytes int64
+
+	// Flushes is the number of flushes of the active log to stable storage
+	Flushes int64
+
+	// WriteAmplification is LogBytes, CompactionBytes, and BackupBytes over LogicalBytes, 0 before the first write
+	WriteAmplification float64
+
+	// LiveBytes is the size of the keys and values of the live keys
+	LiveBytes int64
+
+	// SpaceAmplification is the size of the segment files over LiveBytes, 0 if there is no live key
+	SpaceAmplification float64
+}
+
+// amplification holds the counters behind AmplificationStats
+// The zero value is ready to use
+type amplification struct {
+	writes     atomic.Int64
+	logical    atomic.Int64
+	logged     atomic.Int64
+	compaction atomic.Int64
+	backup     atomic.Int64
+	flushes    atomic.Int64
+}
+
+// record counts a client write of a record with the given payload
+func (a *amplification) record(payload []byte) {
+	a.writes.Add(1)
+	a.logical.Add(max(int64(len(payload))-codec.PayloadOverhead(), 0))
+	a.logged.Add(constants.MetadataSize + int64(len(payload)))
+}
+
+// stats returns the counters, with the space amplification of diskBytes of segment files holding liveBytes
+func (a *amplification) stats(diskBytes int64, liveBytes int64) AmplificationStats {
+	stats := AmplificationStats{
+		Writes:          a.writes.Load(),
+		LogicalBytes:    a.logical.Load(),
+		LogBytes:        a.logged.Load(),
+		CompactionBytes: a.compaction.Load(),
+		BackupBytes:     a.backup.Load(),
+		Flushes:         a.flushes.Load(),
+		LiveBytes:       liveBytes,
+	}
+	if stats.LogicalBytes > 0 {
+		stats.WriteAmplification = float64(stats.LogBytes+stats.CompactionBytes+stats.BackupBytes) / float64(stats.LogicalBytes)
+	}
+	if liveBytes > 0 {
+		stats.SpaceAmplification = float64(diskBytes) / float64(liveBytes)
+	}
+	return stats
+}
+
+// logicalSize returns the size of the key and value held by the record of entry
+// Payloads in the older formats have a smaller header, so their size is underestimated by up to 16 bytes
+func logicalSize(entry *models.KVStashIndexEntry) int64 {
+	return max(entry.Size-codec.PayloadOverhead(), 0)
+}
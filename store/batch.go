@@ -0,0 +1,405 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Conditional batches:
+
+CheckAndSet evaluates every check and writes every record of a batch while holding mu, so no other read or
+write sees the keys in between. On disk, every record of the batch but the last one carries FlagBatch; the last
+record is written without it and commits the batch. buildIndex holds batch records back until it reads the
+commit record, so a crash in the middle of a batch loses all of it, and Open truncates the uncommitted records
+from the active log before new records are appended behind them.
+
+Log rotation is deferred while a batch is written, keeping the batch and its commit record in one segment. The
+active log writer holds the records of the batch in memory and writes them with the commit record, see appendRecord,
+so a batch reaches the disk in a single write and, with DurabilitySync, a single flush, however many keys it writes.
+Evictions needed to make room in full namespaces are part of the batch. Changefeed events are published once
+the batch committed, and a batch that fails part way is rolled back in the index and the active log.
+*/
+
+var (
+	// ErrConditionFailed is returned by CheckAndSet when a check does not hold; nothing was written
+	ErrConditionFailed = errors.New("batch condition failed")
+
+	// ErrBadBatch is returned by CheckAndSet for a malformed batch
+	ErrBadBatch = errors.New("invalid batch")
+)
+
+// ConditionError lists the checks of a batch that did not hold
+// It wraps ErrConditionFailed
+type ConditionError struct {
+	// Failed holds the indexes of the failed checks, in ascending order
+	Failed []int
+}
+
+// Error implements the error interface
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf("%v: checks %v", ErrConditionFailed, e.Failed)
+}
+
+// Unwrap returns ErrConditionFailed
+func (e *ConditionError) Unwrap() error {
+	return ErrConditionFailed
+}
+
+// writeBatch is the state of a batch being written by CheckAndSet, protected by mu
+type writeBatch struct {
+	// start is the offset of the active log the batch's first record is written at
+	start int64
+
+	// activeLogCount is the store's activeLogCount before the batch
+	activeLogCount int
+
+	// undo holds the index entries the batch replaced, nil for keys it added
+	undo map[string]*models.KVStashIndexEntry
+
+	// events are the changefeed events of the batch, published once it committed
+	events []batchEvent
+
+	// sets are the writes of the batch, reported to the hooks once it committed
+	sets []SetInfo
+
+	// committing indicates that the next record is the last one of the batch
+	committing bool
+}
+
+// batchEvent is a changefeed event held back until its batch committed
+type batchEvent struct {
+	eventType string
+	key       string
+	revision  uint64
+}
+
+// CheckAndSet applies writes only if every check holds, all of them or none, even across a crash
+// The checks see the keys as they are right before the writes, no other write can come in between
+// A write sets a string value with an optional TTL in seconds (0 applies the namespace default, -1 never expires)
+// or expiry Unix time, or deletes a key; deleting a key that does not exist is not an error
+// Returns the version of every write, nil for deletes
+// Returns a ConditionError wrapping ErrConditionFailed if a check does not hold, ErrBadBatch for a malformed
+// batch, and the validation errors of Set (client errors)
+// Returns other errors for server-side failures, in which case nothing was written
+func (s *Store) CheckAndSet(checks []models.KVStashCondition, writes []models.KVStashBatchWrite) ([]*models.KVStashVersion, error) {
+	return s.checkAndSet(checks, writes, nil)
+}
+
+// checkAndSet is CheckAndSet, recording the time spent in each phase in phases if it is not nil
+func (s *Store) checkAndSet(checks []models.KVStashCondition, writes []models.KVStashBatchWrite,
+	phases *models.KVStashPhases) ([]*models.KVStashVersion, error) {
+	t := s.startOp(OpBatch)
+	defer t.finish(phases)
+
+	if err := s.validateBatch(checks, writes); err != nil {
+		return nil, fmt.Errorf("CheckAndSet: %w", err)
+	}
+
+	// Normalize keys and compute expiry times before taking the lock
+	checks = append([]models.KVStashCondition(nil), checks...)
+	for i := range checks {
+		checks[i].Key = s.normalization.Key(checks[i].Key)
+	}
+	writes = append([]models.KVStashBatchWrite(nil), writes...)
+	expiresAt := make([]int64, len(writes))
+	for i := range writes {
+		writes[i].Key = s.normalization.Key(writes[i].Key)
+		if !writes[i].Delete {
+			expiresAt[i] = s.expiryFor(writes[i].Key, time.Duration(writes[i].TTL)*time.Second)
+			if writes[i].ExpireAt > 0 {
+				expiresAt[i] = expireAtTime(writes[i].ExpireAt).UnixMilli()
+			}
+		}
+	}
+
+	t.lock()
+	defer s.mu.Unlock()
+
+	if err := s.evaluate(checks, t); err != nil {
+		return nil, fmt.Errorf("CheckAndSet: %w", err)
+	}
+
+	if err := s.checkWritable(); err != nil {
+		return nil, fmt.Errorf("CheckAndSet: %w", err)
+	}
+	if err := s.logRotation(); err != nil {
+		s.recordWrite(err)
+		return nil, fmt.Errorf("CheckAndSet: failed to rotate log: %w", err)
+	}
+
+	b := s.beginBatch()
+	defer func() { s.batch = nil }()
+
+	entries := make([]*models.KVStashIndexEntry, len(writes))
+	for i, w := range writes {
+		if err := s.writeBatched(w, expiresAt[i], i == len(writes)-1, t); err != nil {
+			s.rollback(b, err)
+			return nil, fmt.Errorf("CheckAndSet: write %d failed, the batch was rolled back: %w", i, err)
+		}
+		if !w.Delete {
+			entries[i] = s.index[w.Key]
+		}
+	}
+
+	s.committed(b)
+
+	// Every write of the batch becomes visible at once, at the position of its last event
+	pos := s.feed.position()
+	versions := make([]*models.KVStashVersion, len(writes))
+	for i, entry := range entries {
+		if entry != nil {
+			versions[i] = newVersion(entry, pos)
+		}
+	}
+
+	return versions, nil
+}
+
+// CompareAndSwap sets req.Value under req.Key only if the key's current value is what req expects, see
+// KVStashRequest.Expectation; a batch of one check and one write
+// Returns a ConditionError wrapping ErrConditionFailed if it is not, ErrBadBatch if req expects nothing, and the
+// errors of Set otherwise
+func (s *Store) CompareAndSwap(req *models.KVStashRequest) (*models.KVStashVersion, error) {
+	versions, err := s.checkAndSet([]models.KVStashCondition{req.Expectation()}, []models.KVStashBatchWrite{{
+		Key:      req.Key,
+		Value:    req.Value,
+		TTL:      req.TTL,
+		ExpireAt: req.ExpireAt,
+	}}, req.Phases)
+	if err != nil {
+		return nil, fmt.Errorf("CompareAndSwap: %w", err)
+	}
+	return versions[0], nil
+}
+
+// validateBatch checks the size of a batch, its keys and values, and that every check tests something
+func (s *Store) validateBatch(checks []models.KVStashCondition, writes []models.KVStashBatchWrite) error {
+	if len(writes) == 0 {
+		return fmt.Errorf("validateBatch: %w: no writes", ErrBadBatch)
+	}
+	if len(checks)+len(writes) > constants.MaxBatchKeys {
+		return fmt.Errorf("validateBatch: %w: too many checks and writes (max %d)", ErrBadBatch, constants.MaxBatchKeys)
+	}
+
+	for i, c := range checks {
+		if err := validateKey(s.normalization.Key(c.Key)); err != nil {
+			return fmt.Errorf("validateBatch: check %d: %w", i, err)
+		}
+		if c.Exists == nil && c.Version == "" && c.Revision == nil && c.Value == nil {
+			return fmt.Errorf("validateBatch: %w: check %d has no condition", ErrBadBatch, i)
+		}
+	}
+
+	for i, w := range writes {
+		key := s.normalization.Key(w.Key)
+		if err := validateKey(key); err != nil {
+			return fmt.Errorf("validateBatch: write %d: %w", i, err)
+		}
+		if w.Delete {
+			continue
+		}
+		if len(w.Value) == 0 {
+			return fmt.Errorf("validateBatch: %w: write %d has an empty value", ErrBadBatch, i)
+		}
+		if err := validateExpiry(w.TTL, w.ExpireAt); err != nil {
+			return fmt.Errorf("validateBatch: write %d: %w", i, err)
+		}
+		if err := s.validateValueFor(key, w.Value); err != nil {
+			return fmt.Errorf("validateBatch: write %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// evaluate returns a ConditionError listing the checks that do not hold
+// Must be called with mu held
+func (s *Store) evaluate(checks []models.KVStashCondition, t *opTimer) error {
+	var failed []int
+	for i, c := range checks {
+		ok, err := s.holds(c, t)
+		if err != nil {
+			return fmt.Errorf("evaluate: check %d: %w", i, err)
+		}
+		if !ok {
+			failed = append(failed, i)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ConditionError{Failed: failed}
+	}
+	return nil
+}
+
+// holds reports whether every condition of c holds for the current value of its key
+// Must be called with mu held
+func (s *Store) holds(c models.KVStashCondition, t *opTimer) (bool, error) {
+	entry, exists := s.lookup(c.Key)
+	if c.Exists != nil && *c.Exists != exists {
+		return false, nil
+	}
+
+	if c.Version != "" && (!exists || c.Version != hex.EncodeToString(entry.Checksum[:])) {
+		return false, nil
+	}
+
+	if c.Revision != nil && (!exists || *c.Revision != entry.Revision) {
+		return false, nil
+	}
+
+	if c.Value != nil {
+		if !exists || (entry.Type != models.TypeString && entry.Type != models.TypeJSON) {
+			return false, nil
+		}
+		value, err := fetchValue(s.files, s.dbPath, entry.SegmentFile, entry.Offset, entry.Size, entryFlags(entry), entry.Checksum, VerifyFull, t)
+		if err != nil {
+			return false, fmt.Errorf("holds: %w", err)
+		}
+		if value != *c.Value {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// writeBatched writes w as part of the open batch; last marks the write that commits the batch
+// Must be called with mu held
+func (s *Store) writeBatched(w models.KVStashBatchWrite, expiresAt int64, last bool, t *opTimer) error {
+	if w.Delete {
+		s.batch.committing = last
+		return s.tombstone(w.Key, models.EventDelete, "", t)
+	}
+
+	// Evictions come before the commit record, so they are part of the batch
+	if err := s.makeRoom(w.Key, t); err != nil {
+		return fmt.Errorf("writeBatched: %w", err)
+	}
+	s.batch.committing = last
+	return s.put(w.Key, w.Value, models.TypeString, expiresAt, t)
+}
+
+// beginBatch opens a batch: the records written until it is committed or rolled back are part of it
+// Must be called with mu held, after the log was rotated if needed
+func (s *Store) beginBatch() *writeBatch {
+	s.writer.hold()
+	s.batch = &writeBatch{
+		start:          s.writer.offset,
+		activeLogCount: s.activeLogCount,
+		undo:           make(map[string]*models.KVStashIndexEntry),
+	}
+	return s.batch
+}
+
+// committed closes the batch b once its commit record was written, publishing its changefeed events and
+// reporting its writes to the hooks
+// Must be called with mu held
+func (s *Store) committed(b *writeBatch) {
+	for _, ev := range b.events {
+		s.feed.publish(ev.eventType, ev.key, ev.revision)
+	}
+	s.batch = nil
+	for _, info := range b.sets {
+		s.notifySet(info)
+	}
+}
+
+// appendRecord appends a record with flags to the active log, holding the records of the open batch until its
+// commit record is written, see LogWriter.hold
+// Must be called with mu held
+func (s *Store) appendRecord(data []byte, flags []int64) (*models.KVStashMetadata, error) {
+	metadata, err := s.writer.Write(data, flags)
+	if err == nil && s.batch != nil && s.batch.committing {
+		err = s.writer.release()
+	}
+	return metadata, err
+}
+
+// batchFlags returns flags with FlagBatch added if the record is written as part of a batch and does not commit it
+// Must be called with mu held
+func (s *Store) batchFlags(flags []int64) ([]int64, bool) {
+	if s.batch == nil || s.batch.committing {
+		return flags, false
+	}
+	return append(flags, constants.FlagBatch), true
+}
+
+// setEntry points the index entry of key at entry, remembering the replaced entry if a batch is open
+// Must be called with mu held
+func (s *Store) setEntry(key string, entry *models.KVStashIndexEntry) {
+	if s.batch != nil {
+		if _, ok := s.batch.undo[key]; !ok {
+			s.batch.undo[key] = s.index[key]
+		}
+	}
+	if _, ok := s.index[key]; !ok {
+		s.keys.insert(key)
+	}
+	s.index[key] = entry
+	s.readCache.remove(key)
+	s.scheduleExpiry(key, entry)
+}
+
+// publish publishes a changefeed event for the write of key at revision, or holds it back until the open batch
+// committed
+// Must be called with mu held
+func (s *Store) publish(eventType string, key string, revision uint64) {
+	if s.batch != nil {
+		s.batch.events = append(s.batch.events, batchEvent{eventType, key, revision})
+		return
+	}
+	s.feed.publish(eventType, key, revision)
+}
+
+// rollback undoes a batch that failed with cause: the index and the active log go back to where the batch started
+// If the active log cannot be truncated the store is degraded, and ResumeWrites drops the records later
+// Must be called with mu held
+func (s *Store) rollback(b *writeBatch, cause error) {
+	now := s.now().UnixMilli()
+	for key, entry := range b.undo {
+		if entry == nil {
+			delete(s.index, key)
+			s.keys.remove(key)
+		} else {
+			s.index[key] = entry
+		}
+		s.readCache.remove(key)
+		if live(entry, now) {
+			s.touch(key, true)
+		} else {
+			s.forget(key)
+		}
+	}
+	s.activeLogCount = b.activeLogCount
+
+	if err := s.writer.truncate(b.start); err != nil {
+		logging.Errorf("rollback: %v", err)
+		s.degrade(fmt.Errorf("failed to roll back a batch after %v: %w", cause, err))
+	}
+}
+
+// discardUncommittedBatch truncates the active log before the batch buildIndex found without its commit record,
+// so new records are not read as its commit record on the next start
+// Must be called before the writer is opened
+func (s *Store) discardUncommittedBatch() error {
+	if s.uncommittedBatch < 0 {
+		return nil
+	}
+
+	if err := os.Truncate(filepath.Join(s.dbPath, s.activeLog), s.uncommittedBatch); err != nil {
+		return fmt.Errorf("discardUncommittedBatch: failed to truncate %v: %w", s.activeLog, err)
+	}
+	logging.Infof("discardUncommittedBatch: truncated %v to %d bytes", s.activeLog, s.uncommittedBatch)
+	s.uncommittedBatch = -1
+
+	return nil
+}
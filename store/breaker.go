@@ -0,0 +1,147 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrDegraded is returned by writes while the store is degraded, see BreakerStats
+var ErrDegraded = errors.New("store is degraded after repeated storage errors, writes are disabled")
+
+// BreakerStats describes the write circuit breaker of a store
+// After FailureThreshold consecutive failed writes (append, fsync, or segment rotation) the breaker trips:
+// the store becomes read-only and writes fail with ErrDegraded until ResumeWrites is called,
+// instead of continuing to append to a likely failing disk
+type BreakerStats struct {
+	// Degraded indicates that the breaker tripped and writes are disabled
+	Degraded bool
+
+	// ConsecutiveFailures is the number of failed writes since the last successful one
+	ConsecutiveFailures int
+
+	// Trips is the number of times the breaker tripped
+	Trips int64
+
+	// DegradedSince is when the breaker last tripped (zero if it never did)
+	DegradedSince time.Time
+
+	// LastError is the most recent storage error, empty if none occurred
+	LastError string
+
+	// Failovers is the number of times a failed write sealed the active log and writes continued in a new segment
+	Failovers int64
+
+	// LastFailover is when the active log was last sealed after a failed write (zero if it never was)
+	LastFailover time.Time
+}
+
+// checkWritable returns ErrMaintenance in maintenance mode, ErrDegraded if the breaker tripped, or ErrDiskFull
+// if free disk space is low
+func (s *Store) checkWritable() error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+	if s.Degraded() {
+		return ErrDegraded
+	}
+	return s.checkDiskWritable()
+}
+
+// recordWrite feeds the outcome of a write to the breaker, tripping it after failureThreshold consecutive failures
+func (s *Store) recordWrite(err error) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if err == nil {
+		s.breaker.ConsecutiveFailures = 0
+		return
+	}
+
+	s.breaker.ConsecutiveFailures++
+	s.breaker.LastError = err.Error()
+	if s.breaker.Degraded || s.breaker.ConsecutiveFailures < s.failureThreshold {
+		return
+	}
+
+	s.trip()
+	s.raiseAlert(models.AlertBreakerTripped, models.SeverityCritical,
+		"%d consecutive write failures on %v, the store is now read-only: %v", s.breaker.ConsecutiveFailures, s.dbPath, err)
+}
+
+// degrade trips the breaker right away, after a failure that leaves the active log unfit for new records
+func (s *Store) degrade(err error) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.breaker.LastError = err.Error()
+	if s.breaker.Degraded {
+		return
+	}
+
+	s.trip()
+	s.raiseAlert(models.AlertBreakerTripped, models.SeverityCritical, "writes to %v stopped, the store is now read-only: %v", s.dbPath, err)
+}
+
+// trip disables writes until ResumeWrites
+// Must be called with statsMu held
+func (s *Store) trip() {
+	s.breaker.Degraded = true
+	s.breaker.DegradedSince = time.Now()
+	s.breaker.Trips++
+}
+
+// Degraded reports whether the breaker tripped and writes are disabled
+func (s *Store) Degraded() bool {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return s.breaker.Degraded
+}
+
+// ResumeWrites re-enables writes after the breaker tripped, once the underlying problem was fixed
+// The active log is truncated to its last complete record and reopened; if that fails the store stays degraded
+func (s *Store) ResumeWrites() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Degraded() {
+		return nil
+	}
+
+	if s.writer != nil {
+		offset := s.writer.offset
+		// The old handle may be unusable after the failures, so it is dropped even if closing fails
+		if err := s.closeWriter(); err != nil {
+			logging.Errorf("ResumeWrites: failed to close active log: %v", err)
+			s.writer = nil
+		}
+
+		// Drop a torn record left behind by the failed writes, so new records follow the last good one
+		if err := os.Truncate(filepath.Join(s.dbPath, s.activeLog), offset); err != nil {
+			return fmt.Errorf("ResumeWrites: failed to truncate active log: %w", err)
+		}
+		// A seal left by a failover that could not switch segments is moot once the suspect bytes are gone
+		if err := removeSeal(s.dbPath, s.activeLog); err != nil {
+			return fmt.Errorf("ResumeWrites: %w", err)
+		}
+	}
+
+	writer, err := s.openWriter(s.activeLog, s.durability)
+	if err != nil {
+		return fmt.Errorf("ResumeWrites: failed to reopen active log: %w", err)
+	}
+	s.writer = writer
+
+	s.statsMu.Lock()
+	s.breaker.Degraded = false
+	s.breaker.ConsecutiveFailures = 0
+	s.statsMu.Unlock()
+
+	s.raiseAlert(models.AlertWritesResumed, models.SeverityResolved, "writes to %v were re-enabled", s.dbPath)
+	return nil
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"strings"
+	"sync"
+)
+
+// ErrWatchExpired indicates that a watch cannot resume from the requested position
+// Either the position belongs to another epoch (the server restarted) or the events after it
+// are no longer retained; the watcher has to start over from the current position
+var ErrWatchExpired = errors.New("watch position expired")
+
+// WatchPosition identifies the point in the changefeed a watch starts after
+// The zero value starts at the current end of the feed without replaying anything
+type WatchPosition struct {
+	// Epoch is the changefeed epoch the sequence number belongs to
+	Epoch string
+
+	// Seq is the sequence number of the last event already seen
+	Seq uint64
+}
+
+// changefeed is an in-memory, sequence-numbered log of recent mutations
+// It retains the last WatchRetainedEvents events so that watchers can resume after a disconnect
+// Events are not persisted; a restart starts a new epoch
+type changefeed struct {
+	// mu protects all fields below
+	mu sync.Mutex
+
+	// epoch is a random identifier of this changefeed instance
+	epoch string
+
+	// seq is the sequence number of the last published event
+	seq uint64
+
+	// events holds the retained events in sequence order
+	events []models.KVStashEvent
+
+	// watchers is the set of active watchers
+	watchers map[*Watcher]struct{}
+
+	// subscribers is the set of active keyspace notification subscriptions
+	subscribers map[*Subscription]struct{}
+}
+
+// newChangefeed creates an empty changefeed with a fresh epoch
+func newChangefeed() *changefeed {
+	var b [8]byte
+	rand.Read(b[:])
+
+	return &changefeed{
+		epoch:       hex.EncodeToString(b[:]),
+		watchers:    make(map[*Watcher]struct{}),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// publish appends an event for the write of key at revision to the feed and delivers it to every matching watcher
+// Watchers whose buffer is full are dropped; they can resume from their last seen position
+func (f *changefeed) publish(eventType string, key string, revision uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	event := models.KVStashEvent{Epoch: f.epoch, Seq: f.seq, Type: eventType, Key: key, Revision: revision}
+
+	// Trim in bulk so the retained window is only copied once every WatchRetainedEvents events
+	f.events = append(f.events, event)
+	if len(f.events) >= 2*constants.WatchRetainedEvents {
+		f.events = append([]models.KVStashEvent(nil), f.events[len(f.events)-constants.WatchRetainedEvents:]...)
+	}
+
+	for w := range f.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+
+		select {
+		case w.ch <- event:
+		default:
+			f.remove(w)
+		}
+	}
+
+	for sub := range f.subscribers {
+		sub.deliver(event)
+	}
+}
+
+// position returns the position of the last published event
+func (f *changefeed) position() WatchPosition {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return WatchPosition{Epoch: f.epoch, Seq: f.seq}
+}
+
+// remove unregisters a watcher and closes its channel
+// Must be called with mu held
+func (f *changefeed) remove(w *Watcher) {
+	if _, ok := f.watchers[w]; ok {
+		delete(f.watchers, w)
+		close(w.ch)
+	}
+}
+
+// Watcher receives the changefeed events of keys starting with a prefix
+type Watcher struct {
+	// feed is the changefeed the watcher is registered with
+	feed *changefeed
+
+	// prefix filters the delivered events by key
+	prefix string
+
+	// start is the position the watcher started after
+	start WatchPosition
+
+	// ch delivers the events; it is closed when the watcher is closed or falls too far behind
+	ch chan models.KVStashEvent
+}
+
+// Watch registers a watcher for all keys starting with prefix (empty prefix matches every key)
+// Events after from are replayed first, followed by live events
+// Returns ErrWatchExpired if from cannot be resumed
+// The watcher must be closed with Close when no longer needed
+func (s *Store) Watch(prefix string, from WatchPosition) (*Watcher, error) {
+	prefix = s.normalization.Prefix(prefix)
+	f := s.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var replay []models.KVStashEvent
+	if from.Epoch == "" {
+		from = WatchPosition{Epoch: f.epoch, Seq: f.seq}
+	} else {
+		if from.Epoch != f.epoch || from.Seq > f.seq {
+			return nil, ErrWatchExpired
+		}
+
+		// The oldest retained event must directly follow the requested position
+		if from.Seq < f.seq && (len(f.events) == 0 || f.events[0].Seq > from.Seq+1) {
+			return nil, ErrWatchExpired
+		}
+
+		for _, event := range f.events {
+			if event.Seq > from.Seq && strings.HasPrefix(event.Key, prefix) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	w := &Watcher{
+		feed:   f,
+		prefix: prefix,
+		start:  from,
+		ch:     make(chan models.KVStashEvent, len(replay)+constants.WatchBufferSize),
+	}
+	for _, event := range replay {
+		w.ch <- event
+	}
+	f.watchers[w] = struct{}{}
+
+	return w, nil
+}
+
+// Events returns the channel delivering the watcher's events
+// The channel is closed when the watcher is closed or when it fell more than WatchBufferSize events behind
+func (w *Watcher) Events() <-chan models.KVStashEvent {
+	return w.ch
+}
+
+// Start returns the position the watcher started after
+func (w *Watcher) Start() WatchPosition {
+	return w.start
+}
+
+// Close unregisters the watcher and closes its channel
+// Calling Close more than once has no effect
+func (w *Watcher) Close() {
+	w.feed.mu.Lock()
+	defer w.feed.mu.Unlock()
+
+	w.feed.remove(w)
+}
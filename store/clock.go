@@ -0,0 +1,255 @@
+package store
+
+import (
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"sync"
+	"time"
+)
+
+/*
+Clock skew:
+
+Expiry compares the absolute expiry times of keys with the clock, so a clock that jumps, e.g. when a VM is restored
+from a snapshot or NTP steps a clock that drifted, would expire keys early or keep them late. The store therefore
+reads the time through a hybrid clock: it follows the Clock as long as it advances like the elapsed time measured
+by the monotonic clock, within constants.MaxClockSkew milliseconds, and never goes back. A larger jump either way is
+clock skew: the store keeps counting the elapsed time from before the jump and reports the skew, until the Clock is
+back within the tolerance, e.g. after NTP corrected it, or the store is reopened.
+
+The hybrid time is saved in the superblock, at every rotation and every constants.ClockSaveInterval seconds, and the
+store does not start before it on the next Open, so a clock set back while the store was closed does not bring
+expired keys back. A clock set forward while the store was closed cannot be told from downtime.
+
+The order of writes does not depend on the clock: the latest write of a key is the one with the highest revision,
+see KVStashVersion.Revision.
+*/
+
+// Clock tells the store the time and wakes its background jobs, see Options.Clock
+// Expiry, default TTLs, sessions, compaction pauses and windows, and the delays between the runs of background jobs
+// (automatic compaction, expiry notifications) follow it; latencies, timeouts, and the timestamps of stats and
+// alerts use the system clock
+// The store reads Now through a hybrid clock that ignores jumps, see ClockStats
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After returns a channel that receives the time once d has passed on the clock
+	After(d time.Duration) <-chan time.Time
+}
+
+// MonotonicClock is a Clock that measures elapsed time apart from the time it tells, like the monotonic clock of
+// the system, so the store can tell the time jumping from time passing
+// Clocks without it measure elapsed time by the difference of the times they tell, with the monotonic clock reading
+// of time.Now if they have one
+type MonotonicClock interface {
+	Clock
+
+	// Elapsed returns the time elapsed since an arbitrary fixed point; it never decreases
+	Elapsed() time.Duration
+}
+
+// systemClock is the Clock of the system, the default
+type systemClock struct{}
+
+// Now returns time.Now()
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns time.After(d)
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// ClockStats describes the store's hybrid clock
+type ClockStats struct {
+	// Now is the store's time
+	Now time.Time
+
+	// Skew is how far the Clock is ahead of the store's time, negative if it is behind, 0 unless Skewed
+	Skew time.Duration
+
+	// Skewed indicates that the Clock jumped and the store counts the elapsed time instead
+	Skewed bool
+
+	// SkewedSince is when the Clock jumped, on the store's time, zero unless Skewed
+	SkewedSince time.Time
+
+	// Jumps is the number of times the Clock jumped since the store was opened
+	Jumps int64
+
+	// HighWater is the store's time saved in the superblock, which the store does not start before when reopened
+	HighWater time.Time
+}
+
+// hybridClock follows a Clock while it advances like the monotonic clock, see the comment at the top of the file
+// It is safe for concurrent use
+type hybridClock struct {
+	// clock is the Clock followed
+	clock Clock
+
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// last is the last time returned, without a monotonic clock reading
+	last time.Time
+
+	// lastClock is the Clock's time when last was returned, with its monotonic clock reading if it has one
+	lastClock time.Time
+
+	// lastElapsed is the elapsed time of a MonotonicClock when last was returned
+	lastElapsed time.Duration
+
+	// skew is the Clock's time minus the hybrid time while skewed, 0 otherwise
+	skew time.Duration
+
+	// skewedSince is the hybrid time of the jump while skewed
+	skewedSince time.Time
+
+	// jumps counts the jumps of the Clock
+	jumps int64
+
+	// highWater is the time saved in the superblock, see saveState
+	highWater time.Time
+}
+
+// newHybridClock creates a hybrid clock following clock
+func newHybridClock(clock Clock) *hybridClock {
+	return &hybridClock{clock: clock}
+}
+
+// now returns the hybrid time and whether the clock started or stopped being skewed with this reading
+func (h *hybridClock) now() (time.Time, bool) {
+	t := h.clock.Now()
+	mono, monotonic := h.clock.(MonotonicClock)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var elapsed time.Duration
+	if monotonic {
+		now := mono.Elapsed()
+		elapsed = now - h.lastElapsed
+		h.lastElapsed = now
+	} else {
+		// With readings of the system clock, Sub uses the monotonic clock, which does not jump
+		elapsed = t.Sub(h.lastClock)
+	}
+
+	if h.lastClock.IsZero() {
+		h.lastClock = t
+		h.last = later(t.Round(0), h.last)
+		return h.last, false
+	}
+
+	expected := h.last.Add(max(elapsed, 0))
+	h.lastClock = t
+	skew := t.Round(0).Sub(expected)
+	wasSkewed := h.skew != 0
+
+	if skew.Abs() <= constants.MaxClockSkew*time.Millisecond {
+		h.last = later(t.Round(0), h.last)
+		h.skew = 0
+		h.skewedSince = time.Time{}
+		return h.last, wasSkewed
+	}
+
+	if !wasSkewed {
+		h.jumps++
+		h.skewedSince = expected
+	}
+	h.last = expected
+	h.skew = skew
+	return h.last, !wasSkewed
+}
+
+// floor makes the hybrid time not go before t, the time saved in the superblock by an earlier run
+func (h *hybridClock) floor(t time.Time) {
+	if behind := t.Sub(h.clock.Now().Round(0)); behind > constants.MaxClockSkew*time.Millisecond {
+		logging.Warnf("floor: the clock is %v behind the time saved by the last run, starting from %v",
+			behind.Round(time.Millisecond), t.Format(time.RFC3339))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last = later(t, h.last)
+	h.highWater = later(t, h.highWater)
+}
+
+// saved records t as the time saved in the superblock
+func (h *hybridClock) saved(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.highWater = later(t, h.highWater)
+}
+
+// savedAt returns the time last saved in the superblock
+func (h *hybridClock) savedAt() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.highWater
+}
+
+// stats returns the state of the clock, with now the time just read
+func (h *hybridClock) stats(now time.Time) ClockStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return ClockStats{
+		Now:         now,
+		Skew:        h.skew,
+		Skewed:      h.skew != 0,
+		SkewedSince: h.skewedSince,
+		Jumps:       h.jumps,
+		HighWater:   h.highWater,
+	}
+}
+
+// later returns the later of a and b
+func later(a time.Time, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// now returns the current time of the store's hybrid clock, raising an alert when the clock jumps or recovers
+func (s *Store) now() time.Time {
+	t, changed := s.hlc.now()
+	if changed {
+		stats := s.hlc.stats(t)
+		if stats.Skewed {
+			s.raiseAlert(models.AlertClockSkew, models.SeverityCritical,
+				"the clock jumped %v, expiry follows the elapsed time instead", stats.Skew.Round(time.Millisecond))
+		} else {
+			s.raiseAlert(models.AlertClockRecovered, models.SeverityResolved, "the clock is back in step")
+		}
+	}
+	return t
+}
+
+// saveClock saves the store's time to the superblock once constants.ClockSaveInterval seconds passed since the
+// last save, see floor
+func (s *Store) saveClock() {
+	// A failing disk would only fail again on every check
+	if s.Degraded() || s.now().Sub(s.hlc.savedAt()) < constants.ClockSaveInterval*time.Second {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.saveState(); err != nil {
+		logging.Errorf("saveClock: %v", err)
+	}
+}
+
+// ClockStats returns the state of the store's hybrid clock
+func (s *Store) ClockStats() ClockStats {
+	return s.hlc.stats(s.now())
+}
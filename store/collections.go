@@ -0,0 +1,455 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"slices"
+)
+
+/*
+Collections:
+
+Lists, sets, and hashes are stored like any other value, as a single record holding the whole collection
+encoded as JSON (a list as an array, a set as a sorted array, a hash as an object). The record's flags
+carry the type (FlagList, FlagSet, FlagHash), so the index knows it without reading the payload.
+
+Every mutation is a read-modify-write under the store lock: the current record is read, changed, and a
+new record is appended. A collection is limited to MaxValueSize once encoded, and a collection that
+becomes empty is deleted, like in Redis.
+*/
+
+// ErrWrongType is returned when an operation is applied to a key holding another kind of value,
+// e.g. Get on a list or LPush on a string
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// typeFlags returns the record flags marking a value of type typ
+func typeFlags(typ models.KVStashValueType) []int64 {
+	switch typ {
+	case models.TypeList:
+		return []int64{constants.FlagList}
+	case models.TypeSet:
+		return []int64{constants.FlagSet}
+	case models.TypeHash:
+		return []int64{constants.FlagHash}
+	case models.TypeJSON:
+		return []int64{constants.FlagJSON}
+	}
+	return nil
+}
+
+// entryFlags returns the flags field of the live record entry points at
+func entryFlags(entry *models.KVStashIndexEntry) int64 {
+	flags := typeFlags(entry.Type)
+	if entry.Batch {
+		flags = append(flags, constants.FlagBatch)
+	}
+	flags = append(flags, transformFlags(entry.Transforms)...)
+	return models.ComputeMetadataFlag(flags)
+}
+
+// valueTypeOf returns the kind of value held by the record described by m
+func valueTypeOf(m *codec.Metadata) models.KVStashValueType {
+	switch {
+	case m.GetMetadataFlagValue(constants.FlagList):
+		return models.TypeList
+	case m.GetMetadataFlagValue(constants.FlagSet):
+		return models.TypeSet
+	case m.GetMetadataFlagValue(constants.FlagHash):
+		return models.TypeHash
+	case m.GetMetadataFlagValue(constants.FlagJSON):
+		return models.TypeJSON
+	}
+	return models.TypeString
+}
+
+// encodeJSON encodes v as compact JSON without escaping <, >, and &, which json.Marshal does for HTML
+func encodeJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Type returns the kind of value stored under key, or ErrKeyNotFound
+func (s *Store) Type(key string) (models.KVStashValueType, error) {
+	key = s.normalization.Key(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.lookup(key)
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return entry.Type, nil
+}
+
+// setTyped stores value, a string or an encoded collection, under key with its type, expiry time, and revision
+// Used to copy records between stores without losing their type, TTL, or revision; a revision of 0 assigns one
+func (s *Store) setTyped(key string, value string, typ models.KVStashValueType, expiresAt int64, revision uint64) error {
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if err := validateValue(value); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.putRevision(key, value, typ, expiresAt, revision, nil); err != nil {
+		return fmt.Errorf("setTyped: %w", err)
+	}
+
+	return nil
+}
+
+// ImportRecord stores value, a string or a collection encoded as returned by Iterator.Value, under key with its type
+// and expiry time in Unix milliseconds (0 if it does not expire), assigning it a new revision
+// Used to restore exports; returns the errors of Set for invalid keys and values
+func (s *Store) ImportRecord(key string, value string, typ models.KVStashValueType, expiresAt int64) error {
+	if typ > models.TypeJSON {
+		return fmt.Errorf("ImportRecord: unknown value type %d", typ)
+	}
+
+	if err := s.setTyped(key, value, typ, expiresAt, 0); err != nil {
+		return fmt.Errorf("ImportRecord: %w", err)
+	}
+
+	return nil
+}
+
+// loadCollection decodes the collection of type typ stored under key into coll
+// Returns false if the key does not exist or expired, and ErrWrongType if it holds another kind of value
+// The caller must hold mu (read or write); the read is timed by t, which may be nil
+func (s *Store) loadCollection(key string, typ models.KVStashValueType, coll any, t *opTimer) (bool, error) {
+	entry, ok := s.lookup(key)
+	if !ok {
+		return false, nil
+	}
+	if entry.Type != typ {
+		return false, fmt.Errorf("loadCollection: %w (%v, not %v)", ErrWrongType, entry.Type, typ)
+	}
+
+	raw, err := fetchValue(s.files, s.dbPath, entry.SegmentFile, entry.Offset, entry.Size, entryFlags(entry), entry.Checksum, VerifyFull, t)
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			s.raiseAlert(models.AlertCorruption, models.SeverityCritical,
+				"checksum mismatch reading the %v stored under key=%v from %v", typ, redact.Key(key), entry.SegmentFile)
+			s.notifyCorruption(key, entry.SegmentFile, err)
+		}
+		return false, fmt.Errorf("loadCollection: %w", err)
+	}
+	if err := json.Unmarshal([]byte(raw), coll); err != nil {
+		return false, fmt.Errorf("loadCollection: %v holds a malformed %v: %w", key, typ, err)
+	}
+	s.touch(key, false)
+
+	return true, nil
+}
+
+// readCollection decodes the collection of type typ stored under key; a missing key leaves the zero value
+func readCollection[T any](s *Store, key string, typ models.KVStashValueType) (T, error) {
+	t := s.startOp(OpRead)
+	defer t.finish(nil)
+
+	var coll T
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return coll, err
+	}
+
+	t.rlock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.loadCollection(key, typ, &coll, t); err != nil {
+		return coll, err
+	}
+	return coll, nil
+}
+
+// updateCollection applies fn to the collection of type typ stored under key and writes the result
+// fn returns the number of elements left; an empty collection deletes the key
+// An existing collection keeps its expiry time; a new one gets the default TTL of its namespace
+// Nothing is written if fn fails or reports that it changed nothing
+func updateCollection[T any](s *Store, key string, typ models.KVStashValueType, fn func(coll *T) (size int, changed bool, err error)) error {
+	t := s.startOp(OpUpdate)
+	defer t.finish(nil)
+
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	t.lock()
+	defer s.mu.Unlock()
+
+	var coll T
+	exists, err := s.loadCollection(key, typ, &coll, t)
+	if err != nil {
+		return err
+	}
+
+	size, changed, err := fn(&coll)
+	if err != nil || !changed {
+		return err
+	}
+
+	if size == 0 {
+		if !exists {
+			return nil
+		}
+		return s.tombstone(key, models.EventDelete, "", t)
+	}
+
+	encoded, err := encodeJSON(coll)
+	if err != nil {
+		return fmt.Errorf("updateCollection: failed to encode %v: %w", typ, err)
+	}
+	if err := s.validateValueFor(key, string(encoded)); err != nil {
+		return err
+	}
+
+	expiresAt := s.expiryFor(key, 0)
+	if entry, ok := s.lookup(key); ok {
+		expiresAt = entry.ExpiresAt
+	}
+
+	return s.put(key, string(encoded), typ, expiresAt, t)
+}
+
+// LPush prepends values to the list stored under key, creating it if needed, and returns the new length
+// Like Redis, the values are inserted one after the other, so LPush(k, "a", "b") leaves "b" first
+func (s *Store) LPush(key string, values ...string) (int, error) {
+	var length int
+	err := updateCollection(s, key, models.TypeList, func(list *[]string) (int, bool, error) {
+		for _, v := range values {
+			*list = slices.Insert(*list, 0, v)
+		}
+		length = len(*list)
+		return length, len(values) > 0, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("LPush: %w", err)
+	}
+
+	return length, nil
+}
+
+// RPush appends values to the list stored under key, creating it if needed, and returns the new length
+func (s *Store) RPush(key string, values ...string) (int, error) {
+	var length int
+	err := updateCollection(s, key, models.TypeList, func(list *[]string) (int, bool, error) {
+		*list = append(*list, values...)
+		length = len(*list)
+		return length, len(values) > 0, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("RPush: %w", err)
+	}
+
+	return length, nil
+}
+
+// LPop removes and returns the first element of the list stored under key
+// Returns ErrKeyNotFound if the key does not exist
+func (s *Store) LPop(key string) (string, error) {
+	var first string
+	err := updateCollection(s, key, models.TypeList, func(list *[]string) (int, bool, error) {
+		if len(*list) == 0 {
+			return 0, false, ErrKeyNotFound
+		}
+		first = (*list)[0]
+		*list = (*list)[1:]
+		return len(*list), true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("LPop: %w", err)
+	}
+
+	return first, nil
+}
+
+// LRange returns the elements of the list stored under key from start to stop, both inclusive
+// Negative indexes count from the end (-1 is the last element); out of range indexes are clamped
+// A missing key is an empty list
+func (s *Store) LRange(key string, start int, stop int) ([]string, error) {
+	list, err := readCollection[[]string](s, key, models.TypeList)
+	if err != nil {
+		return nil, fmt.Errorf("LRange: %w", err)
+	}
+
+	n := len(list)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []string{}, nil
+	}
+
+	return list[start : stop+1], nil
+}
+
+// LLen returns the length of the list stored under key, 0 if it does not exist
+func (s *Store) LLen(key string) (int, error) {
+	list, err := readCollection[[]string](s, key, models.TypeList)
+	if err != nil {
+		return 0, fmt.Errorf("LLen: %w", err)
+	}
+
+	return len(list), nil
+}
+
+// SAdd adds members to the set stored under key, creating it if needed
+// Returns the number of members that were not in the set yet
+func (s *Store) SAdd(key string, members ...string) (int, error) {
+	var added int
+	err := updateCollection(s, key, models.TypeSet, func(set *[]string) (int, bool, error) {
+		for _, m := range members {
+			// The set is kept sorted, so membership is a binary search
+			if i, found := slices.BinarySearch(*set, m); !found {
+				*set = slices.Insert(*set, i, m)
+				added++
+			}
+		}
+		return len(*set), added > 0, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("SAdd: %w", err)
+	}
+
+	return added, nil
+}
+
+// SRem removes members from the set stored under key and returns how many were in it
+func (s *Store) SRem(key string, members ...string) (int, error) {
+	var removed int
+	err := updateCollection(s, key, models.TypeSet, func(set *[]string) (int, bool, error) {
+		for _, m := range members {
+			if i, found := slices.BinarySearch(*set, m); found {
+				*set = slices.Delete(*set, i, i+1)
+				removed++
+			}
+		}
+		return len(*set), removed > 0, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("SRem: %w", err)
+	}
+
+	return removed, nil
+}
+
+// SMembers returns the members of the set stored under key in ascending order, empty if it does not exist
+func (s *Store) SMembers(key string) ([]string, error) {
+	set, err := readCollection[[]string](s, key, models.TypeSet)
+	if err != nil {
+		return nil, fmt.Errorf("SMembers: %w", err)
+	}
+	if set == nil {
+		set = []string{}
+	}
+
+	return set, nil
+}
+
+// SIsMember reports whether member is in the set stored under key
+func (s *Store) SIsMember(key string, member string) (bool, error) {
+	set, err := readCollection[[]string](s, key, models.TypeSet)
+	if err != nil {
+		return false, fmt.Errorf("SIsMember: %w", err)
+	}
+
+	_, found := slices.BinarySearch(set, member)
+	return found, nil
+}
+
+// HSet sets fields of the hash stored under key, creating it if needed
+// Returns the number of fields that were not in the hash yet
+func (s *Store) HSet(key string, fields map[string]string) (int, error) {
+	var added int
+	err := updateCollection(s, key, models.TypeHash, func(hash *map[string]string) (int, bool, error) {
+		if *hash == nil {
+			*hash = make(map[string]string, len(fields))
+		}
+		changed := false
+		for field, value := range fields {
+			old, found := (*hash)[field]
+			if !found {
+				added++
+			}
+			if !found || old != value {
+				(*hash)[field] = value
+				changed = true
+			}
+		}
+		return len(*hash), changed, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("HSet: %w", err)
+	}
+
+	return added, nil
+}
+
+// HGet returns the value of field in the hash stored under key
+// Returns ErrKeyNotFound if the key or the field does not exist
+func (s *Store) HGet(key string, field string) (string, error) {
+	hash, err := readCollection[map[string]string](s, key, models.TypeHash)
+	if err != nil {
+		return "", fmt.Errorf("HGet: %w", err)
+	}
+
+	value, ok := hash[field]
+	if !ok {
+		return "", fmt.Errorf("HGet: %w", ErrKeyNotFound)
+	}
+	return value, nil
+}
+
+// HDel removes fields from the hash stored under key and returns how many were in it
+func (s *Store) HDel(key string, fields ...string) (int, error) {
+	var removed int
+	err := updateCollection(s, key, models.TypeHash, func(hash *map[string]string) (int, bool, error) {
+		for _, field := range fields {
+			if _, ok := (*hash)[field]; ok {
+				delete(*hash, field)
+				removed++
+			}
+		}
+		return len(*hash), removed > 0, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("HDel: %w", err)
+	}
+
+	return removed, nil
+}
+
+// HGetAll returns all fields of the hash stored under key, empty if it does not exist
+func (s *Store) HGetAll(key string) (map[string]string, error) {
+	hash, err := readCollection[map[string]string](s, key, models.TypeHash)
+	if err != nil {
+		return nil, fmt.Errorf("HGetAll: %w", err)
+	}
+	if hash == nil {
+		hash = map[string]string{}
+	}
+
+	return hash, nil
+}
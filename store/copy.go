@@ -44,10 +44,10 @@ func copySegment(src, dst string) error {
 // The operation performs the following steps:
 // 1. Removes the destination directory if it exists (ensures clean state)
 // 2. Creates the destination directory with 0755 permissions
-// 3. Scans the source directory for segment files matching the pattern (seg*.log)
-// 4. Copies each segment file using copySegment
+// 3. Scans the source directory for segment files matching the pattern (seg*.log) and the superblock
+// 4. Copies each of them using copySegment
 //
-// Only segment files matching segmentFilePattern are copied - directories and
+// Only segment files matching segmentFilePattern, their seals, and the superblock are copied - directories and
 // other files are skipped. This ensures only valid database files are copied.
 //
 // Returns an error if:
@@ -74,10 +74,10 @@ func copyDB(source, destination string) error {
 		return err
 	}
 
-	// Copy only segment files (skip directories and non-segment files)
+	// Copy only segment files and the superblock (skip directories and other files)
 	for _, entry := range entries {
 		name := entry.Name()
-		if entry.IsDir() || !segmentFilePattern.MatchString(name) {
+		if entry.IsDir() || !isDatabaseFile(name) {
 			continue
 		}
 
@@ -0,0 +1,370 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+Deletion history:
+
+Every tombstone records when its key was deleted, why (a delete or an eviction), and the subject of the request that
+deleted it, see KVStashRequest.Subject. Tombstones only last until compaction or segment retention drops them, so with
+a deletion history log set, the tombstones of the segments about to be dropped are first appended to it, one JSON
+models.KVStashDeletion per line. If that fails, the segments are kept and the compaction cycle fails.
+
+DeletionHistory answers when a key was deleted and by whom from the tombstones still in the database and the log.
+A compaction that failed after archiving archives the same tombstones again, so entries are told apart by revision.
+The log has its own retention: entries of deletions older than it are pruned whenever tombstones are archived.
+*/
+
+// deletionHistory is the configuration of the deletion history log
+type deletionHistory struct {
+	// mu serializes appends to and reads of the log
+	mu sync.Mutex
+
+	// path is the log file, "" if tombstones are not archived
+	path string
+
+	// retention is the age after which deletions are pruned from the log, 0 if they are kept
+	retention time.Duration
+
+	// archived counts the tombstones appended to the log since the store was opened
+	archived int64
+}
+
+// DeletionHistoryStats describes the deletion history log
+type DeletionHistoryStats struct {
+	// Path is the log file, "" if tombstones are not archived
+	Path string
+
+	// Retention is the age after which deletions are pruned from the log, 0 if they are kept
+	Retention time.Duration
+
+	// Archived counts the tombstones appended to the log since the store was opened
+	Archived int64
+}
+
+// tombstoneInfo is the value of a tombstone
+type tombstoneInfo struct {
+	// At is when the key was deleted in Unix milliseconds
+	At int64 `json:"at"`
+
+	// Event is why the key was deleted, see models.KVStashDeletion.Event
+	Event string `json:"event,omitempty"`
+
+	// By is the subject of the request that deleted the key
+	By string `json:"by,omitempty"`
+}
+
+// encodeTombstone returns the value of a tombstone of a deletion at now
+func encodeTombstone(now time.Time, event string, by string) string {
+	data, err := json.Marshal(tombstoneInfo{At: now.UnixMilli(), Event: event, By: by})
+	if err != nil {
+		// A struct of strings and a number always marshals
+		panic(err)
+	}
+	return string(data)
+}
+
+// deletion returns the deletion of key recorded by rec, a tombstone
+// Tombstones written before they recorded their deletion have an empty value, and yield a deletion without a time
+func (rec *record) deletion(key string) models.KVStashDeletion {
+	d := models.KVStashDeletion{Revision: rec.revision}
+	d.Key, d.KeyEncoding = models.EncodeKey(key, models.KeyEncodingFor(key)), models.KeyEncodingFor(key)
+
+	var info tombstoneInfo
+	if rec.data.Value != "" && json.Unmarshal([]byte(rec.data.Value), &info) == nil {
+		if info.At != 0 {
+			d.DeletedAt = time.UnixMilli(info.At).UTC()
+		}
+		d.Event, d.By = info.Event, info.By
+	}
+	return d
+}
+
+// SetDeletionHistory appends the tombstones dropped from now on to the log file path; an empty path stops
+// archiving them
+func (s *Store) SetDeletionHistory(path string) {
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+
+	if s.deletions.path != path {
+		logging.Infof("SetDeletionHistory: %q -> %q", s.deletions.path, path)
+	}
+	s.deletions.path = path
+}
+
+// SetDeletionHistoryRetention prunes the deletions older than retention from the deletion history log the next time
+// tombstones are archived; 0 keeps them all
+// Returns an error if retention is negative
+func (s *Store) SetDeletionHistoryRetention(retention time.Duration) error {
+	if retention < 0 {
+		return fmt.Errorf("SetDeletionHistoryRetention: retention must not be negative, got %v", retention)
+	}
+
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+
+	if s.deletions.retention != retention {
+		logging.Infof("SetDeletionHistoryRetention: %v -> %v", s.deletions.retention, retention)
+	}
+	s.deletions.retention = retention
+	return nil
+}
+
+// DeletionHistoryStats returns the configuration of the deletion history log and the tombstones archived
+func (s *Store) DeletionHistoryStats() DeletionHistoryStats {
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+
+	return DeletionHistoryStats{Path: s.deletions.path, Retention: s.deletions.retention, Archived: s.deletions.archived}
+}
+
+// DeletionHistory returns the deletions of key known to the store, oldest first: the tombstones still in the
+// database and those archived to the deletion history log, see SetDeletionHistory
+// Deletions pruned from the log, or dropped while no log was set, are not returned
+func (s *Store) DeletionHistory(key string) ([]models.KVStashDeletion, error) {
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	dbPath := s.dbPath
+	s.mu.RUnlock()
+
+	// The segments are scanned before the log, so a tombstone dropped in between was archived by then
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("DeletionHistory: %w", err)
+	}
+	byRevision := make(map[uint64]models.KVStashDeletion)
+	for _, segment := range segments {
+		if s.skipSegment(segment, key) {
+			continue
+		}
+		err := s.scanTombstones(dbPath, segment, func(tombKey string, d models.KVStashDeletion) {
+			if tombKey == key {
+				byRevision[d.Revision] = d
+			}
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("DeletionHistory: %w", err)
+		}
+	}
+
+	archived, err := s.readDeletionHistory()
+	if err != nil {
+		return nil, fmt.Errorf("DeletionHistory: %w", err)
+	}
+	for _, d := range archived {
+		if raw, err := models.DecodeKey(d.Key, d.KeyEncoding); err == nil && s.normalization.Key(raw) == key {
+			byRevision[d.Revision] = d
+		}
+	}
+
+	deletions := make([]models.KVStashDeletion, 0, len(byRevision))
+	for _, d := range byRevision {
+		deletions = append(deletions, d)
+	}
+	slices.SortFunc(deletions, func(a, b models.KVStashDeletion) int {
+		return cmp.Compare(a.Revision, b.Revision)
+	})
+	return deletions, nil
+}
+
+// scanTombstones calls fn with the key and deletion of every committed tombstone in segment of dbPath
+// Tombstones of a batch that was not committed are skipped, and reading stops at the first corrupted record
+func (s *Store) scanTombstones(dbPath string, segment string, fn func(key string, d models.KVStashDeletion)) error {
+	file, err := os.Open(filepath.Join(dbPath, segment))
+	if err != nil {
+		return fmt.Errorf("scanTombstones: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("scanTombstones: failed to stat %v: %w", segment, err)
+	}
+	end, err := segmentEnd(dbPath, segment, info.Size())
+	if err != nil {
+		return fmt.Errorf("scanTombstones: %w", err)
+	}
+
+	type pendingTombstone struct {
+		key string
+		d   models.KVStashDeletion
+	}
+	var pending []pendingTombstone
+	for pos := int64(0); ; {
+		rec, err := readRecord(file, end, pos)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logging.Warnf("scanTombstones: stopped reading %v at offset %d: %v", segment, pos, err)
+			return nil
+		}
+		pos = rec.end()
+
+		if rec.deleted() {
+			key := s.normalization.Key(rec.data.Key)
+			pending = append(pending, pendingTombstone{key, rec.deletion(key)})
+		}
+		if rec.metadata.GetMetadataFlagValue(constants.FlagBatch) {
+			continue
+		}
+		for _, p := range pending {
+			fn(p.key, p.d)
+		}
+		pending = pending[:0]
+	}
+}
+
+// archiveTombstones appends the tombstones of segments, which are about to be dropped, to the deletion history log
+// and prunes the deletions older than its retention; nothing is done if no log is set
+// Must be called with mu held
+func (s *Store) archiveTombstones(segments []string) error {
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+
+	if s.deletions.path == "" {
+		return nil
+	}
+
+	now := s.now().UTC()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	count := 0
+	for _, segment := range segments {
+		err := s.scanTombstones(s.dbPath, segment, func(key string, d models.KVStashDeletion) {
+			d.ArchivedAt = now
+			enc.Encode(d)
+			count++
+		})
+		if err != nil {
+			return fmt.Errorf("archiveTombstones: %w", err)
+		}
+	}
+
+	if count > 0 {
+		if err := appendSynced(s.deletions.path, buf.Bytes()); err != nil {
+			return fmt.Errorf("archiveTombstones: %w", err)
+		}
+		s.deletions.archived += int64(count)
+		logging.Infof("archiveTombstones: archived %d tombstones of %d segments to %v", count, len(segments), s.deletions.path)
+	}
+
+	if s.deletions.retention > 0 {
+		if err := s.pruneDeletionHistory(now.Add(-s.deletions.retention)); err != nil {
+			// The tombstones are archived, an oversized log must not keep them in the database
+			logging.Errorf("archiveTombstones: %v", err)
+		}
+	}
+	return nil
+}
+
+// pruneDeletionHistory rewrites the deletion history log without the deletions before cutoff; deletions without a
+// time are pruned by the time they were archived
+// Must be called with deletions.mu held
+func (s *Store) pruneDeletionHistory(cutoff time.Time) error {
+	deletions, err := readDeletions(s.deletions.path)
+	if err != nil {
+		return fmt.Errorf("pruneDeletionHistory: %w", err)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	pruned := 0
+	for _, d := range deletions {
+		if cmp.Or(d.DeletedAt, d.ArchivedAt).Before(cutoff) {
+			pruned++
+			continue
+		}
+		enc.Encode(d)
+	}
+	if pruned == 0 {
+		return nil
+	}
+
+	tmp := s.deletions.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("pruneDeletionHistory: %w", err)
+	}
+	if err := os.Rename(tmp, s.deletions.path); err != nil {
+		return fmt.Errorf("pruneDeletionHistory: %w", err)
+	}
+	logging.Infof("pruneDeletionHistory: pruned %d deletions before %v", pruned, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+// readDeletionHistory returns the deletions in the deletion history log, none if no log is set
+func (s *Store) readDeletionHistory() ([]models.KVStashDeletion, error) {
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+
+	if s.deletions.path == "" {
+		return nil, nil
+	}
+	return readDeletions(s.deletions.path)
+}
+
+// readDeletions reads the deletion history log at path; a missing log holds no deletions
+// A torn last line, left by a crash during an append, is skipped
+func readDeletions(path string) ([]models.KVStashDeletion, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("readDeletions: %w", err)
+	}
+	defer file.Close()
+
+	var deletions []models.KVStashDeletion
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 2*constants.MaxKeySize+4096)
+	for scanner.Scan() {
+		var d models.KVStashDeletion
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			logging.Warnf("readDeletions: skipping a malformed line of %v: %v", path, err)
+			continue
+		}
+		deletions = append(deletions, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("readDeletions: %w", err)
+	}
+	return deletions, nil
+}
+
+// appendSynced appends data to the file at path, creating it if needed, and syncs it to disk
+func appendSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("appendSynced: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("appendSynced: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("appendSynced: %w", err)
+	}
+	return file.Close()
+}
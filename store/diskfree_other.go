@@ -0,0 +1,10 @@
+//go:build !unix
+
+package store
+
+import "errors"
+
+// diskSpace is not implemented on this platform
+func diskSpace(path string) (free uint64, total uint64, err error) {
+	return 0, 0, errors.New("not supported on this platform")
+}
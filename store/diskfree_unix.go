@@ -0,0 +1,16 @@
+//go:build unix
+
+package store
+
+import "syscall"
+
+// diskSpace returns the number of bytes available to unprivileged users and the size in bytes of the filesystem
+// holding path
+func diskSpace(path string) (free uint64, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"time"
+)
+
+// ErrDiskFull is returned by writes while free disk space is below the store's minimum, see DiskStats
+var ErrDiskFull = errors.New("free disk space is below the configured minimum, writes are disabled")
+
+// DiskStats describes the free space on the database volume
+// While it is below MinFreeBytes, writes fail with ErrDiskFull and automatic compaction is skipped;
+// both resume on their own once space is freed
+type DiskStats struct {
+	// FreeBytes is the free space available to the server at the last check
+	FreeBytes uint64
+
+	// TotalBytes is the size of the volume at the last check
+	TotalBytes uint64
+
+	// MinFreeBytes is the threshold below which writes are refused, 0 if the watchdog is disabled
+	MinFreeBytes int64
+
+	// Low indicates that FreeBytes was below MinFreeBytes at the last check
+	Low bool
+
+	// CheckedAt is the time of the last check (zero if the watchdog is disabled or unsupported)
+	CheckedAt time.Time
+}
+
+// MinFreeBytes returns the free disk space below which writes are refused
+func (s *Store) MinFreeBytes() int64 {
+	return s.minFreeBytes.Load()
+}
+
+// SetMinFreeBytes changes the free disk space below which writes are refused; 0 disables the watchdog
+func (s *Store) SetMinFreeBytes(n int64) error {
+	if n < 0 {
+		return fmt.Errorf("SetMinFreeBytes: minimum must not be negative, got %d", n)
+	}
+
+	s.minFreeBytes.Store(n)
+
+	// Re-evaluate on the next write instead of waiting for the check interval
+	s.statsMu.Lock()
+	s.disk.CheckedAt = time.Time{}
+	s.disk.Low = false
+	s.statsMu.Unlock()
+	return nil
+}
+
+// refreshDisk measures the free disk space if the last check is older than constants.DiskCheckInterval
+// Returns the latest measurement; transitions into and out of the low state are logged
+func (s *Store) refreshDisk() DiskStats {
+	minFree := s.minFreeBytes.Load()
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.disk.MinFreeBytes = minFree
+	if minFree <= 0 {
+		s.disk.Low = false
+		return s.disk
+	}
+	if time.Since(s.disk.CheckedAt) < constants.DiskCheckInterval*time.Second {
+		return s.disk
+	}
+
+	free, total, err := diskSpace(s.dbPath)
+	s.disk.CheckedAt = time.Now()
+	if err != nil {
+		// Refusing writes because the check itself fails would turn an unsupported platform into an outage
+		s.disk.Low = false
+		return s.disk
+	}
+
+	low := free < uint64(minFree)
+	if low && !s.disk.Low {
+		s.raiseAlert(models.AlertDiskFull, models.SeverityCritical,
+			"%d bytes free on %v, below the minimum of %d, writes are disabled", free, s.dbPath, minFree)
+	} else if !low && s.disk.Low {
+		s.raiseAlert(models.AlertDiskRecovered, models.SeverityResolved,
+			"%d bytes free on %v, writes are enabled again", free, s.dbPath)
+	}
+	s.disk.FreeBytes = free
+	s.disk.TotalBytes = total
+	s.disk.Low = low
+	return s.disk
+}
+
+// checkDiskWritable returns ErrDiskFull if free disk space is below the minimum
+func (s *Store) checkDiskWritable() error {
+	if s.refreshDisk().Low {
+		return ErrDiskFull
+	}
+	return nil
+}
+
+// compactionFits reports whether a compaction cycle of a database of size bytes can run
+// Compaction keeps a backup and a compacted copy next to the database, so up to twice its size is needed on
+// top of the minimum free space
+func (s *Store) compactionFits(size int64) bool {
+	disk := s.refreshDisk()
+	if disk.MinFreeBytes <= 0 || disk.CheckedAt.IsZero() {
+		return true
+	}
+
+	if disk.Low || disk.FreeBytes < uint64(2*size+disk.MinFreeBytes) {
+		logging.Infof("compact: skipping cycle, %d bytes free but compacting %d bytes needs %d", disk.FreeBytes, size, 2*size+disk.MinFreeBytes)
+		return false
+	}
+	return true
+}
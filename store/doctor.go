@@ -0,0 +1,301 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Severity levels of doctor findings
+const (
+	SeverityOK    = "ok"
+	SeverityWarn  = "warn"
+	SeverityError = "error"
+)
+
+// slowFsyncThreshold is the fsync latency above which writes are reported as slow
+// Every write is synchronous, so this latency is paid by every Set and Delete
+const slowFsyncThreshold = 50 * time.Millisecond
+
+// Finding is the result of a single doctor check
+type Finding struct {
+	// Check is the name of the check
+	Check string
+
+	// Severity is one of SeverityOK, SeverityWarn, or SeverityError
+	Severity string
+
+	// Message describes what was found
+	Message string
+
+	// Fix suggests an action for warnings and errors
+	Fix string
+}
+
+// Diagnose runs environment checks against the database directory at dbPath
+// Checks cover the directory itself, leftovers of interrupted compactions and maintenance runs,
+// segment naming, on-disk format, free disk space, and fsync support of the filesystem
+// The database is not opened, so Diagnose is safe to run while the server is stopped or running
+func Diagnose(dbPath string) []Finding {
+	findings := []Finding{checkDirectory(dbPath)}
+	if findings[0].Severity == SeverityError {
+		return findings
+	}
+
+	findings = append(findings, checkLeftovers(dbPath)...)
+	findings = append(findings, checkSegments(dbPath)...)
+	findings = append(findings, checkDiskSpace(dbPath))
+	findings = append(findings, checkFsync(dbPath))
+
+	return findings
+}
+
+// checkDirectory verifies that dbPath is a directory the process can read and write
+func checkDirectory(dbPath string) Finding {
+	f := Finding{Check: "directory"}
+
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("cannot access %v: %v", dbPath, err)
+		f.Fix = "check the database path; it is created on first start if the parent directory is writable"
+		return f
+	}
+
+	if !info.IsDir() {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("%v is not a directory", dbPath)
+		f.Fix = "move the file out of the way or point the server at another path"
+		return f
+	}
+
+	if _, err := os.ReadDir(dbPath); err != nil {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("cannot list %v: %v", dbPath, err)
+		f.Fix = "grant the server user read and execute permission on the directory"
+		return f
+	}
+
+	probe, err := os.CreateTemp(dbPath, ".doctor-*")
+	if err != nil {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("cannot create files in %v: %v", dbPath, err)
+		f.Fix = "grant the server user write permission on the directory"
+		return f
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	f.Severity = SeverityOK
+	f.Message = fmt.Sprintf("%v is readable and writable (mode %v)", dbPath, info.Mode().Perm())
+	return f
+}
+
+// checkLeftovers looks for directories left behind by interrupted compactions or maintenance commands
+func checkLeftovers(dbPath string) []Finding {
+	clean := filepath.Clean(dbPath)
+	leftovers := []struct {
+		path string
+		fix  string
+	}{
+		{constants.TmpDBPath, "an automatic compaction was interrupted; remove the directory"},
+		{constants.BackupDBPath, "an automatic compaction was interrupted; verify the database, then remove the backup " +
+			"(it is only restored automatically if the database directory is missing)"},
+		{clean + ".compact", "kvstash-admin compact was interrupted; remove the directory"},
+		{clean + ".upgrade", "kvstash-admin upgrade was interrupted; remove the directory"},
+		{clean + ".bkp", "a kvstash-admin compact/upgrade backup; verify the database, then remove it"},
+	}
+
+	findings := []Finding{}
+	for _, leftover := range leftovers {
+		if _, err := os.Stat(leftover.path); err == nil {
+			findings = append(findings, Finding{
+				Check:    "leftovers",
+				Severity: SeverityWarn,
+				Message:  fmt.Sprintf("found leftover directory %v", leftover.path),
+				Fix:      leftover.fix,
+			})
+		}
+	}
+
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "leftovers", Severity: SeverityOK, Message: "no leftover tmp/backup directories"})
+	}
+
+	return findings
+}
+
+// checkSegments verifies the superblock, that segments are numbered without gaps unless the superblock
+// records the active log, that no foreign files live in the database directory, and that all segments use
+// the current format
+func checkSegments(dbPath string) []Finding {
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return []Finding{{Check: "segments", Severity: SeverityError, Message: err.Error()}}
+	}
+
+	findings := []Finding{}
+
+	sb, ok, err := readSuperblock(dbPath)
+	switch {
+	case errors.Is(err, ErrNewerFormat):
+		findings = append(findings, Finding{
+			Check:    "superblock",
+			Severity: SeverityError,
+			Message:  err.Error(),
+			Fix:      "open the database with the kvstash version that wrote it",
+		})
+	case err != nil:
+		findings = append(findings, Finding{
+			Check:    "superblock",
+			Severity: SeverityError,
+			Message:  err.Error(),
+			Fix:      "remove it if the segments are numbered without gaps; the next start rewrites it from the segment files",
+		})
+	case !ok:
+		findings = append(findings, Finding{
+			Check:    "superblock",
+			Severity: SeverityWarn,
+			Message:  "no superblock, the active log is inferred from the segment files",
+			Fix:      "start the server once to write it",
+		})
+	case len(segments) > 0 && segmentNumber(segments[len(segments)-1]) > sb.activeSegment:
+		findings = append(findings, Finding{
+			Check:    "superblock",
+			Severity: SeverityWarn,
+			Message: fmt.Sprintf("superblock names %v as active log but %v exists", segmentName(sb.activeSegment),
+				segments[len(segments)-1]),
+			Fix: "the segments were copied in from elsewhere; the next start uses the highest numbered segment",
+		})
+		ok = false
+	}
+
+	// Without a superblock the active log is derived from the highest numbered segment, so a gap means
+	// a segment was lost and its keys silently fall back to older values
+	for i, segment := range segments {
+		expected := constants.SegmentNamePrefix + strconv.Itoa(i) + constants.SegmentNameExt
+		if !ok && segment != expected {
+			findings = append(findings, Finding{
+				Check:    "segments",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("segment numbering has a gap: expected %v, found %v", expected, segment),
+				Fix:      "restore the missing segment from a backup, or salvage the database into a new directory",
+			})
+			break
+		}
+	}
+
+	entries, err := os.ReadDir(dbPath)
+	if err == nil {
+		for _, e := range entries {
+			if !isDatabaseFile(e.Name()) {
+				findings = append(findings, Finding{
+					Check:    "segments",
+					Severity: SeverityWarn,
+					Message:  fmt.Sprintf("unexpected entry %v in the database directory", e.Name()),
+					Fix:      "move it elsewhere; compaction deletes the whole directory and it would be lost",
+				})
+			}
+		}
+	}
+
+	if formats, err := DetectFormat(dbPath); err != nil {
+		findings = append(findings, Finding{
+			Check:    "format",
+			Severity: SeverityError,
+			Message:  err.Error(),
+			Fix:      "run kvstash-admin verify and salvage the database",
+		})
+	} else if !formats.Current() {
+		findings = append(findings, Finding{
+			Check:    "format",
+			Severity: SeverityError,
+			Message:  "some segments use the legacy metadata format",
+			Fix:      "run kvstash-admin upgrade",
+		})
+	}
+
+	if len(findings) == 0 {
+		findings = append(findings, Finding{
+			Check:    "segments",
+			Severity: SeverityOK,
+			Message:  fmt.Sprintf("%d segments, active log %v, current format", len(segments), segmentName(sb.activeSegment)),
+		})
+	}
+
+	return findings
+}
+
+// checkDiskSpace verifies that there is enough free space for a compaction cycle
+// Compaction keeps a full backup and a compacted copy next to the database, so up to twice
+// the database size may be needed
+func checkDiskSpace(dbPath string) Finding {
+	f := Finding{Check: "disk space"}
+
+	size, err := dirSize(dbPath)
+	if err != nil {
+		f.Severity = SeverityWarn
+		f.Message = err.Error()
+		return f
+	}
+
+	free, _, err := diskSpace(dbPath)
+	if err != nil {
+		f.Severity = SeverityWarn
+		f.Message = fmt.Sprintf("cannot determine free disk space: %v", err)
+		return f
+	}
+
+	f.Message = fmt.Sprintf("%d bytes free, database uses %d bytes", free, size)
+	if free < uint64(2*size) {
+		f.Severity = SeverityWarn
+		f.Fix = "free up disk space; compaction needs up to twice the database size"
+		return f
+	}
+
+	f.Severity = SeverityOK
+	return f
+}
+
+// checkFsync verifies that the filesystem supports synchronous writes and measures their latency
+func checkFsync(dbPath string) Finding {
+	f := Finding{Check: "fsync"}
+
+	probe, err := os.CreateTemp(dbPath, ".doctor-fsync-*")
+	if err != nil {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("cannot create probe file: %v", err)
+		return f
+	}
+	defer os.Remove(probe.Name())
+	defer probe.Close()
+
+	start := time.Now()
+	if _, err := probe.Write(make([]byte, constants.MetadataSize)); err != nil {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("write failed: %v", err)
+		return f
+	}
+
+	if err := probe.Sync(); err != nil {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("fsync failed: %v", err)
+		f.Fix = "move the database to a filesystem that supports fsync; writes are not durable here"
+		return f
+	}
+	elapsed := time.Since(start)
+
+	f.Message = fmt.Sprintf("write+fsync took %v", elapsed)
+	if elapsed > slowFsyncThreshold {
+		f.Severity = SeverityWarn
+		f.Fix = "every write is synchronous; expect write latency of at least this much, consider faster storage"
+		return f
+	}
+
+	f.Severity = SeverityOK
+	return f
+}
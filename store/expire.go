@@ -0,0 +1,133 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"time"
+)
+
+/*
+Expiry updates:
+
+A key's expiry time is set by the write that stores its value, either as a TTL or as an absolute Unix time
+(KVStashRequest.ExpireAt). Expire and ExpireAt change it afterwards without rewriting the value: they append a small
+record carrying FlagExpiry, the key, and the new expiry time, and point the key's index entry at the same value
+record as before with the new expiry time and a new revision. Recovery applies an expiry record to the index entry
+of its key, and compaction copies the value with the expiry time it ended up with, dropping the expiry records.
+
+An expiry time in the past expires the key right away: reads treat it as missing and an EventExpire follows. Every
+change is published as an EventExpiry.
+*/
+
+// validateExpiry checks the TTL in seconds and the expiry Unix time of a write, at most one of which may be set
+func validateExpiry(ttl int64, expireAt int64) error {
+	if ttl < -1 {
+		return fmt.Errorf("%w, got %d", ErrBadTTL, ttl)
+	}
+	if expireAt < 0 || (expireAt > 0 && ttl != 0) {
+		return fmt.Errorf("%w, got %d with a ttl of %d", ErrBadExpireAt, expireAt, ttl)
+	}
+	return nil
+}
+
+// expireAtTime returns the time of an expiry Unix time in seconds, the zero time for 0
+func expireAtTime(expireAt int64) time.Time {
+	if expireAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(expireAt, 0)
+}
+
+// Expire changes the expiry time of key to ttl from now without rewriting its value; a negative ttl makes it
+// never expire
+// Returns the version of the update
+// Returns ErrKeyNotFound if the key does not exist, ErrBadTTL for a ttl of 0, and the key validation errors of Set
+// (client errors)
+// Returns other errors for server-side failures
+func (s *Store) Expire(key string, ttl time.Duration) (*models.KVStashVersion, error) {
+	if ttl == 0 {
+		return nil, fmt.Errorf("Expire: %w, got 0", ErrBadTTL)
+	}
+
+	at := time.Time{}
+	if ttl > 0 {
+		at = s.now().Add(ttl)
+	}
+	return s.expire(key, at)
+}
+
+// ExpireAt changes the expiry time of key to at without rewriting its value; the zero time makes it never expire
+// and a time in the past expires it right away
+// Returns the version of the update
+// Returns ErrKeyNotFound if the key does not exist and the key validation errors of Set (client errors)
+// Returns other errors for server-side failures
+func (s *Store) ExpireAt(key string, at time.Time) (*models.KVStashVersion, error) {
+	return s.expire(key, at)
+}
+
+// expire appends an expiry record setting the expiry time of key to at, or no expiry time if at is zero
+func (s *Store) expire(key string, at time.Time) (*models.KVStashVersion, error) {
+	t := s.startOp(OpExpire)
+	defer t.finish(nil)
+
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	expiresAt := int64(0)
+	if !at.IsZero() {
+		expiresAt = at.UnixMilli()
+	}
+
+	t.lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.lookup(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	if err := s.checkWritable(); err != nil {
+		return nil, fmt.Errorf("expire: %w", err)
+	}
+	if err := s.logRotation(); err != nil {
+		s.recordWrite(err)
+		return nil, fmt.Errorf("expire: failed to rotate log: %w", err)
+	}
+
+	revision := s.nextRevision(0)
+	data, err := codec.EncodeRevisedPayload(key, "", expiresAt, revision)
+	if err != nil {
+		return nil, fmt.Errorf("expire: failed to serialize: %w", err)
+	}
+	start := time.Now()
+	_, err = s.writer.Write(data, []int64{constants.FlagExpiry})
+	t.add(phaseWrite, start)
+	s.recordWrite(err)
+	if err != nil {
+		s.failover(err)
+		return nil, fmt.Errorf("expire: failed to write: %w", err)
+	}
+	s.amp.record(data)
+
+	s.setEntry(key, withExpiry(entry, expiresAt, revision))
+	s.activeLogCount++
+	s.publish(models.EventExpiry, key, revision)
+	logging.Debugf("expire: key=%v expires at %d", redact.Key(key), expiresAt)
+
+	return s.version(key), nil
+}
+
+// withExpiry returns a copy of entry expiring at expiresAt in Unix milliseconds, updated at revision
+// The copy points at the same value record; index entries are replaced, never changed, see expiryQueue
+func withExpiry(entry *models.KVStashIndexEntry, expiresAt int64, revision uint64) *models.KVStashIndexEntry {
+	updated := *entry
+	updated.ExpiresAt = expiresAt
+	updated.Revision = revision
+	return &updated
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"container/heap"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"time"
+)
+
+/*
+Expiry notifications:
+
+Expired keys are not removed when their time comes: reads treat them as missing and compaction drops them. So that
+caches and downstream systems can react to keys aging out, every index entry with an expiry time is queued in
+expiries, ordered by it, and expireKeys publishes an EventExpire on the changefeed for each one that expired, like
+Redis' expired events. An entry that was replaced by a write or a delete before it expired is skipped, so overwritten
+and deleted keys are not announced; their set or delete event was published instead.
+
+The queue is kept in memory only. Keys that had already expired when the store was opened are not announced, their
+event may have been published before the restart.
+*/
+
+// expiryItem is an index entry with an expiry time and its key
+type expiryItem struct {
+	key   string
+	entry *models.KVStashIndexEntry
+}
+
+// expiryQueue is a min-heap of index entries ordered by expiry time, see container/heap
+type expiryQueue []expiryItem
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].entry.ExpiresAt < q[j].entry.ExpiresAt }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x any) {
+	*q = append(*q, x.(expiryItem))
+}
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// scheduleExpiries replaces the queue with every live index entry that has an expiry time
+// Must be called with mu held (or before the store is shared)
+func (s *Store) scheduleExpiries() {
+	now := s.now().UnixMilli()
+	queue := make(expiryQueue, 0)
+	for key, entry := range s.index {
+		if live(entry, now) && entry.ExpiresAt != 0 {
+			queue = append(queue, expiryItem{key: key, entry: entry})
+		}
+	}
+	heap.Init(&queue)
+	s.expiries = queue
+}
+
+// scheduleExpiry queues entry, the new index entry of key, if it has an expiry time
+// The entries it replaced stay queued and are skipped when they are due; the queue is rebuilt if they pile up
+// Must be called with mu held
+func (s *Store) scheduleExpiry(key string, entry *models.KVStashIndexEntry) {
+	if entry.Deleted || entry.ExpiresAt == 0 {
+		return
+	}
+
+	heap.Push(&s.expiries, expiryItem{key: key, entry: entry})
+	if len(s.expiries) > 2*len(s.index) {
+		s.scheduleExpiries()
+	}
+}
+
+// expireKeys announces expired keys every ExpiryCheckInterval, and saves the store's time every
+// constants.ClockSaveInterval seconds, until the store is closed
+func (s *Store) expireKeys() {
+	for s.sleep(JobExpiry, constants.ExpiryCheckInterval*time.Millisecond) {
+		// Checked under the read lock first, so reads are not blocked while nothing expired
+		s.mu.RLock()
+		due := len(s.expiries) > 0 && s.expiries[0].entry.ExpiresAt <= s.now().UnixMilli()
+		s.mu.RUnlock()
+
+		for more := due; more; {
+			s.mu.Lock()
+			more = s.announceExpired(constants.MaxExpiredPerCheck)
+			s.mu.Unlock()
+		}
+
+		s.saveClock()
+	}
+}
+
+// announceExpired publishes an EventExpire for up to limit queued entries that expired and are still the
+// current index entries of their keys
+// Returns true if more expired entries are queued
+// Must be called with mu held
+func (s *Store) announceExpired(limit int) bool {
+	now := s.now().UnixMilli()
+	for n := 0; len(s.expiries) > 0 && s.expiries[0].entry.ExpiresAt <= now; {
+		if n == limit {
+			return true
+		}
+
+		item := heap.Pop(&s.expiries).(expiryItem)
+		if s.index[item.key] != item.entry {
+			continue
+		}
+
+		s.forget(item.key)
+		s.feed.publish(models.EventExpire, item.key, item.entry.Revision)
+		logging.Debugf("announceExpired: key=%v expired", redact.Key(item.key))
+		n++
+	}
+
+	return false
+}
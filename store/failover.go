@@ -0,0 +1,153 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Writer failover:
+
+A write that fails part way (EIO, ENOSPC) can leave a torn record at the end of the active log. Instead of appending
+the next records behind it, failover seals the active log at the start of the failed record and continues in a new
+segment, which the filesystem places on fresh blocks.
+
+Sealing truncates the torn record. If even that fails, the bytes after the seal are suspect: the seal is recorded in
+a file next to the segment (seg3.log.sealed holding the offset), and recovery, verify, and compaction read the
+segment only up to it. Compaction drops the suspect bytes with the old segments.
+
+If the active log cannot be sealed or no new segment can be created, the breaker trips, see ResumeWrites. Batches
+are not failed over; their rollback truncates the active log to the start of the batch.
+*/
+
+// sealFilePattern matches the seal files of segments
+var sealFilePattern = regexp.MustCompile(`^seg(\d+)\.log\` + constants.SealExt + `$`)
+
+// isDatabaseFile reports whether name is a segment file, the superblock, a seal file, the index snapshot, or a
+// segment filter
+func isDatabaseFile(name string) bool {
+	return segmentFilePattern.MatchString(name) || sealFilePattern.MatchString(name) ||
+		name == constants.SuperblockName || name == constants.IndexSnapshotName || filterFilePattern.MatchString(name)
+}
+
+// failover seals the active log after a write failed with cause and continues in a new segment
+// Must be called with mu held
+func (s *Store) failover(cause error) {
+	if s.batch != nil || s.writer == nil {
+		return
+	}
+
+	sealed := s.activeLog
+	offset := s.writer.offset
+	if err := s.writer.truncate(offset); err != nil {
+		logging.Warnf("failover: %v: the bytes after offset %d are suspect: %v", sealed, offset, err)
+		if err := writeSeal(s.dbPath, sealed, offset); err != nil {
+			s.degrade(fmt.Errorf("failed to seal %v after %v: %w", sealed, cause, err))
+			return
+		}
+	}
+
+	// The old handle may be unusable after the failure, so it is dropped even if closing fails
+	if err := s.closeWriter(); err != nil {
+		logging.Errorf("failover: failed to close %v: %v", sealed, err)
+		s.writer = nil
+	}
+
+	if err := s.rotate(); err != nil {
+		s.degrade(fmt.Errorf("failed to continue in a new segment after %v was sealed: %w", sealed, err))
+		return
+	}
+
+	s.statsMu.Lock()
+	s.breaker.Failovers++
+	s.breaker.LastFailover = time.Now()
+	s.statsMu.Unlock()
+
+	logging.Errorf("failover: sealed %v at offset %d after a failed write, writing to %v: %v", sealed, offset, s.activeLog, cause)
+	s.raiseAlert(models.AlertWriterFailover, models.SeverityCritical,
+		"a write to %v failed, it was sealed at offset %d and writes continue in %v: %v", sealed, offset, s.activeLog, cause)
+}
+
+// writeSeal records that segment ends at offset, the bytes after it being suspect
+func writeSeal(dbPath string, segment string, offset int64) error {
+	file, err := os.OpenFile(filepath.Join(dbPath, segment+constants.SealExt), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("writeSeal: %w", err)
+	}
+	if _, err := file.WriteString(strconv.FormatInt(offset, 10)); err != nil {
+		file.Close()
+		return fmt.Errorf("writeSeal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("writeSeal: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writeSeal: %w", err)
+	}
+	return nil
+}
+
+// readSeal returns the offset segment was sealed at, false if it is not sealed
+func readSeal(dbPath string, segment string) (int64, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dbPath, segment+constants.SealExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("readSeal: %w", err)
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, false, fmt.Errorf("readSeal: invalid seal of %v: %q", segment, data)
+	}
+	return offset, true, nil
+}
+
+// removeSeal deletes the seal of segment once the bytes after it are gone
+func removeSeal(dbPath string, segment string) error {
+	if err := os.Remove(filepath.Join(dbPath, segment+constants.SealExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removeSeal: %w", err)
+	}
+	return nil
+}
+
+// segmentEnd returns where the records of segment, a file of fileSize bytes, end: at its seal if it has one
+func segmentEnd(dbPath string, segment string, fileSize int64) (int64, error) {
+	offset, sealed, err := readSeal(dbPath, segment)
+	if err != nil {
+		return 0, fmt.Errorf("segmentEnd: %w", err)
+	}
+	if !sealed || offset >= fileSize {
+		return fileSize, nil
+	}
+
+	logging.Warnf("segmentEnd: ignoring %d suspect bytes after the seal of %v at offset %d", fileSize-offset, segment, offset)
+	return offset, nil
+}
+
+// leaveSealedActiveLog continues in a new segment if the active log was sealed but the store stopped before it
+// switched to a new one, so no record is appended behind the suspect bytes
+// Must be called before the writer is opened
+func (s *Store) leaveSealedActiveLog() error {
+	if _, sealed, err := readSeal(s.dbPath, s.activeLog); err != nil || !sealed {
+		return err
+	}
+
+	logging.Infof("leaveSealedActiveLog: %v is sealed, writing to %v", s.activeLog, segmentName(s.nextSegment))
+	s.activeLog = segmentName(s.nextSegment)
+	s.activeLogCount = 0
+	s.segmentCount++
+	s.nextSegment++
+	return nil
+}
@@ -0,0 +1,239 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/redact"
+	"strings"
+)
+
+// ErrBadPattern indicates that a glob pattern is malformed
+var ErrBadPattern = errors.New("malformed glob pattern")
+
+// Pattern is a compiled glob pattern matched against whole keys
+//
+// Syntax:
+//
+//	pattern  matches
+//	c        the byte c
+//	*        any sequence of bytes, including none
+//	?        exactly one byte
+//	[abc]    one of the listed bytes
+//	[a-z]    one byte in the range
+//	[!a-z]   one byte not in the range
+//	\c       the byte c literally
+//
+// Keys are arbitrary bytes, so patterns work on bytes, not characters: ? matches one byte of a multi-byte
+// UTF-8 character, and ranges compare byte values
+// For example, user:*:profile matches user:1:profile and user:alice:profile
+// The literal text before the first wildcard is the pattern's prefix: only keys starting with
+// it can match, so scans seek straight to it in the sorted key list and stop once they pass it
+type Pattern struct {
+	// glob is the pattern as given to CompilePattern
+	glob string
+
+	// prefix is the literal text preceding the first wildcard
+	prefix string
+
+	// rest is the remainder of the pattern after prefix, or "" for a purely literal pattern
+	rest string
+
+	// literal indicates that the pattern contains no wildcards
+	literal bool
+}
+
+// CompilePattern parses a glob pattern
+// Returns ErrBadPattern if a character class is unterminated or the pattern ends with a backslash
+func CompilePattern(glob string) (*Pattern, error) {
+	var prefix strings.Builder
+	p := &Pattern{glob: glob, literal: true}
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		if c == '\\' {
+			if i+1 == len(glob) {
+				return nil, fmt.Errorf("CompilePattern: %q: trailing backslash: %w", redact.Key(glob), ErrBadPattern)
+			}
+			i++
+			prefix.WriteByte(glob[i])
+			continue
+		}
+		if c == '*' || c == '?' || c == '[' {
+			p.rest = glob[i:]
+			p.literal = false
+			break
+		}
+		prefix.WriteByte(c)
+	}
+	p.prefix = prefix.String()
+
+	// Validate the wildcard part once so Match never has to report errors
+	if err := validateGlob(p.rest); err != nil {
+		return nil, fmt.Errorf("CompilePattern: %q: %w", redact.Key(glob), err)
+	}
+
+	return p, nil
+}
+
+// MatchPattern reports whether key matches the glob pattern
+func MatchPattern(glob string, key string) (bool, error) {
+	p, err := CompilePattern(glob)
+	if err != nil {
+		return false, err
+	}
+	return p.Match(key), nil
+}
+
+// String returns the pattern as given to CompilePattern
+func (p *Pattern) String() string {
+	return p.glob
+}
+
+// Prefix returns the literal prefix every matching key starts with
+func (p *Pattern) Prefix() string {
+	return p.prefix
+}
+
+// Literal reports whether the pattern has no wildcards, i.e. matches only the key Prefix()
+func (p *Pattern) Literal() bool {
+	return p.literal
+}
+
+// Match reports whether key matches the whole pattern
+func (p *Pattern) Match(key string) bool {
+	if !strings.HasPrefix(key, p.prefix) {
+		return false
+	}
+	if p.literal {
+		return len(key) == len(p.prefix)
+	}
+	return matchGlob(p.rest, key[len(p.prefix):])
+}
+
+// validateGlob checks that every character class and escape in glob is well formed
+func validateGlob(glob string) error {
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			if i+1 == len(glob) {
+				return fmt.Errorf("trailing backslash: %w", ErrBadPattern)
+			}
+			i++
+		case '[':
+			end, ok := classEnd(glob, i)
+			if !ok {
+				return fmt.Errorf("unterminated character class: %w", ErrBadPattern)
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+// classEnd returns the index of the ']' closing the character class starting at glob[start]
+// A ']' immediately after '[' or '[!' is part of the class
+func classEnd(glob string, start int) (int, bool) {
+	i := start + 1
+	if i < len(glob) && glob[i] == '!' {
+		i++
+	}
+	if i < len(glob) && glob[i] == ']' {
+		i++
+	}
+	for ; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			i++
+		case ']':
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// matchClass reports whether c matches the character class glob[start:end+1]
+func matchClass(glob string, start int, end int, c byte) bool {
+	i := start + 1
+	negate := false
+	if glob[i] == '!' {
+		negate = true
+		i++
+	}
+
+	matched := false
+	for i < end {
+		lo := glob[i]
+		if lo == '\\' {
+			i++
+			lo = glob[i]
+		}
+		i++
+		hi := lo
+		if i+1 < end && glob[i] == '-' {
+			hi = glob[i+1]
+			if hi == '\\' {
+				hi = glob[i+2]
+				i++
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+
+	return matched != negate
+}
+
+// matchGlob reports whether s matches the validated glob
+// Matching is done byte by byte with a single backtracking point for the last '*', which
+// keeps it linear for the typical patterns with one or two wildcards
+func matchGlob(glob string, s string) bool {
+	gi, si := 0, 0
+	starG, starS := -1, 0
+
+	for si < len(s) {
+		if gi < len(glob) {
+			switch c := glob[gi]; c {
+			case '*':
+				starG, starS = gi, si
+				gi++
+				continue
+			case '?':
+				gi++
+				si++
+				continue
+			case '[':
+				end, _ := classEnd(glob, gi)
+				if matchClass(glob, gi, end, s[si]) {
+					gi = end + 1
+					si++
+					continue
+				}
+			case '\\':
+				if glob[gi+1] == s[si] {
+					gi += 2
+					si++
+					continue
+				}
+			default:
+				if c == s[si] {
+					gi++
+					si++
+					continue
+				}
+			}
+		}
+		if starG < 0 {
+			return false
+		}
+		// Let the last '*' absorb one more character and retry from there
+		starS++
+		gi, si = starG+1, starS
+	}
+
+	for gi < len(glob) && glob[gi] == '*' {
+		gi++
+	}
+	return gi == len(glob)
+}
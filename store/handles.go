@@ -0,0 +1,154 @@
+package store
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileHandle is an open segment file shared by concurrent readers
+type fileHandle struct {
+	// file is the segment file opened for reading
+	file *os.File
+
+	// path is the key of the handle in the pool
+	path string
+
+	// refs is the number of readers using the handle
+	refs int
+
+	// closing indicates that the handle left the pool and is closed once refs drops to 0
+	closing bool
+
+	// elem is the handle's element in the pool's recency list
+	elem *list.Element
+}
+
+// handlePool keeps segment files open between reads, so a read does not pay for opening the file
+// At most max handles are kept; the least recently used one is closed when another segment is opened
+// Handles are reference counted, so a handle leaving the pool is only closed once its last reader is done
+// A nil pool opens and closes the file on every read
+type handlePool struct {
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// max is the number of handles kept open
+	max int
+
+	// open maps segment file paths to their handle
+	open map[string]*fileHandle
+
+	// lru orders the handles from least to most recently used
+	lru *list.List
+}
+
+// newHandlePool creates a pool keeping up to max segment files open
+func newHandlePool(max int) *handlePool {
+	return &handlePool{max: max, open: make(map[string]*fileHandle), lru: list.New()}
+}
+
+// acquire returns an open handle for the file at path, which must be released with release
+func (p *handlePool) acquire(path string) (*fileHandle, error) {
+	if p == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("acquire: %w", err)
+		}
+		return &fileHandle{file: file, path: path, refs: 1, closing: true}, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if h, ok := p.open[path]; ok {
+		h.refs++
+		p.lru.MoveToBack(h.elem)
+		return h, nil
+	}
+
+	// Opening under the lock keeps two readers from opening the same file; it only blocks other first reads
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("acquire: %w", err)
+	}
+
+	h := &fileHandle{file: file, path: path, refs: 1}
+	h.elem = p.lru.PushBack(h)
+	p.open[path] = h
+
+	for p.lru.Len() > p.max {
+		p.evict(p.lru.Front().Value.(*fileHandle))
+	}
+
+	return h, nil
+}
+
+// release ends a reader's use of h, closing it if it has left the pool
+func (p *handlePool) release(h *fileHandle) {
+	if p == nil {
+		h.file.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h.refs--
+	if h.closing && h.refs == 0 {
+		h.file.Close()
+	}
+}
+
+// closeAll removes every handle from the pool, e.g. after compaction replaced the segment files
+// Handles still in use are closed by their last release
+func (p *handlePool) closeAll() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.lru.Len() > 0 {
+		p.evict(p.lru.Front().Value.(*fileHandle))
+	}
+}
+
+// drop removes the handle of the file at path from the pool, e.g. before the file is deleted
+// A handle still in use is closed by its last release
+func (p *handlePool) drop(path string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if h, ok := p.open[path]; ok {
+		p.evict(h)
+	}
+}
+
+// size returns the number of handles in the pool
+func (p *handlePool) size() int {
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lru.Len()
+}
+
+// evict removes h from the pool and closes it unless it is in use
+// The caller must hold mu
+func (p *handlePool) evict(h *fileHandle) {
+	p.lru.Remove(h.elem)
+	delete(p.open, h.path)
+	h.closing = true
+	if h.refs == 0 {
+		h.file.Close()
+	}
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Health check statuses, from best to worst
+const (
+	// HealthOK means the check passed
+	HealthOK = "ok"
+
+	// HealthWarn means the store works, but not as configured, e.g. in maintenance mode
+	HealthWarn = "warn"
+
+	// HealthFail means the store refuses writes
+	HealthFail = "fail"
+)
+
+// HealthCheck is the result of one check of the store's health
+type HealthCheck struct {
+	// Name identifies the check: "writes", "disk", "clock", or "segments"
+	Name string
+
+	// Status is HealthOK, HealthWarn, or HealthFail
+	Status string
+
+	// Message says what is wrong, empty if Status is HealthOK
+	Message string
+}
+
+// Health checks that the store accepts writes, that the database volume has free space, that the system clock
+// did not jump, see ClockStats, and that no corrupt segment was repaired at startup, see Repairs
+func (s *Store) Health() []HealthCheck {
+	s.statsMu.Lock()
+	breaker := s.breaker
+	maintenance := s.maintenance
+	s.statsMu.Unlock()
+
+	writes := HealthCheck{Name: "writes", Status: HealthOK}
+	switch {
+	case breaker.Degraded:
+		writes.Status = HealthFail
+		writes.Message = fmt.Sprintf("writes are disabled after repeated storage errors: %v", breaker.LastError)
+	case maintenance.Enabled:
+		writes.Status = HealthWarn
+		writes.Message = fmt.Sprintf("maintenance mode since %v: %v", maintenance.Since.Format(time.RFC3339), maintenance.Message)
+	}
+
+	disk := HealthCheck{Name: "disk", Status: HealthOK}
+	if d := s.refreshDisk(); d.Low {
+		disk.Status = HealthFail
+		disk.Message = fmt.Sprintf("%d bytes free, below the minimum of %d, writes are disabled", d.FreeBytes, d.MinFreeBytes)
+	}
+
+	clock := HealthCheck{Name: "clock", Status: HealthOK}
+	if c := s.ClockStats(); c.Skewed {
+		clock.Status = HealthWarn
+		clock.Message = fmt.Sprintf("the system clock jumped %v at %v, expiry follows the elapsed time instead",
+			c.Skew.Round(time.Millisecond), c.SkewedSince.Format(time.RFC3339))
+	}
+
+	segments := HealthCheck{Name: "segments", Status: HealthOK}
+	if repairs := s.Repairs(); len(repairs) > 0 {
+		last := repairs[len(repairs)-1]
+		segments.Status = HealthWarn
+		segments.Message = fmt.Sprintf("%d corrupt segments were repaired at startup, the last, %v, with %v; "+
+			"the corrupt files were moved to %v", len(repairs), last.Segment, last.Policy, last.QuarantinedTo)
+	}
+
+	return []HealthCheck{writes, disk, clock, segments}
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"github.com/vi88i/kvstash/models"
+	"time"
+)
+
+/*
+Hooks:
+
+Embedders and plugins register Hooks to see the store's reads, writes, compaction cycles, and corrupt records as they
+happen, e.g. to count reads per key prefix, keep a cache warm, or forward writes to a replica, without changing the
+store package. Every registered hook is called in the order it was added.
+
+Hooks are called synchronously on the goroutine of the operation, often with the store locked, so a hook must not
+block, panic, or call into the store; slow work such as network calls belongs on a goroutine of its own. OnSet is
+called once a write took effect: for a conditional batch, once the whole batch committed, and never for a batch that
+was rolled back. The writes compaction makes to copy live keys are not sets and are not reported.
+*/
+
+// Hooks receives the store's operations, see AddHooks
+// Embed NoHooks to implement only some of the methods
+type Hooks interface {
+	// OnSet is called after a value was written under a key
+	OnSet(info SetInfo)
+
+	// OnGet is called after Get or GetMany looked up a key, whether it was found or not
+	OnGet(info GetInfo)
+
+	// OnCompactionStart is called when a compaction cycle started by trigger, e.g. TriggerInterval, begins to copy
+	// the live keys; skipped cycles are not reported
+	OnCompactionStart(trigger string)
+
+	// OnCompactionEnd is called when a compaction cycle reported by OnCompactionStart finished, successfully or not
+	OnCompactionEnd(run CompactionRun)
+
+	// OnCorruption is called when a record fails its checksum
+	OnCorruption(info CorruptionInfo)
+}
+
+// NoHooks implements every method of Hooks as a no-op, for embedding in hooks that only need some of them
+type NoHooks struct{}
+
+// OnSet does nothing
+func (NoHooks) OnSet(SetInfo) {}
+
+// OnGet does nothing
+func (NoHooks) OnGet(GetInfo) {}
+
+// OnCompactionStart does nothing
+func (NoHooks) OnCompactionStart(string) {}
+
+// OnCompactionEnd does nothing
+func (NoHooks) OnCompactionEnd(CompactionRun) {}
+
+// OnCorruption does nothing
+func (NoHooks) OnCorruption(CorruptionInfo) {}
+
+// SetInfo describes a write reported to Hooks.OnSet
+type SetInfo struct {
+	// Key is the key written, after key normalization
+	Key string
+
+	// Value is the value written; lists, sets, and hashes are encoded as JSON
+	Value string
+
+	// Type is the kind of value written
+	Type models.KVStashValueType
+
+	// ExpiresAt is when the key expires in Unix milliseconds, 0 if it never expires
+	ExpiresAt int64
+
+	// Revision is the revision of the write
+	Revision uint64
+}
+
+// GetInfo describes a read reported to Hooks.OnGet
+type GetInfo struct {
+	// Key is the key read, after key normalization
+	Key string
+
+	// Value is the value read, empty if Err is not nil
+	Value string
+
+	// Err is nil if the value was read, ErrKeyNotFound if the key does not exist, or why the read failed
+	Err error
+
+	// Inline indicates the value was served from the index without reading the segment file, see SetInlineThreshold
+	Inline bool
+
+	// Elapsed is how long the read took; for GetMany, the time taken by the whole call
+	Elapsed time.Duration
+}
+
+// CorruptionInfo describes a corrupt record reported to Hooks.OnCorruption
+type CorruptionInfo struct {
+	// Key is the key of the record, empty if it is not known, e.g. for a record found corrupt during startup
+	Key string
+
+	// Segment is the segment file holding the record
+	Segment string
+
+	// Err describes the corruption
+	Err error
+}
+
+// AddHooks registers h to be called on the store's operations, after the hooks already registered
+// Hooks given in Options are registered before the index is built, so they also see the corrupt records found by Open
+func (s *Store) AddHooks(h Hooks) {
+	for {
+		old := s.hooks.Load()
+		var hooks []Hooks
+		if old != nil {
+			hooks = append(hooks, *old...)
+		}
+		hooks = append(hooks, h)
+		if s.hooks.CompareAndSwap(old, &hooks) {
+			return
+		}
+	}
+}
+
+// registeredHooks returns the registered hooks, nil if there are none
+func (s *Store) registeredHooks() []Hooks {
+	if hooks := s.hooks.Load(); hooks != nil {
+		return *hooks
+	}
+	return nil
+}
+
+// notifySet reports a write to the hooks, or holds it back until the open batch committed
+// Must be called with mu held
+func (s *Store) notifySet(info SetInfo) {
+	hooks := s.registeredHooks()
+	if len(hooks) == 0 {
+		return
+	}
+	if s.batch != nil {
+		s.batch.sets = append(s.batch.sets, info)
+		return
+	}
+	for _, h := range hooks {
+		h.OnSet(info)
+	}
+}
+
+// notifyGet reports a read to the hooks
+func (s *Store) notifyGet(info GetInfo) {
+	for _, h := range s.registeredHooks() {
+		h.OnGet(info)
+	}
+}
+
+// notifyCompactionStart reports the start of a compaction cycle to the hooks
+func (s *Store) notifyCompactionStart(trigger string) {
+	for _, h := range s.registeredHooks() {
+		h.OnCompactionStart(trigger)
+	}
+}
+
+// notifyCompactionEnd reports the end of a compaction cycle to the hooks
+func (s *Store) notifyCompactionEnd(run CompactionRun) {
+	for _, h := range s.registeredHooks() {
+		h.OnCompactionEnd(run)
+	}
+}
+
+// notifyCorruption reports a corrupt record of key, empty if unknown, in segment to the hooks
+func (s *Store) notifyCorruption(key string, segment string, err error) {
+	info := CorruptionInfo{Key: key, Segment: segment, Err: err}
+	for _, h := range s.registeredHooks() {
+		h.OnCorruption(info)
+	}
+}
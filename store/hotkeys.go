@@ -0,0 +1,166 @@
+package store
+
+import (
+	"cmp"
+	"github.com/vi88i/kvstash/constants"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+Hot keys:
+
+Every read and write of a key is counted in a count-min sketch, a constants.HotKeySketchDepth by
+constants.HotKeySketchWidth table of counters: an access increments one counter per row, picked by a hash of the
+key with the row's seed, and the smallest of them estimates the accesses of the key. Collisions only inflate a count,
+so a key is never missed, and the table takes the same memory however many keys there are.
+
+The keys whose estimate is among the highest, up to constants.HotKeysTracked, are kept with their estimate; a key
+enters once its estimate exceeds the lowest one kept, which it replaces. Every constants.HotKeyHalfLife seconds all
+counts are halved, so a key that cooled down drops out and the report reflects the recent traffic.
+*/
+
+// HotKey is one of the keys accessed most often, see HotKeys
+type HotKey struct {
+	// Key is the key
+	Key string
+
+	// Accesses is the estimated number of reads and writes of the key, with older ones counting less, see
+	// constants.HotKeyHalfLife; it may overestimate by the accesses of keys sharing its counters
+	Accesses uint64
+
+	// Share is the fraction of all accesses, counted the same way, that went to the key
+	Share float64
+}
+
+// hotKeys counts the accesses of keys to find the hot ones
+type hotKeys struct {
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// seeds picks the counter of a key in each row of sketch
+	seeds [constants.HotKeySketchDepth]maphash.Seed
+
+	// sketch is the count-min sketch, nil until the first access
+	sketch [constants.HotKeySketchDepth][]uint32
+
+	// top holds the estimates of the hottest keys, at most constants.HotKeysTracked
+	top map[string]uint32
+
+	// floor is at most the lowest estimate in top, which a key must exceed to enter it once it is full
+	floor uint32
+
+	// total is the number of accesses, halved with the counters
+	total uint64
+
+	// halvedAt is when the counts were last halved
+	halvedAt time.Time
+}
+
+// record counts an access of key at now
+func (h *hotKeys) record(key string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sketch[0] == nil {
+		for i := range h.sketch {
+			h.seeds[i] = maphash.MakeSeed()
+			h.sketch[i] = make([]uint32, constants.HotKeySketchWidth)
+		}
+		h.top = make(map[string]uint32, constants.HotKeysTracked)
+		h.halvedAt = now
+	}
+	h.decay(now)
+
+	estimate := uint32(0)
+	for i := range h.sketch {
+		counter := &h.sketch[i][maphash.String(h.seeds[i], key)%constants.HotKeySketchWidth]
+		if *counter < ^uint32(0) {
+			*counter++
+		}
+		if i == 0 || *counter < estimate {
+			estimate = *counter
+		}
+	}
+	h.total++
+
+	if _, ok := h.top[key]; ok || len(h.top) < constants.HotKeysTracked {
+		h.top[key] = estimate
+		return
+	}
+	if estimate <= h.floor {
+		return
+	}
+
+	// The floor may be stale, so the lowest estimate is looked up before it is replaced
+	coldest, lowest := "", ^uint32(0)
+	for k, e := range h.top {
+		if e < lowest {
+			coldest, lowest = k, e
+		}
+	}
+	h.floor = lowest
+	if estimate > lowest {
+		delete(h.top, coldest)
+		h.top[key] = estimate
+	}
+}
+
+// decay halves every count once per half-life passed since they were last halved
+// The caller must hold mu
+func (h *hotKeys) decay(now time.Time) {
+	halvings := int(now.Sub(h.halvedAt) / (constants.HotKeyHalfLife * time.Second))
+	if halvings <= 0 {
+		return
+	}
+	h.halvedAt = h.halvedAt.Add(time.Duration(halvings) * constants.HotKeyHalfLife * time.Second)
+
+	shift := uint(min(halvings, 32))
+	for _, row := range h.sketch {
+		for j := range row {
+			row[j] >>= shift
+		}
+	}
+	for k, e := range h.top {
+		if e >>= shift; e == 0 {
+			delete(h.top, k)
+		} else {
+			h.top[k] = e
+		}
+	}
+	h.floor >>= shift
+	h.total >>= shift
+}
+
+// HotKeys returns the n keys accessed most often, hottest first; n <= 0 lists constants.HotKeysTopN keys and at
+// most constants.HotKeysTracked are listed
+// Reads and writes of values and collections count, with older accesses counting less, see HotKey.Accesses
+func (s *Store) HotKeys(n int) []HotKey {
+	if n <= 0 {
+		n = constants.HotKeysTopN
+	}
+	n = min(n, constants.HotKeysTracked)
+
+	h := &s.hot
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sketch[0] != nil {
+		h.decay(s.clock.Now())
+	}
+
+	hot := make([]HotKey, 0, len(h.top))
+	for key, estimate := range h.top {
+		hk := HotKey{Key: key, Accesses: uint64(estimate)}
+		if h.total > 0 {
+			hk.Share = min(float64(estimate)/float64(h.total), 1)
+		}
+		hot = append(hot, hk)
+	}
+	slices.SortFunc(hot, func(a, b HotKey) int {
+		return cmp.Or(cmp.Compare(b.Accesses, a.Accesses), cmp.Compare(a.Key, b.Key))
+	})
+	return hot[:min(n, len(hot))]
+}
@@ -0,0 +1,426 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Index checks:
+
+The index is built from the segments once, at startup, and then updated by every write. A bug in that bookkeeping, or
+a segment file changed behind the store's back, makes index entries point at the wrong bytes, which only shows when
+the key is read. CheckIndex looks for this early: it compares a random sample of index entries with the records they
+point at, which must exist, pass their metadata checksum, and agree on the offset, size, checksum, key, type, and
+deletion. Expiry times and revisions are not compared, since expiry updates change them without moving the record.
+
+If more than the allowed fraction of the sample diverges, the index is rebuilt from the segments like at startup and
+swapped in, see Reindex. The store is locked for the rebuild, as it is for compaction; entries that still agree with
+the segments are kept as they are, so their inline values and revisions survive. The namespace recency order is
+rebuilt from the write order, and watchers are not told about the keys the rebuild changed.
+
+With an interval set, the check runs in the background as JobIndexCheck. Divergences raise an AlertIndexDivergence,
+and a rebuild that follows an AlertIndexRebuilt; IndexCheckStats reports the last check and rebuild.
+*/
+
+// ErrReindexSkipped is returned by Reindex when the index cannot be rebuilt right now
+var ErrReindexSkipped = errors.New("index rebuild skipped")
+
+// IndexCheck configures the background check of the index against the segments
+type IndexCheck struct {
+	// Interval is the delay between two checks, 0 disables them
+	Interval time.Duration
+
+	// Sample is the number of index entries compared with their records (default: 0, constants.IndexCheckSample)
+	Sample int
+
+	// MaxDivergence is the fraction of the sample that may diverge without rebuilding the index (default: 0, any
+	// divergence rebuilds it)
+	MaxDivergence float64
+}
+
+// validate checks the ranges of the settings
+func (c IndexCheck) validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative, got %v", c.Interval)
+	}
+	if c.Sample < 0 {
+		return fmt.Errorf("sample must not be negative, got %d", c.Sample)
+	}
+	if c.MaxDivergence < 0 || c.MaxDivergence >= 1 {
+		return fmt.Errorf("max divergence must be at least 0 and below 1, got %v", c.MaxDivergence)
+	}
+	return nil
+}
+
+// IndexDivergence is an index entry that disagrees with the record it points at
+type IndexDivergence struct {
+	// Key is the key of the entry
+	Key string
+
+	// Segment and Offset locate the record the entry points at
+	Segment string
+	Offset  int64
+
+	// Reason says how they disagree, e.g. "checksum differs"
+	Reason string
+}
+
+// IndexCheckReport is the result of CheckIndex
+type IndexCheckReport struct {
+	// Time is when the check ran
+	Time time.Time
+
+	// Checked is the number of index entries compared with their records
+	Checked int
+
+	// Divergent is the number of them that disagree
+	Divergent int
+
+	// Divergences details up to constants.IndexCheckMaxDivergences of them
+	Divergences []IndexDivergence
+
+	// Rebuild is the rebuild of the index that followed, nil if there was none
+	Rebuild *ReindexReport
+
+	// Error says why the rebuild failed, empty if it succeeded or did not run
+	Error string
+}
+
+// ReindexReport is the result of Reindex
+type ReindexReport struct {
+	// Start is when the rebuild started, and Duration how long the store was locked for it
+	Start    time.Time
+	Duration time.Duration
+
+	// Segments is the number of segment files read
+	Segments int
+
+	// Keys is the number of entries of the rebuilt index
+	Keys int
+
+	// Added, Removed, and Changed count the entries the rebuild added, removed, and pointed at another record
+	Added   int
+	Removed int
+	Changed int
+}
+
+// IndexCheckStats describes the index checks and rebuilds since the store was opened
+type IndexCheckStats struct {
+	// IndexCheck is the configuration of the background check
+	IndexCheck
+
+	// Runs is the number of checks, and Divergent the number of divergent entries they found
+	Runs      int64
+	Divergent int64
+
+	// Rebuilds is the number of times the index was rebuilt
+	Rebuilds int64
+
+	// Last is the last check, nil if none ran
+	Last *IndexCheckReport
+
+	// LastRebuild is the last rebuild, nil if none ran
+	LastRebuild *ReindexReport
+}
+
+// IndexCheckStats returns the configuration of the index check and the results of the checks and rebuilds
+func (s *Store) IndexCheckStats() IndexCheckStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return s.indexChecks
+}
+
+// IndexCheck returns the configuration of the background index check
+func (s *Store) IndexCheck() IndexCheck {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return s.indexChecks.IndexCheck
+}
+
+// SetIndexCheck changes the configuration of the background index check; the new interval applies after the next
+// check
+// Returns an error if a setting is out of range
+func (s *Store) SetIndexCheck(c IndexCheck) error {
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("SetIndexCheck: %w", err)
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if old := s.indexChecks.IndexCheck; old != c {
+		logging.Infof("SetIndexCheck: interval=%v sample=%d max divergence=%v", c.Interval, c.Sample, c.MaxDivergence)
+	}
+	s.indexChecks.IndexCheck = c
+	return nil
+}
+
+// checkIndexPeriodically runs CheckIndex at the configured interval until the store is closed
+func (s *Store) checkIndexPeriodically() {
+	delay := func() time.Duration {
+		return cmp.Or(s.IndexCheck().Interval, constants.IndexCheckIdleInterval*time.Second)
+	}
+	for s.sleep(JobIndexCheck, delay()) {
+		if s.IndexCheck().Interval > 0 {
+			if _, err := s.CheckIndex(); err != nil {
+				logging.Errorf("checkIndexPeriodically: %v", err)
+			}
+		}
+	}
+}
+
+// CheckIndex compares a random sample of index entries with the records they point at, and rebuilds the index if
+// more of them diverge than the configured fraction, see SetIndexCheck
+// Returns an error if the rebuild failed, along with the report
+func (s *Store) CheckIndex() (*IndexCheckReport, error) {
+	config := s.IndexCheck()
+	report := &IndexCheckReport{Time: s.now()}
+
+	s.mu.RLock()
+	sample := s.sampleIndex(cmp.Or(config.Sample, constants.IndexCheckSample))
+	report.Checked = len(sample)
+	s.checkEntries(sample, report)
+	s.mu.RUnlock()
+
+	var err error
+	if report.Divergent > 0 {
+		first := report.Divergences[0]
+		logging.Warnf("CheckIndex: %d of %d index entries diverge from their records, e.g. key=%v at %v offset %d: %v",
+			report.Divergent, report.Checked, redact.Key(first.Key), first.Segment, first.Offset, first.Reason)
+		s.raiseAlert(models.AlertIndexDivergence, models.SeverityCritical,
+			"%d of %d sampled index entries diverge from the records they point at, e.g. key=%v at %v offset %d: %v",
+			report.Divergent, report.Checked, redact.Key(first.Key), first.Segment, first.Offset, first.Reason)
+
+		if float64(report.Divergent) > config.MaxDivergence*float64(report.Checked) {
+			report.Rebuild, err = s.Reindex()
+			if err != nil {
+				report.Error = err.Error()
+				err = fmt.Errorf("CheckIndex: %w", err)
+			} else {
+				s.raiseAlert(models.AlertIndexRebuilt, models.SeverityResolved,
+					"the index was rebuilt from %d segments: %d entries added, %d removed, %d changed",
+					report.Rebuild.Segments, report.Rebuild.Added, report.Rebuild.Removed, report.Rebuild.Changed)
+			}
+		}
+	} else {
+		logging.Debugf("CheckIndex: %d index entries agree with their records", report.Checked)
+	}
+
+	s.statsMu.Lock()
+	s.indexChecks.Runs++
+	s.indexChecks.Divergent += int64(report.Divergent)
+	s.indexChecks.Last = report
+	s.statsMu.Unlock()
+
+	return report, err
+}
+
+// indexSample is an index entry picked by sampleIndex
+type indexSample struct {
+	key   string
+	entry *models.KVStashIndexEntry
+}
+
+// sampleIndex picks up to n index entries uniformly at random
+// Must be called with mu held
+func (s *Store) sampleIndex(n int) []indexSample {
+	sample := make([]indexSample, 0, min(n, len(s.index)))
+	seen := 0
+	for key, entry := range s.index {
+		seen++
+		if len(sample) < n {
+			sample = append(sample, indexSample{key, entry})
+		} else if i := rand.IntN(seen); i < n {
+			sample[i] = indexSample{key, entry}
+		}
+	}
+	return sample
+}
+
+// checkEntries compares every sampled entry with the record it points at, counting the divergent ones in report
+// Must be called with mu held, so the segments do not change underneath
+func (s *Store) checkEntries(sample []indexSample, report *IndexCheckReport) {
+	type segmentFile struct {
+		file *os.File
+		size int64
+		err  error
+	}
+	files := make(map[string]*segmentFile)
+	defer func() {
+		for _, f := range files {
+			if f.file != nil {
+				f.file.Close()
+			}
+		}
+	}()
+
+	for _, sampled := range sample {
+		entry := sampled.entry
+		f := files[entry.SegmentFile]
+		if f == nil {
+			f = &segmentFile{}
+			f.file, f.err = os.Open(filepath.Join(s.dbPath, entry.SegmentFile))
+			if f.err == nil {
+				var info os.FileInfo
+				if info, f.err = f.file.Stat(); f.err == nil {
+					f.size = info.Size()
+				}
+			}
+			files[entry.SegmentFile] = f
+		}
+
+		reason := ""
+		if f.err != nil {
+			reason = fmt.Sprintf("segment cannot be read: %v", f.err)
+		} else {
+			reason = s.divergence(sampled.key, entry, f.file, f.size)
+		}
+		if reason == "" {
+			continue
+		}
+
+		report.Divergent++
+		if len(report.Divergences) < constants.IndexCheckMaxDivergences {
+			report.Divergences = append(report.Divergences, IndexDivergence{
+				Key:     sampled.key,
+				Segment: entry.SegmentFile,
+				Offset:  entry.Offset,
+				Reason:  reason,
+			})
+		}
+	}
+}
+
+// divergence returns how entry, the index entry of key, disagrees with the record it points at in file, a segment
+// of size bytes, or "" if they agree
+func (s *Store) divergence(key string, entry *models.KVStashIndexEntry, file *os.File, size int64) string {
+	rec, err := readRecord(file, size, entry.Offset-constants.MetadataSize)
+	if err != nil {
+		return fmt.Sprintf("record cannot be read: %v", err)
+	}
+
+	switch {
+	case rec.metadata.Offset != entry.Offset || rec.metadata.Size != entry.Size:
+		return fmt.Sprintf("record holds %d bytes at offset %d, index says %d bytes at offset %d",
+			rec.metadata.Size, rec.metadata.Offset, entry.Size, entry.Offset)
+	case rec.metadata.Checksum != entry.Checksum:
+		return "checksum differs"
+	case s.normalization.Key(rec.data.Key) != key:
+		return fmt.Sprintf("record holds key=%v", redact.Key(rec.data.Key))
+	case rec.expiry():
+		return "record is an expiry update, not a value"
+	case rec.deleted() != entry.Deleted:
+		return fmt.Sprintf("record deleted=%v, index says deleted=%v", rec.deleted(), entry.Deleted)
+	case !entry.Deleted && rec.valueType() != entry.Type:
+		return fmt.Sprintf("record holds a %v, index says %v", rec.valueType(), entry.Type)
+	}
+	return ""
+}
+
+// Reindex rebuilds the index from the segments like startup does and swaps it in, see CheckIndex
+// The store is locked while the segments are read
+// Returns ErrReindexSkipped if the store is closed or being relocated, and other errors if the segments cannot be
+// read, in which case the index is left as it was
+func (s *Store) Reindex() (*ReindexReport, error) {
+	s.mu.Lock()
+	report, err := s.reindex()
+	s.mu.Unlock()
+	if err != nil {
+		logging.Errorf("Reindex: %v", err)
+		return nil, fmt.Errorf("Reindex: %w", err)
+	}
+
+	// The recency order of the namespaces follows the rebuilt index
+	if err := s.SetNamespaces(s.Namespaces()); err != nil {
+		logging.Errorf("Reindex: failed to rebuild the namespace recency order: %v", err)
+	}
+
+	s.statsMu.Lock()
+	s.indexChecks.Rebuilds++
+	s.indexChecks.LastRebuild = report
+	s.statsMu.Unlock()
+
+	logging.Infof("Reindex: rebuilt the index from %d segments in %v: %d entries, %d added, %d removed, %d changed",
+		report.Segments, report.Duration.Round(time.Millisecond), report.Keys, report.Added, report.Removed, report.Changed)
+	return report, nil
+}
+
+// reindex rebuilds the index into a separate store over the same files and swaps its entries in
+// Must be called with mu held
+func (s *Store) reindex() (*ReindexReport, error) {
+	select {
+	case <-s.stop:
+		return nil, fmt.Errorf("%w: store is closed", ErrReindexSkipped)
+	default:
+	}
+	if s.relocating {
+		return nil, fmt.Errorf("%w: relocation in progress", ErrReindexSkipped)
+	}
+
+	start := time.Now()
+	report := &ReindexReport{Start: s.now()}
+
+	rebuilt := &Store{
+		index:         make(models.KVStashIndex),
+		dbPath:        s.dbPath,
+		nextSegment:   1,
+		activeLog:     "seg0.log",
+		feed:          newChangefeed(),
+		normalization: s.normalization,
+		startupCheck:  s.startupCheck,
+		// Segments are never moved out from under the running store, a corrupt one fails the reindex
+		startupRepair: StartupRepairFail,
+		clock:         s.clock,
+		hlc:           s.hlc,
+	}
+	rebuilt.inlineThreshold.Store(int64(s.InlineThreshold()))
+	if err := rebuilt.buildIndex(nil); err != nil {
+		return nil, fmt.Errorf("reindex: %w", err)
+	}
+	if rebuilt.activeLog != s.activeLog {
+		return nil, fmt.Errorf("reindex: the segments name %v as the active log, the store writes to %v",
+			rebuilt.activeLog, s.activeLog)
+	}
+
+	for key, old := range s.index {
+		entry, ok := rebuilt.index[key]
+		switch {
+		case !ok:
+			report.Removed++
+		case sameRecord(old, entry):
+			rebuilt.index[key] = old
+		default:
+			report.Changed++
+		}
+	}
+	report.Added = len(rebuilt.index) - (len(s.index) - report.Removed)
+
+	s.index = rebuilt.index
+	s.keys = rebuilt.keys
+	s.activeLogCount = rebuilt.activeLogCount
+	s.segmentCount = rebuilt.segmentCount
+	s.scheduleExpiries()
+
+	report.Segments = rebuilt.segmentCount
+	report.Keys = len(s.index)
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// sameRecord reports whether two index entries of a key point at the same record with the same state
+func sameRecord(a *models.KVStashIndexEntry, b *models.KVStashIndexEntry) bool {
+	return a.SegmentFile == b.SegmentFile && a.Offset == b.Offset && a.Size == b.Size && a.Checksum == b.Checksum &&
+		a.Deleted == b.Deleted && a.Type == b.Type && a.ExpiresAt == b.ExpiresAt && a.Transforms == b.Transforms
+}
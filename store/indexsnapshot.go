@@ -0,0 +1,632 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Index snapshots:
+
+Without a snapshot, Open builds the index by reading every record of every segment, so startup takes longer the more
+data the database holds. With an interval set, the index is written to the INDEX file of the database directory every
+interval as JobIndexSnapshot, and once more when the store is closed, together with the high-water mark of the active
+log: the offset its next record goes to. Open loads the snapshot and only reads the records after it, from the
+high-water mark of the active log it was taken in and the segments created since, so startup reads about one
+interval of writes whatever the size of the database.
+
+The index is captured under mu, like a Snapshot, but written without it, so writes go on meanwhile. The file records
+the size of every segment it covers and a SHA-256 of the last bytes below that size, and ends with a SHA-256 of the
+whole file; it is written like the superblock, to a temporary file that is synced and renamed over the old one. The
+active log is synced first, so the records below the high-water mark are on disk before a snapshot names them.
+
+Open uses the snapshot only if its checksum matches, the segments it covers are all there with the same size and last
+bytes, no other segment is older than its active log, and the key normalization is the same. Otherwise, e.g. after
+compaction rewrote the segments or retention dropped some, Open reads every segment as without a snapshot, and the
+next snapshot replaces the stale one. StartupCheckFull always reads every segment, since a snapshot cannot verify the
+values of the records it skips.
+
+File layout, big endian: magic (4) | version (4) | revision (8) | active log count (8) | normalization length (2) |
+normalization | segment count (4) | segments | entry count (8) | entries | SHA-256 of the preceding bytes (32)
+Segment: number (4) | size (8) | SHA-256 of its last bytes (32)
+Entry: key length (2) | key | segment number (4) | offset (8) | size (8) | checksum (32) | flags (1) | type (1) |
+expires at (8) | revision (8) | transforms (4) | with the inline flag, inline value length (4) | inline value
+*/
+
+// errBadIndexSnapshot is returned for an index snapshot that is truncated, fails its checksum, or is not a snapshot
+var errBadIndexSnapshot = errors.New("invalid index snapshot")
+
+// Flags of an index snapshot entry
+const (
+	snapshotDeleted = 1 << iota
+	snapshotBatch
+	snapshotInline
+)
+
+// IndexSnapshotStats describes the index snapshots of a store, see Options.IndexSnapshotInterval
+type IndexSnapshotStats struct {
+	// Interval is the delay between two snapshots, 0 if none are written
+	Interval time.Duration
+
+	// Loaded indicates that Open loaded the index from a snapshot, and Replayed is the size in bytes of the records
+	// it read after the snapshot
+	Loaded   bool
+	Replayed int64
+
+	// Saves is the number of snapshots written since the store was opened
+	Saves int64
+
+	// LastSave is when the last snapshot was written, zero if none was, and LastSaveDuration how long it took
+	LastSave         time.Time
+	LastSaveDuration time.Duration
+
+	// LastEntries is the number of index entries, live and deleted, of the last snapshot written
+	LastEntries int
+
+	// LastError says why the last snapshot failed, empty if it succeeded
+	LastError string
+}
+
+// indexSnapshotSegment is a segment covered by an index snapshot
+type indexSnapshotSegment struct {
+	// num is the segment number
+	num int
+
+	// size is the size of the segment when the snapshot was taken, for the active log its high-water mark
+	size int64
+
+	// tail is the SHA-256 of the last constants.MetadataSize bytes below size, see segmentTail
+	tail [sha256.Size]byte
+}
+
+// indexSnapshot is the index of a store up to the high-water mark of its active log
+type indexSnapshot struct {
+	// normalization is the key normalization the keys of the index were normalized with, see KeyNormalization.String
+	normalization string
+
+	// revision is the last revision assigned when the snapshot was taken
+	revision uint64
+
+	// activeLogCount is the number of records in the active log below its high-water mark
+	activeLogCount int
+
+	// segments are the segments the index was built from, oldest first; the last one is the active log
+	segments []indexSnapshotSegment
+
+	// index is the index loaded from the snapshot, nil for one being written
+	index models.KVStashIndex
+}
+
+// active returns the segment that was the active log when the snapshot was taken
+func (snap *indexSnapshot) active() indexSnapshotSegment {
+	return snap.segments[len(snap.segments)-1]
+}
+
+// replayFrom returns the offset buildIndex reads segment from, -1 to skip it: the high-water mark for the active log
+// of the snapshot, -1 for the segments before it, and 0 for the segments created after it or without a snapshot
+func (snap *indexSnapshot) replayFrom(segment string) int64 {
+	if snap == nil {
+		return 0
+	}
+	active := snap.active()
+	switch num := segmentNumber(segment); {
+	case num < active.num:
+		return -1
+	case num == active.num:
+		return active.size
+	}
+	return 0
+}
+
+// check returns an error unless the segments of dbPath are those the snapshot covers, segments being the segment
+// files of dbPath
+func (snap *indexSnapshot) check(dbPath string, segments []string) error {
+	active := snap.active()
+	covered := make(map[int]bool, len(snap.segments))
+	for _, seg := range snap.segments {
+		covered[seg.num] = true
+	}
+	for _, segment := range segments {
+		num := segmentNumber(segment)
+		if num <= active.num && !covered[num] {
+			return fmt.Errorf("check: %v is not in the snapshot", segment)
+		}
+		delete(covered, num)
+	}
+	for num := range covered {
+		return fmt.Errorf("check: %v is missing", segmentName(num))
+	}
+
+	for _, seg := range snap.segments {
+		name := segmentName(seg.num)
+		info, err := os.Stat(filepath.Join(dbPath, name))
+		if err != nil {
+			return fmt.Errorf("check: %w", err)
+		}
+
+		if seg.num == active.num {
+			end, err := segmentEnd(dbPath, name, info.Size())
+			if err != nil {
+				return fmt.Errorf("check: %w", err)
+			}
+			if end < seg.size {
+				return fmt.Errorf("check: %v ends at %d bytes, before the high-water mark %d", name, end, seg.size)
+			}
+		} else if info.Size() != seg.size {
+			return fmt.Errorf("check: %v has %d bytes, %d in the snapshot", name, info.Size(), seg.size)
+		}
+
+		tail, err := segmentTail(dbPath, name, seg.size)
+		if err != nil {
+			return fmt.Errorf("check: %w", err)
+		}
+		if tail != seg.tail {
+			return fmt.Errorf("check: the bytes of %v below offset %d changed", name, seg.size)
+		}
+	}
+
+	return nil
+}
+
+// segmentTail returns the SHA-256 of the last constants.MetadataSize bytes of segment below size, or of all of them
+// if there are fewer
+func segmentTail(dbPath string, segment string, size int64) ([sha256.Size]byte, error) {
+	file, err := os.Open(filepath.Join(dbPath, segment))
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("segmentTail: %w", err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, min(size, constants.MetadataSize))
+	if _, err := file.ReadAt(buf, size-int64(len(buf))); err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("segmentTail: %v: %w", segment, err)
+	}
+	return sha256.Sum256(buf), nil
+}
+
+// IndexSnapshotStats returns the index snapshots loaded and written since the store was opened
+func (s *Store) IndexSnapshotStats() IndexSnapshotStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	stats := s.indexSnapshots
+	stats.Interval = s.indexSnapshotInterval
+	return stats
+}
+
+// saveIndexSnapshots writes a snapshot of the index every indexSnapshotInterval until the store is closed
+// This goroutine is started by Open when Options.IndexSnapshotInterval is set
+func (s *Store) saveIndexSnapshots() {
+	for s.sleep(JobIndexSnapshot, s.indexSnapshotInterval) {
+		if err := s.SaveIndexSnapshot(); err != nil {
+			logging.Errorf("saveIndexSnapshots: %v", err)
+		}
+	}
+}
+
+// SaveIndexSnapshot writes a snapshot of the index to the database directory, which the next Open loads instead of
+// reading the segments it covers, see Options.IndexSnapshotInterval
+// Does nothing while the store has no writer, e.g. after Close or while the breaker is tripped
+// Returns an error if the snapshot cannot be written, in which case the previous one is left in place
+func (s *Store) SaveIndexSnapshot() error {
+	err := s.saveIndexSnapshot()
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if err != nil {
+		s.indexSnapshots.LastError = err.Error()
+		return fmt.Errorf("SaveIndexSnapshot: %w", err)
+	}
+	s.indexSnapshots.LastError = ""
+	return nil
+}
+
+// saveIndexSnapshot captures the index and writes it, see SaveIndexSnapshot
+func (s *Store) saveIndexSnapshot() error {
+	start := time.Now()
+
+	s.mu.RLock()
+	dbPath := s.dbPath
+	snap, keys, entries, err := s.captureIndex()
+	s.mu.RUnlock()
+	if err != nil || snap == nil {
+		return err
+	}
+
+	tmp, err := writeIndexSnapshot(dbPath, snap, keys, entries)
+	if err != nil {
+		return fmt.Errorf("saveIndexSnapshot: %w", err)
+	}
+
+	// Compaction, retention, and relocation change the segments under mu, so they are checked again before the
+	// snapshot replaces the previous one
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	segments, err := listSegments(dbPath)
+	if err == nil && s.dbPath == dbPath {
+		err = snap.check(dbPath, segments)
+	} else if err == nil {
+		err = fmt.Errorf("the database moved to %v", s.dbPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		logging.Infof("saveIndexSnapshot: the segments changed while the snapshot was written, discarding it: %v", err)
+		return nil
+	}
+
+	if err := replaceFile(tmp, filepath.Join(dbPath, constants.IndexSnapshotName)); err != nil {
+		return fmt.Errorf("saveIndexSnapshot: %w", err)
+	}
+
+	duration := time.Since(start)
+	s.statsMu.Lock()
+	s.indexSnapshots.Saves++
+	s.indexSnapshots.LastSave = s.now()
+	s.indexSnapshots.LastSaveDuration = duration
+	s.indexSnapshots.LastEntries = len(keys)
+	s.statsMu.Unlock()
+
+	active := snap.active()
+	logging.Infof("saveIndexSnapshot: saved %d index entries up to offset %d of %v in %v",
+		len(keys), active.size, segmentName(active.num), duration.Round(time.Millisecond))
+	return nil
+}
+
+// captureIndex returns a snapshot of the index and its entries to write, nil if none can be taken right now
+// The entries are not copied: entries are replaced rather than changed, see SetInlineThreshold
+// Must be called with mu held
+func (s *Store) captureIndex() (*indexSnapshot, []string, []*models.KVStashIndexEntry, error) {
+	if s.writer == nil || s.batch != nil {
+		return nil, nil, nil, nil
+	}
+
+	// The records below the high-water mark reach the disk before a snapshot names them
+	if s.durability != DurabilitySync {
+		if err := s.writer.Sync(); err != nil {
+			return nil, nil, nil, fmt.Errorf("captureIndex: %w", err)
+		}
+	}
+
+	hwm, _ := s.writer.position()
+	activeNum := segmentNumber(s.activeLog)
+	snap := &indexSnapshot{
+		normalization:  s.normalization.String(),
+		revision:       s.revision,
+		activeLogCount: s.activeLogCount,
+	}
+
+	segments, err := listSegments(s.dbPath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("captureIndex: %w", err)
+	}
+	for _, segment := range segments {
+		seg := indexSnapshotSegment{num: segmentNumber(segment), size: hwm}
+		if seg.num > activeNum {
+			continue
+		}
+		if seg.num < activeNum {
+			info, err := os.Stat(filepath.Join(s.dbPath, segment))
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("captureIndex: %w", err)
+			}
+			seg.size = info.Size()
+		}
+		if seg.tail, err = segmentTail(s.dbPath, segment, seg.size); err != nil {
+			return nil, nil, nil, fmt.Errorf("captureIndex: %w", err)
+		}
+		snap.segments = append(snap.segments, seg)
+	}
+	if len(snap.segments) == 0 || snap.active().num != activeNum {
+		return nil, nil, nil, fmt.Errorf("captureIndex: the active log %v is missing", s.activeLog)
+	}
+
+	keys := make([]string, 0, len(s.index))
+	entries := make([]*models.KVStashIndexEntry, 0, len(s.index))
+	for key, entry := range s.index {
+		keys = append(keys, key)
+		entries = append(entries, entry)
+	}
+	return snap, keys, entries, nil
+}
+
+// writeIndexSnapshot writes snap with the index entries to a temporary file of dbPath and syncs it
+// Returns the path of the temporary file, which replaceFile moves into place
+func writeIndexSnapshot(dbPath string, snap *indexSnapshot, keys []string, entries []*models.KVStashIndexEntry) (string, error) {
+	tmp := filepath.Join(dbPath, constants.IndexSnapshotName+".tmp")
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("writeIndexSnapshot: %w", err)
+	}
+
+	sum := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(file, sum))
+
+	buf := make([]byte, 0, 1024)
+	buf = append(buf, constants.IndexSnapshotMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, constants.IndexSnapshotVersion)
+	buf = binary.BigEndian.AppendUint64(buf, snap.revision)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snap.activeLogCount))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(snap.normalization)))
+	buf = append(buf, snap.normalization...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(snap.segments)))
+	for _, seg := range snap.segments {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(seg.num))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(seg.size))
+		buf = append(buf, seg.tail[:]...)
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(keys)))
+	_, err = w.Write(buf)
+
+	for i := 0; i < len(keys) && err == nil; i++ {
+		buf = appendSnapshotEntry(buf[:0], keys[i], entries[i])
+		_, err = w.Write(buf)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		_, err = file.Write(sum.Sum(nil))
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("writeIndexSnapshot: %w", err)
+	}
+
+	return tmp, nil
+}
+
+// appendSnapshotEntry appends the on-disk form of the index entry of key to buf
+func appendSnapshotEntry(buf []byte, key string, entry *models.KVStashIndexEntry) []byte {
+	var flags byte
+	if entry.Deleted {
+		flags |= snapshotDeleted
+	}
+	if entry.Batch {
+		flags |= snapshotBatch
+	}
+	if entry.Inline != nil {
+		flags |= snapshotInline
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(key)))
+	buf = append(buf, key...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(segmentNumber(entry.SegmentFile)))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.Offset))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.Size))
+	buf = append(buf, entry.Checksum[:]...)
+	buf = append(buf, flags, byte(entry.Type))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.ExpiresAt))
+	buf = binary.BigEndian.AppendUint64(buf, entry.Revision)
+	buf = binary.BigEndian.AppendUint32(buf, entry.Transforms)
+	if entry.Inline != nil {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(*entry.Inline)))
+		buf = append(buf, *entry.Inline...)
+	}
+	return buf
+}
+
+// replaceFile renames tmp over path and syncs the directory so the rename survives a crash
+func replaceFile(tmp string, path string) error {
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replaceFile: %w", err)
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("replaceFile: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("replaceFile: failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// snapshotReader reads the fields of an index snapshot, remembering the first error
+type snapshotReader struct {
+	r   io.Reader
+	buf []byte
+	err error
+}
+
+// read returns the next n bytes, nil after an error
+// The bytes are only valid until the next read
+func (sr *snapshotReader) read(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	if cap(sr.buf) < n {
+		sr.buf = make([]byte, n)
+	}
+	b := sr.buf[:n]
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		sr.err = err
+		return nil
+	}
+	return b
+}
+
+// uint16, uint32, and uint64 read the next big endian integer, 0 after an error
+func (sr *snapshotReader) uint16() uint16 {
+	if b := sr.read(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (sr *snapshotReader) uint32() uint32 {
+	if b := sr.read(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (sr *snapshotReader) uint64() uint64 {
+	if b := sr.read(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// string reads the next n bytes as a string, "" after an error
+func (sr *snapshotReader) string(n int) string {
+	return string(sr.read(n))
+}
+
+// readIndexSnapshot reads the index snapshot of dbPath
+// Returns nil without an error if the database has no snapshot, and errBadIndexSnapshot if it is not a valid one
+func readIndexSnapshot(dbPath string) (*indexSnapshot, error) {
+	file, err := os.Open(filepath.Join(dbPath, constants.IndexSnapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("readIndexSnapshot: %w", err)
+	}
+	defer file.Close()
+
+	in := bufio.NewReaderSize(file, 1<<20)
+	sum := sha256.New()
+	sr := &snapshotReader{r: io.TeeReader(in, sum), buf: make([]byte, 0, 1024)}
+
+	if magic := sr.string(len(constants.IndexSnapshotMagic)); magic != constants.IndexSnapshotMagic {
+		return nil, fmt.Errorf("readIndexSnapshot: %w: bad magic", errBadIndexSnapshot)
+	}
+	if version := sr.uint32(); version != constants.IndexSnapshotVersion {
+		return nil, fmt.Errorf("readIndexSnapshot: %w: version %d, expected %d",
+			errBadIndexSnapshot, version, constants.IndexSnapshotVersion)
+	}
+
+	snap := &indexSnapshot{index: make(models.KVStashIndex)}
+	snap.revision = sr.uint64()
+	snap.activeLogCount = int(sr.uint64())
+	snap.normalization = sr.string(int(sr.uint16()))
+	for n := sr.uint32(); n > 0 && sr.err == nil; n-- {
+		seg := indexSnapshotSegment{num: int(sr.uint32()), size: int64(sr.uint64())}
+		copy(seg.tail[:], sr.read(sha256.Size))
+		snap.segments = append(snap.segments, seg)
+	}
+	for n := sr.uint64(); n > 0 && sr.err == nil; n-- {
+		key, entry := readSnapshotEntry(sr)
+		snap.index[key] = entry
+	}
+	if sr.err != nil {
+		return nil, fmt.Errorf("readIndexSnapshot: %w: %v", errBadIndexSnapshot, sr.err)
+	}
+
+	var trailer [sha256.Size]byte
+	if _, err := io.ReadFull(in, trailer[:]); err != nil {
+		return nil, fmt.Errorf("readIndexSnapshot: %w: missing checksum: %v", errBadIndexSnapshot, err)
+	}
+	if !bytes.Equal(sum.Sum(nil), trailer[:]) {
+		return nil, fmt.Errorf("readIndexSnapshot: %w: checksum mismatch", errBadIndexSnapshot)
+	}
+	if _, err := in.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("readIndexSnapshot: %w: trailing bytes", errBadIndexSnapshot)
+	}
+	if len(snap.segments) == 0 {
+		return nil, fmt.Errorf("readIndexSnapshot: %w: no segments", errBadIndexSnapshot)
+	}
+
+	return snap, nil
+}
+
+// readSnapshotEntry reads the next index entry of an index snapshot, see appendSnapshotEntry
+func readSnapshotEntry(sr *snapshotReader) (string, *models.KVStashIndexEntry) {
+	key := sr.string(int(sr.uint16()))
+	entry := &models.KVStashIndexEntry{
+		SegmentFile: segmentName(int(sr.uint32())),
+		Offset:      int64(sr.uint64()),
+		Size:        int64(sr.uint64()),
+	}
+	copy(entry.Checksum[:], sr.read(sha256.Size))
+	var flags byte
+	if b := sr.read(2); b != nil {
+		flags, entry.Type = b[0], models.KVStashValueType(b[1])
+	}
+	entry.ExpiresAt = int64(sr.uint64())
+	entry.Revision = sr.uint64()
+	entry.Transforms = sr.uint32()
+	if sr.err != nil {
+		return key, entry
+	}
+
+	entry.Deleted = flags&snapshotDeleted != 0
+	entry.Batch = flags&snapshotBatch != 0
+	if flags&snapshotInline != 0 {
+		n := sr.uint32()
+		if n > constants.MaxInlineThreshold {
+			sr.err = fmt.Errorf("inline value of %d bytes", n)
+			return key, entry
+		}
+		value := sr.string(int(n))
+		entry.Inline = &value
+	}
+	return key, entry
+}
+
+// loadIndexSnapshot returns the index snapshot Open loads the index from, nil to read every segment
+func (s *Store) loadIndexSnapshot() *indexSnapshot {
+	if s.indexSnapshotInterval <= 0 || s.startupCheck == StartupCheckFull {
+		return nil
+	}
+
+	snap, err := readIndexSnapshot(s.dbPath)
+	if err != nil {
+		logging.Warnf("loadIndexSnapshot: reading every segment: %v", err)
+		return nil
+	}
+	if snap != nil && snap.normalization != s.normalization.String() {
+		logging.Warnf("loadIndexSnapshot: reading every segment: the snapshot keys are normalized with %q, not %q",
+			snap.normalization, s.normalization.String())
+		return nil
+	}
+	return snap
+}
+
+// useIndexSnapshot loads the index from snap if it covers segments, the segment files of the database
+// Returns false if it does not, in which case the index is left alone
+// Must be called before the store is shared
+func (s *Store) useIndexSnapshot(snap *indexSnapshot, segments []string) bool {
+	if err := snap.check(s.dbPath, segments); err != nil {
+		logging.Warnf("useIndexSnapshot: reading every segment: %v", err)
+		return false
+	}
+
+	// The inline threshold may have been lowered since the snapshot was taken
+	threshold := s.InlineThreshold()
+	for _, entry := range snap.index {
+		if entry.Inline != nil && len(*entry.Inline) > threshold {
+			entry.Inline = nil
+		}
+	}
+
+	s.index = snap.index
+	s.revision = snap.revision
+	active := snap.active()
+	if segmentName(active.num) == s.activeLog {
+		s.activeLogCount = snap.activeLogCount
+	}
+	logging.Infof("useIndexSnapshot: loaded %d index entries, reading the records after offset %d of %v",
+		len(s.index), active.size, segmentName(active.num))
+	return true
+}
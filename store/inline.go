@@ -0,0 +1,107 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+)
+
+/*
+Inline values:
+
+With an inline threshold set, string values and JSON documents up to that many bytes are kept in their index entry
+as well as written to the active log, so Get and GetMany return them without reading the segment file. The log
+stays the source of truth: the index is rebuilt from it on startup, where small values are inlined again once
+their value checksum was verified, and compaction inlines the values it copies.
+
+Inline values skip the read verification, since they were checked when written or loaded; a read that asks for a
+verification level explicitly still reads the record from disk. Each inlined value costs its length plus
+constants.InlineEntryOverhead bytes of memory, reported by InlineStats.
+*/
+
+// InlineStats describes the values kept in the index
+type InlineStats struct {
+	// Threshold is the size in bytes up to which values are kept in the index, 0 if none are
+	Threshold int
+
+	// Values is the number of index entries holding their value
+	Values int
+
+	// Bytes is the size of the values held by the index
+	Bytes int64
+
+	// MemoryBytes is the memory taken by the values held by the index, Bytes plus the overhead of every value
+	MemoryBytes int64
+
+	// Hits is the number of reads served from the index since the store was opened
+	Hits int64
+}
+
+// InlineThreshold returns the size in bytes up to which values are kept in the index, 0 if none are
+func (s *Store) InlineThreshold() int {
+	return int(s.inlineThreshold.Load())
+}
+
+// SetInlineThreshold changes the size in bytes up to which string values and JSON documents are kept in the index,
+// up to constants.MaxInlineThreshold; 0 keeps none
+// Lowering the threshold drops the values above it from the index right away; after raising it, larger values are
+// kept as they are written, compacted, or loaded on the next start
+func (s *Store) SetInlineThreshold(threshold int) error {
+	if threshold < 0 || threshold > constants.MaxInlineThreshold {
+		return fmt.Errorf("SetInlineThreshold: threshold must be between 0 and %d, got %d",
+			constants.MaxInlineThreshold, threshold)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.InlineThreshold()
+	if old == threshold {
+		return nil
+	}
+	s.inlineThreshold.Store(int64(threshold))
+	logging.Infof("SetInlineThreshold: %d -> %d bytes", old, threshold)
+
+	if threshold > old {
+		return nil
+	}
+
+	// Readers may hold the entries without the lock, so entries are replaced rather than changed
+	for key, entry := range s.index {
+		if entry.Inline != nil && len(*entry.Inline) > threshold {
+			e := *entry
+			e.Inline = nil
+			s.setEntry(key, &e)
+		}
+	}
+	return nil
+}
+
+// inline returns the value to keep in the index entry of a write of value with type typ, nil if it is not kept
+func (s *Store) inline(typ models.KVStashValueType, value string) *string {
+	if (typ != models.TypeString && typ != models.TypeJSON) || len(value) > s.InlineThreshold() {
+		return nil
+	}
+	return &value
+}
+
+// loadInline returns the value of rec, read from segment while the index is rebuilt, to keep in its index entry,
+// nil if it is not kept, its value checksum does not match, or it cannot be decoded
+// The checksum is verified here unless the startup check already did
+// Transformed values are only decoded when they are small enough as stored
+func (s *Store) loadInline(rec *record, segment string) *string {
+	if s.inline(rec.valueType(), rec.data.Value) == nil {
+		return nil
+	}
+	if s.startupCheck != StartupCheckFull {
+		if err := rec.validateChecksum(segment); err != nil {
+			return nil
+		}
+	}
+	value, err := rec.value()
+	if err != nil {
+		return nil
+	}
+	return s.inline(rec.valueType(), value)
+}
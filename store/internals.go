@@ -0,0 +1,82 @@
+package store
+
+import "time"
+
+// Internals is the state of the write path and the index of a store, for diagnosing it, see Store.Internals
+type Internals struct {
+	// DataDir is the database directory
+	DataDir string
+
+	// ActiveLog is the segment written to, and ActiveLogOffset the offset the next record is written at; both are
+	// empty and 0 while the store has no writer, e.g. after Close
+	ActiveLog       string
+	ActiveLogOffset int64
+
+	// ActiveLogCount is the number of records written to the active log
+	ActiveLogCount int
+
+	// SegmentStart and SegmentStartOffset are when and at which offset the store started writing to the active log
+	SegmentStart       time.Time
+	SegmentStartOffset int64
+
+	// HeldBytes is the size of the records of a batch buffered by the writer and not written yet
+	HeldBytes int
+
+	// SegmentCount is the number of segments the store keeps count of, unlike Stats.Segments not read from the
+	// database directory, and NextSegment the number of the segment the next rotation creates
+	SegmentCount int
+	NextSegment  int
+
+	// IndexEntries is the number of index entries, live and deleted, and ExpiryQueue the number of them waiting
+	// for their expiry time
+	IndexEntries int
+	ExpiryQueue  int
+
+	// Revision is the last revision assigned to a write
+	Revision uint64
+
+	// OpenSnapshots is the number of unreleased snapshots, and Relocating whether Relocate is copying the database
+	OpenSnapshots int
+	Relocating    bool
+
+	// Durability is the durability mode of the active log writer
+	Durability Durability
+
+	// IndexSnapshot describes the index snapshot loaded at startup and those written since
+	IndexSnapshot IndexSnapshotStats
+
+	// SegmentFilters is the number of sealed segments whose Bloom filter is kept in memory
+	SegmentFilters int
+
+	// FilterSkips is the number of segments scans for a key skipped because their Bloom filter ruled it out
+	FilterSkips int64
+}
+
+// Internals returns the state of the write path and the index, taking the store lock for reading
+func (s *Store) Internals() Internals {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	internals := Internals{
+		DataDir:            s.dbPath,
+		ActiveLogCount:     s.activeLogCount,
+		SegmentStart:       s.segmentStart,
+		SegmentStartOffset: s.segmentStartOffset,
+		SegmentCount:       s.segmentCount,
+		NextSegment:        s.nextSegment,
+		IndexEntries:       len(s.index),
+		ExpiryQueue:        len(s.expiries),
+		Revision:           s.revision,
+		OpenSnapshots:      s.openSnapshots,
+		Relocating:         s.relocating,
+		Durability:         s.durability,
+		IndexSnapshot:      s.IndexSnapshotStats(),
+		SegmentFilters:     len(s.filters),
+		FilterSkips:        s.filterSkips.Load(),
+	}
+	if s.writer != nil {
+		internals.ActiveLog = s.activeLog
+		internals.ActiveLogOffset, internals.HeldBytes = s.writer.position()
+	}
+	return internals
+}
@@ -0,0 +1,344 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/models"
+	"strconv"
+	"strings"
+)
+
+/*
+JSON Documents:
+
+A JSON document is a value of type TypeJSON. Like collections it is a single record, and every update is a
+read-modify-write under the store lock, so a client changing one field neither transfers the whole document
+nor races with other updates.
+
+Paths use a subset of JSONPath:
+
+	$                 the whole document
+	$.name            member "name" of an object
+	$['odd name']     member "odd name" (single or double quotes, no escapes)
+	$.items[2]        element 2 of an array
+
+Setting a path creates missing object members along the way, and setting the index just past the end of an
+array appends to it. Numbers keep their original text; object members are stored in ascending key order.
+*/
+
+// Errors returned by JSON document operations
+var (
+	// ErrBadJSONPath is returned for a path that does not follow the supported syntax
+	ErrBadJSONPath = errors.New("invalid JSON path")
+
+	// ErrJSONPathNotFound is returned when a path does not lead to a value in the document
+	ErrJSONPathNotFound = errors.New("JSON path not found")
+
+	// ErrInvalidJSON is returned when a value written to a document is not a single valid JSON value
+	ErrInvalidJSON = errors.New("invalid JSON value")
+)
+
+// jsonStep is one step of a parsed JSON path: an object member or an array index
+type jsonStep struct {
+	// name is the object member, if isIndex is false
+	name string
+
+	// index is the array index, if isIndex is true
+	index int
+
+	// isIndex indicates whether the step selects an array element
+	isIndex bool
+}
+
+// String returns the step in path syntax
+func (step jsonStep) String() string {
+	if step.isIndex {
+		return fmt.Sprintf("[%d]", step.index)
+	}
+	return strconv.Quote(step.name)
+}
+
+// parseJSONPath splits a path such as $.a.b[0] into its steps; "" is the same as $
+func parseJSONPath(path string) ([]jsonStep, error) {
+	if path == "" {
+		path = "$"
+	}
+	if path[0] != '$' {
+		return nil, fmt.Errorf("parseJSONPath: %w: %q must start with $", ErrBadJSONPath, path)
+	}
+
+	var steps []jsonStep
+	for i := 1; i < len(path); {
+		switch path[i] {
+		case '.':
+			end := i + 1
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i+1 {
+				return nil, fmt.Errorf("parseJSONPath: %w: empty member name at %d in %q", ErrBadJSONPath, i, path)
+			}
+			steps = append(steps, jsonStep{name: path[i+1 : end]})
+			i = end
+
+		case '[':
+			closing := strings.IndexByte(path[i:], ']')
+			if closing < 0 {
+				return nil, fmt.Errorf("parseJSONPath: %w: unterminated [ at %d in %q", ErrBadJSONPath, i, path)
+			}
+			inner := path[i+1 : i+closing]
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonStep{name: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("parseJSONPath: %w: bad index %q in %q", ErrBadJSONPath, inner, path)
+				}
+				steps = append(steps, jsonStep{index: index, isIndex: true})
+			}
+			i += closing + 1
+
+		default:
+			return nil, fmt.Errorf("parseJSONPath: %w: unexpected %q at %d in %q", ErrBadJSONPath, path[i], i, path)
+		}
+	}
+
+	return steps, nil
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers as json.Number so they round-trip exactly
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the value")
+	}
+
+	return v, nil
+}
+
+// jsonDoc is a decoded JSON document, the collection type of TypeJSON values
+type jsonDoc struct {
+	// root is the top-level value
+	root any
+
+	// loaded indicates that the document was read from the store, as root may be a JSON null
+	loaded bool
+}
+
+// UnmarshalJSON decodes the document with decodeJSON
+func (doc *jsonDoc) UnmarshalJSON(data []byte) error {
+	root, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	doc.root = root
+	doc.loaded = true
+	return nil
+}
+
+// MarshalJSON encodes the document
+func (doc jsonDoc) MarshalJSON() ([]byte, error) {
+	return encodeJSON(doc.root)
+}
+
+// getJSONPath returns the value at steps below node
+func getJSONPath(node any, steps []jsonStep) (any, error) {
+	for _, step := range steps {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[step.name]
+			if step.isIndex || !ok {
+				return nil, fmt.Errorf("%w: no %v", ErrJSONPathNotFound, step)
+			}
+			node = child
+		case []any:
+			if !step.isIndex || step.index >= len(n) {
+				return nil, fmt.Errorf("%w: no %v", ErrJSONPathNotFound, step)
+			}
+			node = n[step.index]
+		default:
+			return nil, fmt.Errorf("%w: no %v in a scalar", ErrJSONPathNotFound, step)
+		}
+	}
+
+	return node, nil
+}
+
+// setJSONPath returns node with the value at steps replaced by value
+// Missing object members are created; an array index may be at most the array's length, which appends
+func setJSONPath(node any, steps []jsonStep, value any) (any, error) {
+	if len(steps) == 0 {
+		return value, nil
+	}
+	step := steps[0]
+
+	if step.isIndex {
+		arr, ok := node.([]any)
+		if !ok || step.index > len(arr) {
+			return nil, fmt.Errorf("%w: no %v", ErrJSONPathNotFound, step)
+		}
+
+		var child any
+		if step.index < len(arr) {
+			child = arr[step.index]
+		}
+		child, err := setJSONPath(child, steps[1:], value)
+		if err != nil {
+			return nil, err
+		}
+
+		if step.index == len(arr) {
+			return append(arr, child), nil
+		}
+		arr[step.index] = child
+		return arr, nil
+	}
+
+	obj, ok := node.(map[string]any)
+	if node == nil {
+		obj, ok = map[string]any{}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: no %v in a non-object", ErrJSONPathNotFound, step)
+	}
+
+	child, err := setJSONPath(obj[step.name], steps[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	obj[step.name] = child
+	return obj, nil
+}
+
+// deleteJSONPath returns node with the value at steps, which must not be empty, removed
+func deleteJSONPath(node any, steps []jsonStep) (any, error) {
+	parentSteps, last := steps[:len(steps)-1], steps[len(steps)-1]
+	parent, err := getJSONPath(node, parentSteps)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p := parent.(type) {
+	case map[string]any:
+		if _, found := p[last.name]; found && !last.isIndex {
+			delete(p, last.name)
+			return node, nil
+		}
+	case []any:
+		// Removing an element shortens the array, so it is stored back into its parent
+		if last.isIndex && last.index < len(p) {
+			shrunk := append(p[:last.index:last.index], p[last.index+1:]...)
+			return setJSONPath(node, parentSteps, shrunk)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no %v", ErrJSONPathNotFound, last)
+}
+
+// JSONGet returns the value at path in the JSON document stored under key, encoded as JSON
+// Returns ErrKeyNotFound if the key does not exist and ErrJSONPathNotFound if the path leads nowhere
+func (s *Store) JSONGet(key string, path string) (string, error) {
+	t := s.startOp(OpRead)
+	defer t.finish(nil)
+
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return "", fmt.Errorf("JSONGet: %w", err)
+	}
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	t.rlock()
+	var doc jsonDoc
+	exists, err := s.loadCollection(key, models.TypeJSON, &doc, t)
+	s.mu.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("JSONGet: %w", err)
+	}
+	if !exists {
+		return "", fmt.Errorf("JSONGet: %w", ErrKeyNotFound)
+	}
+
+	value, err := getJSONPath(doc.root, steps)
+	if err != nil {
+		return "", fmt.Errorf("JSONGet: %w", err)
+	}
+
+	encoded, err := encodeJSON(value)
+	if err != nil {
+		return "", fmt.Errorf("JSONGet: failed to encode: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// JSONSet sets the value at path in the JSON document stored under key to value, a JSON-encoded value
+// Setting $ creates or replaces the whole document; any other path requires the document to exist
+// Returns ErrInvalidJSON if value is not valid JSON
+func (s *Store) JSONSet(key string, path string, value string) error {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return fmt.Errorf("JSONSet: %w", err)
+	}
+	decoded, err := decodeJSON([]byte(value))
+	if err != nil {
+		return fmt.Errorf("JSONSet: %w: %v", ErrInvalidJSON, err)
+	}
+
+	err = updateCollection(s, key, models.TypeJSON, func(doc *jsonDoc) (int, bool, error) {
+		if !doc.loaded && len(steps) > 0 {
+			return 0, false, ErrKeyNotFound
+		}
+		root, err := setJSONPath(doc.root, steps, decoded)
+		if err != nil {
+			return 0, false, err
+		}
+		doc.root = root
+		return 1, true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("JSONSet: %w", err)
+	}
+
+	return nil
+}
+
+// JSONDel removes the value at path from the JSON document stored under key
+// Deleting $ deletes the key; returns ErrJSONPathNotFound if the path leads nowhere
+func (s *Store) JSONDel(key string, path string) error {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return fmt.Errorf("JSONDel: %w", err)
+	}
+
+	err = updateCollection(s, key, models.TypeJSON, func(doc *jsonDoc) (int, bool, error) {
+		if !doc.loaded {
+			return 0, false, ErrKeyNotFound
+		}
+		if len(steps) == 0 {
+			return 0, true, nil
+		}
+		root, err := deleteJSONPath(doc.root, steps)
+		if err != nil {
+			return 0, false, err
+		}
+		doc.root = root
+		return 1, true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("JSONDel: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"github.com/vi88i/kvstash/constants"
+	"math/rand/v2"
+	"slices"
+)
+
+/*
+Ordered keys:
+
+The index is a map, which finds a key in constant time but knows no order. Next to it the store keeps every key of the
+index, deleted ones included, in a skip list in ascending order, so Range seeks to its start and walks only the keys
+it returns instead of sorting the whole index. The list is guarded by the store lock like the index: writes insert and
+remove keys as they change the index, and buildIndex, Reindex, and compaction, which replace the whole index, build
+it again from the sorted keys in one pass.
+
+Every node is on level 0 and, with probability 1/constants.KeyListBranching, on each level above the one below, up to
+constants.KeyListMaxLevel levels; a seek follows the highest level that does not overshoot the key, so it visits about
+log(n) nodes.
+*/
+
+// keyNode is a key of the key list
+type keyNode struct {
+	key string
+
+	// next holds the following node on every level the node is on
+	next []*keyNode
+}
+
+// keyList is a skip list of distinct keys in ascending order
+type keyList struct {
+	// head is the sentinel in front of the first key, on every level
+	head *keyNode
+
+	// level is the number of levels in use
+	level int
+}
+
+// newKeyList returns a key list of keys, which must be distinct; keys is sorted in place
+func newKeyList(keys []string) *keyList {
+	slices.Sort(keys)
+
+	l := &keyList{head: &keyNode{next: make([]*keyNode, constants.KeyListMaxLevel)}, level: 1}
+	var last [constants.KeyListMaxLevel]*keyNode
+	for i := range last {
+		last[i] = l.head
+	}
+	for _, key := range keys {
+		node := &keyNode{key: key, next: make([]*keyNode, randomLevel())}
+		for i := range node.next {
+			last[i].next[i] = node
+			last[i] = node
+		}
+		l.level = max(l.level, len(node.next))
+	}
+	return l
+}
+
+// randomLevel returns the number of levels of a new node
+func randomLevel() int {
+	level := 1
+	for level < constants.KeyListMaxLevel && rand.IntN(constants.KeyListBranching) == 0 {
+		level++
+	}
+	return level
+}
+
+// path returns, on every level, the last node before the first key not below key
+func (l *keyList) path(key string) [constants.KeyListMaxLevel]*keyNode {
+	var path [constants.KeyListMaxLevel]*keyNode
+	node := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		path[i] = node
+	}
+	return path
+}
+
+// seek returns the node of the first key not below key, nil if there is none
+func (l *keyList) seek(key string) *keyNode {
+	return l.path(key)[0].next[0]
+}
+
+// first returns the node of the smallest key, nil if the list is empty
+func (l *keyList) first() *keyNode {
+	return l.head.next[0]
+}
+
+// insert adds key to the list, if it is not in it yet
+func (l *keyList) insert(key string) {
+	path := l.path(key)
+	if next := path[0].next[0]; next != nil && next.key == key {
+		return
+	}
+
+	node := &keyNode{key: key, next: make([]*keyNode, randomLevel())}
+	for i := l.level; i < len(node.next); i++ {
+		path[i] = l.head
+	}
+	l.level = max(l.level, len(node.next))
+	for i := range node.next {
+		node.next[i] = path[i].next[i]
+		path[i].next[i] = node
+	}
+}
+
+// remove drops key from the list, if it is in it
+func (l *keyList) remove(key string) {
+	path := l.path(key)
+	node := path[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+
+	for i := range node.next {
+		path[i].next[i] = node.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+}
+
+// indexKeys builds the key list of the index again, after the whole index was replaced
+// Must be called with mu held
+func (s *Store) indexKeys() {
+	keys := make([]string, 0, len(s.index))
+	for key := range s.index {
+		keys = append(keys, key)
+	}
+	s.keys = newKeyList(keys)
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// ErrBadKeyspaceOptions is returned by Keyspace for options out of range
+var ErrBadKeyspaceOptions = errors.New("invalid keyspace options")
+
+// KeyspaceOptions selects what Keyspace analyzes
+type KeyspaceOptions struct {
+	// TopN is the number of largest values listed, at most constants.KeyspaceMaxTopN
+	// (default: 0, constants.KeyspaceTopN)
+	TopN int
+
+	// Delimiter ends the prefix of a key for the per-prefix counts, e.g. ":" counts "user:1" under "user:"
+	// Keys without it are counted under the empty prefix (default: ":")
+	Delimiter string
+
+	// Sample is the fraction of live keys analyzed, between 0 and 1 (default: 0, every key)
+	// Counts are not scaled up; multiply them by 1/Sample to estimate the whole keyspace
+	Sample float64
+}
+
+// SizeBucket is a bucket of a power-of-two size histogram
+type SizeBucket struct {
+	// UpTo is the largest size in the bucket; the bucket holds sizes above the previous bucket's UpTo
+	UpTo int64
+
+	// Count is the number of keys in the bucket
+	Count int
+}
+
+// KeySize is a key and the size of its value
+type KeySize struct {
+	Key  string
+	Size int64
+}
+
+// PrefixCount counts the keys sharing a prefix
+type PrefixCount struct {
+	// Prefix is the start of the keys up to and including the delimiter, "" for keys without it
+	Prefix string
+
+	// Keys is the number of keys with the prefix
+	Keys int
+
+	// ValueBytes is the total size of their values
+	ValueBytes int64
+}
+
+// KeyspaceStats describes the live keys of a store, see Keyspace
+type KeyspaceStats struct {
+	// LiveKeys is the number of live keys
+	LiveKeys int
+
+	// Analyzed is the number of keys the figures below are computed from, fewer than LiveKeys if sampled
+	Analyzed int
+
+	// Sample is the fraction of keys analyzed
+	Sample float64
+
+	// ValueBytes and KeyBytes are the total size of the analyzed values and keys
+	ValueBytes int64
+	KeyBytes   int64
+
+	// ValueSizes and KeyLengths are power-of-two histograms of value sizes and key lengths in bytes,
+	// up to the bucket of the largest one
+	ValueSizes []SizeBucket
+	KeyLengths []SizeBucket
+
+	// Largest lists the keys with the largest values, largest first
+	Largest []KeySize
+
+	// Prefixes counts the keys by prefix, most keys first, up to constants.KeyspaceMaxPrefixes prefixes
+	Prefixes []PrefixCount
+
+	// OtherPrefixes is the number of prefixes with fewer keys that are not listed
+	OtherPrefixes int
+}
+
+// Keyspace returns analytics of the live keys: value size and key length histograms, the largest values,
+// and key counts per prefix, to help decide on compression, chunking, and sharding
+// Value sizes are the bytes stored on disk, computed from the index without reading the values
+// Collections and JSON documents count with the size of their encoding
+// Returns ErrBadKeyspaceOptions if an option is out of range
+func (s *Store) Keyspace(opts KeyspaceOptions) (KeyspaceStats, error) {
+	topN := cmp.Or(opts.TopN, constants.KeyspaceTopN)
+	if opts.TopN < 0 || topN > constants.KeyspaceMaxTopN {
+		return KeyspaceStats{}, fmt.Errorf("Keyspace: %w: top must be between 1 and %d", ErrBadKeyspaceOptions, constants.KeyspaceMaxTopN)
+	}
+	if opts.Sample < 0 || opts.Sample > 1 {
+		return KeyspaceStats{}, fmt.Errorf("Keyspace: %w: sample must be between 0 and 1", ErrBadKeyspaceOptions)
+	}
+	sample := cmp.Or(opts.Sample, 1)
+	delimiter := cmp.Or(opts.Delimiter, ":")
+
+	stats := KeyspaceStats{Sample: sample}
+	var valueSizes, keyLengths histogram
+	prefixes := make(map[string]*PrefixCount)
+
+	s.mu.RLock()
+	now := s.now().UnixMilli()
+	for key, entry := range s.index {
+		if !live(entry, now) {
+			continue
+		}
+		stats.LiveKeys++
+		if sample < 1 && rand.Float64() >= sample {
+			continue
+		}
+
+		size := max(entry.Size-codec.PayloadOverhead()-int64(len(key)), 0)
+		stats.Analyzed++
+		stats.ValueBytes += size
+		stats.KeyBytes += int64(len(key))
+		valueSizes.add(size)
+		keyLengths.add(int64(len(key)))
+		stats.Largest = addLargest(stats.Largest, KeySize{Key: key, Size: size}, topN)
+
+		prefix := ""
+		if i := strings.Index(key, delimiter); i >= 0 {
+			prefix = key[:i+len(delimiter)]
+		}
+		p := prefixes[prefix]
+		if p == nil {
+			p = &PrefixCount{Prefix: prefix}
+			prefixes[prefix] = p
+		}
+		p.Keys++
+		p.ValueBytes += size
+	}
+	s.mu.RUnlock()
+
+	stats.ValueSizes = valueSizes.buckets()
+	stats.KeyLengths = keyLengths.buckets()
+
+	for _, p := range prefixes {
+		stats.Prefixes = append(stats.Prefixes, *p)
+	}
+	slices.SortFunc(stats.Prefixes, func(a, b PrefixCount) int {
+		return cmp.Or(cmp.Compare(b.Keys, a.Keys), strings.Compare(a.Prefix, b.Prefix))
+	})
+	if len(stats.Prefixes) > constants.KeyspaceMaxPrefixes {
+		stats.OtherPrefixes = len(stats.Prefixes) - constants.KeyspaceMaxPrefixes
+		stats.Prefixes = stats.Prefixes[:constants.KeyspaceMaxPrefixes]
+	}
+
+	return stats, nil
+}
+
+// histogram counts sizes in power-of-two buckets: bucket 0 holds 0 and 1, bucket i sizes up to 1<<i
+type histogram []int
+
+// add counts size in its bucket
+func (h *histogram) add(size int64) {
+	i := 0
+	if size > 1 {
+		i = bits.Len64(uint64(size - 1))
+	}
+	for len(*h) <= i {
+		*h = append(*h, 0)
+	}
+	(*h)[i]++
+}
+
+// buckets returns the buckets up to the last one counting a size
+func (h histogram) buckets() []SizeBucket {
+	buckets := make([]SizeBucket, len(h))
+	for i, count := range h {
+		buckets[i] = SizeBucket{UpTo: 1 << i, Count: count}
+	}
+	return buckets
+}
+
+// addLargest adds ks to largest, the up to n largest values ordered largest first, if it is one of them
+func addLargest(largest []KeySize, ks KeySize, n int) []KeySize {
+	if len(largest) == n && ks.Size <= largest[n-1].Size {
+		return largest
+	}
+
+	i, _ := slices.BinarySearchFunc(largest, ks.Size, func(e KeySize, size int64) int {
+		return cmp.Compare(size, e.Size)
+	})
+	largest = slices.Insert(largest, i, ks)
+	if len(largest) > n {
+		largest = largest[:n]
+	}
+	return largest
+}
@@ -0,0 +1,296 @@
+package store
+
+import (
+	"cmp"
+	"github.com/vi88i/kvstash/models"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Operations with latency histograms, see Store.Latencies
+const (
+	// OpGet reads a string value or JSON document
+	OpGet = "get"
+
+	// OpSet writes a string value
+	OpSet = "set"
+
+	// OpDelete deletes a key
+	OpDelete = "delete"
+
+	// OpGetMany reads several string values or JSON documents in one batch
+	OpGetMany = "get_many"
+
+	// OpBatch applies a conditional batch of writes
+	OpBatch = "batch"
+
+	// OpRead reads a list, set, hash, or JSON document
+	OpRead = "read"
+
+	// OpUpdate changes a list, set, hash, or JSON document
+	OpUpdate = "update"
+
+	// OpRename moves a value to another key
+	OpRename = "rename"
+
+	// OpExpire changes the expiry time of a key
+	OpExpire = "expire"
+)
+
+// Phases of a store operation; the total histogram of an operation is named PhaseTotal
+const (
+	// PhaseTotal is the whole operation
+	PhaseTotal = "total"
+
+	// PhaseLock is the wait for the store lock
+	PhaseLock = "lock"
+
+	// PhaseRead is reading the record from its segment file
+	PhaseRead = "read"
+
+	// PhaseChecksum is decoding the record and verifying its checksum
+	PhaseChecksum = "checksum"
+
+	// PhaseWrite is appending records to the active log; with DurabilitySync it includes the flush to stable storage
+	PhaseWrite = "write"
+)
+
+// phase indexes the phase durations of an opTimer
+type phase int
+
+const (
+	phaseLock phase = iota
+	phaseRead
+	phaseChecksum
+	phaseWrite
+	numPhases
+)
+
+// phaseNames maps phases to their names
+var phaseNames = [numPhases]string{PhaseLock, PhaseRead, PhaseChecksum, PhaseWrite}
+
+// opPhases lists the phases recorded for each operation
+var opPhases = map[string][]phase{
+	OpGet:     {phaseLock, phaseRead, phaseChecksum},
+	OpSet:     {phaseLock, phaseWrite},
+	OpDelete:  {phaseLock, phaseWrite},
+	OpGetMany: {phaseLock, phaseRead, phaseChecksum},
+	OpBatch:   {phaseLock, phaseRead, phaseChecksum, phaseWrite},
+	OpRead:    {phaseLock, phaseRead, phaseChecksum},
+	OpUpdate:  {phaseLock, phaseRead, phaseChecksum, phaseWrite},
+	OpRename:  {phaseLock, phaseRead, phaseChecksum, phaseWrite},
+	OpExpire:  {phaseLock, phaseWrite},
+}
+
+// LatencyBuckets are the upper bounds of the histogram buckets; a last bucket counts the slower observations
+var LatencyBuckets = [...]time.Duration{
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Histogram counts durations in LatencyBuckets
+// The zero value is ready to use and Observe is safe for concurrent use without locking
+type Histogram struct {
+	// counts holds the number of observations per bucket, the last one for observations above every bound
+	counts [len(LatencyBuckets) + 1]atomic.Uint64
+
+	// sum is the total of the observations in nanoseconds
+	sum atomic.Int64
+}
+
+// Observe adds a duration to the histogram
+func (h *Histogram) Observe(d time.Duration) {
+	i, _ := slices.BinarySearch(LatencyBuckets[:], d)
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// Snapshot returns the current counts of the histogram
+// Concurrent observations may be missing from Count or Sum, but never from the bucket they are counted in
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snap := HistogramSnapshot{Counts: make([]uint64, len(h.counts)), Sum: time.Duration(h.sum.Load())}
+	for i := range h.counts {
+		snap.Counts[i] = h.counts[i].Load()
+		snap.Count += snap.Counts[i]
+	}
+	return snap
+}
+
+// HistogramSnapshot is the state of a Histogram at one point in time
+type HistogramSnapshot struct {
+	// Counts holds the number of observations per bucket of LatencyBuckets, plus one for slower observations
+	Counts []uint64
+
+	// Count is the number of observations
+	Count uint64
+
+	// Sum is the total of the observations
+	Sum time.Duration
+}
+
+// Quantile estimates the q-th quantile (0 to 1) of the observations, interpolating within its bucket
+// Observations above the last bound are reported as the last bound
+func (h HistogramSnapshot) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.Count)
+	var seen uint64
+	for i, count := range h.Counts {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+		if i == len(LatencyBuckets) {
+			break
+		}
+
+		lower := time.Duration(0)
+		if i > 0 {
+			lower = LatencyBuckets[i-1]
+		}
+		fraction := (rank - float64(seen)) / float64(count)
+		return lower + time.Duration(fraction*float64(LatencyBuckets[i]-lower))
+	}
+
+	return LatencyBuckets[len(LatencyBuckets)-1]
+}
+
+// Mean returns the average observation
+func (h HistogramSnapshot) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// OpLatency holds the latency histograms of a store operation
+type OpLatency struct {
+	// Op is the operation, e.g. OpGet
+	Op string
+
+	// Total is the latency of the whole operation
+	Total HistogramSnapshot
+
+	// Phases maps phase names, e.g. PhaseLock, to their latency; a phase is only counted when the operation reached it
+	Phases map[string]HistogramSnapshot
+}
+
+// opHistograms holds the histograms of one operation
+type opHistograms struct {
+	// total is the latency of the whole operation
+	total Histogram
+
+	// phases holds the latency of each phase, nil for phases the operation does not have
+	phases [numPhases]*Histogram
+}
+
+// newLatencyHistograms creates the histograms of every operation
+func newLatencyHistograms() map[string]*opHistograms {
+	latency := make(map[string]*opHistograms, len(opPhases))
+	for op, phases := range opPhases {
+		h := &opHistograms{}
+		for _, p := range phases {
+			h.phases[p] = &Histogram{}
+		}
+		latency[op] = h
+	}
+	return latency
+}
+
+// Latencies returns the latency histograms of the store operations, ordered by operation
+func (s *Store) Latencies() []OpLatency {
+	latencies := make([]OpLatency, 0, len(s.latency))
+	for op, h := range s.latency {
+		l := OpLatency{Op: op, Total: h.total.Snapshot(), Phases: make(map[string]HistogramSnapshot)}
+		for p, ph := range h.phases {
+			if ph != nil {
+				l.Phases[phaseNames[p]] = ph.Snapshot()
+			}
+		}
+		latencies = append(latencies, l)
+	}
+
+	slices.SortFunc(latencies, func(a, b OpLatency) int {
+		return cmp.Compare(a.Op, b.Op)
+	})
+	return latencies
+}
+
+// opTimer times the phases of one store operation
+// A nil timer records nothing, for internal reads such as compaction and snapshots
+type opTimer struct {
+	// s is the store the operation runs on
+	s *Store
+
+	// op is the operation, e.g. OpGet
+	op string
+
+	// start is when the operation started
+	start time.Time
+
+	// took holds the time spent in each phase
+	took [numPhases]time.Duration
+
+	// reached records the phases the operation reached
+	reached [numPhases]bool
+}
+
+// startOp starts timing an operation
+func (s *Store) startOp(op string) *opTimer {
+	return &opTimer{s: s, op: op, start: time.Now()}
+}
+
+// lock takes the store lock for writing and records the wait
+func (t *opTimer) lock() {
+	start := time.Now()
+	t.s.mu.Lock()
+	t.add(phaseLock, start)
+}
+
+// rlock takes the store lock for reading and records the wait
+func (t *opTimer) rlock() {
+	start := time.Now()
+	t.s.mu.RLock()
+	t.add(phaseLock, start)
+}
+
+// add records the time since start in phase
+func (t *opTimer) add(p phase, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.took[p] += time.Since(start)
+	t.reached[p] = true
+}
+
+// finish adds the operation to the latency histograms and, if phases is not nil, reports its phases there
+func (t *opTimer) finish(phases *models.KVStashPhases) {
+	h := t.s.latency[t.op]
+	if h == nil {
+		return
+	}
+
+	h.total.Observe(time.Since(t.start))
+	for p, reached := range t.reached {
+		if reached && h.phases[p] != nil {
+			h.phases[p].Observe(t.took[p])
+		}
+	}
+
+	if phases != nil {
+		ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+		*phases = models.KVStashPhases{
+			LockMs:     ms(t.took[phaseLock]),
+			ReadMs:     ms(t.took[phaseRead]),
+			ChecksumMs: ms(t.took[phaseChecksum]),
+			WriteMs:    ms(t.took[phaseWrite]),
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Offline Maintenance Notes:
+
+The functions in this file operate directly on a database directory and are meant to be
+used by kvstash-admin while the server is stopped. None of them start the compaction
+goroutine, and none of them coordinate with a running Store - running them against a
+live database leads to undefined results.
+*/
+
+// SegmentReport describes the state of a single segment file as found by Verify
+type SegmentReport struct {
+	// Name is the segment filename (e.g., "seg0.log")
+	Name string
+
+	// FileSize is the size of the segment file in bytes
+	FileSize int64
+
+	// Records is the number of valid records (values and tombstones) before the first error
+	Records int
+
+	// Tombstones is the number of valid tombstone records
+	Tombstones int
+
+	// ValidEnd is the byte position just past the last valid record
+	ValidEnd int64
+
+	// Err is the first corruption found in the segment, nil if the segment is intact
+	Err error
+}
+
+// VerifyReport is the result of verifying every segment of a database
+type VerifyReport struct {
+	// Segments holds one report per segment file, in segment order
+	Segments []SegmentReport
+
+	// LiveKeys is the number of keys that would be visible after opening the database
+	LiveKeys int
+
+	// DeletedKeys is the number of keys whose latest record is a tombstone
+	DeletedKeys int
+}
+
+// Corrupted reports whether any segment contains an invalid record
+func (r *VerifyReport) Corrupted() bool {
+	for i := range r.Segments {
+		if r.Segments[i].Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify scans every segment in dbPath and validates both the metadata and value checksum of every record
+// Unlike index building, it does not stop at the first corrupted segment, so the report covers the whole database
+// Returns an error only if the directory or a segment file cannot be read
+func Verify(dbPath string) (*VerifyReport, error) {
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Verify: %w", err)
+	}
+
+	report := &VerifyReport{}
+	latest := make(map[string]bool)
+	for _, segment := range segments {
+		segReport, err := verifySegment(dbPath, segment, latest)
+		if err != nil {
+			return nil, fmt.Errorf("Verify: %w", err)
+		}
+		report.Segments = append(report.Segments, *segReport)
+	}
+
+	for _, deleted := range latest {
+		if deleted {
+			report.DeletedKeys++
+		} else {
+			report.LiveKeys++
+		}
+	}
+
+	return report, nil
+}
+
+// verifySegment validates all records of a single segment, recording the deleted state of each key in latest
+func verifySegment(dbPath string, segment string, latest map[string]bool) (*SegmentReport, error) {
+	file, err := os.Open(filepath.Join(dbPath, segment))
+	if err != nil {
+		return nil, fmt.Errorf("verifySegment: failed to open %v: %w", segment, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("verifySegment: failed to stat %v: %w", segment, err)
+	}
+
+	end, err := segmentEnd(dbPath, segment, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("verifySegment: %w", err)
+	}
+
+	report := &SegmentReport{Name: segment, FileSize: info.Size()}
+	for pos := int64(0); ; {
+		rec, err := readRecord(file, end, pos)
+		if err == io.EOF {
+			break
+		}
+
+		if err == nil {
+			err = rec.validateChecksum(segment)
+		}
+
+		if err != nil {
+			report.Err = err
+			break
+		}
+
+		report.Records++
+		if rec.deleted() {
+			report.Tombstones++
+		}
+		if !rec.expiry() {
+			latest[rec.data.Key] = rec.deleted()
+		}
+
+		pos = rec.end()
+		report.ValidEnd = pos
+	}
+
+	return report, nil
+}
+
+// TruncateTornTail removes a partially written record from the end of the active (last) segment
+// A tail is only considered torn if no valid record can be found after the first invalid byte;
+// corruption followed by valid records is not a torn write and is left for Salvage to handle
+// Returns the number of bytes removed (0 if the tail is intact)
+func TruncateTornTail(dbPath string) (int64, error) {
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("TruncateTornTail: %w", err)
+	}
+
+	if len(segments) == 0 {
+		return 0, nil
+	}
+
+	activeLog := segments[len(segments)-1]
+	file, err := os.OpenFile(filepath.Join(dbPath, activeLog), os.O_RDWR, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("TruncateTornTail: failed to open %v: %w", activeLog, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("TruncateTornTail: failed to stat %v: %w", activeLog, err)
+	}
+
+	pos := int64(0)
+	for {
+		rec, err := readRecord(file, info.Size(), pos)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			break
+		}
+		pos = rec.end()
+	}
+
+	if next := resync(file, info.Size(), pos); next >= 0 {
+		return 0, fmt.Errorf("TruncateTornTail: %v is corrupted at offset %d but has valid records at offset %d, use salvage instead",
+			activeLog, pos, next)
+	}
+
+	if err := file.Truncate(pos); err != nil {
+		return 0, fmt.Errorf("TruncateTornTail: failed to truncate %v: %w", activeLog, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("TruncateTornTail: failed to sync %v: %w", activeLog, err)
+	}
+
+	return info.Size() - pos, nil
+}
+
+// SalvageReport is the result of salvaging a database
+type SalvageReport struct {
+	// Records is the number of valid records found across all segments
+	Records int
+
+	// SkippedBytes is the number of bytes that could not be decoded and were skipped
+	SkippedBytes int64
+
+	// LiveKeys is the number of keys written to the salvaged database
+	LiveKeys int
+}
+
+// Salvage recovers every readable record from dbPath into a fresh database at outPath
+// Corrupted regions are skipped by searching forward for the next valid record, so records
+// after a corruption point are recovered too; records failing their value checksum are dropped
+// Segments are replayed in order, so the latest readable record of each key wins; expired keys are dropped
+// outPath must not exist yet
+func Salvage(dbPath string, outPath string) (*SalvageReport, error) {
+	if _, err := os.Stat(outPath); err == nil {
+		return nil, fmt.Errorf("Salvage: output directory already exists - %v", outPath)
+	}
+
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Salvage: %w", err)
+	}
+
+	report := &SalvageReport{}
+	latest := make(map[string]*salvagedRecord)
+	var revision uint64
+	if sb, ok, err := readSuperblock(dbPath); err == nil && ok {
+		revision = sb.revision
+	}
+	for _, segment := range segments {
+		if err := salvageSegment(dbPath, segment, latest, &revision, report); err != nil {
+			return nil, fmt.Errorf("Salvage: %w", err)
+		}
+	}
+
+	out, err := Open(outPath, Options{})
+	if err != nil {
+		return nil, fmt.Errorf("Salvage: failed to create output store: %w", err)
+	}
+	defer out.Close()
+
+	// Raised first, so records written before revisions are numbered above every salvaged revision
+	if err := out.raiseRevision(revision); err != nil {
+		return nil, fmt.Errorf("Salvage: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+	for _, rec := range latest {
+		if rec == nil || (rec.expiresAt != 0 && rec.expiresAt <= now) {
+			continue
+		}
+		if err := out.setTyped(rec.data.Key, rec.data.Value, rec.typ, rec.expiresAt, rec.revision); err != nil {
+			return nil, fmt.Errorf("Salvage: failed to write key=%v: %w", redact.Key(rec.data.Key), err)
+		}
+		report.LiveKeys++
+	}
+
+	return report, nil
+}
+
+// salvagedRecord is the latest readable value of a key found by Salvage
+type salvagedRecord struct {
+	// data is the key and value of the record
+	data models.KVStashRequest
+
+	// typ is the kind of value the record holds
+	typ models.KVStashValueType
+
+	// expiresAt is when the key expires in Unix milliseconds, 0 if it never expires
+	expiresAt int64
+
+	// revision is the revision of the record, 0 if it was written before revisions
+	revision uint64
+}
+
+// salvageSegment collects every valid record of a segment into latest, skipping over corrupted regions
+// Tombstones are recorded as nil entries so that earlier values of deleted keys are not resurrected
+// revision is raised to the highest revision of the records read
+func salvageSegment(dbPath string, segment string, latest map[string]*salvagedRecord, revision *uint64, report *SalvageReport) error {
+	file, err := os.Open(filepath.Join(dbPath, segment))
+	if err != nil {
+		return fmt.Errorf("salvageSegment: failed to open %v: %w", segment, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("salvageSegment: failed to stat %v: %w", segment, err)
+	}
+
+	for pos := int64(0); ; {
+		rec, err := readRecord(file, info.Size(), pos)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err == nil {
+			err = rec.validateChecksum(segment)
+		}
+
+		if err != nil {
+			next := resync(file, info.Size(), pos)
+			if next < 0 {
+				report.SkippedBytes += info.Size() - pos
+				return nil
+			}
+			report.SkippedBytes += next - pos
+			pos = next
+			continue
+		}
+
+		report.Records++
+		*revision = max(*revision, rec.revision)
+		if rec.expiry() {
+			if latest[rec.data.Key] != nil {
+				latest[rec.data.Key].expiresAt = rec.expiresAt
+				latest[rec.data.Key].revision = rec.revision
+			}
+		} else if rec.deleted() {
+			latest[rec.data.Key] = nil
+		} else {
+			// A value that cannot be decoded is not corrupt, its transformer is missing
+			value, err := rec.value()
+			if err != nil {
+				return fmt.Errorf("salvageSegment: %v: %w", segment, err)
+			}
+			data := models.KVStashRequest{Key: rec.data.Key, Value: value}
+			latest[rec.data.Key] = &salvagedRecord{data: data, typ: rec.valueType(), expiresAt: rec.expiresAt, revision: rec.revision}
+		}
+		pos = rec.end()
+	}
+}
+
+// IndexReport summarizes the index rebuilt from a database's segments
+type IndexReport struct {
+	// Segments is the number of segment files
+	Segments int
+
+	// ActiveLog is the segment new writes would be appended to
+	ActiveLog string
+
+	// ActiveLogCount is the number of records in the active log
+	ActiveLogCount int
+
+	// LiveKeys is the number of keys visible to Get
+	LiveKeys int
+
+	// DeletedKeys is the number of soft-deleted or expired keys still tracked in the index
+	DeletedKeys int
+}
+
+// RebuildIndex rebuilds the in-memory index of dbPath from its segment files exactly as startup would
+// The index is not persisted, so this is a dry run proving that the database can be opened
+// Returns an error under the same conditions that would make the server fail to start
+func RebuildIndex(dbPath string) (*IndexReport, error) {
+	s, err := openReadOnly(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("RebuildIndex: %w", err)
+	}
+
+	return s.indexReport(), nil
+}
+
+// ScanDir calls fn for every live key of dbPath matching the glob pattern (see Pattern), in ascending order
+// The database is opened read-only; iteration stops at the first error returned by fn
+func ScanDir(dbPath string, glob string, fn func(key string, value string) error) error {
+	pattern, err := CompilePattern(glob)
+	if err != nil {
+		return fmt.Errorf("ScanDir: %w", err)
+	}
+
+	s, err := openReadOnly(dbPath)
+	if err != nil {
+		return fmt.Errorf("ScanDir: %w", err)
+	}
+
+	snap := s.Snapshot()
+	defer snap.Release()
+
+	it := snap.Scan(pattern)
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("ScanDir: %w", err)
+	}
+
+	return nil
+}
+
+// openReadOnly builds the index of an existing database without opening a writer or starting compaction
+// Nothing in dbPath is created or modified; the returned store must not be written to
+func openReadOnly(dbPath string) (*Store, error) {
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("openReadOnly: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("openReadOnly: %v is not a directory", dbPath)
+	}
+
+	s := &Store{
+		index:         make(models.KVStashIndex),
+		dbPath:        dbPath,
+		feed:          newChangefeed(),
+		prefetchSlots: make(chan struct{}, constants.PrefetchWorkers),
+		startupRepair: StartupRepairFail,
+		clock:         systemClock{},
+		hlc:           newHybridClock(systemClock{}),
+	}
+
+	if err := s.buildIndex(nil); err != nil {
+		return nil, fmt.Errorf("openReadOnly: failed to build index: %w", err)
+	}
+
+	return s, nil
+}
+
+// indexReport summarizes the store's index
+func (s *Store) indexReport() *IndexReport {
+	report := &IndexReport{
+		Segments:       s.segmentCount,
+		ActiveLog:      s.activeLog,
+		ActiveLogCount: s.activeLogCount,
+	}
+	now := s.now().UnixMilli()
+	for _, entry := range s.index {
+		if live(entry, now) {
+			report.LiveKeys++
+		} else {
+			report.DeletedKeys++
+		}
+	}
+
+	return report
+}
+
+// CompactReport is the result of an offline compaction
+type CompactReport struct {
+	// Keys is the number of live keys copied into the compacted database
+	Keys int
+
+	// BytesBefore is the total size of the segment files before compaction
+	BytesBefore int64
+
+	// BytesAfter is the total size of the segment files after compaction
+	BytesAfter int64
+}
+
+// Compact rewrites dbPath so that it only contains the latest value of every live key
+// The compacted database is built next to dbPath and swapped in with renames; the original
+// is kept as a backup until the swap succeeds
+func Compact(dbPath string) (*CompactReport, error) {
+	tmpPath := filepath.Clean(dbPath) + ".compact"
+	bkpPath := filepath.Clean(dbPath) + ".bkp"
+
+	for _, p := range []string{tmpPath, bkpPath} {
+		if _, err := os.Stat(p); err == nil {
+			return nil, fmt.Errorf("Compact: leftover directory from a previous run - %v", p)
+		}
+	}
+
+	report := &CompactReport{}
+	before, err := dirSize(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Compact: %w", err)
+	}
+	report.BytesBefore = before
+
+	if err := copyLiveKeys(dbPath, tmpPath, report); err != nil {
+		os.RemoveAll(tmpPath)
+		return nil, fmt.Errorf("Compact: %w", err)
+	}
+
+	if err := os.Rename(dbPath, bkpPath); err != nil {
+		os.RemoveAll(tmpPath)
+		return nil, fmt.Errorf("Compact: failed to move database to backup: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		if restoreErr := os.Rename(bkpPath, dbPath); restoreErr != nil {
+			return nil, errors.Join(
+				fmt.Errorf("Compact: failed to move compacted database: %w", err),
+				fmt.Errorf("Compact: failed to restore backup from %v: %w", bkpPath, restoreErr),
+			)
+		}
+		return nil, fmt.Errorf("Compact: failed to move compacted database: %w", err)
+	}
+
+	if err := os.RemoveAll(bkpPath); err != nil {
+		return nil, fmt.Errorf("Compact: compacted, but failed to delete backup - %v: %w", bkpPath, err)
+	}
+
+	after, err := dirSize(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Compact: %w", err)
+	}
+	report.BytesAfter = after
+
+	return report, nil
+}
+
+// copyLiveKeys copies the latest value of every live key from the database at src into a new database at dst
+func copyLiveKeys(src string, dst string, report *CompactReport) error {
+	oldStore, err := Open(src, Options{})
+	if err != nil {
+		return fmt.Errorf("copyLiveKeys: failed to open source: %w", err)
+	}
+	defer oldStore.Close()
+
+	newStore, err := Open(dst, Options{})
+	if err != nil {
+		return fmt.Errorf("copyLiveKeys: failed to create destination: %w", err)
+	}
+	defer newStore.Close()
+
+	snap := oldStore.Snapshot()
+	defer snap.Release()
+
+	it := snap.Iterator()
+	for it.Next() {
+		if err := newStore.setTyped(it.Key(), it.Value(), it.Entry().Type, it.Entry().ExpiresAt, it.Entry().Revision); err != nil {
+			return fmt.Errorf("copyLiveKeys: failed to set key=%v: %w", redact.Key(it.Key()), err)
+		}
+		report.Keys++
+	}
+
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("copyLiveKeys: failed to read source: %w", err)
+	}
+
+	if err := newStore.raiseRevision(oldStore.revision); err != nil {
+		return fmt.Errorf("copyLiveKeys: %w", err)
+	}
+
+	return nil
+}
+
+// dirSize returns the total size of the segment files in dbPath
+func dirSize(dbPath string) (int64, error) {
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("dirSize: %w", err)
+	}
+
+	var total int64
+	for _, segment := range segments {
+		info, err := os.Stat(filepath.Join(dbPath, segment))
+		if err != nil {
+			return 0, fmt.Errorf("dirSize: failed to stat %v: %w", segment, err)
+		}
+		total += info.Size()
+	}
+
+	return total, nil
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// GetMany returns the values of the keys holding a string or JSON document, mapped by the keys as given
+// Missing, deleted, and expired keys and keys holding collections are left out
+// The values are read grouped by segment file and in offset order, so every segment is opened once and read front
+// to back; for many keys this is much cheaper than a Get per key
+// The records are checked at verify, or the store's verification level if it is empty, see Verification
+// Inline and cached values are returned from memory unless verify is given, see SetInlineThreshold and
+// SetReadCacheBytes
+// A checksum mismatch purges the key from the index like Get does, and fails the call with ErrChecksumMismatch
+// Returns ErrBadVerification for an unknown verification level
+func (s *Store) GetMany(keys []string, verify Verification) (map[string]string, error) {
+	useMemory := verify == ""
+	verify, err := s.verificationFor(string(verify))
+	if err != nil {
+		return nil, fmt.Errorf("GetMany: %w", err)
+	}
+
+	t := s.startOp(OpGetMany)
+	defer t.finish(nil)
+
+	found := make([]string, 0, len(keys))
+	entries := make([]models.KVStashIndexEntry, 0, len(keys))
+	// indexed holds the index entries entries were copied from, which the read cache remembers
+	indexed := make([]*models.KVStashIndexEntry, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	inline := make(map[string]string)
+	cached := make(map[string]string)
+	misses := 0
+
+	t.rlock()
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		entry, ok := s.lookup(s.normalization.Key(key))
+		if !ok {
+			misses++
+			continue
+		}
+		if entry.Type != models.TypeString && entry.Type != models.TypeJSON {
+			continue
+		}
+		if entry.Inline != nil && useMemory {
+			inline[key] = *entry.Inline
+			continue
+		}
+		if useMemory {
+			if value, ok := s.readCache.get(s.normalization.Key(key), entry); ok {
+				cached[key] = value
+				continue
+			}
+		}
+		found = append(found, key)
+		entries = append(entries, *entry)
+		indexed = append(indexed, entry)
+	}
+	dbPath := s.dbPath
+	s.mu.RUnlock()
+
+	values, errs := readValues(s.files, dbPath, entries, verify, t)
+	s.inlineHits.Add(int64(len(inline)))
+	s.readHits.Add(int64(len(inline) + len(cached)))
+	s.readMisses.Add(int64(misses))
+
+	result := make(map[string]string, len(found)+len(inline)+len(cached))
+	for key, value := range inline {
+		result[key] = value
+		s.touch(s.normalization.Key(key), false)
+	}
+	for key, value := range cached {
+		result[key] = value
+		s.touch(s.normalization.Key(key), false)
+	}
+	var failure error
+	for i, key := range found {
+		if errs != nil && errs[i] != nil {
+			if errors.Is(errs[i], ErrChecksumMismatch) {
+				_ = s.Delete(&models.KVStashRequest{Key: key})
+				logging.Errorf("GetMany: purged corrupted entry for key=%v due to checksum mismatch", redact.Key(key))
+				s.raiseAlert(models.AlertCorruption, models.SeverityCritical,
+					"checksum mismatch reading key=%v from %v, the key was deleted", redact.Key(key), entries[i].SegmentFile)
+				s.notifyCorruption(s.normalization.Key(key), entries[i].SegmentFile, errs[i])
+			}
+			failure = cmp.Or(failure, errs[i])
+			continue
+		}
+
+		result[key] = values[i]
+		s.readHits.Add(1)
+		if useMemory {
+			s.readCache.put(s.normalization.Key(key), indexed[i], values[i])
+		}
+		s.touch(s.normalization.Key(key), false)
+	}
+	s.notifyGetMany(keys, result, inline, found, errs, time.Since(t.start))
+
+	if failure != nil {
+		return nil, fmt.Errorf("GetMany: %w", failure)
+	}
+	return result, nil
+}
+
+// notifyGetMany reports the reads of GetMany to the hooks, every distinct key of keys once, with the values of
+// result, served from the index for the keys of inline, and the errors in errs of the keys read from disk, found
+func (s *Store) notifyGetMany(keys []string, result map[string]string, inline map[string]string, found []string,
+	errs []error, elapsed time.Duration) {
+	if len(s.registeredHooks()) == 0 {
+		return
+	}
+
+	failed := make(map[string]error)
+	for i, key := range found {
+		if errs != nil && errs[i] != nil {
+			failed[key] = errs[i]
+		}
+	}
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		info := GetInfo{Key: s.normalization.Key(key), Elapsed: elapsed}
+		value, ok := result[key]
+		_, info.Inline = inline[key]
+		switch {
+		case ok:
+			info.Value = value
+		case failed[key] != nil:
+			info.Err = failed[key]
+		default:
+			info.Err = ErrKeyNotFound
+		}
+		s.notifyGet(info)
+	}
+}
+
+// readValues reads the values of entries grouped by segment file, reading each file in offset order,
+// so that every file is acquired once and read front to back
+// Returns the values in the order of entries; errs is nil if every read succeeded, otherwise it holds the error
+// of each entry, nil for the entries that were read
+// verify selects how much of each record is checked, see Verification
+// The read and checksum phases are timed by t, which may be nil
+func readValues(files *handlePool, dbPath string, entries []models.KVStashIndexEntry, verify Verification, t *opTimer) ([]string, []error) {
+	values := make([]string, len(entries))
+	var errs []error
+	fail := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(entries))
+		}
+		errs[i] = err
+	}
+
+	bySegment := make(map[string][]int)
+	for i := range entries {
+		bySegment[entries[i].SegmentFile] = append(bySegment[entries[i].SegmentFile], i)
+	}
+
+	for segment, positions := range bySegment {
+		slices.SortFunc(positions, func(a, b int) int {
+			return cmp.Compare(entries[a].Offset, entries[b].Offset)
+		})
+
+		readStart := time.Now()
+		handle, err := files.acquire(filepath.Join(dbPath, segment))
+		if err != nil {
+			for _, i := range positions {
+				fail(i, fmt.Errorf("readValues: failed to open file %s: %w", segment, err))
+			}
+			continue
+		}
+
+		info, err := handle.file.Stat()
+		if err != nil {
+			files.release(handle)
+			for _, i := range positions {
+				fail(i, fmt.Errorf("readValues: failed to stat file: %w", err))
+			}
+			continue
+		}
+
+		for _, i := range positions {
+			entry := &entries[i]
+			value, err := readValue(handle.file, info.Size(), segment, entry.Offset, entry.Size, entryFlags(entry),
+				entry.Checksum, verify, t, readStart)
+			if err != nil {
+				fail(i, fmt.Errorf("readValues: %w", err))
+			}
+			values[i] = value
+			readStart = time.Now()
+		}
+		files.release(handle)
+	}
+
+	return values, errs
+}
@@ -0,0 +1,373 @@
+package store
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Namespaces:
+
+A namespace is the set of keys starting with a prefix, such as "cache:" or "session:", with its own defaults
+and limits, so a cache and durable data can share one server. A key belongs to the namespace with the longest
+matching prefix; a namespace with an empty prefix holds the keys of no other namespace.
+
+  - DefaultTTL applies to writes that don't set a TTL
+  - MaxValueSize lowers the maximum value size for the namespace
+  - MaxKeys bounds the number of live keys; when a write would add one more, the eviction policy either
+    refuses it with ErrNamespaceFull or deletes the least recently used or the oldest key first
+
+Recency is tracked in memory: after a restart keys are ordered by the position of their latest write.
+*/
+
+// Errors returned for namespaces and expiring keys
+var (
+	// ErrBadNamespace is returned by SetNamespaces for an invalid namespace configuration
+	ErrBadNamespace = errors.New("invalid namespace")
+
+	// ErrNamespaceFull is returned when a write would add a key to a namespace that holds MaxKeys keys
+	// and whose eviction policy is EvictNone
+	ErrNamespaceFull = errors.New("namespace is full")
+
+	// ErrBadTTL is returned for a negative TTL other than -1
+	ErrBadTTL = errors.New("ttl must be positive, or -1 for no expiry")
+
+	// ErrBadExpireAt is returned for a negative expiry timestamp or one combined with a TTL
+	ErrBadExpireAt = errors.New("expire_at must be a positive Unix time and cannot be combined with ttl")
+)
+
+// EvictionPolicy selects what happens when a write would exceed the MaxKeys of a namespace
+type EvictionPolicy string
+
+// Eviction policies
+const (
+	// EvictNone refuses the write with ErrNamespaceFull (the default)
+	EvictNone EvictionPolicy = "none"
+
+	// EvictLRU deletes the least recently read or written key
+	EvictLRU EvictionPolicy = "lru"
+
+	// EvictFIFO deletes the least recently written key
+	EvictFIFO EvictionPolicy = "fifo"
+)
+
+// Namespace configures the defaults and limits of the keys starting with Prefix
+type Namespace struct {
+	// Name identifies the namespace in logs and errors
+	Name string
+
+	// Prefix selects the keys of the namespace; the longest matching prefix wins and "" matches every key
+	Prefix string
+
+	// DefaultTTL is the time to live of keys written without one (default: 0, keys never expire)
+	DefaultTTL time.Duration
+
+	// MaxValueSize is the maximum value size in bytes (default: 0, constants.MaxValueSize)
+	MaxValueSize int
+
+	// MaxKeys is the maximum number of live keys (default: 0, unlimited)
+	MaxKeys int
+
+	// Eviction is applied when a write would exceed MaxKeys (default: EvictNone)
+	Eviction EvictionPolicy
+}
+
+// namespaceState is a configured namespace and the recency order of its keys
+type namespaceState struct {
+	Namespace
+
+	// keys orders the tracked keys from the next eviction candidate to the most recently used
+	// Only namespaces with MaxKeys track their keys; tracked keys may have expired since
+	keys *list.List
+
+	// elems maps tracked keys to their element in keys
+	elems map[string]*list.Element
+}
+
+// Validate checks the configuration of a namespace
+func (ns *Namespace) Validate() error {
+	switch {
+	case ns.Name == "":
+		return fmt.Errorf("%w: a name is required (prefix %q)", ErrBadNamespace, ns.Prefix)
+	case ns.DefaultTTL < 0:
+		return fmt.Errorf("%w %v: default TTL must not be negative", ErrBadNamespace, ns.Name)
+	case ns.MaxValueSize < 0 || ns.MaxValueSize > constants.MaxValueSize:
+		return fmt.Errorf("%w %v: max value size must be between 0 and %d", ErrBadNamespace, ns.Name, constants.MaxValueSize)
+	case ns.MaxKeys < 0:
+		return fmt.Errorf("%w %v: max keys must not be negative", ErrBadNamespace, ns.Name)
+	}
+
+	switch ns.Eviction {
+	case "", EvictNone, EvictLRU, EvictFIFO:
+		return nil
+	default:
+		return fmt.Errorf("%w %v: unknown eviction policy %q (expected %v, %v, or %v)",
+			ErrBadNamespace, ns.Name, ns.Eviction, EvictNone, EvictLRU, EvictFIFO)
+	}
+}
+
+// SetNamespaces replaces the namespace configuration
+// Prefixes are normalized like keys (see KeyNormalization) and must be unique
+// Returns ErrBadNamespace if a namespace is invalid, in which case the previous configuration stays in effect
+func (s *Store) SetNamespaces(namespaces []Namespace) error {
+	states := make([]*namespaceState, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if err := ns.Validate(); err != nil {
+			return fmt.Errorf("SetNamespaces: %w", err)
+		}
+		if ns.Eviction == "" {
+			ns.Eviction = EvictNone
+		}
+		ns.Prefix = s.normalization.Prefix(ns.Prefix)
+
+		for _, other := range states {
+			if other.Name == ns.Name || other.Prefix == ns.Prefix {
+				return fmt.Errorf("SetNamespaces: %w: %v and %v have the same name or prefix", ErrBadNamespace, other.Name, ns.Name)
+			}
+		}
+		states = append(states, &namespaceState{Namespace: ns})
+	}
+
+	// Longest prefix first, so the first match is the namespace of a key
+	sort.SliceStable(states, func(i, j int) bool {
+		return len(states[i].Prefix) > len(states[j].Prefix)
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now().UnixMilli()
+	for _, key := range s.writeOrder() {
+		ns := matchNamespace(states, key)
+		if ns == nil || ns.MaxKeys == 0 || !live(s.index[key], now) {
+			continue
+		}
+		if ns.keys == nil {
+			ns.keys = list.New()
+			ns.elems = make(map[string]*list.Element)
+		}
+		ns.elems[key] = ns.keys.PushBack(key)
+	}
+
+	s.nsMu.Lock()
+	s.namespaces = states
+	s.nsMu.Unlock()
+
+	return nil
+}
+
+// Namespaces returns the namespace configuration, longest prefix first
+func (s *Store) Namespaces() []Namespace {
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	namespaces := make([]Namespace, 0, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		namespaces = append(namespaces, ns.Namespace)
+	}
+	return namespaces
+}
+
+// writeOrder returns the keys of the index ordered by the position of their latest write
+// The caller must hold mu
+func (s *Store) writeOrder() []string {
+	keys := make([]string, 0, len(s.index))
+	for key := range s.index {
+		keys = append(keys, key)
+	}
+
+	segmentNum := func(name string) int {
+		num, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, constants.SegmentNamePrefix), constants.SegmentNameExt))
+		return num
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		ea, eb := s.index[a], s.index[b]
+		if na, nb := segmentNum(ea.SegmentFile), segmentNum(eb.SegmentFile); na != nb {
+			return na - nb
+		}
+		return int(ea.Offset - eb.Offset)
+	})
+
+	return keys
+}
+
+// matchNamespace returns the namespace of key among states, which are ordered longest prefix first, or nil
+func matchNamespace(states []*namespaceState, key string) *namespaceState {
+	for _, ns := range states {
+		if strings.HasPrefix(key, ns.Prefix) {
+			return ns
+		}
+	}
+	return nil
+}
+
+// live reports whether entry holds a value that is neither deleted nor expired at now, in Unix milliseconds
+func live(entry *models.KVStashIndexEntry, now int64) bool {
+	return entry != nil && !entry.Deleted && (entry.ExpiresAt == 0 || entry.ExpiresAt > now)
+}
+
+// lookup returns the index entry of key if it is live
+// The caller must hold mu (read or write)
+func (s *Store) lookup(key string) (*models.KVStashIndexEntry, bool) {
+	entry := s.index[key]
+	if !live(entry, s.now().UnixMilli()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// expiryFor returns the expiry time in Unix milliseconds of key written with a time to live of ttl
+// 0 applies the default TTL of the key's namespace and a negative ttl means the key never expires
+func (s *Store) expiryFor(key string, ttl time.Duration) int64 {
+	if ttl == 0 {
+		s.nsMu.Lock()
+		if ns := matchNamespace(s.namespaces, key); ns != nil {
+			ttl = ns.DefaultTTL
+		}
+		s.nsMu.Unlock()
+	}
+
+	if ttl <= 0 {
+		return 0
+	}
+	return s.now().Add(ttl).UnixMilli()
+}
+
+// validateValueFor checks the size of a value written to key against the limit of its namespace
+func (s *Store) validateValueFor(key string, value string) error {
+	if err := validateValue(value); err != nil {
+		return err
+	}
+	if len(value) > s.maxValueSize {
+		return fmt.Errorf("%w (%d bytes)", ErrValueTooLarge, s.maxValueSize)
+	}
+
+	s.nsMu.Lock()
+	ns := matchNamespace(s.namespaces, key)
+	s.nsMu.Unlock()
+
+	if ns != nil && ns.MaxValueSize > 0 && len(value) > ns.MaxValueSize {
+		return fmt.Errorf("%w (%d bytes in namespace %v)", ErrValueTooLarge, ns.MaxValueSize, ns.Name)
+	}
+
+	return nil
+}
+
+// makeRoom evicts keys until a write to key fits in the MaxKeys of its namespace
+// Returns ErrNamespaceFull if the namespace is full and does not evict
+// The caller must hold mu; evictions are timed by t, which may be nil
+func (s *Store) makeRoom(key string, t *opTimer) error {
+	for {
+		victim, err := s.evictionCandidate(key)
+		if err != nil {
+			return fmt.Errorf("makeRoom: %w", err)
+		}
+		if victim == "" {
+			return nil
+		}
+
+		if err := s.tombstone(victim, models.EventEvict, "", t); err != nil {
+			return fmt.Errorf("makeRoom: failed to evict %v: %w", redact.Key(victim), err)
+		}
+		logging.Debugf("makeRoom: evicted key=%v to make room for key=%v", redact.Key(victim), redact.Key(key))
+	}
+}
+
+// evictionCandidate returns the key to evict before key is written, or "" if the write fits
+// Keys that are no longer live are dropped from the recency order on the way
+// The caller must hold mu
+func (s *Store) evictionCandidate(key string) (string, error) {
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	ns := matchNamespace(s.namespaces, key)
+	if ns == nil || ns.MaxKeys == 0 {
+		return "", nil
+	}
+
+	now := s.now().UnixMilli()
+	if live(s.index[key], now) {
+		return "", nil
+	}
+	ns.untrack(key)
+
+	for ns.keys != nil && ns.keys.Len() > 0 && !live(s.index[ns.keys.Front().Value.(string)], now) {
+		ns.untrack(ns.keys.Front().Value.(string))
+	}
+	if ns.keys == nil || ns.keys.Len() < ns.MaxKeys {
+		return "", nil
+	}
+
+	if ns.Eviction == EvictNone {
+		// Expired keys anywhere in the namespace free up room
+		for e := ns.keys.Front(); e != nil; {
+			next := e.Next()
+			if k := e.Value.(string); !live(s.index[k], now) {
+				ns.untrack(k)
+			}
+			e = next
+		}
+		if ns.keys.Len() < ns.MaxKeys {
+			return "", nil
+		}
+		return "", fmt.Errorf("%w: %v holds %d keys", ErrNamespaceFull, ns.Name, ns.MaxKeys)
+	}
+
+	return ns.keys.Front().Value.(string), nil
+}
+
+// touch moves key to the most recently used end of its namespace's recency order
+// Reads only count for EvictLRU; written keys that are not tracked yet are added
+// Every read and write of a key passes here, so the access is counted for HotKeys too
+func (s *Store) touch(key string, write bool) {
+	s.hot.record(key, s.clock.Now())
+
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	ns := matchNamespace(s.namespaces, key)
+	if ns == nil || ns.MaxKeys == 0 || (!write && ns.Eviction != EvictLRU) {
+		return
+	}
+
+	if e, ok := ns.elems[key]; ok {
+		ns.keys.MoveToBack(e)
+		return
+	}
+	if write {
+		if ns.keys == nil {
+			ns.keys = list.New()
+			ns.elems = make(map[string]*list.Element)
+		}
+		ns.elems[key] = ns.keys.PushBack(key)
+	}
+}
+
+// forget removes a deleted key from its namespace's recency order
+func (s *Store) forget(key string) {
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	if ns := matchNamespace(s.namespaces, key); ns != nil {
+		ns.untrack(key)
+	}
+}
+
+// untrack removes key from the recency order
+// The caller must hold the store's nsMu
+func (ns *namespaceState) untrack(key string) {
+	if e, ok := ns.elems[key]; ok {
+		ns.keys.Remove(e)
+		delete(ns.elems, key)
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrBadKeyNormalization is returned by ParseKeyNormalization for an unknown mode
+var ErrBadKeyNormalization = errors.New("unknown key normalization mode")
+
+// KeyNormalization selects how keys are rewritten before they are stored or looked up
+// The zero value leaves keys untouched, which is the default
+//
+// Normalization is applied by every operation that takes a key (Set, Get, Delete, collections, JSON documents),
+// to glob patterns of Scan, and to the prefixes of Watch and Subscribe, so equivalent spellings of a key always
+// reach the same entry. Keys are stored in their normalized form. Keys that are not valid UTF-8 are left as is
+//
+// The modes apply to a whole database: when one is enabled on an existing database, keys written before are
+// normalized as the index is rebuilt, and if two of them become equal the latest write wins
+type KeyNormalization struct {
+	// TrimSpace removes leading and trailing white space, so " user:1 " and "user:1" are the same key
+	TrimSpace bool
+
+	// FoldCase makes keys case-insensitive with Unicode case folding, so "User:1" and "user:1" are the same key
+	FoldCase bool
+
+	// NFC converts keys to Unicode Normalization Form C, so precomposed and decomposed accents are the same key
+	NFC bool
+}
+
+// Key normalization mode names accepted by ParseKeyNormalization
+const (
+	NormalizeTrim = "trim"
+	NormalizeFold = "fold"
+	NormalizeNFC  = "nfc"
+)
+
+// ParseKeyNormalization parses a comma separated list of modes such as "trim,fold,nfc"; "" and "none" disable normalization
+// Returns ErrBadKeyNormalization for an unknown mode
+func ParseKeyNormalization(modes string) (KeyNormalization, error) {
+	var n KeyNormalization
+	if modes == "" || modes == "none" {
+		return n, nil
+	}
+
+	for _, mode := range strings.Split(modes, ",") {
+		switch strings.TrimSpace(mode) {
+		case NormalizeTrim:
+			n.TrimSpace = true
+		case NormalizeFold:
+			n.FoldCase = true
+		case NormalizeNFC:
+			n.NFC = true
+		default:
+			return KeyNormalization{}, fmt.Errorf("ParseKeyNormalization: %w %q (expected %v, %v, or %v)",
+				ErrBadKeyNormalization, mode, NormalizeTrim, NormalizeFold, NormalizeNFC)
+		}
+	}
+
+	return n, nil
+}
+
+// String returns the enabled modes in the form accepted by ParseKeyNormalization, "none" if there are none
+func (n KeyNormalization) String() string {
+	var modes []string
+	if n.TrimSpace {
+		modes = append(modes, NormalizeTrim)
+	}
+	if n.FoldCase {
+		modes = append(modes, NormalizeFold)
+	}
+	if n.NFC {
+		modes = append(modes, NormalizeNFC)
+	}
+	if len(modes) == 0 {
+		return "none"
+	}
+	return strings.Join(modes, ",")
+}
+
+// KeyNormalization returns how the store normalizes keys, see Options.KeyNormalization
+func (s *Store) KeyNormalization() KeyNormalization {
+	return s.normalization
+}
+
+// Enabled reports whether any mode is enabled
+func (n KeyNormalization) Enabled() bool {
+	return n.TrimSpace || n.FoldCase || n.NFC
+}
+
+// Key returns the normalized form of key
+// Case folding runs before NFC because folding can produce text that is not in NFC
+func (n KeyNormalization) Key(key string) string {
+	if !n.Enabled() || !utf8.ValidString(key) {
+		return key
+	}
+
+	if n.TrimSpace {
+		key = strings.TrimSpace(key)
+	}
+	return n.fold(key)
+}
+
+// Prefix returns the normalized form of a key prefix
+// Only leading white space is trimmed, since the keys starting with the prefix continue after it
+func (n KeyNormalization) Prefix(prefix string) string {
+	if !n.Enabled() || !utf8.ValidString(prefix) {
+		return prefix
+	}
+
+	if n.TrimSpace {
+		prefix = strings.TrimLeftFunc(prefix, unicode.IsSpace)
+	}
+	return n.fold(prefix)
+}
+
+// fold applies case folding and NFC, if enabled
+func (n KeyNormalization) fold(s string) string {
+	if n.FoldCase {
+		s = cases.Fold().String(s)
+	}
+	if n.NFC {
+		s = norm.NFC.String(s)
+	}
+	return s
+}
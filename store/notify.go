@@ -0,0 +1,101 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrUnknownEventClass is returned by Subscribe for an event class that is never published
+var ErrUnknownEventClass = errors.New("unknown event class")
+
+// EventClasses lists the event classes a subscription can select
+var EventClasses = []string{models.EventSet, models.EventDelete, models.EventEvict, models.EventExpire, models.EventExpiry}
+
+// Subscription receives keyspace notifications: every event of the selected classes on keys starting with a prefix
+// Unlike a Watcher it is a fire-hose without replay or resume; a subscriber that falls behind misses
+// notifications instead of being disconnected, and learns how many from Dropped
+type Subscription struct {
+	// feed is the changefeed the subscription is registered with
+	feed *changefeed
+
+	// prefix filters the delivered events by key
+	prefix string
+
+	// classes holds the selected event classes
+	classes []string
+
+	// ch delivers the events; it is closed by Close
+	ch chan models.KVStashEvent
+
+	// dropped counts the events not delivered because ch was full
+	dropped atomic.Uint64
+}
+
+// Subscribe registers a keyspace notification subscription for events of the given classes
+// (see EventClasses; none selects all) on keys starting with prefix (empty prefix matches every key)
+// Returns ErrUnknownEventClass for a class that is never published
+// The subscription must be closed with Close when no longer needed
+func (s *Store) Subscribe(prefix string, classes []string) (*Subscription, error) {
+	for _, class := range classes {
+		if !slices.Contains(EventClasses, class) {
+			return nil, fmt.Errorf("Subscribe: %w %q (expected one of %v)", ErrUnknownEventClass, class, strings.Join(EventClasses, ", "))
+		}
+	}
+	if len(classes) == 0 {
+		classes = EventClasses
+	}
+
+	sub := &Subscription{
+		feed:    s.feed,
+		prefix:  s.normalization.Prefix(prefix),
+		classes: classes,
+		ch:      make(chan models.KVStashEvent, constants.NotifyBufferSize),
+	}
+
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	s.feed.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// deliver sends event to the subscriber if it matches, counting it as dropped if the subscriber is behind
+// Must be called with the changefeed's mu held
+func (sub *Subscription) deliver(event models.KVStashEvent) {
+	if !strings.HasPrefix(event.Key, sub.prefix) || !slices.Contains(sub.classes, event.Type) {
+		return
+	}
+
+	select {
+	case sub.ch <- event:
+	default:
+		sub.dropped.Add(1)
+	}
+}
+
+// Events returns the channel delivering the subscription's events; it is closed by Close
+func (sub *Subscription) Events() <-chan models.KVStashEvent {
+	return sub.ch
+}
+
+// Dropped returns the number of events missed since the previous call because the subscriber fell behind
+func (sub *Subscription) Dropped() uint64 {
+	return sub.dropped.Swap(0)
+}
+
+// Close unregisters the subscription and closes its channel
+// Calling Close more than once has no effect
+func (sub *Subscription) Close() {
+	sub.feed.mu.Lock()
+	defer sub.feed.mu.Unlock()
+
+	if _, ok := sub.feed.subscribers[sub]; ok {
+		delete(sub.feed.subscribers, sub)
+		close(sub.ch)
+	}
+}
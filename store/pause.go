@@ -0,0 +1,325 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrBadPauseWindow is returned by SetCompactionPauseWindows and SetCompactionWindows for an invalid window
+var ErrBadPauseWindow = errors.New("invalid compaction pause window")
+
+// ErrBadGarbageRatio is returned by SetCompactionMinGarbage for a ratio outside [0, 1)
+var ErrBadGarbageRatio = errors.New("garbage ratio must be at least 0 and below 1")
+
+// ErrCompactionSkipped is returned by Compact if the cycle could not start, e.g. because snapshots are open
+var ErrCompactionSkipped = errors.New("compaction cycle skipped")
+
+// PauseWindow is a daily time span, in the server's local time, during which automatic compaction does not run,
+// e.g. peak traffic hours or a nightly backup
+// SetCompactionWindows uses the same spans the other way round, as the only times compaction may run
+type PauseWindow struct {
+	// Start and End are offsets from midnight; a window with End before Start runs past midnight
+	Start time.Duration
+	End   time.Duration
+
+	// Days restricts the window to the weekdays it starts on (default: every day)
+	Days []time.Weekday
+}
+
+// ParsePauseWindow parses a window given as "HH:MM" start and end times and weekday names or abbreviations
+func ParsePauseWindow(start string, end string, days []string) (PauseWindow, error) {
+	var w PauseWindow
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return w, fmt.Errorf("ParsePauseWindow: %w", err)
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return w, fmt.Errorf("ParsePauseWindow: %w", err)
+	}
+
+	for _, day := range days {
+		weekday, ok := parseWeekday(day)
+		if !ok {
+			return w, fmt.Errorf("ParsePauseWindow: %w: unknown day %q", ErrBadPauseWindow, day)
+		}
+		w.Days = append(w.Days, weekday)
+	}
+
+	return w, w.Validate()
+}
+
+// parseClock parses an "HH:MM" time of day into an offset from midnight
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("%w: time %q must be HH:MM", ErrBadPauseWindow, clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekday parses a weekday name such as "sunday" or "sun", ignoring case
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// Validate checks the start and end offsets of a window
+func (w PauseWindow) Validate() error {
+	if w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End >= 24*time.Hour {
+		return fmt.Errorf("%w: start and end must be within a day", ErrBadPauseWindow)
+	}
+	if w.Start == w.End {
+		return fmt.Errorf("%w: start and end must differ", ErrBadPauseWindow)
+	}
+	return nil
+}
+
+// String formats the window like "22:00-02:00 sat,sun"
+func (w PauseWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	s := clock(w.Start) + "-" + clock(w.End)
+	if len(w.Days) > 0 {
+		days := make([]string, 0, len(w.Days))
+		for _, day := range w.Days {
+			days = append(days, strings.ToLower(day.String()[:3]))
+		}
+		s += " " + strings.Join(days, ",")
+	}
+	return s
+}
+
+// contains reports whether t falls within the window
+func (w PauseWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	startsOn := func(day time.Weekday) bool {
+		return len(w.Days) == 0 || slices.Contains(w.Days, day)
+	}
+
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End && startsOn(t.Weekday())
+	}
+
+	// The window runs past midnight: the evening part belongs to today, the morning part to yesterday's window
+	if offset >= w.Start {
+		return startsOn(t.Weekday())
+	}
+	return offset < w.End && startsOn((t.Weekday()+6)%7)
+}
+
+// PauseCompaction stops automatic compaction cycles from starting, for d or until ResumeCompaction if d is 0
+// A cycle that is already running finishes; skipped cycles are recorded in the compaction history
+func (s *Store) PauseCompaction(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("PauseCompaction: duration must not be negative, got %v", d)
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	now := s.now()
+	if !s.pause.paused {
+		s.pause.since = now
+	}
+	s.pause.paused = true
+	s.pause.until = time.Time{}
+	if d > 0 {
+		s.pause.until = now.Add(d)
+		logging.Infof("PauseCompaction: compaction paused until %v", s.pause.until.Format(time.RFC3339))
+	} else {
+		logging.Infof("PauseCompaction: compaction paused until resumed")
+	}
+	return nil
+}
+
+// ResumeCompaction lifts a pause set by PauseCompaction; pause windows still apply
+func (s *Store) ResumeCompaction() {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if s.pause.paused {
+		s.pause = compactionPause{windows: s.pause.windows}
+		logging.Infof("ResumeCompaction: compaction resumed")
+	}
+}
+
+// SetCompactionPauseWindows replaces the daily windows during which automatic compaction does not run
+// Returns ErrBadPauseWindow if a window is invalid, in which case the previous windows stay in effect
+func (s *Store) SetCompactionPauseWindows(windows []PauseWindow) error {
+	for _, w := range windows {
+		if err := w.Validate(); err != nil {
+			return fmt.Errorf("SetCompactionPauseWindows: %w", err)
+		}
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.pause.windows = windows
+	return nil
+}
+
+// SetCompactionWindows restricts automatic compaction to daily windows, e.g. 02:00-04:00, so the lock-heavy
+// work runs off-peak; cycles due outside every window are skipped. No windows lets compaction run at any time
+// Pause windows, pauses, and the minimum garbage ratio still apply within a window
+// Returns ErrBadPauseWindow if a window is invalid, in which case the previous windows stay in effect
+func (s *Store) SetCompactionWindows(windows []PauseWindow) error {
+	for _, w := range windows {
+		if err := w.Validate(); err != nil {
+			return fmt.Errorf("SetCompactionWindows: %w", err)
+		}
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.schedule.windows = windows
+	return nil
+}
+
+// SetCompactionMinGarbage skips automatic compaction cycles while less than ratio of the database is garbage,
+// i.e. records of overwritten, deleted, or expired keys (default: 0, every cycle runs)
+// Returns ErrBadGarbageRatio unless 0 <= ratio < 1
+func (s *Store) SetCompactionMinGarbage(ratio float64) error {
+	if ratio < 0 || ratio >= 1 {
+		return fmt.Errorf("SetCompactionMinGarbage: %w, got %v", ErrBadGarbageRatio, ratio)
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.schedule.minGarbage = ratio
+	return nil
+}
+
+// compactionSchedule restricts when automatic compaction runs, protected by the store's statsMu
+type compactionSchedule struct {
+	// windows are the daily windows compaction may run in, none if it may run at any time
+	windows []PauseWindow
+
+	// minGarbage is the garbage ratio below which cycles are skipped
+	minGarbage float64
+}
+
+// compactionPause is the pause state of automatic compaction, protected by the store's statsMu
+type compactionPause struct {
+	// paused indicates a pause set by PauseCompaction
+	paused bool
+
+	// since is when the pause was set
+	since time.Time
+
+	// until is when the pause ends by itself, zero if it lasts until ResumeCompaction
+	until time.Time
+
+	// windows are the daily pause windows
+	windows []PauseWindow
+}
+
+// compactionPaused returns why automatic compaction may not run now, or "" if it may
+func (s *Store) compactionPaused() string {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return s.pauseReason(s.now())
+}
+
+// compactionState returns the compaction statistics including the current pause state
+// Must be called with statsMu held
+func (s *Store) compactionState() CompactionStats {
+	stats := s.compaction
+	stats.PauseReason = s.pauseReason(s.now())
+	stats.Paused = stats.PauseReason != ""
+	if s.pause.paused {
+		stats.PausedSince = s.pause.since
+	}
+	return stats
+}
+
+// pauseReason returns why compaction is paused at now, or "" if it may run
+// A pause that ran out is lifted on the way
+// Must be called with statsMu held
+func (s *Store) pauseReason(now time.Time) string {
+	if s.pause.paused && !s.pause.until.IsZero() && !now.Before(s.pause.until) {
+		s.pause = compactionPause{windows: s.pause.windows}
+		logging.Infof("pauseReason: compaction pause ran out, compaction resumed")
+	}
+
+	if s.maintenance.Enabled {
+		return "in maintenance mode"
+	}
+
+	if s.pause.paused {
+		if s.pause.until.IsZero() {
+			return "paused by operator"
+		}
+		return "paused by operator until " + s.pause.until.Format(time.RFC3339)
+	}
+
+	for _, w := range s.pause.windows {
+		if w.contains(now.Local()) {
+			return "in pause window " + w.String()
+		}
+	}
+
+	if len(s.schedule.windows) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(s.schedule.windows))
+	for _, w := range s.schedule.windows {
+		if w.contains(now.Local()) {
+			return ""
+		}
+		names = append(names, w.String())
+	}
+	return "outside compaction windows " + strings.Join(names, ", ")
+}
+
+// garbageReason returns why a compaction cycle on a database of diskBytes should be skipped for lack of garbage,
+// or "" if it may run
+// Must be called with mu held
+func (s *Store) garbageReason(diskBytes int64) string {
+	s.statsMu.Lock()
+	minGarbage := s.schedule.minGarbage
+	s.statsMu.Unlock()
+
+	if minGarbage == 0 {
+		return ""
+	}
+	if ratio := s.garbageRatio(diskBytes); ratio < minGarbage {
+		return fmt.Sprintf("garbage ratio %.2f below %.2f", ratio, minGarbage)
+	}
+	return ""
+}
+
+// garbageRatio returns the fraction of a database of diskBytes not taken up by the records of live keys
+// Must be called with mu held
+func (s *Store) garbageRatio(diskBytes int64) float64 {
+	if diskBytes <= 0 {
+		return 0
+	}
+
+	var liveBytes int64
+	now := s.now().UnixMilli()
+	for _, entry := range s.index {
+		if live(entry, now) {
+			liveBytes += constants.MetadataSize + entry.Size
+		}
+	}
+	return max(0, 1-float64(liveBytes)/float64(diskBytes))
+}
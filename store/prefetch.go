@@ -0,0 +1,100 @@
+package store
+
+import (
+	"cmp"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"path/filepath"
+	"slices"
+)
+
+// Prefetch hints that the values of keys are about to be read, e.g. by an export or a bulk copy
+// The records are read in the background, grouped by segment file, in offset order, and with nearby records merged
+// into one sequential read, so they are in the operating system's page cache when they are requested
+// Hints are best effort: missing keys are skipped, and the hint is dropped if constants.PrefetchWorkers prefetches
+// are already running
+func (s *Store) Prefetch(keys []string) {
+	entries := make([]models.KVStashIndexEntry, 0, len(keys))
+
+	s.mu.RLock()
+	dbPath := s.dbPath
+	for _, key := range keys {
+		if entry, ok := s.lookup(s.normalization.Key(key)); ok {
+			entries = append(entries, *entry)
+		}
+	}
+	s.mu.RUnlock()
+
+	s.prefetch(dbPath, entries)
+}
+
+// prefetch reads the records of entries, found in the database directory dbPath, in the background, see Prefetch
+func (s *Store) prefetch(dbPath string, entries []models.KVStashIndexEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	select {
+	case s.prefetchSlots <- struct{}{}:
+	default:
+		return
+	}
+
+	go func() {
+		defer func() { <-s.prefetchSlots }()
+		s.readRanges(dbPath, entries)
+	}()
+}
+
+// byteRange is a span [start, end) of a segment file
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// readRanges reads and discards the records of entries, merging records of a segment file that are at most
+// constants.PrefetchGap bytes apart into one read
+// Errors are ignored: the segment may have been replaced by compaction, and the real read reports any problem
+func (s *Store) readRanges(dbPath string, entries []models.KVStashIndexEntry) {
+	bySegment := make(map[string][]byteRange)
+	for i := range entries {
+		entry := &entries[i]
+		bySegment[entry.SegmentFile] = append(bySegment[entry.SegmentFile],
+			byteRange{start: entry.Offset - constants.MetadataSize, end: entry.Offset + entry.Size})
+	}
+
+	buf := make([]byte, constants.PrefetchReadSize)
+	for segment, ranges := range bySegment {
+		// Close may have run in the meantime; reading would put files back into the closed pool
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		slices.SortFunc(ranges, func(a, b byteRange) int {
+			return cmp.Compare(a.start, b.start)
+		})
+
+		handle, err := s.files.acquire(filepath.Join(dbPath, segment))
+		if err != nil {
+			continue
+		}
+
+		for i := 0; i < len(ranges); {
+			span := ranges[i]
+			for i++; i < len(ranges) && ranges[i].start-span.end <= constants.PrefetchGap; i++ {
+				span.end = max(span.end, ranges[i].end)
+			}
+
+			for pos := max(span.start, 0); pos < span.end; pos += int64(len(buf)) {
+				n := min(int64(len(buf)), span.end-pos)
+				if _, err := handle.file.ReadAt(buf[:n], pos); err != nil && err != io.EOF {
+					break
+				}
+			}
+		}
+		s.files.release(handle)
+	}
+}
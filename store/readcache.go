@@ -0,0 +1,215 @@
+package store
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"sync"
+)
+
+/*
+Read cache:
+
+Every Get of a value that is not inline opens its segment file and reads the record. With a read cache budget set,
+the values Get and GetMany read from disk are kept in memory, most recently used first, and the least recently used
+are evicted once the values and their overhead, constants.ReadCacheEntryOverhead bytes each, take more than the
+budget. Values larger than the budget are not cached.
+
+A cached value remembers the index entry it was read for. Entries are replaced, never changed, so a value is only
+served while the index still holds that very entry: a Set, Delete, expiry, rollback, or compaction points the key at
+another entry and the stale value is never returned, even when a read that started before the write caches it after.
+Writes also drop the key from the cache right away so its memory is freed, and compaction empties the cache since it
+replaces every entry.
+
+Like inline values, cached values were verified when read, so reads skip the read verification for them; a read
+that asks for a verification level explicitly still reads the record from disk.
+*/
+
+// ReadCacheStats describes the cache of values read from disk
+type ReadCacheStats struct {
+	// Budget is the memory in bytes the cache may take, 0 if it is disabled
+	Budget int64
+
+	// Values is the number of values cached
+	Values int
+
+	// Bytes is the memory taken by the cached values, overhead included
+	Bytes int64
+
+	// Hits and Misses count the reads served from the cache and the reads of values not in it since the store was
+	// opened; reads of inline values are neither
+	Hits   int64
+	Misses int64
+
+	// Evictions is the number of values evicted to stay within the budget
+	Evictions int64
+}
+
+// readCacheItem is a value in the read cache
+type readCacheItem struct {
+	key string
+
+	// entry is the index entry the value was read for
+	entry *models.KVStashIndexEntry
+
+	value string
+}
+
+// size returns the memory in bytes taken by the item
+func (i *readCacheItem) size() int64 {
+	return int64(len(i.key)+len(i.value)) + constants.ReadCacheEntryOverhead
+}
+
+// readCache is an LRU cache of values by normalized key, within a budget in bytes
+type readCache struct {
+	mu sync.Mutex
+
+	// budget is the memory in bytes the cache may take, 0 if it is disabled
+	budget int64
+
+	// bytes is the memory taken by the cached values
+	bytes int64
+
+	// order holds the items, most recently used first
+	order *list.List
+
+	// items maps the keys to their element of order
+	items map[string]*list.Element
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// newReadCache returns an empty, disabled cache
+func newReadCache() *readCache {
+	return &readCache{order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the cached value of key if it was read for entry, counting the hit or miss
+// Reports false without counting anything if the cache is disabled
+func (c *readCache) get(key string, entry *models.KVStashIndexEntry) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.budget == 0 {
+		return "", false
+	}
+	elem, ok := c.items[key]
+	if !ok || elem.Value.(*readCacheItem).entry != entry {
+		c.misses++
+		return "", false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*readCacheItem).value, true
+}
+
+// put caches value, read for entry, as the value of key, evicting the least recently used values over the budget
+func (c *readCache) put(key string, entry *models.KVStashIndexEntry, value string) {
+	item := &readCacheItem{key: key, entry: entry, value: value}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item.size() > c.budget {
+		return
+	}
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	c.items[key] = c.order.PushFront(item)
+	c.bytes += item.size()
+	c.evict()
+}
+
+// remove drops key from the cache
+func (c *readCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// clear drops every value from the cache
+func (c *readCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.items)
+	c.bytes = 0
+}
+
+// setBudget changes the budget of the cache, evicting the values over it; 0 disables the cache and empties it
+func (c *readCache) setBudget(budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.budget = budget
+	c.evict()
+}
+
+// evict drops the least recently used values until the cache is within its budget
+// Must be called with mu held
+func (c *readCache) evict() {
+	for c.bytes > c.budget {
+		c.removeElement(c.order.Back())
+		c.evictions++
+	}
+}
+
+// removeElement drops the item of elem from the cache
+// Must be called with mu held
+func (c *readCache) removeElement(elem *list.Element) {
+	item := c.order.Remove(elem).(*readCacheItem)
+	delete(c.items, item.key)
+	c.bytes -= item.size()
+}
+
+// stats returns the state and counters of the cache
+func (c *readCache) stats() ReadCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ReadCacheStats{
+		Budget:    c.budget,
+		Values:    c.order.Len(),
+		Bytes:     c.bytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// ReadCacheBytes returns the memory in bytes the cache of values read from disk may take, 0 if it is disabled
+func (s *Store) ReadCacheBytes() int64 {
+	return s.readCache.stats().Budget
+}
+
+// SetReadCacheBytes changes the memory in bytes the cache of values read from disk may take, up to
+// constants.MaxReadCacheBytes; 0 disables the cache and empties it
+// Lowering the budget evicts the least recently used values over it right away
+func (s *Store) SetReadCacheBytes(budget int64) error {
+	if budget < 0 || budget > constants.MaxReadCacheBytes {
+		return fmt.Errorf("SetReadCacheBytes: budget must be between 0 and %d, got %d",
+			int64(constants.MaxReadCacheBytes), budget)
+	}
+
+	old := s.ReadCacheBytes()
+	if old == budget {
+		return nil
+	}
+	s.readCache.setBudget(budget)
+	logging.Infof("SetReadCacheBytes: %d -> %d bytes", old, budget)
+	return nil
+}
+
+// ReadCacheStats returns the budget, size, and counters of the cache of values read from disk
+func (s *Store) ReadCacheStats() ReadCacheStats {
+	return s.readCache.stats()
+}
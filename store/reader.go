@@ -0,0 +1,129 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrChecksumMismatch indicates that the stored data does not match its checksum
+// This suggests data corruption and the entry should be purged from the index
+var ErrChecksumMismatch = codec.ErrChecksumMismatch
+
+// fetchValue reads a value from the log file at the specified offset and size
+// It validates inputs, reads the exact bytes, and deserializes the JSON data
+// Returns the value string or an error if validation or read fails
+// Returns ErrChecksumMismatch if the data checksum doesn't match the stored checksum
+// flags is the flags field of the record, which the checksum covers
+// verify selects how much of the record is checked, see Verification
+// The file is read through files, which keeps it open for later reads (files may be nil)
+// The read and checksum phases are timed by t, which may be nil
+func fetchValue(files *handlePool, dbPath string, fileName string, offset int64, size int64, flags int64, checksum [32]byte, verify Verification, t *opTimer) (string, error) {
+	// Construct full file path
+	filePath := filepath.Join(dbPath, fileName)
+
+	// Open the file for reading, or reuse its open handle
+	readStart := time.Now()
+	handle, err := files.acquire(filePath)
+	if err != nil {
+		return "", fmt.Errorf("fetchValue: failed to open file %s: %w", fileName, err)
+	}
+	defer files.release(handle)
+	file := handle.file
+
+	// Get file size to validate offset
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("fetchValue: failed to stat file: %w", err)
+	}
+
+	value, err := readValue(file, fileInfo.Size(), fileName, offset, size, flags, checksum, verify, t, readStart)
+	if err != nil {
+		return "", fmt.Errorf("fetchValue: %w", err)
+	}
+	return value, nil
+}
+
+// readValue reads and verifies the value at offset and size in fileName, an open file of fileSize bytes
+// It is the part of fetchValue that follows opening the file, for callers reading many values from one file
+// readStart is when the read phase started, so that opening the file counts towards it
+func readValue(file *os.File, fileSize int64, fileName string, offset int64, size int64, flags int64, checksum [32]byte, verify Verification, t *opTimer, readStart time.Time) (string, error) {
+	// Validate inputs
+	if size <= 0 {
+		return "", fmt.Errorf("readValue: size must be positive, got %d", size)
+	}
+
+	if offset < 0 {
+		return "", fmt.Errorf("readValue: offset must be non-negative, got %d", offset)
+	}
+
+	if offset+int64(size) > fileSize {
+		return "", fmt.Errorf("readValue: offset+size (%d+%d=%d) exceeds file size (%d)",
+			offset, size, offset+int64(size), fileSize)
+	}
+
+	// With VerifyMetadata the metadata in front of the value is read along with it
+	start := offset
+	if verify == VerifyMetadata {
+		if offset < constants.MetadataSize {
+			return "", fmt.Errorf("readValue: offset %d leaves no room for metadata", offset)
+		}
+		start -= constants.MetadataSize
+	}
+
+	// Read the exact bytes at offset
+	raw := make([]byte, offset-start+size)
+	n, err := file.ReadAt(raw, start)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("readValue: failed to read at offset %d: %w", start, err)
+	}
+
+	if n != len(raw) {
+		return "", fmt.Errorf("readValue: expected to read %d bytes, got %d", len(raw), n)
+	}
+	buf := raw[offset-start:]
+	t.add(phaseRead, readStart)
+	checksumStart := time.Now()
+
+	_, value, err := codec.DecodePayload(buf)
+	if err != nil {
+		return "", fmt.Errorf("readValue: failed to deserialize data - %w", err)
+	}
+
+	segment, err := codec.SegmentName(fileName)
+	if err != nil {
+		return "", fmt.Errorf("readValue: %w", err)
+	}
+
+	switch verify {
+	case VerifyMetadata:
+		// The metadata must be intact and describe exactly the record the index points to
+		var m codec.Metadata
+		if err := codec.DecodeMetadata(raw, &m); err != nil {
+			return "", fmt.Errorf("readValue: %w", err)
+		}
+		if err := m.ValidateMChecksum(); err != nil ||
+			m.Offset != offset || m.Size != size || m.Flags != flags || m.SegmentFile != segment || m.Checksum != checksum {
+			return "", fmt.Errorf("readValue: %w (metadata at offset %d does not match the index)",
+				ErrChecksumMismatch, start)
+		}
+	case VerifyNone:
+	default:
+		// Validate data integrity by recomputing and comparing checksums
+		if actual := codec.ValueChecksum(offset, size, flags, segment, buf); actual != checksum {
+			return "", fmt.Errorf("readValue: %w (expected %x, got %x)",
+				ErrChecksumMismatch, checksum, actual)
+		}
+	}
+	t.add(phaseChecksum, checksumStart)
+
+	value, err = untransform(value, flags)
+	if err != nil {
+		return "", fmt.Errorf("readValue: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"time"
+)
+
+// ErrMaintenance is returned by writes while the store is in maintenance mode, see SetMaintenance
+var ErrMaintenance = errors.New("server is in maintenance mode, writes are disabled")
+
+// MaintenanceStats describes the maintenance mode of a store
+type MaintenanceStats struct {
+	// Enabled indicates that writes are rejected with ErrMaintenance
+	Enabled bool
+
+	// Since is when maintenance mode was turned on (zero if it is off)
+	Since time.Time
+
+	// Message tells clients why writes are rejected, empty if none was given
+	Message string
+}
+
+// SetMaintenance turns maintenance mode on or off; message tells clients why writes are rejected
+// While it is on, every write fails with ErrMaintenance, reads continue, and automatic compaction does not start,
+// so the segment files stay put during backups, migrations, and format upgrades
+// A compaction cycle that is already running finishes, see CompactionStats.Running
+func (s *Store) SetMaintenance(enabled bool, message string) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	switch {
+	case enabled && !s.maintenance.Enabled:
+		s.maintenance = MaintenanceStats{Enabled: true, Since: time.Now(), Message: message}
+		logging.Infof("SetMaintenance: maintenance mode on, writes are disabled (%v)", message)
+	case enabled:
+		s.maintenance.Message = message
+	case s.maintenance.Enabled:
+		s.maintenance = MaintenanceStats{}
+		logging.Infof("SetMaintenance: maintenance mode off, writes are enabled")
+	}
+}
+
+// Maintenance returns the maintenance mode of the store
+func (s *Store) Maintenance() MaintenanceStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return s.maintenance
+}
+
+// checkMaintenance returns ErrMaintenance with the operator's message if the store is in maintenance mode
+func (s *Store) checkMaintenance() error {
+	m := s.Maintenance()
+	if !m.Enabled {
+		return nil
+	}
+	if m.Message != "" {
+		return fmt.Errorf("%w: %v", ErrMaintenance, m.Message)
+	}
+	return ErrMaintenance
+}
@@ -0,0 +1,431 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+Data directory relocation:
+
+Relocate moves the database to another directory, e.g. on a bigger or faster disk, while the store keeps serving:
+
+1. Copy: the segment files are copied without holding the lock. Archived segments never change and the active log
+   only grows past its last complete record, so every round copies the bytes written since the previous one, until
+   less than constants.RelocationCatchUpBytes are left (or constants.RelocationMaxRounds rounds ran)
+2. Catch up: under the write lock, the last bytes, the seals, and a superblock are written to the new directory
+3. Switch: a RELOCATED file naming the new directory is written to the old one with a rename, so it is either
+   complete or missing. From then on the new directory is the database: Open follows the file. The active log is
+   reopened in the new directory and the lock released
+4. Cleanup: the database files are removed from the old directory; the RELOCATED file stays, so restarts with the
+   old path find the database
+
+Compaction is skipped while a relocation runs, and the switch waits for no one: it fails if snapshots are open, since
+they read the old files. A failed relocation removes its copy; a crash before the switch leaves the old directory in
+charge and a partial copy to remove by hand, a crash after it leaves old files that are no longer read.
+
+The compaction directories move next to the new database (target.tmp and target.bkp), since compaction renames the
+compacted database into place, which only works on the same filesystem.
+*/
+
+// ErrBadRelocation is returned by Relocate for a directory the database cannot be moved to
+var ErrBadRelocation = errors.New("invalid relocation target")
+
+// ErrRelocating is returned by Relocate while another relocation of the store is running
+var ErrRelocating = errors.New("a relocation is already running")
+
+// ErrSnapshotsOpen is returned by Relocate if snapshots still read the old directory when it would switch
+var ErrSnapshotsOpen = errors.New("snapshots are open")
+
+// RelocationReport describes a finished relocation
+type RelocationReport struct {
+	// From and To are the old and the new database directory
+	From string
+	To   string
+
+	// Segments is the number of segment files moved
+	Segments int
+
+	// Bytes is the number of bytes copied
+	Bytes int64
+
+	// Rounds is the number of copy rounds run while writes continued
+	Rounds int
+
+	// Pause is how long writes were blocked for the final catch up and the switch
+	Pause time.Duration
+
+	// Duration is how long the relocation took
+	Duration time.Duration
+}
+
+// relocation is the copy of a database to its new directory
+type relocation struct {
+	// source and target are the absolute paths of the old and the new directory
+	source string
+	target string
+
+	// created indicates that Relocate created target, so it is removed if the relocation fails
+	created bool
+
+	// copied holds the number of bytes of every segment copied so far
+	copied map[string]int64
+
+	report *RelocationReport
+}
+
+// Relocate moves the database to the directory target without stopping the store, see the notes above
+// target must not exist or be an empty directory, and must not be inside the current directory
+// Reads and writes continue while the segment files are copied; writes only wait while the last bytes are copied
+// and the store switches to target. Opening the old directory later opens target
+// Returns ErrBadRelocation for an unusable target, ErrRelocating if a relocation is running, ErrSnapshotsOpen if
+// snapshots were open at the switch, ErrMaintenance in maintenance mode, and ErrDegraded if the breaker tripped
+func (s *Store) Relocate(target string) (*RelocationReport, error) {
+	start := time.Now()
+	if err := s.checkMaintenance(); err != nil {
+		return nil, fmt.Errorf("Relocate: %w", err)
+	}
+	if s.Degraded() {
+		return nil, fmt.Errorf("Relocate: %w", ErrDegraded)
+	}
+
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return nil, fmt.Errorf("Relocate: %w: %w", ErrBadRelocation, err)
+	}
+
+	s.mu.Lock()
+	if s.relocating {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("Relocate: %w", ErrRelocating)
+	}
+	source, err := filepath.Abs(s.dbPath)
+	if err == nil {
+		err = checkRelocationTarget(source, target)
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("Relocate: %w", err)
+	}
+	s.relocating = true
+	s.mu.Unlock()
+
+	r := &relocation{
+		source: source,
+		target: target,
+		copied: make(map[string]int64),
+		report: &RelocationReport{From: source, To: target},
+	}
+	logging.Infof("Relocate: moving the database from %v to %v", source, target)
+
+	if err := r.copyRounds(s); err != nil {
+		s.mu.Lock()
+		s.relocating = false
+		s.mu.Unlock()
+		r.discard()
+		return nil, fmt.Errorf("Relocate: %w", err)
+	}
+
+	s.mu.Lock()
+	paused := time.Now()
+	err = s.switchTo(r)
+	s.relocating = false
+	s.mu.Unlock()
+	if err != nil {
+		r.discard()
+		return nil, fmt.Errorf("Relocate: %w", err)
+	}
+	r.report.Pause = time.Since(paused)
+
+	r.removeSource()
+	r.report.Segments = len(r.copied)
+	r.report.Duration = time.Since(start)
+	logging.Infof("Relocate: moved %d segments (%d bytes) from %v to %v in %v, writes waited %v",
+		r.report.Segments, r.report.Bytes, source, target, r.report.Duration.Round(time.Millisecond),
+		r.report.Pause.Round(time.Millisecond))
+	return r.report, nil
+}
+
+// checkRelocationTarget returns ErrBadRelocation unless the database in source can be moved to target
+func checkRelocationTarget(source string, target string) error {
+	if target == source {
+		return fmt.Errorf("checkRelocationTarget: %w: %v is the current database directory", ErrBadRelocation, target)
+	}
+	if rel, err := filepath.Rel(source, target); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("checkRelocationTarget: %w: %v is inside the current database directory", ErrBadRelocation, target)
+	}
+
+	entries, err := os.ReadDir(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checkRelocationTarget: %w: %w", ErrBadRelocation, err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("checkRelocationTarget: %w: %v is not empty", ErrBadRelocation, target)
+	}
+	return nil
+}
+
+// copyRounds copies the segment files to the target directory while writes continue, until few bytes are left
+func (r *relocation) copyRounds(s *Store) error {
+	if _, err := os.Stat(r.target); errors.Is(err, os.ErrNotExist) {
+		r.created = true
+	}
+	if err := os.MkdirAll(r.target, 0755); err != nil {
+		return fmt.Errorf("copyRounds: failed to create %v: %w", r.target, err)
+	}
+
+	for ; r.report.Rounds < constants.RelocationMaxRounds; r.report.Rounds++ {
+		s.mu.RLock()
+		sizes, err := s.segmentSizes()
+		s.mu.RUnlock()
+		if err != nil {
+			return fmt.Errorf("copyRounds: %w", err)
+		}
+
+		if r.pending(sizes) < constants.RelocationCatchUpBytes {
+			return nil
+		}
+		if err := r.copy(sizes); err != nil {
+			return fmt.Errorf("copyRounds: %w", err)
+		}
+	}
+	return nil
+}
+
+// segmentSizes returns the size of every segment file: its file size if archived, the end of its last complete
+// record if it is the active log
+// Must be called with mu held
+func (s *Store) segmentSizes() (map[string]int64, error) {
+	segments, err := listSegments(s.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("segmentSizes: %w", err)
+	}
+
+	sizes := make(map[string]int64, len(segments))
+	for _, segment := range segments {
+		if segment == s.activeLog && s.writer != nil {
+			sizes[segment] = s.writer.offset
+			continue
+		}
+		info, err := os.Stat(filepath.Join(s.dbPath, segment))
+		if err != nil {
+			return nil, fmt.Errorf("segmentSizes: %w", err)
+		}
+		sizes[segment] = info.Size()
+	}
+	return sizes, nil
+}
+
+// pending returns the number of bytes of sizes not copied yet
+func (r *relocation) pending(sizes map[string]int64) int64 {
+	var total int64
+	for segment, size := range sizes {
+		total += max(size-r.copied[segment], 0)
+	}
+	return total
+}
+
+// copy copies the bytes of every segment up to its size in sizes that were not copied yet
+func (r *relocation) copy(sizes map[string]int64) error {
+	for segment, size := range sizes {
+		from := r.copied[segment]
+		if size < from {
+			return fmt.Errorf("copy: %v shrank from %d to %d bytes while it was copied", segment, from, size)
+		}
+		if size == from {
+			continue
+		}
+
+		if err := copyRange(filepath.Join(r.source, segment), filepath.Join(r.target, segment), from, size); err != nil {
+			return fmt.Errorf("copy: %w", err)
+		}
+		r.copied[segment] = size
+		r.report.Bytes += size - from
+	}
+	return nil
+}
+
+// copyRange copies the bytes [from, to) of the file src to the same place in dst, creating dst if needed
+// dst is synced to disk
+func copyRange(src string, dst string, from int64, to int64) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("copyRange: %w", err)
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("copyRange: %w", err)
+	}
+	defer destination.Close()
+
+	if _, err := destination.Seek(from, io.SeekStart); err != nil {
+		return fmt.Errorf("copyRange: %w", err)
+	}
+	if _, err := io.Copy(destination, io.NewSectionReader(source, from, to-from)); err != nil {
+		return fmt.Errorf("copyRange: %w", err)
+	}
+	if err := destination.Sync(); err != nil {
+		return fmt.Errorf("copyRange: %w", err)
+	}
+	return nil
+}
+
+// switchTo copies the bytes written since the last round and makes the target directory the database
+// An error is only returned before the switch; the old directory is still the database then
+// Must be called with mu held
+func (s *Store) switchTo(r *relocation) error {
+	if s.openSnapshots > 0 {
+		return fmt.Errorf("switchTo: %w: %d snapshots read the old directory", ErrSnapshotsOpen, s.openSnapshots)
+	}
+	if s.Degraded() {
+		return fmt.Errorf("switchTo: %w", ErrDegraded)
+	}
+
+	sizes, err := s.segmentSizes()
+	if err != nil {
+		return fmt.Errorf("switchTo: %w", err)
+	}
+	if err := r.copy(sizes); err != nil {
+		return fmt.Errorf("switchTo: %w", err)
+	}
+	for segment := range sizes {
+		if offset, sealed, err := readSeal(r.source, segment); err != nil {
+			return fmt.Errorf("switchTo: %w", err)
+		} else if sealed {
+			if err := writeSeal(r.target, segment, offset); err != nil {
+				return fmt.Errorf("switchTo: %w", err)
+			}
+		}
+	}
+	if err := s.writeState(r.target, segmentNumber(s.activeLog), s.nextSegment); err != nil {
+		return fmt.Errorf("switchTo: %w", err)
+	}
+
+	if err := writeRelocated(r.source, r.target); err != nil {
+		return fmt.Errorf("switchTo: %w", err)
+	}
+
+	// The target directory is the database from here on, so nothing below fails the relocation
+	if err := s.closeWriter(); err != nil {
+		logging.Errorf("switchTo: failed to close the active log in %v: %v", r.source, err)
+		s.writer = nil
+	}
+
+	s.statsMu.Lock()
+	s.dbPath = r.target
+	s.tmpPath = r.target + ".tmp"
+	s.backupPath = r.target + ".bkp"
+	s.disk.CheckedAt = time.Time{}
+	s.statsMu.Unlock()
+	s.files.closeAll()
+	s.dropSegmentFilters()
+
+	writer, err := s.openWriter(s.activeLog, s.durability)
+	if err != nil {
+		s.degrade(fmt.Errorf("failed to reopen the active log in %v: %w", r.target, err))
+		return nil
+	}
+	s.writer = writer
+	return nil
+}
+
+// discard removes the copy of a relocation that failed before the switch
+func (r *relocation) discard() {
+	removeDatabaseFiles(r.target)
+	if r.created {
+		if err := os.Remove(r.target); err != nil {
+			logging.Errorf("discard: failed to remove %v: %v", r.target, err)
+		}
+	}
+}
+
+// removeSource removes the database files left in the old directory after the switch
+func (r *relocation) removeSource() {
+	removeDatabaseFiles(r.source)
+}
+
+// removeDatabaseFiles removes the segment files, seals, and superblock in dir, logging failures
+func removeDatabaseFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logging.Errorf("removeDatabaseFiles: %v", err)
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !isDatabaseFile(e.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			logging.Errorf("removeDatabaseFiles: %v", err)
+		}
+	}
+}
+
+// writeRelocated records in the directory source that its database moved to target, atomically
+func writeRelocated(source string, target string) error {
+	path := filepath.Join(source, constants.RelocatedName)
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, []byte(target+"\n"), 0644); err != nil {
+		return fmt.Errorf("writeRelocated: %w", err)
+	}
+	file, err := os.Open(tmp)
+	if err == nil {
+		err = file.Sync()
+		file.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writeRelocated: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writeRelocated: %w", err)
+	}
+
+	dir, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("writeRelocated: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("writeRelocated: %w", err)
+	}
+	return nil
+}
+
+// resolveRelocation follows the RELOCATED files from dbPath to the directory its database was moved to
+// Returns dbPath itself if it was never relocated
+func resolveRelocation(dbPath string) (string, error) {
+	for range constants.MaxRelocationHops {
+		data, err := os.ReadFile(filepath.Join(dbPath, constants.RelocatedName))
+		if errors.Is(err, os.ErrNotExist) {
+			return dbPath, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("resolveRelocation: %w", err)
+		}
+
+		target := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(target) {
+			return "", fmt.Errorf("resolveRelocation: invalid %v in %v: %q", constants.RelocatedName, dbPath, data)
+		}
+		logging.Infof("resolveRelocation: the database in %v was moved to %v", dbPath, target)
+		dbPath = target
+	}
+	return "", fmt.Errorf("resolveRelocation: more than %d relocations from %v, is there a loop?", constants.MaxRelocationHops, dbPath)
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+)
+
+/*
+Renames:
+
+Rename moves a value to another key as one batch, see CheckAndSet: the old key is tombstoned and the value is written
+under the new key by the commit record, so after a crash the value is under exactly one of the keys. The value keeps
+its type and expiry time and gets a new revision, like any write; the changefeed sees a delete of the old key and a
+set of the new one. Tombstoning first frees the old key's place in its namespace, so moving a key within a full
+namespace evicts nothing.
+*/
+
+// ErrKeyExists is returned by Rename when the new key holds a value and may not be replaced
+var ErrKeyExists = errors.New("key already exists")
+
+// Rename moves the value of the key from to the key to, keeping its type and expiry time; to is replaced if it
+// exists and replace is set
+// Returns the version of the value under to
+// Returns ErrKeyNotFound if from does not exist, ErrKeyExists if to exists and replace is not set, and the
+// validation errors of Set for to (client errors)
+// Returns other errors for server-side failures, in which case nothing was written
+func (s *Store) Rename(from string, to string, replace bool) (*models.KVStashVersion, error) {
+	t := s.startOp(OpRename)
+	defer t.finish(nil)
+
+	from, to = s.normalization.Key(from), s.normalization.Key(to)
+	if err := validateKey(from); err != nil {
+		return nil, err
+	}
+	if err := validateKey(to); err != nil {
+		return nil, err
+	}
+
+	t.lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.lookup(from)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if _, exists := s.lookup(to); exists && !replace {
+		return nil, fmt.Errorf("Rename: %w: %v", ErrKeyExists, redact.Key(to))
+	}
+	if from == to {
+		return s.version(to), nil
+	}
+
+	value, err := s.renamedValue(from, entry, t)
+	if err != nil {
+		return nil, fmt.Errorf("Rename: %w", err)
+	}
+	if err := s.validateValueFor(to, value); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkWritable(); err != nil {
+		return nil, fmt.Errorf("Rename: %w", err)
+	}
+	if err := s.logRotation(); err != nil {
+		s.recordWrite(err)
+		return nil, fmt.Errorf("Rename: failed to rotate log: %w", err)
+	}
+
+	b := s.beginBatch()
+	defer func() { s.batch = nil }()
+
+	if err := s.tombstone(from, models.EventDelete, "", t); err != nil {
+		s.rollback(b, err)
+		return nil, fmt.Errorf("Rename: %w", err)
+	}
+	b.committing = true
+	if err := s.put(to, value, entry.Type, entry.ExpiresAt, t); err != nil {
+		s.rollback(b, err)
+		return nil, fmt.Errorf("Rename: %w", err)
+	}
+	s.committed(b)
+
+	logging.Debugf("Rename: moved key=%v to key=%v", redact.Key(from), redact.Key(to))
+	return s.version(to), nil
+}
+
+// renamedValue returns the value of entry, the index entry of key, verified in full
+// Must be called with mu held
+func (s *Store) renamedValue(key string, entry *models.KVStashIndexEntry, t *opTimer) (string, error) {
+	if entry.Inline != nil {
+		return *entry.Inline, nil
+	}
+
+	value, err := fetchValue(s.files, s.dbPath, entry.SegmentFile, entry.Offset, entry.Size, entryFlags(entry), entry.Checksum, VerifyFull, t)
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			s.raiseAlert(models.AlertCorruption, models.SeverityCritical,
+				"checksum mismatch reading key=%v from %v", redact.Key(key), entry.SegmentFile)
+			s.notifyCorruption(key, entry.SegmentFile, err)
+		}
+		return "", fmt.Errorf("renamedValue: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"os"
+	"path/filepath"
+)
+
+/*
+Startup repair:
+
+A corrupt record in the active log is what a crash mid-write leaves, so the active log is loaded up to it. A corrupt
+sealed segment is not expected, and by default Open fails on it, leaving the files untouched for an operator. The
+startup repair policy lets the store start anyway:
+
+  - StartupRepairSkip moves the segment to a directory named after the time of the repair in the quarantine
+    directory and builds the index again from the other segments. The records of the segment, even the readable
+    ones, are not loaded; the quarantined file can be salvaged later.
+  - StartupRepairSalvage salvages the whole database into the quarantine directory, moves the original database
+    next to it, and moves the salvaged one into its place. A crash between the two moves leaves the database
+    directory missing; finishSalvage completes the swap on the next Open.
+
+Each repair raises an AlertCorruption and is reported by Repairs and the health check until the store is reopened.
+*/
+
+// salvagedName is the directory in the quarantine directory the startup repair salvages the database into
+const salvagedName = "salvaged"
+
+// Repair describes a corrupt sealed segment repaired by Open, see StartupRepair
+type Repair struct {
+	// Segment is the corrupt segment
+	Segment string
+
+	// Policy is how the segment was repaired, StartupRepairSkip or StartupRepairSalvage
+	Policy StartupRepair
+
+	// Error is the corruption found in the segment
+	Error string
+
+	// QuarantinedTo is where the segment, or with StartupRepairSalvage the original database, was moved
+	QuarantinedTo string
+
+	// Salvage is the result of the salvage, nil with StartupRepairSkip
+	Salvage *SalvageReport
+}
+
+// Repairs returns the corrupt sealed segments repaired when the store was opened, see Options.StartupRepair
+func (s *Store) Repairs() []Repair {
+	return s.repairs
+}
+
+// repair moves segment, a sealed segment buildIndex found corrupt with cause, out of the way as the startup repair
+// policy says and builds the index again
+// Returns an error wrapping cause if the segment cannot be moved, in which case the index is left empty
+func (s *Store) repair(segment string, cause error) error {
+	// The salvaged database is made of records that were read back, so it is not salvaged again
+	if len(s.repairs) > 0 && s.startupRepair == StartupRepairSalvage {
+		return fmt.Errorf("repair: the salvaged database is corrupted: %w", cause)
+	}
+
+	logging.Warnf("repair: %v, repairing it with policy %v", cause, s.startupRepair)
+	quarantine := filepath.Join(s.quarantinePath, s.now().UTC().Format("20060102T150405Z"))
+	r := Repair{Segment: segment, Policy: s.startupRepair, Error: cause.Error()}
+
+	switch s.startupRepair {
+	case StartupRepairSkip:
+		r.QuarantinedTo = filepath.Join(quarantine, segment)
+		if err := os.MkdirAll(quarantine, 0755); err != nil {
+			return fmt.Errorf("repair: failed to create %v: %w (%w)", quarantine, err, cause)
+		}
+		if _, err := os.Stat(r.QuarantinedTo); err == nil {
+			return fmt.Errorf("repair: %v already exists: %w", r.QuarantinedTo, cause)
+		}
+		if err := os.Rename(filepath.Join(s.dbPath, segment), r.QuarantinedTo); err != nil {
+			return fmt.Errorf("repair: failed to quarantine %v: %w (%w)", segment, err, cause)
+		}
+		s.raiseAlert(models.AlertCorruption, models.SeverityCritical,
+			"segment %v is corrupt and was moved to %v, the database was loaded without it: %v",
+			segment, r.QuarantinedTo, cause)
+	case StartupRepairSalvage:
+		r.QuarantinedTo = quarantine
+		report, err := s.salvageDatabase(quarantine)
+		if err != nil {
+			return fmt.Errorf("repair: %w (%w)", err, cause)
+		}
+		r.Salvage = report
+		s.raiseAlert(models.AlertCorruption, models.SeverityCritical,
+			"segment %v is corrupt, the database was salvaged with %d keys and %d bytes lost, the original was moved to %v: %v",
+			segment, report.LiveKeys, report.SkippedBytes, quarantine, cause)
+	default:
+		return cause
+	}
+	s.notifyCorruption("", segment, cause)
+	s.repairs = append(s.repairs, r)
+
+	// Built from scratch, so records written before revisions are numbered as on a startup without the segment
+	s.index = make(models.KVStashIndex)
+	s.revision = 0
+	s.activeLogCount = 0
+	s.renormalized = 0
+	return s.buildIndex(nil)
+}
+
+// salvageDatabase salvages the database into the quarantine directory and swaps it in, moving the original to
+// quarantine
+// Returns an error if the database could not be salvaged, in which case it is left in place
+func (s *Store) salvageDatabase(quarantine string) (*SalvageReport, error) {
+	salvaged := filepath.Join(s.quarantinePath, salvagedName)
+
+	// Left over by a salvage that crashed before the swap
+	if err := os.RemoveAll(salvaged); err != nil {
+		return nil, fmt.Errorf("salvageDatabase: failed to delete %v: %w", salvaged, err)
+	}
+	if err := os.MkdirAll(s.quarantinePath, 0755); err != nil {
+		return nil, fmt.Errorf("salvageDatabase: failed to create %v: %w", s.quarantinePath, err)
+	}
+
+	report, err := Salvage(s.dbPath, salvaged)
+	if err != nil {
+		os.RemoveAll(salvaged)
+		return nil, fmt.Errorf("salvageDatabase: %w", err)
+	}
+
+	if err := os.Rename(s.dbPath, quarantine); err != nil {
+		os.RemoveAll(salvaged)
+		return nil, fmt.Errorf("salvageDatabase: failed to quarantine %v: %w", s.dbPath, err)
+	}
+	if err := os.Rename(salvaged, s.dbPath); err != nil {
+		if restoreErr := os.Rename(quarantine, s.dbPath); restoreErr != nil {
+			logging.Errorf("salvageDatabase: failed to move %v back to %v: %v", quarantine, s.dbPath, restoreErr)
+		}
+		return nil, fmt.Errorf("salvageDatabase: failed to move the salvaged database to %v: %w", s.dbPath, err)
+	}
+
+	logging.Infof("salvageDatabase: salvaged %d records and %d keys, skipped %d bytes, the original database is in %v",
+		report.Records, report.LiveKeys, report.SkippedBytes, quarantine)
+	return report, nil
+}
+
+// finishSalvage moves the salvaged database into place if the database directory is missing
+// This handles a crash during the startup repair after the corrupt database was quarantined but before the salvaged
+// one was moved in
+func (s *Store) finishSalvage() error {
+	if _, err := os.Stat(s.dbPath); !os.IsNotExist(err) {
+		return nil
+	}
+
+	salvaged := filepath.Join(s.quarantinePath, salvagedName)
+	if _, err := os.Stat(salvaged); err != nil {
+		return nil
+	}
+
+	logging.Warnf("finishSalvage: database missing but a salvaged database exists, moving it to %v", s.dbPath)
+	if err := os.Rename(salvaged, s.dbPath); err != nil {
+		return fmt.Errorf("finishSalvage: failed to move %v to %v: %w", salvaged, s.dbPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Segment retention:
+
+Append-mostly data keyed by time, such as events or metrics, is usually kept for a fixed time rather than per key.
+With a retention set, every constants.RetentionCheckInterval seconds the store deletes the segments sealed longer
+ago than the retention, oldest first, and removes the keys whose current record is in them from the index; live
+keys are announced to watchers as expired. Dropping a segment costs a file deletion, where compaction would copy
+every live record.
+
+A segment's age counts from log rotation, or from its modification time for the segments found when the store is
+opened. Segments are dropped in order and the first one that is too young stops the check, so a key never comes
+back from an older record after a restart. The active log is never dropped.
+
+Compaction rewrites the live records into new segments, which restarts their age, so automatic compaction cycles
+are skipped while a retention is set; Compact still runs. Segments are kept while snapshots are open or the database
+is being relocated.
+*/
+
+// retentionCounters counts what segment retention dropped since the store was opened
+type retentionCounters struct {
+	segments atomic.Int64
+	keys     atomic.Int64
+}
+
+// RetentionStats describes the segments dropped by age
+type RetentionStats struct {
+	// Retention is the age after which segments are dropped, 0 if they are kept
+	Retention time.Duration
+
+	// SegmentsDropped and KeysDropped count the segments and index entries dropped since the store was opened
+	SegmentsDropped int64
+	KeysDropped     int64
+
+	// OldestSegment is when the oldest segment other than the active log was sealed, zero if there is none
+	OldestSegment time.Time
+}
+
+// Retention returns the age after which segments are dropped, 0 if they are kept
+func (s *Store) Retention() time.Duration {
+	return time.Duration(s.retention.Load())
+}
+
+// SetRetention changes the age after which segments are dropped with their keys; 0 keeps every segment
+// The new retention applies from the next check
+func (s *Store) SetRetention(retention time.Duration) error {
+	if retention < 0 {
+		return fmt.Errorf("SetRetention: retention must not be negative, got %v", retention)
+	}
+
+	if old := time.Duration(s.retention.Swap(int64(retention))); old != retention {
+		logging.Infof("SetRetention: %v -> %v", old, retention)
+	}
+	return nil
+}
+
+// retentionStats returns the retention and what it dropped
+// Must be called with mu held
+func (s *Store) retentionStats() RetentionStats {
+	stats := RetentionStats{
+		Retention:       s.Retention(),
+		SegmentsDropped: s.retained.segments.Load(),
+		KeysDropped:     s.retained.keys.Load(),
+	}
+	for _, sealed := range s.sealed {
+		if stats.OldestSegment.IsZero() || sealed.Before(stats.OldestSegment) {
+			stats.OldestSegment = sealed
+		}
+	}
+	return stats
+}
+
+// loadSegmentAges takes the age of the segments other than the active log from their modification time
+// Must be called before the store is shared
+func (s *Store) loadSegmentAges() {
+	segments, err := listSegments(s.dbPath)
+	if err != nil {
+		logging.Errorf("loadSegmentAges: %v", err)
+		return
+	}
+
+	for _, segment := range segments {
+		if segment == s.activeLog {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(s.dbPath, segment))
+		if err != nil {
+			logging.Errorf("loadSegmentAges: %v", err)
+			continue
+		}
+		s.sealed[segment] = info.ModTime()
+	}
+}
+
+// retainSegments drops the segments older than the retention every constants.RetentionCheckInterval seconds
+// until the store is closed
+func (s *Store) retainSegments() {
+	for s.sleep(JobRetention, constants.RetentionCheckInterval*time.Second) {
+		if s.Retention() > 0 {
+			s.applyRetention()
+		}
+	}
+}
+
+// applyRetention drops the segments sealed longer ago than the retention, oldest first, see dropSegment
+func (s *Store) applyRetention() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Snapshots read the segment files, and a relocation copies them
+	if s.openSnapshots > 0 || s.relocating {
+		logging.Debugf("applyRetention: skipping check, snapshots are open or a relocation is in progress")
+		return
+	}
+
+	segments := make([]string, 0, len(s.sealed))
+	for segment := range s.sealed {
+		segments = append(segments, segment)
+	}
+	slices.SortFunc(segments, func(a, b string) int {
+		return segmentNumber(a) - segmentNumber(b)
+	})
+
+	cutoff := s.now().Add(-s.Retention())
+	for _, segment := range segments {
+		if s.sealed[segment].After(cutoff) {
+			return
+		}
+		if err := s.dropSegment(segment); err != nil {
+			logging.Errorf("applyRetention: %v", err)
+			return
+		}
+	}
+}
+
+// dropSegment archives the tombstones of segment, see DeletionHistory, deletes it, and removes the index entries
+// pointing into it, announcing the live keys as expired
+// Must be called with mu held
+func (s *Store) dropSegment(segment string) error {
+	if err := s.archiveTombstones([]string{segment}); err != nil {
+		return fmt.Errorf("dropSegment: %w", err)
+	}
+
+	path := filepath.Join(s.dbPath, segment)
+	s.files.drop(path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("dropSegment: %w", err)
+	}
+	if err := os.Remove(path + constants.SealExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Errorf("dropSegment: %v", err)
+	}
+	if err := os.Remove(path + constants.FilterExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Errorf("dropSegment: %v", err)
+	}
+	delete(s.filters, segment)
+
+	now := s.now().UnixMilli()
+	keys := 0
+	for key, entry := range s.index {
+		if entry.SegmentFile != segment {
+			continue
+		}
+		delete(s.index, key)
+		s.keys.remove(key)
+		s.readCache.remove(key)
+		if live(entry, now) {
+			s.forget(key)
+			s.feed.publish(models.EventExpire, key, entry.Revision)
+			logging.Debugf("dropSegment: key=%v dropped with %v", redact.Key(key), segment)
+		}
+		keys++
+	}
+
+	logging.Infof("dropSegment: dropped %v, sealed %v, and %d index entries", segment, s.sealed[segment].Format(time.RFC3339), keys)
+	delete(s.sealed, segment)
+	s.segmentCount--
+	s.retained.segments.Add(1)
+	s.retained.keys.Add(int64(keys))
+	return nil
+}
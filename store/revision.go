@@ -0,0 +1,46 @@
+package store
+
+import (
+	"fmt"
+)
+
+/*
+Revisions:
+
+Every write, delete included, is assigned a revision from one counter for the whole store, like etcd's revisions.
+Writes take mu, so writes to the same key are serialized and a later write always gets a higher revision; the
+revision is returned to clients in the version of the write and can be checked by conditional batches, so a
+read-modify-write can tell that the key was rewritten even with the same value. A single key's revisions grow but
+skip the numbers assigned to other keys.
+
+The revision is stored in every record, so the index gets it back at startup, and copies of a record made by
+compaction, Compact, and Salvage keep it. The superblock records the counter as well, so revisions are not reused
+once the records holding the highest ones were compacted away. Records written before revisions are numbered in the
+order they are read at startup, before any revision stored on disk is taken into account, which numbers them the same
+on every startup until compaction stores their revisions.
+*/
+
+// nextRevision returns the revision of a write: revision if it is not 0, a copy keeping the revision of a record,
+// or the next one
+// Must be called with mu held (or before the store is shared)
+func (s *Store) nextRevision(revision uint64) uint64 {
+	if revision == 0 {
+		s.revision++
+		return s.revision
+	}
+	s.revision = max(s.revision, revision)
+	return revision
+}
+
+// raiseRevision makes sure the revisions assigned from now on are above revision and records it in the superblock
+// Used after copying records, so the copy does not reuse the revisions of records that were not copied
+func (s *Store) raiseRevision(revision uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revision = max(s.revision, revision)
+	if err := s.saveState(); err != nil {
+		return fmt.Errorf("raiseRevision: %w", err)
+	}
+	return nil
+}
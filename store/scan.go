@@ -0,0 +1,189 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// errTruncatedRecord indicates that a record ends past the end of the segment file
+// This is the signature of a torn write at the tail of the active log
+var errTruncatedRecord = codec.ErrTruncated
+
+// record is a single decoded entry read from a segment file
+type record struct {
+	// start is the byte position of the record's metadata in the segment file
+	start int64
+
+	// metadata is the decoded and checksum-validated metadata
+	metadata models.KVStashMetadata
+
+	// data is the decoded key/value payload, with the value as stored, see value
+	data models.KVStashRequest
+
+	// expiresAt is when the key expires in Unix milliseconds, 0 if it never expires
+	expiresAt int64
+
+	// revision is the revision of the write, 0 for records written before revisions
+	revision uint64
+
+	// raw holds the undecoded payload bytes as stored on disk
+	raw []byte
+}
+
+// newRecord decodes the payload of a record read by the codec
+func newRecord(crec *codec.Record) (*record, error) {
+	rec := &record{start: crec.Start, metadata: crec.Metadata, raw: crec.Payload}
+
+	key, value, expiresAt, revision, err := codec.DecodeRevisedPayload(crec.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("newRecord: failed to deserialize value: %w", err)
+	}
+	rec.data = models.KVStashRequest{Key: key, Value: value}
+	rec.expiresAt = expiresAt
+	rec.revision = revision
+
+	return rec, nil
+}
+
+// end returns the byte position just past the record, i.e. where the next record starts
+func (rec *record) end() int64 {
+	return rec.metadata.Offset + rec.metadata.Size
+}
+
+// deleted reports whether the record is a tombstone
+func (rec *record) deleted() bool {
+	return rec.metadata.GetMetadataFlagValue(constants.FlagDeleted)
+}
+
+// expiry reports whether the record changes the expiry time of its key instead of holding a value, see Expire
+func (rec *record) expiry() bool {
+	return rec.metadata.GetMetadataFlagValue(constants.FlagExpiry)
+}
+
+// valueType returns the kind of value held by the record
+func (rec *record) valueType() models.KVStashValueType {
+	return valueTypeOf(&rec.metadata)
+}
+
+// value returns the record's value, undoing the value transformers it was written with
+func (rec *record) value() (string, error) {
+	value, err := untransform(rec.data.Value, rec.metadata.Flags)
+	if err != nil {
+		return "", fmt.Errorf("value: %w at offset %d", err, rec.start)
+	}
+	return value, nil
+}
+
+// flags returns the flag bit indexes set on the record
+func (rec *record) flags() []int64 {
+	var flags []int64
+	for flag := int64(0); flag < 64; flag++ {
+		if rec.metadata.GetMetadataFlagValue(flag) {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// validateChecksum recomputes the value checksum of the record and compares it with the stored one
+// segment is the name of the file the record was read from, which is part of the checksum
+func (rec *record) validateChecksum(segment string) error {
+	if err := rec.metadata.ValidateChecksum(segment, rec.raw); err != nil {
+		return fmt.Errorf("validateChecksum: %w at offset %d", ErrChecksumMismatch, rec.start)
+	}
+
+	return nil
+}
+
+// readRecord reads and validates the metadata checksum of the record starting at pos
+// The value checksum is not verified; use validateChecksum for that
+// Returns io.EOF if pos is exactly the end of the file
+// Returns an error wrapping errTruncatedRecord if the record extends past fileSize
+func readRecord(r io.ReaderAt, fileSize int64, pos int64) (*record, error) {
+	crec, err := codec.ReadRecord(r, fileSize, pos)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("readRecord: %w", err)
+	}
+
+	rec, err := newRecord(crec)
+	if err != nil {
+		return nil, fmt.Errorf("readRecord: %w", err)
+	}
+
+	return rec, nil
+}
+
+// readTrustedRecord reads the record starting at pos like readRecord, without validating the metadata checksum
+func readTrustedRecord(r io.ReaderAt, fileSize int64, pos int64) (*record, error) {
+	crec, err := codec.ReadTrustedRecord(r, fileSize, pos)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("readTrustedRecord: %w", err)
+	}
+
+	rec, err := newRecord(crec)
+	if err != nil {
+		return nil, fmt.Errorf("readTrustedRecord: %w", err)
+	}
+
+	return rec, nil
+}
+
+// resync searches forward from pos for the next position holding a valid record
+// It is used to skip over corrupted regions when salvaging data
+// Returns -1 if no valid record exists after pos
+func resync(r io.ReaderAt, fileSize int64, pos int64) int64 {
+	for p := pos + 1; p+constants.MetadataSize <= fileSize; p++ {
+		if _, err := readRecord(r, fileSize, p); err == nil {
+			return p
+		}
+	}
+
+	return -1
+}
+
+// listSegments returns the segment files in dbPath ordered by their numeric suffix
+func listSegments(dbPath string) ([]string, error) {
+	entries, err := os.ReadDir(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("listSegments: failed to read directory %v: %w", dbPath, err)
+	}
+
+	segments := []segmentFile{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !segmentFilePattern.MatchString(name) {
+			continue
+		}
+
+		numStr := name[len(constants.SegmentNamePrefix) : len(name)-len(constants.SegmentNameExt)]
+		num, err := strconv.ParseUint(numStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("listSegments: invalid segment number: %w", err)
+		}
+
+		segments = append(segments, segmentFile{name, int(num)})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].num < segments[j].num
+	})
+
+	names := make([]string, 0, len(segments))
+	for i := range segments {
+		names = append(names, segments[i].name)
+	}
+
+	return names, nil
+}
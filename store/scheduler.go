@@ -0,0 +1,172 @@
+package store
+
+import (
+	"github.com/vi88i/kvstash/logging"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+Scheduler:
+
+The store's background jobs, automatic compaction, expiry notifications, segment retention, index checks, index
+snapshots, and segment filters, wait for their next run on the store's Clock through sleep, so tests drive them by
+advancing a manual clock instead of sleeping.
+PauseScheduler holds every job before its next run until ResumeScheduler, e.g. to keep the database files still
+while an operator inspects them; a run in progress finishes. Runs due while paused happen once, right after the
+resume. Operations started explicitly, such as Compact, are not held.
+*/
+
+// Background jobs, see JobStats
+const (
+	// JobCompaction is the automatic compaction cycle, see Options.AutoCompact
+	JobCompaction = "compaction"
+
+	// JobExpiry announces the keys that expired to watchers and drops them from the expiry queue
+	JobExpiry = "expiry"
+
+	// JobRetention drops the segments older than the retention, see SetRetention
+	JobRetention = "retention"
+
+	// JobIndexCheck compares a sample of the index with the segments, see SetIndexCheck
+	JobIndexCheck = "index-check"
+
+	// JobIndexSnapshot writes a snapshot of the index, see Options.IndexSnapshotInterval
+	JobIndexSnapshot = "index-snapshot"
+
+	// JobSegmentFilters builds the Bloom filters of the sealed segments that have none
+	JobSegmentFilters = "segment-filters"
+)
+
+// JobStats describes a background job
+type JobStats struct {
+	// Name identifies the job, e.g. JobCompaction
+	Name string
+
+	// Runs is the number of times the job ran
+	Runs int64
+
+	// LastRun is when the job last ran, on the store's clock, zero if it never ran
+	LastRun time.Time
+
+	// NextRun is when the job runs next, on the store's clock, unless the scheduler is paused
+	NextRun time.Time
+}
+
+// SchedulerStats describes the background jobs of a store
+type SchedulerStats struct {
+	// Paused indicates that PauseScheduler holds the jobs
+	Paused bool
+
+	// PausedSince is when the scheduler was paused, zero unless paused
+	PausedSince time.Time
+
+	// Jobs describes every job started, by name
+	Jobs []JobStats
+}
+
+// scheduler holds the pause and the job statistics of a store, protected by statsMu
+type scheduler struct {
+	// paused indicates that the jobs are held, see PauseScheduler
+	paused bool
+
+	// pausedSince is when the scheduler was paused
+	pausedSince time.Time
+
+	// resumed is closed by ResumeScheduler, nil unless paused
+	resumed chan struct{}
+
+	// jobs maps job names to their statistics, created on first use
+	jobs map[string]*JobStats
+}
+
+// job returns the statistics of the job name, creating them if needed
+// Must be called with statsMu held
+func (sc *scheduler) job(name string) *JobStats {
+	if sc.jobs == nil {
+		sc.jobs = make(map[string]*JobStats)
+	}
+	job := sc.jobs[name]
+	if job == nil {
+		job = &JobStats{Name: name}
+		sc.jobs[name] = job
+	}
+	return job
+}
+
+// sleep waits d on the store's clock before the next run of job, and then for as long as the scheduler is paused
+// Returns false if the store was closed meanwhile
+func (s *Store) sleep(job string, d time.Duration) bool {
+	s.statsMu.Lock()
+	s.scheduler.job(job).NextRun = s.now().Add(d)
+	s.statsMu.Unlock()
+
+	select {
+	case <-s.stop:
+		return false
+	case <-s.clock.After(d):
+	}
+
+	for {
+		s.statsMu.Lock()
+		resumed := s.scheduler.resumed
+		if !s.scheduler.paused {
+			stats := s.scheduler.job(job)
+			stats.Runs++
+			stats.LastRun = s.now()
+			s.statsMu.Unlock()
+			return true
+		}
+		s.statsMu.Unlock()
+
+		select {
+		case <-s.stop:
+			return false
+		case <-resumed:
+		}
+	}
+}
+
+// PauseScheduler holds every background job before its next run until ResumeScheduler
+// Runs in progress finish; pausing a paused scheduler does nothing
+func (s *Store) PauseScheduler() {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if s.scheduler.paused {
+		return
+	}
+	s.scheduler.paused = true
+	s.scheduler.pausedSince = s.now()
+	s.scheduler.resumed = make(chan struct{})
+	logging.Infof("PauseScheduler: background jobs paused")
+}
+
+// ResumeScheduler lets the background jobs held by PauseScheduler run; the runs that came due meanwhile start now
+func (s *Store) ResumeScheduler() {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if !s.scheduler.paused {
+		return
+	}
+	close(s.scheduler.resumed)
+	s.scheduler = scheduler{jobs: s.scheduler.jobs}
+	logging.Infof("ResumeScheduler: background jobs resumed")
+}
+
+// SchedulerStats returns the pause and the statistics of every background job, ordered by name
+func (s *Store) SchedulerStats() SchedulerStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	stats := SchedulerStats{Paused: s.scheduler.paused, PausedSince: s.scheduler.pausedSince}
+	for _, job := range s.scheduler.jobs {
+		stats.Jobs = append(stats.Jobs, *job)
+	}
+	slices.SortFunc(stats.Jobs, func(a, b JobStats) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return stats
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+/*
+Segment filters:
+
+The index holds every key, so Get never reads a segment for a key that does not exist. The operations that look for
+the older records of a key, Undelete and DeletionHistory, scan whole segments instead, and a key is usually in few of
+them. Every sealed segment gets a Bloom filter of the keys of its records, normalized, in segN.log.bloom next to it;
+the scans skip the segments whose filter rules the key out, so looking for a key costs about one filter read per
+segment that does not hold it.
+
+Sealed segments do not change, so a filter is built once, by JobSegmentFilters, which looks for sealed segments without
+a valid filter when the store opens and then every constants.FilterBuildInterval seconds; the active log has none and
+is always scanned. A filter records the size and last bytes of its segment like the index snapshot, and the key
+normalization, so a filter left by another segment of the same name, e.g. after compaction rewrote the segments, or
+built with other normalization modes is not used, and is built again. Filters are written to a temporary file and
+renamed into place, and removed with their segment by retention.
+
+The filters are checked once, when they are built or loaded, and kept in memory by segment, so a scan skips a segment
+without touching the disk. Compaction and relocation replace the segments and drop every filter kept, and the job
+loads or builds them again; a filter built from a segment that was replaced meanwhile is not kept.
+
+File layout, big endian: magic (4) | version (4) | segment size (8) | SHA-256 of the segment's last bytes (32) |
+normalization length (2) | normalization | hashes (4) | bits (8) | bit array | SHA-256 of the preceding bytes (32)
+*/
+
+// filterFilePattern matches the Bloom filter files of segments
+var filterFilePattern = regexp.MustCompile(`^seg(\d+)\.log\` + constants.FilterExt + `$`)
+
+// errBadFilter is returned for a segment filter that is truncated, fails its checksum, or is not a filter
+var errBadFilter = errors.New("invalid segment filter")
+
+// bloomFilter is a Bloom filter of keys
+type bloomFilter struct {
+	// bits is the bit array, of a multiple of 8 bits
+	bits []byte
+
+	// hashes is the number of bits set for a key
+	hashes uint32
+}
+
+// newBloomFilter returns an empty filter sized for keys keys, see constants.FilterBitsPerKey
+func newBloomFilter(keys int) *bloomFilter {
+	size := max(keys*constants.FilterBitsPerKey, 64)
+	return &bloomFilter{bits: make([]byte, (size+7)/8), hashes: constants.FilterHashes}
+}
+
+// positions calls fn with the bit positions of key, from two halves of its 64-bit FNV-1a hash
+func (f *bloomFilter) positions(key string, fn func(bit uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(f.bits)) * 8
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		if !fn((h1 + i*h2) % m) {
+			return
+		}
+	}
+}
+
+// add adds key to the filter
+func (f *bloomFilter) add(key string) {
+	f.positions(key, func(bit uint64) bool {
+		f.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// mayContain reports whether key may have been added; false means it certainly was not
+func (f *bloomFilter) mayContain(key string) bool {
+	found := true
+	f.positions(key, func(bit uint64) bool {
+		found = f.bits[bit/8]&(1<<(bit%8)) != 0
+		return found
+	})
+	return found
+}
+
+// skipSegment reports whether the Bloom filter of segment rules key out, counting the segments skipped
+// A segment without a filter in memory is not skipped
+func (s *Store) skipSegment(segment string, key string) bool {
+	s.mu.RLock()
+	filter := s.filters[segment]
+	s.mu.RUnlock()
+
+	if filter != nil && !filter.mayContain(key) {
+		s.filterSkips.Add(1)
+		return true
+	}
+	return false
+}
+
+// dropSegmentFilters drops the Bloom filters kept in memory, and those being built, after the segments were replaced
+// Must be called with mu held
+func (s *Store) dropSegmentFilters() {
+	clear(s.filters)
+	s.filterGeneration++
+}
+
+// loadSegmentFilter reads the Bloom filter of segment in dbPath
+// Returns an error wrapping os.ErrNotExist if there is none, and errBadFilter if it is invalid or was built for
+// another segment or key normalization
+func (s *Store) loadSegmentFilter(dbPath string, segment string) (*bloomFilter, error) {
+	buf, err := os.ReadFile(filepath.Join(dbPath, segment+constants.FilterExt))
+	if err != nil {
+		return nil, fmt.Errorf("loadSegmentFilter: %w", err)
+	}
+
+	fields := len(buf) - sha256.Size
+	if fields < 0 || string(buf[:min(len(buf), 4)]) != constants.FilterMagic {
+		return nil, fmt.Errorf("loadSegmentFilter: %w: bad size or magic", errBadFilter)
+	}
+	if sum := sha256.Sum256(buf[:fields]); !bytes.Equal(sum[:], buf[fields:]) {
+		return nil, fmt.Errorf("loadSegmentFilter: %w: checksum mismatch", errBadFilter)
+	}
+
+	sr := &snapshotReader{r: bytes.NewReader(buf[4:fields])}
+	version := sr.uint32()
+	size := int64(sr.uint64())
+	var tail [sha256.Size]byte
+	copy(tail[:], sr.read(sha256.Size))
+	normalization := sr.string(int(sr.uint16()))
+	filter := &bloomFilter{hashes: sr.uint32()}
+	bits := sr.uint64()
+	if sr.err == nil && bits%8 == 0 && bits/8 <= uint64(fields) {
+		filter.bits = bytes.Clone(sr.read(int(bits / 8)))
+	}
+	switch {
+	case version != constants.FilterVersion:
+		return nil, fmt.Errorf("loadSegmentFilter: %w: version %d, expected %d",
+			errBadFilter, version, constants.FilterVersion)
+	case sr.err != nil || len(filter.bits) == 0 || filter.hashes == 0:
+		return nil, fmt.Errorf("loadSegmentFilter: %w: truncated", errBadFilter)
+	case normalization != s.normalization.String():
+		return nil, fmt.Errorf("loadSegmentFilter: %w: keys normalized with %q", errBadFilter, normalization)
+	}
+
+	info, err := os.Stat(filepath.Join(dbPath, segment))
+	if err != nil {
+		return nil, fmt.Errorf("loadSegmentFilter: %w", err)
+	}
+	if info.Size() != size {
+		return nil, fmt.Errorf("loadSegmentFilter: %w: built for %d bytes of %v, it has %d",
+			errBadFilter, size, segment, info.Size())
+	}
+	if current, err := segmentTail(dbPath, segment, size); err != nil || current != tail {
+		return nil, fmt.Errorf("loadSegmentFilter: %w: built for other contents of %v", errBadFilter, segment)
+	}
+
+	return filter, nil
+}
+
+// buildSegmentFilter builds the Bloom filter of segment in dbPath from the keys of its records, writes it, and
+// returns it
+// The records after a seal are read too, and reading stops at the first corrupted record, so the filter holds every
+// key the scans it serves can find
+func (s *Store) buildSegmentFilter(dbPath string, segment string) (*bloomFilter, error) {
+	file, err := os.Open(filepath.Join(dbPath, segment))
+	if err != nil {
+		return nil, fmt.Errorf("buildSegmentFilter: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("buildSegmentFilter: failed to stat %v: %w", segment, err)
+	}
+	tail, err := segmentTail(dbPath, segment, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("buildSegmentFilter: %w", err)
+	}
+
+	var keys []string
+	for pos := int64(0); ; {
+		rec, err := readRecord(file, info.Size(), pos)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logging.Warnf("buildSegmentFilter: stopped reading %v at offset %d: %v", segment, pos, err)
+			break
+		}
+		keys = append(keys, s.normalization.Key(rec.data.Key))
+		pos = rec.end()
+	}
+
+	filter := newBloomFilter(len(keys))
+	for _, key := range keys {
+		filter.add(key)
+	}
+
+	normalization := s.normalization.String()
+	buf := make([]byte, 0, 64+len(normalization)+len(filter.bits)+sha256.Size)
+	buf = append(buf, constants.FilterMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, constants.FilterVersion)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(info.Size()))
+	buf = append(buf, tail[:]...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(normalization)))
+	buf = append(buf, normalization...)
+	buf = binary.BigEndian.AppendUint32(buf, filter.hashes)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(filter.bits))*8)
+	buf = append(buf, filter.bits...)
+	sum := sha256.Sum256(buf)
+	buf = append(buf, sum[:]...)
+
+	path := filepath.Join(dbPath, segment+constants.FilterExt)
+	if err := os.WriteFile(path+".tmp", buf, 0644); err != nil {
+		os.Remove(path + ".tmp")
+		return nil, fmt.Errorf("buildSegmentFilter: %w", err)
+	}
+	if err := replaceFile(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("buildSegmentFilter: %w", err)
+	}
+
+	logging.Debugf("buildSegmentFilter: %v has %d records, filter of %d bytes", segment, len(keys), len(filter.bits))
+	return filter, nil
+}
+
+// buildSegmentFilters loads or builds the missing Bloom filters of the sealed segments right away and then every
+// constants.FilterBuildInterval seconds until the store is closed
+// This goroutine is started by Open
+func (s *Store) buildSegmentFilters() {
+	s.buildMissingFilters()
+	for s.sleep(JobSegmentFilters, constants.FilterBuildInterval*time.Second) {
+		s.buildMissingFilters()
+	}
+}
+
+// buildMissingFilters keeps in memory the Bloom filter of every sealed segment without one, loading it from its file
+// if that is valid and building it otherwise
+// Segments are read without the lock: sealed segments do not change, and the filters are only kept if compaction or
+// relocation did not replace the segments meanwhile, see dropSegmentFilters
+func (s *Store) buildMissingFilters() {
+	s.mu.RLock()
+	dbPath, activeLog, generation := s.dbPath, s.activeLog, s.filterGeneration
+	kept := make(map[string]bool, len(s.filters))
+	for segment := range s.filters {
+		kept[segment] = true
+	}
+	s.mu.RUnlock()
+
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		logging.Errorf("buildMissingFilters: %v", err)
+		return
+	}
+
+	filters := make(map[string]*bloomFilter)
+	built := 0
+	for _, segment := range segments {
+		if segment == activeLog || segmentNumber(segment) > segmentNumber(activeLog) || kept[segment] {
+			continue
+		}
+		filter, err := s.loadSegmentFilter(dbPath, segment)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logging.Debugf("buildMissingFilters: building again: %v", err)
+			}
+			if filter, err = s.buildSegmentFilter(dbPath, segment); err != nil {
+				logging.Warnf("buildMissingFilters: %v", err)
+				continue
+			}
+			built++
+		}
+		filters[segment] = filter
+	}
+	if len(filters) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filterGeneration != generation {
+		logging.Debugf("buildMissingFilters: the segments were replaced, dropping %d filters", len(filters))
+		return
+	}
+	for segment, filter := range filters {
+		s.filters[segment] = filter
+	}
+	logging.Infof("buildMissingFilters: loaded the Bloom filters of %d segments, built %d of them", len(filters), built)
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+/*
+Segment I/O timings:
+
+To tell a degrading disk from a growing database, the store keeps latency histograms per segment file of:
+
+- flushes to stable storage: every write of the active log with DurabilitySync, which opens it with O_SYNC, and every
+  fsync of the active log with DurabilityNone
+- compaction reads: reading each live record from the segment it is compacted out of
+- compaction writes: writing each live record to the segment of the compacted database it is copied to
+
+A disk going bad shows as growing latencies at a steady rate of observations, growth as more observations at steady
+latencies. Compaction numbers the segments of the compacted database from seg0.log again, so the histograms of a
+segment name cover every segment that had the name since the store was opened.
+*/
+
+// SegmentIO holds the I/O latency histograms of one segment file, see Store.SegmentIO
+type SegmentIO struct {
+	// Segment is the segment file name
+	Segment string
+
+	// Sync is the latency of flushing writes of the segment to stable storage
+	Sync HistogramSnapshot
+
+	// CompactionRead is the latency of reading a live record from the segment during compaction
+	CompactionRead HistogramSnapshot
+
+	// CompactionWrite is the latency of writing a record to the segment during compaction
+	CompactionWrite HistogramSnapshot
+}
+
+// segmentHistograms holds the I/O latency histograms of one segment file
+type segmentHistograms struct {
+	sync            Histogram
+	compactionRead  Histogram
+	compactionWrite Histogram
+}
+
+// segmentTimings maps segment file names to their I/O latency histograms
+// The zero value is ready to use
+type segmentTimings struct {
+	// mu protects segments; the histograms themselves need no lock
+	mu sync.Mutex
+
+	// segments maps segment file names to their histograms, created on first use
+	segments map[string]*segmentHistograms
+}
+
+// of returns the histograms of segment, creating them if needed
+func (t *segmentTimings) of(segment string) *segmentHistograms {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.segments == nil {
+		t.segments = make(map[string]*segmentHistograms)
+	}
+	h := t.segments[segment]
+	if h == nil {
+		h = &segmentHistograms{}
+		t.segments[segment] = h
+	}
+	return h
+}
+
+// SegmentIO returns the I/O latency histograms of every segment file written or compacted since the store was
+// opened, ordered by segment number
+func (s *Store) SegmentIO() []SegmentIO {
+	s.segmentIO.mu.Lock()
+	stats := make([]SegmentIO, 0, len(s.segmentIO.segments))
+	for segment, h := range s.segmentIO.segments {
+		stats = append(stats, SegmentIO{
+			Segment:         segment,
+			Sync:            h.sync.Snapshot(),
+			CompactionRead:  h.compactionRead.Snapshot(),
+			CompactionWrite: h.compactionWrite.Snapshot(),
+		})
+	}
+	s.segmentIO.mu.Unlock()
+
+	slices.SortFunc(stats, func(a, b SegmentIO) int {
+		return cmp.Compare(segmentNumber(a.Segment), segmentNumber(b.Segment))
+	})
+	return stats
+}
+
+// openWriter opens a writer appending to segment with durability, timing its flushes in the segment's histogram
+// and counting them in the store's amplification counters
+// Must be called with mu held (or before the store is shared)
+func (s *Store) openWriter(segment string, durability Durability) (*LogWriter, error) {
+	writer, err := newLogWriter(s.dbPath, segment, durability)
+	if err != nil {
+		return nil, fmt.Errorf("openWriter: %w", err)
+	}
+	writer.syncs = &s.segmentIO.of(segment).sync
+	writer.flushes = &s.amp.flushes
+	return writer, nil
+}
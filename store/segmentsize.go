@@ -0,0 +1,159 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"math/bits"
+	"time"
+)
+
+/*
+Segment sizing:
+
+The active log is rotated after a number of writes, its segment limit. A fixed limit suits one workload only: with
+tiny values the segments are small and numerous, so startup opens and scans many files, and with large values a
+segment grows so big that compacting it copies a lot at once. Instead, every time the active log is rotated, the
+store updates moving averages of the record size and of the write rate in bytes per second, and sizes the next
+segment to hold about constants.SegmentFillTime seconds of writes, between constants.MinSegmentBytes and
+constants.MaxSegmentBytes. The limit is that size divided by the average record size, rounded down to a power of
+two so that it only changes when the workload does, and kept within the bounds configured with SegmentSizing.
+*/
+
+// SegmentSizing bounds the segment limit, the number of writes after which the active log is rotated
+// Equal bounds fix the limit
+type SegmentSizing struct {
+	// MinKeys is the smallest limit (default: constants.MinKeysPerSegment)
+	MinKeys int
+
+	// MaxKeys is the largest limit (default: constants.MaxKeysPerSegment)
+	MaxKeys int
+}
+
+// withDefaults returns the sizing with the zero bounds replaced by their defaults
+func (z SegmentSizing) withDefaults() SegmentSizing {
+	if z.MinKeys == 0 {
+		z.MinKeys = constants.MinKeysPerSegment
+		if z.MaxKeys > 0 {
+			z.MinKeys = min(z.MinKeys, z.MaxKeys)
+		}
+	}
+	if z.MaxKeys == 0 {
+		z.MaxKeys = max(constants.MaxKeysPerSegment, z.MinKeys)
+	}
+	return z
+}
+
+// validate checks that the bounds are positive and ordered
+func (z SegmentSizing) validate() error {
+	if z.MinKeys < 1 || z.MaxKeys < z.MinKeys {
+		return fmt.Errorf("validate: segment sizing bounds must satisfy 1 <= min <= max, got %d and %d", z.MinKeys, z.MaxKeys)
+	}
+	return nil
+}
+
+// clamp returns limit within the bounds
+func (z SegmentSizing) clamp(limit int) int {
+	return min(max(limit, z.MinKeys), z.MaxKeys)
+}
+
+// SegmentStats describes the segment limit chosen by segment sizing
+type SegmentStats struct {
+	// Sizing holds the bounds of the limit
+	Sizing SegmentSizing
+
+	// Limit is the number of writes after which the active log is rotated
+	Limit int
+
+	// RecordBytes is the moving average of the record size in bytes, 0 before a segment was rotated
+	RecordBytes float64
+
+	// WriteRate is the moving average of the write rate in bytes per second, 0 before a segment was rotated
+	WriteRate float64
+}
+
+// SegmentSizing returns the bounds of the segment limit
+func (s *Store) SegmentSizing() SegmentSizing {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sizing.Sizing
+}
+
+// SetSegmentSizing changes the bounds of the segment limit; zero bounds are replaced by their defaults
+// The current limit is moved within the new bounds right away
+func (s *Store) SetSegmentSizing(z SegmentSizing) error {
+	z = z.withDefaults()
+	if err := z.validate(); err != nil {
+		return fmt.Errorf("SetSegmentSizing: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if z != s.sizing.Sizing {
+		logging.Infof("SetSegmentSizing: %d-%d -> %d-%d writes per segment",
+			s.sizing.Sizing.MinKeys, s.sizing.Sizing.MaxKeys, z.MinKeys, z.MaxKeys)
+	}
+	s.sizing.Sizing = z
+	s.sizing.Limit = z.clamp(s.sizing.Limit)
+	return nil
+}
+
+// startSegment records the start of the active log's writes at the writer's offset, see resizeSegments
+// Must be called with mu held, after the writer is opened
+func (s *Store) startSegment() {
+	s.segmentStart = time.Now()
+	s.segmentStartOffset = s.writer.offset
+}
+
+// resizeSegments updates the averages with the active log, which is about to be rotated, and recomputes the
+// segment limit from them
+// The record size is taken over the whole active log, the write rate over the writes since startSegment
+// Must be called with mu held
+func (s *Store) resizeSegments() {
+	elapsed := time.Since(s.segmentStart).Seconds()
+	if s.writer == nil || s.activeLogCount == 0 || elapsed <= 0 {
+		return
+	}
+
+	recordBytes := float64(s.writer.offset) / float64(s.activeLogCount)
+	writeRate := float64(s.writer.offset-s.segmentStartOffset) / elapsed
+	if s.sizing.RecordBytes == 0 {
+		s.sizing.RecordBytes = recordBytes
+		s.sizing.WriteRate = writeRate
+	} else {
+		s.sizing.RecordBytes += constants.SegmentSizingWeight * (recordBytes - s.sizing.RecordBytes)
+		s.sizing.WriteRate += constants.SegmentSizingWeight * (writeRate - s.sizing.WriteRate)
+	}
+
+	segmentBytes := min(max(s.sizing.WriteRate*constants.SegmentFillTime, constants.MinSegmentBytes), constants.MaxSegmentBytes)
+	keys := max(int(segmentBytes/s.sizing.RecordBytes), 1)
+	limit := s.sizing.Sizing.clamp(1 << (bits.Len(uint(keys)) - 1))
+	if limit != s.sizing.Limit {
+		logging.Infof("resizeSegments: %d -> %d writes per segment (%.0f bytes per record, %.0f bytes/s)",
+			s.sizing.Limit, limit, s.sizing.RecordBytes, s.sizing.WriteRate)
+		s.sizing.Limit = limit
+	}
+}
+
+// Rotate closes the active log and continues in a new segment now, as if the active log had reached the segment
+// limit, e.g. to seal the writes so far in a segment of their own
+// Forced rotations do not count in the averages of segment sizing; an empty active log is not rotated
+func (s *Store) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.activeLogCount == 0 {
+		return nil
+	}
+	if err := s.checkWritable(); err != nil {
+		return fmt.Errorf("Rotate: %w", err)
+	}
+	if err := s.rotate(); err != nil {
+		s.recordWrite(err)
+		return fmt.Errorf("Rotate: %w", err)
+	}
+
+	return nil
+}
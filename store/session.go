@@ -0,0 +1,226 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"time"
+)
+
+/*
+Sessions:
+
+Web applications mostly use a key-value store as their session backend, so sessions are built in rather than
+hand-rolled on top of Set and Get. A session is stored under constants.SessionKeyPrefix followed by its ID, 32 bytes
+from crypto/rand generated by the store, and expires with its key.
+
+Sessions are written with CheckAndSet: a session is created only if its key does not exist, and a refresh only
+applies if the session was not written since it was read, see models.KVStashCondition.Revision. A refresh racing
+with another one is retried, and a refresh racing with DestroySession fails with ErrSessionNotFound instead of
+bringing the session back.
+*/
+
+// Errors returned by session operations
+var (
+	// ErrSessionNotFound is returned for a session that does not exist, expired, or was destroyed,
+	// and for IDs that are not session IDs
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrBadSessionTTL is returned for a session TTL that is negative or longer than constants.MaxSessionTTL
+	ErrBadSessionTTL = errors.New("invalid session TTL")
+
+	// ErrSessionBusy is returned by RefreshSession when the session was written concurrently on every attempt
+	ErrSessionBusy = errors.New("session is being written concurrently")
+)
+
+// Session is a session of the session store, see CreateSession
+type Session struct {
+	// ID identifies the session; it is a secret, like a password
+	ID string
+
+	// Data is the application data of the session
+	Data string
+
+	// Created is when the session was created
+	Created time.Time
+
+	// ExpiresAt is when the session expires unless it is refreshed
+	ExpiresAt time.Time
+
+	// Revision is the revision of the last write to the session, see models.KVStashVersion.Revision
+	Revision uint64
+}
+
+// sessionRecord is the stored value of a session
+type sessionRecord struct {
+	// Data is the application data of the session
+	Data string `json:"data"`
+
+	// Created is when the session was created in Unix milliseconds
+	Created int64 `json:"created"`
+}
+
+// CreateSession creates a session holding data with a new random ID, which expires after ttl
+// A ttl of 0 applies constants.SessionTTL
+// Returns ErrBadSessionTTL for an invalid ttl and ErrValueTooLarge if data is too large
+func (s *Store) CreateSession(data string, ttl time.Duration) (*Session, error) {
+	seconds, err := sessionTTL(ttl)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSession: %w", err)
+	}
+
+	var b [constants.SessionIDBytes]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, fmt.Errorf("CreateSession: failed to generate an ID: %w", err)
+	}
+	id := hex.EncodeToString(b[:])
+
+	now := s.now()
+	value, err := json.Marshal(sessionRecord{Data: data, Created: now.UnixMilli()})
+	if err != nil {
+		return nil, fmt.Errorf("CreateSession: %w", err)
+	}
+
+	exists := false
+	versions, err := s.CheckAndSet(
+		[]models.KVStashCondition{{Key: sessionKey(id), Exists: &exists}},
+		[]models.KVStashBatchWrite{{Key: sessionKey(id), Value: string(value), TTL: seconds}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSession: %w", err)
+	}
+
+	return &Session{
+		ID:        id,
+		Data:      data,
+		Created:   time.UnixMilli(now.UnixMilli()),
+		ExpiresAt: time.UnixMilli(now.UnixMilli() + seconds*1000),
+		Revision:  versions[0].Revision,
+	}, nil
+}
+
+// ValidateSession returns the session id if it exists and did not expire
+// Returns ErrSessionNotFound if it does not
+func (s *Store) ValidateSession(id string) (*Session, error) {
+	session, err := s.readSession(id)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateSession: %w", err)
+	}
+	return session, nil
+}
+
+// RefreshSession makes the session id expire ttl from now, and replaces its data if data is not nil
+// A ttl of 0 applies constants.SessionTTL
+// Returns ErrSessionNotFound if the session does not exist, ErrBadSessionTTL for an invalid ttl,
+// and ErrSessionBusy if it was written concurrently on every attempt
+func (s *Store) RefreshSession(id string, ttl time.Duration, data *string) (*Session, error) {
+	seconds, err := sessionTTL(ttl)
+	if err != nil {
+		return nil, fmt.Errorf("RefreshSession: %w", err)
+	}
+
+	for range constants.SessionRefreshAttempts {
+		session, err := s.readSession(id)
+		if err != nil {
+			return nil, fmt.Errorf("RefreshSession: %w", err)
+		}
+		if data != nil {
+			session.Data = *data
+		}
+
+		value, err := json.Marshal(sessionRecord{Data: session.Data, Created: session.Created.UnixMilli()})
+		if err != nil {
+			return nil, fmt.Errorf("RefreshSession: %w", err)
+		}
+
+		now := s.now().UnixMilli()
+		versions, err := s.CheckAndSet(
+			[]models.KVStashCondition{{Key: sessionKey(id), Revision: &session.Revision}},
+			[]models.KVStashBatchWrite{{Key: sessionKey(id), Value: string(value), TTL: seconds}},
+		)
+		if errors.Is(err, ErrConditionFailed) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RefreshSession: %w", err)
+		}
+
+		session.ExpiresAt = time.UnixMilli(now + seconds*1000)
+		session.Revision = versions[0].Revision
+		return session, nil
+	}
+
+	return nil, fmt.Errorf("RefreshSession: %w (%d attempts)", ErrSessionBusy, constants.SessionRefreshAttempts)
+}
+
+// DestroySession deletes the session id
+// Returns ErrSessionNotFound if it does not exist
+func (s *Store) DestroySession(id string) error {
+	if !validSessionID(id) {
+		return fmt.Errorf("DestroySession: %w", ErrSessionNotFound)
+	}
+
+	if err := s.Delete(&models.KVStashRequest{Key: sessionKey(id)}); err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return fmt.Errorf("DestroySession: %w", ErrSessionNotFound)
+		}
+		return fmt.Errorf("DestroySession: %w", err)
+	}
+	return nil
+}
+
+// readSession reads the session id
+// Returns ErrSessionNotFound if it does not exist or id is not a session ID
+func (s *Store) readSession(id string) (*Session, error) {
+	if !validSessionID(id) {
+		return nil, fmt.Errorf("readSession: %w", ErrSessionNotFound)
+	}
+
+	value, entry, err := s.getEntry(&models.KVStashRequest{Key: sessionKey(id)})
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("readSession: %w", ErrSessionNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("readSession: %w", err)
+	}
+
+	var rec sessionRecord
+	if err := json.Unmarshal([]byte(value), &rec); err != nil {
+		return nil, fmt.Errorf("readSession: invalid session record: %w", err)
+	}
+
+	return &Session{
+		ID:        id,
+		Data:      rec.Data,
+		Created:   time.UnixMilli(rec.Created),
+		ExpiresAt: time.UnixMilli(entry.ExpiresAt),
+		Revision:  entry.Revision,
+	}, nil
+}
+
+// sessionKey returns the key the session id is stored under
+func sessionKey(id string) string {
+	return constants.SessionKeyPrefix + id
+}
+
+// validSessionID reports whether id has the form of a session ID
+func validSessionID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == constants.SessionIDBytes
+}
+
+// sessionTTL returns ttl in whole seconds, rounded up, or constants.SessionTTL if ttl is 0
+// Returns ErrBadSessionTTL if ttl is negative or longer than constants.MaxSessionTTL
+func sessionTTL(ttl time.Duration) (int64, error) {
+	if ttl == 0 {
+		return constants.SessionTTL, nil
+	}
+	if ttl < 0 || ttl > constants.MaxSessionTTL*time.Second {
+		return 0, fmt.Errorf("%w: must be between 1s and %ds, got %v", ErrBadSessionTTL, constants.MaxSessionTTL, ttl)
+	}
+	return int64((ttl + time.Second - 1) / time.Second), nil
+}
@@ -0,0 +1,352 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"sort"
+	"strings"
+)
+
+/*
+Snapshots and ranges:
+
+A snapshot copies the live entries of the index, walking the ordered keys so they need no sorting, and holds off
+compaction, relocation, and retention until it is released, so the segments its entries point into stay in place.
+
+Range does not copy anything: a page of a range request would otherwise copy every key after its start. Its iterator
+holds off compaction like a snapshot, which only takes the lock for a moment, and collects the keys of the range from
+the ordered keys of the index as it goes, two read-ahead batches at a time under the lock for reading. Writes are not
+blocked for longer than a batch takes to collect, and walking a range costs the keys it returns, whatever the size of
+the store; in exchange the range is not a point-in-time view, see Store.Range.
+*/
+
+// Snapshot is a consistent point-in-time view of the live keys in the store
+// It copies the index at creation time, so later writes and deletes are not visible through it
+// While at least one snapshot is open, automatic compaction is deferred so the segment
+// files referenced by the snapshot are not removed underneath it
+// Callers must call Release when done with the snapshot
+type Snapshot struct {
+	// store is the store the snapshot was taken from
+	store *Store
+
+	// dbPath is the database directory at the time the snapshot was taken
+	dbPath string
+
+	// keys holds the live keys in ascending order; the snapshot held by the iterator of Store.Range holds none
+	keys []string
+
+	// entries holds a copy of the index entry for every key in keys
+	entries map[string]models.KVStashIndexEntry
+
+	// released indicates whether Release has already been called
+	released bool
+}
+
+// Snapshot captures a consistent view of all live (neither deleted nor expired) keys in the store
+// The returned snapshot must be released with Release to allow compaction to resume
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := &Snapshot{
+		store:   s,
+		dbPath:  s.dbPath,
+		keys:    make([]string, 0, len(s.index)),
+		entries: make(map[string]models.KVStashIndexEntry, len(s.index)),
+	}
+
+	now := s.now().UnixMilli()
+	for node := s.keys.first(); node != nil; node = node.next[0] {
+		if entry := s.index[node.key]; live(entry, now) {
+			snap.keys = append(snap.keys, node.key)
+			snap.entries[node.key] = *entry
+		}
+	}
+
+	s.openSnapshots++
+	return snap
+}
+
+// Len returns the number of live keys captured by the snapshot
+func (snap *Snapshot) Len() int {
+	return len(snap.keys)
+}
+
+// Release frees the snapshot and allows compaction to run again
+// Calling Release more than once has no effect
+func (snap *Snapshot) Release() {
+	snap.store.mu.Lock()
+	defer snap.store.mu.Unlock()
+
+	if snap.released {
+		return
+	}
+	snap.released = true
+	snap.store.openSnapshots--
+}
+
+// Iterator returns an iterator over the snapshot's keys in ascending order
+func (snap *Snapshot) Iterator() *Iterator {
+	return &Iterator{snap: snap, next: 0, end: len(snap.keys)}
+}
+
+// Scan returns an iterator over the snapshot's keys matching pattern, in ascending order
+// Only the range of keys starting with the pattern's literal prefix is visited, and values
+// are read only for keys that match
+func (snap *Snapshot) Scan(pattern *Pattern) *Iterator {
+	prefix := pattern.Prefix()
+	start := sort.SearchStrings(snap.keys, prefix)
+	end := start + sort.Search(len(snap.keys)-start, func(i int) bool {
+		return !strings.HasPrefix(snap.keys[start+i], prefix)
+	})
+
+	return &Iterator{snap: snap, next: start, end: end, pattern: pattern}
+}
+
+// Range returns an iterator over the snapshot's keys from start up to, but not including, end, in ascending
+// order; an empty end has no upper bound
+func (snap *Snapshot) Range(start, end string) *Iterator {
+	from := sort.SearchStrings(snap.keys, start)
+	to := len(snap.keys)
+	if end != "" {
+		to = max(from, sort.SearchStrings(snap.keys, end))
+	}
+
+	return &Iterator{snap: snap, next: from, end: to}
+}
+
+// Iterator returns an iterator over all live keys in ascending order, backed by a new snapshot
+// The snapshot is owned by the iterator and released by Close
+func (s *Store) Iterator() *Iterator {
+	it := s.Snapshot().Iterator()
+	it.owned = true
+	return it
+}
+
+// Scan returns an iterator over the live keys matching the glob pattern (see Pattern), backed by a new snapshot
+// The snapshot is owned by the iterator and released by Close
+// The pattern is normalized like keys, see KeyNormalization
+// Returns ErrBadPattern if the pattern is malformed
+func (s *Store) Scan(glob string) (*Iterator, error) {
+	pattern, err := CompilePattern(s.normalization.Key(glob))
+	if err != nil {
+		return nil, fmt.Errorf("Scan: %w", err)
+	}
+
+	it := s.Snapshot().Scan(pattern)
+	it.owned = true
+	return it, nil
+}
+
+// Range returns an iterator over the live keys from start up to, but not including, end, in ascending order; an
+// empty end has no upper bound
+// The keys are read from the index as the iterator advances, not copied up front, so the cost of a range is that of
+// the keys it visits; a key written or deleted after the call is seen as it is if the iterator has not passed it yet
+// Compaction is held off until Close, like with a snapshot
+// The bounds are normalized like key prefixes, see KeyNormalization.Prefix
+func (s *Store) Range(start, end string) *Iterator {
+	s.mu.Lock()
+	snap := &Snapshot{store: s, dbPath: s.dbPath}
+	s.openSnapshots++
+	s.mu.Unlock()
+
+	return &Iterator{
+		snap:   snap,
+		ranged: &keyRange{cursor: s.normalization.Prefix(start), end: s.normalization.Prefix(end)},
+		owned:  true,
+	}
+}
+
+// keyRange is the part of the index an iterator of Store.Range has not collected yet
+type keyRange struct {
+	// cursor is the last key collected, or the start of the range before any was
+	cursor string
+
+	// started indicates that cursor was collected, so the range goes on after it
+	started bool
+
+	// end is the key the range stops before, "" for no upper bound
+	end string
+
+	// done indicates that every key of the range was collected
+	done bool
+}
+
+// collectRange appends to keys and entries up to n live keys of r after its cursor and a copy of their index entries
+// Returns the extended keys and entries
+func (s *Store) collectRange(r *keyRange, n int, keys []string,
+	entries []models.KVStashIndexEntry) ([]string, []models.KVStashIndexEntry) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	node := s.keys.seek(r.cursor)
+	if r.started && node != nil && node.key == r.cursor {
+		node = node.next[0]
+	}
+
+	now := s.now().UnixMilli()
+	for ; node != nil && n > 0; node = node.next[0] {
+		if r.end != "" && node.key >= r.end {
+			break
+		}
+		r.cursor, r.started = node.key, true
+		if entry := s.index[node.key]; live(entry, now) {
+			keys = append(keys, node.key)
+			entries = append(entries, *entry)
+			n--
+		}
+	}
+	r.done = n > 0
+	return keys, entries
+}
+
+// Iterator walks the keys of a snapshot in ascending order, reading values in batches as it goes
+//
+// Usage:
+//
+//	it := snap.Iterator()
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil { ... }
+type Iterator struct {
+	// snap is the snapshot being iterated
+	snap *Snapshot
+
+	// ranged is the range of the index walked by the iterator of Store.Range, nil to walk snap's keys
+	ranged *keyRange
+
+	// next is the index in snap.keys of the first key not collected yet
+	next int
+
+	// end is the index in snap.keys at which iteration stops
+	end int
+
+	// pending holds the matching keys collected but not read ahead yet, and pendingEntries their index entries
+	pending        []string
+	pendingEntries []models.KVStashIndexEntry
+
+	// ahead holds the keys read ahead but not visited yet, with their index entries and values
+	ahead        []string
+	aheadEntries []models.KVStashIndexEntry
+	aheadValues  []string
+
+	// aheadErr is the error that stopped the last read ahead, reported once the keys before it are visited
+	aheadErr error
+
+	// pattern filters the visited keys, or nil to visit every key
+	pattern *Pattern
+
+	// key, entry, and value are the current key, its index entry, and its value
+	key   string
+	entry models.KVStashIndexEntry
+	value string
+
+	// err holds the first error encountered while reading values
+	err error
+
+	// owned indicates that Close releases the snapshot
+	owned bool
+}
+
+// Next advances the iterator to the next key and reads its value
+// Values are read ahead in batches of constants.ReadAheadKeys, grouped by segment file, see readValues
+// Returns false when the iteration is exhausted or an error occurred (see Err)
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.ahead) == 0 {
+		if it.aheadErr != nil {
+			it.err = it.aheadErr
+			return false
+		}
+		if !it.readAhead() {
+			return false
+		}
+	}
+
+	it.key, it.entry, it.value = it.ahead[0], it.aheadEntries[0], it.aheadValues[0]
+	it.ahead, it.aheadEntries, it.aheadValues = it.ahead[1:], it.aheadEntries[1:], it.aheadValues[1:]
+	return true
+}
+
+// collect tops up the pending keys to two batches of constants.ReadAheadKeys, from the snapshot's keys or, for
+// Store.Range, from the index
+func (it *Iterator) collect() {
+	want := 2*constants.ReadAheadKeys - len(it.pending)
+	if it.ranged != nil {
+		if want > 0 && !it.ranged.done {
+			it.pending, it.pendingEntries = it.snap.store.collectRange(it.ranged, want, it.pending, it.pendingEntries)
+		}
+		return
+	}
+
+	for ; it.next < it.end && want > 0; it.next++ {
+		key := it.snap.keys[it.next]
+		if it.pattern == nil || it.pattern.Match(key) {
+			it.pending = append(it.pending, key)
+			it.pendingEntries = append(it.pendingEntries, it.snap.entries[key])
+			want--
+		}
+	}
+}
+
+// readAhead reads the values of the next batch of pending keys and prefetches the batch after them, so that the
+// batch is in the page cache by the time the caller has consumed this one
+// If a read fails, only the keys before it are kept and the error is left in aheadErr
+// Returns false when no key is left or the first read failed, in which case err is set
+func (it *Iterator) readAhead() bool {
+	it.collect()
+	n := min(len(it.pending), constants.ReadAheadKeys)
+	if n == 0 {
+		return false
+	}
+	it.ahead, it.aheadEntries = it.pending[:n:n], it.pendingEntries[:n:n]
+	it.pending, it.pendingEntries = it.pending[n:], it.pendingEntries[n:]
+	it.snap.store.prefetch(it.snap.dbPath, it.pendingEntries)
+
+	values, errs := readValues(it.snap.store.files, it.snap.dbPath, it.aheadEntries, VerifyFull, nil)
+	for i, err := range errs {
+		if err != nil {
+			it.ahead, it.aheadEntries, values, it.aheadErr = it.ahead[:i], it.aheadEntries[:i], values[:i], err
+			break
+		}
+	}
+	if len(it.ahead) == 0 {
+		it.err = it.aheadErr
+		return false
+	}
+	it.aheadValues = values
+	return true
+}
+
+// Key returns the key at the current position
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+// Value returns the value at the current position
+// Lists, sets, and hashes are returned encoded as JSON; Entry().Type tells them apart from strings
+func (it *Iterator) Value() string {
+	return it.value
+}
+
+// Entry returns the index entry (segment, offset, size, checksum) at the current position
+func (it *Iterator) Entry() models.KVStashIndexEntry {
+	return it.entry
+}
+
+// Err returns the first error encountered during iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator's snapshot if the iterator was created by Store.Iterator
+// Iterators created from a Snapshot leave releasing it to the caller
+func (it *Iterator) Close() {
+	if it.owned {
+		it.snap.Release()
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/models"
+	"maps"
+)
+
+/*
+Soft limits:
+
+Writes fail once they hit a hard limit: a value larger than the maximum value size, a full namespace that does not
+evict, or less free disk space than the minimum. With a soft limit ratio set, SoftLimitWarnings tells which limits a
+successful write came within that fraction of, so clients can react before their writes start failing:
+
+  - models.WarnValueSize: the value is at least ratio of the maximum value size of its namespace
+  - models.WarnNamespaceQuota: the namespace of the key holds at least ratio of its MaxKeys and does not evict;
+    namespaces that evict never refuse writes, so they are not warned about
+  - models.WarnDiskSpace: at least ratio of the space on the database volume above the minimum free space is used;
+    only while the low disk watchdog is enabled, see SetMinFreeBytes
+
+Warnings are counted by limit in SoftLimitStats.
+*/
+
+// SoftLimitStats describes the warnings about writes approaching a limit
+type SoftLimitStats struct {
+	// Ratio is the fraction of a limit past which writes are warned about, 0 if they are not
+	Ratio float64
+
+	// Warnings counts the warnings by limit, e.g. models.WarnValueSize, since the store was opened
+	Warnings map[string]int64
+}
+
+// SoftLimit returns the fraction of a limit past which writes are warned about, 0 if they are not
+func (s *Store) SoftLimit() float64 {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return s.softLimits.Ratio
+}
+
+// SetSoftLimit warns about the writes that reach ratio of a limit, see SoftLimitWarnings; 0 disables the warnings
+// Returns an error unless 0 <= ratio < 1
+func (s *Store) SetSoftLimit(ratio float64) error {
+	if ratio < 0 || ratio >= 1 {
+		return fmt.Errorf("SetSoftLimit: ratio must be at least 0 and below 1, got %v", ratio)
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.softLimits.Ratio = ratio
+	return nil
+}
+
+// SoftLimitWarnings returns the limits a write of a value of valueSize bytes to key came close to, nil if none did
+// or soft limits are disabled; the warnings are counted in SoftLimitStats
+// It is meant to be called once the write succeeded, so that the key counts towards the quota of its namespace
+func (s *Store) SoftLimitWarnings(key string, valueSize int) []models.KVStashWarning {
+	ratio := s.SoftLimit()
+	if ratio == 0 {
+		return nil
+	}
+	key = s.normalization.Key(key)
+
+	var warnings []models.KVStashWarning
+	warn := func(limit string, format string, args ...any) {
+		warnings = append(warnings, models.KVStashWarning{Limit: limit, Message: fmt.Sprintf(format, args...)})
+	}
+
+	maxValueSize, name, keys, maxKeys := s.maxValueSize, "", 0, 0
+	s.nsMu.Lock()
+	if ns := matchNamespace(s.namespaces, key); ns != nil {
+		if ns.MaxValueSize > 0 {
+			maxValueSize = ns.MaxValueSize
+		}
+		if ns.MaxKeys > 0 && ns.Eviction == EvictNone && ns.keys != nil {
+			name, keys, maxKeys = ns.Name, ns.keys.Len(), ns.MaxKeys
+		}
+	}
+	s.nsMu.Unlock()
+
+	if float64(valueSize) >= ratio*float64(maxValueSize) {
+		warn(models.WarnValueSize, "value of %d bytes is close to the maximum of %d bytes", valueSize, maxValueSize)
+	}
+	if maxKeys > 0 && float64(keys) >= ratio*float64(maxKeys) {
+		warn(models.WarnNamespaceQuota, "namespace %v holds %d of at most %d keys", name, keys, maxKeys)
+	}
+
+	disk := s.refreshDisk()
+	if disk.MinFreeBytes > 0 && !disk.CheckedAt.IsZero() && disk.TotalBytes > uint64(disk.MinFreeBytes) {
+		used := float64(disk.TotalBytes) - float64(disk.FreeBytes)
+		if usable := float64(disk.TotalBytes - uint64(disk.MinFreeBytes)); used >= ratio*usable {
+			warn(models.WarnDiskSpace, "%d bytes free on the database volume, writes are refused below %d",
+				disk.FreeBytes, disk.MinFreeBytes)
+		}
+	}
+
+	if len(warnings) > 0 {
+		s.statsMu.Lock()
+		if s.softLimits.Warnings == nil {
+			s.softLimits.Warnings = make(map[string]int64)
+		}
+		for _, w := range warnings {
+			s.softLimits.Warnings[w.Limit]++
+		}
+		s.statsMu.Unlock()
+	}
+	return warnings
+}
+
+// softLimitState returns a copy of the soft limit statistics
+// The caller must hold statsMu
+func (s *Store) softLimitState() SoftLimitStats {
+	return SoftLimitStats{Ratio: s.softLimits.Ratio, Warnings: maps.Clone(s.softLimits.Warnings)}
+}
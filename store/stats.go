@@ -0,0 +1,370 @@
+package store
+
+import (
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/models"
+	"time"
+)
+
+// CompactionStats describes the automatic compaction activity of a store
+type CompactionStats struct {
+	// Running indicates that a compaction cycle is in progress
+	Running bool
+
+	// Runs is the number of compaction cycles that replaced the database
+	Runs int64
+
+	// Failures is the number of compaction cycles that were abandoned or rolled back
+	Failures int64
+
+	// Skipped is the number of compaction cycles skipped because snapshots were open, the store was degraded,
+	// or disk space was low
+	Skipped int64
+
+	// LastStart is the time the most recent cycle started (zero if none ran yet)
+	LastStart time.Time
+
+	// LastDuration is the duration of the most recent finished cycle
+	LastDuration time.Duration
+
+	// LastBytesBefore and LastBytesAfter are the database size before and after the most recent successful cycle
+	LastBytesBefore int64
+	LastBytesAfter  int64
+
+	// Paused indicates that new cycles are skipped, by PauseCompaction or a pause window; PauseReason says which
+	Paused      bool
+	PauseReason string
+
+	// PausedSince is when PauseCompaction paused compaction (zero unless paused by PauseCompaction)
+	PausedSince time.Time
+}
+
+// Compaction triggers, see CompactionRun
+const (
+	// TriggerInterval is a cycle started by the periodic compaction timer
+	TriggerInterval = "interval"
+
+	// TriggerManual is a cycle started by Compact
+	TriggerManual = "manual"
+)
+
+// Compaction outcomes, see CompactionRun
+const (
+	// CompactionSucceeded means the compacted database replaced the old one
+	CompactionSucceeded = "success"
+
+	// CompactionFailed means the cycle was abandoned or rolled back and the old database kept
+	CompactionFailed = "failure"
+
+	// CompactionSkipped means the cycle did not start, see CompactionRun.Error for the reason
+	CompactionSkipped = "skipped"
+)
+
+// CompactionRun records one compaction cycle in the history returned by CompactionHistory
+type CompactionRun struct {
+	// Trigger is why the cycle ran, e.g. TriggerInterval
+	Trigger string
+
+	// Start is when the cycle started
+	Start time.Time
+
+	// Duration is how long the cycle took
+	Duration time.Duration
+
+	// Outcome is CompactionSucceeded, CompactionFailed, or CompactionSkipped
+	Outcome string
+
+	// SegmentsBefore and SegmentsAfter are the number of segment files before and after the cycle
+	SegmentsBefore int
+	SegmentsAfter  int
+
+	// BytesBefore and BytesAfter are the database size before and after the cycle
+	BytesBefore int64
+	BytesAfter  int64
+
+	// Error describes why the cycle failed or was skipped
+	Error string
+}
+
+// ReadStats counts the lookups of keys by Get and GetMany since the store was opened
+// Keys holding a collection are neither hits nor misses
+type ReadStats struct {
+	// Hits is the number of keys found holding a string or JSON document whose value was read
+	Hits int64
+
+	// Misses is the number of missing, deleted, or expired keys
+	Misses int64
+}
+
+// Stats is a point-in-time summary of a store
+type Stats struct {
+	// DataDir is the database directory, which changes when the database is relocated
+	DataDir string
+
+	// Segments is the number of segment files, including the active log
+	Segments int
+
+	// ActiveLog is the name of the segment currently written to
+	ActiveLog string
+
+	// ActiveLogCount is the number of records written to the active log
+	ActiveLogCount int
+
+	// Sizing describes the number of writes after which the active log is rotated
+	Sizing SegmentStats
+
+	// LiveKeys and DeletedKeys count the index entries by state; expired keys count as deleted
+	LiveKeys    int
+	DeletedKeys int
+
+	// DiskBytes is the total size of the segment files
+	DiskBytes int64
+
+	// GarbageRatio is the fraction of DiskBytes not taken up by the records of live keys, which compaction reclaims
+	GarbageRatio float64
+
+	// OpenSnapshots is the number of unreleased snapshots
+	OpenSnapshots int
+
+	// Compaction describes the automatic compaction activity
+	Compaction CompactionStats
+
+	// Breaker describes the write circuit breaker
+	Breaker BreakerStats
+
+	// Maintenance describes the maintenance mode
+	Maintenance MaintenanceStats
+
+	// Disk describes the free space on the database volume
+	Disk DiskStats
+
+	// SoftLimits describes the warnings about writes approaching a limit
+	SoftLimits SoftLimitStats
+
+	// Amplification describes the write and space amplification
+	Amplification AmplificationStats
+
+	// Retention describes the segments dropped by age
+	Retention RetentionStats
+
+	// Clock describes the store's time and clock skew
+	Clock ClockStats
+
+	// Inline describes the values kept in the index
+	Inline InlineStats
+
+	// ReadCache describes the cache of values read from disk
+	ReadCache ReadCacheStats
+
+	// Reads counts the keys found and not found by Get and GetMany
+	Reads ReadStats
+
+	// Transformers names the transformers applied to the values written, see SetTransformers
+	Transformers []string
+
+	// TransformedKeys is the number of live keys whose value is stored transformed
+	TransformedKeys int
+
+	// DeletionHistory describes the log dropped tombstones are archived to, see DeletionHistory
+	DeletionHistory DeletionHistoryStats
+}
+
+// Stats returns a summary of the store's index, disk usage, and compaction activity
+// While a compaction cycle holds the store lock, the index figures of the last call are returned
+// so that monitoring never stalls behind compaction
+func (s *Store) Stats() Stats {
+	disk := s.refreshDisk()
+
+	s.statsMu.Lock()
+	compaction := s.compactionState()
+	breaker := s.breaker
+	maintenance := s.maintenance
+	softLimits := s.softLimitState()
+	last := s.lastStats
+	s.statsMu.Unlock()
+
+	if compaction.Running {
+		last.Compaction = compaction
+		last.Breaker = breaker
+		last.Maintenance = maintenance
+		last.Disk = disk
+		last.SoftLimits = softLimits
+		last.Clock = s.ClockStats()
+		last.Reads = s.readStats()
+		last.ReadCache = s.ReadCacheStats()
+		last.DeletionHistory = s.DeletionHistoryStats()
+		last.Amplification = s.amp.stats(last.DiskBytes, last.Amplification.LiveBytes)
+		return last
+	}
+
+	s.mu.RLock()
+	stats := Stats{
+		DataDir:         s.dbPath,
+		ActiveLog:       s.activeLog,
+		ActiveLogCount:  s.activeLogCount,
+		Sizing:          s.sizing,
+		OpenSnapshots:   s.openSnapshots,
+		Transformers:    s.Transformers(),
+		DeletionHistory: s.DeletionHistoryStats(),
+	}
+	var liveBytes int64
+	stats.Inline = InlineStats{Threshold: s.InlineThreshold(), Hits: s.inlineHits.Load()}
+	now := s.now().UnixMilli()
+	for _, entry := range s.index {
+		if entry.Inline != nil {
+			stats.Inline.Values++
+			stats.Inline.Bytes += int64(len(*entry.Inline))
+		}
+		if live(entry, now) {
+			stats.LiveKeys++
+			liveBytes += logicalSize(entry)
+			if entry.Transforms != 0 {
+				stats.TransformedKeys++
+			}
+		} else {
+			stats.DeletedKeys++
+		}
+	}
+	if segments, err := listSegments(s.dbPath); err == nil {
+		stats.Segments = len(segments)
+	}
+	stats.DiskBytes, _ = dirSize(s.dbPath)
+	stats.GarbageRatio = s.garbageRatio(stats.DiskBytes)
+	stats.Amplification = s.amp.stats(stats.DiskBytes, liveBytes)
+	stats.Retention = s.retentionStats()
+	stats.Inline.MemoryBytes = stats.Inline.Bytes + int64(stats.Inline.Values)*constants.InlineEntryOverhead
+	s.mu.RUnlock()
+
+	stats.Clock = s.ClockStats()
+	stats.Reads = s.readStats()
+	stats.ReadCache = s.ReadCacheStats()
+
+	s.statsMu.Lock()
+	stats.Compaction = s.compactionState()
+	stats.Breaker = s.breaker
+	stats.Maintenance = s.maintenance
+	stats.Disk = disk
+	stats.SoftLimits = s.softLimitState()
+	s.lastStats = stats
+	s.statsMu.Unlock()
+
+	return stats
+}
+
+// readStats returns the hits and misses of Get and GetMany
+func (s *Store) readStats() ReadStats {
+	return ReadStats{Hits: s.readHits.Load(), Misses: s.readMisses.Load()}
+}
+
+// CompactionDurations returns the durations of the compaction cycles that ran since the store was opened, whether
+// they replaced the database or failed; skipped cycles are not counted
+func (s *Store) CompactionDurations() HistogramSnapshot {
+	return s.compactionDurations.Snapshot()
+}
+
+// compactionStarted records the start of a compaction cycle of a database of bytesBefore bytes
+// The returned record is completed by compactionFinished
+func (s *Store) compactionStarted(trigger string, bytesBefore int64) *CompactionRun {
+	run := &CompactionRun{
+		Trigger:        trigger,
+		Start:          time.Now(),
+		SegmentsBefore: segmentCount(s.dbPath),
+		BytesBefore:    bytesBefore,
+	}
+
+	// Deferred first, so the hooks run after statsMu is released
+	defer s.notifyCompactionStart(trigger)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.compaction.Running = true
+	s.compaction.LastStart = run.Start
+	return run
+}
+
+// compactionFinished records the outcome of a compaction cycle, err is nil if the database was replaced
+// The database is measured again, so a failed cycle reports the state it left behind
+func (s *Store) compactionFinished(run *CompactionRun, err error) {
+	run.Duration = time.Since(run.Start)
+	s.compactionDurations.Observe(run.Duration)
+	run.SegmentsAfter = segmentCount(s.dbPath)
+	run.BytesAfter, _ = dirSize(s.dbPath)
+	run.Outcome = CompactionSucceeded
+	if err != nil {
+		run.Outcome = CompactionFailed
+		run.Error = err.Error()
+	}
+
+	defer s.notifyCompactionEnd(*run)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.compaction.Running = false
+	s.compaction.LastDuration = run.Duration
+	if err == nil {
+		s.compaction.Runs++
+		s.compaction.LastBytesBefore = run.BytesBefore
+		s.compaction.LastBytesAfter = run.BytesAfter
+		if s.compactionFailing {
+			s.compactionFailing = false
+			s.raiseAlert(models.AlertCompactionRecovered, models.SeverityResolved,
+				"compaction of %v succeeded again (%d bytes -> %d bytes)", s.dbPath, run.BytesBefore, run.BytesAfter)
+		}
+	} else {
+		s.compaction.Failures++
+		s.compactionFailing = true
+		s.raiseAlert(models.AlertCompactionFailed, models.SeverityCritical,
+			"compaction of %v failed, the database was kept as it was: %v", s.dbPath, err)
+	}
+	s.addCompactionRun(*run)
+}
+
+// compactionSkipped records a compaction cycle that was skipped for reason
+func (s *Store) compactionSkipped(trigger string, reason string) {
+	run := CompactionRun{
+		Trigger:        trigger,
+		Start:          time.Now(),
+		Outcome:        CompactionSkipped,
+		SegmentsBefore: segmentCount(s.dbPath),
+		Error:          reason,
+	}
+	run.SegmentsAfter = run.SegmentsBefore
+	run.BytesBefore, _ = dirSize(s.dbPath)
+	run.BytesAfter = run.BytesBefore
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.compaction.Skipped++
+	s.addCompactionRun(run)
+}
+
+// addCompactionRun appends run to the history, dropping the oldest run beyond constants.CompactionHistorySize
+// Must be called with statsMu held
+func (s *Store) addCompactionRun(run CompactionRun) {
+	if len(s.history) >= constants.CompactionHistorySize {
+		s.history = append(s.history[:0], s.history[1:]...)
+	}
+	s.history = append(s.history, run)
+}
+
+// CompactionHistory returns the most recent compaction cycles, newest first
+func (s *Store) CompactionHistory() []CompactionRun {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	runs := make([]CompactionRun, len(s.history))
+	for i, run := range s.history {
+		runs[len(s.history)-1-i] = run
+	}
+	return runs
+}
+
+// segmentCount returns the number of segment files in dbPath, 0 if it cannot be listed
+func segmentCount(dbPath string) int {
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return 0
+	}
+	return len(segments)
+}
@@ -0,0 +1,1696 @@
+// Package store implements the core storage engine for KVStash
+// It provides thread-safe key-value storage backed by an append-only log file
+package store
+
+import (
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Validation errors that should result in HTTP 400 responses
+var (
+	ErrEmptyKey      = errors.New("key should not be empty")
+	ErrKeyTooLarge   = errors.New("key exceeds maximum size")
+	ErrValueTooLarge = errors.New("value exceeds maximum size")
+	ErrKeyNotFound   = errors.New("key not found in index")
+)
+
+// ErrBadMaxValueSize is returned by Open for a maximum value size above constants.MaxValueSize
+var ErrBadMaxValueSize = errors.New("max value size must be between 0 and the largest value a record can hold")
+
+// segmentFilePattern is used to find the segment files in directory
+var segmentFilePattern = regexp.MustCompile(`^seg(\d+)\.log$`)
+
+// Store manages the key-value storage with thread-safe access
+// It maintains an in-memory index for fast lookups and uses a log writer for persistence
+type Store struct {
+	// index maps keys to their storage locations in the log file
+	index models.KVStashIndex
+
+	// writer handles appending new entries to the active log file
+	writer *LogWriter
+
+	// mu protects concurrent access to the index, activeLog, activeLogCount, segmentCount, and writer
+	mu sync.RWMutex
+
+	// dbPath is the directory where database files are stored, changed by Relocate while holding mu and statsMu
+	dbPath string
+
+	// segmentCount tracks the total number of segments (including active log)
+	segmentCount int
+
+	// nextSegment is the number of the segment created by the next log rotation, persisted in the superblock
+	nextSegment int
+
+	// activeLog tracks the active log file name
+	activeLog string
+
+	// activeLogCount tracks the number of writes to the active log (includes updates to existing keys)
+	activeLogCount int
+
+	// sizing holds the segment limit and the averages it is computed from, protected by mu, see resizeSegments
+	sizing SegmentStats
+
+	// sealed maps the segments other than the active log to when they were sealed, protected by mu, see
+	// applyRetention
+	sealed map[string]time.Time
+
+	// retention is the age in nanoseconds after which segments are dropped, 0 to keep them, see SetRetention
+	retention atomic.Int64
+
+	// retained counts the segments and keys dropped by retention
+	retained retentionCounters
+
+	// inlineThreshold is the size in bytes up to which values are kept in the index, see SetInlineThreshold
+	inlineThreshold atomic.Int64
+
+	// inlineHits counts the reads served from values kept in the index
+	inlineHits atomic.Int64
+
+	// readCache holds the values recently read from disk, see SetReadCacheBytes
+	readCache *readCache
+
+	// filterSkips counts the segments a scan for a key skipped because their Bloom filter ruled it out
+	filterSkips atomic.Int64
+
+	// keys holds the keys of index in ascending order, see keyList
+	keys *keyList
+
+	// filters holds the Bloom filters of the sealed segments by segment, see skipSegment
+	filters map[string]*bloomFilter
+
+	// filterGeneration changes whenever the segments are replaced, see dropSegmentFilters
+	filterGeneration uint64
+
+	// readHits and readMisses count the keys Get and GetMany found and did not find
+	readHits   atomic.Int64
+	readMisses atomic.Int64
+
+	// compactionDurations times the compaction cycles that ran, successful or not
+	compactionDurations Histogram
+
+	// segmentStart and segmentStartOffset are when and at which offset the store started writing to the active log,
+	// protected by mu, see startSegment
+	segmentStart       time.Time
+	segmentStartOffset int64
+
+	// revision is the last revision assigned to a write, protected by mu, see models.KVStashVersion.Revision
+	// Every record carries its revision, and the superblock keeps it once the records are compacted away
+	revision uint64
+
+	// openSnapshots tracks the number of unreleased snapshots; compaction is deferred while it is non-zero
+	openSnapshots int
+
+	// relocating indicates that Relocate is copying the database; compaction is deferred while it is set
+	relocating bool
+
+	// batch is the conditional batch being written, nil outside CheckAndSet
+	batch *writeBatch
+
+	// uncommittedBatch is the offset in the active log of a batch found without its commit record by buildIndex,
+	// -1 if there is none
+	uncommittedBatch int64
+
+	// feed publishes every Set and Delete to watchers
+	feed *changefeed
+
+	// expiries orders the index entries with an expiry time by it, protected by mu, see announceExpired
+	expiries expiryQueue
+
+	// tmpPath is the directory the compacted database is built in
+	tmpPath string
+
+	// backupPath is the directory the database is copied to before compaction
+	backupPath string
+
+	// compactionInterval is the delay between two compaction cycles in nanoseconds, changeable at runtime
+	compactionInterval atomic.Int64
+
+	// indexSnapshotInterval is the delay between two index snapshots, 0 if none are written or loaded, see
+	// Options.IndexSnapshotInterval
+	indexSnapshotInterval time.Duration
+
+	// indexSnapshots describes the index snapshot loaded by Open and those written since, protected by statsMu
+	indexSnapshots IndexSnapshotStats
+
+	// durability is the durability mode of the active log writer
+	durability Durability
+
+	// verification is the verification level of reads that do not ask for one, changeable at runtime
+	verification atomic.Pointer[Verification]
+
+	// startupCheck is how much of every record buildIndex verifies, "" for StartupCheckFast
+	startupCheck StartupCheck
+
+	// startupRepair is what buildIndex does with a corrupt sealed segment
+	startupRepair StartupRepair
+
+	// quarantinePath is the directory corrupt segments and databases are moved to by the startup repair
+	quarantinePath string
+
+	// repairs are the corrupt sealed segments repaired by Open, not changed after it returns
+	repairs []Repair
+
+	// stop is closed by Close to end the compaction and expiry goroutines
+	stop chan struct{}
+
+	// stopOnce guards closing stop
+	stopOnce sync.Once
+
+	// statsMu protects compaction and lastStats, which are read without taking mu
+	statsMu sync.Mutex
+
+	// compaction tracks the automatic compaction activity reported by Stats
+	compaction CompactionStats
+
+	// lastStats is the result of the last Stats call, returned while compaction holds mu
+	lastStats Stats
+
+	// history holds the most recent compaction cycles, oldest first, protected by statsMu
+	history []CompactionRun
+
+	// compactionFailing indicates that the last compaction cycle failed, protected by statsMu
+	compactionFailing bool
+
+	// pause holds the compaction pause set by PauseCompaction and the pause windows, protected by statsMu
+	pause compactionPause
+
+	// schedule holds the compaction windows and minimum garbage ratio, protected by statsMu
+	schedule compactionSchedule
+
+	// breaker tracks consecutive write failures, protected by statsMu
+	breaker BreakerStats
+
+	// maintenance is the maintenance mode set by SetMaintenance, protected by statsMu
+	maintenance MaintenanceStats
+
+	// scheduler holds the pause and statistics of the background jobs, protected by statsMu, see PauseScheduler
+	scheduler scheduler
+
+	// failureThreshold is the number of consecutive write failures that trips the breaker
+	failureThreshold int
+
+	// minFreeBytes is the free disk space below which writes are refused, 0 disables the check
+	minFreeBytes atomic.Int64
+
+	// disk holds the last free disk space measurement, protected by statsMu
+	disk DiskStats
+
+	// softLimits holds the soft limit ratio and the warnings counted, protected by statsMu, see SoftLimitWarnings
+	softLimits SoftLimitStats
+
+	// indexChecks holds the index check configuration and results, protected by statsMu, see CheckIndex
+	indexChecks IndexCheckStats
+
+	// hot counts the accesses of keys, see HotKeys
+	hot hotKeys
+
+	// deletions configures the log the tombstones dropped by compaction and retention are archived to,
+	// see DeletionHistory
+	deletions deletionHistory
+
+	// normalization is applied to every key, prefix, and pattern given to the store
+	normalization KeyNormalization
+
+	// maxValueSize is the largest value writes accept, at most constants.MaxValueSize
+	maxValueSize int
+
+	// clock tells the store the time, see Clock
+	clock Clock
+
+	// hlc is the hybrid clock the store reads the time of clock through, see now
+	hlc *hybridClock
+
+	// renormalized counts the records read by buildIndex whose key was not in normalized form
+	renormalized int
+
+	// nsMu protects namespaces and their recency order, which reads update while holding mu for reading only
+	nsMu sync.Mutex
+
+	// namespaces holds the namespace configuration, longest prefix first
+	namespaces []*namespaceState
+
+	// files keeps segment files open for reads
+	files *handlePool
+
+	// prefetchSlots holds a token for every running prefetch, see Prefetch
+	prefetchSlots chan struct{}
+
+	// latency holds the latency histograms of each operation, see Latencies
+	latency map[string]*opHistograms
+
+	// segmentIO holds the I/O latency histograms of each segment file, see SegmentIO
+	segmentIO segmentTimings
+
+	// amp counts the bytes written and flushes behind the write amplification, see AmplificationStats
+	amp amplification
+
+	// alertMu protects onAlert and pendingAlerts
+	alertMu sync.Mutex
+
+	// onAlert receives the store's alerts, see SetAlertHandler
+	onAlert func(models.KVStashAlert)
+
+	// pendingAlerts holds the alerts raised before a handler was set
+	pendingAlerts []models.KVStashAlert
+
+	// hooks holds the hooks registered with AddHooks, replaced as a whole when one is added
+	hooks atomic.Pointer[[]Hooks]
+
+	// transforms is the chain of transformers applied to the values written, see SetTransformers
+	transforms atomic.Pointer[[]Transformer]
+}
+
+// Options configures a Store opened with Open
+// The zero value opens a store without automatic compaction
+type Options struct {
+	// TmpPath is the directory compacted data is written to before it replaces the database (default: dbPath + ".tmp")
+	// Ignored if the database was relocated, see Relocate
+	TmpPath string
+
+	// BackupPath is the directory the database is copied to before compaction (default: dbPath + ".bkp")
+	// If the database directory is missing but the backup exists, Open restores the backup
+	// Ignored if the database was relocated, see Relocate
+	BackupPath string
+
+	// AutoCompact starts periodic compaction in the background until the store is closed
+	AutoCompact bool
+
+	// CompactionInterval is the delay between compaction cycles (default: constants.CompactionInterval seconds)
+	CompactionInterval time.Duration
+
+	// Durability controls when writes reach stable storage (default: DurabilitySync)
+	Durability Durability
+
+	// Verification controls how much of a record Get and GetMany check (default: VerifyFull)
+	Verification Verification
+
+	// StartupCheck controls how much of every record is verified while the index is rebuilt (default: StartupCheckFast)
+	StartupCheck StartupCheck
+
+	// StartupRepair controls what happens to a corrupt sealed segment found while the index is rebuilt
+	// (default: StartupRepairFail), see Repairs
+	StartupRepair StartupRepair
+
+	// QuarantinePath is the directory the startup repair moves corrupt segments and databases to
+	// (default: dbPath + ".quarantine")
+	QuarantinePath string
+
+	// FailureThreshold is the number of consecutive failed writes that make the store read-only
+	// (default: constants.WriteFailureThreshold), see BreakerStats
+	FailureThreshold int
+
+	// MinFreeBytes is the free space on the database volume below which writes fail with ErrDiskFull
+	// and compaction is skipped (default: 0, disabled), see DiskStats
+	MinFreeBytes int64
+
+	// SoftLimit is the fraction of a limit past which writes are warned about (default: 0, disabled),
+	// see SoftLimitWarnings
+	SoftLimit float64
+
+	// KeyNormalization rewrites keys before they are stored or looked up (default: none)
+	KeyNormalization KeyNormalization
+
+	// MaxValueSize lowers the largest value writes accept (default: 0, constants.MaxValueSize); namespaces can lower
+	// it further, see Namespace.MaxValueSize
+	MaxValueSize int
+
+	// SegmentSizing bounds the number of writes after which the active log is rotated (default:
+	// constants.MinKeysPerSegment to constants.MaxKeysPerSegment), see SetSegmentSizing
+	SegmentSizing SegmentSizing
+
+	// Namespaces configures default TTLs and limits for groups of keys (default: none), see SetNamespaces
+	Namespaces []Namespace
+
+	// Clock tells the store the time (default: the system clock), see Clock
+	Clock Clock
+
+	// Retention is the age after which segments are dropped with their keys (default: 0, segments are kept),
+	// see SetRetention
+	Retention time.Duration
+
+	// InlineThreshold is the size in bytes up to which values are kept in the index too (default: 0, none are),
+	// see SetInlineThreshold
+	InlineThreshold int
+
+	// ReadCacheBytes is the memory in bytes the cache of values read from disk may take (default: 0, no cache),
+	// see SetReadCacheBytes
+	ReadCacheBytes int64
+
+	// Hooks are called on the store's operations (default: none), see AddHooks
+	Hooks []Hooks
+
+	// Transformers names the transformers applied to the values written, in order (default: none),
+	// see SetTransformers
+	Transformers []string
+
+	// IndexCheck configures the background check of the index against the segments (default: disabled),
+	// see SetIndexCheck
+	IndexCheck IndexCheck
+
+	// DeletionHistory is the file the tombstones are appended to before compaction or retention drops them
+	// (default: "", they are not kept), see SetDeletionHistory
+	DeletionHistory string
+
+	// DeletionHistoryRetention is the age after which deletions are pruned from the deletion history
+	// (default: 0, they are kept), see SetDeletionHistoryRetention
+	DeletionHistoryRetention time.Duration
+
+	// IndexSnapshotInterval is the delay between two snapshots of the index, which Open loads to only read the
+	// records written after the last one (default: 0, no snapshot is written or loaded), see SaveIndexSnapshot
+	IndexSnapshotInterval time.Duration
+
+	// compacting is the store compaction copies into the new store: the new store shares its clock and runs no
+	// background jobs
+	compacting *Store
+}
+
+// segmentFile represents a numbered segment file in the database
+type segmentFile struct {
+	// name is the filename (e.g., "seg0.log", "seg1.log")
+	name string
+
+	// num is the segment number extracted from the filename
+	num int
+}
+
+// ServerConfig holds the settings of the server's store, read from flags and environment variables at startup
+type ServerConfig struct {
+	// DBPath is the database directory (default: constants.DBPath)
+	DBPath string
+
+	// CompactionInterval is the delay between automatic compactions (default: constants.CompactionInterval seconds)
+	CompactionInterval time.Duration
+
+	// MaxValueSize lowers the largest value writes accept (default: 0, constants.MaxValueSize)
+	MaxValueSize int
+
+	// KeyNormalization rewrites keys, and must not change between runs on the same database
+	KeyNormalization KeyNormalization
+
+	// StartupCheck and StartupRepair control how the segments are checked and repaired while the index is built
+	StartupCheck  StartupCheck
+	StartupRepair StartupRepair
+
+	// IndexSnapshotInterval is the delay between two snapshots of the index, 0 disables them
+	IndexSnapshotInterval time.Duration
+}
+
+// NewStore creates and initializes the server's Store as cfg says
+// It builds the index by reading all existing segment files and initializes the writer for the active log
+// Creates the database directory if it doesn't exist
+// The database is compacted automatically; constants.DBPath uses constants.TmpDBPath, constants.BackupDBPath, and
+// constants.QuarantineDBPath as scratch directories, and other paths the defaults of Options next to the database
+// Writes are refused while less than constants.MinFreeDiskBytes are free on the database volume, and warned about
+// past constants.SoftLimitRatio of their limits; the index is checked against the segments every
+// constants.IndexCheckInterval seconds
+// Returns an error if the index cannot be built or the writer cannot be created
+func NewStore(cfg ServerConfig) (*Store, error) {
+	opts := Options{
+		AutoCompact:           true,
+		CompactionInterval:    cfg.CompactionInterval,
+		MaxValueSize:          cfg.MaxValueSize,
+		MinFreeBytes:          constants.MinFreeDiskBytes,
+		SoftLimit:             constants.SoftLimitRatio,
+		IndexCheck:            IndexCheck{Interval: constants.IndexCheckInterval * time.Second},
+		KeyNormalization:      cfg.KeyNormalization,
+		StartupCheck:          cfg.StartupCheck,
+		StartupRepair:         cfg.StartupRepair,
+		IndexSnapshotInterval: cfg.IndexSnapshotInterval,
+	}
+	if cfg.DBPath == "" || cfg.DBPath == constants.DBPath {
+		cfg.DBPath = constants.DBPath
+		opts.TmpPath, opts.BackupPath, opts.QuarantinePath = constants.TmpDBPath, constants.BackupDBPath, constants.QuarantineDBPath
+	}
+
+	s, err := Open(cfg.DBPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("NewStore: %w", err)
+	}
+
+	return s, nil
+}
+
+// Open creates and initializes a Store for the database directory dbPath
+// It restores the database from its backup if a compaction crashed mid-swap, builds the index
+// by reading all existing segment files, and initializes the writer for the active log
+// Creates the database directory if it doesn't exist
+// If the database was moved by Relocate, the directory it was moved to is opened instead
+// Returns an error if the index cannot be built or the writer cannot be created
+func Open(dbPath string, opts Options) (*Store, error) {
+	s := &Store{
+		index:            make(models.KVStashIndex),
+		dbPath:           dbPath,
+		segmentCount:     0,
+		nextSegment:      1,
+		activeLog:        "seg0.log",
+		feed:             newChangefeed(),
+		tmpPath:          opts.TmpPath,
+		backupPath:       opts.BackupPath,
+		quarantinePath:   opts.QuarantinePath,
+		durability:       opts.Durability,
+		failureThreshold: opts.FailureThreshold,
+		normalization:    opts.KeyNormalization,
+		clock:            opts.Clock,
+		files:            newHandlePool(constants.MaxOpenSegments),
+		sealed:           make(map[string]time.Time),
+		prefetchSlots:    make(chan struct{}, constants.PrefetchWorkers),
+		latency:          newLatencyHistograms(),
+		keys:             newKeyList(nil),
+		readCache:        newReadCache(),
+		filters:          make(map[string]*bloomFilter),
+		stop:             make(chan struct{}),
+	}
+
+	if s.tmpPath == "" {
+		s.tmpPath = filepath.Clean(dbPath) + ".tmp"
+	}
+	if s.backupPath == "" {
+		s.backupPath = filepath.Clean(dbPath) + ".bkp"
+	}
+	if s.quarantinePath == "" {
+		s.quarantinePath = filepath.Clean(dbPath) + ".quarantine"
+	}
+	if opts.CompactionInterval > 0 {
+		s.compactionInterval.Store(int64(opts.CompactionInterval))
+	} else {
+		s.compactionInterval.Store(int64(time.Second * constants.CompactionInterval))
+	}
+	if s.durability == "" {
+		s.durability = DurabilitySync
+	}
+	if s.failureThreshold <= 0 {
+		s.failureThreshold = constants.WriteFailureThreshold
+	}
+	if opts.IndexSnapshotInterval < 0 {
+		return nil, fmt.Errorf("Open: index snapshot interval must not be negative, got %v", opts.IndexSnapshotInterval)
+	}
+	if opts.compacting == nil {
+		s.indexSnapshotInterval = opts.IndexSnapshotInterval
+	}
+	switch {
+	case opts.MaxValueSize < 0 || opts.MaxValueSize > constants.MaxValueSize:
+		return nil, fmt.Errorf("Open: %w: %d bytes, the limit is %d", ErrBadMaxValueSize, opts.MaxValueSize, constants.MaxValueSize)
+	case opts.MaxValueSize == 0:
+		s.maxValueSize = constants.MaxValueSize
+	default:
+		s.maxValueSize = opts.MaxValueSize
+	}
+	if s.clock == nil {
+		s.clock = systemClock{}
+	}
+	s.hlc = newHybridClock(s.clock)
+	if opts.compacting != nil {
+		s.clock = opts.compacting.clock
+		s.hlc = opts.compacting.hlc
+	}
+	if err := s.SetSoftLimit(opts.SoftLimit); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if err := s.SetMinFreeBytes(opts.MinFreeBytes); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if err := s.SetRetention(opts.Retention); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if err := s.SetInlineThreshold(opts.InlineThreshold); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if err := s.SetReadCacheBytes(opts.ReadCacheBytes); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if err := s.SetTransformers(opts.Transformers); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if err := s.SetIndexCheck(opts.IndexCheck); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.SetDeletionHistory(opts.DeletionHistory)
+	if err := s.SetDeletionHistoryRetention(opts.DeletionHistoryRetention); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if _, err := ParseDurability(string(s.durability)); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.sizing.Sizing = opts.SegmentSizing.withDefaults()
+	if err := s.sizing.Sizing.validate(); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.sizing.Limit = s.sizing.Sizing.clamp(constants.KeysPerSegment)
+	verification := cmp.Or(opts.Verification, VerifyFull)
+	if _, err := ParseVerification(string(verification)); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.verification.Store(&verification)
+	s.startupCheck = cmp.Or(opts.StartupCheck, StartupCheckFast)
+	if _, err := ParseStartupCheck(string(s.startupCheck)); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.startupRepair = cmp.Or(opts.StartupRepair, StartupRepairFail)
+	if _, err := ParseStartupRepair(string(s.startupRepair)); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	for _, h := range opts.Hooks {
+		s.AddHooks(h)
+	}
+
+	relocated, err := resolveRelocation(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if relocated != dbPath {
+		s.dbPath = relocated
+		s.tmpPath = relocated + ".tmp"
+		s.backupPath = relocated + ".bkp"
+		s.quarantinePath = relocated + ".quarantine"
+	}
+
+	if err := s.finishSalvage(); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.restoreBackup()
+
+	// A relocated database that is missing is likely on a volume that is not mounted, not a new database
+	if _, err := os.Stat(s.dbPath); relocated != dbPath && err != nil {
+		return nil, fmt.Errorf("Open: %v was moved to %v: %w", dbPath, s.dbPath, err)
+	}
+
+	// Create database directory if it doesn't exist
+	if err := os.MkdirAll(s.dbPath, 0755); err != nil {
+		return nil, fmt.Errorf("Open: failed to create database directory: %w", err)
+	}
+
+	start := time.Now()
+	if err := s.buildIndex(s.loadIndexSnapshot()); err != nil {
+		return nil, fmt.Errorf("Open: failed to build index: %w", err)
+	}
+	if s.indexSnapshots.Loaded {
+		logging.Infof("Open: indexed %d keys from the index snapshot and %d bytes of records after it in %v",
+			len(s.index), s.indexSnapshots.Replayed, time.Since(start).Round(time.Millisecond))
+	} else {
+		logging.Infof("Open: indexed %d keys from %d segments in %v (startup check %v)",
+			len(s.index), s.segmentCount, time.Since(start).Round(time.Millisecond), s.startupCheck)
+	}
+
+	if err := s.discardUncommittedBatch(); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	if err := s.leaveSealedActiveLog(); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.loadSegmentAges()
+
+	if err := s.saveState(); err != nil {
+		return nil, fmt.Errorf("Open: failed to write superblock: %w", err)
+	}
+
+	if err := s.SetNamespaces(opts.Namespaces); err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	s.scheduleExpiries()
+
+	writer, err := s.openWriter(s.activeLog, s.durability)
+	if err != nil {
+		return nil, fmt.Errorf("Open: failed to create writer: %w", err)
+	}
+	s.writer = writer
+	s.startSegment()
+
+	if opts.AutoCompact {
+		go s.autoCompact()
+	}
+	if opts.compacting == nil {
+		go s.expireKeys()
+		go s.retainSegments()
+		go s.checkIndexPeriodically()
+		go s.buildSegmentFilters()
+	}
+	if s.indexSnapshotInterval > 0 {
+		go s.saveIndexSnapshots()
+	}
+
+	return s, nil
+}
+
+// validateKey checks the length of a key
+// Keys are arbitrary byte strings: newlines, NUL, and bytes that are not valid UTF-8 are all allowed,
+// and keys are compared, ordered, and prefix- or glob-matched byte by byte, with no Unicode normalization
+func validateKey(key string) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+
+	if len(key) > constants.MaxKeySize {
+		return fmt.Errorf("%w (%d bytes)", ErrKeyTooLarge, constants.MaxKeySize)
+	}
+
+	return nil
+}
+
+func validateValue(value string) error {
+	if len(value) > constants.MaxValueSize {
+		return fmt.Errorf("%w (%d bytes)", ErrValueTooLarge, constants.MaxValueSize)
+	}
+
+	return nil
+}
+
+func (s *Store) logRotation() error {
+	// A batch stays in one segment, so recovery sees its commit record next to the rest
+	if s.activeLogCount >= s.sizing.Limit && s.batch == nil {
+		s.resizeSegments()
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("logRotation: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// rotate closes the active log and continues in a new segment
+// Must be called with mu held
+func (s *Store) rotate() error {
+	if err := s.closeWriter(); err != nil {
+		return fmt.Errorf("rotate: failed to close active log - %v: %w", s.activeLog, err)
+	}
+
+	// The superblock names the new active log before it exists, so a crash in between leaves an empty active log
+	// rather than a segment the superblock does not know about
+	activeLog := segmentName(s.nextSegment)
+	if err := s.writeState(s.dbPath, s.nextSegment, s.nextSegment+1); err != nil {
+		return fmt.Errorf("rotate: failed to record new active log - %v: %w", activeLog, err)
+	}
+
+	writer, err := s.openWriter(activeLog, s.durability)
+	if err != nil {
+		return fmt.Errorf("rotate: failed to create new active log - %v: %w", activeLog, err)
+	}
+	s.writer = writer
+	s.startSegment()
+	s.sealed[s.activeLog] = s.now()
+	s.activeLog = activeLog
+	s.activeLogCount = 0
+	s.segmentCount++
+	s.nextSegment++
+
+	return nil
+}
+
+// Set stores a key-value pair in the store
+// The operation is thread-safe and validates key/value size limits
+// Automatically rotates to a new segment when the active log reaches the segment limit, see SegmentSizing
+// If the key was previously deleted (soft-deleted), this operation undeletes it by setting Deleted=false
+// The key expires after req.TTL seconds, at req.ExpireAt, or after the default TTL of its namespace, see Namespace
+// Returns validation errors (ErrEmptyKey, ErrKeyTooLarge, ErrValueTooLarge, ErrBadTTL, ErrBadExpireAt) for client
+// errors
+// Returns the version of the write, which clients can use as an ETag or read-your-writes token
+// Returns ErrNamespaceFull if the key's namespace is full and does not evict
+// Returns other errors for server-side failures
+func (s *Store) Set(req *models.KVStashRequest) (*models.KVStashVersion, error) {
+	if err := validateExpiry(req.TTL, req.ExpireAt); err != nil {
+		return nil, err
+	}
+
+	return s.set(req.Key, req.Value, time.Duration(req.TTL)*time.Second, expireAtTime(req.ExpireAt), req.Phases)
+}
+
+// SetWithTTL stores value under key like Set, with a time to live of ttl
+// A ttl of 0 applies the default TTL of the key's namespace and a negative ttl never expires
+func (s *Store) SetWithTTL(key string, value string, ttl time.Duration) (*models.KVStashVersion, error) {
+	return s.set(key, value, ttl, time.Time{}, nil)
+}
+
+// SetUntil stores value under key like Set, expiring at the absolute time at
+// A time in the past stores the value already expired
+func (s *Store) SetUntil(key string, value string, at time.Time) (*models.KVStashVersion, error) {
+	if at.IsZero() {
+		return nil, ErrBadExpireAt
+	}
+	return s.set(key, value, 0, at, nil)
+}
+
+// set validates and stores a string value expiring at at, or with a time to live of ttl if at is zero, see expiryFor
+// The time spent in each phase is reported in phases if it is not nil
+func (s *Store) set(key string, value string, ttl time.Duration, at time.Time, phases *models.KVStashPhases) (*models.KVStashVersion, error) {
+	t := s.startOp(OpSet)
+	defer t.finish(phases)
+
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	if err := s.validateValueFor(key, value); err != nil {
+		return nil, err
+	}
+
+	expiresAt := s.expiryFor(key, ttl)
+	if !at.IsZero() {
+		expiresAt = at.UnixMilli()
+	}
+
+	t.lock()
+	defer s.mu.Unlock()
+
+	if err := s.put(key, value, models.TypeString, expiresAt, t); err != nil {
+		return nil, fmt.Errorf("Set: %w", err)
+	}
+
+	return s.version(key), nil
+}
+
+// version returns the version of the last write to key
+// Must be called with mu held, right after the write
+func (s *Store) version(key string) *models.KVStashVersion {
+	// Every change is published with mu held, so the feed position is this write's
+	return newVersion(s.index[key], s.feed.position())
+}
+
+// newVersion returns the version of the write of entry published at pos
+func newVersion(entry *models.KVStashIndexEntry, pos WatchPosition) *models.KVStashVersion {
+	return &models.KVStashVersion{
+		Revision: entry.Revision,
+		Epoch:    pos.Epoch,
+		Seq:      pos.Seq,
+		Checksum: hex.EncodeToString(entry.Checksum[:]),
+		Segment:  entry.SegmentFile,
+		Offset:   entry.Offset,
+	}
+}
+
+// put appends a record holding value of type typ for key and points the index at it
+// The key expires at expiresAt in Unix milliseconds, or never if it is 0
+// Keys are evicted first if the key's namespace is full, see Namespace
+// The caller validates key and value and must hold mu; the write is timed by t, which may be nil
+func (s *Store) put(key string, value string, typ models.KVStashValueType, expiresAt int64, t *opTimer) error {
+	return s.putRevision(key, value, typ, expiresAt, 0, t)
+}
+
+// putRevision is put for a record written at revision, which copies of existing records keep
+// A revision of 0 assigns the next revision
+func (s *Store) putRevision(key string, value string, typ models.KVStashValueType, expiresAt int64, revision uint64, t *opTimer) error {
+	if err := s.checkWritable(); err != nil {
+		return fmt.Errorf("putRevision: %w", err)
+	}
+
+	if err := s.makeRoom(key, t); err != nil {
+		return fmt.Errorf("putRevision: %w", err)
+	}
+
+	if err := s.logRotation(); err != nil {
+		s.recordWrite(err)
+		return fmt.Errorf("putRevision: failed to rotate log: %w", err)
+	}
+
+	revision = s.nextRevision(revision)
+	stored, transforms, err := s.transform(value)
+	if err != nil {
+		return fmt.Errorf("putRevision: %w", err)
+	}
+	data, err := codec.EncodeRevisedPayload(key, stored, expiresAt, revision)
+	if err != nil {
+		return fmt.Errorf("putRevision: failed to serialize: %w", err)
+	}
+	flags, batched := s.batchFlags(append(typeFlags(typ), transformFlags(transforms)...))
+	start := time.Now()
+	metadata, err := s.appendRecord(data, flags)
+	t.add(phaseWrite, start)
+	s.recordWrite(err)
+	if err != nil {
+		s.failover(err)
+		return fmt.Errorf("putRevision: failed to write: %w", err)
+	}
+	s.amp.record(data)
+
+	s.setEntry(key, &models.KVStashIndexEntry{
+		SegmentFile: s.activeLog,
+		Offset:      metadata.Offset,
+		Size:        metadata.Size,
+		Checksum:    metadata.Checksum,
+		Deleted:     false,
+		Type:        typ,
+		ExpiresAt:   expiresAt,
+		Batch:       batched,
+		Revision:    revision,
+		Transforms:  transforms,
+		Inline:      s.inline(typ, value),
+	})
+	s.activeLogCount++
+	s.touch(key, true)
+	s.publish(models.EventSet, key, revision)
+	s.notifySet(SetInfo{Key: key, Value: value, Type: typ, ExpiresAt: expiresAt, Revision: revision})
+	logging.Debugf("putRevision: Added key=%v in segment=%v/%v", redact.Key(key), s.dbPath, s.activeLog)
+
+	return nil
+}
+
+// Delete removes a key from the store using a soft-delete approach with tombstones
+// The operation is thread-safe and writes a tombstone entry to the log with FlagDeleted
+// The key remains in the in-memory index but is marked with Deleted=true
+// This ensures that even when all keys are deleted, the index retains tombstone references
+// allowing compaction to properly clean up disk space
+//
+// Soft Delete Flow:
+//  1. Writes a tombstone record to the log with FlagDeleted marker
+//  2. Updates the index entry to point to the tombstone with Deleted=true
+//  3. During recovery, tombstones are replayed and entries are marked as deleted
+//  4. During compaction, entries with Deleted=true are skipped and not copied
+//  5. Physical disk space is reclaimed when old segments are removed during compaction
+//
+// Returns ErrKeyNotFound if the key doesn't exist or is already deleted (client error)
+// Returns validation errors (ErrEmptyKey, ErrKeyTooLarge) for client errors
+// Returns other errors for server-side failures
+func (s *Store) Delete(req *models.KVStashRequest) error {
+	t := s.startOp(OpDelete)
+	defer t.finish(req.Phases)
+
+	key := s.normalization.Key(req.Key)
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	t.lock()
+	defer s.mu.Unlock()
+
+	// Check if key exists and is neither deleted nor expired
+	if _, ok := s.lookup(key); !ok {
+		return ErrKeyNotFound
+	}
+
+	if err := s.tombstone(key, models.EventDelete, req.Subject, t); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+
+	return nil
+}
+
+// tombstone appends a tombstone for key, marks its index entry as deleted, and publishes event
+// The tombstone records when and why the key was deleted, and by whom if by is set, see DeletionHistory
+// The caller must hold mu; the write is timed by t, which may be nil
+func (s *Store) tombstone(key string, event string, by string, t *opTimer) error {
+	if err := s.checkWritable(); err != nil {
+		return fmt.Errorf("tombstone: %w", err)
+	}
+
+	if err := s.logRotation(); err != nil {
+		s.recordWrite(err)
+		return fmt.Errorf("tombstone: failed to rotate logs: %w", err)
+	}
+
+	// Marshal the key and the deletion's details to create the tombstone
+	revision := s.nextRevision(0)
+	data, err := codec.EncodeRevisedPayload(key, encodeTombstone(s.now(), event, by), 0, revision)
+	if err != nil {
+		return fmt.Errorf("tombstone: failed to serialize: %w", err)
+	}
+
+	// Write tombstone with FlagDeleted marker
+	flags, batched := s.batchFlags([]int64{constants.FlagDeleted})
+	start := time.Now()
+	metadata, err := s.appendRecord(data, flags)
+	t.add(phaseWrite, start)
+	s.recordWrite(err)
+	if err != nil {
+		s.failover(err)
+		return fmt.Errorf("tombstone: failed to delete: %w", err)
+	}
+	s.amp.record(data)
+
+	// Mark entry as deleted in the index (soft delete)
+	// The entry remains in the index to track the tombstone location
+	// This ensures compaction can identify and skip deleted entries
+	s.setEntry(key, &models.KVStashIndexEntry{
+		SegmentFile: s.activeLog,
+		Offset:      metadata.Offset,
+		Size:        metadata.Size,
+		Checksum:    metadata.Checksum,
+		Deleted:     true,
+		Batch:       batched,
+		Revision:    revision,
+	})
+	s.activeLogCount++
+	s.forget(key)
+	s.publish(event, key, revision)
+	logging.Debugf("Delete: deleted key=%v", redact.Key(key))
+
+	return nil
+}
+
+// Get retrieves the value for a given key from the store
+// The operation is thread-safe using a read lock on the index
+// If a checksum mismatch is detected, the corrupted entry is purged from the index
+// The record is checked at req.Verify, or the store's verification level if it is empty, see Verification
+// JSON documents are returned as they are stored, compact JSON
+// Returns ErrKeyNotFound for missing keys, ErrWrongType for keys holding a collection, and ErrBadVerification
+// for an unknown verification level (client errors)
+// Returns other errors for server-side failures
+func (s *Store) Get(req *models.KVStashRequest) (string, error) {
+	value, _, err := s.GetVersion(req)
+	return value, err
+}
+
+// GetVersion retrieves the value of a key like Get, along with the version of the write that stored it
+// The version carries the revision, checksum, segment, and offset of the write, not a changefeed position
+func (s *Store) GetVersion(req *models.KVStashRequest) (string, *models.KVStashVersion, error) {
+	start := time.Now()
+	value, entry, err := s.getEntry(req)
+	if len(s.registeredHooks()) > 0 {
+		s.notifyGet(GetInfo{
+			Key:     s.normalization.Key(req.Key),
+			Value:   value,
+			Err:     err,
+			Inline:  err == nil && entry.Inline != nil && req.Verify == "",
+			Elapsed: time.Since(start),
+		})
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return value, newVersion(entry, WatchPosition{}), nil
+}
+
+// getEntry retrieves the value of a key like Get, along with the index entry of the record holding it
+func (s *Store) getEntry(req *models.KVStashRequest) (string, *models.KVStashIndexEntry, error) {
+	t := s.startOp(OpGet)
+	defer t.finish(req.Phases)
+
+	verify, err := s.verificationFor(req.Verify)
+	if err != nil {
+		return "", nil, fmt.Errorf("getEntry: %w", err)
+	}
+
+	key := s.normalization.Key(req.Key)
+	t.rlock()
+	entry, ok := s.lookup(key)
+	dbPath := s.dbPath
+	s.mu.RUnlock()
+
+	if !ok {
+		s.readMisses.Add(1)
+		return "", nil, ErrKeyNotFound
+	}
+	if entry.Type != models.TypeString && entry.Type != models.TypeJSON {
+		return "", nil, fmt.Errorf("getEntry: %w (%v)", ErrWrongType, entry.Type)
+	}
+	if entry.Inline != nil && req.Verify == "" {
+		s.readHits.Add(1)
+		s.inlineHits.Add(1)
+		s.touch(key, false)
+		return *entry.Inline, entry, nil
+	}
+	if req.Verify == "" {
+		if value, ok := s.readCache.get(key, entry); ok {
+			s.readHits.Add(1)
+			s.touch(key, false)
+			return value, entry, nil
+		}
+	}
+
+	value, err := fetchValue(s.files, dbPath, entry.SegmentFile, entry.Offset, entry.Size, entryFlags(entry), entry.Checksum, verify, t)
+	if err != nil {
+		// Check if this is a checksum mismatch error
+		if errors.Is(err, ErrChecksumMismatch) {
+			// Purge the corrupted entry from the index
+			_ = s.Delete(req)
+			logging.Errorf("getEntry: purged corrupted entry for key=%v due to checksum mismatch", redact.Key(req.Key))
+			s.raiseAlert(models.AlertCorruption, models.SeverityCritical,
+				"checksum mismatch reading key=%v from %v, the key was deleted", redact.Key(key), entry.SegmentFile)
+			s.notifyCorruption(key, entry.SegmentFile, err)
+		}
+		return "", nil, fmt.Errorf("getEntry: %w", err)
+	}
+	// Counted once the value was read, so failed reads are not hits
+	s.readHits.Add(1)
+	if req.Verify == "" {
+		s.readCache.put(key, entry, value)
+	}
+	s.touch(key, false)
+
+	return value, entry, nil
+}
+
+// restoreBackup recovers the database from the compaction backup if the database directory is missing
+// This handles a crash during compaction after the old database was deleted but before the compacted one was renamed
+// Panics if the backup cannot be restored, since the database would be unrecoverable
+func (s *Store) restoreBackup() {
+	if _, err := os.Stat(s.dbPath); !os.IsNotExist(err) {
+		return
+	}
+
+	if _, err := os.Stat(s.backupPath); err != nil {
+		return
+	}
+
+	logging.Warnf("restoreBackup: database missing but backup exists, attempting recovery")
+	if err := copyDB(s.backupPath, s.dbPath); err != nil {
+		panic(fmt.Sprintf("restoreBackup: failed to restore from backup: %v", err))
+	}
+	if err := os.RemoveAll(s.backupPath); err != nil {
+		logging.Errorf("restoreBackup: failed to delete backup after recovery: %v", err)
+	}
+	logging.Infof("restoreBackup: successfully recovered from backup")
+	s.raiseAlert(models.AlertBackupRestored, models.SeverityCritical,
+		"%v was missing after an interrupted compaction and was restored from %v", s.dbPath, s.backupPath)
+}
+
+// buildIndex reconstructs the in-memory index by scanning all segment files
+// It reads all entries, validates metadata checksums only, and populates the index
+// With snap, an index snapshot that still covers the segments, the index is loaded from it and only the records
+// written after it are read, see useIndexSnapshot
+// Tolerates corruption in the active log; corruption in archived segments fails unless the startup repair policy
+// moves them aside, see repair
+// Returns an error if segment files cannot be opened or read
+func (s *Store) buildIndex(snap *indexSnapshot) error {
+	s.uncommittedBatch = -1
+	segments, revision, err := s.getSegmentFiles()
+	if err != nil {
+		return fmt.Errorf("buildIndex: failed fetch segment files: %w", err)
+	}
+
+	if snap != nil && !s.useIndexSnapshot(snap, segments) {
+		snap = nil
+	}
+	s.indexSnapshots.Loaded = snap != nil
+	s.indexSnapshots.Replayed = 0
+
+	for _, segment := range segments {
+		from := snap.replayFrom(segment)
+		if from < 0 {
+			continue
+		}
+
+		file, err := os.OpenFile(filepath.Join(s.dbPath, segment), os.O_RDONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("buildIndex: failed to open file: %w", err)
+		}
+		if info, err := file.Stat(); err == nil {
+			s.indexSnapshots.Replayed += max(info.Size()-from, 0)
+		}
+
+		if err := s.readSegment(file, segment, from); err != nil {
+			// don't tolerate checksum corruption in non-active log
+			if segment != s.activeLog {
+				s.index = make(models.KVStashIndex)
+				file.Close()
+				err = fmt.Errorf("buildIndex: non-active log corrupted - %v: %w", segment, err)
+				switch s.startupRepair {
+				case StartupRepairSkip, StartupRepairSalvage:
+					return s.repair(segment, err)
+				default:
+					return err
+				}
+			}
+
+			logging.Errorf("buildIndex: %v", err)
+			s.raiseAlert(models.AlertCorruption, models.SeverityCritical,
+				"the active log %v has a corrupt record, the records after it were not loaded: %v", segment, err)
+			s.notifyCorruption("", segment, err)
+		}
+		file.Close()
+	}
+
+	// Applied after the records, so records written before revisions are numbered the same on every startup
+	s.revision = max(s.revision, revision)
+	s.indexKeys()
+
+	if s.renormalized > 0 {
+		logging.Infof("buildIndex: normalized the keys of %d records (%v); keys that became equal resolve to their latest write",
+			s.renormalized, s.normalization)
+	}
+
+	return nil
+}
+
+// Close stops automatic compaction and expiry notifications, writes a last index snapshot if they are enabled, closes
+// the active log, and releases resources
+// The store must not be used after Close
+func (s *Store) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	if s.indexSnapshotInterval > 0 {
+		if err := s.SaveIndexSnapshot(); err != nil {
+			logging.Errorf("Close: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files.closeAll()
+	return s.closeWriter()
+}
+
+// closeWriter closes the active log writer
+// Must be called with mu held (or before the store is shared)
+func (s *Store) closeWriter() error {
+	if s.writer != nil {
+		err := s.writer.Close()
+		if err == nil {
+			s.writer = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// getSegmentFiles scans the database directory and returns an ordered list of segment files
+// It returns segment files sorted by their numeric suffix (seg0.log, seg1.log, ...)
+// Also sets the active log and the next segment number from the superblock, or from the highest numbered
+// segment if the superblock is missing, corrupt, or older than the segments found
+// This ensures entries are read in chronological order during index building
+// Also returns the revision recorded in the superblock, 0 without a valid one
+// Returns ErrNewerFormat if the database was written by a newer version
+func (s *Store) getSegmentFiles() ([]string, uint64, error) {
+	matches, err := listSegments(s.dbPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("getSegmentFiles: %w", err)
+	}
+	s.segmentCount = len(matches)
+
+	highest := -1
+	if len(matches) > 0 {
+		highest = segmentNumber(matches[len(matches)-1])
+	}
+
+	sb, ok, err := readSuperblock(s.dbPath)
+	if ok && sb.clock > 0 {
+		s.hlc.floor(time.UnixMilli(sb.clock))
+	}
+	switch {
+	case errors.Is(err, ErrNewerFormat):
+		return nil, 0, fmt.Errorf("getSegmentFiles: %w", err)
+	case err != nil:
+		logging.Warnf("getSegmentFiles: ignoring superblock, the active log is inferred from the segment files: %v", err)
+	case ok && highest > sb.activeSegment:
+		logging.Warnf("getSegmentFiles: superblock names %v as active log but %v exists, the active log is inferred from the segment files",
+			segmentName(sb.activeSegment), matches[len(matches)-1])
+	case ok:
+		s.activeLog = segmentName(sb.activeSegment)
+		s.nextSegment = sb.nextSegment
+		return matches, sb.revision, nil
+	}
+
+	s.activeLog = segmentName(max(highest, 0))
+	s.nextSegment = max(highest, 0) + 1
+
+	return matches, sb.revision, nil
+}
+
+// readSegment reads the entries of a segment file from offset from and populates the index
+// It validates metadata checksums and returns an error on the first corrupted entry
+// If reading the active log, it also increments activeLogCount for each entry found
+// Records of a batch are held back until its commit record is read and dropped if it is missing, see CheckAndSet
+// Returns an error if the file cannot be read or contains invalid data
+func (s *Store) readSegment(file *os.File, segment string, from int64) error {
+	if file == nil {
+		return fmt.Errorf("readSegment: nil file %v", segment)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("readSegment: failed to stat file: %w", err)
+	}
+
+	// pending holds the records of a batch whose commit record was not read yet
+	type pendingRecord struct {
+		key   string
+		entry *models.KVStashIndexEntry
+	}
+	var pending []pendingRecord
+	batchStart := int64(0)
+
+	end, err := segmentEnd(s.dbPath, segment, info.Size())
+	if err != nil {
+		return fmt.Errorf("readSegment: %w", err)
+	}
+
+	for pos := from; ; {
+		rec, err := s.loadRecord(file, end, pos, segment)
+
+		// clean EOF
+		if err == io.EOF {
+			if len(pending) > 0 {
+				logging.Warnf("readSegment: discarded %d records of a batch at offset %d of %v that was not committed",
+					len(pending), batchStart, segment)
+				if s.activeLog == segment {
+					s.uncommittedBatch = batchStart
+				}
+			}
+			return nil
+		}
+
+		if err != nil {
+			if len(pending) > 0 {
+				logging.Warnf("readSegment: discarded %d records of a batch at offset %d of %v before a corrupt record",
+					len(pending), batchStart, segment)
+			}
+			return fmt.Errorf("readSegment: %w", err)
+		}
+
+		// Add or update the entry in the index
+		// For tombstones (FlagDeleted=true), this creates an entry with Deleted=true
+		// For normal entries (FlagDeleted=false), this creates/updates an entry with Deleted=false
+		// Later entries in the log take precedence (e.g., a SET after DELETE undeletes the key)
+		key := s.normalization.Key(rec.data.Key)
+		if key != rec.data.Key {
+			s.renormalized++
+		}
+		logging.Debugf("readSegment: read key=%v (deleted=%v)", redact.Key(key), rec.deleted())
+		entry := &models.KVStashIndexEntry{
+			SegmentFile: segment,
+			Offset:      rec.metadata.Offset,
+			Size:        rec.metadata.Size,
+			Checksum:    rec.metadata.Checksum,
+			Deleted:     rec.deleted(),
+			Type:        rec.valueType(),
+			ExpiresAt:   rec.expiresAt,
+			Batch:       rec.metadata.GetMetadataFlagValue(constants.FlagBatch),
+			Revision:    s.nextRevision(rec.revision),
+			Transforms:  transformsOf(rec.metadata.Flags),
+		}
+		if !entry.Deleted && !rec.expiry() {
+			entry.Inline = s.loadInline(rec, segment)
+		}
+
+		if entry.Batch {
+			if len(pending) == 0 {
+				batchStart = rec.start
+			}
+			pending = append(pending, pendingRecord{key, entry})
+			pos = rec.end()
+			continue
+		}
+
+		// A record without FlagBatch commits the batch before it
+		for _, p := range pending {
+			s.index[p.key] = p.entry
+		}
+		if rec.expiry() {
+			// An expiry record keeps the value before it, with a new expiry time, see Expire
+			if prev := s.index[key]; prev != nil && !prev.Deleted {
+				s.index[key] = withExpiry(prev, rec.expiresAt, entry.Revision)
+			}
+		} else {
+			s.index[key] = entry
+		}
+
+		if s.activeLog == segment {
+			s.activeLogCount += len(pending) + 1
+		}
+		pending = pending[:0]
+
+		pos = rec.end()
+	}
+}
+
+// loadRecord reads the record at pos of segment for readSegment, verifying it as far as the startup check asks for
+// Returns io.EOF if pos is exactly the end of the file
+func (s *Store) loadRecord(r io.ReaderAt, fileSize int64, pos int64, segment string) (*record, error) {
+	switch s.startupCheck {
+	case StartupCheckNone:
+		return readTrustedRecord(r, fileSize, pos)
+	case StartupCheckFull:
+		rec, err := readRecord(r, fileSize, pos)
+		if err != nil {
+			return nil, err
+		}
+		if err := rec.validateChecksum(segment); err != nil {
+			return nil, fmt.Errorf("loadRecord: %w", err)
+		}
+		return rec, nil
+	}
+
+	return readRecord(r, fileSize, pos)
+}
+
+// autoCompact runs periodic compaction to reclaim disk space and optimize storage
+// This goroutine is started by Open when Options.AutoCompact is set and ends when the store is closed
+//
+// Compaction Process:
+//  1. Creates a backup of the current database to backupPath
+//  2. Creates a new store at tmpPath
+//  3. Copies all current key-value pairs from the old store to the new store
+//     (this eliminates old values for updated keys and defragments the data)
+//  4. Attempts to replace the old database with the compacted one:
+//     - Closes the old store writer
+//     - Deletes the old database directory
+//     - Renames tmpPath to dbPath
+//  5. On success: Updates store references and cleans up backup
+//  6. On failure: Recovers from backup and panics if recovery fails
+//
+// Lock Strategy:
+// The store mutex (oldStore.mu) is held for the entire compaction cycle to prevent
+// concurrent reads/writes during the database swap operation. This ensures data consistency
+// but blocks all Get/Set operations during compaction.
+//
+// Error Handling:
+// - Backup creation failure: Skip this compaction cycle and retry next interval
+// - New store creation failure: Skip this compaction cycle and retry next interval
+// - Data copy failure: Clean up resources (newStore, tmpPath, backupPath) and retry next cycle
+// - Database swap failure: Attempt recovery from backup, panic if recovery fails
+// - Recovery failure: Panic (database is in inconsistent state, cannot continue safely)
+//
+// Resource Cleanup:
+// - On success: backupPath is removed
+// - On copy failure: newStore, tmpPath, and backupPath are cleaned up
+// - On swap failure with successful recovery: tmpPath is removed, backup is restored
+//
+// This function runs in a loop with compactionInterval delays between cycles until the store is closed.
+func (oldStore *Store) autoCompact() {
+	for oldStore.sleep(JobCompaction, oldStore.CompactionInterval()) {
+		oldStore.compact(TriggerInterval)
+	}
+}
+
+// Compact runs a compaction cycle now, recorded in the compaction history with TriggerManual
+// Unlike automatic cycles, it runs while compaction is paused and whatever the garbage ratio
+// Returns ErrCompactionSkipped if the cycle could not start, e.g. because snapshots are open,
+// or the error that made the cycle keep the old database
+func (s *Store) Compact() error {
+	if err := s.compact(TriggerManual); err != nil {
+		return fmt.Errorf("Compact: %w", err)
+	}
+	return nil
+}
+
+// compact runs a compaction cycle started by trigger, see autoCompact
+// The pause and the minimum garbage ratio only apply to TriggerInterval
+func (oldStore *Store) compact(trigger string) error {
+	// Checked before taking the lock, so a paused store never blocks on compaction
+	if reason := oldStore.compactionPaused(); reason != "" && trigger == TriggerInterval {
+		logging.Debugf("compact: skipping cycle, %v", reason)
+		oldStore.compactionSkipped(trigger, reason)
+		return fmt.Errorf("compact: %w: %v", ErrCompactionSkipped, reason)
+	}
+
+	// Compaction rewrites the live records into new segments, which would restart their age
+	if oldStore.Retention() > 0 && trigger == TriggerInterval {
+		logging.Debugf("compact: skipping cycle, segment retention is set")
+		oldStore.compactionSkipped(trigger, "segment retention is set")
+		return fmt.Errorf("compact: %w: segment retention is set", ErrCompactionSkipped)
+	}
+
+	oldStore.mu.Lock()
+	// Close may have run while waiting for the lock
+	select {
+	case <-oldStore.stop:
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w: store is closed", ErrCompactionSkipped)
+	default:
+	}
+
+	// Snapshots reference the current segment files, so they must not be swapped out
+	if oldStore.openSnapshots > 0 {
+		logging.Infof("compact: skipping cycle, %d open snapshots", oldStore.openSnapshots)
+		oldStore.compactionSkipped(trigger, fmt.Sprintf("%d open snapshots", oldStore.openSnapshots))
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w: %d open snapshots", ErrCompactionSkipped, oldStore.openSnapshots)
+	}
+
+	// The relocation copies the segment files, which compaction would replace underneath it
+	if oldStore.relocating {
+		logging.Infof("compact: skipping cycle, relocation in progress")
+		oldStore.compactionSkipped(trigger, "relocation in progress")
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w: relocation in progress", ErrCompactionSkipped)
+	}
+
+	// A failing disk would only make compaction fail halfway, or worse, fail the swap
+	if oldStore.Degraded() {
+		logging.Infof("compact: skipping cycle, store is degraded")
+		oldStore.compactionSkipped(trigger, "store is degraded")
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w: store is degraded", ErrCompactionSkipped)
+	}
+
+	bytesBefore, _ := dirSize(oldStore.dbPath)
+	if !oldStore.compactionFits(bytesBefore) {
+		oldStore.compactionSkipped(trigger, "not enough free disk space")
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w: not enough free disk space", ErrCompactionSkipped)
+	}
+
+	// Rewriting a database that is mostly live keys holds the lock for little gain
+	if reason := oldStore.garbageReason(bytesBefore); reason != "" && trigger == TriggerInterval {
+		logging.Debugf("compact: skipping cycle, %v", reason)
+		oldStore.compactionSkipped(trigger, reason)
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w: %v", ErrCompactionSkipped, reason)
+	}
+
+	run := oldStore.compactionStarted(trigger, bytesBefore)
+
+	// failure is the first error that made the cycle keep the old database
+	var failure error
+
+	// Step 1: Create backup before any modifications
+	if err := copyDB(oldStore.dbPath, oldStore.backupPath); err != nil {
+		logging.Errorf("compact: backup failed: %v", err)
+		failure = fmt.Errorf("backup failed: %w", err)
+		oldStore.compactionFinished(run, failure)
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w", failure)
+	}
+	oldStore.amp.backup.Add(bytesBefore)
+
+	// Step 2: Create new store at temporary location
+	// Note: the new store is opened without its own compaction goroutine
+	// The copy runs far faster than the writes it replays, so the segments keep the current limit
+	limit := oldStore.sizing.Limit
+	newStore, err := Open(oldStore.tmpPath, Options{
+		Durability:      oldStore.durability,
+		SegmentSizing:   SegmentSizing{MinKeys: limit, MaxKeys: limit},
+		InlineThreshold: oldStore.InlineThreshold(),
+		Transformers:    oldStore.Transformers(),
+		compacting:      oldStore,
+	})
+	if err != nil {
+		logging.Errorf("compact: creating new store failed: %v", err)
+		failure = fmt.Errorf("creating new store failed: %w", err)
+		oldStore.compactionFinished(run, failure)
+		oldStore.mu.Unlock()
+		return fmt.Errorf("compact: %w", failure)
+	}
+
+	// Expired keys are not copied, so they are announced before they are gone
+	oldStore.announceExpired(len(oldStore.expiries))
+
+	// Step 3: Group keys by segment file for efficient reading
+	// This allows us to read from each segment file sequentially
+	var keysGroupedBySegments map[string][]string = make(map[string][]string)
+	for key, entry := range oldStore.index {
+		segment := entry.SegmentFile
+		_, ok := keysGroupedBySegments[segment]
+		if !ok {
+			keysGroupedBySegments[segment] = make([]string, 0)
+		}
+
+		keysGroupedBySegments[segment] = append(keysGroupedBySegments[segment], key)
+	}
+
+	copySuccess := true
+
+	// Step 4: Copy all current key-value pairs to the new store
+	// This excludes entries marked with Deleted=true (soft-deleted keys) and expired keys
+	// Even if all keys are deleted, the index still contains tombstone entries
+	// which are skipped here, allowing compaction to clean up the disk space
+	now := oldStore.now().UnixMilli()
+compactLoop:
+	for _, keys := range keysGroupedBySegments {
+		noOfKeys := len(keys)
+		for i := range noOfKeys {
+			key := keys[i]
+
+			entry := oldStore.index[key]
+
+			// Skip soft-deleted entries (tombstones)
+			// These entries remain in the index but won't be copied to the new store
+			// This is how deleted and expired keys are permanently removed during compaction
+			if !live(entry, now) {
+				continue
+			}
+
+			// Fetch the current value from the old store
+			start := time.Now()
+			value, err := fetchValue(oldStore.files, oldStore.dbPath, entry.SegmentFile, entry.Offset, entry.Size, entryFlags(entry), entry.Checksum, VerifyFull, nil)
+			oldStore.segmentIO.of(entry.SegmentFile).compactionRead.Observe(time.Since(start))
+			if err != nil {
+				logging.Errorf("compact: failed to fetch %v: %v", redact.Key(key), err)
+				failure = fmt.Errorf("failed to fetch %v: %w", redact.Key(key), err)
+				copySuccess = false
+				break compactLoop
+			}
+
+			// Write the key-value pair to the new store, keeping its type, expiry, and revision
+			// newStore is not shared yet, so its lock is not needed
+			start = time.Now()
+			err = newStore.putRevision(key, value, entry.Type, entry.ExpiresAt, entry.Revision, nil)
+			oldStore.segmentIO.of(newStore.activeLog).compactionWrite.Observe(time.Since(start))
+			if err != nil {
+				logging.Errorf("compact: failed to set key in new store %v: %v", redact.Key(key), err)
+				failure = fmt.Errorf("failed to set key in new store %v: %w", redact.Key(key), err)
+				copySuccess = false
+				break compactLoop
+			}
+		}
+	}
+
+	oldStore.amp.compaction.Add(newStore.amp.logged.Load())
+
+	// The revisions of the dropped tombstones and overwritten values must not be reused
+	if copySuccess {
+		newStore.revision = oldStore.revision
+		if err := newStore.saveState(); err != nil {
+			logging.Errorf("compact: failed to record the revision in new store: %v", err)
+			failure = fmt.Errorf("failed to record the revision in new store: %w", err)
+			copySuccess = false
+		}
+	}
+
+	// The tombstones are not copied, so they are kept in the deletion history before they are gone
+	if copySuccess {
+		segments, err := listSegments(oldStore.dbPath)
+		if err == nil {
+			err = oldStore.archiveTombstones(segments)
+		}
+		if err != nil {
+			logging.Errorf("compact: failed to archive tombstones: %v", err)
+			failure = fmt.Errorf("failed to archive tombstones: %w", err)
+			copySuccess = false
+		}
+	}
+
+	if copySuccess {
+		recover := false
+
+		// The open handles point at segment files that are about to be replaced
+		oldStore.files.closeAll()
+
+		// Close old store writer to release file handles
+		if err := oldStore.closeWriter(); err != nil {
+			logging.Errorf("compact: failed to close old store writer: %v", err)
+			failure = cmp.Or(failure, fmt.Errorf("failed to close old store writer: %w", err))
+			recover = true
+		}
+
+		// Close new store writer before rename (Windows requires this)
+		if err := newStore.Close(); err != nil {
+			logging.Errorf("compact: failed to close new store writer: %v", err)
+			failure = cmp.Or(failure, fmt.Errorf("failed to close new store writer: %w", err))
+			recover = true
+		}
+
+		// The segments are replaced, or restored from the backup if the swap fails
+		oldStore.dropSegmentFilters()
+
+		// Remove old database directory
+		if err := os.RemoveAll(oldStore.dbPath); err != nil {
+			logging.Errorf("compact: failed delete old store: %v", err)
+			failure = cmp.Or(failure, fmt.Errorf("failed to delete old store: %w", err))
+			recover = true
+		}
+
+		// Rename tmp database to main database location
+		if err := os.Rename(oldStore.tmpPath, oldStore.dbPath); err != nil {
+			logging.Errorf("compact: failed to rename tmp db: %v", err)
+			failure = cmp.Or(failure, fmt.Errorf("failed to rename tmp db: %w", err))
+			recover = true
+		}
+
+		if recover {
+			// Clean up temporary database directory
+			if err := os.RemoveAll(oldStore.tmpPath); err != nil {
+				logging.Errorf("compact: failed to remove tmp db: %v", err)
+			}
+
+			// Copy backup DB back to active DB
+			if err := copyDB(oldStore.backupPath, oldStore.dbPath); err != nil {
+				panic(err)
+			}
+
+			// Recreate writer for the restored database
+			writer, err := oldStore.openWriter(oldStore.activeLog, oldStore.durability)
+			if err != nil {
+				panic(err)
+			}
+			oldStore.writer = writer
+		} else {
+			// Success path - rename succeeded, newStore is now at dbPath
+			// Reopen the writer at the new location
+			writer, err := oldStore.openWriter(newStore.activeLog, oldStore.durability)
+			if err != nil {
+				logging.Errorf("compact: failed to reopen writer after rename: %v", err)
+				failure = fmt.Errorf("failed to reopen writer after rename: %w", err)
+				// Try to recover from backup
+				if err := copyDB(oldStore.backupPath, oldStore.dbPath); err != nil {
+					panic(err)
+				}
+				writer, err = oldStore.openWriter(oldStore.activeLog, oldStore.durability)
+				if err != nil {
+					panic(err)
+				}
+				oldStore.writer = writer
+			} else {
+				// Successfully reopened writer, update store references
+				oldStore.index = newStore.index
+				oldStore.keys = newStore.keys
+				oldStore.readCache.clear()
+				oldStore.expiries = newStore.expiries
+				oldStore.activeLog = newStore.activeLog
+				oldStore.activeLogCount = newStore.activeLogCount
+				oldStore.segmentCount = newStore.segmentCount
+				oldStore.nextSegment = newStore.nextSegment
+				oldStore.writer = writer
+				oldStore.startSegment()
+				oldStore.sealed = make(map[string]time.Time)
+				for segment := range newStore.sealed {
+					oldStore.sealed[segment] = oldStore.now()
+				}
+
+				// Clean up backup after successful compaction
+				if err := os.RemoveAll(oldStore.backupPath); err != nil {
+					logging.Errorf("compact: failed to delete backup: %v", err)
+				}
+
+				logging.Infof("compact: done")
+			}
+		}
+	} else {
+		if err := newStore.Close(); err != nil {
+			logging.Errorf("compact: failed to close new store writer: %v", err)
+		}
+
+		if err := os.RemoveAll(oldStore.backupPath); err != nil {
+			logging.Errorf("compact: failed delete - %v: %v", oldStore.backupPath, err)
+		}
+
+		if err := os.RemoveAll(oldStore.tmpPath); err != nil {
+			logging.Errorf("compact: failed to delete - %v: %v", oldStore.tmpPath, err)
+		}
+
+		logging.Warnf("compact: skipping store replacement")
+	}
+
+	oldStore.compactionFinished(run, failure)
+	oldStore.mu.Unlock()
+	if failure != nil {
+		return fmt.Errorf("compact: %w", failure)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrBadSuperblock is returned for a superblock that is truncated, fails its checksum, or is not a superblock
+var ErrBadSuperblock = errors.New("invalid superblock")
+
+// ErrNewerFormat is returned for a database written by a newer version of kvstash
+var ErrNewerFormat = errors.New("database format is newer than this version supports")
+
+// superblock records the segment state of a database, so that startup does not depend on the segment numbering
+// found in the directory, which has gaps once segments are archived or only partially copied
+type superblock struct {
+	// version is the database format version, see constants.FormatVersion
+	version uint32
+
+	// activeSegment is the number of the active log
+	activeSegment int
+
+	// nextSegment is the number of the segment created by the next log rotation
+	nextSegment int
+
+	// revision is at least the last revision assigned, see Store.revision
+	// Records carry their revision too; this keeps it when the records with the highest revisions were compacted away
+	revision uint64
+
+	// clock is the store's time when the superblock was written in Unix milliseconds, 0 if unknown, see hybridClock
+	clock int64
+}
+
+// encode returns the on-disk form of the superblock, see constants.SuperblockSize
+func (sb superblock) encode() []byte {
+	buf := make([]byte, constants.SuperblockSize)
+	copy(buf[0:4], constants.SuperblockMagic)
+	binary.BigEndian.PutUint32(buf[4:8], sb.version)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(sb.activeSegment))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(sb.nextSegment))
+	binary.BigEndian.PutUint64(buf[24:32], sb.revision)
+	binary.BigEndian.PutUint64(buf[32:40], uint64(sb.clock))
+	sum := sha256.Sum256(buf[:40])
+	copy(buf[40:], sum[:])
+	return buf
+}
+
+// decodeSuperblock parses the on-disk form of a superblock
+// Superblocks written before revisions are read with a revision of 0, and those written before the clock high-water
+// mark with a clock of 0
+// Returns ErrBadSuperblock if buf is not a valid superblock and ErrNewerFormat if it was written by a newer version
+func decodeSuperblock(buf []byte) (superblock, error) {
+	if (len(buf) != constants.SuperblockSize && len(buf) != constants.RevisionSuperblockSize &&
+		len(buf) != constants.LegacySuperblockSize) ||
+		string(buf[0:4]) != constants.SuperblockMagic {
+		return superblock{}, fmt.Errorf("decodeSuperblock: %w: bad size or magic", ErrBadSuperblock)
+	}
+
+	fields := len(buf) - sha256.Size
+	sum := sha256.Sum256(buf[:fields])
+	if !bytes.Equal(sum[:], buf[fields:]) {
+		return superblock{}, fmt.Errorf("decodeSuperblock: %w: checksum mismatch", ErrBadSuperblock)
+	}
+
+	sb := superblock{
+		version:       binary.BigEndian.Uint32(buf[4:8]),
+		activeSegment: int(binary.BigEndian.Uint64(buf[8:16])),
+		nextSegment:   int(binary.BigEndian.Uint64(buf[16:24])),
+	}
+	if len(buf) >= constants.RevisionSuperblockSize {
+		sb.revision = binary.BigEndian.Uint64(buf[24:32])
+	}
+	if len(buf) == constants.SuperblockSize {
+		sb.clock = int64(binary.BigEndian.Uint64(buf[32:40]))
+	}
+	if sb.version > constants.FormatVersion {
+		return superblock{}, fmt.Errorf("decodeSuperblock: %w: version %d, supported up to %d",
+			ErrNewerFormat, sb.version, constants.FormatVersion)
+	}
+	if sb.activeSegment < 0 || sb.nextSegment <= sb.activeSegment {
+		return superblock{}, fmt.Errorf("decodeSuperblock: %w: active segment %d, next segment %d",
+			ErrBadSuperblock, sb.activeSegment, sb.nextSegment)
+	}
+
+	return sb, nil
+}
+
+// readSuperblock reads the superblock of dbPath
+// Returns false without an error if the database has no superblock, e.g. it was created by an older version
+func readSuperblock(dbPath string) (superblock, bool, error) {
+	buf, err := os.ReadFile(filepath.Join(dbPath, constants.SuperblockName))
+	if errors.Is(err, os.ErrNotExist) {
+		return superblock{}, false, nil
+	}
+	if err != nil {
+		return superblock{}, false, fmt.Errorf("readSuperblock: %w", err)
+	}
+
+	sb, err := decodeSuperblock(buf)
+	if err != nil {
+		return superblock{}, false, fmt.Errorf("readSuperblock: %w", err)
+	}
+	return sb, true, nil
+}
+
+// writeSuperblock replaces the superblock of dbPath atomically: the new superblock is written and synced to a
+// temporary file, renamed over the old one, and the directory is synced so the rename survives a crash
+func writeSuperblock(dbPath string, sb superblock) error {
+	path := filepath.Join(dbPath, constants.SuperblockName)
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("writeSuperblock: %w", err)
+	}
+	if _, err := file.Write(sb.encode()); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("writeSuperblock: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("writeSuperblock: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writeSuperblock: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writeSuperblock: %w", err)
+	}
+
+	dir, err := os.Open(dbPath)
+	if err != nil {
+		return fmt.Errorf("writeSuperblock: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("writeSuperblock: failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// segmentName returns the filename of segment number num
+func segmentName(num int) string {
+	return constants.SegmentNamePrefix + strconv.Itoa(num) + constants.SegmentNameExt
+}
+
+// segmentNumber returns the number of a segment filename
+func segmentNumber(name string) int {
+	num, _ := strconv.Atoi(name[len(constants.SegmentNamePrefix) : len(name)-len(constants.SegmentNameExt)])
+	return num
+}
+
+// saveState writes the store's active segment, next segment number, revision, and time to the superblock
+// Must be called with mu held (or before the store is shared)
+func (s *Store) saveState() error {
+	if err := s.writeState(s.dbPath, segmentNumber(s.activeLog), s.nextSegment); err != nil {
+		return fmt.Errorf("saveState: %w", err)
+	}
+	return nil
+}
+
+// writeState writes the superblock of dbPath with activeSegment, nextSegment, and the store's revision and time,
+// and records the time as the clock's high-water mark
+// Must be called with mu held (or before the store is shared)
+func (s *Store) writeState(dbPath string, activeSegment int, nextSegment int) error {
+	now := s.now()
+	if err := writeSuperblock(dbPath, superblock{
+		version:       constants.FormatVersion,
+		activeSegment: activeSegment,
+		nextSegment:   nextSegment,
+		revision:      s.revision,
+		clock:         now.UnixMilli(),
+	}); err != nil {
+		return fmt.Errorf("writeState: %w", err)
+	}
+	s.hlc.saved(now)
+	return nil
+}
@@ -0,0 +1,288 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+Value transformers:
+
+A Transformer rewrites values on their way to disk and back, e.g. to compress or encrypt them or to store them in a
+custom encoding. Transformers are registered once per process with RegisterTransformer, usually from an init
+function, and a store applies its chain of transformers, see SetTransformers, to every value it writes, in order.
+The IDs of the transformers applied are recorded in the record's flags, from bit constants.FlagTransforms on, so
+reads undo them in reverse order whatever the chain is at the time: the chain can change while the store runs, and
+compaction rewrites the live values with the current chain.
+
+Only values are transformed; keys, expiry times, and revisions are not, so the index is rebuilt without decoding
+values. The value checksum covers the value as stored. constants.MaxValueSize applies before values are transformed,
+and inline values, hooks, iterators, and exports see values as they were written.
+
+The deflate transformer is built in. IDs below constants.MinCustomTransformID are reserved for transformers shipped
+with KVStash. A database holding values written by a transformer can only be read where that transformer is
+registered under the same ID, kvstash-admin included.
+*/
+
+var (
+	// ErrBadTransformer is returned for a transformer that cannot be registered or a chain that cannot be applied
+	ErrBadTransformer = errors.New("invalid value transformer")
+
+	// ErrUnknownTransformer is returned when reading a value written by a transformer that is not registered
+	ErrUnknownTransformer = errors.New("value written by an unknown transformer")
+)
+
+// Transformer encodes values before they are written and decodes them after they are read
+// Its methods may be called concurrently
+type Transformer interface {
+	// Name names the transformer in SetTransformers and the configuration file
+	Name() string
+
+	// ID identifies the transformer in the records it encoded, from 1 to 255; it must never change
+	ID() uint8
+
+	// Encode returns the stored form of value
+	Encode(value []byte) ([]byte, error)
+
+	// Decode returns the value whose stored form is data, reversing Encode
+	Decode(data []byte) ([]byte, error)
+}
+
+// transformers holds the registered transformers by ID and by name
+var transformers = struct {
+	sync.RWMutex
+	byID   map[uint8]Transformer
+	byName map[string]Transformer
+}{
+	byID:   map[uint8]Transformer{},
+	byName: map[string]Transformer{},
+}
+
+func init() {
+	if err := RegisterTransformer(deflateTransformer{}); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterTransformer makes t available to every store of the process under its ID and name
+// Custom transformers take IDs from constants.MinCustomTransformID on
+// Returns ErrBadTransformer if the ID is 0, the name is empty, or either is taken
+func RegisterTransformer(t Transformer) error {
+	if t.ID() == 0 || t.Name() == "" {
+		return fmt.Errorf("RegisterTransformer: %w: ID %d and name %q must not be empty", ErrBadTransformer, t.ID(), t.Name())
+	}
+
+	transformers.Lock()
+	defer transformers.Unlock()
+
+	if other, ok := transformers.byID[t.ID()]; ok {
+		return fmt.Errorf("RegisterTransformer: %w: ID %d is taken by %q", ErrBadTransformer, t.ID(), other.Name())
+	}
+	if _, ok := transformers.byName[t.Name()]; ok {
+		return fmt.Errorf("RegisterTransformer: %w: name %q is taken", ErrBadTransformer, t.Name())
+	}
+	transformers.byID[t.ID()] = t
+	transformers.byName[t.Name()] = t
+	return nil
+}
+
+// lookupTransformer returns the transformer registered under name
+func lookupTransformer(name string) (Transformer, error) {
+	transformers.RLock()
+	defer transformers.RUnlock()
+
+	t, ok := transformers.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("lookupTransformer: %w: %q is not registered", ErrBadTransformer, name)
+	}
+	return t, nil
+}
+
+// ValidateTransformers checks that names is a chain SetTransformers accepts
+func ValidateTransformers(names []string) error {
+	if len(names) > constants.MaxTransforms {
+		return fmt.Errorf("ValidateTransformers: %w: at most %d transformers can be chained, got %d",
+			ErrBadTransformer, constants.MaxTransforms, len(names))
+	}
+	for _, name := range names {
+		if _, err := lookupTransformer(name); err != nil {
+			return fmt.Errorf("ValidateTransformers: %w", err)
+		}
+	}
+	return nil
+}
+
+// Transformers returns the names of the transformers applied to the values written, in the order they are applied
+func (s *Store) Transformers() []string {
+	var names []string
+	if chain := s.transforms.Load(); chain != nil {
+		for _, t := range *chain {
+			names = append(names, t.Name())
+		}
+	}
+	return names
+}
+
+// SetTransformers changes the transformers applied to the values written from now on, in order; an empty chain
+// stores values as they are
+// Values written before keep the transformers they were written with until compaction rewrites them
+// Returns ErrBadTransformer if a transformer is not registered or more than constants.MaxTransforms are given
+func (s *Store) SetTransformers(names []string) error {
+	if err := ValidateTransformers(names); err != nil {
+		return fmt.Errorf("SetTransformers: %w", err)
+	}
+
+	chain := make([]Transformer, len(names))
+	for i, name := range names {
+		chain[i], _ = lookupTransformer(name)
+	}
+
+	if old := s.Transformers(); !slices.Equal(old, names) {
+		logging.Infof("SetTransformers: [%v] -> [%v]", strings.Join(old, " "), strings.Join(names, " "))
+	}
+	s.transforms.Store(&chain)
+	return nil
+}
+
+// transform applies the store's transformers to value, returning its stored form and the IDs of the transformers
+// applied, packed as in the record flags, see transformFlags
+func (s *Store) transform(value string) (string, uint32, error) {
+	chain := s.transforms.Load()
+	if chain == nil || len(*chain) == 0 {
+		return value, 0, nil
+	}
+
+	data := []byte(value)
+	var ids uint32
+	for i, t := range *chain {
+		encoded, err := t.Encode(data)
+		if err != nil {
+			return "", 0, fmt.Errorf("transform: %v: %w", t.Name(), err)
+		}
+		data = encoded
+		ids |= uint32(t.ID()) << (8 * i)
+	}
+	return string(data), ids, nil
+}
+
+// untransform returns the value whose stored form is stored, undoing the transformers recorded in flags
+// Returns ErrUnknownTransformer if one of them is not registered
+func untransform(stored string, flags int64) (string, error) {
+	ids := transformsOf(flags)
+	if ids == 0 {
+		return stored, nil
+	}
+
+	var chain []Transformer
+	transformers.RLock()
+	for ; ids != 0; ids >>= 8 {
+		t, ok := transformers.byID[uint8(ids)]
+		if !ok {
+			transformers.RUnlock()
+			return "", fmt.Errorf("untransform: %w: ID %d", ErrUnknownTransformer, uint8(ids))
+		}
+		chain = append(chain, t)
+	}
+	transformers.RUnlock()
+
+	data := []byte(stored)
+	for i := len(chain) - 1; i >= 0; i-- {
+		decoded, err := chain[i].Decode(data)
+		if err != nil {
+			return "", fmt.Errorf("untransform: %v: %w", chain[i].Name(), err)
+		}
+		data = decoded
+	}
+	return string(data), nil
+}
+
+// transformsOf returns the transformer IDs recorded in flags, the first applied in the lowest byte
+func transformsOf(flags int64) uint32 {
+	return uint32(uint64(flags) >> constants.FlagTransforms)
+}
+
+// transformFlags returns the flag bit indexes recording the transformer IDs ids, see transformsOf
+func transformFlags(ids uint32) []int64 {
+	var flags []int64
+	for bit := int64(0); ids != 0; bit, ids = bit+1, ids>>1 {
+		if ids&1 != 0 {
+			flags = append(flags, constants.FlagTransforms+bit)
+		}
+	}
+	return flags
+}
+
+// deflateTransformer compresses values with DEFLATE, storing the values it cannot shrink as they are
+// The stored form starts with a byte telling which: deflateStored or deflateCompressed
+type deflateTransformer struct{}
+
+const (
+	// deflateStored marks a value stored as it is
+	deflateStored = 0
+
+	// deflateCompressed marks a compressed value
+	deflateCompressed = 1
+)
+
+// Name implements Transformer
+func (deflateTransformer) Name() string {
+	return "deflate"
+}
+
+// ID implements Transformer
+func (deflateTransformer) ID() uint8 {
+	return constants.DeflateTransformID
+}
+
+// Encode implements Transformer
+func (deflateTransformer) Encode(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(deflateCompressed)
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, fmt.Errorf("Encode: %w", err)
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, fmt.Errorf("Encode: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Encode: %w", err)
+	}
+
+	if buf.Len() > len(value) {
+		return append([]byte{deflateStored}, value...), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Transformer
+func (deflateTransformer) Decode(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Decode: empty value")
+	}
+
+	switch data[0] {
+	case deflateStored:
+		return data[1:], nil
+	case deflateCompressed:
+		r := flate.NewReader(bytes.NewReader(data[1:]))
+		defer r.Close()
+		value, err := io.ReadAll(io.LimitReader(r, constants.MaxValueSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("Decode: %w", err)
+		}
+		if len(value) > constants.MaxValueSize {
+			return nil, fmt.Errorf("Decode: value exceeds %d bytes", constants.MaxValueSize)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("Decode: unknown format %d", data[0])
+}
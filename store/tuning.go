@@ -0,0 +1,218 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"time"
+)
+
+// Durability controls when writes reach stable storage
+type Durability string
+
+// Durability modes
+const (
+	// DurabilitySync opens the active log with O_SYNC, so every write is on disk before it is acknowledged (default)
+	DurabilitySync Durability = "sync"
+
+	// DurabilityNone leaves flushing to the operating system; a machine crash can lose recent writes,
+	// but the checksums still detect the torn tail on restart
+	DurabilityNone Durability = "none"
+)
+
+// ParseDurability validates a durability mode name
+func ParseDurability(name string) (Durability, error) {
+	switch d := Durability(name); d {
+	case DurabilitySync, DurabilityNone:
+		return d, nil
+	}
+
+	return "", fmt.Errorf("ParseDurability: unknown durability mode %q (expected %q or %q)", name, DurabilitySync, DurabilityNone)
+}
+
+// Durability returns the store's current durability mode
+func (s *Store) Durability() Durability {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.durability
+}
+
+// SetDurability changes the durability mode, reopening the active log if it changed
+// Writes already acknowledged under DurabilityNone are flushed when the log is reopened
+func (s *Store) SetDurability(d Durability) error {
+	if _, err := ParseDurability(string(d)); err != nil {
+		return fmt.Errorf("SetDurability: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d == s.durability {
+		return nil
+	}
+
+	if s.writer != nil {
+		if err := s.writer.Sync(); err != nil {
+			s.recordWrite(err)
+			return fmt.Errorf("SetDurability: %w", err)
+		}
+		if err := s.closeWriter(); err != nil {
+			return fmt.Errorf("SetDurability: failed to close active log: %w", err)
+		}
+
+		writer, err := s.openWriter(s.activeLog, d)
+		if err != nil {
+			return fmt.Errorf("SetDurability: failed to reopen active log: %w", err)
+		}
+		s.writer = writer
+	}
+
+	logging.Infof("SetDurability: %v -> %v", s.durability, d)
+	s.durability = d
+	return nil
+}
+
+// CompactionInterval returns the delay between automatic compaction cycles
+func (s *Store) CompactionInterval() time.Duration {
+	return time.Duration(s.compactionInterval.Load())
+}
+
+// SetCompactionInterval changes the delay between automatic compaction cycles
+// The new interval takes effect after the cycle that is currently being waited for
+func (s *Store) SetCompactionInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("SetCompactionInterval: interval must be positive, got %v", interval)
+	}
+
+	s.compactionInterval.Store(int64(interval))
+	return nil
+}
+
+// ErrBadVerification is returned for an unknown verification level
+var ErrBadVerification = errors.New("unknown verification level")
+
+// Verification controls how much of a record is checked when a value is read by Get or GetMany
+type Verification string
+
+// Verification levels
+const (
+	// VerifyFull recomputes the SHA-256 checksum of the value, detecting any corrupted byte (default)
+	VerifyFull Verification = "full"
+
+	// VerifyMetadata reads the metadata in front of the value, in the same read, and checks that it is intact and
+	// describes the record the index points to; the value itself is not hashed
+	// It catches misplaced or overwritten records at a fixed cost, but not bit rot inside a value
+	VerifyMetadata Verification = "metadata"
+
+	// VerifyNone only decodes the value
+	VerifyNone Verification = "none"
+)
+
+// ParseVerification validates a verification level name
+// Returns ErrBadVerification for an unknown name
+func ParseVerification(name string) (Verification, error) {
+	switch v := Verification(name); v {
+	case VerifyFull, VerifyMetadata, VerifyNone:
+		return v, nil
+	}
+
+	return "", fmt.Errorf("ParseVerification: %w %q (expected %q, %q, or %q)", ErrBadVerification, name, VerifyFull, VerifyMetadata, VerifyNone)
+}
+
+// Verification returns the verification level of reads that do not ask for one
+func (s *Store) Verification() Verification {
+	return *s.verification.Load()
+}
+
+// SetVerification changes the verification level of reads that do not ask for one
+// Compaction, snapshots, and collection updates always verify values in full
+func (s *Store) SetVerification(v Verification) error {
+	if _, err := ParseVerification(string(v)); err != nil {
+		return fmt.Errorf("SetVerification: %w", err)
+	}
+
+	if old := s.verification.Swap(&v); *old != v {
+		logging.Infof("SetVerification: %v -> %v", *old, v)
+	}
+	return nil
+}
+
+// verificationFor returns the verification level of a read asking for name, the store's level if name is empty
+// Returns ErrBadVerification for an unknown name
+func (s *Store) verificationFor(name string) (Verification, error) {
+	if name == "" {
+		return s.Verification(), nil
+	}
+	return ParseVerification(name)
+}
+
+// ErrBadStartupCheck is returned for an unknown startup check level
+var ErrBadStartupCheck = errors.New("unknown startup check level")
+
+// StartupCheck controls how much of every record Open verifies while it rebuilds the index,
+// trading boot time against how early corruption is found, e.g. after an unclean shutdown
+type StartupCheck string
+
+// Startup check levels
+const (
+	// StartupCheckFast validates the metadata checksum of every record (default)
+	StartupCheckFast StartupCheck = "fast"
+
+	// StartupCheckFull also recomputes the value checksum of every record, reading the whole database
+	// A corrupt value is handled like corrupt metadata: in the active log the records from it on are not loaded,
+	// in an archived segment Open fails
+	StartupCheckFull StartupCheck = "full"
+
+	// StartupCheckNone trusts the segment files and only checks that every record fits in its file
+	// There are no hint files or index snapshots, so every record is still read; only the hashing is skipped
+	// Reads still verify values as configured by Verification
+	StartupCheckNone StartupCheck = "none"
+)
+
+// ParseStartupCheck validates a startup check level name
+// Returns ErrBadStartupCheck for an unknown name
+func ParseStartupCheck(name string) (StartupCheck, error) {
+	switch c := StartupCheck(name); c {
+	case StartupCheckFast, StartupCheckFull, StartupCheckNone:
+		return c, nil
+	}
+
+	return "", fmt.Errorf("ParseStartupCheck: %w %q (expected %q, %q, or %q)", ErrBadStartupCheck, name,
+		StartupCheckFast, StartupCheckFull, StartupCheckNone)
+}
+
+// ErrBadStartupRepair is returned for an unknown startup repair policy
+var ErrBadStartupRepair = errors.New("unknown startup repair policy")
+
+// StartupRepair controls what Open does when a sealed segment is corrupt, see Repair
+// A corrupt active log is always loaded up to the corrupt record, since that is how a crash mid-write looks
+type StartupRepair string
+
+// Startup repair policies
+const (
+	// StartupRepairFail makes Open fail, leaving the files untouched (default)
+	StartupRepairFail StartupRepair = "fail"
+
+	// StartupRepairSkip moves the corrupt segment to the quarantine directory and loads the other segments
+	// Keys last written in the segment come back with their previous value, or not at all, and keys deleted in it
+	// come back with the value they had before
+	StartupRepairSkip StartupRepair = "skip-segment"
+
+	// StartupRepairSalvage salvages the database into a fresh one that replaces it, see Salvage, and moves the
+	// original to the quarantine directory
+	// Only the corrupt regions are lost, but every segment is rewritten, so startup takes as long as a compaction
+	StartupRepairSalvage StartupRepair = "salvage"
+)
+
+// ParseStartupRepair validates a startup repair policy name
+// Returns ErrBadStartupRepair for an unknown name
+func ParseStartupRepair(name string) (StartupRepair, error) {
+	switch r := StartupRepair(name); r {
+	case StartupRepairFail, StartupRepairSkip, StartupRepairSalvage:
+		return r, nil
+	}
+
+	return "", fmt.Errorf("ParseStartupRepair: %w %q (expected %q, %q, or %q)", ErrBadStartupRepair, name,
+		StartupRepairFail, StartupRepairSkip, StartupRepairSalvage)
+}
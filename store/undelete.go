@@ -0,0 +1,159 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+var (
+	// ErrNotDeleted is returned by Undelete for a key that is live
+	ErrNotDeleted = errors.New("key is not deleted")
+
+	// ErrNoPriorVersion is returned by Undelete when no earlier version of a deleted key is left to restore,
+	// because compaction removed it or it expired
+	ErrNoPriorVersion = errors.New("no earlier version of the key is left to restore")
+)
+
+// Undelete restores the most recent version of a deleted (or evicted) key written before its tombstone
+// Tombstones and the versions they hide stay on disk until compaction drops them, which gives a grace window
+// against accidental deletes; the older segments are scanned for the version, newest first
+// The version keeps its value, type, and original expiry, and is written again like a Set
+// Returns ErrKeyNotFound if the key is unknown, ErrNotDeleted if it is live, and ErrNoPriorVersion if
+// there is nothing left to restore (client errors)
+// Returns other errors for server-side failures
+func (s *Store) Undelete(key string) (*models.KVStashVersion, error) {
+	key = s.normalization.Key(key)
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	tomb := s.index[key]
+	dbPath := s.dbPath
+	s.mu.RUnlock()
+
+	if tomb == nil {
+		return nil, ErrKeyNotFound
+	}
+	if !tomb.Deleted {
+		return nil, ErrNotDeleted
+	}
+
+	// The scan runs without the lock, segments are only appended to; the index is checked again before writing
+	rec, err := s.findPriorVersion(dbPath, key, tomb)
+	if err != nil {
+		return nil, fmt.Errorf("Undelete: %w", err)
+	}
+	if rec.expiresAt != 0 && rec.expiresAt <= s.now().UnixMilli() {
+		return nil, fmt.Errorf("Undelete: %w: the last version expired", ErrNoPriorVersion)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A write, another undelete, or compaction got in between
+	if current := s.index[key]; current != tomb {
+		if live(current, s.now().UnixMilli()) {
+			return nil, ErrNotDeleted
+		}
+		return nil, fmt.Errorf("Undelete: %w: the key changed during the undelete", ErrNoPriorVersion)
+	}
+
+	value, err := rec.value()
+	if err != nil {
+		return nil, fmt.Errorf("Undelete: %w", err)
+	}
+	if err := s.put(key, value, rec.valueType(), rec.expiresAt, nil); err != nil {
+		return nil, fmt.Errorf("Undelete: %w", err)
+	}
+
+	logging.Debugf("Undelete: restored key=%v", redact.Key(key))
+	return s.version(key), nil
+}
+
+// findPriorVersion returns the last record holding a value of key written before its tombstone tomb
+// in the database directory dbPath
+// Segments are scanned from the tombstone's segment back to the oldest, skipping those whose Bloom filter rules the
+// key out; the record's checksum is verified
+// Returns ErrNoPriorVersion if no such record is left
+func (s *Store) findPriorVersion(dbPath string, key string, tomb *models.KVStashIndexEntry) (*record, error) {
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("findPriorVersion: %w", err)
+	}
+
+	last := segmentNumber(tomb.SegmentFile)
+	for _, segment := range slices.Backward(segments) {
+		if segmentNumber(segment) > last || s.skipSegment(segment, key) {
+			continue
+		}
+
+		// In the tombstone's segment, only records before the tombstone are older
+		limit := int64(-1)
+		if segment == tomb.SegmentFile {
+			limit = tomb.Offset - constants.MetadataSize
+		}
+
+		rec, err := s.lastVersionIn(dbPath, segment, key, limit)
+		if err != nil {
+			return nil, fmt.Errorf("findPriorVersion: %w", err)
+		}
+		if rec == nil {
+			continue
+		}
+
+		if err := rec.validateChecksum(segment); err != nil {
+			return nil, fmt.Errorf("findPriorVersion: %v: %w", segment, err)
+		}
+		return rec, nil
+	}
+
+	return nil, ErrNoPriorVersion
+}
+
+// lastVersionIn returns the last record holding a value of key in segment of dbPath that starts before limit,
+// or anywhere in the segment if limit is negative; nil if there is none
+// Reading stops at the first corrupted record, like recovery does
+func (s *Store) lastVersionIn(dbPath string, segment string, key string, limit int64) (*record, error) {
+	file, err := os.Open(filepath.Join(dbPath, segment))
+	if err != nil {
+		// Compaction removed the segment in the meantime
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("lastVersionIn: failed to open %v: %w", segment, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("lastVersionIn: failed to stat %v: %w", segment, err)
+	}
+
+	var found *record
+	for pos := int64(0); limit < 0 || pos < limit; {
+		rec, err := readRecord(file, info.Size(), pos)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logging.Warnf("lastVersionIn: stopped reading %v at offset %d: %v", segment, pos, err)
+			break
+		}
+
+		if !rec.deleted() && !rec.expiry() && s.normalization.Key(rec.data.Key) == key {
+			found = rec
+		}
+		pos = rec.end()
+	}
+
+	return found, nil
+}
@@ -0,0 +1,275 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/codec"
+	"github.com/vi88i/kvstash/constants"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// On-disk formats recognized by DetectFormat
+const (
+	// FormatCurrent is the format written by this version (constants.MetadataSize-byte metadata with flags)
+	FormatCurrent = "current"
+
+	// FormatLegacy is the format written before flags were introduced (constants.LegacyMetadataSize-byte metadata)
+	FormatLegacy = "legacy"
+)
+
+// FormatReport describes the on-disk format of every segment of a database
+type FormatReport struct {
+	// Segments maps each segment filename to its format (FormatCurrent or FormatLegacy)
+	// Empty segments are reported as FormatCurrent
+	Segments map[string]string
+
+	// Order lists the segment filenames in segment order
+	Order []string
+}
+
+// Current reports whether every segment already uses the current format
+func (r *FormatReport) Current() bool {
+	for _, format := range r.Segments {
+		if format != FormatCurrent {
+			return false
+		}
+	}
+	return true
+}
+
+// DetectFormat inspects the first record of every segment to determine the on-disk format of dbPath
+// Returns an error if a segment starts with a record that is valid in none of the known formats
+func DetectFormat(dbPath string) (*FormatReport, error) {
+	segments, err := listSegments(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("DetectFormat: %w", err)
+	}
+
+	report := &FormatReport{Segments: make(map[string]string), Order: segments}
+	for _, segment := range segments {
+		format, err := detectSegmentFormat(filepath.Join(dbPath, segment))
+		if err != nil {
+			return nil, fmt.Errorf("DetectFormat: %v: %w", segment, err)
+		}
+		report.Segments[segment] = format
+	}
+
+	return report, nil
+}
+
+// detectSegmentFormat returns the format of the segment file at path based on its first record
+func detectSegmentFormat(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("detectSegmentFormat: failed to open: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("detectSegmentFormat: failed to stat: %w", err)
+	}
+
+	_, currentErr := readRecord(file, info.Size(), 0)
+	if currentErr == nil || currentErr == io.EOF {
+		return FormatCurrent, nil
+	}
+
+	if _, err := readLegacyRecord(file, info.Size(), 0); err == nil {
+		return FormatLegacy, nil
+	}
+
+	return "", fmt.Errorf("detectSegmentFormat: not in a recognized format: %w", currentErr)
+}
+
+// readLegacyRecord reads and fully validates the legacy record starting at pos
+// Both the metadata and the value checksum are verified
+// Returns io.EOF if pos is exactly the end of the file
+func readLegacyRecord(r io.ReaderAt, fileSize int64, pos int64) (*record, error) {
+	crec, err := codec.ReadLegacyRecord(r, fileSize, pos)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("readLegacyRecord: %w", err)
+	}
+
+	rec, err := newRecord(crec)
+	if err != nil {
+		return nil, fmt.Errorf("readLegacyRecord: %w", err)
+	}
+
+	return rec, nil
+}
+
+// UpgradeReport is the result of upgrading a database to the current format
+type UpgradeReport struct {
+	// UpgradedSegments is the number of segments rewritten from the legacy format
+	UpgradedSegments int
+
+	// Records is the number of records in the upgraded database
+	Records int
+
+	// BackupPath is the directory holding the original database, empty if it was removed
+	BackupPath string
+}
+
+// UpgradeFormat rewrites every segment of dbPath that is not in the current format
+//
+// Upgrade Process:
+//  1. Every record of every segment is read and its metadata and value checksums are verified;
+//     any corruption aborts the upgrade (use Salvage first)
+//  2. Records are re-written in order into a new database next to dbPath, keeping segment names,
+//     so the upgraded database replays to the same index
+//  3. The new database is verified with Verify and its record counts are compared with the source
+//  4. Only then is the new database swapped in; the original is kept at dbPath+".bkp" if keepBackup is set
+//
+// Returns a report with UpgradedSegments=0 if the database already uses the current format
+func UpgradeFormat(dbPath string, keepBackup bool) (*UpgradeReport, error) {
+	formats, err := DetectFormat(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("UpgradeFormat: %w", err)
+	}
+
+	report := &UpgradeReport{}
+	if formats.Current() {
+		return report, nil
+	}
+
+	tmpPath := filepath.Clean(dbPath) + ".upgrade"
+	bkpPath := filepath.Clean(dbPath) + ".bkp"
+	for _, p := range []string{tmpPath, bkpPath} {
+		if _, err := os.Stat(p); err == nil {
+			return nil, fmt.Errorf("UpgradeFormat: leftover directory from a previous run - %v", p)
+		}
+	}
+
+	if err := os.MkdirAll(tmpPath, 0755); err != nil {
+		return nil, fmt.Errorf("UpgradeFormat: failed to create %v: %w", tmpPath, err)
+	}
+
+	expected := make(map[string]int, len(formats.Order))
+	for _, segment := range formats.Order {
+		count, err := rewriteSegment(dbPath, tmpPath, segment, formats.Segments[segment])
+		if err != nil {
+			os.RemoveAll(tmpPath)
+			return nil, fmt.Errorf("UpgradeFormat: %w", err)
+		}
+		expected[segment] = count
+		report.Records += count
+		if formats.Segments[segment] != FormatCurrent {
+			report.UpgradedSegments++
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dbPath, constants.SuperblockName)); err == nil {
+		if err := copySegment(filepath.Join(dbPath, constants.SuperblockName), filepath.Join(tmpPath, constants.SuperblockName)); err != nil {
+			os.RemoveAll(tmpPath)
+			return nil, fmt.Errorf("UpgradeFormat: failed to copy superblock: %w", err)
+		}
+	}
+
+	if err := verifyUpgrade(tmpPath, expected); err != nil {
+		os.RemoveAll(tmpPath)
+		return nil, fmt.Errorf("UpgradeFormat: %w", err)
+	}
+
+	if err := os.Rename(dbPath, bkpPath); err != nil {
+		os.RemoveAll(tmpPath)
+		return nil, fmt.Errorf("UpgradeFormat: failed to move database to backup: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		if restoreErr := os.Rename(bkpPath, dbPath); restoreErr != nil {
+			return nil, errors.Join(
+				fmt.Errorf("UpgradeFormat: failed to move upgraded database: %w", err),
+				fmt.Errorf("UpgradeFormat: failed to restore backup from %v: %w", bkpPath, restoreErr),
+			)
+		}
+		return nil, fmt.Errorf("UpgradeFormat: failed to move upgraded database: %w", err)
+	}
+
+	if keepBackup {
+		report.BackupPath = bkpPath
+	} else if err := os.RemoveAll(bkpPath); err != nil {
+		return nil, fmt.Errorf("UpgradeFormat: upgraded, but failed to delete backup - %v: %w", bkpPath, err)
+	}
+
+	return report, nil
+}
+
+// rewriteSegment copies every record of segment from src into a segment of the same name in dst,
+// writing it in the current format
+// Returns the number of records written
+func rewriteSegment(src string, dst string, segment string, format string) (int, error) {
+	file, err := os.Open(filepath.Join(src, segment))
+	if err != nil {
+		return 0, fmt.Errorf("rewriteSegment: failed to open %v: %w", segment, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("rewriteSegment: failed to stat %v: %w", segment, err)
+	}
+
+	writer, err := newLogWriter(dst, segment, DurabilitySync)
+	if err != nil {
+		return 0, fmt.Errorf("rewriteSegment: %w", err)
+	}
+	defer writer.Close()
+
+	count := 0
+	for pos := int64(0); ; {
+		var rec *record
+		if format == FormatLegacy {
+			rec, err = readLegacyRecord(file, info.Size(), pos)
+		} else {
+			rec, err = readRecord(file, info.Size(), pos)
+			if err == nil {
+				err = rec.validateChecksum(segment)
+			}
+		}
+
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("rewriteSegment: %v is corrupted, salvage it before upgrading: %w", segment, err)
+		}
+
+		if _, err := writer.Write(rec.raw, rec.flags()); err != nil {
+			return 0, fmt.Errorf("rewriteSegment: failed to write %v: %w", segment, err)
+		}
+
+		count++
+		pos = rec.end()
+	}
+}
+
+// verifyUpgrade checks that the upgraded database at dbPath is intact and that every segment
+// holds the expected number of records
+func verifyUpgrade(dbPath string, expected map[string]int) error {
+	report, err := Verify(dbPath)
+	if err != nil {
+		return fmt.Errorf("verifyUpgrade: %w", err)
+	}
+
+	if report.Corrupted() {
+		return fmt.Errorf("verifyUpgrade: upgraded database failed verification")
+	}
+
+	if len(report.Segments) != len(expected) {
+		return fmt.Errorf("verifyUpgrade: expected %d segments, found %d", len(expected), len(report.Segments))
+	}
+
+	for _, seg := range report.Segments {
+		if seg.Records != expected[seg.Name] {
+			return fmt.Errorf("verifyUpgrade: %v has %d records, expected %d", seg.Name, seg.Records, expected[seg.Name])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/redact"
+	"path/filepath"
+	"time"
+)
+
+// WarmupOptions selects the values read by Warmup
+type WarmupOptions struct {
+	// Recent is the number of most recently written keys whose values are read
+	Recent int
+
+	// Keys lists further keys whose values are read; missing keys are skipped
+	Keys []string
+}
+
+// WarmupReport is the result of a warmup
+type WarmupReport struct {
+	// Segments is the number of segment files opened
+	Segments int
+
+	// Keys is the number of values read
+	Keys int
+
+	// Bytes is the total size of the records read
+	Bytes int64
+
+	// Failed is the number of values that could not be read; Get reports their error
+	Failed int
+
+	// Duration is how long the warmup took
+	Duration time.Duration
+}
+
+// Warmup prepares the store for its first reads, typically right after Open and before serving traffic
+// It opens the newest segment files, up to the number kept open for reads, and reads the selected values,
+// validating their checksums, so they are in the operating system's page cache when they are first requested
+// Failures are logged and counted, never returned, since the store works without a warmup
+func (s *Store) Warmup(opts WarmupOptions) *WarmupReport {
+	start := time.Now()
+	report := &WarmupReport{}
+
+	s.mu.RLock()
+	segments, err := listSegments(s.dbPath)
+	if err != nil {
+		logging.Warnf("Warmup: %v", err)
+	}
+	for i := len(segments) - 1; i >= 0 && s.files != nil && report.Segments < s.files.max; i-- {
+		h, err := s.files.acquire(filepath.Join(s.dbPath, segments[i]))
+		if err != nil {
+			logging.Warnf("Warmup: %v", err)
+			continue
+		}
+		s.files.release(h)
+		report.Segments++
+	}
+
+	keys := make([]string, 0, opts.Recent+len(opts.Keys))
+	if opts.Recent > 0 {
+		order := s.writeOrder()
+		now := s.now().UnixMilli()
+		for i := len(order) - 1; i >= 0 && len(keys) < opts.Recent; i-- {
+			if live(s.index[order[i]], now) {
+				keys = append(keys, order[i])
+			}
+		}
+	}
+	for _, key := range opts.Keys {
+		keys = append(keys, s.normalization.Key(key))
+	}
+	s.mu.RUnlock()
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		s.mu.RLock()
+		entry, ok := s.lookup(key)
+		dbPath := s.dbPath
+		s.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		_, err := fetchValue(s.files, dbPath, entry.SegmentFile, entry.Offset, entry.Size, entryFlags(entry), entry.Checksum, VerifyFull, nil)
+		if err != nil {
+			logging.Warnf("Warmup: failed to read key=%v: %v", redact.Key(key), err)
+			report.Failed++
+			continue
+		}
+		report.Keys++
+		report.Bytes += entry.Size
+	}
+
+	report.Duration = time.Since(start)
+	logging.Infof("Warmup: opened %d segments and read %d values (%d bytes) in %v, %d failed",
+		report.Segments, report.Keys, report.Bytes, report.Duration, report.Failed)
+
+	return report
+}
@@ -0,0 +1,247 @@
+package store
+
+import (
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Log Writer Design Notes:
+
+Requirements:
+- Multiple writers
+- Multiple readers
+- Durability vs Throughput trade-off
+
+1. Durability vs Throughput:
+   With DurabilitySync the file is opened with O_SYNC and writes are synchronous (high durability, lower throughput)
+   With DurabilityNone the kernel batches writes (higher throughput, lower durability)
+
+2. Thread Safety:
+   Mutex protects concurrent writes from multiple goroutines
+
+3. Batches:
+   Between hold and release, records are appended to a buffer instead of the file and written by release in one
+   write, so a batch of records costs a single flush with DurabilitySync instead of two per record
+*/
+
+// LogWriter handles thread-safe append operations to the active log file
+// It maintains the current offset and ensures synchronous writes for durability
+type LogWriter struct {
+	// file is the open file handle for the active log file
+	file *os.File
+
+	// offset tracks the current write position in the file
+	offset int64
+
+	// mu protects concurrent write operations
+	mu sync.Mutex
+
+	// name is the log filename used for checksum computation
+	name string
+
+	// synced indicates that the file is opened with O_SYNC, so every write is flushed to stable storage
+	synced bool
+
+	// syncs times every flush to stable storage, nil if flushes are not timed, see Store.openWriter
+	syncs *Histogram
+
+	// flushes counts every flush to stable storage, nil if flushes are not counted, see Store.openWriter
+	flushes *atomic.Int64
+
+	// held buffers the records written since hold, nil unless records are held
+	held []byte
+
+	// heldOffset is the offset of the file the held records are written at
+	heldOffset int64
+}
+
+// newLogWriter creates a new LogWriter for the specified database path and log file
+// With DurabilitySync the file is opened with O_CREATE|O_SYNC|O_WRONLY for synchronous I/O (durability over throughput)
+// If the file already exists, it resumes writing from the current end of file
+// Returns an error if the file cannot be opened or queried
+func newLogWriter(dbPath string, activeLog string, durability Durability) (*LogWriter, error) {
+	logPath := filepath.Join(dbPath, activeLog)
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if durability != DurabilityNone {
+		flags |= os.O_SYNC
+	}
+
+	file, err := os.OpenFile(logPath, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("newLogWriter: failed to open file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("newLogWriter: failed to stat file: %w", err)
+	}
+
+	return &LogWriter{file: file, offset: info.Size(), name: activeLog, synced: durability != DurabilityNone}, nil
+}
+
+// Write appends data to the log file with metadata and checksums
+// The write format is: [metadata (112 bytes)][value data]
+// Automatically rolls back the offset on partial write failures
+// Returns the metadata containing offset, size, and checksums
+// Thread-safe: uses mutex to serialize concurrent writes
+func (lw *LogWriter) Write(data []byte, flags []int64) (*models.KVStashMetadata, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	metadataFlag := models.ComputeMetadataFlag(flags)
+	metaDataOffset := lw.offset
+	valueOffset := metaDataOffset + constants.MetadataSize
+	valueSize := int64(len(data))
+	metadata := models.KVStashMetadata{}
+	if err := metadata.ComputeChecksum(valueOffset, valueSize, metadataFlag, lw.name, data); err != nil {
+		return &metadata, fmt.Errorf("Write: metadata compute failed: %w", err)
+	}
+
+	if lw.held != nil {
+		lw.held = append(append(lw.held, metadata.Serialize()...), data...)
+		lw.offset = valueOffset + valueSize
+		return &metadata, nil
+	}
+
+	n, err := lw.writeAt(metadata.Serialize(), metaDataOffset)
+	if err != nil {
+		return &metadata, fmt.Errorf("Write: metadata write failed: %w", err)
+	}
+
+	if n != constants.MetadataSize {
+		logging.Errorf("Write: expected size: %v, recvd size: %v", constants.MetadataSize, n)
+		return &metadata, fmt.Errorf("Write: metadata size inconsistent")
+	}
+
+	lw.offset += constants.MetadataSize
+	n, err = lw.writeAt(data, valueOffset)
+	bytesWritten := int64(n)
+	if err != nil || bytesWritten != metadata.Size {
+		lw.offset -= constants.MetadataSize
+		return &metadata, fmt.Errorf("Write: value write failed: %w", err)
+	}
+	lw.offset += int64(n)
+
+	return &metadata, nil
+}
+
+// writeAt writes data at offset, timing it as a flush if the file is opened with O_SYNC
+func (lw *LogWriter) writeAt(data []byte, offset int64) (int, error) {
+	if lw.synced && lw.flushes != nil {
+		lw.flushes.Add(1)
+	}
+	if !lw.synced || lw.syncs == nil {
+		return lw.file.WriteAt(data, offset)
+	}
+
+	start := time.Now()
+	n, err := lw.file.WriteAt(data, offset)
+	lw.syncs.Observe(time.Since(start))
+	return n, err
+}
+
+// hold makes Write buffer the records instead of writing them, until release
+func (lw *LogWriter) hold() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if lw.held == nil {
+		lw.held = make([]byte, 0, constants.MetadataSize)
+		lw.heldOffset = lw.offset
+	}
+}
+
+// release writes the records held since hold in one write and makes Write write records right away again
+// If the write fails, none of the held records count as written
+func (lw *LogWriter) release() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	held := lw.held
+	lw.held = nil
+	if len(held) == 0 {
+		return nil
+	}
+
+	n, err := lw.writeAt(held, lw.heldOffset)
+	if err == nil && n != len(held) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		lw.offset = lw.heldOffset
+		return fmt.Errorf("release: wrote %d of %d bytes: %w", n, len(held), err)
+	}
+	return nil
+}
+
+// truncate discards the records written at or after offset, and any held records
+// New records are written at offset even if truncating the file fails
+func (lw *LogWriter) truncate(offset int64) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.held = nil
+	lw.offset = offset
+	if err := lw.file.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+
+	return nil
+}
+
+// position returns the offset the next record is written at and the size of the held records
+func (lw *LogWriter) position() (int64, int) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.offset, len(lw.held)
+}
+
+// Sync flushes writes made without O_SYNC to stable storage
+func (lw *LogWriter) Sync() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if lw.flushes != nil {
+		lw.flushes.Add(1)
+	}
+	start := time.Now()
+	err := lw.file.Sync()
+	if lw.syncs != nil {
+		lw.syncs.Observe(time.Since(start))
+	}
+	if err != nil {
+		return fmt.Errorf("Sync: %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes the log file to disk, closes it, and releases the file handle
+// Returns an error if the flush or the close operation fails
+func (lw *LogWriter) Close() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	// Writes are only flushed as they are made with DurabilitySync, so the rest reaches the disk now
+	if err := lw.file.Sync(); err != nil {
+		lw.file.Close()
+		return fmt.Errorf("Close: failed to sync file: %w", err)
+	}
+	if err := lw.file.Close(); err != nil {
+		return fmt.Errorf("Close: failed to close file: %w", err)
+	}
+
+	return nil
+}
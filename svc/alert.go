@@ -0,0 +1,58 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/alert"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"time"
+)
+
+// alerts posts the store's alerts to the configured webhooks, nil if alerting is not set up
+var alerts *alert.Notifier
+
+// SetAlertNotifier enables the alert statistics and the test alert endpoint for n
+func SetAlertNotifier(n *alert.Notifier) {
+	alerts = n
+}
+
+// alertStats returns the alert counters for the stats endpoint, nil if alerting is not set up
+func alertStats() *models.KVStashAlertStats {
+	if alerts == nil {
+		return nil
+	}
+
+	s := alerts.Stats()
+	return &models.KVStashAlertStats{Webhooks: s.Webhooks, Sent: s.Sent, Failed: s.Failed, Dropped: s.Dropped}
+}
+
+// testAlertHandler queues a test alert for every webhook, to check the alert configuration end to end
+// Only POST is supported; responds with 202 once the alert is queued, or 409 if no webhook is configured
+func testAlertHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, success bool, message string) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(models.KVStashResponse{Success: success, Message: message}); err != nil {
+			logging.From(r.Context()).Warnf("testAlertHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false, "")
+		return
+	}
+	if alerts == nil || len(alerts.URLs()) == 0 {
+		sendResponse(http.StatusConflict, false, "no alert webhooks configured")
+		return
+	}
+
+	alerts.Send(models.KVStashAlert{
+		Event:    models.AlertTest,
+		Severity: models.SeverityResolved,
+		Message:  "test alert requested through the admin endpoint",
+		Time:     time.Now().UTC().Format(time.RFC3339),
+	})
+	sendResponse(http.StatusAccepted, true, "")
+}
@@ -0,0 +1,50 @@
+package svc
+
+import (
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/logging"
+	"net"
+	"net/http"
+	"time"
+)
+
+// auditLog receives the audit trail, nil if auditing is disabled
+var auditLog *audit.Logger
+
+// SetAuditLog enables the audit trail of mutations and admin actions
+func SetAuditLog(l *audit.Logger) {
+	auditLog = l
+}
+
+// recordAudit appends an operation of r to the audit trail if auditing is enabled
+// A failure to write the trail is logged but does not fail the request
+func recordAudit(r *http.Request, op string, key string, size int, status int) {
+	if auditLog == nil {
+		return
+	}
+
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	subject := ""
+	if identity := requestIdentity(r); identity != nil {
+		subject = identity.String()
+	}
+
+	err := auditLog.Log(audit.Entry{
+		Time:       time.Now().UTC(),
+		RequestID:  requestID(r),
+		Client:     client,
+		Credential: audit.Fingerprint(r.Header.Get("Authorization")),
+		Subject:    subject,
+		Op:         op,
+		Key:        key,
+		Size:       size,
+		Status:     status,
+	})
+	if err != nil {
+		logging.From(r.Context()).Errorf("recordAudit: %v", err)
+	}
+}
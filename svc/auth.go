@@ -0,0 +1,77 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/auth"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+)
+
+// authenticator authenticates every request, nil if authentication is disabled
+var authenticator *auth.Authenticator
+
+// identityKey is the context key of the authenticated identity of a request
+type identityKey struct{}
+
+// SetAuthenticator requires every request to present a token accepted by a
+// Must be called before StartHTTPServer
+func SetAuthenticator(a *auth.Authenticator) {
+	authenticator = a
+}
+
+// requestIdentity returns the identity r was authenticated as, nil if authentication is disabled
+func requestIdentity(r *http.Request) *auth.Identity {
+	identity, _ := r.Context().Value(identityKey{}).(*auth.Identity)
+	return identity
+}
+
+// requestSubject identifies who sent r, recorded with the keys it deletes: the identity it was authenticated as,
+// or else the fingerprint of the token it sent, "" if it sent none
+func requestSubject(r *http.Request) string {
+	if identity := requestIdentity(r); identity != nil {
+		return identity.String()
+	}
+	return audit.Fingerprint(auth.BearerToken(r))
+}
+
+// authenticate rejects requests without a valid bearer token with 401 if authentication is enabled
+// If the token cannot be checked, e.g. because the identity provider's keys cannot be fetched, it answers 503
+// The files of the admin UI are served to anyone, see isUIAsset
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil || isUIAsset(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, err := authenticator.Authenticate(r.Context(), auth.BearerToken(r))
+		if err == nil {
+			ctx := context.WithValue(r.Context(), identityKey{}, identity)
+			next.ServeHTTP(w, r.WithContext(logging.WithFields(ctx, "subject", identity.Subject)))
+			return
+		}
+
+		status := http.StatusUnauthorized
+		challenge := `Bearer realm="kvstash"`
+		switch {
+		case errors.Is(err, auth.ErrNoCredentials):
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			challenge += `, error="invalid_token"`
+		default:
+			status = http.StatusServiceUnavailable
+		}
+		logging.From(r.Context()).Warnf("authenticate: rejected %v %v from %v: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		recordAudit(r, audit.OpAuthReject, "", 0, status)
+
+		w.Header().Set("Content-Type", "application/json")
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(models.KVStashResponse{Success: false, Message: err.Error()})
+	})
+}
@@ -0,0 +1,133 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+)
+
+// batchHandler applies a conditional batch, see store.CheckAndSet
+// Accepts POST with a JSON body of checks and writes:
+//
+//	{"checks": [{"key": "stock:42", "value": "3"}],
+//	 "writes": [{"key": "stock:42", "value": "2"}, {"key": "order:7", "value": "42"}]}
+//
+// Responds with the versions of the writes, or with 412 and the indexes of the failed checks
+// Every write is recorded in the audit trail with the status of the batch
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashBatchRequest
+	trace := startSlowTrace(r, "batch")
+
+	sendResponse := func(statusCode int, resp models.KVStashBatchResponse) {
+		trace.markStored()
+		for _, write := range reqData.Writes {
+			op := "batch.set"
+			if write.Delete {
+				op = "batch.delete"
+			}
+			recordAudit(r, op, write.Key, len(write.Value), statusCode)
+		}
+
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("batchHandler: failed to encode response: %v", err)
+		}
+
+		firstKey := ""
+		if len(reqData.Writes) > 0 {
+			firstKey = reqData.Writes[0].Key
+		}
+		trace.finish(firstKey, len(reqData.Checks)+len(reqData.Writes), statusCode)
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashBatchResponse{})
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("batchHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, models.KVStashBatchResponse{Message: "invalid json body"})
+		return
+	}
+
+	for i := range reqData.Checks {
+		key, err := models.DecodeKey(reqData.Checks[i].Key, reqData.KeyEncoding)
+		if err != nil {
+			sendResponse(http.StatusBadRequest, models.KVStashBatchResponse{Message: err.Error()})
+			return
+		}
+		reqData.Checks[i].Key = key
+		if reqData.Checks[i].Value != nil {
+			value, err := models.DecodeValue(*reqData.Checks[i].Value, reqData.ValueEncoding)
+			if err != nil {
+				sendResponse(http.StatusBadRequest, models.KVStashBatchResponse{Message: err.Error()})
+				return
+			}
+			reqData.Checks[i].Value = &value
+		}
+	}
+	for i := range reqData.Writes {
+		key, err := models.DecodeKey(reqData.Writes[i].Key, reqData.KeyEncoding)
+		if err != nil {
+			sendResponse(http.StatusBadRequest, models.KVStashBatchResponse{Message: err.Error()})
+			return
+		}
+		reqData.Writes[i].Key = key
+		if reqData.Writes[i].Value, err = models.DecodeValue(reqData.Writes[i].Value, reqData.ValueEncoding); err != nil {
+			sendResponse(http.StatusBadRequest, models.KVStashBatchResponse{Message: err.Error()})
+			return
+		}
+	}
+	trace.markDecoded()
+
+	versions, err := kvStore.CheckAndSet(reqData.Checks, reqData.Writes)
+	if err != nil {
+		var condErr *store.ConditionError
+		if errors.As(err, &condErr) {
+			logFailure(r, http.StatusPreconditionFailed, "batchHandler: batch not applied: %v", err)
+			sendResponse(http.StatusPreconditionFailed, models.KVStashBatchResponse{
+				Message: store.ErrConditionFailed.Error(),
+				Failed:  condErr.Failed,
+			})
+			return
+		}
+
+		statusCode, message := batchErrorStatus(err)
+		logFailure(r, statusCode, "batchHandler: batch not applied: %v", err)
+		sendResponse(statusCode, models.KVStashBatchResponse{Message: message})
+		return
+	}
+
+	var keys, values []string
+	for _, write := range reqData.Writes {
+		if !write.Delete {
+			keys = append(keys, write.Key)
+			values = append(values, write.Value)
+		}
+	}
+	sendResponse(http.StatusOK, models.KVStashBatchResponse{
+		Success:  true,
+		Versions: versions,
+		Warnings: softLimitWarnings(w, keys, values),
+	})
+}
+
+// batchErrorStatus maps an error of CheckAndSet to a status code and a message for the client
+func batchErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrBadBatch), errors.Is(err, store.ErrBadTTL), errors.Is(err, store.ErrBadExpireAt):
+		return http.StatusBadRequest, err.Error()
+	}
+
+	statusCode, message := collectionErrorStatus(err)
+	if statusCode == http.StatusInternalServerError {
+		message = "batch failed"
+	}
+	return statusCode, message
+}
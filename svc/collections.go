@@ -0,0 +1,157 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+)
+
+// collectionWrites are the collection operations recorded in the audit trail
+var collectionWrites = map[string]bool{
+	"lpush": true,
+	"rpush": true,
+	"lpop":  true,
+	"sadd":  true,
+	"srem":  true,
+	"hset":  true,
+	"hdel":  true,
+}
+
+// collectionsHandler applies an operation to a list, set, or hash
+// Only POST is supported, with a models.KVStashCollectionRequest body
+// Responds with 409 if the key holds another kind of value and 404 if lpop or hget find nothing
+func collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashCollectionRequest
+	trace := startSlowTrace(r, "collection")
+
+	sendResponse := func(statusCode int, resp models.KVStashCollectionResponse) {
+		trace.markStored()
+		if collectionWrites[reqData.Op] {
+			recordAudit(r, reqData.Op, reqData.Key, 0, statusCode)
+		}
+
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("collectionsHandler: failed to encode response: %v", err)
+		}
+		trace.finish(reqData.Key, 0, statusCode)
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashCollectionResponse{})
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("collectionsHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, models.KVStashCollectionResponse{Message: "invalid json body"})
+		return
+	}
+	key, err := models.DecodeKey(reqData.Key, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, models.KVStashCollectionResponse{Message: err.Error()})
+		return
+	}
+	reqData.Key = key
+	trace.markDecoded()
+
+	resp, err := applyCollectionOp(&reqData)
+	if err != nil {
+		statusCode, message := collectionErrorStatus(err)
+		logFailure(r, statusCode, "collectionsHandler: %v failed: %v", reqData.Op, err)
+		sendResponse(statusCode, models.KVStashCollectionResponse{Message: message})
+		return
+	}
+
+	resp.Success = true
+	sendResponse(http.StatusOK, resp)
+}
+
+// errBadCollectionRequest is returned for an unknown operation or missing arguments
+var errBadCollectionRequest = errors.New("invalid collection request")
+
+// applyCollectionOp runs the operation described by req against the store
+func applyCollectionOp(req *models.KVStashCollectionRequest) (models.KVStashCollectionResponse, error) {
+	var resp models.KVStashCollectionResponse
+	var err error
+
+	switch req.Op {
+	case "lpush":
+		resp.Count, err = kvStore.LPush(req.Key, req.Values...)
+	case "rpush":
+		resp.Count, err = kvStore.RPush(req.Key, req.Values...)
+	case "lpop":
+		resp.Value, err = kvStore.LPop(req.Key)
+	case "lrange":
+		stop := -1
+		if req.Stop != nil {
+			stop = *req.Stop
+		}
+		resp.Values, err = kvStore.LRange(req.Key, req.Start, stop)
+		resp.Count = len(resp.Values)
+	case "llen":
+		resp.Count, err = kvStore.LLen(req.Key)
+	case "sadd":
+		resp.Count, err = kvStore.SAdd(req.Key, req.Values...)
+	case "srem":
+		resp.Count, err = kvStore.SRem(req.Key, req.Values...)
+	case "smembers":
+		resp.Values, err = kvStore.SMembers(req.Key)
+		resp.Count = len(resp.Values)
+	case "sismember":
+		if len(req.Values) != 1 {
+			return resp, fmt.Errorf("%w: sismember takes exactly one value", errBadCollectionRequest)
+		}
+		resp.Member, err = kvStore.SIsMember(req.Key, req.Values[0])
+	case "hset":
+		resp.Count, err = kvStore.HSet(req.Key, req.Fields)
+	case "hget":
+		if len(req.Values) != 1 {
+			return resp, fmt.Errorf("%w: hget takes exactly one field name in values", errBadCollectionRequest)
+		}
+		resp.Value, err = kvStore.HGet(req.Key, req.Values[0])
+	case "hdel":
+		resp.Count, err = kvStore.HDel(req.Key, req.Values...)
+	case "hgetall":
+		resp.Fields, err = kvStore.HGetAll(req.Key)
+		resp.Count = len(resp.Fields)
+	case "type":
+		var typ models.KVStashValueType
+		typ, err = kvStore.Type(req.Key)
+		resp.Value = typ.String()
+	default:
+		return resp, fmt.Errorf("%w: unknown operation %q", errBadCollectionRequest, req.Op)
+	}
+
+	return resp, err
+}
+
+// collectionErrorStatus maps an error of a collection operation to a status code and message
+func collectionErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound):
+		return http.StatusNotFound, "key not found"
+	case errors.Is(err, store.ErrWrongType):
+		return http.StatusConflict, store.ErrWrongType.Error()
+	case errors.Is(err, store.ErrMaintenance):
+		return http.StatusServiceUnavailable, maintenanceMessage()
+	case errors.Is(err, store.ErrDegraded):
+		return http.StatusServiceUnavailable, store.ErrDegraded.Error()
+	case errors.Is(err, store.ErrDiskFull):
+		return http.StatusInsufficientStorage, store.ErrDiskFull.Error()
+	case errors.Is(err, store.ErrNamespaceFull):
+		return http.StatusInsufficientStorage, store.ErrNamespaceFull.Error()
+	case errors.Is(err, store.ErrEmptyKey), errors.Is(err, store.ErrKeyTooLarge), errors.Is(err, store.ErrValueTooLarge):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, errBadCollectionRequest):
+		return http.StatusBadRequest, err.Error()
+	}
+
+	return http.StatusInternalServerError, "collection operation failed"
+}
@@ -0,0 +1,69 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"time"
+)
+
+// compactionPauseHandler pauses automatic compaction until it is resumed, or for the duration given by ?for=
+// such as "2h"; pausing again replaces the duration
+// Only POST is supported; the pause state is reported under compaction in the stats
+func compactionPauseHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, success bool, message string) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(models.KVStashResponse{Success: success, Message: message}); err != nil {
+			logging.From(r.Context()).Warnf("compactionPauseHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false, "")
+		return
+	}
+
+	var d time.Duration
+	if param := r.URL.Query().Get("for"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 {
+			sendResponse(http.StatusBadRequest, false, "for must be a positive duration such as 2h")
+			return
+		}
+		d = parsed
+	}
+
+	if err := kvStore.PauseCompaction(d); err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error())
+		return
+	}
+
+	recordAudit(r, audit.OpCompactionPause, "", 0, http.StatusOK)
+	sendResponse(http.StatusOK, true, "")
+}
+
+// compactionResumeHandler lifts a pause set through compactionPauseHandler; pause windows still apply
+// Only POST is supported
+func compactionResumeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, success bool) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(models.KVStashResponse{Success: success}); err != nil {
+			logging.From(r.Context()).Warnf("compactionResumeHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false)
+		return
+	}
+
+	kvStore.ResumeCompaction()
+	recordAudit(r, audit.OpCompactionResume, "", 0, http.StatusOK)
+	sendResponse(http.StatusOK, true)
+}
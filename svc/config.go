@@ -0,0 +1,82 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/config"
+	"github.com/vi88i/kvstash/logging"
+	"net/http"
+	"time"
+)
+
+// configReloader reloads the configuration file, nil if the server was started without one
+var configReloader *config.Reloader
+
+// SetConfigReloader enables the admin config endpoint for the server's configuration file
+func SetConfigReloader(r *config.Reloader) {
+	configReloader = r
+}
+
+// configResponse is the body returned by the admin config endpoint
+type configResponse struct {
+	// Success indicates whether the operation completed successfully
+	Success bool `json:"success"`
+
+	// Message describes why a reload failed
+	Message string `json:"message"`
+
+	// Path is the configuration file
+	Path string `json:"path"`
+
+	// LoadedAt is the RFC 3339 time the configuration in effect was applied
+	LoadedAt string `json:"loaded_at,omitempty"`
+
+	// Config is the configuration in effect
+	Config *config.Config `json:"config"`
+}
+
+// configHandler shows (GET) or reloads (POST) the configuration file
+// A failed reload leaves the previous configuration in effect and responds with 400 and the reason
+// Responds with 404 if the server was started without a configuration file
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, resp configResponse) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("configHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if configReloader == nil {
+		sendResponse(http.StatusNotFound, configResponse{Message: "server was started without -config"})
+		return
+	}
+
+	statusCode := http.StatusOK
+	resp := configResponse{Success: true, Path: configReloader.Path()}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if _, err := configReloader.Reload(); err != nil {
+			logging.From(r.Context()).Warnf("configHandler: %v", err)
+			statusCode = http.StatusBadRequest
+			resp.Success = false
+			resp.Message = err.Error()
+		} else {
+			logging.From(r.Context()).Infof("configHandler: reloaded %v", configReloader.Path())
+		}
+		recordAudit(r, audit.OpConfigReload, "", 0, statusCode)
+	default:
+		sendResponse(http.StatusMethodNotAllowed, configResponse{})
+		return
+	}
+
+	cfg, loadedAt := configReloader.Current()
+	resp.Config = cfg
+	if !loadedAt.IsZero() {
+		resp.LoadedAt = loadedAt.Format(time.RFC3339)
+	}
+	sendResponse(statusCode, resp)
+}
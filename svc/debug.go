@@ -0,0 +1,118 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// pprofPrefix is the path the profiling endpoints are served under on the debug listener
+const pprofPrefix = "/debug/pprof/"
+
+// debugMux returns the handler of the debug listener: the profiles of net/http/pprof and the store internals
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pprofPrefix, pprof.Index)
+	mux.HandleFunc(pprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(pprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(pprofPrefix+"trace", pprof.Trace)
+	mux.HandleFunc("/debug/kvstash", internalsHandler)
+	return mux
+}
+
+// hidePprof answers 404 for the profiling endpoints, which importing net/http/pprof registers on
+// http.DefaultServeMux, so that they are only served by the debug listener
+func hidePprof(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, pprofPrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// internalsHandler dumps the state of the store's write path and index, and of the Go runtime, see store.Internals
+// Only GET is supported
+func internalsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	s := kvStore.Internals()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	resp := models.KVStashDebugInternals{
+		DataDir:            s.DataDir,
+		ActiveLog:          s.ActiveLog,
+		ActiveLogOffset:    s.ActiveLogOffset,
+		ActiveLogCount:     s.ActiveLogCount,
+		SegmentStart:       s.SegmentStart,
+		SegmentStartOffset: s.SegmentStartOffset,
+		HeldBytes:          s.HeldBytes,
+		SegmentCount:       s.SegmentCount,
+		NextSegment:        s.NextSegment,
+		IndexEntries:       s.IndexEntries,
+		ExpiryQueue:        s.ExpiryQueue,
+		Revision:           s.Revision,
+		OpenSnapshots:      s.OpenSnapshots,
+		Relocating:         s.Relocating,
+		Durability:         string(s.Durability),
+		SegmentFilters:     s.SegmentFilters,
+		FilterSkips:        s.FilterSkips,
+		Goroutines:         runtime.NumGoroutine(),
+		GoMaxProcs:         runtime.GOMAXPROCS(0),
+		HeapBytes:          mem.HeapAlloc,
+		GCRuns:             mem.NumGC,
+	}
+	snap := s.IndexSnapshot
+	resp.IndexSnapshot = models.KVStashDebugIndexSnapshot{
+		IntervalSeconds: snap.Interval.Seconds(),
+		Loaded:          snap.Loaded,
+		ReplayedBytes:   snap.Replayed,
+		Saves:           snap.Saves,
+		LastSaveMs:      float64(snap.LastSaveDuration) / float64(time.Millisecond),
+		LastEntries:     snap.LastEntries,
+		LastError:       snap.LastError,
+	}
+	if !snap.LastSave.IsZero() {
+		resp.IndexSnapshot.LastSave = &snap.LastSave
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.From(r.Context()).Warnf("internalsHandler: failed to encode response: %v", err)
+	}
+}
+
+// startDebugServer serves debugMux on addr until ctx is done
+// The listener is bound before it returns, so a bad address fails startup; it is closed without waiting for the
+// requests in flight, since a CPU profile or trace may run for a long time
+func startDebugServer(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("startDebugServer: %w", err)
+	}
+	server := &http.Server{Handler: recoverPanics(debugMux())}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Errorf("startDebugServer: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logging.Infof("startDebugServer: serving pprof and store internals on http://%v/debug/", listener.Addr())
+	return nil
+}
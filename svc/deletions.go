@@ -0,0 +1,52 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+)
+
+// deletionsHandler lists when a key was deleted and by whom, see store.DeletionHistory
+// Accepts GET with the key in the query, /kvstash/admin/deletions?key=user:1, and key_encoding=base64 for keys
+// that are not valid UTF-8
+func deletionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, resp models.KVStashDeletionHistory) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("deletionsHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodGet {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashDeletionHistory{})
+		return
+	}
+
+	query := r.URL.Query()
+	key, err := models.DecodeKey(query.Get("key"), query.Get("key_encoding"))
+	if err != nil {
+		sendResponse(http.StatusBadRequest, models.KVStashDeletionHistory{Message: err.Error()})
+		return
+	}
+
+	deletions, err := kvStore.DeletionHistory(key)
+	if err != nil {
+		logging.From(r.Context()).Errorf("deletionsHandler: failed to read the deletion history: %v", err)
+		if errors.Is(err, store.ErrEmptyKey) || errors.Is(err, store.ErrKeyTooLarge) {
+			sendResponse(http.StatusBadRequest, models.KVStashDeletionHistory{Message: err.Error()})
+		} else {
+			sendResponse(http.StatusInternalServerError, models.KVStashDeletionHistory{Message: "reading the deletion history failed"})
+		}
+		return
+	}
+
+	if deletions == nil {
+		deletions = []models.KVStashDeletion{}
+	}
+	sendResponse(http.StatusOK, models.KVStashDeletionHistory{Success: true, Deletions: deletions})
+}
@@ -0,0 +1,91 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"time"
+)
+
+// expireHandler changes the expiry time of a key without rewriting its value, see store.ExpireAt
+// Accepts POST with a JSON body holding the key and either a TTL in seconds, -1 for no expiry, or an absolute Unix
+// time, {"key": "user:1", "expire_at": 1767225600}, and returns the version of the update
+// Responds with 404 if the key does not exist
+func expireHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashExpireRequest
+	var version *models.KVStashVersion
+	trace := startSlowTrace(r, "expire")
+
+	sendResponse := func(statusCode int, success bool, message string) {
+		trace.markStored()
+		recordAudit(r, "expire", reqData.Key, 0, statusCode)
+
+		w.WriteHeader(statusCode)
+		respData := models.KVStashResponse{
+			Success: success,
+			Message: message,
+			Version: version,
+		}
+		if err := json.NewEncoder(w).Encode(respData); err != nil {
+			logging.From(r.Context()).Warnf("expireHandler: failed to encode response: %v", err)
+		}
+		trace.finish(reqData.Key, 0, statusCode)
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false, "")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("expireHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, false, "invalid json body")
+		return
+	}
+	key, err := models.DecodeKey(reqData.Key, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error())
+		return
+	}
+	reqData.Key = key
+	trace.markDecoded()
+
+	switch {
+	case reqData.ExpireAt < 0 || (reqData.ExpireAt > 0 && reqData.TTL != 0):
+		sendResponse(http.StatusBadRequest, false, store.ErrBadExpireAt.Error())
+		return
+	case reqData.ExpireAt > 0:
+		version, err = kvStore.ExpireAt(reqData.Key, time.Unix(reqData.ExpireAt, 0))
+	case reqData.TTL < -1 || reqData.TTL == 0:
+		sendResponse(http.StatusBadRequest, false, store.ErrBadTTL.Error())
+		return
+	default:
+		version, err = kvStore.Expire(reqData.Key, time.Duration(reqData.TTL)*time.Second)
+	}
+	if err != nil {
+		statusCode, message := expireErrorStatus(err)
+		logFailure(r, statusCode, "expireHandler: failed to change expiry: %v", err)
+		sendResponse(statusCode, false, message)
+		return
+	}
+
+	sendResponse(http.StatusOK, true, "")
+}
+
+// expireErrorStatus maps an error of Expire and ExpireAt to a status code and a message for the client
+func expireErrorStatus(err error) (int, string) {
+	if errors.Is(err, store.ErrBadTTL) {
+		return http.StatusBadRequest, store.ErrBadTTL.Error()
+	}
+
+	statusCode, message := collectionErrorStatus(err)
+	if statusCode == http.StatusInternalServerError {
+		message = "expire failed"
+	}
+	return statusCode, message
+}
@@ -0,0 +1,44 @@
+package svc
+
+import (
+	"expvar"
+	"time"
+)
+
+// publishExpvar publishes the core store and request counters as the "kvstash" variable of /debug/vars
+// The values are computed on every scrape, like the stats endpoint
+func publishExpvar() {
+	expvar.Publish("kvstash", expvar.Func(func() any {
+		s := kvStore.Stats()
+
+		requests := map[string]uint64{}
+		var errorCount uint64
+		for op, m := range metrics {
+			stats := m.stats()
+			requests[op] = stats.Count
+			errorCount += stats.Errors
+		}
+
+		return map[string]any{
+			"uptime_seconds":      time.Since(startTime).Seconds(),
+			"gets":                requests["get"],
+			"sets":                requests["set"],
+			"deletes":             requests["delete"],
+			"mgets":               requests["mget"],
+			"errors":              errorCount,
+			"panics":              panics.Load(),
+			"compactions":         s.Compaction.Runs,
+			"compaction_failures": s.Compaction.Failures,
+			"compaction_running":  s.Compaction.Running,
+			"active_segment":      s.ActiveLog,
+			"segments":            s.Segments,
+			"index_size":          s.LiveKeys + s.DeletedKeys,
+			"live_keys":           s.LiveKeys,
+			"deleted_keys":        s.DeletedKeys,
+			"disk_bytes":          s.DiskBytes,
+			"garbage_ratio":       s.GarbageRatio,
+			"degraded":            s.Breaker.Degraded,
+			"maintenance":         s.Maintenance.Enabled,
+		}
+	}))
+}
@@ -0,0 +1,58 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"time"
+)
+
+// healthHandler reports the store's health checks, see store.Store.Health
+// The status is 503 if a check fails, so load balancers take the server out of rotation while it refuses writes
+// Only GET is supported
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := models.KVStashHealth{Status: store.HealthOK}
+	for _, check := range kvStore.Health() {
+		resp.Checks = append(resp.Checks, models.KVStashHealthCheck{
+			Name:    check.Name,
+			Status:  check.Status,
+			Message: check.Message,
+		})
+		if check.Status == store.HealthFail || (check.Status == store.HealthWarn && resp.Status == store.HealthOK) {
+			resp.Status = check.Status
+		}
+	}
+
+	if resp.Status == store.HealthFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.From(r.Context()).Warnf("healthHandler: failed to encode response: %v", err)
+	}
+}
+
+// clockStats converts the store's clock statistics for the stats response
+func clockStats(c store.ClockStats) models.KVStashClockStats {
+	stats := models.KVStashClockStats{
+		Now:    c.Now.UTC().Format(time.RFC3339Nano),
+		Skewed: c.Skewed,
+		SkewMs: float64(c.Skew) / float64(time.Millisecond),
+		Jumps:  c.Jumps,
+	}
+	if !c.SkewedSince.IsZero() {
+		stats.SkewedSince = c.SkewedSince.UTC().Format(time.RFC3339)
+	}
+	if !c.HighWater.IsZero() {
+		stats.HighWater = c.HighWater.UTC().Format(time.RFC3339)
+	}
+	return stats
+}
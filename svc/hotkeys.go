@@ -0,0 +1,55 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"strconv"
+)
+
+// hotKeysHandler lists the keys read and written most often, see store.HotKeys
+// Only GET is supported, with an optional ?top= (number of keys listed, default constants.HotKeysTopN)
+func hotKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendError := func(statusCode int, message string) {
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(models.KVStashResponse{Success: false, Message: message})
+	}
+
+	if r.Method != http.MethodGet {
+		sendError(http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	top := constants.HotKeysTopN
+	if param := r.URL.Query().Get("top"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed <= 0 || parsed > constants.HotKeysTracked {
+			sendError(http.StatusBadRequest, "invalid top, must be between 1 and "+strconv.Itoa(constants.HotKeysTracked))
+			return
+		}
+		top = parsed
+	}
+
+	hot := kvStore.HotKeys(top)
+	resp := models.KVStashHotKeys{
+		HalfLifeSeconds: constants.HotKeyHalfLife,
+		Keys:            make([]models.KVStashHotKey, 0, len(hot)),
+	}
+	for _, hk := range hot {
+		key, encoding := jsonKey(hk.Key)
+		resp.Keys = append(resp.Keys, models.KVStashHotKey{
+			Key:         key,
+			KeyEncoding: encoding,
+			Accesses:    hk.Accesses,
+			Share:       hk.Share,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.From(r.Context()).Warnf("hotKeysHandler: failed to encode response: %v", err)
+	}
+}
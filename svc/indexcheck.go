@@ -0,0 +1,132 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"time"
+)
+
+// indexCheckStats returns the configuration and results of the index checks
+func indexCheckStats() models.KVStashIndexCheckStats {
+	s := kvStore.IndexCheckStats()
+	return models.KVStashIndexCheckStats{
+		IntervalSeconds: s.Interval.Seconds(),
+		Sample:          s.Sample,
+		MaxDivergence:   s.MaxDivergence,
+		Runs:            s.Runs,
+		Divergent:       s.Divergent,
+		Rebuilds:        s.Rebuilds,
+		Last:            indexCheckReport(s.Last),
+		LastRebuild:     reindexReport(s.LastRebuild),
+	}
+}
+
+// indexCheckReport converts the result of store.CheckIndex, nil if r is nil
+func indexCheckReport(r *store.IndexCheckReport) *models.KVStashIndexCheckReport {
+	if r == nil {
+		return nil
+	}
+	report := &models.KVStashIndexCheckReport{
+		Time:        r.Time,
+		Checked:     r.Checked,
+		Divergent:   r.Divergent,
+		Divergences: make([]models.KVStashIndexDivergence, 0, len(r.Divergences)),
+		Rebuild:     reindexReport(r.Rebuild),
+		Error:       r.Error,
+	}
+	for _, d := range r.Divergences {
+		key, encoding := jsonKey(d.Key)
+		report.Divergences = append(report.Divergences, models.KVStashIndexDivergence{
+			Key:         key,
+			KeyEncoding: encoding,
+			Segment:     d.Segment,
+			Offset:      d.Offset,
+			Reason:      d.Reason,
+		})
+	}
+	return report
+}
+
+// reindexReport converts the result of store.Reindex, nil if r is nil
+func reindexReport(r *store.ReindexReport) *models.KVStashReindexReport {
+	if r == nil {
+		return nil
+	}
+	return &models.KVStashReindexReport{
+		Start:      r.Start,
+		DurationMs: float64(r.Duration) / float64(time.Millisecond),
+		Segments:   r.Segments,
+		Keys:       r.Keys,
+		Added:      r.Added,
+		Removed:    r.Removed,
+		Changed:    r.Changed,
+	}
+}
+
+// indexCheckHandler reports the index checks on GET, and on POST checks a sample of the index against the
+// segments right away, rebuilding the index if too much of it diverges, see store.CheckIndex
+func indexCheckHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, resp any) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("indexCheckHandler: failed to encode response: %v", err)
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sendResponse(http.StatusOK, indexCheckStats())
+	case http.MethodPost:
+		report, err := kvStore.CheckIndex()
+		resp := models.KVStashIndexCheckResponse{Success: err == nil, Check: indexCheckReport(report)}
+		if err != nil {
+			resp.Message = err.Error()
+			recordAudit(r, audit.OpIndexCheck, "", 0, http.StatusInternalServerError)
+			sendResponse(http.StatusInternalServerError, resp)
+			return
+		}
+		recordAudit(r, audit.OpIndexCheck, "", 0, http.StatusOK)
+		sendResponse(http.StatusOK, resp)
+	default:
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashIndexCheckResponse{})
+	}
+}
+
+// reindexHandler rebuilds the index from the segments whether or not it diverges, see store.Reindex
+// Only POST is supported; responds with 409 if the store is being relocated or closed
+func reindexHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, resp models.KVStashIndexCheckResponse) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("reindexHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashIndexCheckResponse{})
+		return
+	}
+
+	report, err := kvStore.Reindex()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, store.ErrReindexSkipped) {
+			status = http.StatusConflict
+		}
+		recordAudit(r, audit.OpReindex, "", 0, status)
+		sendResponse(status, models.KVStashIndexCheckResponse{Message: err.Error()})
+		return
+	}
+
+	recordAudit(r, audit.OpReindex, "", 0, http.StatusOK)
+	sendResponse(http.StatusOK, models.KVStashIndexCheckResponse{Success: true, Rebuild: reindexReport(report)})
+}
@@ -0,0 +1,103 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"io"
+	"net/http"
+)
+
+// jsonMethodOps maps the HTTP methods of /kvstash/json to the operation recorded in the audit trail
+var jsonMethodOps = map[string]string{
+	http.MethodPatch:  "json.set",
+	http.MethodDelete: "json.del",
+}
+
+// jsonHandler reads and updates JSON documents by path
+// Query parameters:
+//   - key: the key holding the document
+//   - path: a JSON path such as $.a.b[0] (optional, defaults to $, the whole document)
+//
+// GET returns the value at path, PATCH sets it to the JSON value in the request body (setting $ creates
+// the document), and DELETE removes it (deleting $ deletes the key)
+// Responds with 404 if the key or path does not exist and 409 if the key holds another kind of value
+func jsonHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	key, path := query.Get("key"), query.Get("path")
+	trace := startSlowTrace(r, "json")
+
+	var bodySize int
+	sendResponse := func(statusCode int, resp models.KVStashJSONResponse) {
+		trace.markStored()
+		if op := jsonMethodOps[r.Method]; op != "" {
+			recordAudit(r, op, key, bodySize, statusCode)
+		}
+
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("jsonHandler: failed to encode response: %v", err)
+		}
+		trace.finish(key, 0, statusCode)
+	}
+
+	sendError := func(err error) {
+		statusCode, message := jsonErrorStatus(err)
+		logFailure(r, statusCode, "jsonHandler: %v failed: %v", r.Method, err)
+		sendResponse(statusCode, models.KVStashJSONResponse{Message: message})
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		trace.markDecoded()
+		value, err := kvStore.JSONGet(key, path)
+		if err != nil {
+			sendError(err)
+			return
+		}
+		sendResponse(http.StatusOK, models.KVStashJSONResponse{Success: true, Data: json.RawMessage(value)})
+
+	case http.MethodPatch:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, constants.MaxValueSize))
+		bodySize = len(body)
+		if err != nil {
+			sendResponse(http.StatusBadRequest, models.KVStashJSONResponse{Message: "failed to read body: " + err.Error()})
+			return
+		}
+		trace.markDecoded()
+
+		if err := kvStore.JSONSet(key, path, string(body)); err != nil {
+			sendError(err)
+			return
+		}
+		sendResponse(http.StatusOK, models.KVStashJSONResponse{Success: true})
+
+	case http.MethodDelete:
+		trace.markDecoded()
+		if err := kvStore.JSONDel(key, path); err != nil {
+			sendError(err)
+			return
+		}
+		sendResponse(http.StatusOK, models.KVStashJSONResponse{Success: true})
+
+	default:
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashJSONResponse{})
+	}
+}
+
+// jsonErrorStatus maps an error of a JSON document operation to a status code and message
+func jsonErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrBadJSONPath), errors.Is(err, store.ErrInvalidJSON):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, store.ErrJSONPathNotFound):
+		return http.StatusNotFound, store.ErrJSONPathNotFound.Error()
+	}
+
+	return collectionErrorStatus(err)
+}
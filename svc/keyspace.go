@@ -0,0 +1,91 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"strconv"
+)
+
+// keyspaceHandler reports value size and key length histograms, the largest values, and key counts per prefix
+// Only GET is supported, with optional parameters ?top= (number of largest values), ?delimiter= (end of a prefix,
+// default ":"), and ?sample= (fraction of keys analyzed, default 1)
+func keyspaceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendError := func(statusCode int, message string) {
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(models.KVStashResponse{Success: false, Message: message})
+	}
+
+	if r.Method != http.MethodGet {
+		sendError(http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	query := r.URL.Query()
+	opts := store.KeyspaceOptions{Delimiter: query.Get("delimiter")}
+	if top := query.Get("top"); top != "" {
+		parsed, err := strconv.Atoi(top)
+		if err != nil || parsed <= 0 {
+			sendError(http.StatusBadRequest, "invalid top")
+			return
+		}
+		opts.TopN = parsed
+	}
+	if sample := query.Get("sample"); sample != "" {
+		parsed, err := strconv.ParseFloat(sample, 64)
+		if err != nil || parsed <= 0 {
+			sendError(http.StatusBadRequest, "invalid sample")
+			return
+		}
+		opts.Sample = parsed
+	}
+
+	stats, err := kvStore.Keyspace(opts)
+	if err != nil {
+		sendError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := models.KVStashKeyspaceStats{
+		LiveKeys:      stats.LiveKeys,
+		Analyzed:      stats.Analyzed,
+		Sample:        stats.Sample,
+		ValueBytes:    stats.ValueBytes,
+		KeyBytes:      stats.KeyBytes,
+		ValueSizes:    sizeBuckets(stats.ValueSizes),
+		KeyLengths:    sizeBuckets(stats.KeyLengths),
+		Largest:       make([]models.KVStashKeySize, 0, len(stats.Largest)),
+		Prefixes:      make([]models.KVStashPrefixCount, 0, len(stats.Prefixes)),
+		OtherPrefixes: stats.OtherPrefixes,
+	}
+	for _, ks := range stats.Largest {
+		key, encoding := jsonKey(ks.Key)
+		resp.Largest = append(resp.Largest, models.KVStashKeySize{Key: key, KeyEncoding: encoding, Size: ks.Size})
+	}
+	for _, p := range stats.Prefixes {
+		prefix, encoding := jsonKey(p.Prefix)
+		resp.Prefixes = append(resp.Prefixes, models.KVStashPrefixCount{
+			Prefix:         prefix,
+			PrefixEncoding: encoding,
+			Keys:           p.Keys,
+			ValueBytes:     p.ValueBytes,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.From(r.Context()).Warnf("keyspaceHandler: failed to encode response: %v", err)
+	}
+}
+
+// sizeBuckets converts a size histogram to its JSON form
+func sizeBuckets(buckets []store.SizeBucket) []models.KVStashSizeBucket {
+	resp := make([]models.KVStashSizeBucket, 0, len(buckets))
+	for _, b := range buckets {
+		resp = append(resp, models.KVStashSizeBucket{UpTo: b.UpTo, Count: b.Count})
+	}
+	return resp
+}
@@ -0,0 +1,156 @@
+package svc
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// errOverloaded is returned by limiter.acquire when the wait queue is full
+var errOverloaded = errors.New("server overloaded")
+
+// limiter caps the number of key-value requests served concurrently
+// Requests beyond the cap wait in a bounded FIFO queue until a slot frees up or their deadline expires;
+// requests arriving at a full queue are rejected right away, since they would most likely time out anyway
+type limiter struct {
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// maxInFlight is the number of requests served concurrently, 0 disables the limiter
+	maxInFlight int
+
+	// maxQueued is the number of requests allowed to wait for a slot
+	maxQueued int
+
+	// inFlight is the number of requests being served
+	inFlight int
+
+	// queue holds a channel per waiting request, closed when the request is granted a slot
+	queue *list.List
+
+	// rejected counts the requests rejected because the queue was full
+	rejected uint64
+}
+
+// limits is the limiter of key-value requests
+var limits = &limiter{
+	maxInFlight: constants.MaxInFlightRequests,
+	maxQueued:   constants.MaxQueuedRequests,
+	queue:       list.New(),
+}
+
+// SetConcurrencyLimit changes the number of key-value requests served concurrently and the number allowed to wait
+// A maxInFlight of 0 disables the limit; queued requests are admitted right away if the limit grows
+func SetConcurrencyLimit(maxInFlight int, maxQueued int) {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+
+	limits.maxInFlight = maxInFlight
+	limits.maxQueued = maxQueued
+	limits.grant()
+}
+
+// ConcurrencyLimit returns the number of key-value requests served concurrently and the number allowed to wait
+func ConcurrencyLimit() (maxInFlight int, maxQueued int) {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+
+	return limits.maxInFlight, limits.maxQueued
+}
+
+// acquire waits for a slot, returning errOverloaded if the queue is full or ctx's error if it ends first
+// Every successful acquire must be paired with a release
+func (l *limiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.maxInFlight <= 0 || (l.inFlight < l.maxInFlight && l.queue.Len() == 0) {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.queue.Len() >= l.maxQueued {
+		l.rejected++
+		l.mu.Unlock()
+		return errOverloaded
+	}
+	granted := make(chan struct{})
+	elem := l.queue.PushBack(granted)
+	l.mu.Unlock()
+
+	select {
+	case <-granted:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		select {
+		case <-granted:
+			// Granted while giving up; hand the slot on
+			l.inFlight--
+			l.grant()
+		default:
+			l.queue.Remove(elem)
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees the slot of a finished request, admitting the next queued one
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.grant()
+}
+
+// grant admits queued requests while slots are free
+// The caller must hold mu
+func (l *limiter) grant() {
+	for l.queue.Len() > 0 && (l.maxInFlight <= 0 || l.inFlight < l.maxInFlight) {
+		close(l.queue.Remove(l.queue.Front()).(chan struct{}))
+		l.inFlight++
+	}
+}
+
+// stats returns the limiter's settings and counters
+func (l *limiter) stats() models.KVStashLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return models.KVStashLimiterStats{
+		MaxInFlight: l.maxInFlight,
+		MaxQueued:   l.maxQueued,
+		InFlight:    l.inFlight,
+		Queued:      l.queue.Len(),
+		Rejected:    l.rejected,
+	}
+}
+
+// withLimit runs next once the limiter grants the request a slot
+// Requests rejected by a full queue are answered with 503 and a Retry-After header; a request whose deadline
+// expires while queued is abandoned, and withTimeout answers it
+func withLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := limits.acquire(r.Context()); err != nil {
+			if errors.Is(err, errOverloaded) {
+				logging.From(r.Context()).Warnf("withLimit: rejected %v %v, %v", r.Method, r.URL.Path, err)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", strconv.Itoa(constants.OverloadRetryAfter))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(models.KVStashResponse{Success: false, Message: err.Error()})
+			}
+			return
+		}
+		defer limits.release()
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,61 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"time"
+)
+
+// maintenanceHandler reports or changes the maintenance mode, in which writes are rejected with 503 and reads continue
+// GET returns the current mode; POST sets it from a JSON body such as {"enabled": true, "message": "nightly backup"}
+// and returns the new mode
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, resp any) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("maintenanceHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashResponse{})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req models.KVStashMaintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendResponse(http.StatusBadRequest, models.KVStashResponse{Message: "invalid json body"})
+			return
+		}
+
+		kvStore.SetMaintenance(req.Enabled, req.Message)
+		op := audit.OpMaintenanceOff
+		if req.Enabled {
+			op = audit.OpMaintenanceOn
+		}
+		recordAudit(r, op, "", 0, http.StatusOK)
+	}
+
+	m := kvStore.Maintenance()
+	resp := models.KVStashMaintenanceStats{Enabled: m.Enabled, Message: m.Message}
+	if !m.Since.IsZero() {
+		resp.Since = m.Since.Format(time.RFC3339)
+	}
+	sendResponse(http.StatusOK, resp)
+}
+
+// maintenanceMessage returns the message of a write rejected in maintenance mode, with the operator's reason
+func maintenanceMessage() string {
+	message := store.ErrMaintenance.Error()
+	if reason := kvStore.Maintenance().Message; reason != "" {
+		message += ": " + reason
+	}
+	return message
+}
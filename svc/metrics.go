@@ -0,0 +1,319 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// startTime is when the server started, used to report uptime
+var startTime = time.Now()
+
+// opMetrics tracks the requests of a single operation
+type opMetrics struct {
+	// mu protects all fields
+	mu sync.Mutex
+
+	// count is the total number of requests
+	count uint64
+
+	// errors is the number of requests answered with a 5xx status
+	errors uint64
+
+	// samples is a ring of the most recent request latencies
+	samples [constants.LatencySamples]time.Duration
+
+	// next is the position in samples the next latency is written to
+	next int
+
+	// filled is the number of valid entries in samples
+	filled int
+
+	// latency counts every request latency, exported to Prometheus
+	latency store.Histogram
+}
+
+// record adds a finished request to the metrics
+func (m *opMetrics) record(latency time.Duration, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.latency.Observe(latency)
+	m.count++
+	if status >= 500 {
+		m.errors++
+	}
+
+	m.samples[m.next] = latency
+	m.next = (m.next + 1) % len(m.samples)
+	if m.filled < len(m.samples) {
+		m.filled++
+	}
+}
+
+// stats returns the counters and latency percentiles of the operation
+func (m *opMetrics) stats() models.KVStashOpStats {
+	m.mu.Lock()
+	sorted := slices.Clone(m.samples[:m.filled])
+	stats := models.KVStashOpStats{Count: m.count, Errors: m.errors}
+	m.mu.Unlock()
+
+	slices.Sort(sorted)
+	stats.P50Ms = percentileMs(sorted, 0.50)
+	stats.P95Ms = percentileMs(sorted, 0.95)
+	stats.P99Ms = percentileMs(sorted, 0.99)
+
+	return stats
+}
+
+// percentileMs returns the p-th percentile of the sorted latencies in milliseconds
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(p * float64(len(sorted)-1))
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// latencyStats summarizes a latency histogram
+func latencyStats(h store.HistogramSnapshot) models.KVStashLatencyStats {
+	return models.KVStashLatencyStats{
+		Count:  h.Count,
+		MeanMs: durationMs(h.Mean()),
+		P50Ms:  durationMs(h.Quantile(0.50)),
+		P95Ms:  durationMs(h.Quantile(0.95)),
+		P99Ms:  durationMs(h.Quantile(0.99)),
+	}
+}
+
+// metrics holds the metrics of every instrumented operation
+var metrics = map[string]*opMetrics{
+	"get":        {},
+	"set":        {},
+	"delete":     {},
+	"mget":       {},
+	"range":      {},
+	"collection": {},
+	"json":       {},
+	"batch":      {},
+	"undelete":   {},
+	"rename":     {},
+	"expire":     {},
+	"session":    {},
+}
+
+// methodOps maps the HTTP methods of /kvstash to the operation they perform
+var methodOps = map[string]string{
+	http.MethodGet:    "get",
+	http.MethodPost:   "set",
+	http.MethodDelete: "delete",
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+
+	// status is the status code of the response
+	status int
+}
+
+// WriteHeader records the status code and forwards it
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument wraps a handler to record the count, errors, and latency of its requests
+// op returns the operation a request performs, or "" to leave the request unrecorded
+func instrument(op func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next(rec, r)
+
+		if name := op(r); metrics[name] != nil {
+			latency := time.Since(start)
+			metrics[name].record(latency, rec.status)
+			recordOTelLatency(name, latency)
+		}
+	}
+}
+
+// statsHandler reports request metrics, store statistics, and compaction activity
+// Only GET is supported
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := kvStore.Stats()
+	resp := models.KVStashStats{
+		UptimeSeconds: time.Since(startTime).Seconds(),
+		Panics:        panics.Load(),
+		Requests:      make(map[string]models.KVStashOpStats, len(metrics)),
+		StoreLatency:  make(map[string]map[string]models.KVStashLatencyStats),
+		SegmentIO:     make(map[string]map[string]models.KVStashLatencyStats),
+		Store: models.KVStashStoreStats{
+			DataDir:           s.DataDir,
+			Segments:          s.Segments,
+			ActiveLog:         s.ActiveLog,
+			SegmentLimit:      s.Sizing.Limit,
+			RecordBytes:       s.Sizing.RecordBytes,
+			WriteBytesPerSec:  s.Sizing.WriteRate,
+			LiveKeys:          s.LiveKeys,
+			DeletedKeys:       s.DeletedKeys,
+			DiskBytes:         s.DiskBytes,
+			GarbageRatio:      s.GarbageRatio,
+			OpenSnapshots:     s.OpenSnapshots,
+			DiskFreeBytes:     s.Disk.FreeBytes,
+			DiskLow:           s.Disk.Low,
+			SoftLimitRatio:    s.SoftLimits.Ratio,
+			SoftLimitWarnings: s.SoftLimits.Warnings,
+			InlineThreshold:   s.Inline.Threshold,
+			InlineValues:      s.Inline.Values,
+			InlineBytes:       s.Inline.Bytes,
+			InlineMemoryBytes: s.Inline.MemoryBytes,
+			InlineHits:        s.Inline.Hits,
+			ReadCacheBudget:   s.ReadCache.Budget,
+			ReadCacheValues:   s.ReadCache.Values,
+			ReadCacheBytes:    s.ReadCache.Bytes,
+			ReadCacheHits:     s.ReadCache.Hits,
+			ReadCacheMisses:   s.ReadCache.Misses,
+			ReadCacheEvicted:  s.ReadCache.Evictions,
+			ValueTransformers: s.Transformers,
+			TransformedKeys:   s.TransformedKeys,
+		},
+		Compaction: models.KVStashCompactionStats{
+			Running:         s.Compaction.Running,
+			Runs:            s.Compaction.Runs,
+			Failures:        s.Compaction.Failures,
+			Skipped:         s.Compaction.Skipped,
+			LastDurationMs:  float64(s.Compaction.LastDuration) / float64(time.Millisecond),
+			LastBytesBefore: s.Compaction.LastBytesBefore,
+			LastBytesAfter:  s.Compaction.LastBytesAfter,
+			Paused:          s.Compaction.Paused,
+			PauseReason:     s.Compaction.PauseReason,
+		},
+		Breaker: models.KVStashBreakerStats{
+			Degraded:            s.Breaker.Degraded,
+			ConsecutiveFailures: s.Breaker.ConsecutiveFailures,
+			Trips:               s.Breaker.Trips,
+			LastError:           s.Breaker.LastError,
+			Failovers:           s.Breaker.Failovers,
+		},
+		Maintenance: models.KVStashMaintenanceStats{
+			Enabled: s.Maintenance.Enabled,
+			Message: s.Maintenance.Message,
+		},
+		Scheduler:  schedulerStats(),
+		IndexCheck: indexCheckStats(),
+		Clock:      clockStats(s.Clock),
+		Retention: models.KVStashRetentionStats{
+			RetentionSeconds: s.Retention.Retention.Seconds(),
+			SegmentsDropped:  s.Retention.SegmentsDropped,
+			KeysDropped:      s.Retention.KeysDropped,
+		},
+		Amplification: models.KVStashAmplificationStats{
+			Writes:             s.Amplification.Writes,
+			LogicalBytes:       s.Amplification.LogicalBytes,
+			LogBytes:           s.Amplification.LogBytes,
+			CompactionBytes:    s.Amplification.CompactionBytes,
+			BackupBytes:        s.Amplification.BackupBytes,
+			Flushes:            s.Amplification.Flushes,
+			WriteAmplification: s.Amplification.WriteAmplification,
+			LiveBytes:          s.Amplification.LiveBytes,
+			SpaceAmplification: s.Amplification.SpaceAmplification,
+		},
+		Limiter: limits.stats(),
+		Alerts:  alertStats(),
+		Mirror:  mirrorStats(),
+	}
+	if !s.Compaction.LastStart.IsZero() {
+		resp.Compaction.LastStart = s.Compaction.LastStart.Format(time.RFC3339)
+	}
+	if !s.Compaction.PausedSince.IsZero() {
+		resp.Compaction.PausedSince = s.Compaction.PausedSince.Format(time.RFC3339)
+	}
+	if s.DeletionHistory.Path != "" {
+		resp.DeletionHistory = &models.KVStashDeletionHistoryStats{
+			RetentionSeconds: s.DeletionHistory.Retention.Seconds(),
+			Archived:         s.DeletionHistory.Archived,
+		}
+	}
+	if !s.Retention.OldestSegment.IsZero() {
+		resp.Retention.OldestSegment = s.Retention.OldestSegment.Format(time.RFC3339)
+	}
+	if !s.Breaker.DegradedSince.IsZero() {
+		resp.Breaker.DegradedSince = s.Breaker.DegradedSince.Format(time.RFC3339)
+	}
+	if !s.Breaker.LastFailover.IsZero() {
+		resp.Breaker.LastFailover = s.Breaker.LastFailover.Format(time.RFC3339)
+	}
+	if !s.Maintenance.Since.IsZero() {
+		resp.Maintenance.Since = s.Maintenance.Since.Format(time.RFC3339)
+	}
+	for op, m := range metrics {
+		resp.Requests[op] = m.stats()
+	}
+	for _, l := range kvStore.Latencies() {
+		phases := map[string]models.KVStashLatencyStats{store.PhaseTotal: latencyStats(l.Total)}
+		for phase, h := range l.Phases {
+			phases[phase] = latencyStats(h)
+		}
+		resp.StoreLatency[l.Op] = phases
+	}
+	for _, io := range kvStore.SegmentIO() {
+		resp.SegmentIO[io.Segment] = map[string]models.KVStashLatencyStats{
+			"sync":             latencyStats(io.Sync),
+			"compaction_read":  latencyStats(io.CompactionRead),
+			"compaction_write": latencyStats(io.CompactionWrite),
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.From(r.Context()).Warnf("statsHandler: failed to encode response: %v", err)
+	}
+}
+
+// compactionsHandler reports the most recent compaction cycles, newest first
+// Only GET is supported
+func compactionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	history := kvStore.CompactionHistory()
+	resp := models.KVStashCompactionHistory{Runs: make([]models.KVStashCompactionRun, 0, len(history))}
+	for _, run := range history {
+		resp.Runs = append(resp.Runs, models.KVStashCompactionRun{
+			Trigger:        run.Trigger,
+			Start:          run.Start.Format(time.RFC3339),
+			DurationMs:     durationMs(run.Duration),
+			Outcome:        run.Outcome,
+			SegmentsBefore: run.SegmentsBefore,
+			SegmentsAfter:  run.SegmentsAfter,
+			BytesBefore:    run.BytesBefore,
+			BytesAfter:     run.BytesAfter,
+			BytesReclaimed: run.BytesBefore - run.BytesAfter,
+			Error:          run.Error,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.From(r.Context()).Warnf("compactionsHandler: failed to encode response: %v", err)
+	}
+}
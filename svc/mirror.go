@@ -0,0 +1,97 @@
+package svc
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/mirror"
+	"github.com/vi88i/kvstash/models"
+	"io"
+	"net/http"
+)
+
+// mirrors replays sampled writes against a secondary, nil if mirroring is disabled
+var mirrors *mirror.Mirror
+
+// SetMirror enables mirroring a sample of writes to a secondary
+// Must be called before StartHTTPServer
+func SetMirror(m *mirror.Mirror) {
+	mirrors = m
+}
+
+// mirrorStats returns the mirroring counters for the stats endpoint, nil if mirroring is disabled
+func mirrorStats() *models.KVStashMirrorStats {
+	if mirrors == nil {
+		return nil
+	}
+
+	s := mirrors.Stats()
+	return &models.KVStashMirrorStats{
+		Target:   s.Target,
+		Percent:  s.Percent,
+		Mirrored: s.Mirrored,
+		Diverged: s.Diverged,
+		Failed:   s.Failed,
+		Dropped:  s.Dropped,
+	}
+}
+
+// mirroredHeaders are the request headers copied to mirrored requests
+var mirroredHeaders = []string{"Content-Type", "Idempotency-Key"}
+
+// isWriteMethod reports whether a request to an endpoint is a write by its method alone
+func isWriteMethod(methods ...string) func(r *http.Request, body []byte) bool {
+	return func(r *http.Request, body []byte) bool {
+		for _, m := range methods {
+			if r.Method == m {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isCollectionWrite reports whether a collections request is a write, see collectionWrites
+func isCollectionWrite(r *http.Request, body []byte) bool {
+	var req models.KVStashCollectionRequest
+	return r.Method == http.MethodPost && json.Unmarshal(body, &req) == nil && collectionWrites[req.Op]
+}
+
+// withMirror queues a copy of sampled writes for the secondary once they are answered, if mirroring is enabled
+// isWrite tells writes from reads by the request and its body
+func withMirror(isWrite func(r *http.Request, body []byte) bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mirrors == nil || r.Method == http.MethodGet || !mirrors.Sample() {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, constants.MaxMirrorBodySize+1))
+		if err != nil || len(body) > constants.MaxMirrorBodySize {
+			// Let the handler see the whole body, or the read error
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			if isWrite(r, nil) {
+				mirrors.Drop()
+			}
+			next(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if !isWrite(r, body) {
+			next(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		header := make(http.Header)
+		for _, name := range mirroredHeaders {
+			if value := r.Header.Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		header.Set(requestIDHeader, requestID(r))
+		mirrors.Send(mirror.Request{Method: r.Method, URI: r.URL.RequestURI(), Header: header, Body: body, Status: rec.status})
+	}
+}
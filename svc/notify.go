@@ -0,0 +1,93 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notifyHandler streams keyspace notifications as server-sent events
+// Query parameters: events, a comma separated list of event classes (default all), and prefix
+// Every event is sent as an SSE event named after its class, with the JSON event as data; when the client
+// falls behind and notifications are dropped, a "dropped" event with their count follows
+// Idle streams carry a comment every NotifyHeartbeat seconds so proxies keep them open
+func notifyHandler(w http.ResponseWriter, r *http.Request) {
+	sendError := func(statusCode int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		fmt.Fprintf(w, "{\"success\":false,\"message\":%q}\n", message)
+	}
+
+	if r.Method != http.MethodGet {
+		sendError(http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	query := r.URL.Query()
+	var classes []string
+	if events := query.Get("events"); events != "" {
+		classes = strings.Split(events, ",")
+	}
+
+	sub, err := kvStore.Subscribe(query.Get("prefix"), classes)
+	if err != nil {
+		if errors.Is(err, store.ErrUnknownEventClass) {
+			sendError(http.StatusBadRequest, err.Error())
+		} else {
+			logging.From(r.Context()).Errorf("notifyHandler: failed to subscribe: %v", err)
+			sendError(http.StatusInternalServerError, "subscribe failed")
+		}
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(constants.NotifyHeartbeat * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event := <-sub.Events():
+			event.Key, event.KeyEncoding = jsonKey(event.Key)
+			data, err := json.Marshal(event)
+			if err != nil {
+				logging.From(r.Context()).Warnf("notifyHandler: failed to encode event: %v", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %v\nid: %d\ndata: %s\n\n", event.Type, event.Seq, data); err != nil {
+				return
+			}
+		}
+
+		if dropped := sub.Dropped(); dropped > 0 {
+			if _, err := fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,187 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"os"
+	"strings"
+	"time"
+)
+
+// otelMeterName identifies the instrumentation scope of the exported metrics
+const otelMeterName = "github.com/vi88i/kvstash"
+
+// requestDuration records the latency of every instrumented request, nil unless the OTLP exporter is running
+var requestDuration metric.Float64Histogram
+
+// otelEnabled reports whether the standard OTEL environment variables ask for OTLP metrics export
+// Export is enabled by an OTLP endpoint (OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_METRICS_ENDPOINT)
+// or OTEL_METRICS_EXPORTER=otlp, and disabled by OTEL_SDK_DISABLED=true or OTEL_METRICS_EXPORTER=none
+func otelEnabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+
+	switch os.Getenv("OTEL_METRICS_EXPORTER") {
+	case "none":
+		return false
+	case "otlp":
+		return true
+	}
+
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT") != ""
+}
+
+// StartOTelExporter pushes store and HTTP metrics to an OpenTelemetry collector over OTLP/HTTP
+// It is configured entirely by the standard OTEL environment variables (endpoint, headers, export
+// interval, service name, resource attributes) and does nothing unless they enable it, see otelEnabled
+// The returned function flushes pending metrics and stops the exporter
+func StartOTelExporter(s *store.Store) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if !otelEnabled() {
+		return noop, nil
+	}
+
+	if protocol := otelProtocol(); protocol != "" && protocol != "http/protobuf" {
+		logging.Warnf("StartOTelExporter: protocol %q is not supported, using http/protobuf", protocol)
+	}
+
+	ctx := context.Background()
+	exporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return noop, fmt.Errorf("StartOTelExporter: failed to create exporter: %w", err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "kvstash")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return noop, fmt.Errorf("StartOTelExporter: failed to build resource: %w", err)
+	}
+
+	// The export interval defaults to 60s and is read from OTEL_METRIC_EXPORT_INTERVAL by the reader
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	if err := registerOTelInstruments(provider.Meter(otelMeterName), s); err != nil {
+		provider.Shutdown(ctx)
+		return noop, fmt.Errorf("StartOTelExporter: %w", err)
+	}
+
+	logging.Infof("StartOTelExporter: exporting metrics over OTLP")
+	return provider.Shutdown, nil
+}
+
+// otelProtocol returns the OTLP protocol requested for metrics, if any
+func otelProtocol() string {
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_PROTOCOL"); protocol != "" {
+		return protocol
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+}
+
+// registerOTelInstruments creates the request latency histogram and the observable store and request metrics
+func registerOTelInstruments(meter metric.Meter, s *store.Store) error {
+	duration, err := meter.Float64Histogram("kvstash.request.duration",
+		metric.WithUnit("s"), metric.WithDescription("Latency of HTTP requests by operation"))
+	if err != nil {
+		return fmt.Errorf("registerOTelInstruments: %w", err)
+	}
+
+	// errs collects the errors of the instrument constructors below
+	var errs []error
+	counter := func(name string, unit string, desc string) metric.Int64ObservableCounter {
+		c, err := meter.Int64ObservableCounter(name, metric.WithUnit(unit), metric.WithDescription(desc))
+		errs = append(errs, err)
+		return c
+	}
+	gauge := func(name string, unit string, desc string) metric.Int64ObservableGauge {
+		g, err := meter.Int64ObservableGauge(name, metric.WithUnit(unit), metric.WithDescription(desc))
+		errs = append(errs, err)
+		return g
+	}
+
+	requests := counter("kvstash.requests", "{request}", "HTTP requests by operation")
+	requestErrors := counter("kvstash.request.errors", "{request}", "HTTP requests answered with a 5xx status by operation")
+	recoveredPanics := counter("kvstash.panics", "{panic}", "Request handler panics recovered")
+	segments := gauge("kvstash.store.segments", "{segment}", "Segment files, including the active log")
+	liveKeys := gauge("kvstash.store.live_keys", "{key}", "Live keys in the index")
+	deletedKeys := gauge("kvstash.store.deleted_keys", "{key}", "Deleted keys (tombstones) in the index")
+	diskBytes := gauge("kvstash.store.disk_usage", "By", "Total size of the segment files")
+	snapshots := gauge("kvstash.store.open_snapshots", "{snapshot}", "Unreleased snapshots")
+	compactionRunning := gauge("kvstash.compaction.running", "1", "1 while a compaction cycle is in progress")
+	compactionRuns := counter("kvstash.compaction.runs", "{run}", "Compaction cycles by outcome")
+	degraded := gauge("kvstash.store.degraded", "1", "1 while writes are disabled after repeated storage errors")
+	diskFree := gauge("kvstash.store.disk_free", "By", "Free space on the database volume")
+	breakerTrips := counter("kvstash.store.breaker_trips", "{trip}", "Times repeated storage errors disabled writes")
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("registerOTelInstruments: %w", err)
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		for op, m := range metrics {
+			stats := m.stats()
+			attrs := metric.WithAttributes(attribute.String("op", op))
+			o.ObserveInt64(requests, int64(stats.Count), attrs)
+			o.ObserveInt64(requestErrors, int64(stats.Errors), attrs)
+		}
+
+		o.ObserveInt64(recoveredPanics, int64(panics.Load()))
+
+		st := s.Stats()
+		o.ObserveInt64(segments, int64(st.Segments))
+		o.ObserveInt64(liveKeys, int64(st.LiveKeys))
+		o.ObserveInt64(deletedKeys, int64(st.DeletedKeys))
+		o.ObserveInt64(diskBytes, st.DiskBytes)
+		o.ObserveInt64(snapshots, int64(st.OpenSnapshots))
+
+		running := int64(0)
+		if st.Compaction.Running {
+			running = 1
+		}
+		o.ObserveInt64(compactionRunning, running)
+		o.ObserveInt64(compactionRuns, st.Compaction.Runs, metric.WithAttributes(attribute.String("outcome", "success")))
+		o.ObserveInt64(compactionRuns, st.Compaction.Failures, metric.WithAttributes(attribute.String("outcome", "failure")))
+		o.ObserveInt64(compactionRuns, st.Compaction.Skipped, metric.WithAttributes(attribute.String("outcome", "skipped")))
+
+		isDegraded := int64(0)
+		if st.Breaker.Degraded {
+			isDegraded = 1
+		}
+		o.ObserveInt64(degraded, isDegraded)
+		o.ObserveInt64(breakerTrips, st.Breaker.Trips)
+		if !st.Disk.CheckedAt.IsZero() {
+			o.ObserveInt64(diskFree, int64(st.Disk.FreeBytes))
+		}
+		return nil
+	}, requests, requestErrors, recoveredPanics, segments, liveKeys, deletedKeys, diskBytes, snapshots, compactionRunning,
+		compactionRuns, degraded, breakerTrips, diskFree)
+	if err != nil {
+		return fmt.Errorf("registerOTelInstruments: failed to register callback: %w", err)
+	}
+
+	requestDuration = duration
+	return nil
+}
+
+// recordOTelLatency adds a request to the OTLP latency histogram if the exporter is running
+func recordOTelLatency(op string, latency time.Duration) {
+	if requestDuration == nil {
+		return
+	}
+
+	requestDuration.Record(context.Background(), latency.Seconds(), metric.WithAttributes(attribute.String("op", op)))
+}
@@ -0,0 +1,187 @@
+package svc
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// prometheusHandler serves the request and store metrics in the Prometheus text exposition format
+// Latencies are exported as cumulative histograms in seconds, so quantiles can be computed over any time window
+// Only GET is supported
+func prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	out := bufio.NewWriter(w)
+	ops := make([]string, 0, len(metrics))
+	for op := range metrics {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+
+	writeHeader(out, "kvstash_requests_total", "counter", "HTTP requests by operation")
+	for _, op := range ops {
+		fmt.Fprintf(out, "kvstash_requests_total{op=%q} %d\n", op, metrics[op].stats().Count)
+	}
+	writeHeader(out, "kvstash_request_errors_total", "counter", "HTTP requests answered with a 5xx status by operation")
+	for _, op := range ops {
+		fmt.Fprintf(out, "kvstash_request_errors_total{op=%q} %d\n", op, metrics[op].stats().Errors)
+	}
+	writeHeader(out, "kvstash_request_duration_seconds", "histogram", "Latency of HTTP requests by operation")
+	for _, op := range ops {
+		writeHistogram(out, "kvstash_request_duration_seconds", fmt.Sprintf("op=%q", op), metrics[op].latency.Snapshot())
+	}
+
+	writeHeader(out, "kvstash_store_duration_seconds", "histogram", "Latency of store operations by phase")
+	for _, l := range kvStore.Latencies() {
+		writeHistogram(out, "kvstash_store_duration_seconds", fmt.Sprintf("op=%q,phase=%q", l.Op, store.PhaseTotal), l.Total)
+
+		phases := make([]string, 0, len(l.Phases))
+		for phase := range l.Phases {
+			phases = append(phases, phase)
+		}
+		slices.Sort(phases)
+		for _, phase := range phases {
+			writeHistogram(out, "kvstash_store_duration_seconds", fmt.Sprintf("op=%q,phase=%q", l.Op, phase), l.Phases[phase])
+		}
+	}
+
+	segments := kvStore.SegmentIO()
+	writeHeader(out, "kvstash_segment_sync_duration_seconds", "histogram", "Latency of flushes to stable storage by segment file")
+	for _, io := range segments {
+		writeHistogram(out, "kvstash_segment_sync_duration_seconds", fmt.Sprintf("segment=%q", io.Segment), io.Sync)
+	}
+	writeHeader(out, "kvstash_compaction_read_duration_seconds", "histogram", "Latency of reading a record during compaction by segment file")
+	for _, io := range segments {
+		writeHistogram(out, "kvstash_compaction_read_duration_seconds", fmt.Sprintf("segment=%q", io.Segment), io.CompactionRead)
+	}
+	writeHeader(out, "kvstash_compaction_write_duration_seconds", "histogram", "Latency of writing a record during compaction by segment file")
+	for _, io := range segments {
+		writeHistogram(out, "kvstash_compaction_write_duration_seconds", fmt.Sprintf("segment=%q", io.Segment), io.CompactionWrite)
+	}
+	writeHeader(out, "kvstash_compaction_duration_seconds", "histogram", "Duration of the compaction cycles that ran, successful or not")
+	writeHistogram(out, "kvstash_compaction_duration_seconds", "", kvStore.CompactionDurations())
+
+	s := kvStore.Stats()
+	degraded := 0
+	if s.Breaker.Degraded {
+		degraded = 1
+	}
+	maintenance := 0
+	if s.Maintenance.Enabled {
+		maintenance = 1
+	}
+	writeGauge(out, "kvstash_uptime_seconds", "Time since the server started", time.Since(startTime).Seconds())
+	writeGauge(out, "kvstash_segments", "Segment files, including the active log", float64(s.Segments))
+	writeGauge(out, "kvstash_segment_limit", "Writes after which the active log is rotated", float64(s.Sizing.Limit))
+	writeGauge(out, "kvstash_live_keys", "Live keys in the index", float64(s.LiveKeys))
+	writeGauge(out, "kvstash_deleted_keys", "Deleted keys (tombstones) in the index", float64(s.DeletedKeys))
+	writeGauge(out, "kvstash_index_entries", "Entries in the index, live and deleted", float64(s.LiveKeys+s.DeletedKeys))
+	writeGauge(out, "kvstash_disk_bytes", "Total size of the segment files", float64(s.DiskBytes))
+	writeGauge(out, "kvstash_garbage_ratio", "Fraction of the segment files not taken up by live keys", s.GarbageRatio)
+	writeGauge(out, "kvstash_transformed_keys", "Live keys whose value is stored transformed", float64(s.TransformedKeys))
+	writeGauge(out, "kvstash_inline_values", "Values kept in the index", float64(s.Inline.Values))
+	writeGauge(out, "kvstash_inline_memory_bytes", "Memory taken by the values kept in the index", float64(s.Inline.MemoryBytes))
+	writeHeader(out, "kvstash_inline_hits_total", "counter", "Reads served from values kept in the index")
+	fmt.Fprintf(out, "kvstash_inline_hits_total %d\n", s.Inline.Hits)
+	writeGauge(out, "kvstash_read_cache_values", "Values in the read cache", float64(s.ReadCache.Values))
+	writeGauge(out, "kvstash_read_cache_bytes", "Memory taken by the read cache", float64(s.ReadCache.Bytes))
+	writeHeader(out, "kvstash_read_cache_hits_total", "counter", "Reads served from the read cache")
+	fmt.Fprintf(out, "kvstash_read_cache_hits_total %d\n", s.ReadCache.Hits)
+	writeHeader(out, "kvstash_read_cache_misses_total", "counter", "Reads of values not in the read cache")
+	fmt.Fprintf(out, "kvstash_read_cache_misses_total %d\n", s.ReadCache.Misses)
+	writeHeader(out, "kvstash_read_cache_evictions_total", "counter", "Values evicted from the read cache")
+	fmt.Fprintf(out, "kvstash_read_cache_evictions_total %d\n", s.ReadCache.Evictions)
+	writeHeader(out, "kvstash_get_hits_total", "counter", "Keys read by get and mget that were found")
+	fmt.Fprintf(out, "kvstash_get_hits_total %d\n", s.Reads.Hits)
+	writeHeader(out, "kvstash_get_misses_total", "counter", "Keys read by get and mget that were missing, deleted, or expired")
+	fmt.Fprintf(out, "kvstash_get_misses_total %d\n", s.Reads.Misses)
+	writeHeader(out, "kvstash_soft_limit_warnings_total", "counter", "Writes answered with a warning that they came close to a limit by limit")
+	for _, limit := range []string{models.WarnValueSize, models.WarnNamespaceQuota, models.WarnDiskSpace} {
+		fmt.Fprintf(out, "kvstash_soft_limit_warnings_total{limit=%q} %d\n", limit, s.SoftLimits.Warnings[limit])
+	}
+	writeGauge(out, "kvstash_degraded", "1 while writes are disabled after repeated storage errors", float64(degraded))
+	writeGauge(out, "kvstash_maintenance", "1 while writes are disabled by maintenance mode", float64(maintenance))
+
+	scheduler := kvStore.SchedulerStats()
+	schedulerPaused := 0
+	if scheduler.Paused {
+		schedulerPaused = 1
+	}
+	writeGauge(out, "kvstash_scheduler_paused", "1 while the background jobs are held through the admin endpoint", float64(schedulerPaused))
+	writeHeader(out, "kvstash_job_runs_total", "counter", "Runs of the store's background jobs by job")
+	for _, job := range scheduler.Jobs {
+		fmt.Fprintf(out, "kvstash_job_runs_total{job=%q} %d\n", job.Name, job.Runs)
+	}
+
+	writeGauge(out, "kvstash_clock_skew_seconds", "How far the system clock is ahead of the store's time while skewed, negative if behind", s.Clock.Skew.Seconds())
+	writeHeader(out, "kvstash_clock_jumps_total", "counter", "Jumps of the system clock the store ignored")
+	fmt.Fprintf(out, "kvstash_clock_jumps_total %d\n", s.Clock.Jumps)
+	writeGauge(out, "kvstash_retention_seconds", "Age after which segments are dropped, 0 if they are kept", s.Retention.Retention.Seconds())
+	writeHeader(out, "kvstash_retention_dropped_segments_total", "counter", "Segments dropped by age")
+	fmt.Fprintf(out, "kvstash_retention_dropped_segments_total %d\n", s.Retention.SegmentsDropped)
+	writeHeader(out, "kvstash_retention_dropped_keys_total", "counter", "Index entries dropped with their segment by age")
+	fmt.Fprintf(out, "kvstash_retention_dropped_keys_total %d\n", s.Retention.KeysDropped)
+
+	amp := s.Amplification
+	writeGauge(out, "kvstash_write_amplification", "Bytes written to disk per byte of key and value written by clients", amp.WriteAmplification)
+	writeGauge(out, "kvstash_space_amplification", "Size of the segment files per byte of key and value of the live keys", amp.SpaceAmplification)
+	writeHeader(out, "kvstash_logical_bytes_total", "counter", "Bytes of keys and values written by clients")
+	fmt.Fprintf(out, "kvstash_logical_bytes_total %d\n", amp.LogicalBytes)
+	writeHeader(out, "kvstash_disk_write_bytes_total", "counter", "Bytes written to disk by destination")
+	fmt.Fprintf(out, "kvstash_disk_write_bytes_total{destination=\"log\"} %d\n", amp.LogBytes)
+	fmt.Fprintf(out, "kvstash_disk_write_bytes_total{destination=\"compaction\"} %d\n", amp.CompactionBytes)
+	fmt.Fprintf(out, "kvstash_disk_write_bytes_total{destination=\"backup\"} %d\n", amp.BackupBytes)
+	writeHeader(out, "kvstash_log_flushes_total", "counter", "Flushes of the active log to stable storage")
+	fmt.Fprintf(out, "kvstash_log_flushes_total %d\n", amp.Flushes)
+
+	if m := mirrorStats(); m != nil {
+		writeHeader(out, "kvstash_mirror_requests_total", "counter", "Writes sampled for mirroring to the secondary by result")
+		fmt.Fprintf(out, "kvstash_mirror_requests_total{result=\"mirrored\"} %d\n", m.Mirrored)
+		fmt.Fprintf(out, "kvstash_mirror_requests_total{result=\"failed\"} %d\n", m.Failed)
+		fmt.Fprintf(out, "kvstash_mirror_requests_total{result=\"dropped\"} %d\n", m.Dropped)
+		writeHeader(out, "kvstash_mirror_divergences_total", "counter", "Mirrored writes the secondary answered with another status than the primary")
+		fmt.Fprintf(out, "kvstash_mirror_divergences_total %d\n", m.Diverged)
+	}
+
+	if err := out.Flush(); err != nil {
+		logging.From(r.Context()).Warnf("prometheusHandler: failed to write response: %v", err)
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(out *bufio.Writer, name string, typ string, help string) {
+	fmt.Fprintf(out, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+// writeGauge writes a gauge without labels
+func writeGauge(out *bufio.Writer, name string, help string, value float64) {
+	writeHeader(out, name, "gauge", help)
+	fmt.Fprintf(out, "%v %v\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// writeHistogram writes the cumulative buckets, sum, and count of a histogram with the given labels, "" for none
+func writeHistogram(out *bufio.Writer, name string, labels string, h store.HistogramSnapshot) {
+	prefix, selector := "", ""
+	if labels != "" {
+		prefix, selector = labels+",", "{"+labels+"}"
+	}
+	var cumulative uint64
+	for i, bound := range store.LatencyBuckets {
+		cumulative += h.Counts[i]
+		fmt.Fprintf(out, "%v_bucket{%vle=\"%v\"} %d\n", name, prefix, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(out, "%v_bucket{%vle=\"+Inf\"} %d\n", name, prefix, h.Count)
+	fmt.Fprintf(out, "%v_sum%v %v\n", name, selector, strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(out, "%v_count%v %d\n", name, selector, h.Count)
+}
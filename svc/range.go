@@ -0,0 +1,112 @@
+package svc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+)
+
+// rangeKey encodes key for a range response with the encoding of the request, or if it has none, base64 if the key
+// is not valid UTF-8
+func rangeKey(key, encoding string) (string, string) {
+	if encoding == "" {
+		return jsonKey(key)
+	}
+	return models.EncodeKey(key, encoding), encoding
+}
+
+// rangeHandler returns the keys k with start <= k < end in ascending order, with their values, see store.Range
+// Supports GET and POST with a models.KVStashRangeRequest body; a range longer than the limit is returned a page at a
+// time, each response giving the start of the next page
+func rangeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashRangeRequest
+	trace := startSlowTrace(r, "range")
+
+	sendResponse := func(statusCode int, resp models.KVStashRangeResponse) {
+		trace.markStored()
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("rangeHandler: failed to encode response: %v", err)
+		}
+		trace.finish(reqData.Start, len(resp.Data), statusCode)
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashRangeResponse{})
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("rangeHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, models.KVStashRangeResponse{Message: "invalid json body"})
+		return
+	}
+	trace.markDecoded()
+
+	limit := reqData.Limit
+	if limit == 0 {
+		limit = constants.RangeLimit
+	}
+	if limit < 0 || limit > constants.MaxBatchKeys {
+		sendResponse(http.StatusBadRequest, models.KVStashRangeResponse{
+			Message: fmt.Sprintf("limit must be between 1 and %d", constants.MaxBatchKeys),
+		})
+		return
+	}
+
+	start, err := models.DecodeKey(reqData.Start, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, models.KVStashRangeResponse{Message: err.Error()})
+		return
+	}
+	end, err := models.DecodeKey(reqData.End, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, models.KVStashRangeResponse{Message: err.Error()})
+		return
+	}
+	if err := checkValueEncoding(reqData.ValueEncoding); err != nil {
+		sendResponse(http.StatusBadRequest, models.KVStashRangeResponse{Message: err.Error()})
+		return
+	}
+	if end != "" && end <= start {
+		sendResponse(http.StatusBadRequest, models.KVStashRangeResponse{Message: "end must be after start"})
+		return
+	}
+
+	it := kvStore.Range(start, end)
+	defer it.Close()
+
+	resp := models.KVStashRangeResponse{Success: true, Data: []models.KVStashRequest{}}
+	for seen := 0; it.Next(); seen++ {
+		// One key past the limit is read to tell whether the range goes on
+		if seen == limit {
+			resp.Next, resp.NextEncoding = rangeKey(it.Key(), reqData.KeyEncoding)
+			break
+		}
+		// Lists, sets, and hashes have no single value to return
+		if t := it.Entry().Type; t != models.TypeString && t != models.TypeJSON {
+			continue
+		}
+		key, encoding := rangeKey(it.Key(), reqData.KeyEncoding)
+		value, valueEncoding := models.EncodeValue(it.Value(), reqData.ValueEncoding)
+		resp.Data = append(resp.Data, models.KVStashRequest{
+			Key:           key,
+			Value:         value,
+			KeyEncoding:   encoding,
+			ValueEncoding: valueEncoding,
+			Checksum:      models.ValueChecksum(it.Value()),
+		})
+	}
+	if err := it.Err(); err != nil {
+		logging.From(r.Context()).Errorf("rangeHandler: failed to read the range: %v", err)
+		sendResponse(http.StatusInternalServerError, models.KVStashRangeResponse{Message: "read failed"})
+		return
+	}
+
+	sendResponse(http.StatusOK, resp)
+}
@@ -0,0 +1,138 @@
+package svc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// requestIDHeader carries the ID of a request; a client-supplied value is kept, otherwise one is generated
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// requestID returns the ID assigned to r by recoverPanics, empty if it did not pass through it
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// panics counts the handler panics recovered since the server started
+var panics atomic.Uint64
+
+// handlerPanic carries a panic recovered in another goroutine, with the stack where it happened
+type handlerPanic struct {
+	// value is the recovered panic value
+	value any
+
+	// stack is the stack trace of the panicking goroutine
+	stack []byte
+}
+
+// recoveryWriter tracks whether a response has been started, so a panic is only answered with a 500
+// if nothing was sent yet
+type recoveryWriter struct {
+	http.ResponseWriter
+
+	// wroteHeader indicates that the status line was sent
+	wroteHeader bool
+}
+
+// WriteHeader records that the response was started and forwards the status code
+func (w *recoveryWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records that the response was started and forwards the body
+func (w *recoveryWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer so streaming handlers keep working
+func (w *recoveryWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recoverPanics assigns every request an ID and converts handler panics into 500 responses
+// The request ID, method, and path are attached to the request's context as log fields, see logging.From
+// The panic value and stack are logged with the request ID, which is also returned to the client in
+// the X-Request-ID header and the error message so the two can be matched
+// http.ErrAbortHandler is re-raised, since it is the documented way for a handler to abort a response
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		r = r.WithContext(logging.WithFields(ctx, "request_id", requestID, "method", r.Method, "path", r.URL.Path))
+
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			stack := debug.Stack()
+			if hp, ok := recovered.(handlerPanic); ok {
+				recovered, stack = hp.value, hp.stack
+			}
+
+			panics.Add(1)
+			logging.From(r.Context()).Errorf("recoverPanics: request %v %v %v panicked: %v\n%s", requestID, r.Method, r.URL.Path, recovered, stack)
+
+			// Headers already went out; the client sees a truncated response
+			if rw.wroteHeader {
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(models.KVStashResponse{
+				Success: false,
+				Message: "internal error (request id " + requestID + ")",
+			})
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// logFailure logs why a request failed with the fields of the request: at the error level if statusCode is 500, the
+// only status whose response does not say what went wrong, and at the debug level otherwise, e.g. for a missing key,
+// a bad request, or a write refused in maintenance mode, which the client is told about and the store reports itself
+func logFailure(r *http.Request, statusCode int, format string, args ...any) {
+	if statusCode != http.StatusInternalServerError {
+		logging.From(r.Context()).Debugf(format, args...)
+		return
+	}
+	logging.From(r.Context()).Errorf(format, args...)
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,70 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"time"
+)
+
+// relocateHandler moves the database to another directory while the server keeps serving, see store.Relocate
+// Only POST is supported, with a JSON body such as {"path": "/mnt/fast/kvstash"}; responds once the database was moved
+func relocateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, resp models.KVStashRelocateResponse) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("relocateHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashRelocateResponse{})
+		return
+	}
+
+	var req models.KVStashRelocateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		sendResponse(http.StatusBadRequest, models.KVStashRelocateResponse{Message: "invalid json body, expected a path"})
+		return
+	}
+
+	report, err := kvStore.Relocate(req.Path)
+	if err != nil {
+		statusCode := relocateErrorStatus(err)
+		logFailure(r, statusCode, "relocateHandler: %v", err)
+		recordAudit(r, audit.OpRelocate, "", 0, statusCode)
+		sendResponse(statusCode, models.KVStashRelocateResponse{Message: err.Error()})
+		return
+	}
+
+	recordAudit(r, audit.OpRelocate, "", 0, http.StatusOK)
+	sendResponse(http.StatusOK, models.KVStashRelocateResponse{
+		Success:    true,
+		From:       report.From,
+		To:         report.To,
+		Segments:   report.Segments,
+		Bytes:      report.Bytes,
+		Rounds:     report.Rounds,
+		PauseMs:    float64(report.Pause) / float64(time.Millisecond),
+		DurationMs: float64(report.Duration) / float64(time.Millisecond),
+	})
+}
+
+// relocateErrorStatus maps an error of Relocate to a status code
+func relocateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrBadRelocation):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrRelocating), errors.Is(err, store.ErrSnapshotsOpen):
+		return http.StatusConflict
+	case errors.Is(err, store.ErrMaintenance), errors.Is(err, store.ErrDegraded):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,85 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+)
+
+// renameHandler moves the value of a key to another key, see store.Rename
+// Accepts POST with a JSON body naming both keys, {"key": "user:1", "new_key": "user:2"}, and returns the version
+// of the value under its new key
+// Responds with 404 if the key does not exist and 409 if the new key exists and "replace" is not set
+// The rename is recorded in the audit trail as a delete of the key and a set of the new key
+func renameHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashRenameRequest
+	var version *models.KVStashVersion
+	trace := startSlowTrace(r, "rename")
+
+	sendResponse := func(statusCode int, success bool, message string) {
+		trace.markStored()
+		recordAudit(r, "rename.delete", reqData.Key, 0, statusCode)
+		recordAudit(r, "rename.set", reqData.NewKey, 0, statusCode)
+
+		w.WriteHeader(statusCode)
+		respData := models.KVStashResponse{
+			Success: success,
+			Message: message,
+			Version: version,
+		}
+		if err := json.NewEncoder(w).Encode(respData); err != nil {
+			logging.From(r.Context()).Warnf("renameHandler: failed to encode response: %v", err)
+		}
+		trace.finish(reqData.Key, 0, statusCode)
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false, "")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("renameHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, false, "invalid json body")
+		return
+	}
+	key, err := models.DecodeKey(reqData.Key, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error())
+		return
+	}
+	newKey, err := models.DecodeKey(reqData.NewKey, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error())
+		return
+	}
+	reqData.Key, reqData.NewKey = key, newKey
+	trace.markDecoded()
+
+	if version, err = kvStore.Rename(reqData.Key, reqData.NewKey, reqData.Replace); err != nil {
+		statusCode, message := renameErrorStatus(err)
+		logFailure(r, statusCode, "renameHandler: failed to rename key: %v", err)
+		sendResponse(statusCode, false, message)
+		return
+	}
+
+	sendResponse(http.StatusOK, true, "")
+}
+
+// renameErrorStatus maps an error of Rename to a status code and a message for the client
+func renameErrorStatus(err error) (int, string) {
+	if errors.Is(err, store.ErrKeyExists) {
+		return http.StatusConflict, store.ErrKeyExists.Error()
+	}
+
+	statusCode, message := collectionErrorStatus(err)
+	if statusCode == http.StatusInternalServerError {
+		message = "rename failed"
+	}
+	return statusCode, message
+}
@@ -0,0 +1,89 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"time"
+)
+
+// schedulerStats returns the state of the store's background jobs
+func schedulerStats() models.KVStashSchedulerStats {
+	s := kvStore.SchedulerStats()
+	stats := models.KVStashSchedulerStats{Paused: s.Paused, Jobs: make([]models.KVStashJobStats, 0, len(s.Jobs))}
+	if !s.PausedSince.IsZero() {
+		stats.PausedSince = s.PausedSince.Format(time.RFC3339)
+	}
+	for _, job := range s.Jobs {
+		j := models.KVStashJobStats{Name: job.Name, Runs: job.Runs}
+		if !job.LastRun.IsZero() {
+			j.LastRun = job.LastRun.Format(time.RFC3339)
+		}
+		if !job.NextRun.IsZero() {
+			j.NextRun = job.NextRun.Format(time.RFC3339)
+		}
+		stats.Jobs = append(stats.Jobs, j)
+	}
+	return stats
+}
+
+// schedulerHandler reports the store's background jobs and whether they are paused
+// Only GET is supported
+func schedulerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(schedulerStats()); err != nil {
+		logging.From(r.Context()).Warnf("schedulerHandler: failed to encode response: %v", err)
+	}
+}
+
+// schedulerPauseHandler holds the store's background jobs, such as automatic compaction, until they are resumed
+// Only POST is supported
+func schedulerPauseHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, success bool) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(models.KVStashResponse{Success: success}); err != nil {
+			logging.From(r.Context()).Warnf("schedulerPauseHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false)
+		return
+	}
+
+	kvStore.PauseScheduler()
+	recordAudit(r, audit.OpSchedulerPause, "", 0, http.StatusOK)
+	sendResponse(http.StatusOK, true)
+}
+
+// schedulerResumeHandler lets the background jobs held through schedulerPauseHandler run again
+// Only POST is supported
+func schedulerResumeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, success bool) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(models.KVStashResponse{Success: success}); err != nil {
+			logging.From(r.Context()).Warnf("schedulerResumeHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false)
+		return
+	}
+
+	kvStore.ResumeScheduler()
+	recordAudit(r, audit.OpSchedulerResume, "", 0, http.StatusOK)
+	sendResponse(http.StatusOK, true)
+}
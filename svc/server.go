@@ -0,0 +1,545 @@
+// Package svc implements the HTTP server and API handlers for KVStash
+package svc
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// kvStore is the global store instance used by the HTTP handlers
+var kvStore *store.Store
+
+// jsonKey returns key and its encoding as sent in a response that was not asked for a specific encoding:
+// plain if it is valid UTF-8, base64 otherwise
+func jsonKey(key string) (string, string) {
+	encoding := models.KeyEncodingFor(key)
+	return models.EncodeKey(key, encoding), encoding
+}
+
+// checkValueEncoding returns models.ErrBadValueEncoding if encoding is not a value encoding, see
+// models.KVStashRequest.ValueEncoding
+func checkValueEncoding(encoding string) error {
+	_, err := models.DecodeValue("", encoding)
+	return err
+}
+
+// readVerification returns the verification level of a read that asked for verify, applying the consistency query
+// parameter of r: consistency=strong reads the record from disk and checks it in full, bypassing inline values and
+// the read cache, like verify "full"
+// Returns an error for an unknown consistency or one that contradicts verify
+func readVerification(r *http.Request, verify string) (string, error) {
+	switch consistency := r.URL.Query().Get("consistency"); consistency {
+	case "":
+		return verify, nil
+	case "strong":
+		if verify != "" && store.Verification(verify) != store.VerifyFull {
+			return "", fmt.Errorf("consistency=strong cannot be combined with verify %q", verify)
+		}
+		return string(store.VerifyFull), nil
+	default:
+		return "", fmt.Errorf("unknown consistency %q (expected \"strong\")", consistency)
+	}
+}
+
+// apiHandler processes HTTP requests for key-value operations
+// Supports POST for setting values, GET for retrieving values, and DELETE for removing keys
+// Returns JSON responses with success status and data
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashRequest
+	var version *models.KVStashVersion
+	var warnings []models.KVStashWarning
+	trace := startSlowTrace(r, methodOps[r.Method])
+
+	// Helper function to send JSON response
+	// Writes and deletes, including rejected ones, are recorded in the audit trail
+	sendResponse := func(statusCode int, success bool, message string, data *models.KVStashRequest) {
+		trace.markStored()
+		if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+			recordAudit(r, methodOps[r.Method], reqData.Key, len(reqData.Value), statusCode)
+		}
+
+		w.WriteHeader(statusCode)
+		respData := models.KVStashResponse{
+			Success:  success,
+			Message:  message,
+			Data:     data,
+			Version:  version,
+			Warnings: warnings,
+		}
+		if err := json.NewEncoder(w).Encode(respData); err != nil {
+			logging.From(r.Context()).Warnf("apiHandler: failed to encode response: %v", err)
+		}
+		trace.finish(reqData.Key, 0, statusCode)
+	}
+
+	// Validate HTTP method
+	if !slices.Contains([]string{http.MethodPost, http.MethodGet, http.MethodDelete}, r.Method) {
+		sendResponse(http.StatusMethodNotAllowed, false, "", nil)
+		return
+	}
+
+	// Decode request body
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("apiHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, false, "invalid json body", nil)
+		return
+	}
+	key, err := models.DecodeKey(reqData.Key, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error(), nil)
+		return
+	}
+	reqData.Key = key
+	if reqData.Value, err = models.DecodeValue(reqData.Value, reqData.ValueEncoding); err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error(), nil)
+		return
+	}
+	if reqData.ExpectedValue != nil {
+		expected, err := models.DecodeValue(*reqData.ExpectedValue, reqData.ValueEncoding)
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, err.Error(), nil)
+			return
+		}
+		reqData.ExpectedValue = &expected
+	}
+	reqData.Phases = &trace.phases
+	trace.markDecoded()
+
+	switch r.Method {
+	case http.MethodPost:
+		// Validate value is non-empty
+		if len(reqData.Value) == 0 {
+			sendResponse(http.StatusBadRequest, false, "value should be non-empty", nil)
+			return
+		}
+
+		// Attempt to set key-value pair, or with an expectation to swap it if the current value matches
+		if reqData.Conditional() {
+			version, err = kvStore.CompareAndSwap(&reqData)
+		} else {
+			version, err = kvStore.Set(&reqData)
+		}
+		if err != nil {
+			statusCode, message := setErrorStatus(err)
+			logFailure(r, statusCode, "apiHandler: failed to set key: %v", err)
+			sendResponse(statusCode, false, message, nil)
+			return
+		}
+
+		warnings = softLimitWarnings(w, []string{reqData.Key}, []string{reqData.Value})
+		sendResponse(http.StatusCreated, true, "", nil)
+
+	case http.MethodGet:
+		if reqData.Verify, err = readVerification(r, reqData.Verify); err != nil {
+			sendResponse(http.StatusBadRequest, false, err.Error(), nil)
+			return
+		}
+
+		// Attempt to get value
+		value, v, err := kvStore.GetVersion(&reqData)
+		if err != nil {
+			statusCode, message := getErrorStatus(err)
+			logFailure(r, statusCode, "apiHandler: failed to get key: %v", err)
+			sendResponse(statusCode, false, message, nil)
+			return
+		}
+
+		version = v
+		encoded, valueEncoding := models.EncodeValue(value, reqData.ValueEncoding)
+		sendResponse(http.StatusOK, true, "", &models.KVStashRequest{
+			Key:           models.EncodeKey(reqData.Key, reqData.KeyEncoding),
+			Value:         encoded,
+			KeyEncoding:   reqData.KeyEncoding,
+			ValueEncoding: valueEncoding,
+			Checksum:      models.ValueChecksum(value),
+		})
+
+	case http.MethodDelete:
+		// Attempt to delete key, recording who deleted it
+		reqData.Subject = requestSubject(r)
+		err := kvStore.Delete(&reqData)
+		if err != nil {
+			statusCode, message := deleteErrorStatus(err)
+			logFailure(r, statusCode, "apiHandler: failed to delete key: %v", err)
+			sendResponse(statusCode, false, message, nil)
+			return
+		}
+
+		sendResponse(http.StatusOK, true, "", nil)
+	default:
+		sendResponse(http.StatusInternalServerError, false, "unable to process request", nil)
+	}
+}
+
+// setErrorStatus maps an error of Set and CompareAndSwap to a status code and a message for the client
+func setErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrConditionFailed):
+		return http.StatusConflict, "current value does not match the expected one"
+	case errors.Is(err, store.ErrEmptyKey), errors.Is(err, store.ErrKeyTooLarge), errors.Is(err, store.ErrValueTooLarge),
+		errors.Is(err, store.ErrBadTTL), errors.Is(err, store.ErrBadExpireAt):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, store.ErrMaintenance):
+		return http.StatusServiceUnavailable, maintenanceMessage()
+	case errors.Is(err, store.ErrDegraded):
+		return http.StatusServiceUnavailable, store.ErrDegraded.Error()
+	case errors.Is(err, store.ErrDiskFull):
+		return http.StatusInsufficientStorage, store.ErrDiskFull.Error()
+	case errors.Is(err, store.ErrNamespaceFull):
+		return http.StatusInsufficientStorage, store.ErrNamespaceFull.Error()
+	}
+	return http.StatusInternalServerError, "write failed"
+}
+
+// getErrorStatus maps an error of GetVersion to a status code and a message for the client
+func getErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound):
+		return http.StatusNotFound, "key not found"
+	case errors.Is(err, store.ErrWrongType):
+		return http.StatusConflict, store.ErrWrongType.Error()
+	case errors.Is(err, store.ErrBadVerification):
+		return http.StatusBadRequest, err.Error()
+	}
+	return http.StatusInternalServerError, "read failed"
+}
+
+// deleteErrorStatus maps an error of Delete to a status code and a message for the client
+func deleteErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrEmptyKey), errors.Is(err, store.ErrKeyTooLarge):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, store.ErrKeyNotFound):
+		return http.StatusNotFound, "key not found"
+	case errors.Is(err, store.ErrMaintenance):
+		return http.StatusServiceUnavailable, maintenanceMessage()
+	case errors.Is(err, store.ErrDegraded):
+		return http.StatusServiceUnavailable, store.ErrDegraded.Error()
+	case errors.Is(err, store.ErrDiskFull):
+		return http.StatusInsufficientStorage, store.ErrDiskFull.Error()
+	}
+	return http.StatusInternalServerError, "delete failed"
+}
+
+// mgetHandler processes multi-get requests
+// Accepts GET or POST with a JSON body listing up to MaxBatchKeys keys
+// Responds with the key-value pairs that exist, and lists the missing and deleted keys, and keys holding collections,
+// separately
+func mgetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashMultiGetRequest
+	trace := startSlowTrace(r, "mget")
+
+	// Helper function to send JSON response
+	sendResponse := func(statusCode int, success bool, message string, data []models.KVStashRequest, missing []string) {
+		trace.markStored()
+		w.WriteHeader(statusCode)
+		respData := models.KVStashMultiGetResponse{
+			Success: success,
+			Message: message,
+			Data:    data,
+			Missing: missing,
+		}
+		if err := json.NewEncoder(w).Encode(respData); err != nil {
+			logging.From(r.Context()).Warnf("mgetHandler: failed to encode response: %v", err)
+		}
+
+		firstKey := ""
+		if len(reqData.Keys) > 0 {
+			firstKey = reqData.Keys[0]
+		}
+		trace.finish(firstKey, len(reqData.Keys), statusCode)
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false, "", nil, nil)
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("mgetHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, false, "invalid json body", nil, nil)
+		return
+	}
+	trace.markDecoded()
+
+	if len(reqData.Keys) > constants.MaxBatchKeys {
+		sendResponse(http.StatusBadRequest, false, fmt.Sprintf("too many keys (max %d)", constants.MaxBatchKeys), nil, nil)
+		return
+	}
+	if err := checkValueEncoding(reqData.ValueEncoding); err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error(), nil, nil)
+		return
+	}
+	verify, err := readVerification(r, reqData.Verify)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error(), nil, nil)
+		return
+	}
+
+	keys := make([]string, 0, len(reqData.Keys))
+	for _, encoded := range reqData.Keys {
+		key, err := models.DecodeKey(encoded, reqData.KeyEncoding)
+		if err != nil {
+			sendResponse(http.StatusBadRequest, false, err.Error(), nil, nil)
+			return
+		}
+		keys = append(keys, key)
+	}
+
+	values, err := kvStore.GetMany(keys, store.Verification(verify))
+	if errors.Is(err, store.ErrBadVerification) {
+		sendResponse(http.StatusBadRequest, false, err.Error(), nil, nil)
+		return
+	}
+	if err != nil {
+		logging.From(r.Context()).Errorf("mgetHandler: failed to get keys: %v", err)
+		sendResponse(http.StatusInternalServerError, false, "read failed", nil, nil)
+		return
+	}
+
+	data := make([]models.KVStashRequest, 0, len(values))
+	missing := []string{}
+	for i, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			missing = append(missing, reqData.Keys[i])
+			continue
+		}
+		encoded, valueEncoding := models.EncodeValue(value, reqData.ValueEncoding)
+		data = append(data, models.KVStashRequest{
+			Key:           reqData.Keys[i],
+			Value:         encoded,
+			KeyEncoding:   reqData.KeyEncoding,
+			ValueEncoding: valueEncoding,
+			Checksum:      models.ValueChecksum(value),
+		})
+	}
+
+	sendResponse(http.StatusOK, true, "", data, missing)
+}
+
+// watchHandler streams changefeed events as newline-delimited JSON
+// Query parameters:
+//   - prefix: only stream events of keys starting with prefix (optional)
+//   - epoch, since: resume after the event with sequence number since of the given epoch (optional)
+//
+// The epoch and sequence number the stream starts after are returned in the X-KVStash-Epoch and
+// X-KVStash-Seq headers, so clients can resume even if no event was received before a disconnect
+// The X-KVStash-Key-Normalization header names the key normalization modes, since events carry normalized keys
+// Responds with 410 Gone if the requested position can no longer be resumed
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	sendError := func(statusCode int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(models.KVStashResponse{Message: message}); err != nil {
+			logging.From(r.Context()).Warnf("watchHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodGet {
+		sendError(http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	query := r.URL.Query()
+	var from store.WatchPosition
+	if epoch := query.Get("epoch"); epoch != "" {
+		since, err := strconv.ParseUint(query.Get("since"), 10, 64)
+		if err != nil {
+			sendError(http.StatusBadRequest, "invalid since")
+			return
+		}
+		from = store.WatchPosition{Epoch: epoch, Seq: since}
+	}
+
+	watcher, err := kvStore.Watch(query.Get("prefix"), from)
+	if err != nil {
+		if errors.Is(err, store.ErrWatchExpired) {
+			sendError(http.StatusGone, err.Error())
+		} else {
+			logging.From(r.Context()).Errorf("watchHandler: failed to watch: %v", err)
+			sendError(http.StatusInternalServerError, "watch failed")
+		}
+		return
+	}
+	defer watcher.Close()
+
+	start := watcher.Start()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-KVStash-Epoch", start.Epoch)
+	w.Header().Set("X-KVStash-Seq", strconv.FormatUint(start.Seq, 10))
+	w.Header().Set("X-KVStash-Key-Normalization", kvStore.KeyNormalization().String())
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		case event, ok := <-watcher.Events():
+			if !ok {
+				// The watcher fell behind and was dropped; the client resumes from its last event
+				return
+			}
+			event.Key, event.KeyEncoding = jsonKey(event.Key)
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// resumeWritesHandler re-enables writes after repeated storage errors made the store read-only
+// Only POST is supported; responds with 500 if the active log cannot be reopened
+func resumeWritesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sendResponse := func(statusCode int, success bool, message string) {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(models.KVStashResponse{Success: success, Message: message}); err != nil {
+			logging.From(r.Context()).Warnf("resumeWritesHandler: failed to encode response: %v", err)
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false, "")
+		return
+	}
+
+	if err := kvStore.ResumeWrites(); err != nil {
+		logging.From(r.Context()).Errorf("resumeWritesHandler: %v", err)
+		recordAudit(r, audit.OpResumeWrites, "", 0, http.StatusInternalServerError)
+		sendResponse(http.StatusInternalServerError, false, err.Error())
+		return
+	}
+
+	recordAudit(r, audit.OpResumeWrites, "", 0, http.StatusOK)
+	sendResponse(http.StatusOK, true, "")
+}
+
+// ServerConfig holds the settings of the HTTP server, read from flags and environment variables at startup
+type ServerConfig struct {
+	// Port is the port the server listens on (default: constants.Port)
+	Port int
+
+	// ShutdownTimeout is how long a stopping server waits for the requests in flight
+	// (default: constants.ShutdownTimeout seconds)
+	ShutdownTimeout time.Duration
+
+	// DebugAddr is the address of the debug listener serving pprof and the store internals under /debug/, ""
+	// to not start it; the profiles are never served on Port
+	DebugAddr string
+}
+
+// shuttingDown is closed when the server starts shutting down, ending the watch and notification streams, which
+// would otherwise hold the shutdown until its timeout
+var shuttingDown = make(chan struct{})
+
+// StartHTTPServer initializes and starts the HTTP server on the port cfg says
+// It registers the API handler and blocks until ctx is done, then stops accepting connections and waits up to
+// cfg.ShutdownTimeout for the requests in flight before it returns; the caller closes the store afterwards
+// Accepts a Store instance for handling key-value operations
+// With cfg.DebugAddr set, the debug listener is started alongside and closed when ctx is done
+// Returns an error if the server or the debug listener cannot listen, or if requests were still running when the timeout expired
+func StartHTTPServer(ctx context.Context, s *store.Store, cfg ServerConfig) error {
+	kvStore = s
+	http.HandleFunc("/kvstash", instrument(func(r *http.Request) string { return methodOps[r.Method] }, withMirror(isWriteMethod(http.MethodPost, http.MethodDelete), withTimeout(withLimit(apiHandler)))))
+	http.HandleFunc("/kvstash/mget", instrument(func(r *http.Request) string { return "mget" }, withTimeout(withLimit(mgetHandler))))
+	http.HandleFunc("/kvstash/range", instrument(func(r *http.Request) string { return "range" }, withTimeout(withLimit(rangeHandler))))
+	http.HandleFunc("/kvstash/collections", instrument(func(r *http.Request) string { return "collection" }, withMirror(isCollectionWrite, withTimeout(withLimit(collectionsHandler)))))
+	http.HandleFunc("/kvstash/json", instrument(func(r *http.Request) string { return "json" }, withMirror(isWriteMethod(http.MethodPatch, http.MethodDelete), withTimeout(withLimit(jsonHandler)))))
+	http.HandleFunc("/kvstash/batch", instrument(func(r *http.Request) string { return "batch" }, withMirror(isWriteMethod(http.MethodPost), withTimeout(withLimit(batchHandler)))))
+	http.HandleFunc("/kvstash/expire", instrument(func(r *http.Request) string { return "expire" }, withMirror(isWriteMethod(http.MethodPost), withTimeout(withLimit(expireHandler)))))
+	http.HandleFunc("/kvstash/rename", instrument(func(r *http.Request) string { return "rename" }, withMirror(isWriteMethod(http.MethodPost), withTimeout(withLimit(renameHandler)))))
+	http.HandleFunc("/kvstash/undelete", instrument(func(r *http.Request) string { return "undelete" }, withMirror(isWriteMethod(http.MethodPost), withTimeout(withLimit(undeleteHandler)))))
+	http.HandleFunc("/kvstash/session", instrument(func(r *http.Request) string { return "session" }, withTimeout(withLimit(sessionHandler))))
+	http.HandleFunc("/kvstash/watch", watchHandler)
+	http.HandleFunc("/kvstash/notify", notifyHandler)
+	http.HandleFunc("/kvstash/stats", statsHandler)
+	http.HandleFunc("/kvstash/health", healthHandler)
+	http.HandleFunc("/kvstash/admin/config", configHandler)
+	http.HandleFunc("/kvstash/admin/resume-writes", resumeWritesHandler)
+	http.HandleFunc("/kvstash/admin/slowlog", slowLogHandler)
+	http.HandleFunc("/kvstash/admin/compactions", compactionsHandler)
+	http.HandleFunc("/kvstash/admin/compaction/pause", compactionPauseHandler)
+	http.HandleFunc("/kvstash/admin/compaction/resume", compactionResumeHandler)
+	http.HandleFunc("/kvstash/admin/scheduler", schedulerHandler)
+	http.HandleFunc("/kvstash/admin/scheduler/pause", schedulerPauseHandler)
+	http.HandleFunc("/kvstash/admin/scheduler/resume", schedulerResumeHandler)
+	http.HandleFunc("/kvstash/admin/maintenance", maintenanceHandler)
+	http.HandleFunc("/kvstash/admin/keyspace", keyspaceHandler)
+	http.HandleFunc("/kvstash/admin/hotkeys", hotKeysHandler)
+	http.HandleFunc("/kvstash/admin/deletions", deletionsHandler)
+	http.HandleFunc("/kvstash/admin/index-check", indexCheckHandler)
+	http.HandleFunc("/kvstash/admin/reindex", reindexHandler)
+	http.HandleFunc("/kvstash/admin/relocate", relocateHandler)
+	http.HandleFunc("/kvstash/admin/test-alert", testAlertHandler)
+	http.HandleFunc("/metrics", prometheusHandler)
+	ui := uiHandler()
+	http.Handle("/ui", ui)
+	http.Handle("/ui/", ui)
+	publishExpvar() // importing expvar registers /debug/vars
+
+	port := fmt.Sprintf(":%d", cmp.Or(cfg.Port, constants.Port))
+	server := &http.Server{Addr: port, Handler: recoverPanics(authenticate(hidePprof(http.DefaultServeMux)))}
+	server.RegisterOnShutdown(func() { close(shuttingDown) })
+
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		return fmt.Errorf("StartHTTPServer: %w", err)
+	}
+	if cfg.DebugAddr != "" {
+		if err := startDebugServer(ctx, cfg.DebugAddr); err != nil {
+			listener.Close()
+			return fmt.Errorf("StartHTTPServer: %w", err)
+		}
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	logging.Infof("StartHTTPServer: listening on http://localhost%v, admin UI at http://localhost%v/ui/", port, port)
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("StartHTTPServer: %w", err)
+	case <-ctx.Done():
+	}
+
+	timeout := cmp.Or(cfg.ShutdownTimeout, constants.ShutdownTimeout*time.Second)
+	logging.Infof("StartHTTPServer: shutting down, waiting up to %v for the requests in flight", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("StartHTTPServer: requests still running after %v: %w", timeout, err)
+	}
+	logging.Infof("StartHTTPServer: every request finished")
+	return nil
+}
@@ -0,0 +1,126 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/audit"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+	"time"
+)
+
+// sessionMethodOps maps the HTTP methods of /kvstash/session to the operation recorded in the audit trail
+var sessionMethodOps = map[string]string{
+	http.MethodPost:   "session.create",
+	http.MethodPatch:  "session.refresh",
+	http.MethodDelete: "session.destroy",
+}
+
+// sessionHandler serves the session store, see store.CreateSession
+// The request is a JSON body, see models.KVStashSessionRequest:
+//   - POST creates a session with data and ttl and returns it, including its new ID
+//   - GET validates the session id and returns it
+//   - PATCH refreshes the session id so it expires ttl from now, replacing its data if data is set
+//   - DELETE destroys the session id
+//
+// Session IDs are secrets, so the audit trail and the slow log record a fingerprint of them instead
+// Responds with 404 if the session does not exist or expired and 409 if it was written concurrently too often
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashSessionRequest
+	trace := startSlowTrace(r, "session")
+
+	sendResponse := func(statusCode int, resp models.KVStashSessionResponse) {
+		trace.markStored()
+		id := reqData.ID
+		if resp.Session != nil {
+			id = resp.Session.ID
+		}
+		if op := sessionMethodOps[r.Method]; op != "" {
+			size := 0
+			if reqData.Data != nil {
+				size = len(*reqData.Data)
+			}
+			recordAudit(r, op, audit.Fingerprint(id), size, statusCode)
+		}
+
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("sessionHandler: failed to encode response: %v", err)
+		}
+		trace.finish(audit.Fingerprint(id), 0, statusCode)
+	}
+
+	if _, ok := sessionMethodOps[r.Method]; !ok && r.Method != http.MethodGet {
+		sendResponse(http.StatusMethodNotAllowed, models.KVStashSessionResponse{})
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("sessionHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, models.KVStashSessionResponse{Message: "invalid json body"})
+		return
+	}
+	if reqData.TTL < 0 {
+		sendResponse(http.StatusBadRequest, models.KVStashSessionResponse{Message: store.ErrBadSessionTTL.Error()})
+		return
+	}
+	trace.markDecoded()
+
+	ttl := time.Duration(reqData.TTL) * time.Second
+	var session *store.Session
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		data := ""
+		if reqData.Data != nil {
+			data = *reqData.Data
+		}
+		session, err = kvStore.CreateSession(data, ttl)
+	case http.MethodGet:
+		session, err = kvStore.ValidateSession(reqData.ID)
+	case http.MethodPatch:
+		session, err = kvStore.RefreshSession(reqData.ID, ttl, reqData.Data)
+	case http.MethodDelete:
+		err = kvStore.DestroySession(reqData.ID)
+	}
+	if err != nil {
+		statusCode, message := sessionErrorStatus(err)
+		logFailure(r, statusCode, "sessionHandler: %v failed: %v", r.Method, err)
+		sendResponse(statusCode, models.KVStashSessionResponse{Message: message})
+		return
+	}
+
+	statusCode := http.StatusOK
+	if r.Method == http.MethodPost {
+		statusCode = http.StatusCreated
+	}
+	resp := models.KVStashSessionResponse{Success: true}
+	if session != nil {
+		resp.Session = &models.KVStashSession{
+			ID:        session.ID,
+			Data:      session.Data,
+			CreatedAt: session.Created,
+			ExpiresAt: session.ExpiresAt,
+			Revision:  session.Revision,
+		}
+	}
+	sendResponse(statusCode, resp)
+}
+
+// sessionErrorStatus maps an error of a session operation to a status code and message
+func sessionErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrSessionNotFound):
+		return http.StatusNotFound, store.ErrSessionNotFound.Error()
+	case errors.Is(err, store.ErrBadSessionTTL):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, store.ErrSessionBusy):
+		return http.StatusConflict, store.ErrSessionBusy.Error()
+	}
+
+	return collectionErrorStatus(err)
+}
@@ -0,0 +1,193 @@
+package svc
+
+import (
+	"encoding/json"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/redact"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slowLogThreshold is the latency in nanoseconds above which requests are recorded, 0 records every request
+var slowLogThreshold atomic.Int64
+
+func init() {
+	slowLogThreshold.Store(int64(constants.SlowLogThreshold * time.Millisecond))
+}
+
+// SetSlowLogThreshold changes the latency above which requests enter the slow query log
+func SetSlowLogThreshold(d time.Duration) {
+	slowLogThreshold.Store(int64(d))
+}
+
+// SlowLogThreshold returns the latency above which requests enter the slow query log
+func SlowLogThreshold() time.Duration {
+	return time.Duration(slowLogThreshold.Load())
+}
+
+// slowLog keeps the most recent slow requests, like Redis SLOWLOG
+type slowLog struct {
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// entries is a ring of the last constants.SlowLogSize slow requests
+	entries [constants.SlowLogSize]models.KVStashSlowLogEntry
+
+	// lastID is the ID of the most recently recorded request; lastID % SlowLogSize is the slot after it
+	lastID uint64
+
+	// count is the number of valid entries in the ring
+	count int
+}
+
+// slowRequests is the server's slow query log
+var slowRequests = &slowLog{}
+
+// add records a slow request, assigning its ID
+func (l *slowLog) add(e models.KVStashSlowLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.ID = l.lastID + 1
+	l.entries[l.lastID%constants.SlowLogSize] = e
+	l.lastID++
+	l.count = min(l.count+1, constants.SlowLogSize)
+}
+
+// list returns up to n recorded requests, most recent first
+func (l *slowLog) list(n int) []models.KVStashSlowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := min(l.count, n)
+	entries := make([]models.KVStashSlowLogEntry, 0, count)
+	for i := range count {
+		entries = append(entries, l.entries[(l.lastID-1-uint64(i))%constants.SlowLogSize])
+	}
+	return entries
+}
+
+// reset forgets all recorded requests; IDs keep increasing
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count = 0
+}
+
+// slowTrace times the phases of one request for the slow query log
+type slowTrace struct {
+	// r is the traced request
+	r *http.Request
+
+	// op is the operation performed by the request
+	op string
+
+	// start is when the handler started
+	start time.Time
+
+	// decoded and stored are when the request body was decoded and when the store returned
+	decoded time.Time
+	stored  time.Time
+
+	// phases receives the store phases of the request, for handlers that pass it to the store
+	phases models.KVStashPhases
+}
+
+// startSlowTrace starts timing a request performing op
+func startSlowTrace(r *http.Request, op string) *slowTrace {
+	return &slowTrace{r: r, op: op, start: time.Now()}
+}
+
+// markDecoded records the end of request decoding
+func (t *slowTrace) markDecoded() {
+	t.decoded = time.Now()
+}
+
+// markStored records the end of the store operation, i.e. the start of the response
+func (t *slowTrace) markStored() {
+	t.stored = time.Now()
+}
+
+// finish records the request in the slow query log if it took longer than the threshold
+// key is the requested key (for mget the first one) and keys the number of keys of an mget request
+// A phase that was never marked, e.g. when decoding failed, is folded into the phase before it
+func (t *slowTrace) finish(key string, keys int, status int) {
+	end := time.Now()
+	total := end.Sub(t.start)
+	if total < SlowLogThreshold() {
+		return
+	}
+
+	stored := t.stored
+	if stored.IsZero() {
+		stored = end
+	}
+	decoded := t.decoded
+	if decoded.IsZero() {
+		decoded = stored
+	}
+
+	if key != "" {
+		key = redact.Key(key)
+	}
+	var phases *models.KVStashPhases
+	if t.phases != (models.KVStashPhases{}) {
+		phases = &t.phases
+	}
+	slowRequests.add(models.KVStashSlowLogEntry{
+		Time:      t.start.UTC().Format(time.RFC3339Nano),
+		RequestID: requestID(t.r),
+		Op:        t.op,
+		Key:       key,
+		Keys:      keys,
+		Status:    status,
+		TotalMs:   durationMs(total),
+		DecodeMs:  durationMs(decoded.Sub(t.start)),
+		StoreMs:   durationMs(stored.Sub(decoded)),
+		EncodeMs:  durationMs(end.Sub(stored)),
+		Phases:    phases,
+	})
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// slowLogHandler lists (GET, with an optional ?n= limit) or clears (DELETE) the slow query log
+func slowLogHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		n := constants.SlowLogSize
+		if limit := r.URL.Query().Get("n"); limit != "" {
+			parsed, err := strconv.Atoi(limit)
+			if err != nil || parsed < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(models.KVStashResponse{Success: false, Message: "invalid n"})
+				return
+			}
+			n = parsed
+		}
+
+		resp := models.KVStashSlowLogResponse{
+			ThresholdMs: durationMs(SlowLogThreshold()),
+			Entries:     slowRequests.list(n),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.From(r.Context()).Warnf("slowLogHandler: failed to encode response: %v", err)
+		}
+	case http.MethodDelete:
+		slowRequests.reset()
+		json.NewEncoder(w).Encode(models.KVStashResponse{Success: true})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
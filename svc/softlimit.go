@@ -0,0 +1,26 @@
+package svc
+
+import (
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"slices"
+)
+
+// warningHeader carries a warning about a write approaching a limit as "<limit>; <message>", once per warning
+const warningHeader = "X-KVStash-Warning"
+
+// softLimitWarnings returns the warnings about the successful writes of values to keys, see store.SoftLimitWarnings,
+// and adds them to the headers of w; warnings repeated by several writes are returned once
+// It must be called before the response status is written
+func softLimitWarnings(w http.ResponseWriter, keys []string, values []string) []models.KVStashWarning {
+	var warnings []models.KVStashWarning
+	for i, key := range keys {
+		for _, warning := range kvStore.SoftLimitWarnings(key, len(values[i])) {
+			if !slices.Contains(warnings, warning) {
+				warnings = append(warnings, warning)
+				w.Header().Add(warningHeader, warning.Limit+"; "+warning.Message)
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,149 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/vi88i/kvstash/constants"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// requestTimeout is the deadline of key-value requests in nanoseconds, 0 disables it
+var requestTimeout atomic.Int64
+
+func init() {
+	requestTimeout.Store(int64(constants.RequestTimeout * time.Second))
+}
+
+// SetRequestTimeout changes the deadline of key-value requests; it applies to requests that start afterwards
+// A zero duration disables the deadline
+func SetRequestTimeout(d time.Duration) {
+	requestTimeout.Store(int64(d))
+}
+
+// RequestTimeout returns the deadline of key-value requests
+func RequestTimeout() time.Duration {
+	return time.Duration(requestTimeout.Load())
+}
+
+// timeoutWriter buffers a handler's response so it can be discarded if the deadline expires first
+type timeoutWriter struct {
+	// header is the handler's copy of the response headers
+	header http.Header
+
+	// mu protects the fields below, which are shared with the serving goroutine
+	mu sync.Mutex
+
+	// body is the buffered response body
+	body bytes.Buffer
+
+	// status is the status code set by the handler, 0 until WriteHeader is called
+	status int
+
+	// timedOut indicates that a 504 was sent and further writes are rejected
+	timedOut bool
+}
+
+// Header returns the buffered response headers
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader buffers the status code
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut || w.status != 0 {
+		return
+	}
+	w.status = status
+}
+
+// Write buffers the response body, failing with http.ErrHandlerTimeout once the deadline expired
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// withTimeout runs next under the request deadline (see SetRequestTimeout) and answers 504 if it expires
+// The deadline is carried by the request context, so operations that honor it give up early; a handler
+// blocked elsewhere, e.g. on a stuck disk, keeps running in the background but no longer holds the connection
+// A panic in next is re-raised in the serving goroutine so recoverPanics still handles it
+func withTimeout(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := RequestTimeout()
+		if timeout <= 0 {
+			next(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					if p != http.ErrAbortHandler {
+						p = handlerPanic{value: p, stack: debug.Stack()}
+					}
+					panicked <- p
+				}
+			}()
+			next(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+
+			dst := w.Header()
+			for k, v := range tw.header {
+				dst[k] = v
+			}
+			if tw.status != 0 {
+				w.WriteHeader(tw.status)
+			}
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+
+			if ctx.Err() != context.DeadlineExceeded {
+				// The client went away; nobody is left to answer
+				return
+			}
+
+			logging.From(r.Context()).Warnf("withTimeout: %v %v exceeded the %v deadline", r.Method, r.URL.Path, timeout)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			json.NewEncoder(w).Encode(models.KVStashResponse{
+				Success: false,
+				Message: "request timed out after " + timeout.String(),
+			})
+		}
+	}
+}
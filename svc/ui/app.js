@@ -0,0 +1,193 @@
+// Admin UI of the KVStash server, built on the JSON API only
+"use strict";
+
+const base = new URL("..", location.href);
+const tokenInput = document.getElementById("token");
+tokenInput.value = sessionStorage.getItem("kvstash-token") || "";
+
+// api calls an endpoint of the server, relative to its root, and returns the decoded JSON response
+async function api(path, method = "GET", body) {
+  const headers = {};
+  const token = sessionStorage.getItem("kvstash-token");
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch(new URL(path, base), {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = await resp.json().catch(() => ({}));
+  if (resp.status === 401) {
+    throw new Error("unauthorized: enter a token above");
+  }
+  return { status: resp.status, data };
+}
+
+// fill replaces the children of the element id with the items made by make
+function fill(id, items, make) {
+  document.getElementById(id).replaceChildren(...items.map(make));
+}
+
+// field returns a dt/dd pair showing value under label
+function field([label, value]) {
+  const div = document.createElement("div");
+  const dt = document.createElement("dt");
+  const dd = document.createElement("dd");
+  dt.textContent = label;
+  dd.textContent = value;
+  div.append(dt, dd);
+  return div;
+}
+
+// row returns a table row holding cells
+function row(cells) {
+  const tr = document.createElement("tr");
+  for (const cell of cells) {
+    const td = document.createElement("td");
+    td.textContent = cell;
+    tr.append(td);
+  }
+  return tr;
+}
+
+// bytes formats a number of bytes for people
+function bytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  for (; Math.abs(n) >= 1024 && i < units.length - 1; i++) {
+    n /= 1024;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+// showError shows err, or hides the error line if err is null
+function showError(err) {
+  const el = document.getElementById("error");
+  el.hidden = err === null;
+  el.textContent = err === null ? "" : String(err.message || err);
+}
+
+// refresh reloads the health, statistics, and compaction history
+async function refresh() {
+  try {
+    const [health, stats, history] = await Promise.all([
+      api("kvstash/health"),
+      api("kvstash/stats"),
+      api("kvstash/admin/compactions"),
+    ]);
+
+    const badge = document.getElementById("health");
+    badge.textContent = health.data.status || "unknown";
+    badge.className = "badge " + (health.data.status || "");
+
+    const s = stats.data.store || {};
+    fill("store", [
+      ["Uptime", Math.round(stats.data.uptime_seconds || 0) + " s"],
+      ["Live keys", s.live_keys],
+      ["Deleted keys", s.deleted_keys],
+      ["Segments", s.segments],
+      ["Active log", s.active_log],
+      ["Writes per segment", s.segment_limit],
+      ["Disk", bytes(s.disk_bytes || 0)],
+      ["Garbage", ((s.garbage_ratio || 0) * 100).toFixed(1) + " %"],
+      ["Free disk", bytes(s.disk_free_bytes || 0) + (s.disk_low ? " (low)" : "")],
+      ["Open snapshots", s.open_snapshots],
+      ["Read-only", stats.data.breaker && stats.data.breaker.degraded ? "yes" : "no"],
+    ], field);
+
+    const io = stats.data.segment_io || {};
+    const segments = Object.keys(io).sort((a, b) => parseInt(a.slice(3)) - parseInt(b.slice(3)));
+    fill("segments", segments, (name) => {
+      const seg = io[name];
+      return row([
+        name + (name === s.active_log ? " (active)" : ""),
+        seg.sync ? seg.sync.count : 0,
+        seg.sync ? seg.sync.p99_ms : "",
+        seg.compaction_read ? seg.compaction_read.count : 0,
+        seg.compaction_write ? seg.compaction_write.count : 0,
+      ]);
+    });
+
+    const c = stats.data.compaction || {};
+    fill("compaction", [
+      ["Running", c.running ? "yes" : "no"],
+      ["Runs", c.runs],
+      ["Failures", c.failures],
+      ["Skipped", c.skipped],
+      ["Paused", c.paused ? "yes" : "no"],
+    ], field);
+    fill("compactions", history.data.runs || [], (run) => row([
+      run.start,
+      run.trigger,
+      run.outcome,
+      run.duration_ms.toFixed(1),
+      run.segments_before + " → " + run.segments_after,
+      bytes(run.bytes_reclaimed),
+      run.error || "",
+    ]));
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+// show writes the outcome of a key operation
+function show(text) {
+  document.getElementById("result").textContent = text;
+}
+
+// onSubmit runs fn with the fields of the form id when it is submitted, showing its errors
+function onSubmit(id, fn) {
+  document.getElementById(id).addEventListener("submit", async (event) => {
+    event.preventDefault();
+    try {
+      await fn(Object.fromEntries(new FormData(event.target)));
+    } catch (err) {
+      show(String(err.message || err));
+    }
+  });
+}
+
+document.getElementById("auth").addEventListener("submit", (event) => {
+  event.preventDefault();
+  sessionStorage.setItem("kvstash-token", tokenInput.value);
+  refresh();
+});
+
+// GET /kvstash takes its key in a request body, which browsers cannot send, so lookups use mget
+onSubmit("lookup", async ({ key }) => {
+  const { status, data } = await api("kvstash/mget", "POST", { keys: [key] });
+  if (status !== 200) {
+    show(status + ": " + data.message);
+  } else if (!data.data || data.data.length === 0) {
+    show("not found (or holds a list, set, or hash)");
+  } else {
+    show(data.data[0].value);
+  }
+});
+
+onSubmit("set", async ({ key, value, ttl }) => {
+  const body = { key, value };
+  if (ttl !== "") {
+    body.ttl = Number(ttl);
+  }
+  const { status, data } = await api("kvstash", "POST", body);
+  show(status + (data.success ? ": stored at revision " + data.version.revision : ": " + data.message));
+  refresh();
+});
+
+onSubmit("delete", async ({ key }) => {
+  if (!confirm("Delete " + key + "?")) {
+    return;
+  }
+  const { status, data } = await api("kvstash", "DELETE", { key });
+  show(status + (data.success ? ": deleted" : ": " + data.message));
+  refresh();
+});
+
+refresh();
+setInterval(refresh, 5000);
@@ -0,0 +1,80 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vi88i/kvstash/logging"
+	"github.com/vi88i/kvstash/models"
+	"github.com/vi88i/kvstash/store"
+	"net/http"
+)
+
+// undeleteHandler restores the most recent version of a deleted key, see store.Undelete
+// Accepts POST with a JSON body naming the key, {"key": "user:1"}, and returns the version of the restored value
+// Responds with 404 if there is nothing to restore and 409 if the key is not deleted
+func undeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var reqData models.KVStashRequest
+	var version *models.KVStashVersion
+	trace := startSlowTrace(r, "undelete")
+
+	sendResponse := func(statusCode int, success bool, message string) {
+		trace.markStored()
+		recordAudit(r, "undelete", reqData.Key, 0, statusCode)
+
+		w.WriteHeader(statusCode)
+		respData := models.KVStashResponse{
+			Success: success,
+			Message: message,
+			Version: version,
+		}
+		if err := json.NewEncoder(w).Encode(respData); err != nil {
+			logging.From(r.Context()).Warnf("undeleteHandler: failed to encode response: %v", err)
+		}
+		trace.finish(reqData.Key, 0, statusCode)
+	}
+
+	if r.Method != http.MethodPost {
+		sendResponse(http.StatusMethodNotAllowed, false, "")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		logging.From(r.Context()).Debugf("undeleteHandler: failed to decode request body: %v", err)
+		sendResponse(http.StatusBadRequest, false, "invalid json body")
+		return
+	}
+	key, err := models.DecodeKey(reqData.Key, reqData.KeyEncoding)
+	if err != nil {
+		sendResponse(http.StatusBadRequest, false, err.Error())
+		return
+	}
+	reqData.Key = key
+	trace.markDecoded()
+
+	if version, err = kvStore.Undelete(reqData.Key); err != nil {
+		statusCode, message := undeleteErrorStatus(err)
+		logFailure(r, statusCode, "undeleteHandler: failed to undelete key: %v", err)
+		sendResponse(statusCode, false, message)
+		return
+	}
+
+	sendResponse(http.StatusOK, true, "")
+}
+
+// undeleteErrorStatus maps an error of Undelete to a status code and a message for the client
+func undeleteErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, store.ErrNoPriorVersion):
+		return http.StatusNotFound, store.ErrNoPriorVersion.Error()
+	case errors.Is(err, store.ErrNotDeleted):
+		return http.StatusConflict, store.ErrNotDeleted.Error()
+	}
+
+	statusCode, message := collectionErrorStatus(err)
+	if statusCode == http.StatusInternalServerError {
+		message = "undelete failed"
+	}
+	return statusCode, message
+}